@@ -0,0 +1,312 @@
+package flexlimit
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Vipul984/flexlimit/algorithm"
+)
+
+// consumedRecord is the kind of record counting what a key consumed in a
+// window while running below its full rate, kept for the key and window
+// index (see recordKey).
+const consumedRecord = "consumed"
+
+// AdaptiveConfig configures how a key's rate follows the health of the
+// resource it protects. See WithAdaptive.
+//
+// Each key runs at a fraction of the limiter's rate, starting at 1. A
+// failed outcome multiplies the fraction by Decrease, and a successful one
+// adds Increase back, up to 1: the additive-increase/multiplicative-
+// decrease (AIMD) scheme TCP uses to find the capacity of a network.
+type AdaptiveConfig struct {
+	// LatencyTarget makes successful outcomes slower than it count as
+	// failures. 0 only counts reported failures
+	LatencyTarget time.Duration
+
+	// Decrease multiplies a key's fraction of the rate on failure, in
+	// (0, 1). Default: 0.5
+	Decrease float64
+
+	// Increase is added to a key's fraction of the rate on success, in
+	// (0, 1]. Default: 0.01, so a key recovers from half its rate in 50
+	// successes
+	Increase float64
+
+	// MinFraction is the lowest fraction of the rate a key is lowered to,
+	// in (0, 1]. Default: 0.1
+	MinFraction float64
+
+	// Cooldown is the minimum time between two decreases of a key, so a
+	// burst of failures from requests already in flight counts once.
+	// Default: 1 second
+	Cooldown time.Duration
+
+	// IdleReset returns a key to its full rate once no outcome has been
+	// reported for it for this long. Default: 1 minute
+	IdleReset time.Duration
+
+	// MaxKeys bounds memory: at most this many keys run below their full
+	// rate; failures of other keys are ignored until some recover.
+	// Default: 10000
+	MaxKeys int
+}
+
+// WithAdaptive lowers a key's effective rate when its requests fail or
+// slow down, and raises it back as they succeed. Outcomes are reported
+// with Limiter.ReportOutcome once the protected work is done.
+//
+// Requests are first decided by the algorithm as usual; an allowed request
+// is then denied, and its tokens refunded, if it takes what the key
+// consumed in the current window above its fraction of the rate, so the
+// fraction lowers the key's sustained rate with every algorithm, not only
+// its bursts. Consumption is counted in the storage, next to the key's
+// state, while the key runs below its full rate. Each instance adapts to
+// the outcomes it observes, so instances sharing storage may run keys at
+// different fractions. AllowMulti decides adaptive requests one by one.
+//
+// Example:
+//
+//	limiter, err := flexlimit.New(1000, time.Second,
+//	    flexlimit.WithAdaptive(flexlimit.AdaptiveConfig{
+//	        LatencyTarget: 200 * time.Millisecond,
+//	    }),
+//	)
+//
+//	if allowed, _ := limiter.Allow(ctx, "payments-api"); allowed {
+//	    start := time.Now()
+//	    err := callPayments(ctx)
+//	    limiter.ReportOutcome("payments-api", err == nil, time.Since(start))
+//	}
+func WithAdaptive(cfg AdaptiveConfig) Option {
+	return func(o *Options) {
+		o.adaptive = &cfg
+	}
+}
+
+// adaptive tracks the fraction of the rate each key runs at. Keys at
+// their full rate are not stored.
+type adaptive struct {
+	cfg AdaptiveConfig
+
+	mu   sync.Mutex
+	keys map[string]*adaptiveKey
+}
+
+// adaptiveKey is the state of a key running below its full rate.
+type adaptiveKey struct {
+	fraction    float64
+	decreasedAt time.Time
+	reportedAt  time.Time
+}
+
+// newAdaptive creates the adaptive state of a limiter, filling in the
+// defaults of cfg.
+func newAdaptive(cfg AdaptiveConfig) *adaptive {
+	if cfg.Decrease == 0 {
+		cfg.Decrease = 0.5
+	}
+	if cfg.Increase == 0 {
+		cfg.Increase = 0.01
+	}
+	if cfg.MinFraction == 0 {
+		cfg.MinFraction = 0.1
+	}
+	if cfg.Cooldown == 0 {
+		cfg.Cooldown = time.Second
+	}
+	if cfg.IdleReset == 0 {
+		cfg.IdleReset = time.Minute
+	}
+	if cfg.MaxKeys == 0 {
+		cfg.MaxKeys = 10000
+	}
+	return &adaptive{cfg: cfg, keys: make(map[string]*adaptiveKey)}
+}
+
+// report applies the outcome of a request for key.
+func (a *adaptive) report(key string, success bool, latency time.Duration, now time.Time) {
+	if success && a.cfg.LatencyTarget > 0 && latency > a.cfg.LatencyTarget {
+		success = false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	k := a.lookup(key, now)
+	if success {
+		if k == nil {
+			return
+		}
+		k.fraction += a.cfg.Increase
+		k.reportedAt = now
+		if k.fraction >= 1 {
+			delete(a.keys, key)
+		}
+		return
+	}
+
+	if k == nil {
+		if len(a.keys) >= a.cfg.MaxKeys && !a.sweep(now) {
+			return
+		}
+		k = &adaptiveKey{fraction: 1}
+		a.keys[key] = k
+	}
+	k.reportedAt = now
+	if !k.decreasedAt.IsZero() && now.Sub(k.decreasedAt) < a.cfg.Cooldown {
+		return
+	}
+	k.fraction = max(k.fraction*a.cfg.Decrease, a.cfg.MinFraction)
+	k.decreasedAt = now
+}
+
+// fraction returns the fraction of the rate key runs at.
+func (a *adaptive) fraction(key string, now time.Time) float64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if k := a.lookup(key, now); k != nil {
+		return k.fraction
+	}
+	return 1
+}
+
+// lookup returns the state of key, or nil if it runs at its full rate,
+// dropping it if it has been idle for IdleReset. Must be called with a.mu
+// held.
+func (a *adaptive) lookup(key string, now time.Time) *adaptiveKey {
+	k, ok := a.keys[key]
+	if !ok {
+		return nil
+	}
+	if now.Sub(k.reportedAt) >= a.cfg.IdleReset {
+		delete(a.keys, key)
+		return nil
+	}
+	return k
+}
+
+// sweep drops idle keys, and reports whether any was dropped. Must be
+// called with a.mu held.
+func (a *adaptive) sweep(now time.Time) bool {
+	swept := false
+	for key, k := range a.keys {
+		if now.Sub(k.reportedAt) >= a.cfg.IdleReset {
+			delete(a.keys, key)
+			swept = true
+		}
+	}
+	return swept
+}
+
+// ReportOutcome reports how a request allowed for key went, adapting the
+// key's rate: failures, and successes slower than
+// AdaptiveConfig.LatencyTarget, lower it; other successes raise it back.
+// It does nothing if the limiter wasn't created with WithAdaptive.
+func (l *Limiter) ReportOutcome(key string, success bool, latency time.Duration) {
+	if l.adaptive == nil {
+		return
+	}
+	l.adaptive.report(l.HashKey(key), success, latency, l.clock.Now())
+}
+
+// capped applies fraction of the limit to a request costing n that the
+// algorithm allowed with state st. A request taking the key above the
+// fraction is denied and refunded, and st updated to match. Must be called
+// with l.mu held.
+func (l *Limiter) capped(ctx context.Context, key string, n int, st *algorithm.State, fraction float64) bool {
+	if fraction >= 1 || st == nil {
+		return true
+	}
+
+	limit := max(int64(fraction*float64(st.Limit)), 1)
+	if st.Current <= limit {
+		st.Remaining = min(st.Remaining, limit-st.Current)
+		return true
+	}
+
+	over := st.Current - limit
+	l.refundDenied(ctx, key, n, st)
+	st.RetryAfter = time.Duration(math.Ceil(float64(over) * float64(l.window) / float64(l.rate)))
+	return false
+}
+
+// consumption is what a key consumed in the current window, counted once
+// per request by scaled.
+type consumption struct {
+	key     string
+	end     time.Time
+	used    int64
+	counted bool
+	failed  bool
+}
+
+// scaled applies fraction of the rate to a request costing n that the
+// algorithm allowed with state st. The request is counted in c, what its
+// key consumed in the current window; if that goes above fraction of the
+// rate, the request is denied and refunded, and st updated to match.
+//
+// The algorithm's state holds the key's fill level, which only caps the
+// bursts of a bucket: counting consumption instead lowers the sustained
+// rate of every algorithm. If the count can't be kept the request is left
+// to the algorithm. Must be called with l.mu held.
+func (l *Limiter) scaled(ctx context.Context, key string, n int, st *algorithm.State, fraction float64, c *consumption, now time.Time) bool {
+	if fraction >= 1 || st == nil || c.failed {
+		return true
+	}
+
+	if !c.counted {
+		index := now.UnixNano() / int64(l.window)
+		c.key = recordKey(consumedRecord, key+":"+strconv.FormatInt(index, 10))
+		c.end = time.Unix(0, (index+1)*int64(l.window))
+		used, err := l.be.store.Incr(ctx, c.key, int64(n), c.end.Sub(now))
+		if err != nil {
+			c.failed = true
+			return true
+		}
+		c.used, c.counted = used, true
+	}
+
+	rate := l.rate
+	if l.maintenance != nil {
+		rate = l.maintenance.scale(rate)
+	}
+	if l.regions != nil {
+		rate = l.regions.scale(rate)
+	}
+	limit := max(int64(fraction*float64(rate)), 1)
+	if c.used <= limit {
+		st.Remaining = min(st.Remaining, limit-c.used)
+		return true
+	}
+
+	l.uncount(ctx, n, c, now)
+	l.refundDenied(ctx, key, n, st)
+	st.RetryAfter = c.end.Sub(now)
+	return false
+}
+
+// uncount takes a denied request costing n back out of the consumption c,
+// if scaled counted it. Must be called with l.mu held.
+func (l *Limiter) uncount(ctx context.Context, n int, c *consumption, now time.Time) {
+	if c.counted {
+		_, _ = l.be.store.Incr(ctx, c.key, -int64(n), c.end.Sub(now))
+		c.counted = false
+	}
+}
+
+// refundDenied gives back the tokens of a request costing n that the
+// algorithm allowed with state st but that was denied after all. Must be
+// called with l.mu held.
+func (l *Limiter) refundDenied(ctx context.Context, key string, n int, st *algorithm.State) {
+	if r, ok := l.be.active().(algorithm.Refunder); ok {
+		if r.Refund(ctx, key, n) == nil {
+			st.Current = max(st.Current-int64(n), 0)
+		}
+	}
+	st.Remaining = 0
+}
@@ -0,0 +1,59 @@
+package flexlimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Vipul984/flexlimit/clock"
+)
+
+// drive makes perWindow requests for key per window of l, evenly spaced,
+// for windows windows, and returns how many were allowed.
+func drive(t *testing.T, l *Limiter, clk *clock.Mock, key string, perWindow, windows int) int {
+	t.Helper()
+	step := l.window / time.Duration(perWindow)
+	allowed := 0
+	for range perWindow * windows {
+		ok, err := l.Allow(context.Background(), key)
+		if err != nil {
+			t.Fatalf("Allow: %v", err)
+		}
+		if ok {
+			allowed++
+		}
+		clk.Advance(step)
+	}
+	return allowed
+}
+
+func TestAdaptiveLowersSustainedRate(t *testing.T) {
+	for _, algo := range []AlgorithmType{TokenBucket, LeakyBucket, FixedWindow, SlidingWindow} {
+		t.Run(string(algo), func(t *testing.T) {
+			clk := clock.NewMock()
+			l, err := New(100, time.Minute,
+				WithAlgorithm(algo),
+				WithBurst(100),
+				WithClock(clk),
+				WithAdaptive(AdaptiveConfig{MinFraction: 0.1, Cooldown: time.Nanosecond, IdleReset: time.Hour}),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+
+			for range 10 {
+				l.ReportOutcome("k", false, 0)
+				clk.Advance(time.Millisecond)
+			}
+
+			got := drive(t, l, clk, "k", 100, 10)
+			if got > 110 {
+				t.Errorf("allowed %d of 1000 requests at a tenth of the rate, want at most 110", got)
+			}
+			if got < 90 {
+				t.Errorf("allowed %d of 1000 requests at a tenth of the rate, want at least 90", got)
+			}
+		})
+	}
+}
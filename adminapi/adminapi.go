@@ -0,0 +1,366 @@
+// Package adminapi provides an HTTP handler for inspecting and adjusting
+// rate limits at runtime.
+//
+// It lets operators unblock a legitimate user or raise a limit during an
+// incident without redeploying. The handler serves the limiters of a
+// flexlimit.Group and requires a bearer token on every request.
+//
+// Endpoints (paths relative to where the handler is mounted):
+//
+//	GET    /limiters                        list limiters and their limits
+//	GET    /limiters/{name}                 show one limiter's limit
+//	PUT    /limiters/{name}                 change the limit: {"rate": 200, "window": "1m"}
+//	GET    /limiters/{name}/suggestion      show the limit suggested by flexlimit.WithAdvisor
+//	GET    /limiters/{name}/keys?prefix=p   list keys with stored state
+//	GET    /limiters/{name}/keys/{key}      show a key's state
+//	DELETE /limiters/{name}/keys/{key}      reset a key
+//	PUT    /limiters/{name}/bans/{key}      ban a key: {"duration": "1h"}, or {} until unbanned
+//	DELETE /limiters/{name}/bans/{key}      lift a ban
+//
+// Bans require a limiter with flexlimit.WithLifecycle, whose key statuses
+// are also reported in key states.
+//
+// Example:
+//
+//	admin, err := adminapi.New(limits, os.Getenv("FLEXLIMIT_ADMIN_TOKEN"))
+//	if err != nil {
+//	    return err
+//	}
+//	mux.Handle("/admin/", http.StripPrefix("/admin", admin))
+//
+//	// curl -H "Authorization: Bearer $TOKEN" -X DELETE \
+//	//     http://localhost:8080/admin/limiters/login/keys/user:123
+package adminapi
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Vipul984/flexlimit"
+)
+
+// maxBodyBytes bounds the size of request bodies.
+const maxBodyBytes = 1 << 16
+
+// errNoAdvisor is returned for suggestions of limiters without an advisor.
+var errNoAdvisor = errors.New("adminapi: limiter has no advisor")
+
+// Handler serves the admin API. Create one with New.
+type Handler struct {
+	group *flexlimit.Group
+	token []byte
+	mux   *http.ServeMux
+}
+
+// New creates a Handler for the limiters in group. Every request must send
+// "Authorization: Bearer <token>".
+//
+// Returns an *flexlimit.InvalidConfigError if group is nil or token is empty.
+func New(group *flexlimit.Group, token string) (*Handler, error) {
+	if group == nil {
+		return nil, &flexlimit.InvalidConfigError{Field: "group", Value: group, Reason: "must not be nil"}
+	}
+	if token == "" {
+		return nil, &flexlimit.InvalidConfigError{Field: "token", Value: "", Reason: "must not be empty"}
+	}
+
+	h := &Handler{
+		group: group,
+		token: []byte(token),
+		mux:   http.NewServeMux(),
+	}
+	h.mux.HandleFunc("GET /limiters", h.listLimiters)
+	h.mux.HandleFunc("GET /limiters/{name}", h.getLimiter)
+	h.mux.HandleFunc("PUT /limiters/{name}", h.setLimit)
+	h.mux.HandleFunc("GET /limiters/{name}/suggestion", h.getSuggestion)
+	h.mux.HandleFunc("GET /limiters/{name}/keys", h.listKeys)
+	h.mux.HandleFunc("GET /limiters/{name}/keys/{key...}", h.getKey)
+	h.mux.HandleFunc("DELETE /limiters/{name}/keys/{key...}", h.resetKey)
+	h.mux.HandleFunc("PUT /limiters/{name}/bans/{key...}", h.banKey)
+	h.mux.HandleFunc("DELETE /limiters/{name}/bans/{key...}", h.unbanKey)
+	return h, nil
+}
+
+// ServeHTTP authenticates the request and dispatches it.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="flexlimit"`)
+		writeError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+// authorized reports whether r carries the admin token.
+func (h *Handler) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), h.token) == 1
+}
+
+// limiterInfo describes a limiter in responses.
+type limiterInfo struct {
+	Name   string `json:"name"`
+	Rate   int    `json:"rate"`
+	Window string `json:"window"`
+}
+
+// keyState describes a key's state in responses.
+type keyState struct {
+	Key       string    `json:"key"`
+	Limit     int       `json:"limit"`
+	Used      int       `json:"used"`
+	Remaining int       `json:"remaining"`
+	ResetAt   time.Time `json:"reset_at"`
+	ResetIn   string    `json:"reset_in"`
+	Window    string    `json:"window"`
+	Status    string    `json:"status,omitempty"`
+
+	BannedUntil *time.Time `json:"banned_until,omitempty"`
+	Offenses    int        `json:"offenses,omitempty"`
+}
+
+// suggestion is the JSON form of a flexlimit.Suggestion.
+type suggestion struct {
+	Rate         int       `json:"rate"`
+	Window       string    `json:"window"`
+	Percentile   float64   `json:"percentile"`
+	SafetyFactor float64   `json:"safety_factor"`
+	Observed     int       `json:"observed"`
+	Samples      int       `json:"samples"`
+	Since        time.Time `json:"since"`
+	Ready        bool      `json:"ready"`
+}
+
+// banRequest is the body of PUT /limiters/{name}/bans/{key}. A missing
+// duration bans the key until it is unbanned.
+type banRequest struct {
+	Duration *string `json:"duration"`
+}
+
+// limitRequest is the body of PUT /limiters/{name}. A missing field keeps
+// its current value.
+type limitRequest struct {
+	Rate   *int    `json:"rate"`
+	Window *string `json:"window"`
+}
+
+func (h *Handler) listLimiters(w http.ResponseWriter, r *http.Request) {
+	names := h.group.Names()
+	limiters := make([]limiterInfo, 0, len(names))
+	for _, name := range names {
+		if l, ok := h.group.Get(name); ok {
+			limiters = append(limiters, describe(name, l))
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"limiters": limiters})
+}
+
+func (h *Handler) getLimiter(w http.ResponseWriter, r *http.Request) {
+	name, l, ok := h.limiter(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, describe(name, l))
+}
+
+func (h *Handler) setLimit(w http.ResponseWriter, r *http.Request) {
+	name, l, ok := h.limiter(w, r)
+	if !ok {
+		return
+	}
+
+	var req limitRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	rate, window := l.Limit()
+	if req.Rate != nil {
+		rate = *req.Rate
+	}
+	if req.Window != nil {
+		d, err := time.ParseDuration(*req.Window)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		window = d
+	}
+
+	if err := l.SetLimit(rate, window); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, describe(name, l))
+}
+
+func (h *Handler) getSuggestion(w http.ResponseWriter, r *http.Request) {
+	_, l, ok := h.limiter(w, r)
+	if !ok {
+		return
+	}
+
+	s, ok := l.Suggest()
+	if !ok {
+		writeError(w, http.StatusNotFound, errNoAdvisor)
+		return
+	}
+	writeJSON(w, http.StatusOK, suggestion{
+		Rate:         s.Rate,
+		Window:       s.Window.String(),
+		Percentile:   s.Percentile,
+		SafetyFactor: s.SafetyFactor,
+		Observed:     s.Observed,
+		Samples:      s.Samples,
+		Since:        s.Since,
+		Ready:        s.Ready,
+	})
+}
+
+func (h *Handler) listKeys(w http.ResponseWriter, r *http.Request) {
+	_, l, ok := h.limiter(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := l.Keys(r.Context(), r.URL.Query().Get("prefix"))
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"keys": keys})
+}
+
+func (h *Handler) getKey(w http.ResponseWriter, r *http.Request) {
+	_, l, ok := h.limiter(w, r)
+	if !ok {
+		return
+	}
+
+	st, err := l.State(r.Context(), r.PathValue("key"))
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	ks := keyState{
+		Key:       st.Key,
+		Limit:     st.Limit,
+		Used:      st.Used,
+		Remaining: st.Remaining,
+		ResetAt:   st.ResetAt,
+		ResetIn:   st.ResetIn.String(),
+		Window:    st.Window.String(),
+		Status:    string(st.Status),
+		Offenses:  st.Offenses,
+	}
+	if !st.BannedUntil.IsZero() {
+		ks.BannedUntil = &st.BannedUntil
+	}
+	writeJSON(w, http.StatusOK, ks)
+}
+
+func (h *Handler) resetKey(w http.ResponseWriter, r *http.Request) {
+	_, l, ok := h.limiter(w, r)
+	if !ok {
+		return
+	}
+
+	if err := l.Reset(r.Context(), r.PathValue("key")); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) banKey(w http.ResponseWriter, r *http.Request) {
+	_, l, ok := h.limiter(w, r)
+	if !ok {
+		return
+	}
+
+	var req banRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var d time.Duration
+	if req.Duration != nil {
+		var err error
+		if d, err = time.ParseDuration(*req.Duration); err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, &flexlimit.InvalidConfigError{Field: "duration", Value: *req.Duration, Reason: "must be a positive duration"})
+			return
+		}
+	}
+
+	if err := l.Ban(r.Context(), r.PathValue("key"), d); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) unbanKey(w http.ResponseWriter, r *http.Request) {
+	_, l, ok := h.limiter(w, r)
+	if !ok {
+		return
+	}
+
+	if err := l.Unban(r.Context(), r.PathValue("key")); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// limiter looks up the limiter named in the path, writing a 404 if there
+// is none.
+func (h *Handler) limiter(w http.ResponseWriter, r *http.Request) (string, *flexlimit.Limiter, bool) {
+	name := r.PathValue("name")
+	l, ok := h.group.Get(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, flexlimit.ErrLimiterNotFound)
+		return "", nil, false
+	}
+	return name, l, true
+}
+
+// describe builds the response for a limiter.
+func describe(name string, l *flexlimit.Limiter) limiterInfo {
+	rate, window := l.Limit()
+	return limiterInfo{Name: name, Rate: rate, Window: window.String()}
+}
+
+// statusOf maps a limiter error to an HTTP status code.
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, flexlimit.ErrInvalidConfig):
+		return http.StatusBadRequest
+	case errors.Is(err, flexlimit.ErrLimiterClosed):
+		return http.StatusGone
+	case errors.Is(err, flexlimit.ErrContextCanceled), errors.Is(err, flexlimit.ErrContextDeadlineExceeded):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusServiceUnavailable
+	}
+}
+
+// writeJSON writes v as a JSON response.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes err as a JSON error response.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,162 @@
+package flexlimit
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotAdmitted is returned by Composite.Allow when every rule allowed a
+// request but the Composite's Admission rejected it.
+var ErrNotAdmitted = errors.New("request not admitted")
+
+// Admission makes the final decision on requests evaluated by a
+// Composite, so business rules such as "always let paying customers
+// through during an incident" can override the limits without changing
+// how they are evaluated. See Composite.SetAdmission.
+//
+// Implementations must be safe for concurrent use.
+type Admission interface {
+	// Admit returns whether the request described by ev is allowed. It is
+	// called for every request, whatever the rules decided.
+	Admit(ctx context.Context, ev *Evaluation) bool
+}
+
+// AdmissionFunc adapts a function to the Admission interface.
+//
+// Example:
+//
+//	c.SetAdmission(flexlimit.AdmissionFunc(func(ctx context.Context, ev *flexlimit.Evaluation) bool {
+//	    return ev.Allowed || ev.Attributes["plan"] == "enterprise" && ev.Degraded()
+//	}))
+type AdmissionFunc func(ctx context.Context, ev *Evaluation) bool
+
+// Admit calls f(ctx, ev).
+func (f AdmissionFunc) Admit(ctx context.Context, ev *Evaluation) bool {
+	return f(ctx, ev)
+}
+
+// Evaluation is how a Composite's rules decided a request, as passed to
+// an Admission.
+type Evaluation struct {
+	// Attributes describe the request
+	Attributes Attributes
+
+	// Results holds the outcome of each limiter key the request was
+	// charged to, in rule order
+	Results []RuleResult
+
+	// Allowed reports whether every rule allowed the request
+	Allowed bool
+
+	// Err is the error Allow returns if the request is not admitted: the
+	// *LimitExceededError or limiter error of the first rule that did not
+	// allow it, or nil if Allowed
+	Err error
+}
+
+// Degraded reports whether any rule was decided by its limiter's
+// fallback strategy or failed, typically because storage is unavailable.
+func (ev *Evaluation) Degraded() bool {
+	for _, r := range ev.Results {
+		if r.Degraded() {
+			return true
+		}
+	}
+	return false
+}
+
+// Result returns the outcome of the rule named rule, or false if the rule
+// didn't apply to the request or shares its key with an earlier rule.
+func (ev *Evaluation) Result(rule string) (RuleResult, bool) {
+	for _, r := range ev.Results {
+		if r.Rule == rule {
+			return r, true
+		}
+	}
+	return RuleResult{}, false
+}
+
+// RuleResult is the outcome of one limiter key charged for a request.
+type RuleResult struct {
+	// Rule is the first rule resolving to the key
+	Rule string
+
+	// Key and Cost are the key charged and the tokens it was charged
+	Key  string
+	Cost int
+
+	// Allowed reports whether the limiter allowed the charge
+	Allowed bool
+
+	// State is the key's state after the charge. It is nil if the
+	// fallback strategy decided or the limiter failed
+	State *State
+
+	// Err is the error of the limiter, if it failed
+	Err error
+}
+
+// Degraded reports whether the charge was decided by the limiter's
+// fallback strategy or failed.
+func (r RuleResult) Degraded() bool {
+	return r.State == nil
+}
+
+// SetAdmission sets the Admission making the final decision on each
+// request, or removes it if a is nil.
+//
+// With an Admission, Allow charges every rule that applies to a request
+// instead of stopping at the first denial, so the Admission sees the state
+// of all of them. If the request is not admitted, every charge is
+// refunded; if it is, the charges rules allowed are kept.
+func (c *Composite) SetAdmission(a Admission) {
+	if a == nil {
+		c.admission.Store(nil)
+		return
+	}
+	c.admission.Store(&a)
+}
+
+// admit evaluates charges for a request described by attrs and lets a
+// decide whether it is allowed.
+func (c *Composite) admit(ctx context.Context, a Admission, attrs Attributes, charges []charge) error {
+	ev := &Evaluation{
+		Attributes: attrs,
+		Results:    make([]RuleResult, 0, len(charges)),
+		Allowed:    true,
+	}
+	taken := make([]charge, 0, len(charges))
+
+	for _, ch := range charges {
+		allowed, st, err := ch.limiter.allowN(ctx, ch.key, ch.cost)
+		res := RuleResult{Rule: ch.name, Key: ch.limiter.HashKey(ch.key), Cost: ch.cost, Allowed: allowed && err == nil, Err: err}
+		if st != nil {
+			res.State = ch.limiter.newState(st, ch.limiter.clock.Now())
+		}
+		ev.Results = append(ev.Results, res)
+
+		if res.Allowed {
+			taken = append(taken, ch)
+			continue
+		}
+		if ev.Allowed {
+			ev.Allowed = false
+			if err == nil {
+				limitErr := newLimitExceededError("", ch.key, ch.limiter, st)
+				limitErr.Rule = ch.name
+				err = limitErr
+			}
+			ev.Err = err
+		}
+	}
+
+	if a.Admit(ctx, ev) {
+		return nil
+	}
+
+	refundCharges(ctx, taken)
+	if ev.Err != nil {
+		return ev.Err
+	}
+	return ErrNotAdmitted
+}
@@ -0,0 +1,174 @@
+package flexlimit
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+)
+
+// AdvisorConfig configures limit suggestions from observed traffic. See
+// WithAdvisor.
+type AdvisorConfig struct {
+	// Period is how long traffic is observed before a suggestion is
+	// considered ready. Default: 24 hours
+	Period time.Duration
+
+	// Percentile of the per-key usage per window the suggestion is based
+	// on, in (0, 100]. Default: 99.9
+	Percentile float64
+
+	// SafetyFactor multiplies the percentile to leave headroom for growth.
+	// Default: 1.5
+	SafetyFactor float64
+
+	// MaxSamples bounds memory: at most this many per-key window totals
+	// are kept (a uniform random sample of all of them), and at most this
+	// many keys are counted per window. Default: 100000
+	MaxSamples int
+}
+
+// Suggestion is a limit suggested from observed traffic.
+type Suggestion struct {
+	// Rate is the suggested number of requests per Window for each key
+	Rate int
+
+	// Window is the window the traffic was measured in: the limiter's
+	// window when it was created
+	Window time.Duration
+
+	// Percentile and SafetyFactor are the configured ones; Rate is
+	// Observed × SafetyFactor, rounded up
+	Percentile   float64
+	SafetyFactor float64
+
+	// Observed is the Percentile of per-key usage per window
+	Observed int
+
+	// Samples is how many per-key window totals Observed was computed from
+	Samples int
+
+	// Since is when observation started
+	Since time.Time
+
+	// Ready reports whether traffic has been observed for the configured
+	// Period
+	Ready bool
+}
+
+// advisor records how much each key uses per window. Only complete windows
+// are sampled; keys with no requests in a window are not.
+type advisor struct {
+	cfg    AdvisorConfig
+	window time.Duration
+	since  time.Time
+
+	mu          sync.Mutex
+	windowStart time.Time
+	current     map[string]int64
+	samples     []int64
+	seen        int64
+}
+
+// newAdvisor creates an advisor for a limiter with the given window,
+// filling in the defaults of cfg.
+func newAdvisor(cfg AdvisorConfig, window time.Duration, now time.Time) *advisor {
+	if cfg.Period == 0 {
+		cfg.Period = 24 * time.Hour
+	}
+	if cfg.Percentile == 0 {
+		cfg.Percentile = 99.9
+	}
+	if cfg.SafetyFactor == 0 {
+		cfg.SafetyFactor = 1.5
+	}
+	if cfg.MaxSamples == 0 {
+		cfg.MaxSamples = 100000
+	}
+
+	return &advisor{
+		cfg:         cfg,
+		window:      window,
+		since:       now,
+		windowStart: now.Truncate(window),
+		current:     make(map[string]int64),
+	}
+}
+
+// observe records a request costing n for key, whatever the decision.
+func (a *advisor) observe(key string, n int, now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.roll(now)
+	if c, ok := a.current[key]; ok || len(a.current) < a.cfg.MaxSamples {
+		a.current[key] = c + int64(n)
+	}
+}
+
+// roll samples the totals of the current window once now is past it.
+// Must be called with a.mu held.
+func (a *advisor) roll(now time.Time) {
+	start := now.Truncate(a.window)
+	if !start.After(a.windowStart) {
+		return
+	}
+
+	for _, total := range a.current {
+		a.sample(total)
+	}
+	clear(a.current)
+	a.windowStart = start
+}
+
+// sample adds total to the reservoir of samples. Must be called with a.mu
+// held.
+func (a *advisor) sample(total int64) {
+	a.seen++
+	if len(a.samples) < a.cfg.MaxSamples {
+		a.samples = append(a.samples, total)
+		return
+	}
+	if i := rand.Int64N(a.seen); i < int64(len(a.samples)) {
+		a.samples[i] = total
+	}
+}
+
+// suggest computes a Suggestion from the complete windows seen by now.
+func (a *advisor) suggest(now time.Time) Suggestion {
+	a.mu.Lock()
+	a.roll(now)
+	samples := slices.Clone(a.samples)
+	a.mu.Unlock()
+
+	s := Suggestion{
+		Window:       a.window,
+		Percentile:   a.cfg.Percentile,
+		SafetyFactor: a.cfg.SafetyFactor,
+		Samples:      len(samples),
+		Since:        a.since,
+		Ready:        now.Sub(a.since) >= a.cfg.Period,
+	}
+	if len(samples) == 0 {
+		return s
+	}
+
+	slices.Sort(samples)
+	i := int(math.Ceil(a.cfg.Percentile/100*float64(len(samples)))) - 1
+	s.Observed = int(samples[max(i, 0)])
+	s.Rate = max(int(math.Ceil(float64(s.Observed)*a.cfg.SafetyFactor)), 1)
+	return s
+}
+
+// Suggest returns a limit suggested from the traffic observed since the
+// limiter was created, or false if it wasn't created with WithAdvisor.
+//
+// Until Suggestion.Ready, the suggestion is based on less traffic than
+// configured and should be taken with care.
+func (l *Limiter) Suggest() (Suggestion, bool) {
+	if l.advisor == nil {
+		return Suggestion{}, false
+	}
+	return l.advisor.suggest(l.clock.Now()), true
+}
@@ -3,7 +3,11 @@ package algorithm
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
+
+	"github.com/Vipul984/flexlimit/clock"
+	"github.com/Vipul984/flexlimit/storage"
 )
 
 type Algorithm interface {
@@ -57,6 +61,70 @@ type Algorithm interface {
 	Close() error
 }
 
+// Queuer is implemented by algorithms that can queue excess requests
+// instead of dropping them.
+type Queuer interface {
+	// Enqueue reserves cost units for key and returns how long the caller
+	// must wait before proceeding. ok is false, and nothing is reserved, if
+	// the queue is full.
+	Enqueue(ctx context.Context, key string, cost int) (wait time.Duration, ok bool, state *State, err error)
+
+	// Dequeue releases cost units reserved by Enqueue, for a caller that
+	// gave up before its turn came.
+	Dequeue(ctx context.Context, key string, cost int) error
+}
+
+// Refunder is implemented by algorithms that can give back units consumed
+// by Allow, for requests that were counted but never carried out.
+type Refunder interface {
+	// Refund returns cost units to key. It never leaves more units
+	// available than a key that was never used has.
+	Refund(ctx context.Context, key string, cost int) error
+}
+
+// Batcher is implemented by algorithms that can decide several requests
+// in one storage round trip.
+type Batcher interface {
+	// AllowBatch runs Allow for every request and returns the results in
+	// the same order. Requests are decided independently: some may be
+	// allowed and others denied.
+	AllowBatch(ctx context.Context, reqs []BatchRequest) ([]BatchResult, error)
+}
+
+// BatchRequest is one request of an AllowBatch call.
+type BatchRequest struct {
+	// Key is the rate limit key
+	Key string
+
+	// Cost is the number of tokens the request consumes
+	Cost int
+}
+
+// BatchResult is the outcome of one BatchRequest.
+type BatchResult struct {
+	// Allowed reports whether the request's tokens were consumed
+	Allowed bool
+
+	// State is the key's state after the request
+	State *State
+}
+
+// KeyMapper is implemented by algorithms that store a key's state under
+// derived storage keys instead of the key itself.
+type KeyMapper interface {
+	// KeyOf returns the rate limit key a storage key belongs to, or false
+	// if storageKey was not written by the algorithm.
+	KeyOf(storageKey string) (string, bool)
+}
+
+// KeyPatterner is implemented by KeyMappers whose storage keys don't
+// start with the rate limit key, such as hash-tagged counter keys.
+type KeyPatterner interface {
+	// KeyPattern returns the storage.Storage.Keys pattern matching the
+	// storage keys of every rate limit key starting with prefix.
+	KeyPattern(prefix string) string
+}
+
 // State represents the current rate limiting state for a key.
 //
 // This is the algorithm's view of state - it contains calculated values
@@ -85,7 +153,8 @@ type State struct {
 	Current int64
 
 	// Algorithm identifies which algorithm produced this state
-	// ("token_bucket", "fixed_window", "sliding_window", "leaky_bucket")
+	// ("token_bucket", "fixed_window", "sliding_window", "leaky_bucket",
+	// "decayed_window")
 	Algorithm string
 }
 
@@ -102,12 +171,40 @@ type Config struct {
 	// Window is the time period for the rate limit
 	Window time.Duration
 
-	// BurstSize is the maximum burst capacity (Token Bucket specific)
-	// If 0, defaults to Rate (no extra burst capacity)
+	// BurstSize is the maximum burst capacity (Token Bucket, Leaky Bucket
+	// and Decayed Window). If 0, defaults to Rate (no extra burst
+	// capacity), or to 1 for Leaky Bucket
 	BurstSize int64
 
 	// Algorithm specifies which algorithm to use
 	Algorithm string
+
+	// Buckets is the number of sub-window counters a Sliding Window keeps
+	// per key. If 0, it stores one timestamp per request instead
+	Buckets int64
+
+	// QueueSize is how many units may wait in the queue beyond BurstSize
+	// (Leaky Bucket specific). If 0, excess requests are always dropped
+	QueueSize int64
+
+	// DrainJitter spreads the release times of different keys over up to
+	// this long, so keys filled at the same instant don't all release
+	// their requests at once (Leaky Bucket specific). Each key is delayed
+	// by its own fixed part of it. If 0, keys drain in step
+	DrainJitter time.Duration
+
+	// Calendar aligns Fixed Window windows to calendar periods ("day",
+	// "week", "month" or "year") in Location instead of Window. If empty,
+	// windows are Window long and aligned to the Unix epoch
+	Calendar string
+
+	// Location is the time zone of calendar windows. Default: UTC
+	Location *time.Location
+
+	// Rollover is the most unused requests a Fixed Window key carries over
+	// into later windows, on top of Rate (Fixed Window specific). If 0,
+	// unused requests are lost when their window ends
+	Rollover int64
 }
 
 // Validate checks if the config is valid.
@@ -136,6 +233,48 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	if c.Buckets < 0 || c.Buckets > int64(c.Window) {
+		return &ConfigError{
+			Field:  "buckets",
+			Value:  c.Buckets,
+			Reason: "must be between 0 and the window in nanoseconds",
+		}
+	}
+
+	if c.QueueSize < 0 {
+		return &ConfigError{
+			Field:  "queue_size",
+			Value:  c.QueueSize,
+			Reason: "cannot be negative",
+		}
+	}
+
+	if c.DrainJitter < 0 {
+		return &ConfigError{
+			Field:  "drain_jitter",
+			Value:  c.DrainJitter,
+			Reason: "cannot be negative",
+		}
+	}
+
+	if c.Rollover < 0 {
+		return &ConfigError{
+			Field:  "rollover",
+			Value:  c.Rollover,
+			Reason: "cannot be negative",
+		}
+	}
+
+	switch c.Calendar {
+	case "", "day", "week", "month", "year":
+	default:
+		return &ConfigError{
+			Field:  "calendar",
+			Value:  c.Calendar,
+			Reason: "must be one of: day, week, month, year",
+		}
+	}
+
 	return nil
 }
 
@@ -171,6 +310,11 @@ const (
 	// LeakyBucket enforces a strict constant rate.
 	// Best for: Traffic shaping, smooth rate enforcement
 	LeakyBucket AlgorithmType = "leaky_bucket"
+
+	// DecayedWindow counts requests in a sliding window where recent
+	// requests weigh more than old ones.
+	// Best for: Abuse detection, penalizing sustained pressure over bursts
+	DecayedWindow AlgorithmType = "decayed_window"
 )
 
 // String returns the string representation of the algorithm type.
@@ -178,16 +322,60 @@ func (a AlgorithmType) String() string {
 	return string(a)
 }
 
-// Validate checks if the algorithm type is valid.
+// Validate checks if the algorithm type is a built-in or registered
+// algorithm (see Register).
 func (a AlgorithmType) Validate() error {
-	switch a {
-	case TokenBucket, FixedWindow, SlidingWindow, LeakyBucket:
+	if builtin(a) {
 		return nil
-	default:
-		return &ConfigError{
-			Field:  "algorithm",
-			Value:  a,
-			Reason: "must be one of: token_bucket, fixed_window, sliding_window, leaky_bucket",
-		}
 	}
+	if _, ok := Lookup(string(a)); ok {
+		return nil
+	}
+	return &ConfigError{
+		Field:  "algorithm",
+		Value:  a,
+		Reason: "must be one of: " + strings.Join(Names(), ", "),
+	}
+}
+
+// New creates the algorithm named by cfg.Algorithm, built-in or
+// registered with Register, backed by store.
+//
+// An empty cfg.Algorithm selects TokenBucket.
+//
+// Example:
+//
+//	algo, err := algorithm.New(algorithm.Config{
+//	    Rate:      100,
+//	    Window:    time.Minute,
+//	    Algorithm: "sliding_window",
+//	}, store, clock.New())
+func New(cfg Config, store storage.Storage, clk clock.Clock) (Algorithm, error) {
+	algo := AlgorithmType(cfg.Algorithm)
+	if algo == "" {
+		algo = TokenBucket
+	}
+
+	if err := algo.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch algo {
+	case FixedWindow:
+		return NewFixedWindow(cfg, store, clk)
+	case SlidingWindow:
+		return NewSlidingWindow(cfg, store, clk)
+	case LeakyBucket:
+		return NewLeakyBucket(cfg, store, clk)
+	case DecayedWindow:
+		return NewDecayedWindow(cfg, store, clk)
+	case TokenBucket:
+		return NewTokenBucket(cfg, store, clk)
+	}
+
+	factory, _ := Lookup(string(algo))
+	if clk == nil {
+		clk = clock.New()
+	}
+	return factory(cfg, store, clk)
 }
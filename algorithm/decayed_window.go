@@ -0,0 +1,187 @@
+package algorithm
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/Vipul984/flexlimit/clock"
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+var (
+	_ Algorithm = (*decayedWindow)(nil)
+	_ Refunder  = (*decayedWindow)(nil)
+)
+
+// decayFloor is the weight below which a decayed count is dropped from
+// storage, a hundredth of a request.
+const decayFloor = 0.01
+
+// decayedWindow implements a sliding window whose requests weigh less as
+// they age: each key keeps an exponentially decayed count of its
+// requests, which loses a factor of e every Window.
+//
+// A request is allowed if the decayed count plus its cost stays within
+// the limit, BurstSize or Rate if BurstSize is 0. Traffic sustained at
+// Rate requests per Window holds the count at Rate, so the limit is the
+// same as a sliding window's for steady traffic; what differs is that a
+// burst is forgotten quickly while sustained pressure keeps the count, and
+// a key denied for abuse stays denied as long as the pressure lasts.
+//
+// State is stored as storage.State{Tokens, LastRefill}, where Tokens is
+// the decayed count and LastRefill the time it was last decayed.
+type decayedWindow struct {
+	limit  float64
+	tau    float64 // decay time constant, in nanoseconds
+	window time.Duration
+
+	store storage.Storage
+	clock clock.Clock
+	locks keyLocks
+}
+
+// NewDecayedWindow creates a decayed sliding window backed by store.
+func NewDecayedWindow(cfg Config, store storage.Storage, clk clock.Clock) (Algorithm, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	limit := cfg.Rate
+	if cfg.BurstSize > 0 {
+		limit = cfg.BurstSize
+	}
+
+	if clk == nil {
+		clk = clock.New()
+	}
+
+	return &decayedWindow{
+		limit:  float64(limit),
+		tau:    float64(cfg.Window),
+		window: cfg.Window,
+		store:  store,
+		clock:  clk,
+	}, nil
+}
+
+// Allow adds cost to the decayed count if it stays within the limit.
+func (dw *decayedWindow) Allow(ctx context.Context, key string, cost int) (bool, *State, error) {
+	unlock := dw.locks.lock(key)
+	defer unlock()
+
+	now := dw.clock.Now()
+	count, err := dw.load(ctx, key, now)
+	if err != nil {
+		return false, nil, err
+	}
+
+	if count+float64(cost) > dw.limit {
+		return false, dw.state(key, count, float64(cost), now), nil
+	}
+
+	count += float64(cost)
+	if err := dw.save(ctx, key, count, now); err != nil {
+		return false, nil, err
+	}
+
+	return true, dw.state(key, count, 0, now), nil
+}
+
+// Refund takes cost back out of the decayed count.
+func (dw *decayedWindow) Refund(ctx context.Context, key string, cost int) error {
+	unlock := dw.locks.lock(key)
+	defer unlock()
+
+	now := dw.clock.Now()
+	count, err := dw.load(ctx, key, now)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return nil
+	}
+
+	return dw.save(ctx, key, math.Max(0, count-float64(cost)), now)
+}
+
+// State returns the key's current decayed count without adding to it.
+func (dw *decayedWindow) State(ctx context.Context, key string) (*State, error) {
+	now := dw.clock.Now()
+	count, err := dw.load(ctx, key, now)
+	if err != nil {
+		return nil, err
+	}
+	return dw.state(key, count, 1, now), nil
+}
+
+// Reset clears the decayed count for key.
+func (dw *decayedWindow) Reset(ctx context.Context, key string) error {
+	return dw.store.Delete(ctx, key)
+}
+
+// Close is a no-op; the storage is owned by the caller.
+func (dw *decayedWindow) Close() error {
+	return nil
+}
+
+// save stores count for key, keeping it until it has decayed below
+// decayFloor.
+func (dw *decayedWindow) save(ctx context.Context, key string, count float64, now time.Time) error {
+	return dw.store.Set(ctx, key, &storage.State{
+		Tokens:     count,
+		LastRefill: now,
+	}, dw.timeToDecay(count, decayFloor)+dw.window)
+}
+
+// load returns the decayed count for key at now.
+func (dw *decayedWindow) load(ctx context.Context, key string, now time.Time) (float64, error) {
+	st, err := dw.store.Get(ctx, key)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	elapsed := now.Sub(st.LastRefill)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return st.Tokens * math.Exp(-float64(elapsed)/dw.tau), nil
+}
+
+// state builds the public State. need is the number of requests a caller
+// wants to add; RetryAfter is how long until they fit. ResetAt is when
+// the count decays below one request.
+func (dw *decayedWindow) state(key string, count, need float64, now time.Time) *State {
+	limit := int64(dw.limit)
+	remaining := int64(math.Floor(dw.limit - count))
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	var retryAfter time.Duration
+	if need > 0 && count+need > dw.limit {
+		retryAfter = dw.timeToDecay(count, math.Max(dw.limit-need, 1))
+	}
+
+	return &State{
+		Key:        key,
+		Limit:      limit,
+		Remaining:  remaining,
+		Current:    limit - remaining,
+		ResetAt:    now.Add(dw.timeToDecay(count, 1)),
+		RetryAfter: retryAfter,
+		Algorithm:  string(DecayedWindow),
+	}
+}
+
+// timeToDecay returns how long a count takes to decay to target.
+func (dw *decayedWindow) timeToDecay(count, target float64) time.Duration {
+	if count <= target {
+		return 0
+	}
+	return time.Duration(math.Ceil(dw.tau * math.Log(count/target)))
+}
@@ -0,0 +1,349 @@
+package algorithm
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Vipul984/flexlimit/clock"
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+var (
+	_ Algorithm = (*fixedWindow)(nil)
+	_ KeyMapper = (*fixedWindow)(nil)
+	_ Refunder  = (*fixedWindow)(nil)
+)
+
+// creditPrefix starts the key of a key's rollover credit record, in the
+// namespace flexlimit reserves for records besides a key's state.
+const creditPrefix = "_fl:credit:"
+
+// fixedWindow implements the fixed window counter algorithm.
+//
+// Time is divided into windows aligned to the Unix epoch, or to calendar
+// periods such as months in a time zone. Each window has its own counter
+// key ("<key>:<window index>") that expires with the window, so counters
+// never need explicit cleanup.
+//
+// Counting uses storage.Incr, which is atomic in every backend. A request
+// that would exceed the limit is rolled back with a negative Incr, so
+// concurrent callers may be denied spuriously under contention but the
+// limit is never exceeded.
+//
+// With a Rollover, the requests a key leaves unused in a window raise its
+// limit in the next one, up to Rollover on top of Rate. Each key keeps a
+// credit record ("_fl:credit:<key>") holding its credit for the last window
+// it made requests in, as storage.State{Tokens, WindowStart}; the credit
+// of a later window is rolled forward from it and that window's counter.
+// A key without a record has no credit, so credit only comes from unused
+// windows. Counters and records outlive their window until the credit
+// would have filled up again; a key idle for longer starts over.
+type fixedWindow struct {
+	limit  int64
+	window time.Duration
+
+	// calendar and location align windows to calendar periods; calendar
+	// is empty for epoch-aligned windows
+	calendar string
+	location *time.Location
+
+	// rollover caps the credit carried between windows; keep is how many
+	// idle windows refill it plus the one it is full in, which counters
+	// and records outlive
+	rollover int64
+	keep     int64
+
+	store storage.Storage
+	clock clock.Clock
+}
+
+// NewFixedWindow creates a fixed window counter backed by store.
+func NewFixedWindow(cfg Config, store storage.Storage, clk clock.Clock) (Algorithm, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	if clk == nil {
+		clk = clock.New()
+	}
+
+	location := cfg.Location
+	if location == nil {
+		location = time.UTC
+	}
+
+	var keep int64
+	if cfg.Rollover > 0 {
+		keep = (cfg.Rollover+cfg.Rate-1)/cfg.Rate + 1
+	}
+
+	return &fixedWindow{
+		limit:    cfg.Rate,
+		window:   cfg.Window,
+		calendar: cfg.Calendar,
+		location: location,
+		rollover: cfg.Rollover,
+		keep:     keep,
+		store:    store,
+		clock:    clk,
+	}, nil
+}
+
+// Allow counts cost requests against the current window.
+func (fw *fixedWindow) Allow(ctx context.Context, key string, cost int) (bool, *State, error) {
+	now := fw.clock.Now()
+	start, resetAt := fw.current(now)
+	windowKey, ttl := fw.counterKey(key, start), fw.ttl(start, now)
+
+	limit, err := fw.limitOf(ctx, key, start, now, true)
+	if err != nil {
+		return false, nil, err
+	}
+
+	count, err := fw.store.Incr(ctx, windowKey, int64(cost), ttl)
+	if err != nil {
+		return false, nil, err
+	}
+
+	if count > limit {
+		count, err = fw.store.Incr(ctx, windowKey, -int64(cost), ttl)
+		if err != nil {
+			return false, nil, err
+		}
+		return false, fw.state(key, count, limit, resetAt, now, true), nil
+	}
+
+	return true, fw.state(key, count, limit, resetAt, now, false), nil
+}
+
+// State returns the current window's usage without counting a request.
+func (fw *fixedWindow) State(ctx context.Context, key string) (*State, error) {
+	now := fw.clock.Now()
+	start, resetAt := fw.current(now)
+
+	limit, err := fw.limitOf(ctx, key, start, now, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var count int64
+	st, err := fw.store.Get(ctx, fw.counterKey(key, start))
+	switch {
+	case errors.Is(err, storage.ErrKeyNotFound):
+	case err != nil:
+		return nil, err
+	default:
+		count = st.Count
+	}
+
+	return fw.state(key, count, limit, resetAt, now, count >= limit), nil
+}
+
+// Refund takes cost requests back out of the current window's counter.
+// Requests counted in an earlier window have already expired.
+func (fw *fixedWindow) Refund(ctx context.Context, key string, cost int) error {
+	now := fw.clock.Now()
+	start, _ := fw.current(now)
+
+	_, err := refundCounter(ctx, fw.store, fw.counterKey(key, start), int64(cost), fw.ttl(start, now))
+	return err
+}
+
+// Reset clears the current window's counter for key, and its credit
+// record so it starts over without credit.
+func (fw *fixedWindow) Reset(ctx context.Context, key string) error {
+	start, _ := fw.current(fw.clock.Now())
+	if err := fw.store.Delete(ctx, fw.counterKey(key, start)); err != nil {
+		return err
+	}
+	if fw.rollover == 0 {
+		return nil
+	}
+	return fw.store.Delete(ctx, creditPrefix+key)
+}
+
+// Close is a no-op; the storage is owned by the caller.
+func (fw *fixedWindow) Close() error {
+	return nil
+}
+
+// KeyOf strips the window index from a counter key.
+func (fw *fixedWindow) KeyOf(storageKey string) (string, bool) {
+	return counterKeyOf(storageKey)
+}
+
+// refundCounter takes up to amount back out of a counter without leaving
+// it below zero, and returns how much of amount was left over. Both steps
+// are atomic Incr calls, so a concurrent request may briefly see the
+// counter below zero when more is refunded than was counted.
+func refundCounter(ctx context.Context, store storage.Storage, key string, amount int64, ttl time.Duration) (int64, error) {
+	count, err := store.Incr(ctx, key, -amount, ttl)
+	if err != nil {
+		return amount, err
+	}
+	if count >= 0 {
+		return 0, nil
+	}
+
+	if _, err := store.Incr(ctx, key, -count, ttl); err != nil {
+		return -count, err
+	}
+	return -count, nil
+}
+
+// counterKeyOf strips the ":<index>" suffix of a counter key.
+func counterKeyOf(storageKey string) (string, bool) {
+	i := strings.LastIndexByte(storageKey, ':')
+	if i < 0 {
+		return "", false
+	}
+	if _, err := strconv.ParseInt(storageKey[i+1:], 10, 64); err != nil {
+		return "", false
+	}
+	return storageKey[:i], true
+}
+
+// current returns the start and end of the window containing now.
+func (fw *fixedWindow) current(now time.Time) (time.Time, time.Time) {
+	if fw.calendar != "" {
+		return calendarWindow(fw.calendar, now.In(fw.location))
+	}
+
+	index := now.UnixNano() / int64(fw.window)
+	return time.Unix(0, index*int64(fw.window)), time.Unix(0, (index+1)*int64(fw.window))
+}
+
+// next returns the start of the window after the one starting at start.
+func (fw *fixedWindow) next(start time.Time) time.Time {
+	if fw.calendar != "" {
+		_, end := calendarWindow(fw.calendar, start.In(fw.location))
+		return end
+	}
+	return start.Add(fw.window)
+}
+
+// counterKey returns the counter key of key for the window starting at
+// start.
+func (fw *fixedWindow) counterKey(key string, start time.Time) string {
+	if fw.calendar != "" {
+		return key + ":" + strconv.FormatInt(start.Unix(), 10)
+	}
+	return key + ":" + strconv.FormatInt(start.UnixNano()/int64(fw.window), 10)
+}
+
+// ttl returns how long to keep the counter and credit record of the
+// window starting at start: until it ends, plus the windows it takes to
+// refill the credit and the first window it is full in.
+func (fw *fixedWindow) ttl(start, now time.Time) time.Duration {
+	end := fw.next(start)
+	for range fw.keep {
+		end = fw.next(end)
+	}
+	return end.Sub(now)
+}
+
+// limitOf returns the limit of key in the window starting at start: Rate
+// plus the key's credit. With save, the credit is recorded for the window.
+func (fw *fixedWindow) limitOf(ctx context.Context, key string, start, now time.Time, save bool) (int64, error) {
+	if fw.rollover == 0 {
+		return fw.limit, nil
+	}
+
+	creditKey := creditPrefix + key
+	var credit int64
+	st, err := fw.store.Get(ctx, creditKey)
+	switch {
+	case errors.Is(err, storage.ErrKeyNotFound):
+	case err != nil:
+		return 0, err
+	case !st.WindowStart.Before(start):
+		return fw.limit + int64(st.Tokens), nil
+	default:
+		credit, err = fw.rollForward(ctx, key, st.WindowStart, int64(st.Tokens), start)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if save {
+		err := fw.store.Set(ctx, creditKey, &storage.State{
+			Tokens:      float64(credit),
+			WindowStart: start,
+		}, fw.ttl(start, now))
+		if err != nil {
+			return 0, err
+		}
+	}
+	return fw.limit + credit, nil
+}
+
+// rollForward returns the credit of key in the window starting at start,
+// given its credit in the earlier window starting at from. The windows in
+// between had no requests, or the record would be for one of them.
+func (fw *fixedWindow) rollForward(ctx context.Context, key string, from time.Time, credit int64, start time.Time) (int64, error) {
+	var used int64
+	st, err := fw.store.Get(ctx, fw.counterKey(key, from))
+	switch {
+	case errors.Is(err, storage.ErrKeyNotFound):
+	case err != nil:
+		return 0, err
+	default:
+		used = st.Count
+	}
+
+	credit = min(max(fw.limit+credit-used, 0), fw.rollover)
+	for t := fw.next(from); t.Before(start) && credit < fw.rollover; t = fw.next(t) {
+		credit = min(credit+fw.limit, fw.rollover)
+	}
+	return credit, nil
+}
+
+// calendarWindow returns the start and end of the calendar period holding
+// t, in t's location. Weeks start on Monday. Periods follow the wall
+// clock, so a day with a daylight saving change is 23 or 25 hours long.
+func calendarWindow(period string, t time.Time) (time.Time, time.Time) {
+	year, month, day := t.Date()
+	loc := t.Location()
+
+	switch period {
+	case "week":
+		start := time.Date(year, month, day-(int(t.Weekday())+6)%7, 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 0, 7)
+	case "month":
+		start := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 1, 0)
+	case "year":
+		start := time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+		return start, start.AddDate(1, 0, 0)
+	default:
+		start := time.Date(year, month, day, 0, 0, 0, 0, loc)
+		return start, start.AddDate(0, 0, 1)
+	}
+}
+
+// state builds the public State for a window with count requests out of
+// limit.
+func (fw *fixedWindow) state(key string, count, limit int64, resetAt, now time.Time, limited bool) *State {
+	remaining := limit - count
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	var retryAfter time.Duration
+	if limited {
+		retryAfter = resetAt.Sub(now)
+	}
+
+	return &State{
+		Key:        key,
+		Limit:      limit,
+		Remaining:  remaining,
+		Current:    count,
+		ResetAt:    resetAt,
+		RetryAfter: retryAfter,
+		Algorithm:  string(FixedWindow),
+	}
+}
@@ -0,0 +1,247 @@
+package algorithm
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"math"
+	"time"
+
+	"github.com/Vipul984/flexlimit/clock"
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+var (
+	_ Algorithm = (*leakyBucket)(nil)
+	_ Queuer    = (*leakyBucket)(nil)
+	_ Refunder  = (*leakyBucket)(nil)
+)
+
+// leakyBucket implements the leaky bucket algorithm as a meter.
+//
+// Each request pours cost units into the bucket, which drains at Rate units
+// per Window. A request that would overflow the bucket is dropped. The
+// bucket holds BurstSize units, or 1 if BurstSize is 0, so by default
+// requests are spaced exactly Window/Rate apart with no bursts at all.
+//
+// With a QueueSize, the bucket also works as a queue: Enqueue lets the
+// level rise up to QueueSize units above capacity and tells each caller how
+// long to wait until its units drain below the rim. Queued units count
+// against the bucket, so Allow keeps rejecting requests while a queue is
+// waiting and callers can't jump it.
+//
+// With a DrainJitter, each key's waits and retry times are pushed back by
+// a fixed offset derived from the key, so keys whose queues filled at the
+// same instant release their requests spread over the jitter instead of
+// all at once. The offset only delays callers: units still drain on the
+// key's schedule.
+//
+// State is stored as storage.State{Tokens, LastRefill}, where Tokens is
+// the current water level and LastRefill the time it was last drained.
+type leakyBucket struct {
+	capacity  float64
+	queueSize float64
+	drainRate float64 // units per nanosecond
+	window    time.Duration
+	jitter    time.Duration
+
+	store storage.Storage
+	clock clock.Clock
+	locks keyLocks
+}
+
+// NewLeakyBucket creates a leaky bucket backed by store.
+func NewLeakyBucket(cfg Config, store storage.Storage, clk clock.Clock) (Algorithm, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	capacity := int64(1)
+	if cfg.BurstSize > 0 {
+		capacity = cfg.BurstSize
+	}
+
+	if clk == nil {
+		clk = clock.New()
+	}
+
+	return &leakyBucket{
+		capacity:  float64(capacity),
+		queueSize: float64(cfg.QueueSize),
+		drainRate: float64(cfg.Rate) / float64(cfg.Window),
+		window:    cfg.Window,
+		jitter:    cfg.DrainJitter,
+		store:     store,
+		clock:     clk,
+	}, nil
+}
+
+// Allow pours cost units into the bucket if they fit.
+func (lb *leakyBucket) Allow(ctx context.Context, key string, cost int) (bool, *State, error) {
+	unlock := lb.locks.lock(key)
+	defer unlock()
+
+	now := lb.clock.Now()
+	level, err := lb.load(ctx, key, now)
+	if err != nil {
+		return false, nil, err
+	}
+
+	if level+float64(cost) > lb.capacity {
+		return false, lb.state(key, level, float64(cost), now), nil
+	}
+
+	level += float64(cost)
+	if err := lb.save(ctx, key, level, now); err != nil {
+		return false, nil, err
+	}
+
+	return true, lb.state(key, level, 0, now), nil
+}
+
+// Enqueue pours cost units into the bucket if they fit in the bucket plus
+// its queue, and returns how long until they drain below the rim.
+func (lb *leakyBucket) Enqueue(ctx context.Context, key string, cost int) (time.Duration, bool, *State, error) {
+	unlock := lb.locks.lock(key)
+	defer unlock()
+
+	now := lb.clock.Now()
+	level, err := lb.load(ctx, key, now)
+	if err != nil {
+		return 0, false, nil, err
+	}
+
+	if level+float64(cost) > lb.capacity+lb.queueSize {
+		return 0, false, lb.state(key, level, float64(cost), now), nil
+	}
+
+	wait := lb.timeToDrain(level + float64(cost) - lb.capacity)
+	if wait > 0 {
+		wait += lb.offset(key)
+	}
+	level += float64(cost)
+	if err := lb.save(ctx, key, level, now); err != nil {
+		return 0, false, nil, err
+	}
+
+	return wait, true, lb.state(key, level, 0, now), nil
+}
+
+// Dequeue takes cost units back out of the bucket.
+func (lb *leakyBucket) Dequeue(ctx context.Context, key string, cost int) error {
+	unlock := lb.locks.lock(key)
+	defer unlock()
+
+	now := lb.clock.Now()
+	level, err := lb.load(ctx, key, now)
+	if err != nil {
+		return err
+	}
+	if level == 0 {
+		return nil
+	}
+
+	return lb.save(ctx, key, math.Max(0, level-float64(cost)), now)
+}
+
+// Refund takes cost units back out of the bucket, like Dequeue.
+func (lb *leakyBucket) Refund(ctx context.Context, key string, cost int) error {
+	return lb.Dequeue(ctx, key, cost)
+}
+
+// State returns the bucket's current level without adding to it.
+func (lb *leakyBucket) State(ctx context.Context, key string) (*State, error) {
+	now := lb.clock.Now()
+	level, err := lb.load(ctx, key, now)
+	if err != nil {
+		return nil, err
+	}
+	return lb.state(key, level, 1, now), nil
+}
+
+// Reset empties the bucket for key.
+func (lb *leakyBucket) Reset(ctx context.Context, key string) error {
+	return lb.store.Delete(ctx, key)
+}
+
+// Close is a no-op; the storage is owned by the caller.
+func (lb *leakyBucket) Close() error {
+	return nil
+}
+
+// save stores level for key, keeping it until it has fully drained.
+func (lb *leakyBucket) save(ctx context.Context, key string, level float64, now time.Time) error {
+	return lb.store.Set(ctx, key, &storage.State{
+		Tokens:     level,
+		LastRefill: now,
+	}, lb.timeToDrain(level)+lb.window)
+}
+
+// load returns the drained water level for key at now.
+func (lb *leakyBucket) load(ctx context.Context, key string, now time.Time) (float64, error) {
+	st, err := lb.store.Get(ctx, key)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	elapsed := now.Sub(st.LastRefill)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return math.Max(0, st.Tokens-float64(elapsed)*lb.drainRate), nil
+}
+
+// state builds the public State. need is the number of units a caller
+// wants to add; RetryAfter is how long until they fit.
+func (lb *leakyBucket) state(key string, level, need float64, now time.Time) *State {
+	limit := int64(lb.capacity)
+	remaining := int64(math.Floor(lb.capacity - level))
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	var retryAfter time.Duration
+	if overflow := level + need - lb.capacity; need > 0 && overflow > 0 {
+		retryAfter = lb.timeToDrain(overflow) + lb.offset(key)
+	}
+
+	return &State{
+		Key:        key,
+		Limit:      limit,
+		Remaining:  remaining,
+		Current:    limit - remaining,
+		ResetAt:    now.Add(lb.timeToDrain(level)),
+		RetryAfter: retryAfter,
+		Algorithm:  string(LeakyBucket),
+	}
+}
+
+// timeToDrain returns how long it takes to drain n units.
+func (lb *leakyBucket) timeToDrain(n float64) time.Duration {
+	if n <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(n / lb.drainRate))
+}
+
+// offset returns the delay DrainJitter adds to the releases of key.
+func (lb *leakyBucket) offset(key string) time.Duration {
+	if lb.jitter == 0 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+
+	// FNV leaves similar keys close together; mix the bits so offsets
+	// spread over the whole jitter (MurmurHash3's finalizer)
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return time.Duration(float64(lb.jitter) * float64(x) / (1 << 32))
+}
@@ -0,0 +1,31 @@
+package algorithm
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// lockShards is the number of mutexes keys are striped across.
+const lockShards = 256
+
+// keyLocks serializes read-modify-write cycles per key within a process.
+//
+// Keys are hashed onto a fixed set of mutexes so memory use is constant
+// no matter how many keys are seen. Two keys may share a mutex; that only
+// costs a little contention, never correctness.
+//
+// This protects local storage. Distributed backends need atomic
+// server-side operations in addition, since other processes don't share
+// these locks.
+type keyLocks struct {
+	shards [lockShards]sync.Mutex
+}
+
+// lock acquires the mutex for key and returns the function that releases it.
+func (l *keyLocks) lock(key string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	mu := &l.shards[h.Sum32()%lockShards]
+	mu.Lock()
+	return mu.Unlock
+}
@@ -0,0 +1,88 @@
+package algorithm
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/Vipul984/flexlimit/clock"
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+// Factory creates an algorithm for cfg over store, like New. cfg.Rate and
+// cfg.Window are positive; the other fields may be ignored.
+type Factory func(cfg Config, store storage.Storage, clk clock.Clock) (Algorithm, error)
+
+// registry holds the algorithms added with Register.
+var registry = struct {
+	sync.RWMutex
+	factories map[string]Factory
+}{factories: make(map[string]Factory)}
+
+// Register makes a custom algorithm available to New, and to
+// flexlimit.WithAlgorithm and configuration files, under name. Like
+// database/sql.Register, it is meant to be called from an init function,
+// and panics if name is empty or already taken, by a built-in algorithm or
+// an earlier Register, or if factory is nil.
+//
+// The factory is called whenever a limiter builds its algorithms: at
+// creation, for the local fallback and admin storage, and again on
+// SetLimit and storage migrations.
+//
+// Example:
+//
+//	func init() {
+//	    algorithm.Register("gcra", func(cfg algorithm.Config, store storage.Storage, clk clock.Clock) (algorithm.Algorithm, error) {
+//	        return newGCRA(cfg, store, clk), nil
+//	    })
+//	}
+func Register(name string, factory Factory) {
+	if name == "" {
+		panic("algorithm: Register with an empty name")
+	}
+	if factory == nil {
+		panic("algorithm: Register factory is nil for " + name)
+	}
+	if builtin(AlgorithmType(name)) {
+		panic("algorithm: Register called for built-in algorithm " + name)
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	if _, dup := registry.factories[name]; dup {
+		panic("algorithm: Register called twice for " + name)
+	}
+	registry.factories[name] = factory
+}
+
+// Lookup returns the factory registered under name.
+func Lookup(name string) (Factory, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	f, ok := registry.factories[name]
+	return f, ok
+}
+
+// Names returns the names of the built-in and registered algorithms,
+// sorted.
+func Names() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	names := []string{string(TokenBucket), string(FixedWindow), string(SlidingWindow), string(LeakyBucket), string(DecayedWindow)}
+	for name := range registry.factories {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// builtin reports whether a is one of the algorithms of this package.
+func builtin(a AlgorithmType) bool {
+	switch a {
+	case TokenBucket, FixedWindow, SlidingWindow, LeakyBucket, DecayedWindow:
+		return true
+	}
+	return false
+}
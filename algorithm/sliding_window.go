@@ -0,0 +1,230 @@
+package algorithm
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Vipul984/flexlimit/clock"
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+var (
+	_ Algorithm = (*slidingWindow)(nil)
+	_ Refunder  = (*slidingWindow)(nil)
+)
+
+// slidingWindow implements the sliding window log algorithm.
+//
+// Every allowed request's timestamp is kept in storage.State.Timestamps.
+// A request is allowed if fewer than Rate timestamps fall within the last
+// Window. This is exact, with no boundary bursts, at the cost of storing
+// up to Rate timestamps per key.
+//
+// If the store implements storage.SlidingLogStore (e.g., Redis), the whole
+// check-and-record cycle runs there atomically, so the window stays exact
+// across instances sharing the store.
+type slidingWindow struct {
+	limit  int64
+	window time.Duration
+
+	store storage.Storage
+	clock clock.Clock
+	locks keyLocks
+}
+
+// NewSlidingWindow creates a sliding window backed by store: a log of
+// timestamps, or sub-window counters if cfg.Buckets is set.
+func NewSlidingWindow(cfg Config, store storage.Storage, clk clock.Clock) (Algorithm, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	if clk == nil {
+		clk = clock.New()
+	}
+
+	if cfg.Buckets > 0 {
+		return newBucketedSlidingWindow(cfg, store, clk), nil
+	}
+
+	return &slidingWindow{
+		limit:  cfg.Rate,
+		window: cfg.Window,
+		store:  store,
+		clock:  clk,
+	}, nil
+}
+
+// Allow records cost requests if they fit within the window.
+func (sw *slidingWindow) Allow(ctx context.Context, key string, cost int) (bool, *State, error) {
+	now := sw.clock.Now()
+	if res, ok, err := sw.take(ctx, key, int64(cost), int64(cost), now); ok {
+		if err != nil {
+			return false, nil, err
+		}
+		if res.Allowed {
+			cost = 0
+		}
+		return res.Allowed, sw.newState(key, res.Count, res.Newest, res.Expiring, cost, now), nil
+	}
+
+	unlock := sw.locks.lock(key)
+	defer unlock()
+
+	timestamps, err := sw.load(ctx, key, now)
+	if err != nil {
+		return false, nil, err
+	}
+
+	if int64(len(timestamps)+cost) > sw.limit {
+		return false, sw.state(key, timestamps, cost, now), nil
+	}
+
+	for i := 0; i < cost; i++ {
+		timestamps = append(timestamps, now)
+	}
+
+	err = sw.store.Set(ctx, key, &storage.State{Timestamps: timestamps}, sw.window)
+	if err != nil {
+		return false, nil, err
+	}
+
+	return true, sw.state(key, timestamps, 0, now), nil
+}
+
+// State returns the window's usage without recording a request.
+func (sw *slidingWindow) State(ctx context.Context, key string) (*State, error) {
+	now := sw.clock.Now()
+	if res, ok, err := sw.take(ctx, key, 0, 1, now); ok {
+		if err != nil {
+			return nil, err
+		}
+		return sw.newState(key, res.Count, res.Newest, res.Expiring, 1, now), nil
+	}
+
+	timestamps, err := sw.load(ctx, key, now)
+	if err != nil {
+		return nil, err
+	}
+	return sw.state(key, timestamps, 1, now), nil
+}
+
+// Refund removes the cost most recent requests recorded for key.
+func (sw *slidingWindow) Refund(ctx context.Context, key string, cost int) error {
+	now := sw.clock.Now()
+	if _, ok, err := sw.take(ctx, key, -int64(cost), 0, now); ok {
+		return err
+	}
+
+	unlock := sw.locks.lock(key)
+	defer unlock()
+
+	timestamps, err := sw.load(ctx, key, now)
+	if err != nil || len(timestamps) == 0 {
+		return err
+	}
+
+	timestamps = timestamps[:max(len(timestamps)-cost, 0)]
+	return sw.store.Set(ctx, key, &storage.State{Timestamps: timestamps}, sw.window)
+}
+
+// Reset clears all recorded requests for key.
+func (sw *slidingWindow) Reset(ctx context.Context, key string) error {
+	return sw.store.Delete(ctx, key)
+}
+
+// Close is a no-op; the storage is owned by the caller.
+func (sw *slidingWindow) Close() error {
+	return nil
+}
+
+// take runs the operation atomically in the store if it supports it.
+// ok is false if the caller must fall back to load and Set.
+func (sw *slidingWindow) take(ctx context.Context, key string, cost, need int64, now time.Time) (storage.SlidingLogResult, bool, error) {
+	sls, ok := sw.store.(storage.SlidingLogStore)
+	if !ok {
+		return storage.SlidingLogResult{}, false, nil
+	}
+
+	res, err := sls.TakeSlots(ctx, key, storage.SlidingLogRequest{
+		Limit:  sw.limit,
+		Window: sw.window,
+		Cost:   cost,
+		Need:   need,
+		Now:    now,
+	})
+	if errors.Is(err, storage.ErrNotSupported) {
+		return storage.SlidingLogResult{}, false, nil
+	}
+	return res, true, err
+}
+
+// load returns the timestamps for key that are still inside the window.
+func (sw *slidingWindow) load(ctx context.Context, key string, now time.Time) ([]time.Time, error) {
+	st, err := sw.store.Get(ctx, key)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := now.Add(-sw.window)
+	i := 0
+	for i < len(st.Timestamps) && !st.Timestamps[i].After(cutoff) {
+		i++
+	}
+	return st.Timestamps[i:], nil
+}
+
+// state builds the public State from the timestamps within the window.
+// need is the number of requests a caller wants to make.
+func (sw *slidingWindow) state(key string, timestamps []time.Time, need int, now time.Time) *State {
+	used := int64(len(timestamps))
+
+	var newest, expiring time.Time
+	if used > 0 {
+		newest = timestamps[used-1]
+	}
+	if excess := used + int64(need) - sw.limit; need > 0 && excess > 0 && excess <= used {
+		expiring = timestamps[excess-1]
+	}
+	return sw.newState(key, used, newest, expiring, need, now)
+}
+
+// newState builds the public State of a window holding used requests, the
+// most recent at newest. need is the number of requests a caller wants to
+// make, and expiring the request whose expiry makes room for them;
+// RetryAfter is how long until then.
+func (sw *slidingWindow) newState(key string, used int64, newest, expiring time.Time, need int, now time.Time) *State {
+	remaining := sw.limit - used
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	resetAt := now
+	if !newest.IsZero() {
+		resetAt = newest.Add(sw.window)
+	}
+
+	var retryAfter time.Duration
+	if excess := used + int64(need) - sw.limit; need > 0 && excess > 0 {
+		if !expiring.IsZero() {
+			retryAfter = expiring.Add(sw.window).Sub(now)
+		} else {
+			// The request is larger than the limit and can never fit.
+			retryAfter = sw.window
+		}
+	}
+
+	return &State{
+		Key:        key,
+		Limit:      sw.limit,
+		Remaining:  remaining,
+		Current:    used,
+		ResetAt:    resetAt,
+		RetryAfter: retryAfter,
+		Algorithm:  string(SlidingWindow),
+	}
+}
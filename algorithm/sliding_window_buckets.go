@@ -0,0 +1,234 @@
+package algorithm
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/Vipul984/flexlimit/clock"
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+var (
+	_ Algorithm    = (*bucketedSlidingWindow)(nil)
+	_ KeyMapper    = (*bucketedSlidingWindow)(nil)
+	_ KeyPatterner = (*bucketedSlidingWindow)(nil)
+	_ Refunder     = (*bucketedSlidingWindow)(nil)
+)
+
+// bucketedSlidingWindow implements a sliding window over sub-window
+// counters.
+//
+// The window is split into Buckets sub-windows aligned to the Unix epoch,
+// each counted under its own key ("<key>:<bucket index>") like a fixed
+// window. The usage of a key is the sum of the buckets inside the window
+// plus the bucket that is sliding out of it, weighted by how much of it
+// still overlaps the window. Memory per key is bounded by the number of
+// buckets instead of the rate, and the count is off by at most one
+// bucket's share of the window.
+//
+// Counting uses storage.Incr with rollback, so like fixedWindow it is safe
+// across processes sharing the storage.
+//
+// On stores using hash tags (see storage.HashTagStore), the buckets of a
+// key are stored as "{<key>}:<bucket index>", so reading them all is one
+// round trip to one node of a cluster.
+type bucketedSlidingWindow struct {
+	limit   int64
+	buckets int64
+	width   time.Duration // duration of one bucket
+
+	store    storage.Storage
+	clock    clock.Clock
+	hashTags bool
+}
+
+// newBucketedSlidingWindow creates a bucketed sliding window from a
+// validated config.
+func newBucketedSlidingWindow(cfg Config, store storage.Storage, clk clock.Clock) *bucketedSlidingWindow {
+	return &bucketedSlidingWindow{
+		limit:    cfg.Rate,
+		buckets:  cfg.Buckets,
+		width:    cfg.Window / time.Duration(cfg.Buckets),
+		store:    store,
+		clock:    clk,
+		hashTags: storage.UsesHashTags(store),
+	}
+}
+
+// Allow counts cost requests in the current bucket if the window has room.
+func (bw *bucketedSlidingWindow) Allow(ctx context.Context, key string, cost int) (bool, *State, error) {
+	now := bw.clock.Now()
+	current := bw.index(now)
+
+	previous, err := bw.previous(ctx, key, current, now)
+	if err != nil {
+		return false, nil, err
+	}
+
+	currentKey := bw.bucketKey(key, current)
+	ttl := bw.expiry(current).Sub(now)
+	count, err := bw.store.Incr(ctx, currentKey, int64(cost), ttl)
+	if err != nil {
+		return false, nil, err
+	}
+
+	if previous+float64(count) > float64(bw.limit) {
+		count, err = bw.store.Incr(ctx, currentKey, -int64(cost), ttl)
+		if err != nil {
+			return false, nil, err
+		}
+		return false, bw.state(key, previous+float64(count), current, now, true), nil
+	}
+
+	return true, bw.state(key, previous+float64(count), current, now, false), nil
+}
+
+// State returns the window's usage without counting a request.
+func (bw *bucketedSlidingWindow) State(ctx context.Context, key string) (*State, error) {
+	now := bw.clock.Now()
+	current := bw.index(now)
+
+	counts, err := bw.counts(ctx, key, current-bw.buckets, current)
+	if err != nil {
+		return nil, err
+	}
+
+	used := bw.weighted(counts, current, now)
+	return bw.state(key, used, current, now, used >= float64(bw.limit)), nil
+}
+
+// Refund takes cost requests back out of the buckets of the window, newest
+// first.
+func (bw *bucketedSlidingWindow) Refund(ctx context.Context, key string, cost int) error {
+	now := bw.clock.Now()
+	current := bw.index(now)
+
+	left := int64(cost)
+	for i := current; i >= current-bw.buckets && left > 0; i-- {
+		var err error
+		left, err = refundCounter(ctx, bw.store, bw.bucketKey(key, i), left, bw.expiry(i).Sub(now))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reset clears every bucket of the window for key.
+func (bw *bucketedSlidingWindow) Reset(ctx context.Context, key string) error {
+	current := bw.index(bw.clock.Now())
+	for i := current - bw.buckets; i <= current; i++ {
+		if err := bw.store.Delete(ctx, bw.bucketKey(key, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close is a no-op; the storage is owned by the caller.
+func (bw *bucketedSlidingWindow) Close() error {
+	return nil
+}
+
+// KeyOf strips the bucket index, and the hash tag, from a counter key.
+func (bw *bucketedSlidingWindow) KeyOf(storageKey string) (string, bool) {
+	key, ok := counterKeyOf(storageKey)
+	if !ok || !bw.hashTags {
+		return key, ok
+	}
+	return storage.CutHashTag(key)
+}
+
+// KeyPattern matches the counter keys of the keys starting with prefix.
+func (bw *bucketedSlidingWindow) KeyPattern(prefix string) string {
+	if bw.hashTags {
+		return "{" + prefix + "*"
+	}
+	return prefix + "*"
+}
+
+// previous returns the weighted count of the buckets before current.
+func (bw *bucketedSlidingWindow) previous(ctx context.Context, key string, current int64, now time.Time) (float64, error) {
+	counts, err := bw.counts(ctx, key, current-bw.buckets, current-1)
+	if err != nil {
+		return 0, err
+	}
+	return bw.weighted(append(counts, 0), current, now), nil
+}
+
+// counts reads the buckets from first to last, inclusive.
+func (bw *bucketedSlidingWindow) counts(ctx context.Context, key string, first, last int64) ([]int64, error) {
+	keys := make([]string, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		keys = append(keys, bw.bucketKey(key, i))
+	}
+
+	states, err := bw.store.GetMulti(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make([]int64, len(keys))
+	for i, st := range states {
+		if st != nil {
+			counts[i] = st.Count
+		}
+	}
+	return counts, nil
+}
+
+// weighted sums the counts of buckets current-Buckets through current,
+// counting the oldest one only for the part still inside the window.
+func (bw *bucketedSlidingWindow) weighted(counts []int64, current int64, now time.Time) float64 {
+	elapsed := now.Sub(time.Unix(0, current*int64(bw.width)))
+	overlap := 1 - float64(elapsed)/float64(bw.width)
+
+	sum := float64(counts[0]) * overlap
+	for _, c := range counts[1:] {
+		sum += float64(c)
+	}
+	return sum
+}
+
+// index returns the bucket containing t.
+func (bw *bucketedSlidingWindow) index(t time.Time) int64 {
+	return t.UnixNano() / int64(bw.width)
+}
+
+// expiry returns when bucket i has fully slid out of the window.
+func (bw *bucketedSlidingWindow) expiry(i int64) time.Time {
+	return time.Unix(0, (i+bw.buckets+1)*int64(bw.width))
+}
+
+// bucketKey returns the counter key of bucket i.
+func (bw *bucketedSlidingWindow) bucketKey(key string, i int64) string {
+	if bw.hashTags {
+		key = storage.HashTag(key)
+	}
+	return key + ":" + strconv.FormatInt(i, 10)
+}
+
+// state builds the public State for a window with used requests.
+// RetryAfter is the time until the next bucket starts, when the oldest
+// bucket's share decays to zero.
+func (bw *bucketedSlidingWindow) state(key string, used float64, current int64, now time.Time, limited bool) *State {
+	count := int64(math.Ceil(used))
+	remaining := max(bw.limit-count, 0)
+
+	var retryAfter time.Duration
+	if limited {
+		retryAfter = time.Unix(0, (current+1)*int64(bw.width)).Sub(now)
+	}
+
+	return &State{
+		Key:        key,
+		Limit:      bw.limit,
+		Remaining:  remaining,
+		Current:    count,
+		ResetAt:    bw.expiry(current),
+		RetryAfter: retryAfter,
+		Algorithm:  string(SlidingWindow),
+	}
+}
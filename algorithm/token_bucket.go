@@ -0,0 +1,272 @@
+package algorithm
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/Vipul984/flexlimit/clock"
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+var (
+	_ Algorithm = (*tokenBucket)(nil)
+	_ Refunder  = (*tokenBucket)(nil)
+	_ Batcher   = (*tokenBucket)(nil)
+)
+
+// tokenBucket implements the token bucket algorithm.
+//
+// The bucket holds up to capacity tokens and refills continuously at
+// Rate tokens per Window. Each request consumes cost tokens. Capacity is
+// BurstSize if set, otherwise Rate.
+//
+// State is stored as storage.State{Tokens, LastRefill}. Refilling is a pure
+// function of elapsed time, so denied requests don't write anything.
+//
+// If the store implements storage.TokenBucketStore (e.g., Redis), the whole
+// refill-and-consume cycle runs atomically in the backend, which keeps
+// concurrent instances from racing on the same key.
+type tokenBucket struct {
+	capacity   float64
+	refillRate float64 // tokens per nanosecond
+	window     time.Duration
+
+	store storage.Storage
+	clock clock.Clock
+	locks keyLocks
+}
+
+// NewTokenBucket creates a token bucket backed by store.
+func NewTokenBucket(cfg Config, store storage.Storage, clk clock.Clock) (Algorithm, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	capacity := cfg.Rate
+	if cfg.BurstSize > 0 {
+		capacity = cfg.BurstSize
+	}
+
+	if clk == nil {
+		clk = clock.New()
+	}
+
+	return &tokenBucket{
+		capacity:   float64(capacity),
+		refillRate: float64(cfg.Rate) / float64(cfg.Window),
+		window:     cfg.Window,
+		store:      store,
+		clock:      clk,
+	}, nil
+}
+
+// Allow consumes cost tokens if enough are available.
+func (tb *tokenBucket) Allow(ctx context.Context, key string, cost int) (bool, *State, error) {
+	now := tb.clock.Now()
+
+	if res, ok, err := tb.take(ctx, key, float64(cost), now); ok {
+		if err != nil {
+			return false, nil, err
+		}
+		if !res.Allowed {
+			return false, tb.state(key, res.Tokens, float64(cost), now), nil
+		}
+		return true, tb.state(key, res.Tokens, 0, now), nil
+	}
+
+	unlock := tb.locks.lock(key)
+	defer unlock()
+
+	tokens, err := tb.load(ctx, key, now)
+	if err != nil {
+		return false, nil, err
+	}
+
+	need := float64(cost)
+	if tokens < need {
+		return false, tb.state(key, tokens, need, now), nil
+	}
+
+	tokens -= need
+	err = tb.store.Set(ctx, key, &storage.State{
+		Tokens:     tokens,
+		LastRefill: now,
+	}, tb.ttl(tokens))
+	if err != nil {
+		return false, nil, err
+	}
+
+	return true, tb.state(key, tokens, 0, now), nil
+}
+
+// AllowBatch decides every request in one round trip if the store
+// implements storage.TokenBucketBatchStore, and one by one otherwise.
+func (tb *tokenBucket) AllowBatch(ctx context.Context, reqs []BatchRequest) ([]BatchResult, error) {
+	now := tb.clock.Now()
+	results := make([]BatchResult, len(reqs))
+
+	if bs, ok := tb.store.(storage.TokenBucketBatchStore); ok {
+		keys := make([]string, len(reqs))
+		tbReqs := make([]storage.TokenBucketRequest, len(reqs))
+		for i, req := range reqs {
+			keys[i] = req.Key
+			tbReqs[i] = tb.request(float64(req.Cost), now)
+		}
+
+		res, err := bs.TakeTokensMulti(ctx, keys, tbReqs)
+		if err == nil {
+			for i, r := range res {
+				need := 0.0
+				if !r.Allowed {
+					need = float64(reqs[i].Cost)
+				}
+				results[i] = BatchResult{Allowed: r.Allowed, State: tb.state(keys[i], r.Tokens, need, now)}
+			}
+			return results, nil
+		}
+		if !errors.Is(err, storage.ErrNotSupported) {
+			return nil, err
+		}
+	}
+
+	for i, req := range reqs {
+		allowed, st, err := tb.Allow(ctx, req.Key, req.Cost)
+		if err != nil {
+			return nil, err
+		}
+		results[i] = BatchResult{Allowed: allowed, State: st}
+	}
+	return results, nil
+}
+
+// State returns the bucket's current state without consuming tokens.
+func (tb *tokenBucket) State(ctx context.Context, key string) (*State, error) {
+	now := tb.clock.Now()
+
+	if res, ok, err := tb.take(ctx, key, 0, now); ok {
+		if err != nil {
+			return nil, err
+		}
+		return tb.state(key, res.Tokens, 1, now), nil
+	}
+
+	tokens, err := tb.load(ctx, key, now)
+	if err != nil {
+		return nil, err
+	}
+	return tb.state(key, tokens, 1, now), nil
+}
+
+// Refund puts cost tokens back into the bucket, up to its capacity.
+func (tb *tokenBucket) Refund(ctx context.Context, key string, cost int) error {
+	now := tb.clock.Now()
+
+	if _, ok, err := tb.take(ctx, key, -float64(cost), now); ok {
+		return err
+	}
+
+	unlock := tb.locks.lock(key)
+	defer unlock()
+
+	tokens, err := tb.load(ctx, key, now)
+	if err != nil {
+		return err
+	}
+
+	tokens = math.Min(tb.capacity, tokens+float64(cost))
+	return tb.store.Set(ctx, key, &storage.State{
+		Tokens:     tokens,
+		LastRefill: now,
+	}, tb.ttl(tokens))
+}
+
+// Reset refills the bucket for key.
+func (tb *tokenBucket) Reset(ctx context.Context, key string) error {
+	return tb.store.Delete(ctx, key)
+}
+
+// Close is a no-op; the storage is owned by the caller.
+func (tb *tokenBucket) Close() error {
+	return nil
+}
+
+// take runs the operation atomically in the store if it supports it.
+// ok is false if the caller must fall back to load and Set.
+func (tb *tokenBucket) take(ctx context.Context, key string, cost float64, now time.Time) (storage.TokenBucketResult, bool, error) {
+	tbs, ok := tb.store.(storage.TokenBucketStore)
+	if !ok {
+		return storage.TokenBucketResult{}, false, nil
+	}
+
+	res, err := tbs.TakeTokens(ctx, key, tb.request(cost, now))
+	if errors.Is(err, storage.ErrNotSupported) {
+		return storage.TokenBucketResult{}, false, nil
+	}
+	return res, true, err
+}
+
+// request builds the storage request for an operation costing cost.
+func (tb *tokenBucket) request(cost float64, now time.Time) storage.TokenBucketRequest {
+	return storage.TokenBucketRequest{
+		Capacity:   tb.capacity,
+		RefillRate: tb.refillRate * float64(time.Second),
+		Cost:       cost,
+		Now:        now,
+		TTL:        tb.ttl(0),
+	}
+}
+
+// load returns the refilled token count for key at now.
+func (tb *tokenBucket) load(ctx context.Context, key string, now time.Time) (float64, error) {
+	st, err := tb.store.Get(ctx, key)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return tb.capacity, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	elapsed := now.Sub(st.LastRefill)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return math.Min(tb.capacity, st.Tokens+float64(elapsed)*tb.refillRate), nil
+}
+
+// state builds the public State. need is the token count a caller is
+// waiting for; RetryAfter is how long until that many are available.
+func (tb *tokenBucket) state(key string, tokens, need float64, now time.Time) *State {
+	remaining := int64(math.Floor(tokens))
+	limit := int64(tb.capacity)
+
+	var retryAfter time.Duration
+	if need > tokens {
+		retryAfter = tb.timeToRefill(need - tokens)
+	}
+
+	return &State{
+		Key:        key,
+		Limit:      limit,
+		Remaining:  remaining,
+		Current:    limit - remaining,
+		ResetAt:    now.Add(tb.timeToRefill(tb.capacity - tokens)),
+		RetryAfter: retryAfter,
+		Algorithm:  string(TokenBucket),
+	}
+}
+
+// ttl is how long state must be kept: once the bucket is full again a
+// missing key is equivalent, so there is no need to keep it longer.
+func (tb *tokenBucket) ttl(tokens float64) time.Duration {
+	return tb.timeToRefill(tb.capacity-tokens) + tb.window
+}
+
+// timeToRefill returns how long it takes to refill n tokens.
+func (tb *tokenBucket) timeToRefill(n float64) time.Duration {
+	if n <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(n / tb.refillRate))
+}
@@ -0,0 +1,248 @@
+package flexlimit
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Vipul984/flexlimit/algorithm"
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+// backend bundles an algorithm with the storage it runs on.
+//
+// A limiter swaps its whole backend at once (e.g., when migrating storage),
+// so the algorithm and its storage never get out of step.
+type backend struct {
+	algo  algorithm.Algorithm
+	store storage.Storage
+
+	// ownsStore is true if the limiter created store and must close it
+	ownsStore bool
+
+	// ownsAlgorithms is false for an algorithm instance passed to
+	// WithAlgorithm, which the limiter must not close
+	ownsAlgorithms bool
+
+	// failover and local are set only for the LocalMemory fallback strategy
+	failover *storage.Failover
+	local    algorithm.Algorithm
+
+	// admin and adminStore serve admin operations (Keys, Reset, States).
+	// They are algo and store unless WithAdminStorage is set.
+	admin      algorithm.Algorithm
+	adminStore storage.Storage
+}
+
+// newBackend builds the algorithms for the limiter's configuration over
+// store, with admin operations going to adminStore if it is not nil. If
+// store is nil, an in-memory store owned by the backend is created: a
+// lock-free one for the token bucket algorithm.
+func (l *Limiter) newBackend(store, adminStore storage.Storage) (*backend, error) {
+	b := &backend{store: l.namespaced(store)}
+	if b.store == nil {
+		b.store = l.newOwnedStore()
+		b.ownsStore = true
+	} else if l.opts.cacheStaleness > 0 {
+		b.store = l.cached(b.store)
+		b.ownsStore = true
+	} else if l.opts.leaseSize > 0 {
+		b.store = l.leased(b.store)
+		b.ownsStore = true
+	}
+
+	if FallbackStrategy(l.opts.fallbackStrategy) == LocalMemory {
+		l.setupLocalFallback(b)
+	}
+
+	b.adminStore = b.store
+	if adminStore != nil {
+		b.adminStore = l.namespaced(adminStore)
+	}
+
+	if err := l.initAlgorithms(b, l.rate); err != nil {
+		b.closeStore()
+		return nil, err
+	}
+	return b, nil
+}
+
+// namespaced returns s keeping its keys under the limiter's key prefix, if
+// any (see WithKeyPrefix).
+func (l *Limiter) namespaced(s storage.Storage) storage.Storage {
+	if s == nil || l.opts.keyPrefix == "" {
+		return s
+	}
+	return storage.NewPrefixed(s, l.opts.keyPrefix)
+}
+
+// setupLocalFallback wraps the backend's store in a Failover whose
+// secondary holds the local state used while the primary is unavailable.
+func (l *Limiter) setupLocalFallback(b *backend) {
+	primary := b.store
+	if !b.ownsStore {
+		primary = unownedStorage{primary}
+	}
+
+	b.failover = storage.NewFailover(primary, l.newMemoryStore(), storage.FailoverConfig{
+		OnFailover: l.failedOver,
+		OnRecover:  l.recovered,
+	})
+	b.store = b.failover
+	b.ownsStore = true
+}
+
+// initAlgorithms creates the backend's algorithms for rate over its
+// stores. The local fallback algorithm gets the scaled local rate. An
+// algorithm instance passed to WithAlgorithm serves every role instead.
+func (l *Limiter) initAlgorithms(b *backend, rate int) error {
+	if algo := l.opts.algorithmInstance; algo != nil {
+		b.algo, b.admin, b.ownsAlgorithms = algo, algo, false
+		if b.failover != nil {
+			b.local = algo
+		}
+		return nil
+	}
+	b.ownsAlgorithms = true
+
+	algo, err := algorithm.New(l.algorithmConfig(rate), b.store, l.clock)
+	if err != nil {
+		return err
+	}
+	b.algo, b.admin = algo, algo
+
+	if b.failover != nil {
+		local, err := algorithm.New(l.algorithmConfig(l.localRate(rate)), b.failover.Secondary(), l.clock)
+		if err != nil {
+			return err
+		}
+		b.local = local
+	}
+
+	if b.adminStore != b.store {
+		admin, err := algorithm.New(l.algorithmConfig(rate), b.adminStore, l.clock)
+		if err != nil {
+			return err
+		}
+		b.admin = admin
+	}
+	return nil
+}
+
+// localRate returns the rate used by the local fallback for a limiter
+// rate, scaled down by the local fallback scale but never below 1.
+func (l *Limiter) localRate(rate int) int {
+	return max(rate/l.opts.localFallbackScale, 1)
+}
+
+// active returns the algorithm that should serve the next call.
+func (b *backend) active() algorithm.Algorithm {
+	if b.failover != nil && b.failover.Degraded() {
+		return b.local
+	}
+	return b.algo
+}
+
+// reset clears key through primary (the main or the admin algorithm) and
+// in the local algorithm.
+func (b *backend) reset(ctx context.Context, key string, primary algorithm.Algorithm) error {
+	err := primary.Reset(ctx, key)
+	if b.local != nil {
+		err = errors.Join(err, b.local.Reset(ctx, key))
+	}
+	return err
+}
+
+// close releases the algorithms and the store if the backend owns it.
+// An admin store is owned by the caller and left open.
+func (b *backend) close() error {
+	return errors.Join(b.closeAlgorithms(), b.closeStore())
+}
+
+// flush writes back what the limiter's storage wrappers hold for the
+// store: the consumption buffered by a Cached store and the tokens leased
+// by a Leased one.
+func (b *backend) flush(ctx context.Context) error {
+	for s := b.store; ; {
+		switch w := s.(type) {
+		case *storage.Cached:
+			return w.Flush(ctx)
+		case *storage.Leased:
+			return w.Return(ctx)
+		case *storage.Failover:
+			s = w.Primary()
+		case *storage.Prefixed:
+			s = w.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// closeAlgorithms releases the backend's algorithms.
+func (b *backend) closeAlgorithms() error {
+	if !b.ownsAlgorithms {
+		return nil
+	}
+	err := b.algo.Close()
+	if b.local != nil {
+		err = errors.Join(err, b.local.Close())
+	}
+	if b.admin != b.algo {
+		err = errors.Join(err, b.admin.Close())
+	}
+	return err
+}
+
+// closeStore closes the store if the backend owns it.
+func (b *backend) closeStore() error {
+	if !b.ownsStore {
+		return nil
+	}
+	return b.store.Close()
+}
+
+// unownedStorage shields a caller-owned storage from Close, so wrapping it
+// (e.g., in a Failover) doesn't close something the limiter doesn't own.
+type unownedStorage struct {
+	storage.Storage
+}
+
+// Close does nothing.
+func (unownedStorage) Close() error {
+	return nil
+}
+
+// Unwrap returns the wrapped storage.
+func (u unownedStorage) Unwrap() storage.Storage {
+	return u.Storage
+}
+
+// TakeTokens forwards to the wrapped storage if it supports atomic token
+// bucket operations.
+func (u unownedStorage) TakeTokens(ctx context.Context, key string, req storage.TokenBucketRequest) (storage.TokenBucketResult, error) {
+	tbs, ok := u.Storage.(storage.TokenBucketStore)
+	if !ok {
+		return storage.TokenBucketResult{}, storage.ErrNotSupported
+	}
+	return tbs.TakeTokens(ctx, key, req)
+}
+
+// TakeTokensMulti forwards to the wrapped storage if it supports batched
+// token bucket operations.
+func (u unownedStorage) TakeTokensMulti(ctx context.Context, keys []string, reqs []storage.TokenBucketRequest) ([]storage.TokenBucketResult, error) {
+	tbs, ok := u.Storage.(storage.TokenBucketBatchStore)
+	if !ok {
+		return nil, storage.ErrNotSupported
+	}
+	return tbs.TakeTokensMulti(ctx, keys, reqs)
+}
+
+// TakeSlots forwards to the wrapped storage if it supports atomic sliding
+// window log operations.
+func (u unownedStorage) TakeSlots(ctx context.Context, key string, req storage.SlidingLogRequest) (storage.SlidingLogResult, error) {
+	sls, ok := u.Storage.(storage.SlidingLogStore)
+	if !ok {
+		return storage.SlidingLogResult{}, storage.ErrNotSupported
+	}
+	return sls.TakeSlots(ctx, key, req)
+}
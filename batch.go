@@ -0,0 +1,154 @@
+package flexlimit
+
+import (
+	"context"
+
+	"github.com/Vipul984/flexlimit/algorithm"
+)
+
+// AllowRequest is one request of an AllowMulti call.
+type AllowRequest struct {
+	// Key is the rate limit key
+	Key string
+
+	// N is the number of tokens the request costs. Default: 1
+	N int
+}
+
+// Decision is the outcome of one AllowRequest.
+type Decision struct {
+	// Key is the rate limit key of the request
+	Key string
+
+	// Allowed reports whether the request is allowed
+	Allowed bool
+
+	// State is the key's state after the request. It is nil if the
+	// fallback strategy made the decision
+	State *State
+}
+
+// AllowMulti decides several requests at once, for gateways that check
+// dozens of limits per incoming request. It returns one Decision per
+// request, in the same order.
+//
+// With the token bucket algorithm, all requests are decided in a single
+// storage round trip: one pipeline to Redis, one lock pass over the memory
+// store. Other algorithms and storages decide them one by one, with the
+// same results.
+//
+// Requests are independent: AllowMulti consumes tokens for each allowed
+// request even if others are denied. To require every limit to pass, use
+// a Composite or a Hierarchy.
+//
+// Returns an *InvalidConfigError if a request has a negative N, and
+// ErrLimiterClosed or a context error as AllowN does.
+//
+// Example:
+//
+//	decisions, err := limiter.AllowMulti(ctx, []flexlimit.AllowRequest{
+//	    {Key: "ip:" + ip},
+//	    {Key: "user:" + userID},
+//	    {Key: "route:" + route, N: 5},
+//	})
+func (l *Limiter) AllowMulti(ctx context.Context, reqs []AllowRequest) ([]Decision, error) {
+	if err := l.checkCall(ctx, 1); err != nil {
+		return nil, err
+	}
+
+	batch := make([]algorithm.BatchRequest, len(reqs))
+	for i, req := range reqs {
+		n := req.N
+		if n == 0 {
+			n = 1
+		}
+		if n < 0 {
+			return nil, &InvalidConfigError{Field: "cost", Value: n, Reason: "must be positive"}
+		}
+		batch[i] = algorithm.BatchRequest{Key: l.HashKey(req.Key), Cost: n}
+	}
+
+	decisions, ok, err := l.allowBatch(ctx, batch)
+	if ok || err != nil {
+		return decisions, err
+	}
+
+	decisions = make([]Decision, len(batch))
+	for i, req := range batch {
+		allowed, st, err := l.allowN(ctx, reqs[i].Key, req.Cost)
+		if err != nil {
+			return nil, err
+		}
+		decisions[i] = l.decision(req.Key, allowed, st)
+	}
+	return decisions, nil
+}
+
+// allowBatch decides reqs in one call to the algorithm, leaving them to
+// the fallback strategy if the call fails. ok is false if the algorithm
+// can't batch, and the caller must decide the requests one by one.
+func (l *Limiter) allowBatch(ctx context.Context, reqs []algorithm.BatchRequest) (decisions []Decision, ok bool, err error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	// Lifecycle records, adaptive rates, warm-ups, decay, priority reserves, load
+	// shedding, key lists and suspensions are applied per request, and a latency budget bounds each request rather than the
+	// batch
+	b, ok := l.be.active().(algorithm.Batcher)
+	if !ok || l.lifecycle != nil || l.adaptive != nil || l.warmup != nil || l.opts.decay != nil || l.opts.priority != nil || l.opts.shedder != nil ||
+		l.opts.allowlist != nil || l.opts.denylist != nil || l.suspended() || l.opts.latencyBudget > 0 {
+		return nil, false, nil
+	}
+
+	start := l.clock.Now()
+	if l.advisor != nil {
+		for _, req := range reqs {
+			l.advisor.observe(req.Key, req.Cost, start)
+		}
+	}
+	if l.regions != nil {
+		for _, req := range reqs {
+			l.regions.demand.Add(int64(req.Cost))
+		}
+	}
+
+	decisions = make([]Decision, len(reqs))
+	if !l.storageGate.allow(start) {
+		l.selfLimited(ctx, "storage_ops")
+		for i, req := range reqs {
+			allowed := l.shadowed(l.degrade(ctx, req.Key, req.Cost, ErrSelfLimited))
+			decisions[i] = Decision{Key: req.Key, Allowed: allowed}
+		}
+		return decisions, true, nil
+	}
+
+	results, err := b.AllowBatch(ctx, reqs)
+	if err != nil && ctx.Err() != nil {
+		return nil, false, wrapContextError(ctx.Err())
+	}
+	if err != nil {
+		// Part of the batch may have been applied, so deciding the
+		// requests again would charge them twice
+		for i, req := range reqs {
+			allowed := l.shadowed(l.fallback(ctx, req.Key, req.Cost, err))
+			decisions[i] = Decision{Key: req.Key, Allowed: allowed}
+		}
+		return decisions, true, nil
+	}
+
+	for i, req := range reqs {
+		res := results[i]
+		allowed := l.decide(ctx, req.Key, req.Cost, res.Allowed, deniedByLimit, res.State, start, nil)
+		decisions[i] = l.decision(req.Key, allowed, res.State)
+	}
+	return decisions, true, nil
+}
+
+// decision builds the Decision on a request for key.
+func (l *Limiter) decision(key string, allowed bool, st *algorithm.State) Decision {
+	d := Decision{Key: key, Allowed: allowed}
+	if st != nil {
+		d.State = l.newState(st, l.clock.Now())
+	}
+	return d
+}
@@ -0,0 +1,299 @@
+// Package benchmarks measures the limiter's throughput and allocations
+// for each algorithm and storage backend, under configurable concurrency,
+// key cardinality and share of requests over the limit, so performance
+// claims can be checked and regressions caught between releases.
+//
+// Run executes one Scenario with testing.Benchmark; Matrix builds the
+// scenarios of every combination of settings. Results can be saved as
+// JSON and compared with a baseline by Compare. The flexlimitbench
+// command drives all three.
+//
+// Example:
+//
+//	scenarios := benchmarks.Matrix(benchmarks.Axes{
+//	    Concurrency: []int{1, 16},
+//	    Keys:        []int{1, 10000},
+//	    HitRatio:    []float64{0, 0.5},
+//	})
+//	for _, s := range scenarios {
+//	    r, err := benchmarks.Run(s)
+//	    if err != nil {
+//	        return err
+//	    }
+//	    fmt.Println(r)
+//	}
+package benchmarks
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Vipul984/flexlimit"
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+// Algorithms are the algorithms Matrix benchmarks by default.
+var Algorithms = []flexlimit.AlgorithmType{
+	flexlimit.TokenBucket,
+	flexlimit.SlidingWindow,
+	flexlimit.FixedWindow,
+	flexlimit.LeakyBucket,
+	flexlimit.DecayedWindow,
+}
+
+// openRate is the rate of the limiter serving keys within their limit,
+// high enough that no benchmark exhausts it.
+const openRate = 1 << 30
+
+// Scenario describes one benchmark.
+type Scenario struct {
+	// Name identifies the scenario in results. Default: built from the
+	// other fields, as in "token_bucket/memory/c=16/keys=1000/hit=0.5"
+	Name string
+
+	// Algorithm is the algorithm benchmarked. Default: TokenBucket
+	Algorithm flexlimit.AlgorithmType
+
+	// Backend names the storage in the default Name. Default: "memory"
+	Backend string
+
+	// Storage opens the storage benchmarked, closed after the run.
+	// Default: the limiter's own in-memory store
+	Storage func() (storage.Storage, error)
+
+	// Concurrency is the number of goroutines calling Allow. Default:
+	// GOMAXPROCS
+	Concurrency int
+
+	// Keys is the number of distinct keys requests are spread over,
+	// uniformly. Default: 1000
+	Keys int
+
+	// HitRatio is the fraction of requests, in [0, 1], for keys already
+	// over their limit, which are denied. Default: 0
+	HitRatio float64
+
+	// Options are added to the options of the limiters benchmarked
+	Options []flexlimit.Option
+}
+
+// Result is the outcome of a Scenario.
+type Result struct {
+	// Scenario is the scenario's name
+	Scenario string `json:"scenario"`
+
+	// N is the number of Allow calls measured
+	N int `json:"n"`
+
+	// NsPerOp is the mean wall time per Allow call, all goroutines
+	// together, so it falls as concurrency helps
+	NsPerOp float64 `json:"ns_per_op"`
+
+	// AllocsPerOp and BytesPerOp are the mean allocations per Allow call
+	AllocsPerOp int64 `json:"allocs_per_op"`
+	BytesPerOp  int64 `json:"bytes_per_op"`
+
+	// DenyRatio is the fraction of calls denied, which should match the
+	// scenario's HitRatio
+	DenyRatio float64 `json:"deny_ratio"`
+}
+
+// String formats r like a line of go test -bench output.
+func (r Result) String() string {
+	return fmt.Sprintf("%s\t%d\t%.1f ns/op\t%d B/op\t%d allocs/op\t%.2f denied",
+		r.Scenario, r.N, r.NsPerOp, r.BytesPerOp, r.AllocsPerOp, r.DenyRatio)
+}
+
+// withDefaults fills in the defaults of s.
+func (s Scenario) withDefaults() Scenario {
+	if s.Algorithm == "" {
+		s.Algorithm = flexlimit.TokenBucket
+	}
+	if s.Backend == "" {
+		s.Backend = "memory"
+	}
+	if s.Concurrency <= 0 {
+		s.Concurrency = runtime.GOMAXPROCS(0)
+	}
+	if s.Keys <= 0 {
+		s.Keys = 1000
+	}
+	s.HitRatio = min(max(s.HitRatio, 0), 1)
+	if s.Name == "" {
+		s.Name = fmt.Sprintf("%s/%s/c=%d/keys=%d/hit=%s",
+			s.Algorithm, s.Backend, s.Concurrency, s.Keys, strconv.FormatFloat(s.HitRatio, 'g', -1, 64))
+	}
+	return s
+}
+
+// Run benchmarks s: Concurrency goroutines calling Allow on keys picked at
+// random, a HitRatio share of them already over their limit. Keys within
+// their limit are served by a limiter with a rate no run exhausts, keys
+// over it by one whose keys were used up beforehand, both on the
+// scenario's storage.
+//
+// SlidingWindow keeps a timestamp for each request of the window, and the
+// window of a run never ends, so its cost grows with the run; add
+// flexlimit.WithSlidingWindowBuckets to Options to benchmark its bucketed
+// form instead.
+//
+// It returns an error if the storage can't be opened or a call fails.
+func Run(s Scenario) (Result, error) {
+	s = s.withDefaults()
+
+	var store storage.Storage
+	if s.Storage != nil {
+		var err error
+		if store, err = s.Storage(); err != nil {
+			return Result{}, fmt.Errorf("benchmarks: %s: open storage: %w", s.Name, err)
+		}
+		defer store.Close()
+	}
+
+	newLimiter := func(rate int, prefix string) (*flexlimit.Limiter, error) {
+		opts := []flexlimit.Option{flexlimit.WithAlgorithm(s.Algorithm), flexlimit.WithMaxKeys(2 * s.Keys)}
+		if store != nil {
+			opts = append(opts, flexlimit.WithStorage(store), flexlimit.WithKeyPrefix(prefix))
+		}
+		return flexlimit.New(rate, time.Hour, append(opts, s.Options...)...)
+	}
+	open, err := newLimiter(openRate, "bench-open:")
+	if err != nil {
+		return Result{}, err
+	}
+	defer open.Close()
+	closed, err := newLimiter(1, "bench-closed:")
+	if err != nil {
+		return Result{}, err
+	}
+	defer closed.Close()
+
+	ctx := context.Background()
+	keys := make([]string, s.Keys)
+	for i := range keys {
+		keys[i] = "key:" + strconv.Itoa(i)
+		if s.HitRatio > 0 {
+			if _, err := closed.Allow(ctx, keys[i]); err != nil {
+				return Result{}, fmt.Errorf("benchmarks: %s: %w", s.Name, err)
+			}
+		}
+	}
+	// Without a shared storage the limiters keep their own keys, so the
+	// closed limiter doesn't need cleaning up
+	if store != nil {
+		defer func() {
+			_, _ = storage.Purge(ctx, store, "bench-open:")
+			_, _ = storage.Purge(ctx, store, "bench-closed:")
+		}()
+	}
+
+	var calls, denied atomic.Int64
+	var failure atomic.Pointer[error]
+	res := testing.Benchmark(func(b *testing.B) {
+		b.ReportAllocs()
+		var wg sync.WaitGroup
+		for g := range s.Concurrency {
+			n := b.N / s.Concurrency
+			if g < b.N%s.Concurrency {
+				n++
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rng := rand.New(rand.NewPCG(uint64(g), uint64(b.N)))
+				var deniedHere int64
+				for range n {
+					l := open
+					if rng.Float64() < s.HitRatio {
+						l = closed
+					}
+					allowed, err := l.Allow(ctx, keys[rng.IntN(len(keys))])
+					if err != nil {
+						failure.CompareAndSwap(nil, &err)
+						return
+					}
+					if !allowed {
+						deniedHere++
+					}
+				}
+				calls.Add(int64(n))
+				denied.Add(deniedHere)
+			}()
+		}
+		wg.Wait()
+	})
+	if err := failure.Load(); err != nil {
+		return Result{}, fmt.Errorf("benchmarks: %s: %w", s.Name, *err)
+	}
+
+	r := Result{
+		Scenario:    s.Name,
+		N:           res.N,
+		NsPerOp:     float64(res.T.Nanoseconds()) / float64(max(res.N, 1)),
+		AllocsPerOp: res.AllocsPerOp(),
+		BytesPerOp:  res.AllocedBytesPerOp(),
+	}
+	if n := calls.Load(); n > 0 {
+		r.DenyRatio = float64(denied.Load()) / float64(n)
+	}
+	return r, nil
+}
+
+// Axes are the settings Matrix combines. Empty axes take the default of
+// the matching Scenario field.
+type Axes struct {
+	// Algorithms default to the package's Algorithms
+	Algorithms  []flexlimit.AlgorithmType
+	Concurrency []int
+	Keys        []int
+	HitRatio    []float64
+
+	// Backend and Storage apply to every scenario, as in Scenario
+	Backend string
+	Storage func() (storage.Storage, error)
+}
+
+// Matrix returns a scenario for every combination of the settings of a.
+func Matrix(a Axes) []Scenario {
+	algorithms := a.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = Algorithms
+	}
+	concurrency := orDefault(a.Concurrency)
+	keys := orDefault(a.Keys)
+	hitRatios := orDefault(a.HitRatio)
+
+	var scenarios []Scenario
+	for _, algo := range algorithms {
+		for _, c := range concurrency {
+			for _, k := range keys {
+				for _, h := range hitRatios {
+					scenarios = append(scenarios, Scenario{
+						Algorithm:   algo,
+						Backend:     a.Backend,
+						Storage:     a.Storage,
+						Concurrency: c,
+						Keys:        k,
+						HitRatio:    h,
+					}.withDefaults())
+				}
+			}
+		}
+	}
+	return scenarios
+}
+
+// orDefault returns values, or the zero value alone if it is empty.
+func orDefault[T any](values []T) []T {
+	if len(values) == 0 {
+		return make([]T, 1)
+	}
+	return values
+}
@@ -0,0 +1,74 @@
+package benchmarks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Regression is a scenario slower, or allocating more, than in a
+// baseline.
+type Regression struct {
+	// Scenario is the scenario's name
+	Scenario string
+
+	// Metric is "ns/op", "allocs/op" or "B/op"
+	Metric string
+
+	// Baseline and Current are the metric's values
+	Baseline, Current float64
+}
+
+// String describes the regression, as in
+// "token_bucket/memory/c=1/keys=1000/hit=0: ns/op 120.0 -> 180.0 (+50.0%)".
+func (r Regression) String() string {
+	change := 100 * (r.Current - r.Baseline) / max(r.Baseline, 1)
+	return fmt.Sprintf("%s: %s %.1f -> %.1f (%+.1f%%)", r.Scenario, r.Metric, r.Baseline, r.Current, change)
+}
+
+// Compare returns the regressions of current against baseline: scenarios
+// whose time or bytes allocated per call grew by more than tolerance, a
+// fraction such as 0.1 for 10%, or that make more allocations per call.
+// Allocation counts are stable, so they get no tolerance; timings are
+// noisy, so compare runs made on the same machine, with a tolerance to
+// match. Scenarios missing from either side are skipped.
+func Compare(baseline, current []Result, tolerance float64) []Regression {
+	base := make(map[string]Result, len(baseline))
+	for _, r := range baseline {
+		base[r.Scenario] = r
+	}
+
+	var regressions []Regression
+	for _, cur := range current {
+		prev, ok := base[cur.Scenario]
+		if !ok {
+			continue
+		}
+		if cur.NsPerOp > prev.NsPerOp*(1+tolerance) {
+			regressions = append(regressions, Regression{cur.Scenario, "ns/op", prev.NsPerOp, cur.NsPerOp})
+		}
+		if cur.AllocsPerOp > prev.AllocsPerOp {
+			regressions = append(regressions, Regression{cur.Scenario, "allocs/op", float64(prev.AllocsPerOp), float64(cur.AllocsPerOp)})
+		}
+		if float64(cur.BytesPerOp) > float64(prev.BytesPerOp)*(1+tolerance) {
+			regressions = append(regressions, Regression{cur.Scenario, "B/op", float64(prev.BytesPerOp), float64(cur.BytesPerOp)})
+		}
+	}
+	return regressions
+}
+
+// WriteResults writes results to w as JSON, for a later Compare.
+func WriteResults(w io.Writer, results []Result) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
+
+// ReadResults reads results written by WriteResults.
+func ReadResults(r io.Reader) ([]Result, error) {
+	var results []Result
+	if err := json.NewDecoder(r).Decode(&results); err != nil {
+		return nil, fmt.Errorf("benchmarks: read results: %w", err)
+	}
+	return results, nil
+}
@@ -0,0 +1,86 @@
+package flexlimit
+
+import (
+	"time"
+)
+
+// CalendarPeriod is a calendar period that fixed windows can be aligned
+// to. See WithCalendarWindow.
+type CalendarPeriod string
+
+const (
+	// CalendarDay windows reset at midnight.
+	CalendarDay CalendarPeriod = "day"
+
+	// CalendarWeek windows reset at midnight between Sunday and Monday.
+	CalendarWeek CalendarPeriod = "week"
+
+	// CalendarMonth windows reset at midnight on the first of the month.
+	CalendarMonth CalendarPeriod = "month"
+
+	// CalendarYear windows reset at midnight on the first of January.
+	CalendarYear CalendarPeriod = "year"
+)
+
+// Validate checks if the period is valid.
+func (p CalendarPeriod) Validate() error {
+	switch p {
+	case CalendarDay, CalendarWeek, CalendarMonth, CalendarYear:
+		return nil
+	default:
+		return &InvalidConfigError{
+			Field:  "calendar",
+			Value:  p,
+			Reason: "must be one of: day, week, month, year",
+		}
+	}
+}
+
+// Duration returns the longest the period can be: 25 hours for a day
+// with a daylight saving change, 31 days for a month, 366 days for a
+// year. It is the nominal window of limiters with calendar windows.
+func (p CalendarPeriod) Duration() time.Duration {
+	switch p {
+	case CalendarWeek:
+		return 7*24*time.Hour + time.Hour
+	case CalendarMonth:
+		return 31*24*time.Hour + time.Hour
+	case CalendarYear:
+		return 366*24*time.Hour + time.Hour
+	default:
+		return 25 * time.Hour
+	}
+}
+
+// WithCalendarWindow aligns FixedWindow windows to calendar periods in
+// loc (default: UTC) instead of the limiter's window, so a quota of
+// "10,000 calls per calendar month" resets at midnight on the first of
+// each month rather than 30 days after some arbitrary instant. Every
+// instance sharing storage must use the same period and location.
+//
+// The limiter's window is then only nominal, used where a duration is
+// needed, such as the default ban duration of WithLifecycle; PerCalendar
+// sets it to period.Duration(). Requires the FixedWindow algorithm.
+//
+// Example:
+//
+//	berlin, _ := time.LoadLocation("Europe/Berlin")
+//	limiter, err := flexlimit.NewFromConfig(flexlimit.PerCalendar(10000, flexlimit.CalendarMonth, berlin))
+func WithCalendarWindow(period CalendarPeriod, loc *time.Location) Option {
+	return func(o *Options) {
+		o.calendar = period
+		o.location = loc
+	}
+}
+
+// PerCalendar returns a FixedWindow Config allowing rate requests per
+// calendar period in loc (default: UTC). See WithCalendarWindow.
+func PerCalendar(rate int, period CalendarPeriod, loc *time.Location) Config {
+	return Config{
+		Rate:      rate,
+		Window:    period.Duration(),
+		Algorithm: FixedWindow,
+		Calendar:  period,
+		Location:  loc,
+	}
+}
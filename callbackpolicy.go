@@ -0,0 +1,167 @@
+package flexlimit
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Callback names a callback for WithCallbackPolicy.
+type Callback string
+
+const (
+	// CallbackOnLimit is the callback of OnLimit.
+	CallbackOnLimit Callback = "on_limit"
+
+	// CallbackOnAllow is the callback of OnAllow.
+	CallbackOnAllow Callback = "on_allow"
+
+	// CallbackOnStateChange is the callback of OnStateChange.
+	CallbackOnStateChange Callback = "on_state_change"
+
+	// CallbackOnFallback is the callback of OnFallback. Its calls have no
+	// key, so DedupWindow allows one call per window.
+	CallbackOnFallback Callback = "on_fallback"
+)
+
+// CallbackPolicy thins out the calls of a callback. See
+// WithCallbackPolicy.
+type CallbackPolicy struct {
+	// DedupWindow, if set, skips the calls for a key within this long of
+	// the last call made for it, so a key denied over and over is
+	// reported once per window
+	DedupWindow time.Duration
+
+	// SampleEvery, if above 1, makes one call out of every SampleEvery
+	// left after deduplication, starting with the first
+	SampleEvery int
+
+	// MaxPerSecond, if set, caps the calls made per second; calls over
+	// the cap are skipped
+	MaxPerSecond int
+}
+
+// WithCallbackPolicy thins out the calls of callback with p, so a
+// callback such as OnLimit doesn't overwhelm logging during an attack
+// that gets every request denied. Calls are first deduplicated per key,
+// then sampled, then capped; skipped calls are lost. Each callback has
+// its own policy, and WithSelfLimits still caps all calls together.
+//
+// Example:
+//
+//	flexlimit.New(100, time.Minute,
+//	    flexlimit.OnLimit(logDenial),
+//	    flexlimit.WithCallbackPolicy(flexlimit.CallbackOnLimit, flexlimit.CallbackPolicy{
+//	        DedupWindow:  time.Minute,
+//	        MaxPerSecond: 100,
+//	    }),
+//	)
+//
+// Default: every call is made
+func WithCallbackPolicy(callback Callback, p CallbackPolicy) Option {
+	return func(o *Options) {
+		if o.callbackPolicies == nil {
+			o.callbackPolicies = make(map[Callback]CallbackPolicy)
+		}
+		o.callbackPolicies[callback] = p
+	}
+}
+
+// validateCallbackPolicies checks the policies of WithCallbackPolicy.
+func validateCallbackPolicies(policies map[Callback]CallbackPolicy) error {
+	for callback, p := range policies {
+		switch callback {
+		case CallbackOnLimit, CallbackOnAllow, CallbackOnStateChange, CallbackOnFallback:
+		default:
+			return &InvalidConfigError{Field: "callback_policy", Value: callback, Reason: "unknown callback"}
+		}
+		if p.DedupWindow < 0 || p.SampleEvery < 0 || p.MaxPerSecond < 0 {
+			return &InvalidConfigError{Field: "callback_policy", Value: p, Reason: "fields cannot be negative"}
+		}
+	}
+	return nil
+}
+
+// callbackFilters holds the state of the callback policies, by callback.
+// It is not modified after New.
+type callbackFilters map[Callback]*callbackFilter
+
+// newCallbackFilters returns the filters of policies, or nil if there
+// are none.
+func newCallbackFilters(policies map[Callback]CallbackPolicy, now time.Time) callbackFilters {
+	if len(policies) == 0 {
+		return nil
+	}
+	filters := make(callbackFilters, len(policies))
+	for callback, p := range policies {
+		f := &callbackFilter{
+			policy: p,
+			gate:   newRateGate(p.MaxPerSecond, now),
+		}
+		if p.DedupWindow > 0 {
+			f.recent = make(map[string]time.Time)
+			f.older = make(map[string]time.Time)
+			f.rotated = now
+		}
+		filters[callback] = f
+	}
+	return filters
+}
+
+// allow reports whether callback may be called for key at now.
+func (fs callbackFilters) allow(callback Callback, key string, now time.Time) bool {
+	f := fs[callback]
+	if f == nil {
+		return true
+	}
+	return f.allow(key, now)
+}
+
+// callbackFilter applies a CallbackPolicy to the calls of a callback.
+type callbackFilter struct {
+	policy CallbackPolicy
+	calls  atomic.Uint64
+	gate   *rateGate
+
+	// recent and older are the last calls per key in the current and
+	// previous DedupWindow, so calls older than two windows are forgotten
+	mu      sync.Mutex
+	recent  map[string]time.Time
+	older   map[string]time.Time
+	rotated time.Time
+}
+
+// allow reports whether the callback may be called for key at now.
+func (f *callbackFilter) allow(key string, now time.Time) bool {
+	if f.recent != nil && f.duplicate(key, now) {
+		return false
+	}
+	if n := f.policy.SampleEvery; n > 1 && (f.calls.Add(1)-1)%uint64(n) != 0 {
+		return false
+	}
+	return f.gate.allow(now)
+}
+
+// duplicate reports whether a call was made for key within DedupWindow
+// of now, and records a call at now otherwise.
+func (f *callbackFilter) duplicate(key string, now time.Time) bool {
+	window := f.policy.DedupWindow
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if now.Sub(f.rotated) >= window {
+		f.older, f.recent = f.recent, make(map[string]time.Time)
+		f.rotated = now
+	}
+
+	last, ok := f.recent[key]
+	if !ok {
+		last, ok = f.older[key]
+	}
+	if ok && now.Sub(last) < window {
+		return true
+	}
+	f.recent[key] = now
+	return false
+}
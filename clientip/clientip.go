@@ -0,0 +1,200 @@
+// Package clientip finds the address of the client behind an HTTP
+// request, for keying rate limits by IP.
+//
+// Behind a load balancer or CDN, http.Request.RemoteAddr is the proxy's
+// address and the client's is in a header such as X-Forwarded-For. Those
+// headers are set by whoever sends the request, so trusting them blindly
+// lets a client pick a fresh address for every request and never be
+// limited. A Resolver only reads them from the proxies it trusts, and
+// only the part those proxies wrote: it walks the forwarding chain from
+// the nearest hop back and stops at the first address that isn't a
+// trusted proxy.
+//
+// Example:
+//
+//	proxies, err := clientip.New([]string{"10.0.0.0/8"})
+//	if err != nil {
+//	    return err
+//	}
+//	http.ListenAndServe(":8080", routes.Middleware(router, proxies.Key)(mux))
+package clientip
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/Vipul984/flexlimit"
+)
+
+// Header names a Resolver can read client addresses from.
+const (
+	// XForwardedFor is the de facto standard list of addresses, one
+	// appended by each proxy
+	XForwardedFor = "X-Forwarded-For"
+
+	// XRealIP holds the single client address set by a proxy such as
+	// nginx
+	XRealIP = "X-Real-IP"
+
+	// Forwarded is the standard header of RFC 7239, whose "for"
+	// parameters list the addresses
+	Forwarded = "Forwarded"
+)
+
+// Resolver finds client addresses. The zero Resolver trusts no proxy and
+// always returns the address of the connection's peer.
+//
+// A Resolver must not be modified after its first use.
+type Resolver struct {
+	// TrustedProxies are the addresses of the proxies in front of the
+	// server. Headers are only read from requests whose peer is one of
+	// them
+	TrustedProxies []netip.Prefix
+
+	// Headers are the headers holding client addresses, tried in order
+	// until one is present. Only list the headers your proxies set or
+	// overwrite: a header they pass through untouched is whatever the
+	// client wants it to be. Default: X-Forwarded-For
+	Headers []string
+}
+
+// New returns a Resolver trusting the proxies at trusted, IP addresses or
+// CIDR ranges, and reading the given headers (default: X-Forwarded-For).
+// Returns an *flexlimit.InvalidConfigError if an entry of trusted isn't
+// an address or a range.
+func New(trusted []string, headers ...string) (*Resolver, error) {
+	r := &Resolver{Headers: headers}
+	for _, entry := range trusted {
+		entry = strings.TrimSpace(entry)
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				return nil, &flexlimit.InvalidConfigError{Field: "trusted_proxies", Value: entry, Reason: "not an IP address or CIDR range"}
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		r.TrustedProxies = append(r.TrustedProxies, prefix.Masked())
+	}
+	return r, nil
+}
+
+// ClientIP returns the address of the client that sent req: the first
+// untrusted address of the forwarding chain of its header, or the
+// farthest address if all are trusted. It returns the peer's address if
+// the peer isn't a trusted proxy or sent no header, and the last trusted
+// address if the chain continues with something that isn't an address,
+// such as an obfuscated Forwarded identifier.
+func (r *Resolver) ClientIP(req *http.Request) string {
+	peer, ok := parseAddr(req.RemoteAddr)
+	if !ok {
+		return hostOf(req.RemoteAddr)
+	}
+	if !r.trusted(peer) {
+		return peer.String()
+	}
+
+	chain := r.chain(req)
+	if chain == nil {
+		return peer.String()
+	}
+
+	client := peer
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr, ok := parseAddr(chain[i])
+		if !ok {
+			break
+		}
+		client = addr
+		if !r.trusted(addr) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// Key returns "ip:" followed by the client address of req (see
+// ClientIP), for rate limiting by client.
+func (r *Resolver) Key(req *http.Request) string {
+	return "ip:" + r.ClientIP(req)
+}
+
+// chain returns the addresses of the first present header, farthest
+// first, or nil if none is present.
+func (r *Resolver) chain(req *http.Request) []string {
+	headers := r.Headers
+	if len(headers) == 0 {
+		headers = []string{XForwardedFor}
+	}
+
+	for _, name := range headers {
+		values := req.Header.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+
+		var chain []string
+		for _, value := range values {
+			switch {
+			case strings.EqualFold(name, Forwarded):
+				chain = append(chain, forwardedFor(value)...)
+			case strings.EqualFold(name, XRealIP):
+				chain = append(chain, strings.TrimSpace(value))
+			default:
+				for _, addr := range strings.Split(value, ",") {
+					chain = append(chain, strings.TrimSpace(addr))
+				}
+			}
+		}
+		return chain
+	}
+	return nil
+}
+
+// trusted reports whether addr is one of the trusted proxies.
+func (r *Resolver) trusted(addr netip.Addr) bool {
+	for _, p := range r.TrustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the "for" parameters of the elements of a
+// Forwarded header value. An element without one gets "", which isn't
+// an address, so the chain stops there.
+func forwardedFor(value string) []string {
+	var addrs []string
+	for _, element := range strings.Split(value, ",") {
+		var addr string
+		for _, pair := range strings.Split(element, ";") {
+			name, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(name, "for") {
+				addr = strings.Trim(v, `"`)
+			}
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// parseAddr parses an address with an optional port, as in "10.0.0.1",
+// "10.0.0.1:4711", "2001:db8::1" or "[2001:db8::1]:4711".
+func parseAddr(s string) (netip.Addr, bool) {
+	addr, err := netip.ParseAddr(hostOf(s))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+// hostOf removes the port, if any, from an address.
+func hostOf(s string) string {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+}
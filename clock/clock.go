@@ -0,0 +1,449 @@
+// Package clock provides the time source of limiters and storages.
+//
+// This package allows the rate limiter to work with controllable time
+// in tests while using real time in production. This is critical for
+// testing time-based logic without waiting for real time to pass: with a
+// Mock, waits, timers and background cleanup fire when the test advances
+// the clock, not after real sleeps.
+//
+// Usage in production code:
+//
+//	clock := clock.New()
+//	now := clock.Now()
+//
+// Usage in tests:
+//
+//	clock := clock.NewMock()
+//	limiter, err := flexlimit.New(10, time.Second, flexlimit.WithClock(clock))
+//	clock.Set(specificTime)
+//	clock.Advance(1 * time.Hour)
+package clock
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Clock provides the current time, and timers running on it.
+//
+// Implementations must be safe for concurrent use by multiple goroutines.
+type Clock interface {
+	// Now returns the current time.
+	//
+	// For real clocks, this returns time.Now().
+	// For mock clocks, this returns the simulated time.
+	Now() time.Time
+
+	// After waits for d to elapse and then sends the current time on the
+	// returned channel, like time.After.
+	After(d time.Duration) <-chan time.Time
+
+	// Sleep blocks until d has elapsed, like time.Sleep.
+	Sleep(d time.Duration)
+
+	// NewTimer creates a Timer that sends the current time on its channel
+	// after d, like time.NewTimer.
+	NewTimer(d time.Duration) Timer
+
+	// NewTicker creates a Ticker that sends the current time on its
+	// channel every d, like time.NewTicker. It panics if d <= 0.
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a single event of a Clock, like time.Timer.
+type Timer interface {
+	// C returns the channel on which the time is delivered.
+	C() <-chan time.Time
+
+	// Stop prevents the Timer from firing. It returns false if the timer
+	// already fired or was stopped.
+	Stop() bool
+
+	// Reset changes the timer to fire after d. It returns true if the
+	// timer had been active.
+	Reset(d time.Duration) bool
+}
+
+// Ticker delivers ticks of a Clock at intervals, like time.Ticker.
+type Ticker interface {
+	// C returns the channel on which the ticks are delivered.
+	C() <-chan time.Time
+
+	// Stop turns off the ticker. No more ticks are sent after Stop.
+	Stop()
+
+	// Reset stops the ticker and resets its period to d.
+	Reset(d time.Duration)
+}
+
+// Real is a Clock that uses the system time.
+//
+// This is the production implementation and simply wraps the time
+// package.
+type Real struct{}
+
+// New creates a new real clock that uses system time.
+//
+// This is the default clock used in production.
+func New() Clock {
+	return &Real{}
+}
+
+// Now returns the current system time.
+func (r *Real) Now() time.Time {
+	return time.Now()
+}
+
+// After calls time.After.
+func (r *Real) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Sleep calls time.Sleep.
+func (r *Real) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// NewTimer returns a Timer wrapping a time.Timer.
+func (r *Real) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+// NewTicker returns a Ticker wrapping a time.Ticker.
+func (r *Real) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+// realTimer is a Timer of the system clock.
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time        { return t.t.C }
+func (t realTimer) Stop() bool                 { return t.t.Stop() }
+func (t realTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+// realTicker is a Ticker of the system clock.
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time   { return t.t.C }
+func (t realTicker) Stop()                 { t.t.Stop() }
+func (t realTicker) Reset(d time.Duration) { t.t.Reset(d) }
+
+// Mock is a Clock with controllable time for testing.
+//
+// Mock is safe for concurrent use. All methods use a mutex to
+// ensure thread-safety when reading or modifying the current time.
+//
+// Timers, tickers, After and Sleep wait on the mock time: they fire when
+// Set, Advance or auto-advance moves the clock past their deadline, in
+// deadline order, with Now returning the deadline as each fires. Use
+// BlockUntil to wait for goroutines to start waiting before advancing.
+//
+// Example usage:
+//
+//	clock := clock.NewMock()
+//	clock.Set(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+//
+//	// Test some logic
+//	result := limiter.Allow("user")
+//
+//	// Advance time
+//	clock.Advance(1 * time.Hour)
+//
+//	// Test again with new time
+//	result = limiter.Allow("user")
+type Mock struct {
+	mu   sync.Mutex
+	now  time.Time
+	auto bool // If true, advances time automatically on each Now() call
+	step time.Duration
+
+	waiters []*mockWaiter // pending timers and tickers
+	added   *sync.Cond    // broadcast when a waiter is added, for BlockUntil
+}
+
+// mockWaiter is a pending timer or ticker of a Mock.
+type mockWaiter struct {
+	at     time.Time
+	period time.Duration // 0 for timers
+	c      chan time.Time
+}
+
+// NewMock creates a new mock clock starting at the current system time.
+//
+// You can change the time using Set() or Advance().
+func NewMock() *Mock {
+	return NewMockAt(time.Now())
+}
+
+// NewMockAt creates a new mock clock starting at the specified time.
+func NewMockAt(t time.Time) *Mock {
+	return &Mock{
+		now: t,
+	}
+}
+
+// Now returns the current mock time.
+//
+// If auto-advance is enabled, this will automatically advance
+// the clock by the configured step duration, firing the timers it
+// passes.
+func (m *Mock) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now
+
+	if m.auto {
+		// Auto-advance happens after reading, so next call sees advanced time
+		m.advanceTo(m.now.Add(m.step))
+	}
+
+	return now
+}
+
+// Set sets the mock clock to a specific time, firing the timers and
+// tickers due by then.
+//
+// This is useful for setting up test scenarios at exact times.
+//
+// Example:
+//
+//	clock.Set(time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC))
+func (m *Mock) Set(t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.advanceTo(t)
+}
+
+// Advance moves the mock clock forward by the specified duration, firing
+// the timers and tickers due by then.
+//
+// This is useful for simulating the passage of time in tests.
+//
+// Example:
+//
+//	clock.Advance(5 * time.Minute)  // Jump forward 5 minutes
+//	clock.Advance(1 * time.Hour)     // Jump forward 1 hour
+func (m *Mock) Advance(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.advanceTo(m.now.Add(d))
+}
+
+// advanceTo moves the clock to t, firing the waiters due on the way. Must
+// be called with m.mu held.
+func (m *Mock) advanceTo(t time.Time) {
+	for {
+		i := m.next()
+		if i < 0 || m.waiters[i].at.After(t) {
+			break
+		}
+
+		w := m.waiters[i]
+		if w.at.After(m.now) {
+			m.now = w.at
+		}
+		if w.period > 0 {
+			// Ticks past the one pending in the channel would be dropped,
+			// so skip to the last one due by t
+			w.at = w.at.Add(max(t.Sub(w.at)/w.period, 1) * w.period)
+		} else {
+			m.waiters = slices.Delete(m.waiters, i, i+1)
+		}
+
+		// Like the time package, drop ticks nobody is reading
+		select {
+		case w.c <- m.now:
+		default:
+		}
+	}
+	m.now = t
+}
+
+// next returns the index of the earliest waiter, or -1 if there is none.
+// Must be called with m.mu held.
+func (m *Mock) next() int {
+	first := -1
+	for i, w := range m.waiters {
+		if first < 0 || w.at.Before(m.waiters[first].at) {
+			first = i
+		}
+	}
+	return first
+}
+
+// SetAutoAdvance enables automatic time advancement.
+//
+// When enabled, each call to Now() automatically advances the clock
+// by the specified step duration. This is useful for simulating
+// continuous time progression in tests.
+//
+// Example:
+//
+//	clock.SetAutoAdvance(1 * time.Second)
+//	clock.Now() // Returns T
+//	clock.Now() // Returns T + 1s
+//	clock.Now() // Returns T + 2s
+func (m *Mock) SetAutoAdvance(step time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.auto = true
+	m.step = step
+}
+
+// DisableAutoAdvance disables automatic time advancement.
+func (m *Mock) DisableAutoAdvance() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.auto = false
+}
+
+// Since returns the duration since the given time.
+//
+// This is a convenience method equivalent to:
+//
+//	clock.Now().Sub(t)
+func (m *Mock) Since(t time.Time) time.Duration {
+	return m.Now().Sub(t)
+}
+
+// After returns a channel receiving the mock time once the clock is
+// advanced by d.
+func (m *Mock) After(d time.Duration) <-chan time.Time {
+	return m.NewTimer(d).C()
+}
+
+// Sleep blocks until the clock is advanced by d.
+func (m *Mock) Sleep(d time.Duration) {
+	<-m.After(d)
+}
+
+// NewTimer returns a Timer firing once the clock is advanced by d. A
+// timer for d <= 0 fires at once.
+func (m *Mock) NewTimer(d time.Duration) Timer {
+	t := &mockTimer{m: m, w: &mockWaiter{c: make(chan time.Time, 1)}}
+	t.Reset(d)
+	return t
+}
+
+// NewTicker returns a Ticker firing every time the clock is advanced by
+// d. It panics if d <= 0.
+func (m *Mock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	t := &mockTicker{m: m, w: &mockWaiter{c: make(chan time.Time, 1)}}
+	t.Reset(d)
+	return t
+}
+
+// BlockUntil blocks until at least n timers and tickers are waiting on
+// the clock, counting those of After and Sleep. Tests call it to be sure
+// a goroutine has started waiting before advancing the clock past its
+// deadline.
+func (m *Mock) BlockUntil(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for len(m.waiters) < n {
+		m.cond().Wait()
+	}
+}
+
+// Waiters returns the number of timers and tickers waiting on the clock.
+func (m *Mock) Waiters() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.waiters)
+}
+
+// add schedules w. Must be called with m.mu held.
+func (m *Mock) add(w *mockWaiter) {
+	m.waiters = append(m.waiters, w)
+	m.cond().Broadcast()
+}
+
+// cond returns m.added, creating it on first use. Must be called with
+// m.mu held.
+func (m *Mock) cond() *sync.Cond {
+	if m.added == nil {
+		m.added = sync.NewCond(&m.mu)
+	}
+	return m.added
+}
+
+// remove unschedules w and reports whether it was scheduled. Must be
+// called with m.mu held.
+func (m *Mock) remove(w *mockWaiter) bool {
+	i := slices.Index(m.waiters, w)
+	if i < 0 {
+		return false
+	}
+	m.waiters = slices.Delete(m.waiters, i, i+1)
+	return true
+}
+
+// mockTimer is a Timer of a Mock.
+type mockTimer struct {
+	m *Mock
+	w *mockWaiter
+}
+
+func (t *mockTimer) C() <-chan time.Time {
+	return t.w.c
+}
+
+func (t *mockTimer) Stop() bool {
+	t.m.mu.Lock()
+	defer t.m.mu.Unlock()
+	return t.m.remove(t.w)
+}
+
+func (t *mockTimer) Reset(d time.Duration) bool {
+	t.m.mu.Lock()
+	defer t.m.mu.Unlock()
+
+	active := t.m.remove(t.w)
+	t.w.at = t.m.now.Add(d)
+	if d <= 0 {
+		select {
+		case t.w.c <- t.m.now:
+		default:
+		}
+		return active
+	}
+	t.m.add(t.w)
+	return active
+}
+
+// mockTicker is a Ticker of a Mock.
+type mockTicker struct {
+	m *Mock
+	w *mockWaiter
+}
+
+func (t *mockTicker) C() <-chan time.Time {
+	return t.w.c
+}
+
+func (t *mockTicker) Stop() {
+	t.m.mu.Lock()
+	defer t.m.mu.Unlock()
+	t.m.remove(t.w)
+}
+
+func (t *mockTicker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("clock: non-positive interval for Ticker.Reset")
+	}
+	t.m.mu.Lock()
+	defer t.m.mu.Unlock()
+
+	t.m.remove(t.w)
+	t.w.at, t.w.period = t.m.now.Add(d), d
+	t.m.add(t.w)
+}
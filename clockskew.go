@@ -0,0 +1,120 @@
+package flexlimit
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/Vipul984/flexlimit/clock"
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+// skewCheckInterval is how often the limiter measures its clock against
+// the storage's with WithMaxClockSkew.
+const skewCheckInterval = time.Minute
+
+// skewCheckTimeout bounds each measurement.
+const skewCheckTimeout = time.Second
+
+// WithMaxClockSkew bounds how far the limiter's clock may drift from its
+// storage's before it is corrected. Instances sharing a storage stamp
+// token buckets, windows and logs with their own clocks, so one running
+// ahead refills buckets early and over-limits, and one running behind
+// under-limits. With a storage that has a clock of its own (see
+// storage.TimeSource, implemented by the redis package), the limiter
+// measures the offset of its clock from the storage's when it is created
+// and every minute after, and once the offset exceeds d it uses the
+// storage's time instead, logging a warning (see WithLogger). Smaller
+// offsets are left alone, since the measurement itself is only accurate
+// to half a round trip.
+//
+// Storages without a clock are not measured. With Redis, also consider
+// redis.WithServerTime, which has the atomic scripts read Redis's clock
+// directly.
+//
+// Example:
+//
+//	limiter, err := flexlimit.New(100, time.Second,
+//	    flexlimit.WithStorage(redisStore),
+//	    flexlimit.WithMaxClockSkew(50*time.Millisecond),
+//	)
+//
+// Default: 0 (the limiter's clock is used as is)
+func WithMaxClockSkew(d time.Duration) Option {
+	return func(o *Options) {
+		o.maxClockSkew = d
+	}
+}
+
+// skewClock is the limiter's clock corrected by the offset measured
+// against the storage's clock.
+// Timers run on the base clock, since they only measure durations.
+type skewClock struct {
+	clock.Clock
+	max time.Duration
+
+	offset    atomic.Int64 // nanoseconds added to base
+	next      atomic.Int64 // base time of the next measurement, Unix ns
+	measuring atomic.Bool
+}
+
+// Now returns the corrected time.
+func (c *skewClock) Now() time.Time {
+	return c.Clock.Now().Add(time.Duration(c.offset.Load()))
+}
+
+// due reports whether a measurement is due and no other is running, and
+// if so claims it.
+func (c *skewClock) due() bool {
+	return c.Clock.Now().UnixNano() >= c.next.Load() && c.measuring.CompareAndSwap(false, true)
+}
+
+// checkSkew measures the clock in the background if a measurement is
+// due. Must be called with l.mu held.
+func (l *Limiter) checkSkew() {
+	if l.skew == nil || !l.skew.due() {
+		return
+	}
+
+	// The measurement may outlive l.mu, so whatever closes the backend
+	// waits for it on l.detached
+	store := l.be.store
+	l.detached.Add(1)
+	go func() {
+		defer l.detached.Done()
+		l.measureSkew(store)
+	}()
+}
+
+// measureSkew measures the offset of the limiter's clock from the clock
+// of store, and corrects the limiter's clock if it exceeds the maximum.
+// The caller must have claimed the measurement with due, or be New.
+func (l *Limiter) measureSkew(store storage.Storage) {
+	c := l.skew
+	defer c.measuring.Store(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), skewCheckTimeout)
+	defer cancel()
+
+	sent := c.Clock.Now()
+	server, ok, err := storage.ServerTime(ctx, store)
+	received := c.Clock.Now()
+	c.next.Store(received.Add(skewCheckInterval).UnixNano())
+	if err != nil {
+		l.logger.Warn("flexlimit: measuring clock skew failed", "error", err)
+		return
+	}
+	if !ok {
+		c.offset.Store(0)
+		return
+	}
+
+	skew := server.Sub(sent.Add(received.Sub(sent) / 2))
+	if skew.Abs() <= c.max {
+		c.offset.Store(0)
+		return
+	}
+	if time.Duration(c.offset.Swap(int64(skew))).Abs() <= c.max {
+		l.logger.Warn("flexlimit: clock skew exceeds the maximum, using the storage's clock", "skew", skew, "max", c.max)
+	}
+}
@@ -0,0 +1,191 @@
+// Command flexlimitbench benchmarks the limiter's algorithms and storage
+// backends and checks the results against a baseline.
+//
+// Usage:
+//
+//	flexlimitbench [flags]
+//
+// Flags:
+//
+//	-algorithms    comma-separated algorithms (default all)
+//	-concurrency   comma-separated goroutine counts (default GOMAXPROCS)
+//	-keys          comma-separated key counts (default 1000)
+//	-hit-ratio     comma-separated fractions of requests over the limit
+//	               (default 0)
+//	-redis         Redis address to benchmark; the in-memory store is
+//	               benchmarked if empty (default $FLEXLIMIT_REDIS_ADDR)
+//	-benchtime     approximate run time of each scenario (default 1s)
+//	-out           file to write the results to as JSON
+//	-baseline      results file to compare with; regressions make the
+//	               command exit with status 1
+//	-tolerance     slowdown allowed against the baseline (default 0.1)
+//
+// Try it:
+//
+//	flexlimitbench -concurrency 1,8 -keys 1,10000 -out base.json
+//	# change the code, then
+//	flexlimitbench -concurrency 1,8 -keys 1,10000 -baseline base.json
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Vipul984/flexlimit"
+	"github.com/Vipul984/flexlimit/benchmarks"
+	"github.com/Vipul984/flexlimit/storage"
+	"github.com/Vipul984/flexlimit/storage/redis"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes the command line args and returns the exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("flexlimitbench", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	algorithms := fs.String("algorithms", "", "comma-separated algorithms (default all)")
+	concurrency := fs.String("concurrency", "", "comma-separated goroutine counts (default GOMAXPROCS)")
+	keys := fs.String("keys", "", "comma-separated key counts (default 1000)")
+	hitRatio := fs.String("hit-ratio", "", "comma-separated fractions of requests over the limit (default 0)")
+	addr := fs.String("redis", os.Getenv("FLEXLIMIT_REDIS_ADDR"), "Redis address; the in-memory store is benchmarked if empty")
+	benchtime := fs.String("benchtime", "1s", "approximate run time of each scenario")
+	out := fs.String("out", "", "file to write the results to as JSON")
+	baseline := fs.String("baseline", "", "results file to compare with")
+	tolerance := fs.Float64("tolerance", 0.1, "slowdown allowed against the baseline")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	axes, err := parseAxes(*algorithms, *concurrency, *keys, *hitRatio)
+	if err == nil {
+		// testing.Benchmark reads its run time from the test flags
+		err = setBenchtime(*benchtime)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "flexlimitbench:", err)
+		return 2
+	}
+	if *addr != "" {
+		axes.Backend = "redis"
+		axes.Storage = func() (storage.Storage, error) {
+			return redis.New(storage.Config{Backend: "redis", RedisAddr: *addr})
+		}
+	}
+
+	var results []benchmarks.Result
+	for _, s := range benchmarks.Matrix(axes) {
+		r, err := benchmarks.Run(s)
+		if err != nil {
+			fmt.Fprintln(stderr, "flexlimitbench:", err)
+			return 1
+		}
+		fmt.Fprintln(stdout, r)
+		results = append(results, r)
+	}
+
+	if *out != "" {
+		if err := writeFile(*out, results); err != nil {
+			fmt.Fprintln(stderr, "flexlimitbench:", err)
+			return 1
+		}
+	}
+	if *baseline != "" {
+		return compare(*baseline, results, *tolerance, stdout, stderr)
+	}
+	return 0
+}
+
+// parseAxes parses the comma-separated flag values.
+func parseAxes(algorithms, concurrency, keys, hitRatio string) (benchmarks.Axes, error) {
+	var axes benchmarks.Axes
+	for _, name := range splitList(algorithms) {
+		algo := flexlimit.AlgorithmType(name)
+		if err := algo.Validate(); err != nil {
+			return axes, err
+		}
+		axes.Algorithms = append(axes.Algorithms, algo)
+	}
+
+	var errs []error
+	for _, v := range splitList(concurrency) {
+		n, err := strconv.Atoi(v)
+		errs = append(errs, err)
+		axes.Concurrency = append(axes.Concurrency, n)
+	}
+	for _, v := range splitList(keys) {
+		n, err := strconv.Atoi(v)
+		errs = append(errs, err)
+		axes.Keys = append(axes.Keys, n)
+	}
+	for _, v := range splitList(hitRatio) {
+		f, err := strconv.ParseFloat(v, 64)
+		errs = append(errs, err)
+		axes.HitRatio = append(axes.HitRatio, f)
+	}
+	return axes, errors.Join(errs...)
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// setBenchtime sets the -test.benchtime flag read by testing.Benchmark.
+func setBenchtime(d string) error {
+	testing.Init()
+	return flag.Set("test.benchtime", d)
+}
+
+// writeFile writes results to the file at path.
+func writeFile(path string, results []benchmarks.Result) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := benchmarks.WriteResults(f, results); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// compare reports the regressions of results against the baseline file
+// and returns the exit code.
+func compare(path string, results []benchmarks.Result, tolerance float64, stdout, stderr io.Writer) int {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintln(stderr, "flexlimitbench:", err)
+		return 1
+	}
+	defer f.Close()
+
+	base, err := benchmarks.ReadResults(f)
+	if err != nil {
+		fmt.Fprintln(stderr, "flexlimitbench:", err)
+		return 1
+	}
+
+	regressions := benchmarks.Compare(base, results, tolerance)
+	for _, r := range regressions {
+		fmt.Fprintln(stdout, "regression:", r)
+	}
+	if len(regressions) > 0 {
+		return 1
+	}
+	fmt.Fprintln(stdout, "no regressions")
+	return 0
+}
@@ -0,0 +1,329 @@
+// Command flexlimitctl inspects and edits rate limit state stored in Redis,
+// and replays recorded traffic against a policy file offline.
+//
+// It talks to the storage backend directly, so it works without access to
+// the application and is meant for on-call debugging of a distributed
+// limiter.
+//
+// Usage:
+//
+//	flexlimitctl [flags] <command> [args]
+//
+// Commands:
+//
+//	keys [pattern]   list stored keys (default pattern "*")
+//	get <key>        show the stored state of a key as JSON
+//	reset <key>      delete the stored state of a key
+//	metrics          show key count, latency and Redis server statistics
+//	simulate -config <file> -traffic <file> [-top n]
+//	                 replay a traffic CSV against the limiters of a policy
+//	                 file (see package config) and show the percentage of
+//	                 requests each limiter denies and its most denied keys
+//
+// Flags:
+//
+//	-addr       Redis address (default $FLEXLIMIT_REDIS_ADDR or localhost:6379)
+//	-password   Redis password (default $FLEXLIMIT_REDIS_PASSWORD)
+//	-db         Redis database number
+//	-timeout    timeout for the whole command (default 10s)
+//
+// Keys are storage keys: fixed window counters carry a ":<window index>"
+// suffix, and the records a limiter keeps besides a key's state start with
+// "_fl:", as in "_fl:grace:<key>" for grace periods. State written with a
+// codec (encryption, checksums) can be listed and reset but not shown.
+//
+// simulate doesn't connect to Redis: the policy's limiters run in memory
+// on a mock clock (see package simulation for the traffic format).
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Vipul984/flexlimit/config"
+	"github.com/Vipul984/flexlimit/simulation"
+	"github.com/Vipul984/flexlimit/storage"
+	"github.com/Vipul984/flexlimit/storage/redis"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes the command line args and returns the exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("flexlimitctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	addr := fs.String("addr", envOr("FLEXLIMIT_REDIS_ADDR", "localhost:6379"), "Redis address")
+	password := fs.String("password", os.Getenv("FLEXLIMIT_REDIS_PASSWORD"), "Redis password")
+	db := fs.Int("db", 0, "Redis database number")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout for the whole command")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: flexlimitctl [flags] keys [pattern] | get <key> | reset <key> | metrics | simulate -config <file> -traffic <file>")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	if fs.Arg(0) == "simulate" {
+		if err := simulate(ctx, fs.Args()[1:], stdout, stderr); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return 2
+			}
+			fmt.Fprintln(stderr, "flexlimitctl:", err)
+			return 1
+		}
+		return 0
+	}
+
+	store, err := redis.New(storage.Config{
+		Backend:        "redis",
+		RedisAddr:      *addr,
+		RedisPassword:  *password,
+		RedisDB:        *db,
+		ConnectTimeout: *timeout,
+	})
+	if err != nil {
+		fmt.Fprintln(stderr, "flexlimitctl:", err)
+		return 1
+	}
+	defer store.Close()
+
+	cmd, cmdArgs := fs.Arg(0), fs.Args()[1:]
+	switch cmd {
+	case "keys":
+		err = keys(ctx, store, cmdArgs, stdout)
+	case "get":
+		err = get(ctx, store, cmdArgs, stdout)
+	case "reset":
+		err = reset(ctx, store, cmdArgs, stdout)
+	case "metrics":
+		err = dumpMetrics(ctx, store, *addr, stdout)
+	default:
+		err = fmt.Errorf("unknown command %q", cmd)
+	}
+
+	if err != nil {
+		fmt.Fprintln(stderr, "flexlimitctl:", err)
+		return 1
+	}
+	return 0
+}
+
+// keys lists the keys matching the optional pattern argument.
+func keys(ctx context.Context, store *redis.Store, args []string, w io.Writer) error {
+	if len(args) > 1 {
+		return errors.New("usage: keys [pattern]")
+	}
+
+	pattern := "*"
+	if len(args) == 1 {
+		pattern = args[0]
+	}
+
+	found, err := store.Keys(ctx, pattern)
+	if err != nil {
+		return err
+	}
+	for _, key := range found {
+		fmt.Fprintln(w, key)
+	}
+	return nil
+}
+
+// simulate replays the traffic file of args against the limiters of the
+// policy file of args.
+func simulate(ctx context.Context, args []string, w, stderr io.Writer) error {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	policy := fs.String("config", "", "policy file, YAML or JSON (required)")
+	trafficPath := fs.String("traffic", "", "traffic CSV file: time,limiter,key[,cost] (required)")
+	top := fs.Int("top", 5, "most denied keys shown per limiter")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *policy == "" || *trafficPath == "" || fs.NArg() > 0 {
+		return errors.New("usage: simulate -config <file> -traffic <file> [-top n]")
+	}
+
+	data, err := os.ReadFile(*policy)
+	if err != nil {
+		return err
+	}
+	f, err := config.Parse(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *policy, err)
+	}
+
+	file, err := os.Open(*trafficPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	traffic, err := simulation.ReadCSV(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *trafficPath, err)
+	}
+
+	report, err := simulation.Run(ctx, f, traffic)
+	if err != nil {
+		return err
+	}
+	for _, rule := range report.Rules {
+		fmt.Fprintf(w, "%s\t%d requests\t%d denied\t%.1f%%\n", rule.Name, rule.Requests, rule.Denied, rule.DenyPercent())
+		for _, key := range rule.Keys[:min(*top, len(rule.Keys))] {
+			fmt.Fprintf(w, "  %s\t%d of %d denied\n", key.Key, key.Denied, key.Requests)
+		}
+	}
+	return nil
+}
+
+// storedState is the JSON form of a storage.State.
+type storedState struct {
+	Key         string         `json:"key"`
+	Tokens      float64        `json:"tokens,omitempty"`
+	LastRefill  *time.Time     `json:"last_refill,omitempty"`
+	Count       int64          `json:"count,omitempty"`
+	WindowStart *time.Time     `json:"window_start,omitempty"`
+	Timestamps  int            `json:"timestamps,omitempty"`
+	CreatedAt   *time.Time     `json:"created_at,omitempty"`
+	UpdatedAt   *time.Time     `json:"updated_at,omitempty"`
+	Metadata    map[string]any `json:"metadata,omitempty"`
+}
+
+// get prints the stored state of a key.
+func get(ctx context.Context, store *redis.Store, args []string, w io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("usage: get <key>")
+	}
+
+	st, err := store.Get(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(storedState{
+		Key:         args[0],
+		Tokens:      st.Tokens,
+		LastRefill:  timeOrNil(st.LastRefill),
+		Count:       st.Count,
+		WindowStart: timeOrNil(st.WindowStart),
+		Timestamps:  len(st.Timestamps),
+		CreatedAt:   timeOrNil(st.CreatedAt),
+		UpdatedAt:   timeOrNil(st.UpdatedAt),
+		Metadata:    st.Metadata,
+	})
+}
+
+// reset deletes the stored state of a key.
+func reset(ctx context.Context, store *redis.Store, args []string, w io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("usage: reset <key>")
+	}
+
+	if err := store.Delete(ctx, args[0]); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "reset %s\n", args[0])
+	return nil
+}
+
+// serverStats are the Redis INFO fields shown by the metrics command.
+var serverStats = []string{
+	"redis_version",
+	"connected_clients",
+	"used_memory_human",
+	"instantaneous_ops_per_sec",
+	"keyspace_hits",
+	"keyspace_misses",
+	"evicted_keys",
+	"expired_keys",
+}
+
+// dumpMetrics prints storage health and size.
+func dumpMetrics(ctx context.Context, store *redis.Store, addr string, w io.Writer) error {
+	start := time.Now()
+	if err := store.Ping(ctx); err != nil {
+		return err
+	}
+	latency := time.Since(start)
+
+	all, err := store.Keys(ctx, "*")
+	if err != nil {
+		return err
+	}
+	grace := 0
+	for _, key := range all {
+		if strings.HasPrefix(key, "_fl:grace:") {
+			grace++
+		}
+	}
+
+	fmt.Fprintf(w, "addr\t%s\n", addr)
+	fmt.Fprintf(w, "ping_latency\t%s\n", latency)
+	fmt.Fprintf(w, "keys\t%d\n", len(all))
+	fmt.Fprintf(w, "grace_keys\t%d\n", grace)
+
+	info, err := store.Client().Info(ctx).Result()
+	if err != nil {
+		return err
+	}
+	fields := parseInfo(info)
+	for _, name := range serverStats {
+		if v, ok := fields[name]; ok {
+			fmt.Fprintf(w, "%s\t%s\n", name, v)
+		}
+	}
+	return nil
+}
+
+// parseInfo parses the "field:value" lines of a Redis INFO reply.
+func parseInfo(info string) map[string]string {
+	fields := make(map[string]string)
+	sc := bufio.NewScanner(strings.NewReader(info))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if k, v, ok := strings.Cut(line, ":"); ok {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+// timeOrNil returns a pointer to t, or nil if t is zero.
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// envOr returns the environment variable name, or def if it is unset.
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
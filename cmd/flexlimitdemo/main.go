@@ -0,0 +1,105 @@
+// Command flexlimitdemo runs the flexlimitdemo server.
+//
+// Usage:
+//
+//	flexlimitdemo [flags]
+//
+// Flags:
+//
+//	-listen     address to serve on (default :8080)
+//	-redis      Redis address; state is kept in memory if empty
+//	            (default $FLEXLIMIT_REDIS_ADDR)
+//	-scenario   scenario to run (default gateway)
+//	-list       list the scenarios and exit
+//	-trusted-proxies
+//	            comma-separated addresses or CIDR ranges of proxies whose
+//	            X-Forwarded-For gives the client address
+//
+// The admin API is served under /admin/ when $FLEXLIMIT_ADMIN_TOKEN is set.
+//
+// Try it:
+//
+//	flexlimitdemo -scenario gateway &
+//	for i in $(seq 50); do curl -s -o /dev/null -w '%{http_code}\n' -H 'X-User: alice' -X POST localhost:8080/; done
+//	curl localhost:8080/metrics
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/Vipul984/flexlimit/flexlimitdemo"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run executes the command line args and returns the exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("flexlimitdemo", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	listen := fs.String("listen", ":8080", "address to serve on")
+	redisAddr := fs.String("redis", os.Getenv("FLEXLIMIT_REDIS_ADDR"), "Redis address (empty keeps state in memory)")
+	name := fs.String("scenario", "gateway", "scenario to run")
+	list := fs.Bool("list", false, "list the scenarios and exit")
+	proxies := fs.String("trusted-proxies", "", "comma-separated addresses or CIDR ranges of proxies whose X-Forwarded-For is trusted")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if *list {
+		for _, s := range flexlimitdemo.Scenarios() {
+			fmt.Fprintf(stdout, "%-10s %s\n", s.Name, s.Description)
+		}
+		return 0
+	}
+
+	scenario, ok := flexlimitdemo.Lookup(*name)
+	if !ok {
+		fmt.Fprintf(stderr, "flexlimitdemo: unknown scenario %q (see -list)\n", *name)
+		return 2
+	}
+
+	var trusted []string
+	if *proxies != "" {
+		trusted = strings.Split(*proxies, ",")
+	}
+
+	srv, err := flexlimitdemo.New(flexlimitdemo.Config{
+		RedisAddr:      *redisAddr,
+		AdminToken:     os.Getenv("FLEXLIMIT_ADMIN_TOKEN"),
+		TrustedProxies: trusted,
+	}, scenario)
+	if err != nil {
+		fmt.Fprintln(stderr, "flexlimitdemo:", err)
+		return 1
+	}
+	defer srv.Close()
+
+	httpSrv := &http.Server{Addr: *listen, Handler: srv, ReadHeaderTimeout: 5 * time.Second}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpSrv.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Fprintf(stdout, "flexlimitdemo: serving scenario %q on %s\n", scenario.Name, *listen)
+	if err := httpSrv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintln(stderr, "flexlimitdemo:", err)
+		return 1
+	}
+	return 0
+}
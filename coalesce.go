@@ -0,0 +1,177 @@
+package flexlimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Vipul984/flexlimit/algorithm"
+)
+
+// WithCoalescing merges the requests for the same key arriving within
+// window of each other into one storage operation, for hot keys hit by
+// hundreds of goroutines at once against Redis. The first request of a
+// key waits for window, collecting the others, then charges their total
+// cost in a single call; if that doesn't fit, it charges the requests in
+// arrival order up to the first that exceeds the remaining limit, and
+// denies the rest. A batch costs one storage operation when all its
+// requests fit, and at most three otherwise, however many it holds.
+//
+// Every request of a batch is decided as if it had been made on its own,
+// and callbacks, metrics and grace periods see each of them. Requests
+// wait up to window longer, so keep it to a millisecond or so. A request
+// whose context is done while it waits returns the context's error, but
+// may still be charged.
+//
+// Can't be combined with WithDeadlines or WithLatencyBudget.
+//
+// Example:
+//
+//	limiter, err := flexlimit.New(1000, time.Second,
+//	    flexlimit.WithStorage(redisStore),
+//	    flexlimit.WithCoalescing(time.Millisecond),
+//	)
+//
+// Default: 0 (every request is its own storage operation)
+func WithCoalescing(window time.Duration) Option {
+	return func(o *Options) {
+		o.coalesceWindow = window
+	}
+}
+
+// coalescer collects the requests of each key into batches.
+type coalescer struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	batches map[string]*coalescedBatch
+}
+
+// coalescedBatch is the requests for one key collected during a window.
+type coalescedBatch struct {
+	costs []int
+	done  chan struct{}
+
+	// Set by the leader before done is closed
+	allowed []bool
+	granted *algorithm.State // state after the allowed requests
+	denied  *algorithm.State // state seen by the denied requests
+	err     error
+}
+
+// newCoalescer returns a coalescer, or nil if window is 0.
+func newCoalescer(window time.Duration) *coalescer {
+	if window <= 0 {
+		return nil
+	}
+	return &coalescer{window: window, batches: make(map[string]*coalescedBatch)}
+}
+
+// join adds a request costing n to the open batch of key, opening one if
+// there is none. It returns the batch, the request's index in it, and
+// whether the caller opened it and must decide it.
+func (c *coalescer) join(key string, n int) (b *coalescedBatch, i int, leader bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, ok := c.batches[key]
+	if !ok {
+		b = &coalescedBatch{done: make(chan struct{})}
+		c.batches[key] = b
+	}
+	b.costs = append(b.costs, n)
+	return b, len(b.costs) - 1, !ok
+}
+
+// seal closes the batch of key to new requests.
+func (c *coalescer) seal(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.batches, key)
+}
+
+// allowCoalesced decides a request for key costing n as part of a batch.
+// It has the signature of Algorithm.Allow and must be called with l.mu
+// held for reading.
+func (l *Limiter) allowCoalesced(ctx context.Context, key string, n int) (bool, *algorithm.State, error) {
+	b, i, leader := l.coalescer.join(key, n)
+	if leader {
+		time.Sleep(l.coalescer.window)
+		l.coalescer.seal(key)
+
+		// The batch is decided for every request in it, so the leader
+		// giving up must not cancel it
+		l.decideBatch(context.WithoutCancel(ctx), key, b)
+		close(b.done)
+	}
+
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+		return false, nil, ctx.Err()
+	}
+	if b.err != nil {
+		return false, nil, b.err
+	}
+	if b.allowed[i] {
+		return true, b.granted, nil
+	}
+	return false, b.denied, nil
+}
+
+// decideBatch charges the requests of b to key.
+func (l *Limiter) decideBatch(ctx context.Context, key string, b *coalescedBatch) {
+	algo := l.be.active()
+	b.allowed = make([]bool, len(b.costs))
+
+	total := 0
+	for _, cost := range b.costs {
+		total += cost
+	}
+	allowed, st, err := algo.Allow(ctx, key, total)
+	if err != nil {
+		b.err = err
+		return
+	}
+	if allowed || len(b.costs) == 1 {
+		for i := range b.allowed {
+			b.allowed[i] = allowed
+		}
+		b.granted, b.denied = st, st
+		return
+	}
+
+	// Charge the requests that fit what is left, in arrival order
+	var remaining int64
+	if st != nil {
+		remaining = st.Remaining
+	}
+	fit := 0
+	for _, cost := range b.costs {
+		if int64(fit+cost) > remaining {
+			break
+		}
+		fit += cost
+	}
+	if fit > 0 {
+		granted, st, err := algo.Allow(ctx, key, fit)
+		if err != nil {
+			b.err = err
+			return
+		}
+		if granted {
+			for i, sum := 0, 0; sum < fit; i++ {
+				b.allowed[i] = true
+				sum += b.costs[i]
+			}
+			b.granted = st
+		}
+	}
+
+	// The first call's state tells when the whole batch fits; the denied
+	// requests need when a single one does
+	b.denied = st
+	if denied, err := algo.State(ctx, key); err == nil {
+		b.denied = denied
+	}
+}
@@ -0,0 +1,115 @@
+// Package compat keeps code written against the v0 API of flexlimit
+// compiling as the API evolves, so early adopters can upgrade flexlimit
+// without rewriting every call site at once.
+//
+// Each interface below is the v0 method set of a flexlimit type, asserted
+// against the current type at compile time. As long as they hold, the
+// flexlimit types satisfy them directly; when a signature changes, this
+// package gains a thin adapter implementing the v0 interface over the new
+// API, and the constructors here return it instead. Code that depends on
+// these interfaces and constructors rather than the concrete types keeps
+// building either way.
+//
+// Example:
+//
+//	var limiter compat.Limiter
+//	limiter, err := compat.New(100, time.Minute)
+//	if err != nil {
+//	    return err
+//	}
+//	allowed, err := limiter.Allow(ctx, "user:123")
+package compat
+
+import (
+	"context"
+	"time"
+
+	"github.com/Vipul984/flexlimit"
+)
+
+// APIVersion is the flexlimit API version whose signatures this package
+// preserves.
+const APIVersion = "0"
+
+// Limiter is the v0 method set of flexlimit.Limiter.
+type Limiter interface {
+	Allow(ctx context.Context, key string) (bool, error)
+	AllowN(ctx context.Context, key string, n int) (bool, error)
+	AllowMulti(ctx context.Context, reqs []flexlimit.AllowRequest) ([]flexlimit.Decision, error)
+	Wait(ctx context.Context, key string) error
+	WaitN(ctx context.Context, key string, n int) error
+	Check(ctx context.Context, key string, n int) (bool, *flexlimit.State, error)
+	State(ctx context.Context, key string) (*flexlimit.State, error)
+	Reset(ctx context.Context, key string) error
+	Refund(ctx context.Context, key string, n int) error
+	Limit() (rate int, window time.Duration)
+	SetLimit(rate int, window time.Duration) error
+	Close() error
+}
+
+// CostLimiter is the v0 method set of flexlimit.CostLimiter.
+type CostLimiter interface {
+	Allow(ctx context.Context, key string, cost flexlimit.Cost) error
+	State(ctx context.Context, key string) (map[flexlimit.Dimension]*flexlimit.State, error)
+	Reset(ctx context.Context, key string) error
+	Close() error
+}
+
+// Composite is the v0 method set of flexlimit.Composite.
+type Composite interface {
+	Allow(ctx context.Context, attrs flexlimit.Attributes) error
+	Rules() []string
+	Close() error
+}
+
+// Group is the v0 method set of flexlimit.Group.
+type Group interface {
+	Add(name string, rate int, window time.Duration, opts ...flexlimit.Option) (*flexlimit.Limiter, error)
+	Get(name string) (*flexlimit.Limiter, bool)
+	Allow(ctx context.Context, name, key string) (bool, error)
+	AllowN(ctx context.Context, name, key string, n int) (bool, error)
+	Names() []string
+	Remove(name string) error
+	Close() error
+}
+
+var (
+	_ Limiter     = (*flexlimit.Limiter)(nil)
+	_ CostLimiter = (*flexlimit.CostLimiter)(nil)
+	_ Composite   = (*flexlimit.Composite)(nil)
+	_ Group       = (*flexlimit.Group)(nil)
+)
+
+// New creates a limiter with the v0 signature of flexlimit.New.
+func New(rate int, window time.Duration, opts ...flexlimit.Option) (Limiter, error) {
+	l, err := flexlimit.New(rate, window, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// NewCostLimiter creates a cost limiter with the v0 signature of
+// flexlimit.NewCostLimiter.
+func NewCostLimiter(limits flexlimit.CostLimits, opts ...flexlimit.Option) (CostLimiter, error) {
+	c, err := flexlimit.NewCostLimiter(limits, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewComposite creates a composite limiter with the v0 signature of
+// flexlimit.NewComposite.
+func NewComposite(rules ...flexlimit.Rule) (Composite, error) {
+	c, err := flexlimit.NewComposite(rules...)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewGroup creates a group with the v0 signature of flexlimit.NewGroup.
+func NewGroup() Group {
+	return flexlimit.NewGroup()
+}
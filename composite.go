@@ -0,0 +1,208 @@
+package flexlimit
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// Attributes describe a request to a Composite, such as its tenant, user,
+// IP address or route.
+type Attributes map[string]string
+
+// Rule is one limit of a Composite.
+type Rule struct {
+	// Name identifies the rule in LimitExceededError.Rule
+	Name string
+
+	// Limiter enforces the rule
+	Limiter *Limiter
+
+	// Key returns the rule's key for a request, or "" if the rule doesn't
+	// apply to it
+	Key func(attrs Attributes) string
+
+	// Cost is the number of tokens a request consumes. Default: 1
+	Cost int
+}
+
+// Composite enforces several rules on each request; a request is allowed
+// only if every rule that applies to it allows it.
+//
+// Rules may share limiters, and different rules may resolve to the same
+// key of the same limiter for a request, for example two rules both keyed
+// by tenant. Such rules are evaluated once per request: their limiter is
+// charged once, with the largest of their costs, and they share the
+// decision. Rules are charged in order, and when one denies the request,
+// the rules charged before it are refunded (see Limiter.Refund), so a
+// denied request consumes nothing.
+//
+// Example:
+//
+//	perTenant, _ := flexlimit.New(1000, time.Minute)
+//	perUser, _ := flexlimit.New(100, time.Minute)
+//	c, err := flexlimit.NewComposite(
+//	    flexlimit.Rule{Name: "tenant", Limiter: perTenant, Key: func(a flexlimit.Attributes) string {
+//	        return a["tenant"]
+//	    }},
+//	    flexlimit.Rule{Name: "tenant_writes", Limiter: perTenant, Cost: 5, Key: func(a flexlimit.Attributes) string {
+//	        if a["method"] == "GET" {
+//	            return ""
+//	        }
+//	        return a["tenant"]
+//	    }},
+//	    flexlimit.Rule{Name: "user", Limiter: perUser, Key: func(a flexlimit.Attributes) string {
+//	        return a["user"]
+//	    }},
+//	)
+//	...
+//	err = c.Allow(ctx, flexlimit.Attributes{"tenant": tenantID, "user": userID, "method": r.Method})
+type Composite struct {
+	rules     []Rule
+	admission atomic.Pointer[Admission]
+}
+
+// NewComposite creates a Composite enforcing rules in order. The Composite
+// takes ownership of the rules' limiters and closes them in Close.
+//
+// Returns an *InvalidConfigError if there are no rules, or a rule has no
+// name, limiter or key function, or a negative cost, or two rules share a
+// name.
+func NewComposite(rules ...Rule) (*Composite, error) {
+	if len(rules) == 0 {
+		return nil, &InvalidConfigError{Field: "rules", Value: rules, Reason: "must not be empty"}
+	}
+
+	rules = append([]Rule(nil), rules...)
+	seen := make(map[string]struct{}, len(rules))
+	for i, r := range rules {
+		switch {
+		case r.Name == "":
+			return nil, &InvalidConfigError{Field: "rule", Value: r.Name, Reason: "name must not be empty"}
+		case r.Limiter == nil:
+			return nil, &InvalidConfigError{Field: "rule", Value: r.Name, Reason: "limiter must not be nil"}
+		case r.Key == nil:
+			return nil, &InvalidConfigError{Field: "rule", Value: r.Name, Reason: "key function must not be nil"}
+		case r.Cost < 0:
+			return nil, &InvalidConfigError{Field: "rule", Value: r.Name, Reason: "cost cannot be negative"}
+		}
+		if _, dup := seen[r.Name]; dup {
+			return nil, &InvalidConfigError{Field: "rule", Value: r.Name, Reason: "already defined"}
+		}
+		seen[r.Name] = struct{}{}
+
+		if r.Cost == 0 {
+			rules[i].Cost = 1
+		}
+	}
+
+	return &Composite{rules: rules}, nil
+}
+
+// charge is the consumption of one limiter key by a request, shared by
+// every rule that resolves to it.
+type charge struct {
+	name    string // first rule or dimension resolving to the key
+	limiter *Limiter
+	key     string
+	cost    int
+}
+
+// chargeKey identifies a limiter key.
+type chargeKey struct {
+	limiter *Limiter
+	key     string
+}
+
+// Allow checks a request described by attrs against every rule that
+// applies to it, consuming tokens only if all of them allow it.
+//
+// Returns nil if the request is allowed, a *LimitExceededError (which
+// matches ErrRateLimitExceeded) whose Rule names the first rule that
+// denied it, or the error of a limiter that failed. With an Admission (see
+// SetAdmission), it returns nil if the Admission admits the request, and
+// otherwise the same errors, or ErrNotAdmitted if every rule allowed it.
+func (c *Composite) Allow(ctx context.Context, attrs Attributes) error {
+	charges := c.resolve(attrs)
+	if a := c.admission.Load(); a != nil {
+		return c.admit(ctx, *a, attrs, charges)
+	}
+
+	i, err := takeCharges(ctx, charges)
+	var limitErr *LimitExceededError
+	if errors.As(err, &limitErr) {
+		limitErr.Rule = charges[i].name
+	}
+	return err
+}
+
+// Rules returns the names of the rules in order.
+func (c *Composite) Rules() []string {
+	names := make([]string, len(c.rules))
+	for i, r := range c.rules {
+		names[i] = r.Name
+	}
+	return names
+}
+
+// Close closes the limiters of every rule, once each.
+func (c *Composite) Close() error {
+	closed := make(map[*Limiter]struct{}, len(c.rules))
+	var errs []error
+	for _, r := range c.rules {
+		if _, ok := closed[r.Limiter]; ok {
+			continue
+		}
+		closed[r.Limiter] = struct{}{}
+		errs = append(errs, r.Limiter.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// resolve returns the charges of a request, one per limiter key in the
+// order of the first rule resolving to it.
+func (c *Composite) resolve(attrs Attributes) []charge {
+	charges := make([]charge, 0, len(c.rules))
+	index := make(map[chargeKey]int, len(c.rules))
+
+	for _, r := range c.rules {
+		key := r.Key(attrs)
+		if key == "" {
+			continue
+		}
+
+		ck := chargeKey{limiter: r.Limiter, key: key}
+		if i, ok := index[ck]; ok {
+			charges[i].cost = max(charges[i].cost, r.Cost)
+			continue
+		}
+		index[ck] = len(charges)
+		charges = append(charges, charge{name: r.Name, limiter: r.Limiter, key: key, cost: r.Cost})
+	}
+	return charges
+}
+
+// takeCharges consumes charges in order. If one is denied or fails, it
+// refunds the ones before it and returns its index, with a
+// *LimitExceededError or the limiter's error.
+func takeCharges(ctx context.Context, charges []charge) (int, error) {
+	for i, ch := range charges {
+		allowed, st, err := ch.limiter.allowN(ctx, ch.key, ch.cost)
+		if err == nil && !allowed {
+			err = newLimitExceededError("", ch.key, ch.limiter, st)
+		}
+		if err != nil {
+			refundCharges(ctx, charges[:i])
+			return i, err
+		}
+	}
+	return len(charges), nil
+}
+
+// refundCharges gives back the tokens of charges, even if ctx has ended.
+func refundCharges(ctx context.Context, charges []charge) {
+	ctx = context.WithoutCancel(ctx)
+	for _, ch := range charges {
+		_ = ch.limiter.Refund(ctx, ch.key, ch.cost)
+	}
+}
@@ -0,0 +1,210 @@
+package flexlimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is a rate limit: Rate requests per Window, optionally with a
+// burst and an algorithm.
+//
+// Build one with PerSecond, PerMinute, PerHour, PerDay, Per or PerCalendar
+// rather than passing a bare rate and window to New, where the two are
+// easy to swap:
+//
+//	limiter, err := flexlimit.NewFromConfig(flexlimit.PerMinute(100).WithBurst(20))
+//
+// Configs are values; the With methods return modified copies.
+type Config struct {
+	// Rate is the number of requests allowed per Window
+	Rate int
+
+	// Window is the time window of the limit
+	Window time.Duration
+
+	// Burst is the bucket capacity (see WithBurst). Default: 0
+	Burst int
+
+	// Algorithm is the rate limiting algorithm. Default: TokenBucket
+	Algorithm AlgorithmType
+
+	// Calendar aligns windows to calendar periods (see
+	// WithCalendarWindow). Default: none, windows are Window long
+	Calendar CalendarPeriod
+
+	// Location is the time zone of calendar windows. Default: UTC
+	Location *time.Location
+}
+
+// Per returns a Config allowing rate requests per window.
+func Per(rate int, window time.Duration) Config {
+	return Config{Rate: rate, Window: window}
+}
+
+// PerSecond returns a Config allowing rate requests per second.
+func PerSecond(rate int) Config {
+	return Per(rate, time.Second)
+}
+
+// PerMinute returns a Config allowing rate requests per minute.
+func PerMinute(rate int) Config {
+	return Per(rate, time.Minute)
+}
+
+// PerHour returns a Config allowing rate requests per hour.
+func PerHour(rate int) Config {
+	return Per(rate, time.Hour)
+}
+
+// PerDay returns a Config allowing rate requests per 24 hours.
+func PerDay(rate int) Config {
+	return Per(rate, 24*time.Hour)
+}
+
+// WithBurst returns a copy of c with the given burst size.
+func (c Config) WithBurst(n int) Config {
+	c.Burst = n
+	return c
+}
+
+// WithAlgorithm returns a copy of c using the given algorithm.
+func (c Config) WithAlgorithm(algorithm AlgorithmType) Config {
+	c.Algorithm = algorithm
+	return c
+}
+
+// Options returns the options that apply c's burst and algorithm.
+func (c Config) Options() []Option {
+	opts := []Option{WithBurst(c.Burst)}
+	if c.Algorithm != "" {
+		opts = append(opts, WithAlgorithm(c.Algorithm))
+	}
+	if c.Calendar != "" {
+		opts = append(opts, WithCalendarWindow(c.Calendar, c.Location))
+	}
+	return opts
+}
+
+// Validate checks c as New would.
+//
+// Returns an *InvalidConfigError (matching ErrInvalidConfig) if the rate
+// or window is not positive, the burst is negative, the algorithm or
+// calendar period is unknown, or a calendar period is set without the
+// FixedWindow algorithm.
+func (c Config) Validate() error {
+	o := defaultOptions()
+	for _, opt := range c.Options() {
+		opt(o)
+	}
+	return validateOptions(c.Rate, c.Window, o)
+}
+
+// String returns c in a form like "100 per 1m0s", or "10000 per
+// calendar month in Europe/Berlin" with a calendar period.
+func (c Config) String() string {
+	if c.Calendar == "" {
+		return fmt.Sprintf("%d per %s", c.Rate, c.Window)
+	}
+	s := fmt.Sprintf("%d per calendar %s", c.Rate, c.Calendar)
+	if c.Location != nil && c.Location != time.UTC {
+		s += " in " + c.Location.String()
+	}
+	return s
+}
+
+// windowUnits are the window names accepted by ParseConfig.
+var windowUnits = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "second": time.Second,
+	"m": time.Minute, "min": time.Minute, "minute": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hour": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour,
+}
+
+// ParseConfig parses a rate limit written "<rate>/<window>" or
+// "<rate> per <window>", where the window is a unit (s, sec, second, m,
+// min, minute, h, hr, hour, d or day) or a duration accepted by
+// time.ParseDuration: "10/min", "1000/hour", "5/30s", and the form
+// returned by Config.String, "100 per 1m0s".
+//
+// The window may also be "calendar" followed by a calendar period, and
+// optionally "in" and a time zone name, for a FixedWindow Config (see
+// PerCalendar): "10000/calendar month", "500 per calendar day in
+// America/New_York".
+//
+// Returns an *InvalidConfigError if s is malformed or the limit is
+// invalid (see Config.Validate).
+func ParseConfig(s string) (Config, error) {
+	rate, window, ok := strings.Cut(s, " per ")
+	if !ok {
+		rate, window, ok = strings.Cut(s, "/")
+	}
+	if !ok {
+		return Config{}, &InvalidConfigError{Field: "limit", Value: s, Reason: `must be written "<rate>/<window>"`}
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(rate))
+	if err != nil {
+		return Config{}, &InvalidConfigError{Field: "limit", Value: s, Reason: "rate must be an integer"}
+	}
+	if fields := strings.Fields(window); len(fields) > 0 && strings.EqualFold(fields[0], "calendar") {
+		return parseCalendar(s, n, fields[1:])
+	}
+	window = strings.ToLower(strings.TrimSpace(window))
+	d, ok := windowUnits[strings.TrimSuffix(window, "s")]
+	if !ok {
+		if d, ok = windowUnits[window]; !ok {
+			if d, err = time.ParseDuration(window); err != nil {
+				return Config{}, &InvalidConfigError{Field: "limit", Value: s, Reason: "unknown window " + strconv.Quote(window)}
+			}
+		}
+	}
+
+	cfg := Per(n, d)
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// parseCalendar parses the calendar window of the limit s, fields being
+// what follows "calendar", as in "month in Europe/Berlin".
+func parseCalendar(s string, rate int, fields []string) (Config, error) {
+	if len(fields) != 1 && (len(fields) != 3 || !strings.EqualFold(fields[1], "in")) {
+		return Config{}, &InvalidConfigError{Field: "limit", Value: s, Reason: `calendar windows must be written "calendar <period> [in <time zone>]"`}
+	}
+
+	var loc *time.Location
+	if len(fields) == 3 {
+		var err error
+		if loc, err = time.LoadLocation(fields[2]); err != nil {
+			return Config{}, &InvalidConfigError{Field: "limit", Value: s, Reason: "unknown time zone " + strconv.Quote(fields[2])}
+		}
+	}
+
+	cfg := PerCalendar(rate, CalendarPeriod(strings.ToLower(fields[0])), loc)
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// NewFromConfig creates a limiter for cfg. opts are applied after cfg's
+// own, so they take precedence.
+//
+// Example:
+//
+//	limiter, err := flexlimit.NewFromConfig(
+//	    flexlimit.PerSecond(10).WithBurst(50),
+//	    flexlimit.WithStorage(store),
+//	)
+func NewFromConfig(cfg Config, opts ...Option) (*Limiter, error) {
+	return New(cfg.Rate, cfg.Window, append(cfg.Options(), opts...)...)
+}
+
+// AddConfig creates a limiter with NewFromConfig and registers it under
+// name.
+func (g *Group) AddConfig(name string, cfg Config, opts ...Option) (*Limiter, error) {
+	return g.Add(name, cfg.Rate, cfg.Window, append(cfg.Options(), opts...)...)
+}
@@ -0,0 +1,321 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Vipul984/flexlimit"
+	"github.com/Vipul984/flexlimit/routes"
+	"github.com/Vipul984/flexlimit/storage"
+	"github.com/Vipul984/flexlimit/storage/etcd"
+	"github.com/Vipul984/flexlimit/storage/gossip"
+	"github.com/Vipul984/flexlimit/storage/redis"
+)
+
+// Set holds the limiters built from a File.
+type Set struct {
+	// Limiters holds the named limiters, and each tier under its limiter's
+	// name and the tier's joined by TierSeparator, as in "api.pro"
+	Limiters *flexlimit.Group
+
+	// Composites holds the named composites. Each has its own limiters,
+	// built from the limiters its rules name, and its own keys
+	Composites map[string]*flexlimit.Composite
+
+	// Routers holds the named route rule sets
+	Routers map[string]*routes.Router
+
+	store storage.Storage // shared storage, nil without a storage section
+	opts  []flexlimit.Option
+
+	mu   sync.Mutex
+	file *File // applied file
+
+	// composite limiters by composite name and limiter reference
+	compositeLimiters map[string]map[string]*flexlimit.Limiter
+}
+
+// Limiter returns the limiter named name, or one of its tiers, as in
+// "api.pro".
+func (s *Set) Limiter(name string) (*flexlimit.Limiter, bool) {
+	return s.Limiters.Get(name)
+}
+
+// Tier returns the tier of the limiter named name, or the limiter itself
+// if it has no such tier, so a request of an unknown plan gets the base
+// limit.
+func (s *Set) Tier(name, tier string) (*flexlimit.Limiter, bool) {
+	if l, ok := s.Limiters.Get(name + TierSeparator + tier); ok {
+		return l, true
+	}
+	return s.Limiters.Get(name)
+}
+
+// Close closes every limiter, composite and router, and the shared
+// storage.
+func (s *Set) Close() error {
+	errs := []error{s.Limiters.Close()}
+	for _, c := range s.Composites {
+		errs = append(errs, c.Close())
+	}
+	for _, r := range s.Routers {
+		errs = append(errs, r.Close())
+	}
+	if s.store != nil {
+		errs = append(errs, s.store.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Build connects to the storage of f and creates its limiters. opts
+// apply to every limiter after the file's settings. f must be valid (see
+// Validate).
+func (f *File) Build(opts ...flexlimit.Option) (*Set, error) {
+	set := &Set{
+		Limiters:          flexlimit.NewGroup(),
+		Composites:        make(map[string]*flexlimit.Composite),
+		Routers:           make(map[string]*routes.Router),
+		opts:              opts,
+		file:              f,
+		compositeLimiters: make(map[string]map[string]*flexlimit.Limiter),
+	}
+
+	if f.Storage != nil {
+		store, err := f.Storage.open()
+		if err != nil {
+			return nil, err
+		}
+		set.store = store
+	}
+
+	if err := f.build(set, opts); err != nil {
+		set.Close()
+		return nil, err
+	}
+	return set, nil
+}
+
+// build creates the limiters, composites and routers of f into set.
+func (f *File) build(set *Set, opts []flexlimit.Option) error {
+	for name, l := range f.Limiters {
+		l = f.merge(l)
+		if err := set.add(name, l, l.Limit, opts); err != nil {
+			return at("limiters."+name, err)
+		}
+		for tier, limit := range l.Tiers {
+			if err := set.add(name+TierSeparator+tier, l, limit, opts); err != nil {
+				return at("limiters."+name+".tiers."+tier, err)
+			}
+		}
+	}
+
+	for name, c := range f.Composites {
+		composite, limiters, err := f.composite(name, c, set, opts)
+		if err != nil {
+			return at("composites."+name, err)
+		}
+		set.Composites[name] = composite
+		set.compositeLimiters[name] = limiters
+	}
+
+	for name, lines := range f.Routes {
+		rules := make([]routes.Rule, 0, len(lines))
+		for _, line := range lines {
+			r, err := routes.ParseRule(line)
+			if err != nil {
+				return at("routes."+name, err)
+			}
+			r.Limit = r.Limit.WithBurst(f.Defaults.Burst)
+			if f.Defaults.Algorithm != "" {
+				r.Limit = r.Limit.WithAlgorithm(flexlimit.AlgorithmType(f.Defaults.Algorithm))
+			}
+			rules = append(rules, r)
+		}
+		router, err := routes.New(rules, set.options(f.Defaults, opts)...)
+		if err != nil {
+			return at("routes."+name, err)
+		}
+		set.Routers[name] = router
+	}
+	return nil
+}
+
+// add creates a limiter with the settings of l and limit, and registers it
+// in the set's group under name.
+func (s *Set) add(name string, l Limiter, limit string, opts []flexlimit.Option) error {
+	cfg, err := l.config(limit)
+	if err != nil {
+		return err
+	}
+	_, err = s.Limiters.AddConfig(name, cfg, s.options(l, opts)...)
+	return err
+}
+
+// composite creates the composite name for c, with a limiter per distinct
+// limiter name of its rules. Keys are prefixed with the composite and
+// limiter names, as in "checkout.api.pro|acme", so composites can share
+// storage with the set's limiters.
+func (f *File) composite(name string, c Composite, set *Set, opts []flexlimit.Option) (*flexlimit.Composite, map[string]*flexlimit.Limiter, error) {
+	limiters := make(map[string]*flexlimit.Limiter)
+	closeAll := func() {
+		for _, l := range limiters {
+			l.Close()
+		}
+	}
+
+	rules := make([]flexlimit.Rule, 0, len(c.Rules))
+	for _, r := range c.Rules {
+		l, ok := limiters[r.Limiter]
+		if !ok {
+			settings, limit, found := f.lookup(r.Limiter)
+			if !found {
+				closeAll()
+				return nil, nil, &flexlimit.InvalidConfigError{Field: "limiter", Value: r.Limiter, Reason: "no such limiter or tier"}
+			}
+			cfg, err := settings.config(limit)
+			if err == nil {
+				l, err = flexlimit.NewFromConfig(cfg, set.options(settings, opts)...)
+			}
+			if err != nil {
+				closeAll()
+				return nil, nil, err
+			}
+			limiters[r.Limiter] = l
+		}
+
+		attr, prefix := r.Key, name+TierSeparator+r.Limiter+"|"
+		rules = append(rules, flexlimit.Rule{
+			Name:    r.Name,
+			Limiter: l,
+			Key: func(a flexlimit.Attributes) string {
+				if a[attr] == "" {
+					return ""
+				}
+				return prefix + a[attr]
+			},
+			Cost: r.Cost,
+		})
+	}
+
+	composite, err := flexlimit.NewComposite(rules...)
+	if err != nil {
+		closeAll()
+		return nil, nil, err
+	}
+	return composite, limiters, nil
+}
+
+// options returns the options of a limiter with the settings of l: the
+// shared storage, l's own, then opts.
+func (s *Set) options(l Limiter, opts []flexlimit.Option) []flexlimit.Option {
+	var all []flexlimit.Option
+	if s.store != nil {
+		all = append(all, flexlimit.WithStorage(s.store))
+		if prefix := s.file.Storage.KeyPrefix; prefix != "" {
+			all = append(all, flexlimit.WithKeyPrefix(prefix))
+		}
+	}
+	all = append(all, limiterOptions(l)...)
+	return append(all, opts...)
+}
+
+// limiterOptions returns the options applying l's settings other than its
+// limit, burst and algorithm.
+func limiterOptions(l Limiter) []flexlimit.Option {
+	var opts []flexlimit.Option
+	if l.Fallback != "" {
+		opts = append(opts, flexlimit.WithFallback(flexlimit.FallbackStrategy(l.Fallback)))
+	}
+	if l.Shadow {
+		opts = append(opts, flexlimit.WithShadowMode(true))
+	}
+	if l.GracePeriod > 0 {
+		opts = append(opts, flexlimit.WithGracePeriod(time.Duration(l.GracePeriod)))
+	}
+	if l.Queue > 0 {
+		opts = append(opts, flexlimit.WithQueue(l.Queue))
+	}
+	if l.DrainJitter > 0 {
+		opts = append(opts, flexlimit.WithDrainJitter(time.Duration(l.DrainJitter)))
+	}
+	if l.Rollover > 0 {
+		opts = append(opts, flexlimit.WithRollover(l.Rollover))
+	}
+	if l.MaxKeys > 0 {
+		opts = append(opts, flexlimit.WithMaxKeys(l.MaxKeys))
+	}
+	if len(l.Maintenance) > 0 {
+		windows := make([]flexlimit.MaintenanceWindow, 0, len(l.Maintenance))
+		for _, m := range l.Maintenance {
+			// Validate rejects windows that don't convert
+			w, _ := m.window()
+			windows = append(windows, w)
+		}
+		opts = append(opts, flexlimit.WithMaintenanceWindows(windows...))
+	}
+	return opts
+}
+
+// open connects to the storage backend, one of this module's or one added
+// with storage.Register.
+func (s *Storage) open() (storage.Storage, error) {
+	cfg := storage.Config{
+		Backend:               s.Backend,
+		MaxKeys:               s.MaxKeys,
+		Codec:                 s.Codec,
+		RedisAddr:             s.Addr,
+		RedisPassword:         s.Password,
+		RedisDB:               s.DB,
+		RedisPoolSize:         s.PoolSize,
+		RedisAddrs:            s.Endpoints,
+		RedisMasterName:       s.MasterName,
+		RedisSentinelPassword: s.SentinelPassword,
+		RedisMaxRetries:       s.MaxRetries,
+		RedisClusterMode:      s.Cluster,
+		RedisMinIdleConns:     s.MinIdleConns,
+		RedisPipelineWindow:   s.PipelineWindow,
+		EtcdEndpoints:         s.Endpoints,
+		EtcdUsername:          s.Username,
+		EtcdPassword:          s.Password,
+		ConnectTimeout:        time.Duration(s.ConnectTimeout),
+		ReadTimeout:           time.Duration(s.ReadTimeout),
+		WriteTimeout:          time.Duration(s.WriteTimeout),
+	}
+
+	switch s.Backend {
+	case "memory":
+		return storage.NewMemory(cfg), nil
+	case "redis":
+		return redis.New(cfg)
+	case "etcd":
+		return etcd.New(cfg)
+	case "gossip":
+		return openGossip(cfg, s)
+	}
+	if _, ok := storage.Lookup(s.Backend); ok {
+		return storage.Open(cfg)
+	}
+	return nil, &flexlimit.InvalidConfigError{Field: "storage.backend", Value: s.Backend, Reason: fmt.Sprintf("unknown backend %q", s.Backend)}
+}
+
+// openGossip starts a gossip member bound to s.Addr ("host:port"), joining
+// the members of s.Endpoints.
+func openGossip(cfg storage.Config, s *Storage) (storage.Storage, error) {
+	opts := []gossip.Option{gossip.WithJoin(s.Endpoints...)}
+	if s.Addr != "" {
+		host, port, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return nil, &flexlimit.InvalidConfigError{Field: "storage.addr", Value: s.Addr, Reason: err.Error()}
+		}
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return nil, &flexlimit.InvalidConfigError{Field: "storage.addr", Value: s.Addr, Reason: "invalid port"}
+		}
+		opts = append(opts, gossip.WithBindAddr(host, p))
+	}
+	return gossip.New(cfg, opts...)
+}
@@ -0,0 +1,689 @@
+// Package config builds limiters from a declarative YAML or JSON file, so
+// rate limit policy can live in reviewed configuration rather than code.
+//
+// A file declares the storage shared by the limiters, defaults for every
+// limiter, templates of limiter settings, named limiters with optional
+// tiers, composites combining limiters, and per-endpoint route rules (see
+// package routes):
+//
+//	version: 1
+//	storage:
+//	  backend: redis
+//	  addr: localhost:6379
+//	defaults:
+//	  algorithm: sliding_window
+//	  fallback: local_memory
+//	templates:
+//	  default-api:
+//	    limit: 100/min
+//	    burst: 20
+//	    tiers:
+//	      free: 10/min
+//	      pro: 1000/min
+//	limiters:
+//	  api:
+//	    extends: default-api
+//	  search:
+//	    extends: default-api
+//	    limit: 20/min
+//	  login:
+//	    limit: 5/min
+//	    algorithm: fixed_window
+//	  export:
+//	    limit: 1000/hour
+//	    maintenance:
+//	      - {from: "02:00", to: "04:00", multiplier: 3}
+//	composites:
+//	  checkout:
+//	    rules:
+//	      - {name: tenant, limiter: api.pro, key: tenant}
+//	      - {name: user, limiter: login, key: user}
+//	routes:
+//	  public:
+//	    - POST /api/search => 10/min
+//	    - GET /api/* => 100/min
+//	    - default => 1000/min
+//
+// JSON files use the same field names. Limits are written as accepted by
+// flexlimit.ParseConfig and durations as accepted by time.ParseDuration.
+// Unknown fields are errors, so a typo doesn't silently drop a limit.
+//
+// The named limiters share the key space of the file's storage: callers
+// keep their keys apart, as in "login:" + user. Composites and routes
+// prefix their keys with their names. The storage's key_prefix keeps the
+// file's keys apart from those of other services sharing the backend.
+// Without a storage section, every limiter keeps its keys in its own
+// memory store.
+//
+// Configuration can also come from the environment or from a control
+// plane (see Source, EnvSource, EtcdSource and ConsulSource), and Sync
+// applies its changes to running limiters.
+//
+// Example:
+//
+//	set, err := config.Load("ratelimits.yaml", flexlimit.WithMetrics(collector))
+//	if err != nil {
+//	    return err
+//	}
+//	defer set.Close()
+//
+//	api, _ := set.Tier("api", user.Plan)
+//	allowed, err := api.Allow(ctx, user.ID)
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/Vipul984/flexlimit"
+	"github.com/Vipul984/flexlimit/routes"
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+// Version is the file format version this package reads.
+const Version = 1
+
+// TierSeparator separates a limiter's name from one of its tiers when a
+// composite rule refers to a tier, as in "api.pro".
+const TierSeparator = "."
+
+// File is the content of a configuration file.
+type File struct {
+	// Version is the file format version. Default: Version
+	Version int `json:"version,omitempty"`
+
+	// Storage is the backend shared by every limiter. Default: each
+	// limiter has its own memory store
+	Storage *Storage `json:"storage,omitempty"`
+
+	// Defaults apply to every limiter, composite and route rule; limiters
+	// override them field by field. Its limit and tiers are ignored
+	Defaults Limiter `json:"defaults,omitempty"`
+
+	// Templates are named limiter settings that limiters and other
+	// templates extend
+	Templates map[string]Limiter `json:"templates,omitempty"`
+
+	// Limiters are the named limiters
+	Limiters map[string]Limiter `json:"limiters,omitempty"`
+
+	// Composites are named composites of the limiters
+	Composites map[string]Composite `json:"composites,omitempty"`
+
+	// Routes are named sets of route rules, each line written as accepted
+	// by routes.ParseRule
+	Routes map[string][]string `json:"routes,omitempty"`
+}
+
+// Storage configures the shared storage backend.
+type Storage struct {
+	// Backend is "memory", "redis", "etcd", "gossip" (experimental, see
+	// storage/gossip) or a backend added with storage.Register, which
+	// gets the fields below as a storage.Config
+	Backend string `json:"backend"`
+
+	// Addr, Password, DB and PoolSize configure Redis. For gossip, Addr
+	// is the "host:port" to bind
+	Addr     string `json:"addr,omitempty"`
+	Password string `json:"password,omitempty"`
+	DB       int    `json:"db,omitempty"`
+	PoolSize int    `json:"pool_size,omitempty"`
+
+	// MinIdleConns, PipelineWindow, MaxRetries, MasterName,
+	// SentinelPassword and Cluster configure Redis (see the Redis fields
+	// of storage.Config). With MasterName, Endpoints are the sentinels;
+	// otherwise two or more Endpoints, or Cluster, select a Redis Cluster
+	MinIdleConns     int    `json:"min_idle_conns,omitempty"`
+	PipelineWindow   int    `json:"pipeline_window,omitempty"`
+	MaxRetries       int    `json:"max_retries,omitempty"`
+	MasterName       string `json:"master_name,omitempty"`
+	SentinelPassword string `json:"sentinel_password,omitempty"`
+	Cluster          bool   `json:"cluster,omitempty"`
+
+	// Endpoints, Username and Password configure etcd. For gossip,
+	// Endpoints are the members to join
+	Endpoints []string `json:"endpoints,omitempty"`
+	Username  string   `json:"username,omitempty"`
+
+	// Codec is the state encoding of Redis and etcd (see
+	// storage.NewCodec)
+	Codec string `json:"codec,omitempty"`
+
+	// MaxKeys bounds the memory backend
+	MaxKeys int `json:"max_keys,omitempty"`
+
+	// KeyPrefix namespaces every limiter's keys in the storage, so several
+	// services can share one backend (see flexlimit.WithKeyPrefix)
+	KeyPrefix string `json:"key_prefix,omitempty"`
+
+	// ConnectTimeout, ReadTimeout and WriteTimeout bound network calls
+	ConnectTimeout Duration `json:"connect_timeout,omitempty"`
+	ReadTimeout    Duration `json:"read_timeout,omitempty"`
+	WriteTimeout   Duration `json:"write_timeout,omitempty"`
+}
+
+// Limiter configures a named limiter, or a template of limiters.
+type Limiter struct {
+	// Extends is the name of a template the limiter inherits its settings
+	// from, field by field, overriding those it sets itself. Tiers are
+	// inherited one by one. The template's own template is inherited
+	// from next, then the defaults
+	Extends string `json:"extends,omitempty"`
+
+	// Limit is the limit, such as "100/min" or "10000/calendar month" (see
+	// flexlimit.ParseConfig)
+	Limit string `json:"limit,omitempty"`
+
+	// Burst is the bucket capacity (see flexlimit.WithBurst)
+	Burst int `json:"burst,omitempty"`
+
+	// Algorithm is the algorithm, such as "token_bucket", or the name of
+	// one registered with algorithm.Register
+	Algorithm string `json:"algorithm,omitempty"`
+
+	// Fallback is the fallback strategy: "allow_all", "deny_all" or
+	// "local_memory"
+	Fallback string `json:"fallback,omitempty"`
+
+	// Shadow enables shadow mode (see flexlimit.WithShadowMode)
+	Shadow bool `json:"shadow,omitempty"`
+
+	// GracePeriod is the grace period (see flexlimit.WithGracePeriod)
+	GracePeriod Duration `json:"grace_period,omitempty"`
+
+	// Queue is the queue size of Wait (see flexlimit.WithQueue)
+	Queue int `json:"queue,omitempty"`
+
+	// DrainJitter spreads the releases of leaky bucket keys (see
+	// flexlimit.WithDrainJitter)
+	DrainJitter Duration `json:"drain_jitter,omitempty"`
+
+	// Rollover caps the unused requests fixed window keys carry into
+	// later windows (see flexlimit.WithRollover)
+	Rollover int `json:"rollover,omitempty"`
+
+	// MaxKeys bounds the limiter's own memory store
+	MaxKeys int `json:"max_keys,omitempty"`
+
+	// Maintenance are daily windows changing or suspending the limit (see
+	// flexlimit.WithMaintenanceWindows)
+	Maintenance []Maintenance `json:"maintenance,omitempty"`
+
+	// Tiers are alternative limits of the limiter by tier name, such as
+	// a customer plan. Each tier is a limiter of its own with the other
+	// settings of this one
+	Tiers map[string]string `json:"tiers,omitempty"`
+}
+
+// Maintenance configures a daily maintenance window of a limiter.
+type Maintenance struct {
+	// From and To are the times of day the window opens and closes, as
+	// "02:00". A window closing before it opens runs past midnight
+	From string `json:"from"`
+	To   string `json:"to"`
+
+	// Zone is the IANA time zone of From and To, as "Europe/Paris".
+	// Default: UTC
+	Zone string `json:"zone,omitempty"`
+
+	// Multiplier multiplies the limit while the window is open, as 3 to
+	// triple it. Default: 1
+	Multiplier float64 `json:"multiplier,omitempty"`
+
+	// Suspend lifts the limit while the window is open
+	Suspend bool `json:"suspend,omitempty"`
+}
+
+// window returns m as a flexlimit.MaintenanceWindow.
+func (m Maintenance) window() (flexlimit.MaintenanceWindow, error) {
+	from, err := timeOfDay(m.From)
+	if err != nil {
+		return flexlimit.MaintenanceWindow{}, &flexlimit.InvalidConfigError{Field: "from", Value: m.From, Reason: err.Error()}
+	}
+	to, err := timeOfDay(m.To)
+	if err != nil {
+		return flexlimit.MaintenanceWindow{}, &flexlimit.InvalidConfigError{Field: "to", Value: m.To, Reason: err.Error()}
+	}
+	loc := time.UTC
+	if m.Zone != "" {
+		if loc, err = time.LoadLocation(m.Zone); err != nil {
+			return flexlimit.MaintenanceWindow{}, &flexlimit.InvalidConfigError{Field: "zone", Value: m.Zone, Reason: err.Error()}
+		}
+	}
+	if m.Multiplier < 0 {
+		return flexlimit.MaintenanceWindow{}, &flexlimit.InvalidConfigError{Field: "multiplier", Value: m.Multiplier, Reason: "cannot be negative"}
+	}
+
+	duration := to - from
+	if duration <= 0 {
+		duration += 24 * time.Hour
+	}
+	return flexlimit.MaintenanceWindow{
+		Start:      from,
+		Duration:   duration,
+		Location:   loc,
+		Multiplier: m.Multiplier,
+		Suspend:    m.Suspend,
+	}, nil
+}
+
+// timeOfDay parses a "15:04" time of day as the time since midnight.
+func timeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, errors.New(`must be a time of day such as "02:00"`)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Composite configures a named flexlimit.Composite.
+type Composite struct {
+	// Rules are the composite's rules, in order
+	Rules []CompositeRule `json:"rules"`
+}
+
+// CompositeRule configures a rule of a composite.
+type CompositeRule struct {
+	// Name identifies the rule
+	Name string `json:"name"`
+
+	// Limiter is the name of the limiter enforcing the rule, or of one of
+	// its tiers, as in "api.pro". Rules of a composite naming the same
+	// limiter share it
+	Limiter string `json:"limiter"`
+
+	// Key is the request attribute keying the rule; requests without it
+	// are not checked by the rule
+	Key string `json:"key"`
+
+	// Cost is the number of tokens a request consumes. Default: 1
+	Cost int `json:"cost,omitempty"`
+}
+
+// Duration is a time.Duration written as a string such as "1m30s".
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"1m30s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON writes d as a duration string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Load reads, validates and builds the configuration file at path. opts
+// apply to every limiter after the file's settings, for what a file can't
+// express, such as metrics collectors and callbacks.
+func Load(path string, opts ...flexlimit.Option) (*Set, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	set, err := f.Build(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return set, nil
+}
+
+// Parse decodes and validates a YAML or JSON configuration file.
+//
+// Returns an *flexlimit.InvalidConfigError if data is malformed, or the
+// errors of Validate.
+func Parse(data []byte) (*File, error) {
+	f, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// decode decodes a YAML or JSON configuration file without validating it.
+func decode(data []byte) (*File, error) {
+	// YAML is a superset of JSON: decode either generically, then apply the
+	// JSON field names strictly
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, &flexlimit.InvalidConfigError{Field: "file", Value: "document", Reason: err.Error()}
+	}
+	if doc == nil {
+		doc = map[string]interface{}{}
+	}
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return nil, &flexlimit.InvalidConfigError{Field: "file", Value: "document", Reason: err.Error()}
+	}
+
+	var f File
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f); err != nil {
+		return nil, &flexlimit.InvalidConfigError{Field: "file", Value: "document", Reason: err.Error()}
+	}
+	return &f, nil
+}
+
+// Validate checks every setting of f, without connecting to storage.
+//
+// Returns every problem found, joined, each an
+// *flexlimit.InvalidConfigError whose Field is the path of the setting,
+// such as "limiters.api.tiers.pro".
+func (f *File) Validate() error {
+	var errs []error
+	invalid := func(field string, value interface{}, reason string) {
+		errs = append(errs, &flexlimit.InvalidConfigError{Field: field, Value: value, Reason: reason})
+	}
+
+	if f.Version != 0 && f.Version != Version {
+		invalid("version", f.Version, fmt.Sprintf("only version %d is supported", Version))
+	}
+
+	if s := f.Storage; s != nil {
+		switch s.Backend {
+		case "memory":
+		case "redis":
+			if s.Addr == "" && len(s.Endpoints) == 0 && s.MasterName == "" {
+				invalid("storage.addr", s.Addr, "required by redis, unless endpoints are set")
+			}
+			if s.MasterName != "" && len(s.Endpoints) == 0 {
+				invalid("storage.endpoints", s.Endpoints, "sentinels required by master_name")
+			}
+		case "etcd":
+			if len(s.Endpoints) == 0 {
+				invalid("storage.endpoints", s.Endpoints, "required by etcd")
+			}
+		case "gossip":
+		default:
+			if _, ok := storage.Lookup(s.Backend); !ok {
+				invalid("storage.backend", s.Backend, fmt.Sprintf("must be gossip or a registered backend (%s)", strings.Join(storage.Names(), ", ")))
+			}
+		}
+		if s.DB < 0 || s.PoolSize < 0 || s.MinIdleConns < 0 || s.PipelineWindow < 0 || s.MaxKeys < 0 {
+			invalid("storage", s.Backend, "db, pool_size, min_idle_conns, pipeline_window and max_keys cannot be negative")
+		}
+		if s.MaxRetries < -1 {
+			invalid("storage.max_retries", s.MaxRetries, "must be -1 (no retries) or more")
+		}
+		if s.ConnectTimeout < 0 || s.ReadTimeout < 0 || s.WriteTimeout < 0 {
+			invalid("storage", s.Backend, "timeouts cannot be negative")
+		}
+	}
+
+	errs = append(errs, f.Defaults.validate("defaults", false)...)
+	if f.Defaults.Extends != "" {
+		invalid("defaults.extends", f.Defaults.Extends, "defaults can't extend a template")
+	}
+	for _, name := range slices.Sorted(maps.Keys(f.Templates)) {
+		t, path := f.Templates[name], "templates."+name
+		if name == "" {
+			invalid(path, name, "name must be non-empty")
+		}
+		if err := f.extends(path, t); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		errs = append(errs, f.merge(t).validate(path, false)...)
+	}
+	if len(f.Limiters) == 0 && len(f.Routes) == 0 {
+		invalid("limiters", nil, "the file must declare limiters or routes")
+	}
+	for _, name := range slices.Sorted(maps.Keys(f.Limiters)) {
+		l, path := f.Limiters[name], "limiters."+name
+		if name == "" || strings.Contains(name, TierSeparator) {
+			invalid(path, name, "name must be non-empty and not contain "+TierSeparator)
+		}
+		if err := f.extends(path, l); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		errs = append(errs, f.merge(l).validate(path, true)...)
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(f.Composites)) {
+		c, path := f.Composites[name], "composites."+name
+		if len(c.Rules) == 0 {
+			invalid(path+".rules", nil, "must not be empty")
+		}
+		seen := make(map[string]bool, len(c.Rules))
+		for i, r := range c.Rules {
+			rpath := fmt.Sprintf("%s.rules[%d]", path, i)
+			switch {
+			case r.Name == "":
+				invalid(rpath+".name", r.Name, "must not be empty")
+			case seen[r.Name]:
+				invalid(rpath+".name", r.Name, "already defined")
+			}
+			seen[r.Name] = true
+			if _, _, ok := f.lookup(r.Limiter); !ok {
+				invalid(rpath+".limiter", r.Limiter, "no such limiter or tier")
+			}
+			if r.Key == "" {
+				invalid(rpath+".key", r.Key, "must not be empty")
+			}
+			if r.Cost < 0 {
+				invalid(rpath+".cost", r.Cost, "cannot be negative")
+			}
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(f.Routes)) {
+		lines, path := f.Routes[name], "routes."+name
+		if len(lines) == 0 {
+			invalid(path, nil, "must not be empty")
+		}
+		for i, line := range lines {
+			if _, err := routes.ParseRule(line); err != nil {
+				errs = append(errs, at(fmt.Sprintf("%s[%d]", path, i), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validate checks the settings of a limiter at path. needLimit requires
+// its limit.
+func (l Limiter) validate(path string, needLimit bool) []error {
+	var errs []error
+	invalid := func(field string, value interface{}, reason string) {
+		errs = append(errs, &flexlimit.InvalidConfigError{Field: path + "." + field, Value: value, Reason: reason})
+	}
+
+	if needLimit && l.Limit == "" {
+		invalid("limit", l.Limit, "required")
+	}
+	if l.Limit != "" {
+		if _, err := l.config(l.Limit); err != nil {
+			errs = append(errs, at(path+".limit", err))
+		}
+	}
+	for _, tier := range slices.Sorted(maps.Keys(l.Tiers)) {
+		limit := l.Tiers[tier]
+		if tier == "" {
+			invalid("tiers", tier, "tier name must not be empty")
+		}
+		if _, err := l.config(limit); err != nil {
+			errs = append(errs, at(path+".tiers."+tier, err))
+		}
+	}
+	switch flexlimit.FallbackStrategy(l.Fallback) {
+	case "", flexlimit.AllowAll, flexlimit.DenyAll, flexlimit.LocalMemory:
+	default:
+		invalid("fallback", l.Fallback, `must be "allow_all", "deny_all" or "local_memory"`)
+	}
+	if l.Burst < 0 {
+		invalid("burst", l.Burst, "cannot be negative")
+	}
+	if l.Queue < 0 {
+		invalid("queue", l.Queue, "cannot be negative")
+	}
+	if l.DrainJitter < 0 {
+		invalid("drain_jitter", time.Duration(l.DrainJitter), "cannot be negative")
+	}
+	if l.Rollover < 0 {
+		invalid("rollover", l.Rollover, "cannot be negative")
+	}
+	if l.MaxKeys < 0 {
+		invalid("max_keys", l.MaxKeys, "cannot be negative")
+	}
+	if l.GracePeriod < 0 {
+		invalid("grace_period", time.Duration(l.GracePeriod), "cannot be negative")
+	}
+	for i, m := range l.Maintenance {
+		if _, err := m.window(); err != nil {
+			errs = append(errs, at(fmt.Sprintf("%s.maintenance[%d]", path, i), err))
+		}
+	}
+	return errs
+}
+
+// config returns the limit of l written limit, with l's burst and
+// algorithm.
+func (l Limiter) config(limit string) (flexlimit.Config, error) {
+	cfg, err := flexlimit.ParseConfig(limit)
+	if err != nil {
+		return cfg, err
+	}
+	cfg = cfg.WithBurst(l.Burst)
+	if l.Algorithm != "" {
+		cfg = cfg.WithAlgorithm(flexlimit.AlgorithmType(l.Algorithm))
+	}
+	return cfg, cfg.Validate()
+}
+
+// merge returns l with the settings of the templates it extends and the
+// defaults of f filled in.
+func (f *File) merge(l Limiter) Limiter {
+	seen := make(map[string]bool)
+	for l.Extends != "" && !seen[l.Extends] {
+		seen[l.Extends] = true
+		t := f.Templates[l.Extends]
+		l.Extends = t.Extends
+		l = l.inherit(t)
+	}
+
+	d := f.Defaults
+	d.Limit, d.Tiers = "", nil
+	l.Extends = ""
+	return l.inherit(d)
+}
+
+// inherit returns l with the settings it doesn't set taken from t, and
+// the tiers of t it doesn't override.
+func (l Limiter) inherit(t Limiter) Limiter {
+	if l.Limit == "" {
+		l.Limit = t.Limit
+	}
+	if l.Burst == 0 {
+		l.Burst = t.Burst
+	}
+	if l.Algorithm == "" {
+		l.Algorithm = t.Algorithm
+	}
+	if l.Fallback == "" {
+		l.Fallback = t.Fallback
+	}
+	if !l.Shadow {
+		l.Shadow = t.Shadow
+	}
+	if l.GracePeriod == 0 {
+		l.GracePeriod = t.GracePeriod
+	}
+	if l.Queue == 0 {
+		l.Queue = t.Queue
+	}
+	if l.DrainJitter == 0 {
+		l.DrainJitter = t.DrainJitter
+	}
+	if l.Rollover == 0 {
+		l.Rollover = t.Rollover
+	}
+	if l.MaxKeys == 0 {
+		l.MaxKeys = t.MaxKeys
+	}
+	if l.Maintenance == nil {
+		l.Maintenance = t.Maintenance
+	}
+	if len(t.Tiers) > 0 {
+		tiers := maps.Clone(t.Tiers)
+		maps.Copy(tiers, l.Tiers)
+		l.Tiers = tiers
+	}
+	return l
+}
+
+// extends returns an *flexlimit.InvalidConfigError if the limiter or
+// template l at path extends a template that doesn't exist, or one
+// extending it back.
+func (f *File) extends(path string, l Limiter) error {
+	seen := make(map[string]bool)
+	for name := l.Extends; name != ""; name = f.Templates[name].Extends {
+		if _, ok := f.Templates[name]; !ok {
+			return &flexlimit.InvalidConfigError{Field: path + ".extends", Value: name, Reason: "no such template"}
+		}
+		if seen[name] {
+			return &flexlimit.InvalidConfigError{Field: path + ".extends", Value: name, Reason: "templates extend each other in a cycle"}
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// lookup resolves the name of a limiter, or of one of its tiers, to the
+// limiter's merged settings and limit.
+func (f *File) lookup(name string) (Limiter, string, bool) {
+	base, tier, hasTier := strings.Cut(name, TierSeparator)
+	l, ok := f.Limiters[base]
+	if !ok {
+		return Limiter{}, "", false
+	}
+	l = f.merge(l)
+	if !hasTier {
+		return l, l.Limit, true
+	}
+	limit, ok := l.Tiers[tier]
+	return l, limit, ok
+}
+
+// at prefixes the field of an *flexlimit.InvalidConfigError with path, or
+// wraps other non-nil errors with it.
+func at(path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var ic *flexlimit.InvalidConfigError
+	if errors.As(err, &ic) {
+		field := path
+		if ic.Field != "" && ic.Field != "limit" && ic.Field != "rule" {
+			field += " (" + ic.Field + ")"
+		}
+		return &flexlimit.InvalidConfigError{Field: field, Value: ic.Value, Reason: ic.Reason}
+	}
+	return fmt.Errorf("%s: %w", path, err)
+}
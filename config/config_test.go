@@ -0,0 +1,161 @@
+package config_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Vipul984/flexlimit"
+	"github.com/Vipul984/flexlimit/clock"
+	"github.com/Vipul984/flexlimit/config"
+)
+
+func TestMaintenance(t *testing.T) {
+	f, err := config.Parse([]byte(`
+defaults:
+  algorithm: fixed_window
+limiters:
+  export:
+    limit: 10/hour
+    maintenance:
+      - {from: "02:00", to: "04:00", multiplier: 3}
+      - {from: "23:00", to: "01:00", zone: Europe/Paris, suspend: true}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clk := clock.NewMockAt(time.Date(2025, 1, 1, 2, 0, 0, 0, time.UTC))
+	set, err := f.Build(flexlimit.WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close()
+	export, _ := set.Limiter("export")
+
+	count := func() int {
+		allowed := 0
+		for range 100 {
+			if ok, _ := export.Allow(context.Background(), "nightly"); ok {
+				allowed++
+			}
+		}
+		return allowed
+	}
+	if got := count(); got != 30 {
+		t.Errorf("at 02:00 UTC allowed %d, want 30", got)
+	}
+	clk.Set(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+	if got := count(); got != 10 {
+		t.Errorf("at noon allowed %d, want 10", got)
+	}
+	// 23:30 in Paris is 22:30 UTC in winter
+	clk.Set(time.Date(2025, 1, 1, 22, 30, 0, 0, time.UTC))
+	if got := count(); got != 100 {
+		t.Errorf("at 23:30 in Paris allowed %d, want all 100", got)
+	}
+}
+
+func TestMaintenanceInvalid(t *testing.T) {
+	for _, window := range []string{
+		`{from: "25:00", to: "01:00"}`,
+		`{from: "02:00", to: "2am"}`,
+		`{from: "02:00", to: "04:00", zone: Mars/Olympus}`,
+		`{from: "02:00", to: "04:00", multiplier: -1}`,
+	} {
+		_, err := config.Parse([]byte("limiters:\n  export:\n    limit: 10/hour\n    maintenance:\n      - " + window + "\n"))
+		if err == nil {
+			t.Errorf("Parse accepted maintenance window %s", window)
+		}
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	f, err := config.Parse([]byte(`
+defaults:
+  fallback: deny_all
+templates:
+  default-api:
+    limit: 100/min
+    burst: 150
+    algorithm: fixed_window
+    tiers:
+      free: 10/min
+      pro: 1000/min
+  strict-api:
+    extends: default-api
+    algorithm: token_bucket
+    tiers:
+      free: 5/min
+limiters:
+  api:
+    extends: default-api
+  search:
+    extends: strict-api
+    limit: 20/min
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := f.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close()
+
+	for name, want := range map[string]int{
+		"api":         100,
+		"api.free":    10,
+		"search":      20,
+		"search.free": 5,
+		"search.pro":  1000,
+	} {
+		l, ok := set.Limiter(name)
+		if !ok {
+			t.Errorf("no limiter %s", name)
+			continue
+		}
+		if rate, window := l.Limit(); rate != want || window != time.Minute {
+			t.Errorf("%s limit = %d per %s, want %d per minute", name, rate, window, want)
+		}
+	}
+}
+
+func TestTemplatesInvalid(t *testing.T) {
+	for name, file := range map[string]string{
+		"unknown template": `
+limiters:
+  api: {extends: missing, limit: 10/min}
+`,
+		"cycle": `
+templates:
+  a: {extends: b, limit: 10/min}
+  b: {extends: a}
+limiters:
+  api: {extends: a}
+`,
+		"invalid template": `
+templates:
+  base: {limit: 10/fortnight}
+limiters:
+  api: {limit: 10/min}
+`,
+		"no limit": `
+templates:
+  base: {burst: 10}
+limiters:
+  api: {extends: base}
+`,
+		"defaults extending": `
+defaults: {extends: base}
+templates:
+  base: {burst: 10}
+limiters:
+  api: {limit: 10/min}
+`,
+	} {
+		if _, err := config.Parse([]byte(file)); err == nil {
+			t.Errorf("Parse accepted a file with %s", name)
+		}
+	}
+}
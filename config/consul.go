@@ -0,0 +1,135 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Vipul984/flexlimit"
+)
+
+// ConsulSource reads configuration from a key of Consul's KV store, and
+// watches it for changes with blocking queries, so a control plane can
+// push new limits to every service watching the key at once.
+type ConsulSource struct {
+	// Addr is the address of the Consul agent. Default:
+	// "http://127.0.0.1:8500"
+	Addr string
+
+	// Key holds the YAML or JSON document
+	Key string
+
+	// Token is the ACL token, if any
+	Token string
+
+	// Client makes the requests. Default: a client without timeout, as
+	// blocking queries last up to WaitTime
+	Client *http.Client
+
+	// WaitTime bounds each blocking query. Default: 5 minutes
+	WaitTime time.Duration
+
+	// RetryInterval is how long Watch waits after a failed query.
+	// Default: 5 seconds
+	RetryInterval time.Duration
+}
+
+// Load reads and parses the key.
+func (s *ConsulSource) Load(ctx context.Context) (*File, error) {
+	data, _, err := s.get(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	return parseFrom("consul:"+s.Key, data)
+}
+
+// Watch calls fn whenever the key changes. Failed queries are reported to
+// fn and retried after RetryInterval.
+func (s *ConsulSource) Watch(ctx context.Context, fn func(*File, error)) error {
+	_, index, err := s.get(ctx, 0)
+	if err != nil {
+		return err
+	}
+
+	retry := s.RetryInterval
+	if retry <= 0 {
+		retry = 5 * time.Second
+	}
+	for ctx.Err() == nil {
+		data, next, err := s.get(ctx, index)
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case err != nil:
+			fn(nil, err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(retry):
+			}
+		case next < index:
+			index = 0 // the index went backwards: start over, as Consul advises
+		case next > index:
+			index = next
+			fn(parseFrom("consul:"+s.Key, data))
+		}
+	}
+	return nil
+}
+
+// get reads the key, blocking until its index is past index if index is
+// positive, and returns its value and index.
+func (s *ConsulSource) get(ctx context.Context, index uint64) ([]byte, uint64, error) {
+	addr := s.Addr
+	if addr == "" {
+		addr = "http://127.0.0.1:8500"
+	}
+	wait := s.WaitTime
+	if wait <= 0 {
+		wait = 5 * time.Minute
+	}
+
+	q := url.Values{"raw": {""}}
+	if index > 0 {
+		q.Set("index", strconv.FormatUint(index, 10))
+		q.Set("wait", strconv.Itoa(int(wait.Seconds()))+"s")
+	}
+	u := strings.TrimSuffix(addr, "/") + "/v1/kv/" + strings.TrimPrefix(s.Key, "/") + "?" + q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if s.Token != "" {
+		req.Header.Set("X-Consul-Token", s.Token)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, 0, &flexlimit.InvalidConfigError{Field: "consul_key", Value: s.Key, Reason: "not found"}
+	default:
+		return nil, 0, fmt.Errorf("consul:%s: %s", s.Key, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	next, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	return data, next, nil
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/Vipul984/flexlimit"
+)
+
+// EtcdSource reads configuration from a key of etcd, and watches it for
+// changes, so a control plane can push new limits to every service
+// watching the key at once.
+type EtcdSource struct {
+	// Client is the etcd client. The caller keeps ownership
+	Client *clientv3.Client
+
+	// Key holds the YAML or JSON document
+	Key string
+}
+
+// Load reads and parses the key.
+func (s *EtcdSource) Load(ctx context.Context) (*File, error) {
+	resp, err := s.Client.Get(ctx, s.Key)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, &flexlimit.InvalidConfigError{Field: "etcd_key", Value: s.Key, Reason: "not found"}
+	}
+	return parseFrom("etcd:"+s.Key, resp.Kvs[0].Value)
+}
+
+// Watch calls fn whenever the key is written. Deleting the key leaves the
+// configuration as it was. If the watch is interrupted, fn is called with
+// the error and the watch resumes from the last revision seen.
+func (s *EtcdSource) Watch(ctx context.Context, fn func(*File, error)) error {
+	resp, err := s.Client.Get(ctx, s.Key)
+	if err != nil {
+		return err
+	}
+	rev := resp.Header.Revision + 1
+
+	for ctx.Err() == nil {
+		for wresp := range s.Client.Watch(clientv3.WithRequireLeader(ctx), s.Key, clientv3.WithRev(rev)) {
+			if err := wresp.Err(); err != nil {
+				fn(nil, fmt.Errorf("etcd:%s: %w", s.Key, err))
+				if wresp.CompactRevision > 0 {
+					rev = wresp.CompactRevision
+				}
+				break
+			}
+			for _, ev := range wresp.Events {
+				rev = ev.Kv.ModRevision + 1
+				if ev.Type == clientv3.EventTypePut {
+					fn(parseFrom("etcd:"+s.Key, ev.Kv.Value))
+				}
+			}
+		}
+
+		// Don't spin on a client that keeps closing watches
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"reflect"
+	"slices"
+
+	"github.com/Vipul984/flexlimit"
+)
+
+// Apply updates the set's limiters to the limits of f at runtime, keeping
+// each key's share of its limit (see flexlimit.Limiter.UpdateConfig).
+//
+// Limits, bursts, algorithms and tiers of limiters may change, directly
+// or through the templates they extend, and limiters and tiers may be
+// added or removed; composites follow the limiters they name. The storage, the defaults, the composites' rules,
+// the routes and the other settings of existing limiters can't change
+// without building a new Set.
+//
+// Returns an *flexlimit.InvalidConfigError if f changes what can't be
+// changed, in which case nothing is applied, or the errors of the
+// limiters that couldn't be updated.
+func (s *Set) Apply(ctx context.Context, f *File) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.file.reloadable(f); err != nil {
+		return err
+	}
+
+	var errs []error
+	keep := make(map[string]bool)
+	for _, name := range slices.Sorted(maps.Keys(f.Limiters)) {
+		l := f.merge(f.Limiters[name])
+		keep[name] = true
+		errs = append(errs, at("limiters."+name, s.update(ctx, name, l, l.Limit)))
+		for _, tier := range slices.Sorted(maps.Keys(l.Tiers)) {
+			keep[name+TierSeparator+tier] = true
+			errs = append(errs, at("limiters."+name+".tiers."+tier, s.update(ctx, name+TierSeparator+tier, l, l.Tiers[tier])))
+		}
+	}
+	for _, name := range s.Limiters.Names() {
+		if !keep[name] {
+			errs = append(errs, s.Limiters.Remove(name))
+		}
+	}
+
+	for name, limiters := range s.compositeLimiters {
+		for ref, l := range limiters {
+			settings, limit, _ := f.lookup(ref)
+			cfg, err := settings.config(limit)
+			if err == nil {
+				err = l.UpdateConfig(ctx, cfg)
+			}
+			errs = append(errs, at("composites."+name, err))
+		}
+	}
+
+	s.file = f
+	return errors.Join(errs...)
+}
+
+// update applies the settings of l and limit to the limiter named name,
+// creating it if needed.
+func (s *Set) update(ctx context.Context, name string, l Limiter, limit string) error {
+	limiter, ok := s.Limiters.Get(name)
+	if !ok {
+		return s.add(name, l, limit, s.opts)
+	}
+	cfg, err := l.config(limit)
+	if err != nil {
+		return err
+	}
+	return limiter.UpdateConfig(ctx, cfg)
+}
+
+// reloadable returns an error if next changes settings of f that Apply
+// can't change.
+func (f *File) reloadable(next *File) error {
+	fixed := func(field string, a, b interface{}) error {
+		if reflect.DeepEqual(a, b) {
+			return nil
+		}
+		return &flexlimit.InvalidConfigError{Field: field, Value: b, Reason: "can't change without building a new set"}
+	}
+
+	errs := []error{
+		fixed("storage", f.Storage, next.Storage),
+		fixed("defaults", f.Defaults, next.Defaults),
+		fixed("composites", f.Composites, next.Composites),
+		fixed("routes", f.Routes, next.Routes),
+	}
+	for name, l := range next.Limiters {
+		if prev, ok := f.Limiters[name]; ok {
+			errs = append(errs, fixed("limiters."+name, settings(f.merge(prev)), settings(next.merge(l))))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// settings returns l without the settings Apply can change.
+func settings(l Limiter) Limiter {
+	l.Limit, l.Burst, l.Algorithm, l.Tiers = "", 0, "", nil
+	return l
+}
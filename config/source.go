@@ -0,0 +1,268 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Vipul984/flexlimit"
+)
+
+// Source is where configuration files come from: a file, the
+// environment, or a control plane such as etcd or Consul pushing new
+// limits to a fleet of services.
+//
+// Implementations must be safe for concurrent use.
+type Source interface {
+	// Load returns the current configuration.
+	Load(ctx context.Context) (*File, error)
+
+	// Watch calls fn with each new configuration, or with the error that
+	// prevented reading or parsing it, until ctx is done. It returns nil
+	// once ctx is done, or an error if watching can't start.
+	Watch(ctx context.Context, fn func(f *File, err error)) error
+}
+
+// LoadSource loads the configuration of src and builds it (see
+// File.Build).
+func LoadSource(ctx context.Context, src Source, opts ...flexlimit.Option) (*Set, error) {
+	f, err := src.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return f.Build(opts...)
+}
+
+// Sync applies every new configuration of src to set (see Set.Apply)
+// until ctx is done. onReload, if not nil, is called after each new
+// configuration with the error of reading, parsing or applying it, if any.
+//
+// Example:
+//
+//	src := &config.EtcdSource{Client: client, Key: "/config/ratelimits"}
+//	set, err := config.LoadSource(ctx, src)
+//	if err != nil {
+//	    return err
+//	}
+//	go config.Sync(ctx, src, set, func(err error) {
+//	    if err != nil {
+//	        log.Printf("rate limits not reloaded: %v", err)
+//	    }
+//	})
+func Sync(ctx context.Context, src Source, set *Set, onReload func(error)) error {
+	return src.Watch(ctx, func(f *File, err error) {
+		if err == nil {
+			err = set.Apply(ctx, f)
+		}
+		if onReload != nil {
+			onReload(err)
+		}
+	})
+}
+
+// Watch polls the file at path every interval (default: 5 seconds) until
+// ctx is done, and applies it to set whenever its content changes. It is
+// Sync with a FileSource. A file that fails to parse or apply leaves the
+// limits as they were, or partly updated for errors of Apply.
+//
+// Example:
+//
+//	set, err := config.Load("ratelimits.yaml")
+//	if err != nil {
+//	    return err
+//	}
+//	go config.Watch(ctx, "ratelimits.yaml", set, 10*time.Second, func(err error) {
+//	    if err != nil {
+//	        log.Printf("rate limits not reloaded: %v", err)
+//	    }
+//	})
+func Watch(ctx context.Context, path string, set *Set, interval time.Duration, onReload func(error)) {
+	Sync(ctx, &FileSource{Path: path, Interval: interval}, set, onReload)
+}
+
+// FileSource reads configuration from a YAML or JSON file, polling it for
+// changes.
+type FileSource struct {
+	// Path is the file's path
+	Path string
+
+	// Interval is how often the file is checked for changes. Default: 5
+	// seconds
+	Interval time.Duration
+}
+
+// Load reads and parses the file.
+func (s *FileSource) Load(ctx context.Context) (*File, error) {
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	return parseFrom(s.Path, data)
+}
+
+// Watch calls fn whenever the file's content changes. A file that can't
+// be read, for example while it is being replaced, is checked again at
+// the next interval.
+func (s *FileSource) Watch(ctx context.Context, fn func(*File, error)) error {
+	return poll(ctx, s.Interval, func() ([]byte, error) {
+		return os.ReadFile(s.Path)
+	}, func(data []byte) {
+		fn(parseFrom(s.Path, data))
+	})
+}
+
+// EnvSource reads configuration from environment variables: a whole
+// document in Var, limits in variables starting with Prefix, or both.
+//
+// A limit variable is named Prefix followed by a limiter's name, and
+// optionally "__" and a tier, in upper case: with the prefix
+// "RATELIMIT_", RATELIMIT_API=100/min sets the limit of the limiter
+// "api" and RATELIMIT_API__PRO=1000/min that of its tier "pro". Limit
+// variables override the document.
+//
+// Environment variables don't change under a running process unless it
+// sets them, so Watch is mostly useful to processes that do.
+type EnvSource struct {
+	// Var is the variable holding a YAML or JSON document, if any
+	Var string
+
+	// Prefix starts the names of limit variables. Empty disables them
+	Prefix string
+
+	// Interval is how often the variables are checked for changes.
+	// Default: 5 seconds
+	Interval time.Duration
+}
+
+// Load reads and parses the variables.
+func (s *EnvSource) Load(ctx context.Context) (*File, error) {
+	return s.parse(s.read())
+}
+
+// Watch calls fn whenever the variables change.
+func (s *EnvSource) Watch(ctx context.Context, fn func(*File, error)) error {
+	return poll(ctx, s.Interval, func() ([]byte, error) {
+		return s.read(), nil
+	}, func(data []byte) {
+		fn(s.parse(data))
+	})
+}
+
+// read returns the document and limit variables, one per line after the
+// document, sorted, so changes to any of them are detected.
+func (s *EnvSource) read() []byte {
+	var b bytes.Buffer
+	if s.Var != "" {
+		b.WriteString(os.Getenv(s.Var))
+	}
+	b.WriteByte(0)
+	if s.Prefix == "" {
+		return b.Bytes()
+	}
+
+	var vars []string
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, s.Prefix) && !strings.HasPrefix(kv, s.Var+"=") {
+			vars = append(vars, kv)
+		}
+	}
+	sort.Strings(vars)
+	for _, kv := range vars {
+		b.WriteString(kv)
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+// parse parses what read returned.
+func (s *EnvSource) parse(data []byte) (*File, error) {
+	doc, vars, _ := bytes.Cut(data, []byte{0})
+
+	var f File
+	if len(bytes.TrimSpace(doc)) > 0 {
+		parsed, err := decode(doc)
+		if err != nil {
+			return nil, fmt.Errorf("$%s: %w", s.Var, err)
+		}
+		f = *parsed
+	}
+
+	for _, kv := range strings.Split(string(vars), "\n") {
+		name, limit, ok := strings.Cut(kv, "=")
+		if !ok {
+			continue
+		}
+		if f.Limiters == nil {
+			f.Limiters = make(map[string]Limiter)
+		}
+		limiter, tier, hasTier := strings.Cut(strings.ToLower(strings.TrimPrefix(name, s.Prefix)), "__")
+		l := f.Limiters[limiter]
+		if hasTier {
+			if l.Tiers == nil {
+				l.Tiers = make(map[string]string)
+			}
+			l.Tiers[tier] = limit
+		} else {
+			l.Limit = limit
+		}
+		f.Limiters[limiter] = l
+	}
+
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// parseFrom parses data read from name.
+func parseFrom(name string, data []byte) (*File, error) {
+	f, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return f, nil
+}
+
+// poll calls read every interval (default: 5 seconds) until ctx is done,
+// and changed with what it returned whenever it changes.
+func poll(ctx context.Context, interval time.Duration, read func() ([]byte, error), changed func([]byte)) error {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	var last []byte
+	if data, err := read(); err == nil {
+		last = digest(data)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		data, err := read()
+		if err != nil {
+			continue
+		}
+		if sum := digest(data); !bytes.Equal(sum, last) {
+			last = sum
+			changed(data)
+		}
+	}
+}
+
+// digest returns the SHA-256 of data.
+func digest(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
@@ -0,0 +1,200 @@
+package flexlimit
+
+import (
+	"context"
+	"errors"
+)
+
+// Dimension is an axis along which a CostLimiter meters usage.
+type Dimension string
+
+const (
+	// DimensionRequests meters the number of requests.
+	DimensionRequests Dimension = "requests"
+
+	// DimensionBytes meters payload size.
+	DimensionBytes Dimension = "bytes"
+
+	// DimensionCU meters compute units, an application-defined measure
+	// of work such as tokens generated or rows scanned.
+	DimensionCU Dimension = "compute_units"
+)
+
+// Cost is what a request consumes in each dimension. A zero field
+// consumes nothing in that dimension.
+type Cost struct {
+	// Requests is the number of requests
+	Requests int
+
+	// Bytes is the payload size in bytes
+	Bytes int
+
+	// CU is the number of compute units
+	CU int
+}
+
+// CostLimits are the per-key limits of a CostLimiter, one Config per
+// dimension. A zero Config leaves its dimension unmetered.
+type CostLimits struct {
+	// Requests limits DimensionRequests
+	Requests Config
+
+	// Bytes limits DimensionBytes
+	Bytes Config
+
+	// CU limits DimensionCU
+	CU Config
+}
+
+// CostLimiter limits keys along several dimensions at once, such as
+// requests, bytes and compute units, the way API products meter usage.
+//
+// A request is allowed only if every dimension it consumes from has
+// enough left, and then consumes from all of them; a denied request
+// consumes nothing (see Limiter.Refund). Each dimension is an ordinary
+// Limiter built from its Config and the shared options, and stores its
+// state under the key prefixed with "cost:<dimension>:", so all dimensions
+// can share one storage.
+//
+// Example:
+//
+//	costs, err := flexlimit.NewCostLimiter(flexlimit.CostLimits{
+//	    Requests: flexlimit.PerMinute(600),
+//	    Bytes:    flexlimit.PerMinute(100 << 20),
+//	    CU:       flexlimit.PerHour(10000),
+//	}, flexlimit.WithStorage(redisStore))
+//	...
+//	err = costs.Allow(ctx, apiKey, flexlimit.Cost{Requests: 1, Bytes: 1_500_000, CU: 12})
+//	var limitErr *flexlimit.LimitExceededError
+//	if errors.As(err, &limitErr) {
+//	    log.Printf("out of %s", limitErr.Dimension)
+//	}
+type CostLimiter struct {
+	dims []costDimension
+}
+
+// costDimension is one metered dimension of a CostLimiter.
+type costDimension struct {
+	name    Dimension
+	limiter *Limiter
+}
+
+// NewCostLimiter creates a CostLimiter with the given limits. opts apply
+// to the limiter of every dimension, after the options of its Config.
+//
+// Returns an *InvalidConfigError if no dimension has a limit, or if
+// NewFromConfig would reject a limit or opts.
+func NewCostLimiter(limits CostLimits, opts ...Option) (*CostLimiter, error) {
+	c := &CostLimiter{}
+	for _, d := range []struct {
+		name Dimension
+		cfg  Config
+	}{
+		{DimensionRequests, limits.Requests},
+		{DimensionBytes, limits.Bytes},
+		{DimensionCU, limits.CU},
+	} {
+		if d.cfg == (Config{}) {
+			continue
+		}
+
+		l, err := NewFromConfig(d.cfg, opts...)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.dims = append(c.dims, costDimension{name: d.name, limiter: l})
+	}
+
+	if len(c.dims) == 0 {
+		return nil, &InvalidConfigError{Field: "limits", Value: limits, Reason: "must limit at least one dimension"}
+	}
+	return c, nil
+}
+
+// Allow consumes cost for key in every dimension, or in none of them.
+// Amounts in unmetered dimensions are ignored.
+//
+// Returns nil if the request is allowed, a *LimitExceededError (which
+// matches ErrRateLimitExceeded) whose Dimension names the first dimension
+// without enough left, or the error of a limiter that failed. Returns an
+// *InvalidConfigError if an amount is negative.
+func (c *CostLimiter) Allow(ctx context.Context, key string, cost Cost) error {
+	if cost.Requests < 0 || cost.Bytes < 0 || cost.CU < 0 {
+		return &InvalidConfigError{Field: "cost", Value: cost, Reason: "cannot be negative"}
+	}
+
+	charges := make([]charge, 0, len(c.dims))
+	for _, d := range c.dims {
+		if n := cost.of(d.name); n > 0 {
+			charges = append(charges, charge{name: string(d.name), limiter: d.limiter, key: d.key(key), cost: n})
+		}
+	}
+
+	i, err := takeCharges(ctx, charges)
+	var limitErr *LimitExceededError
+	if errors.As(err, &limitErr) {
+		limitErr.Key = key
+		limitErr.Dimension = Dimension(charges[i].name)
+	}
+	return err
+}
+
+// State returns the state of key in every metered dimension, without
+// consuming anything.
+func (c *CostLimiter) State(ctx context.Context, key string) (map[Dimension]*State, error) {
+	states := make(map[Dimension]*State, len(c.dims))
+	for _, d := range c.dims {
+		st, err := d.limiter.State(ctx, d.key(key))
+		if err != nil {
+			return nil, err
+		}
+		st.Key = key
+		states[d.name] = st
+	}
+	return states, nil
+}
+
+// Reset clears the state of key in every dimension.
+func (c *CostLimiter) Reset(ctx context.Context, key string) error {
+	var errs []error
+	for _, d := range c.dims {
+		errs = append(errs, d.limiter.Reset(ctx, d.key(key)))
+	}
+	return errors.Join(errs...)
+}
+
+// Dimensions returns the metered dimensions.
+func (c *CostLimiter) Dimensions() []Dimension {
+	names := make([]Dimension, len(c.dims))
+	for i, d := range c.dims {
+		names[i] = d.name
+	}
+	return names
+}
+
+// Close closes the limiters of every dimension.
+func (c *CostLimiter) Close() error {
+	var errs []error
+	for _, d := range c.dims {
+		errs = append(errs, d.limiter.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// key returns the storage key of key in dimension d.
+func (d costDimension) key(key string) string {
+	return "cost:" + string(d.name) + ":" + key
+}
+
+// of returns the amount of c in dimension d.
+func (c Cost) of(d Dimension) int {
+	switch d {
+	case DimensionRequests:
+		return c.Requests
+	case DimensionBytes:
+		return c.Bytes
+	default:
+		return c.CU
+	}
+}
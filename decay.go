@@ -0,0 +1,149 @@
+package flexlimit
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Vipul984/flexlimit/metrics"
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+// seenRecord is the kind of record storing when a key was last seen (see
+// recordKey).
+const seenRecord = "seen"
+
+// DecayPolicy says what happens to a key that has been idle. See
+// WithDecay.
+type DecayPolicy struct {
+	// IdleAfter is how long a key must go without requests to decay
+	IdleAfter time.Duration
+
+	// Refill resets the key's rate limit state, so a token bucket is full
+	// again and window counters are empty
+	Refill bool
+
+	// ClearPenalties forgets the key's lifecycle record (see
+	// WithLifecycle): its status, the denials counted toward BanAfter and
+	// the offenses escalating its bans, and its tarpit (see WithTarpit).
+	// A banned key keeps its record unless LiftBans is set
+	ClearPenalties bool
+
+	// LiftBans ends the ban of a banned key, keeping its offenses unless
+	// ClearPenalties is set
+	LiftBans bool
+}
+
+// WithDecay relaxes the limits of keys that come back after being idle
+// for p.IdleAfter, instead of waiting for their state to expire: their
+// state is refilled, their penalties cleared or their bans lifted, as p
+// says. Requests denied by a ban count as activity, so a banned key only
+// decays by staying away.
+//
+// Keys decay on their next request, before it is decided, so the request
+// that ends the idle period is the first to see the relaxed limit. When a
+// key was last seen is stored next to its state, so every instance sharing
+// the storage decays a key alike. The record is only rewritten once a
+// tenth of IdleAfter has passed, so a key decays after between IdleAfter
+// and 1.1 times IdleAfter without requests; this costs a storage read per
+// request and a write at most every IdleAfter/10. A key without a record,
+// new or idle for twice IdleAfter, decays too, which does nothing for a
+// new key. If the record can't be read the key is left as it is.
+// AllowMulti decides requests one by one with a decay policy.
+//
+// Example:
+//
+//	limiter, err := flexlimit.New(100, time.Minute,
+//	    flexlimit.WithLifecycle(flexlimit.LifecyclePolicy{BanAfter: 5}),
+//	    flexlimit.WithDecay(flexlimit.DecayPolicy{
+//	        IdleAfter:      time.Hour,
+//	        Refill:         true,
+//	        ClearPenalties: true,
+//	    }),
+//	)
+func WithDecay(p DecayPolicy) Option {
+	return func(o *Options) {
+		o.decay = &p
+	}
+}
+
+// decay applies the decay policy to key if it has been idle, and records
+// that it was seen at now. Must be called with l.mu held.
+func (l *Limiter) decay(ctx context.Context, key string, now time.Time) {
+	p := l.opts.decay
+	store := l.be.store
+	seenKey := recordKey(seenRecord, key)
+	every := p.IdleAfter / 10
+
+	st, err := store.Get(ctx, seenKey)
+	switch {
+	case err == nil:
+		idle := now.Sub(st.LastRefill)
+		if idle < every {
+			return
+		}
+		if idle > p.IdleAfter+every {
+			l.decayKey(ctx, key, now)
+			l.opts.metrics.IncCounter(metrics.KeysDecayed, l.labels)
+		}
+	case errors.Is(err, storage.ErrKeyNotFound):
+		l.decayKey(ctx, key, now)
+	default:
+		return
+	}
+
+	if err := store.Set(ctx, seenKey, &storage.State{LastRefill: now}, 2*p.IdleAfter); err != nil {
+		l.warn(ctx, "flexlimit: failed to record key activity", "key", key, "error", err)
+	}
+}
+
+// decayKey relaxes the limit of the idle key as the decay policy says.
+// Must be called with l.mu held.
+func (l *Limiter) decayKey(ctx context.Context, key string, now time.Time) {
+	p := l.opts.decay
+	store := l.be.store
+
+	var err error
+	if p.Refill {
+		err = l.be.reset(ctx, key, l.be.active())
+	}
+	if p.ClearPenalties && l.opts.tarpit != nil {
+		if delErr := store.Delete(ctx, recordKey(tarpitRecord, key)); !errors.Is(delErr, storage.ErrKeyNotFound) {
+			err = errors.Join(err, delErr)
+		}
+	}
+	if l.lifecycle != nil && (p.ClearPenalties || p.LiftBans) {
+		err = errors.Join(err, l.decayRecord(ctx, key, now))
+	}
+	if err != nil {
+		l.warn(ctx, "flexlimit: failed to decay idle key", "key", key, "error", err)
+	}
+}
+
+// decayRecord clears the penalties of the idle key's lifecycle record or
+// lifts its ban, as the decay policy says. Must be called with l.mu held.
+func (l *Limiter) decayRecord(ctx context.Context, key string, now time.Time) error {
+	p := l.opts.decay
+	store := l.be.store
+
+	rec, err := l.loadRecord(ctx, store, key)
+	if err != nil {
+		return err
+	}
+
+	next := rec
+	switch {
+	case rec.banned(now) && p.LiftBans:
+		next = keyRecord{status: KeyActive}
+		if !p.ClearPenalties {
+			next.offenses = rec.offenses
+		}
+	case rec.banned(now):
+	case p.ClearPenalties && rec.status != KeyArchived:
+		next = keyRecord{status: KeyActive}
+	}
+	if next == rec {
+		return nil
+	}
+	return l.transition(ctx, store, key, rec, next, "decayed", now)
+}
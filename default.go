@@ -0,0 +1,87 @@
+package flexlimit
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultConfig is the limit of the default limiter when none was set with
+// SetDefault.
+var defaultConfig = PerSecond(10)
+
+var (
+	defaultLimiter atomic.Pointer[Limiter]
+	defaultMu      sync.Mutex
+)
+
+// Default returns the process-wide default limiter used by the
+// package-level Allow, AllowN, Wait and WaitN.
+//
+// Unless SetDefault installed one, the first call creates an in-memory
+// token bucket allowing 10 requests per second per key. Larger programs
+// should create their own limiters with New rather than share this one.
+func Default() *Limiter {
+	if l := defaultLimiter.Load(); l != nil {
+		return l
+	}
+
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	if l := defaultLimiter.Load(); l != nil {
+		return l
+	}
+	l, err := NewFromConfig(defaultConfig)
+	if err != nil {
+		panic("flexlimit: default limiter: " + err.Error())
+	}
+	defaultLimiter.Store(l)
+	return l
+}
+
+// SetDefault makes l the default limiter and returns the previous one, or
+// nil if there was none. Passing nil restores the built-in default on the
+// next call to Default.
+//
+// SetDefault doesn't close the previous limiter; the caller owns it.
+//
+// Example:
+//
+//	limiter, err := flexlimit.NewFromConfig(flexlimit.PerMinute(60))
+//	...
+//	flexlimit.SetDefault(limiter)
+//
+//	if err := flexlimit.Wait(ctx, "api"); err != nil {
+//	    return err
+//	}
+func SetDefault(l *Limiter) *Limiter {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	return defaultLimiter.Swap(l)
+}
+
+// Allow reports whether a request for key is allowed by the default
+// limiter. See Limiter.Allow.
+func Allow(ctx context.Context, key string) (bool, error) {
+	return Default().Allow(ctx, key)
+}
+
+// AllowN reports whether a request for key costing n is allowed by the
+// default limiter. See Limiter.AllowN.
+func AllowN(ctx context.Context, key string, n int) (bool, error) {
+	return Default().AllowN(ctx, key, n)
+}
+
+// Wait blocks until the default limiter allows a request for key, or ctx
+// ends. See Limiter.Wait.
+func Wait(ctx context.Context, key string) error {
+	return Default().Wait(ctx, key)
+}
+
+// WaitN blocks until the default limiter allows a request for key costing
+// n, or ctx ends. See Limiter.WaitN.
+func WaitN(ctx context.Context, key string, n int) error {
+	return Default().WaitN(ctx, key, n)
+}
@@ -23,8 +23,8 @@ var (
 	//
 	// Example:
 	//
-	//	limiter := flexlimit.New(0, time.Minute) // Invalid: rate must be > 0
-	//	// Returns ErrInvalidConfig
+	//	_, err := flexlimit.New(0, time.Minute) // Invalid: rate must be > 0
+	//	// errors.Is(err, flexlimit.ErrInvalidConfig) == true
 	ErrInvalidConfig = errors.New("invalid configuration")
 
 	// ErrStorageUnavailable is returned when the storage backend is unavailable.
@@ -41,10 +41,33 @@ var (
 	ErrKeyNotFound = errors.New("key not found")
 
 	// ErrContextCanceled is returned when the context is canceled during an operation.
+	// Errors matching it also match context.Canceled.
 	ErrContextCanceled = errors.New("context canceled")
 
 	// ErrContextDeadlineExceeded is returned when the context deadline is exceeded.
+	// Errors matching it also match context.DeadlineExceeded.
 	ErrContextDeadlineExceeded = errors.New("context deadline exceeded")
+
+	// ErrLimiterClosed is returned when a limiter is used after Close().
+	ErrLimiterClosed = errors.New("limiter closed")
+
+	// ErrQueueFull is returned by Wait when a queueing limiter (see
+	// WithQueue) has no room left for the request.
+	ErrQueueFull = errors.New("rate limit queue full")
+
+	// ErrSelfLimited is passed to OnFallback when a request is decided by
+	// the fallback strategy because the limiter reached its own storage
+	// operation limit (see WithSelfLimits).
+	ErrSelfLimited = errors.New("limiter self-limit reached")
+
+	// ErrKeyBlocked is returned by Wait for a key of the limiter's
+	// denylist, which no amount of waiting lets through (see
+	// WithDenylist).
+	ErrKeyBlocked = errors.New("key blocked")
+
+	// ErrLimiterNotFound is returned when a Group has no limiter with the
+	// requested name.
+	ErrLimiterNotFound = errors.New("limiter not found")
 )
 
 // LimitExceededError is returned when a rate limit is exceeded and provides
@@ -79,12 +102,33 @@ type LimitExceededError struct {
 
 	// ResetAt is the absolute time when the rate limit resets
 	ResetAt time.Time
+
+	// Level is the name of the Hierarchy level that denied the request,
+	// or empty if the error doesn't come from a Hierarchy
+	Level string
+
+	// Rule is the name of the Composite rule that denied the request, or
+	// empty if the error doesn't come from a Composite
+	Rule string
+
+	// Dimension is the CostLimiter dimension that denied the request, or
+	// empty if the error doesn't come from a CostLimiter
+	Dimension Dimension
 }
 
 // Error implements the error interface.
 func (e *LimitExceededError) Error() string {
-	return fmt.Sprintf("rate limit exceeded for key %q: %d/%d requests used, retry after %s",
-		e.Key, e.Used, e.Limit, e.RetryAfter.Round(time.Second))
+	var where string
+	switch {
+	case e.Rule != "":
+		where = fmt.Sprintf(" by rule %q", e.Rule)
+	case e.Level != "":
+		where = fmt.Sprintf(" at level %q", e.Level)
+	case e.Dimension != "":
+		where = fmt.Sprintf(" on %s", e.Dimension)
+	}
+	return fmt.Sprintf("rate limit exceeded%s for key %q: %d/%d used, retry after %s",
+		where, e.Key, e.Used, e.Limit, e.RetryAfter.Round(time.Second))
 }
 
 // Is allows this error to be matched with errors.Is(err, ErrRateLimitExceeded)
@@ -186,6 +230,10 @@ func (e *StorageError) Unwrap() error {
 
 // wrapContextError wraps context errors to our custom error types.
 // This is an internal helper function.
+//
+// The result matches both ErrContextCanceled (or
+// ErrContextDeadlineExceeded) and the context package's error with
+// errors.Is, so callers can check either.
 func wrapContextError(err error) error {
 	if err == nil {
 		return nil
@@ -193,10 +241,42 @@ func wrapContextError(err error) error {
 
 	switch {
 	case errors.Is(err, context.Canceled):
-		return ErrContextCanceled
+		return &contextError{sentinel: ErrContextCanceled, cause: err}
 	case errors.Is(err, context.DeadlineExceeded):
-		return ErrContextDeadlineExceeded
+		return &contextError{sentinel: ErrContextDeadlineExceeded, cause: err}
 	default:
 		return err
 	}
 }
+
+// contextOr returns ctx's error, wrapped by wrapContextError, if ctx has
+// ended, and err otherwise. Storage errors caused by a canceled context
+// don't always wrap it, so the context itself is checked.
+func contextOr(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return wrapContextError(ctxErr)
+	}
+	return err
+}
+
+// contextError is a context error wrapped by wrapContextError.
+type contextError struct {
+	sentinel error
+	cause    error
+}
+
+// Error implements the error interface
+func (e *contextError) Error() string {
+	return e.sentinel.Error()
+}
+
+// Is allows matching with errors.Is(err, ErrContextCanceled) and
+// errors.Is(err, ErrContextDeadlineExceeded)
+func (e *contextError) Is(target error) bool {
+	return target == e.sentinel
+}
+
+// Unwrap returns the context package's error
+func (e *contextError) Unwrap() error {
+	return e.cause
+}
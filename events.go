@@ -0,0 +1,143 @@
+package flexlimit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Vipul984/flexlimit/metrics"
+)
+
+// EventType is what an Event reports. See Limiter.Events.
+type EventType string
+
+const (
+	// EventAllowed is a request allowed by the limiter.
+	EventAllowed EventType = "allowed"
+
+	// EventDenied is a request denied by the limiter.
+	EventDenied EventType = "denied"
+
+	// EventEvicted is a key dropped by an in-memory store of the limiter
+	// (see OnKeyEvicted).
+	EventEvicted EventType = "evicted"
+
+	// EventFallbackActivated is the fallback strategy taking over after a
+	// storage failure (see OnFallback).
+	EventFallbackActivated EventType = "fallback_activated"
+
+	// EventConfigReloaded is a limit changed by SetLimit or UpdateConfig.
+	EventConfigReloaded EventType = "config_reloaded"
+)
+
+// Event is something that happened in a limiter, as delivered by
+// Limiter.Events.
+type Event struct {
+	// Type is what happened
+	Type EventType
+
+	// At is when it happened
+	At time.Time
+
+	// Key is the rate limit key, for EventAllowed, EventDenied and
+	// EventEvicted
+	Key string
+
+	// Info describes the request, for EventAllowed and EventDenied, as
+	// passed to OnAllow and OnLimit
+	Info LimitInfo
+
+	// Err is the storage error, for EventFallbackActivated
+	Err error
+
+	// Rate, Window and Algorithm are the new limit, for
+	// EventConfigReloaded
+	Rate      int
+	Window    time.Duration
+	Algorithm string
+}
+
+// WithEventBuffer sets how many events Limiter.Events holds for a slow
+// receiver before dropping new ones.
+//
+// Default: 1024
+func WithEventBuffer(n int) Option {
+	return func(o *Options) {
+		o.eventBuffer = n
+	}
+}
+
+// eventStream is the channel of Limiter.Events.
+type eventStream struct {
+	mu     sync.RWMutex
+	ch     chan Event
+	closed bool
+}
+
+// Events returns a channel delivering the limiter's events: decisions,
+// evictions, fallback activations and limit changes, for observability
+// pipelines that would otherwise register a callback for each. Every call
+// returns the same channel; events are delivered from the first call on.
+//
+// Delivery never blocks the limiter: events arriving while the channel's
+// buffer (see WithEventBuffer) is full are dropped and counted in
+// metrics.EventsDropped. The channel is closed by Close, or by a Shutdown
+// that succeeds.
+//
+// Example:
+//
+//	go func() {
+//	    for e := range limiter.Events() {
+//	        if e.Type == flexlimit.EventDenied {
+//	            denials.WithLabelValues(e.Info.Algorithm).Inc()
+//	        }
+//	    }
+//	}()
+func (l *Limiter) Events() <-chan Event {
+	l.eventsOnce.Do(func() {
+		s := &eventStream{ch: make(chan Event, l.opts.eventBuffer)}
+		l.events.Store(s)
+		if l.closed.Load() {
+			s.close()
+		}
+	})
+	return l.events.Load().ch
+}
+
+// emit delivers e to the event stream s without blocking.
+func (l *Limiter) emit(s *eventStream, e Event) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+
+	select {
+	case s.ch <- e:
+	default:
+		l.opts.metrics.IncCounter(metrics.EventsDropped, l.labels)
+	}
+}
+
+// emitEvent delivers e to the event stream, if Events was called.
+func (l *Limiter) emitEvent(e Event) {
+	if s := l.events.Load(); s != nil {
+		l.emit(s, e)
+	}
+}
+
+// closeEvents closes the event stream, if Events was called.
+func (l *Limiter) closeEvents() {
+	if s := l.events.Load(); s != nil {
+		s.close()
+	}
+}
+
+// close closes the channel. Calling it more than once is a no-op.
+func (s *eventStream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.ch)
+	}
+}
@@ -0,0 +1,256 @@
+// Package export periodically copies rate limit usage to an analytics sink.
+//
+// An Exporter walks the limiters of a flexlimit.Group in the background,
+// reads each key's state without consuming anything, and writes the
+// snapshots in batches to a Sink, such as a JSON lines or CSV file for a
+// billing system to meter consumption. It never runs on the request path,
+// so analytics get usage data without querying the limiter's storage
+// directly. Reads go through Limiter.Usage, so they use the admin storage
+// of limiters configured with flexlimit.WithAdminStorage.
+//
+// Wrap the sink in a RetrySink to retry failed writes and dead-letter the
+// batches that can't be delivered.
+//
+// When several instances share one storage, set Config.Leader so only the
+// elected instance exports (see the leader package).
+//
+// Example:
+//
+//	f, err := os.OpenFile("usage.jsonl", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+//	if err != nil {
+//	    return err
+//	}
+//	exp, err := export.New(limits, export.NewJSONLinesSink(f), export.Config{
+//	    Interval: 5 * time.Minute,
+//	})
+//	if err != nil {
+//	    return err
+//	}
+//	defer exp.Close()
+package export
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Vipul984/flexlimit"
+)
+
+// Snapshot is the usage of one key at the time it was exported.
+type Snapshot struct {
+	// Limiter is the name of the limiter in the Group
+	Limiter string `json:"limiter"`
+
+	// Key is the rate limit key
+	Key string `json:"key"`
+
+	// Limit, Used and Remaining are the key's usage, as in flexlimit.State
+	Limit     int `json:"limit"`
+	Used      int `json:"used"`
+	Remaining int `json:"remaining"`
+
+	// ResetAt is when the key's limit resets
+	ResetAt time.Time `json:"reset_at"`
+
+	// Window is the limiter's window, in nanoseconds in JSON
+	Window time.Duration `json:"window_ns"`
+
+	// TakenAt is when the snapshot was read
+	TakenAt time.Time `json:"taken_at"`
+}
+
+// Config configures an Exporter.
+type Config struct {
+	// Interval is how often usage is exported. Default: 1 minute
+	Interval time.Duration
+
+	// Timeout bounds a single export run. Default: Interval
+	Timeout time.Duration
+
+	// BatchSize is the maximum number of snapshots passed to one
+	// Sink.Write call. Default: 500
+	BatchSize int
+
+	// Prefix restricts the export to keys starting with it. Default: all keys
+	Prefix string
+
+	// Pattern restricts the export to keys matching it, as in
+	// flexlimit.Limiter.Usage, such as "tenant:*", instead of Prefix.
+	// Default: all keys
+	Pattern string
+
+	// OnError is called with the error of a failed export run. The
+	// Exporter keeps running and tries again at the next interval.
+	OnError func(error)
+
+	// Leader restricts the background export to the instance it reports
+	// as leader, so a fleet sharing one storage exports usage once rather
+	// than once per instance (see leader.Elector). Export called directly
+	// always runs. Default: every instance exports
+	Leader Leader
+}
+
+// Leader reports whether this instance should run cluster-wide work.
+// It is implemented by *leader.Elector.
+type Leader interface {
+	IsLeader() bool
+}
+
+// Exporter periodically writes usage snapshots to a Sink.
+type Exporter struct {
+	group *flexlimit.Group
+	sink  Sink
+	cfg   Config
+
+	mu     sync.Mutex
+	closed bool
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// New creates an Exporter for the limiters in group and starts exporting
+// every cfg.Interval. Call Close to stop it.
+//
+// Returns an *flexlimit.InvalidConfigError if group or sink is nil, or a
+// Config field is negative.
+func New(group *flexlimit.Group, sink Sink, cfg Config) (*Exporter, error) {
+	if group == nil {
+		return nil, &flexlimit.InvalidConfigError{Field: "group", Value: group, Reason: "must not be nil"}
+	}
+	if sink == nil {
+		return nil, &flexlimit.InvalidConfigError{Field: "sink", Value: sink, Reason: "must not be nil"}
+	}
+	if cfg.Interval < 0 || cfg.Timeout < 0 || cfg.BatchSize < 0 {
+		return nil, &flexlimit.InvalidConfigError{Field: "config", Value: cfg, Reason: "durations and batch size cannot be negative"}
+	}
+
+	if cfg.Interval == 0 {
+		cfg.Interval = time.Minute
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = cfg.Interval
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = 500
+	}
+
+	e := &Exporter{
+		group: group,
+		sink:  sink,
+		cfg:   cfg,
+		stop:  make(chan struct{}),
+	}
+
+	e.wg.Add(1)
+	go e.run()
+	return e, nil
+}
+
+// Export exports the current usage of every key once, independently of
+// the background schedule.
+func (e *Exporter) Export(ctx context.Context) error {
+	batch := make([]Snapshot, 0, e.cfg.BatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := e.sink.Write(ctx, batch)
+		batch = make([]Snapshot, 0, e.cfg.BatchSize)
+		return err
+	}
+
+	var errs []error
+	for _, name := range e.group.Names() {
+		l, ok := e.group.Get(name)
+		if !ok {
+			continue
+		}
+
+		usage, err := l.Usage(ctx, e.pattern())
+		if err != nil {
+			errs = append(errs, err)
+			if ctx.Err() != nil {
+				return errors.Join(errs...)
+			}
+			continue
+		}
+
+		now := time.Now()
+		for _, st := range usage {
+			batch = append(batch, Snapshot{
+				Limiter:   name,
+				Key:       st.Key,
+				Limit:     st.Limit,
+				Used:      st.Used,
+				Remaining: st.Remaining,
+				ResetAt:   st.ResetAt,
+				Window:    st.Window,
+				TakenAt:   now,
+			})
+			if len(batch) == e.cfg.BatchSize {
+				if err := flush(); err != nil {
+					return errors.Join(append(errs, err)...)
+				}
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// pattern returns the Usage pattern of the keys to export.
+func (e *Exporter) pattern() string {
+	if e.cfg.Pattern != "" {
+		return e.cfg.Pattern
+	}
+	return e.cfg.Prefix + "*"
+}
+
+// Close stops the background export and waits for a run in progress to
+// finish. It does not close the sink. Calling Close more than once is a
+// no-op.
+func (e *Exporter) Close() error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	e.closed = true
+	close(e.stop)
+	e.mu.Unlock()
+
+	e.wg.Wait()
+	return nil
+}
+
+// run exports every interval until the Exporter is closed.
+func (e *Exporter) run() {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(e.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+		}
+
+		if e.cfg.Leader != nil && !e.cfg.Leader.IsLeader() {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), e.cfg.Timeout)
+		err := e.Export(ctx)
+		cancel()
+		if err != nil && e.cfg.OnError != nil {
+			e.cfg.OnError(err)
+		}
+	}
+}
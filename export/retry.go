@@ -0,0 +1,153 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Vipul984/flexlimit"
+)
+
+// RetryConfig configures a RetrySink.
+type RetryConfig struct {
+	// MaxAttempts is how many times a batch is written to the sink before
+	// it is dead-lettered, including the first. Default: 5
+	MaxAttempts int
+
+	// Backoff is the wait before the first retry. It doubles after every
+	// attempt, up to MaxBackoff. Default: 100 milliseconds
+	Backoff time.Duration
+
+	// MaxBackoff caps the wait between two attempts. Default: 10 seconds
+	MaxBackoff time.Duration
+
+	// DeadLetter receives the batches that could not be delivered, e.g. a
+	// JSONLinesSink appending to a local file to replay later, or a
+	// SinkFunc raising an alert. It is written to once, even if the
+	// context of the delivery is done. Default: undelivered batches are
+	// only reported by the returned error
+	DeadLetter Sink
+}
+
+// DeliveryError is returned by RetrySink.Write when a batch could not be
+// delivered, and could not be dead-lettered either.
+type DeliveryError struct {
+	// Rows is the size of the batch
+	Rows int
+
+	// Attempts is the number of writes made to the sink
+	Attempts int
+
+	// Err is the error of the last attempt
+	Err error
+
+	// DeadLetterErr is the error of the dead-letter sink, or nil if there
+	// is none
+	DeadLetterErr error
+}
+
+func (e *DeliveryError) Error() string {
+	msg := fmt.Sprintf("export: delivering %d rows failed after %d attempts: %v", e.Rows, e.Attempts, e.Err)
+	if e.DeadLetterErr != nil {
+		msg += fmt.Sprintf(" (dead letter: %v)", e.DeadLetterErr)
+	}
+	return msg
+}
+
+// Unwrap returns the errors of the sink and of the dead-letter sink.
+func (e *DeliveryError) Unwrap() []error {
+	if e.DeadLetterErr != nil {
+		return []error{e.Err, e.DeadLetterErr}
+	}
+	return []error{e.Err}
+}
+
+// RetrySink delivers batches at least once: it retries failed writes to
+// another sink with exponential backoff, and hands the batches that still
+// fail to a dead-letter sink, so usage and alerts aren't lost during a
+// downstream outage.
+//
+// The wrapped sink may see a batch more than once, for example when a
+// write fails after the destination stored it, so it should tolerate
+// duplicates.
+//
+// Example:
+//
+//	dlq, err := os.OpenFile("undelivered.jsonl", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+//	if err != nil {
+//	    return err
+//	}
+//	sink, err := export.NewRetrySink(warehouse, export.RetryConfig{
+//	    MaxAttempts: 8,
+//	    DeadLetter:  export.NewJSONLinesSink(dlq),
+//	})
+type RetrySink struct {
+	sink Sink
+	cfg  RetryConfig
+}
+
+// NewRetrySink wraps sink with retries.
+//
+// Returns an *flexlimit.InvalidConfigError if sink is nil or a RetryConfig
+// field is negative.
+func NewRetrySink(sink Sink, cfg RetryConfig) (*RetrySink, error) {
+	if sink == nil {
+		return nil, &flexlimit.InvalidConfigError{Field: "sink", Value: sink, Reason: "must not be nil"}
+	}
+	if cfg.MaxAttempts < 0 || cfg.Backoff < 0 || cfg.MaxBackoff < 0 {
+		return nil, &flexlimit.InvalidConfigError{Field: "retry", Value: cfg, Reason: "attempts and backoffs cannot be negative"}
+	}
+
+	if cfg.MaxAttempts == 0 {
+		cfg.MaxAttempts = 5
+	}
+	if cfg.Backoff == 0 {
+		cfg.Backoff = 100 * time.Millisecond
+	}
+	if cfg.MaxBackoff == 0 {
+		cfg.MaxBackoff = 10 * time.Second
+	}
+	return &RetrySink{sink: sink, cfg: cfg}, nil
+}
+
+// Write writes batch to the wrapped sink until it succeeds, MaxAttempts
+// is reached or ctx is done. An undelivered batch is passed to the
+// dead-letter sink; Write returns nil if that succeeds, and a
+// *DeliveryError otherwise.
+func (s *RetrySink) Write(ctx context.Context, batch []Snapshot) error {
+	backoff := s.cfg.Backoff
+	attempts := 0
+	var err error
+	for {
+		attempts++
+		if err = s.sink.Write(ctx, batch); err == nil {
+			return nil
+		}
+		if attempts == s.cfg.MaxAttempts || !sleep(ctx, backoff) {
+			break
+		}
+		backoff = min(2*backoff, s.cfg.MaxBackoff)
+	}
+
+	deliveryErr := &DeliveryError{Rows: len(batch), Attempts: attempts, Err: err}
+	if s.cfg.DeadLetter == nil {
+		return deliveryErr
+	}
+	if deliveryErr.DeadLetterErr = s.cfg.DeadLetter.Write(context.WithoutCancel(ctx), batch); deliveryErr.DeadLetterErr != nil {
+		return deliveryErr
+	}
+	return nil
+}
+
+// sleep waits for d, and reports false if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
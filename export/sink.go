@@ -0,0 +1,114 @@
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Sink receives batches of usage snapshots.
+//
+// Sink is shaped like the batch writers of analytics stores (BigQuery
+// inserters, warehouse loaders): each Write call carries up to
+// Config.BatchSize rows. Implementations must not keep batch after Write
+// returns.
+type Sink interface {
+	Write(ctx context.Context, batch []Snapshot) error
+}
+
+// SinkFunc adapts a function to the Sink interface.
+//
+// Example:
+//
+//	sink := export.SinkFunc(func(ctx context.Context, batch []export.Snapshot) error {
+//	    return inserter.Put(ctx, batch)
+//	})
+type SinkFunc func(ctx context.Context, batch []Snapshot) error
+
+// Write calls f(ctx, batch).
+func (f SinkFunc) Write(ctx context.Context, batch []Snapshot) error {
+	return f(ctx, batch)
+}
+
+// JSONLinesSink writes each snapshot as one line of JSON, e.g. to a file.
+type JSONLinesSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONLinesSink creates a sink writing to w. The caller owns w and
+// closes it after the Exporter.
+func NewJSONLinesSink(w io.Writer) *JSONLinesSink {
+	return &JSONLinesSink{enc: json.NewEncoder(w)}
+}
+
+// Write appends batch to the underlying writer.
+func (s *JSONLinesSink) Write(ctx context.Context, batch []Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range batch {
+		if err := s.enc.Encode(&batch[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// csvHeader is the header row written by CSVSink.
+var csvHeader = []string{"limiter", "key", "limit", "used", "remaining", "reset_at", "window_ns", "taken_at"}
+
+// CSVSink writes snapshots as CSV rows, after a header row, e.g. to a
+// file loaded by a billing system. Times are in RFC 3339 format with
+// nanoseconds.
+type CSVSink struct {
+	mu     sync.Mutex
+	w      *csv.Writer
+	header bool // header row written
+}
+
+// NewCSVSink creates a sink writing to w, starting with a header row.
+// The caller owns w and closes it after the Exporter.
+func NewCSVSink(w io.Writer) *CSVSink {
+	return &CSVSink{w: csv.NewWriter(w)}
+}
+
+// NewCSVSinkNoHeader creates a sink writing to w without a header row,
+// e.g. to append to a file that already has one.
+func NewCSVSinkNoHeader(w io.Writer) *CSVSink {
+	return &CSVSink{w: csv.NewWriter(w), header: true}
+}
+
+// Write appends batch to the underlying writer.
+func (s *CSVSink) Write(ctx context.Context, batch []Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.header {
+		if err := s.w.Write(csvHeader); err != nil {
+			return err
+		}
+		s.header = true
+	}
+	for _, snap := range batch {
+		err := s.w.Write([]string{
+			snap.Limiter,
+			snap.Key,
+			strconv.Itoa(snap.Limit),
+			strconv.Itoa(snap.Used),
+			strconv.Itoa(snap.Remaining),
+			snap.ResetAt.Format(time.RFC3339Nano),
+			strconv.FormatInt(int64(snap.Window), 10),
+			snap.TakenAt.Format(time.RFC3339Nano),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	s.w.Flush()
+	return s.w.Error()
+}
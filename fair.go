@@ -0,0 +1,233 @@
+package flexlimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Vipul984/flexlimit/clock"
+)
+
+// fairScale is the number of share units per request, so that costs
+// scaled by fractional shares stay precise as integers.
+const fairScale = 1000
+
+// FairConfig configures how a Fair limiter divides its limit.
+type FairConfig struct {
+	// Weights are the tenants' weights. A tenant with twice the weight of
+	// another gets twice its share
+	Weights map[string]float64
+
+	// DefaultWeight is the weight of tenants missing from Weights.
+	// Default: 1
+	DefaultWeight float64
+}
+
+// Fair shares one global limit across tenants in proportion to their
+// weights, so that a noisy tenant can't consume the whole budget.
+//
+// Each tenant gets a share of the limit equal to its weight divided by the
+// total weight of the tenants active in the last window. Idle tenants
+// don't hold capacity back: with two active tenants of equal weight, each
+// can use half the limit; when one goes quiet, the other can use all of
+// it. The total stays within the global limit.
+//
+// Shares are enforced with a token bucket per tenant, whatever algorithm
+// the options select for the global limit. Which tenants are active is
+// tracked per process, so instances sharing storage agree on the global
+// limit but compute shares from the traffic they see.
+//
+// Use it in front of, not instead of, per-tenant caps: a request should
+// pass both the tenant's own limiter and the Fair limiter.
+//
+// Example:
+//
+//	fair, err := flexlimit.NewFair(10000, time.Minute, flexlimit.FairConfig{
+//	    Weights: map[string]float64{"enterprise": 4, "pro": 2},
+//	})
+//	...
+//	allowed, err := fair.Allow(ctx, tenantID)
+type Fair struct {
+	global *Limiter
+	shares *Limiter
+	window time.Duration
+	clock  clock.Clock
+
+	mu            sync.Mutex
+	weights       map[string]float64
+	defaultWeight float64
+
+	// lastSeen holds the active tenants; active is the sum of their
+	// weights. Tenants idle for a window are dropped every quarter window
+	lastSeen map[string]time.Time
+	active   float64
+	swept    time.Time
+}
+
+// NewFair creates a Fair limiter allowing rate requests per window in
+// total. opts configure the underlying limiters as for New; both use the
+// same storage.
+//
+// Returns an *InvalidConfigError if a weight is not positive, or if New
+// would reject rate, window or opts.
+func NewFair(rate int, window time.Duration, cfg FairConfig, opts ...Option) (*Fair, error) {
+	if cfg.DefaultWeight == 0 {
+		cfg.DefaultWeight = 1
+	}
+	if cfg.DefaultWeight < 0 {
+		return nil, &InvalidConfigError{Field: "default_weight", Value: cfg.DefaultWeight, Reason: "must be positive"}
+	}
+
+	weights := make(map[string]float64, len(cfg.Weights))
+	for tenant, w := range cfg.Weights {
+		if w <= 0 {
+			return nil, &InvalidConfigError{Field: "weight", Value: w, Reason: "must be positive"}
+		}
+		weights[tenant] = w
+	}
+
+	global, err := New(rate, window, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	shareOpts := append(slices.Clone(opts), func(o *Options) {
+		o.algorithm, o.algorithmInstance = string(TokenBucket), nil
+		o.burstSize *= fairScale
+		o.windowBuckets = 0
+		o.queueSize = 0
+		o.gracePeriod = 0
+		o.advisor = nil
+	})
+	shares, err := New(rate*fairScale, window, shareOpts...)
+	if err != nil {
+		global.Close()
+		return nil, err
+	}
+
+	return &Fair{
+		global:        global,
+		shares:        shares,
+		window:        window,
+		clock:         global.clock,
+		weights:       weights,
+		defaultWeight: cfg.DefaultWeight,
+		lastSeen:      make(map[string]time.Time),
+	}, nil
+}
+
+// Allow reports whether a request from tenant is allowed.
+func (f *Fair) Allow(ctx context.Context, tenant string) (bool, error) {
+	return f.AllowN(ctx, tenant, 1)
+}
+
+// AllowN reports whether a request from tenant costing n is allowed,
+// consuming n from both the tenant's share and the global limit if it is.
+func (f *Fair) AllowN(ctx context.Context, tenant string, n int) (bool, error) {
+	if err := f.global.checkCall(ctx, n); err != nil {
+		return false, err
+	}
+
+	share := f.observe(tenant)
+	cost := int(math.Ceil(float64(n) * fairScale / share))
+
+	shareKey := "fair:tenant:" + tenant
+	allowed, err := f.shares.AllowN(ctx, shareKey, cost)
+	if err != nil || !allowed {
+		return false, err
+	}
+
+	allowed, err = f.global.AllowN(ctx, "fair:global", n)
+	if err != nil || !allowed {
+		_ = f.shares.Refund(context.WithoutCancel(ctx), shareKey, cost)
+	}
+	return allowed, err
+}
+
+// Share returns the fraction of the global limit tenant currently gets,
+// given the tenants active in the last window.
+func (f *Fair) Share(tenant string) float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.share(tenant, f.clock.Now())
+}
+
+// SetWeight changes the weight of tenant. It takes effect immediately.
+//
+// Returns an *InvalidConfigError if w is not positive.
+func (f *Fair) SetWeight(tenant string, w float64) error {
+	if w <= 0 {
+		return &InvalidConfigError{Field: "weight", Value: w, Reason: "must be positive"}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.lastSeen[tenant]; ok {
+		f.active += w - f.weight(tenant)
+	}
+	f.weights[tenant] = w
+	return nil
+}
+
+// Close closes the underlying limiters.
+func (f *Fair) Close() error {
+	return errors.Join(f.shares.Close(), f.global.Close())
+}
+
+// observe marks tenant active and returns its share.
+func (f *Fair) observe(tenant string) float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.clock.Now()
+	share := f.share(tenant, now)
+	if _, ok := f.lastSeen[tenant]; !ok {
+		f.active += f.weight(tenant)
+	}
+	f.lastSeen[tenant] = now
+	return share
+}
+
+// share returns tenant's weight over the total weight of tenant and the
+// active tenants. Must be called with f.mu held.
+func (f *Fair) share(tenant string, now time.Time) float64 {
+	f.sweep(now)
+
+	own := f.weight(tenant)
+	total := f.active
+	if _, ok := f.lastSeen[tenant]; !ok {
+		total += own
+	}
+	return own / total
+}
+
+// sweep drops tenants idle for a window, at most every quarter window.
+// Must be called with f.mu held.
+func (f *Fair) sweep(now time.Time) {
+	if now.Sub(f.swept) < f.window/4 {
+		return
+	}
+	f.swept = now
+
+	f.active = 0
+	for t, seen := range f.lastSeen {
+		if now.Sub(seen) > f.window {
+			delete(f.lastSeen, t)
+			continue
+		}
+		f.active += f.weight(t)
+	}
+}
+
+// weight returns the weight of tenant. Must be called with f.mu held.
+func (f *Fair) weight(tenant string) float64 {
+	if w, ok := f.weights[tenant]; ok {
+		return w
+	}
+	return f.defaultWeight
+}
@@ -0,0 +1,176 @@
+// Package flexlimitdemo is a runnable server that wires the features of
+// flexlimit together: a Composite of rules over a Group of limiters,
+// optional Redis storage with local fallback, metrics in the Prometheus
+// text format, the adminapi handler and an HTTP middleware that answers
+// 429 with RateLimit headers.
+//
+// It serves as living documentation: each Scenario is a small, complete
+// rate limiting policy. It is also importable, so integration tests can
+// start a Server against any storage and drive the whole feature surface
+// over HTTP. The cmd/flexlimitdemo command runs it from the command line.
+//
+// Routes:
+//
+//	/            the rate limited demo endpoint
+//	/metrics     counters in the Prometheus text format
+//	/admin/      the adminapi handler, if Config.AdminToken is set
+//
+// Example:
+//
+//	srv, err := flexlimitdemo.New(flexlimitdemo.Config{
+//	    RedisAddr:  "localhost:6379",
+//	    AdminToken: os.Getenv("ADMIN_TOKEN"),
+//	}, flexlimitdemo.APIGateway())
+//	if err != nil {
+//	    return err
+//	}
+//	defer srv.Close()
+//	http.ListenAndServe(":8080", srv)
+package flexlimitdemo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Vipul984/flexlimit"
+	"github.com/Vipul984/flexlimit/adminapi"
+	"github.com/Vipul984/flexlimit/clientip"
+	"github.com/Vipul984/flexlimit/storage"
+	"github.com/Vipul984/flexlimit/storage/redis"
+)
+
+// Config configures a Server.
+type Config struct {
+	// Storage holds rate limit state. If nil, the Server connects to
+	// RedisAddr, or keeps state in memory if that is empty too. The
+	// caller keeps ownership of Storage
+	Storage storage.Storage
+
+	// RedisAddr is the Redis address used when Storage is nil
+	RedisAddr string
+
+	// AdminToken enables the admin API under /admin/, protected by this
+	// bearer token. If empty, the admin API is not served
+	AdminToken string
+
+	// TrustedProxies are the addresses or CIDR ranges of the proxies in
+	// front of the Server, whose X-Forwarded-For headers give the client
+	// address (see clientip.Resolver). If empty, clients are keyed by the
+	// connection's peer address
+	TrustedProxies []string
+
+	// Options are applied to every limiter after the scenario's own
+	Options []flexlimit.Option
+}
+
+// Server serves a Scenario over HTTP. Create one with New and release it
+// with Close.
+type Server struct {
+	scenario Scenario
+	group    *flexlimit.Group
+	policy   *flexlimit.Composite
+	metrics  *Metrics
+	mux      *http.ServeMux
+
+	// store is the storage the Server created, closed by Close
+	store storage.Storage
+}
+
+// New creates a Server enforcing scenario.
+//
+// Returns an error if Redis can't be reached or the scenario's limits or
+// rules are invalid.
+func New(cfg Config, scenario Scenario) (*Server, error) {
+	s := &Server{
+		scenario: scenario,
+		group:    flexlimit.NewGroup(),
+		metrics:  NewMetrics(),
+		mux:      http.NewServeMux(),
+	}
+
+	store := cfg.Storage
+	if store == nil && cfg.RedisAddr != "" {
+		rs, err := redis.New(storage.Config{Backend: "redis", RedisAddr: cfg.RedisAddr})
+		if err != nil {
+			return nil, fmt.Errorf("flexlimitdemo: connect to redis: %w", err)
+		}
+		store, s.store = rs, rs
+	}
+
+	opts := []flexlimit.Option{flexlimit.WithMetrics(s.metrics)}
+	if store != nil {
+		opts = append(opts,
+			flexlimit.WithStorage(store),
+			flexlimit.WithFallback(flexlimit.LocalMemory),
+		)
+	}
+	opts = append(opts, cfg.Options...)
+
+	proxies, err := clientip.New(cfg.TrustedProxies)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := s.init(opts); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	s.mux.Handle("/", Middleware(s.policy, AttributesBehind(proxies))(http.HandlerFunc(s.hello)))
+	s.mux.Handle("/metrics", s.metrics)
+	if cfg.AdminToken != "" {
+		admin, err := adminapi.New(s.group, cfg.AdminToken)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.mux.Handle("/admin/", http.StripPrefix("/admin", admin))
+	}
+	return s, nil
+}
+
+// init registers the scenario's limiters and builds its policy.
+func (s *Server) init(opts []flexlimit.Option) error {
+	for _, name := range s.scenario.limitNames() {
+		if _, err := s.group.AddConfig(name, s.scenario.Limits[name], opts...); err != nil {
+			return fmt.Errorf("flexlimitdemo: limit %q: %w", name, err)
+		}
+	}
+
+	policy, err := flexlimit.NewComposite(s.scenario.Rules(s.group)...)
+	if err != nil {
+		return fmt.Errorf("flexlimitdemo: scenario %q: %w", s.scenario.Name, err)
+	}
+	s.policy = policy
+	return nil
+}
+
+// ServeHTTP serves the Server's routes.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Group returns the limiters of the scenario, by the names of its Limits.
+func (s *Server) Group() *flexlimit.Group {
+	return s.group
+}
+
+// Metrics returns the Server's metrics collector.
+func (s *Server) Metrics() *Metrics {
+	return s.metrics
+}
+
+// Close closes the limiters and the storage the Server created.
+func (s *Server) Close() error {
+	err := s.group.Close()
+	if s.store != nil {
+		err = errors.Join(err, s.store.Close())
+	}
+	return err
+}
+
+// hello is the demo endpoint behind the rate limiting middleware.
+func (s *Server) hello(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, "ok: %s %s (scenario %s)\n", r.Method, r.URL.Path, s.scenario.Name)
+}
@@ -0,0 +1,139 @@
+package flexlimitdemo
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Vipul984/flexlimit/metrics"
+)
+
+var _ metrics.Collector = (*Metrics)(nil)
+
+// Metrics is a metrics.Collector that keeps counters and duration
+// summaries in memory and serves them in the Prometheus text exposition
+// format, so the demo can be scraped without a metrics library.
+type Metrics struct {
+	mu        sync.Mutex
+	counters  map[series]float64
+	durations map[series]*summary
+}
+
+// series is a metric name with its formatted labels.
+type series struct {
+	name   string
+	labels string
+}
+
+// summary is the sum and count of duration observations.
+type summary struct {
+	sum   float64
+	count uint64
+}
+
+// NewMetrics creates an empty Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		counters:  make(map[series]float64),
+		durations: make(map[series]*summary),
+	}
+}
+
+// IncCounter increments the named counter.
+func (m *Metrics) IncCounter(name string, labels metrics.Labels) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.counters[series{name, formatLabels(labels)}]++
+}
+
+// ObserveDuration records d in the named summary.
+func (m *Metrics) ObserveDuration(name string, d time.Duration, labels metrics.Labels) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := series{name, formatLabels(labels)}
+	s := m.durations[key]
+	if s == nil {
+		s = &summary{}
+		m.durations[key] = s
+	}
+	s.sum += d.Seconds()
+	s.count++
+}
+
+// Counter returns the value of the named counter for labels.
+func (m *Metrics) Counter(name string, labels metrics.Labels) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.counters[series{name, formatLabels(labels)}]
+}
+
+// ServeHTTP writes every metric in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	typed := make(map[string]bool)
+	for _, s := range sortedSeries(m.counters) {
+		if !typed[s.name] {
+			fmt.Fprintf(w, "# TYPE %s counter\n", s.name)
+			typed[s.name] = true
+		}
+		fmt.Fprintf(w, "%s%s %g\n", s.name, s.labels, m.counters[s])
+	}
+	for _, s := range sortedSeries(m.durations) {
+		if !typed[s.name] {
+			fmt.Fprintf(w, "# TYPE %s summary\n", s.name)
+			typed[s.name] = true
+		}
+		d := m.durations[s]
+		fmt.Fprintf(w, "%s_sum%s %g\n", s.name, s.labels, d.sum)
+		fmt.Fprintf(w, "%s_count%s %d\n", s.name, s.labels, d.count)
+	}
+}
+
+// sortedSeries returns the keys of m ordered by name, then labels.
+func sortedSeries[V any](m map[series]V) []series {
+	keys := make([]series, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].name != keys[j].name {
+			return keys[i].name < keys[j].name
+		}
+		return keys[i].labels < keys[j].labels
+	})
+	return keys
+}
+
+// formatLabels formats labels as `{a="1",b="2"}`, or "" if there are none.
+func formatLabels(labels metrics.Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", name, labels[name])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
@@ -0,0 +1,77 @@
+package flexlimitdemo
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Vipul984/flexlimit"
+	"github.com/Vipul984/flexlimit/clientip"
+	"github.com/Vipul984/flexlimit/httpheaders"
+)
+
+// UserHeader is the request header RequestAttributes reads the user from.
+// A real server would take the user from its authentication instead.
+const UserHeader = "X-User"
+
+// RequestAttributes describes r for a Composite: "ip" is the client
+// address, "subnet" its prefix under flexlimit.DefaultIPAggregation,
+// "user" the UserHeader, "method" and "path" come from the request line.
+func RequestAttributes(r *http.Request) flexlimit.Attributes {
+	return attributes(r, &clientip.Resolver{})
+}
+
+// AttributesBehind is RequestAttributes for a server behind proxies:
+// "ip" is the client address found by proxies.
+func AttributesBehind(proxies *clientip.Resolver) func(*http.Request) flexlimit.Attributes {
+	return func(r *http.Request) flexlimit.Attributes {
+		return attributes(r, proxies)
+	}
+}
+
+// attributes describes r, finding the client address with proxies.
+func attributes(r *http.Request, proxies *clientip.Resolver) flexlimit.Attributes {
+	ip := proxies.ClientIP(r)
+	return flexlimit.Attributes{
+		"ip":     ip,
+		"subnet": flexlimit.DefaultIPAggregation.Aggregate(ip),
+		"user":   r.Header.Get(UserHeader),
+		"method": r.Method,
+		"path":   r.URL.Path,
+	}
+}
+
+// Middleware rate limits requests with policy, describing each request
+// with attrs.
+//
+// Denied requests get 429 Too Many Requests with Retry-After and the
+// RateLimit header fields of the rule that denied them. If a limiter
+// fails, the request gets 503 Service Unavailable; storage failures are
+// handled by each limiter's fallback strategy and don't get here.
+func Middleware(policy *flexlimit.Composite, attrs func(*http.Request) flexlimit.Attributes) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			err := policy.Allow(r.Context(), attrs(r))
+			if err == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var limitErr *flexlimit.LimitExceededError
+			if !errors.As(err, &limitErr) {
+				http.Error(w, "rate limiter unavailable", http.StatusServiceUnavailable)
+				return
+			}
+
+			httpheaders.Set(w.Header(), httpheaders.Quota{
+				Limit:     limitErr.Limit,
+				Remaining: limitErr.Limit - limitErr.Used,
+				ResetIn:   limitErr.RetryAfter,
+				Window:    limitErr.Window,
+			})
+			retryAfter := int(limitErr.RetryAfter.Seconds() + 0.999)
+			w.Header().Set("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+			http.Error(w, "too many requests (rule "+limitErr.Rule+")", http.StatusTooManyRequests)
+		})
+	}
+}
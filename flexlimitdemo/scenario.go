@@ -0,0 +1,115 @@
+package flexlimitdemo
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/Vipul984/flexlimit"
+)
+
+// Scenario is a complete rate limiting policy: a set of named limits and
+// the Composite rules that apply them to requests.
+type Scenario struct {
+	// Name identifies the scenario (e.g., on the command line)
+	Name string
+
+	// Description says what the scenario demonstrates
+	Description string
+
+	// Limits are the limiters to create, by name. They are registered in
+	// the Server's Group, so the admin API can inspect and change them
+	Limits map[string]flexlimit.Config
+
+	// Rules returns the rules of the scenario's Composite, over the
+	// limiters of group. Rule keys should carry a prefix naming their
+	// limit, so that limiters sharing a storage don't share keys
+	Rules func(group *flexlimit.Group) []flexlimit.Rule
+}
+
+// limitNames returns the names of s.Limits in order.
+func (s Scenario) limitNames() []string {
+	names := make([]string, 0, len(s.Limits))
+	for name := range s.Limits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Scenarios returns the built-in scenarios.
+func Scenarios() []Scenario {
+	return []Scenario{APIGateway(), Login()}
+}
+
+// Lookup returns the built-in scenario called name.
+func Lookup(name string) (Scenario, bool) {
+	for _, s := range Scenarios() {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Scenario{}, false
+}
+
+// APIGateway limits every client IPv4 address or IPv6 /64 and, for
+// authenticated requests, every user, with writes costing five times as
+// much as reads against the user's budget.
+func APIGateway() Scenario {
+	return Scenario{
+		Name:        "gateway",
+		Description: "per-IP and per-user limits, writes cost 5",
+		Limits: map[string]flexlimit.Config{
+			"ip":   flexlimit.PerSecond(20).WithBurst(40),
+			"user": flexlimit.PerMinute(300),
+		},
+		Rules: func(g *flexlimit.Group) []flexlimit.Rule {
+			ip, _ := g.Get("ip")
+			user, _ := g.Get("user")
+			return []flexlimit.Rule{
+				{Name: "ip", Limiter: ip, Key: attr("subnet", "ip")},
+				{Name: "user_reads", Limiter: user, Key: attr("user", "user")},
+				{Name: "user_writes", Limiter: user, Cost: 5, Key: func(a flexlimit.Attributes) string {
+					if a["method"] == http.MethodGet || a["method"] == http.MethodHead {
+						return ""
+					}
+					return attr("user", "user")(a)
+				}},
+			}
+		},
+	}
+}
+
+// Login protects a login endpoint from credential stuffing: each IP
+// address and each account get a few attempts per window, counted with a
+// sliding window so the limit can't be doubled at window boundaries.
+func Login() Scenario {
+	return Scenario{
+		Name:        "login",
+		Description: "sliding window limits on login attempts per IP and account",
+		Limits: map[string]flexlimit.Config{
+			"login_ip":      flexlimit.Per(20, 10*time.Minute).WithAlgorithm(flexlimit.SlidingWindow),
+			"login_account": flexlimit.Per(5, 10*time.Minute).WithAlgorithm(flexlimit.SlidingWindow),
+		},
+		Rules: func(g *flexlimit.Group) []flexlimit.Rule {
+			ip, _ := g.Get("login_ip")
+			account, _ := g.Get("login_account")
+			return []flexlimit.Rule{
+				{Name: "ip", Limiter: ip, Key: attr("ip", "login_ip")},
+				{Name: "account", Limiter: account, Key: attr("user", "login_account")},
+			}
+		},
+	}
+}
+
+// attr returns a rule key function keying by the attribute name, with
+// prefix in front. The rule doesn't apply to requests without the
+// attribute.
+func attr(name, prefix string) func(flexlimit.Attributes) string {
+	return func(a flexlimit.Attributes) string {
+		if v := a[name]; v != "" {
+			return prefix + ":" + v
+		}
+		return ""
+	}
+}
@@ -0,0 +1,208 @@
+// Package flexlimitpb encodes flexlimit's key states and decisions as the
+// protobuf messages of proto/flexlimit/v1/flexlimit.proto, so they can be
+// exchanged with services in other languages, such as sidecars or control
+// planes, using code generated from the same schema.
+//
+// State stored by the backends is encoded by storage.ProtoCodec, selected
+// with the "protobuf" codec of storage.Config.
+//
+// Example:
+//
+//	limiter, err := flexlimit.New(100, time.Minute,
+//	    flexlimit.OnLimit(func(info flexlimit.LimitInfo) {
+//	        msg, err := flexlimitpb.MarshalLimitInfo(info)
+//	        if err != nil {
+//	            return
+//	        }
+//	        producer.Send("ratelimit.denied", msg)
+//	    }),
+//	)
+package flexlimitpb
+
+import (
+	"github.com/Vipul984/flexlimit"
+	"github.com/Vipul984/flexlimit/internal/pbwire"
+)
+
+// KeyState field numbers
+const (
+	stateKey           = 1
+	stateLimit         = 2
+	stateUsed          = 3
+	stateRemaining     = 4
+	stateResetAt       = 5
+	stateResetIn       = 6
+	stateLastRequestAt = 7
+	stateWindow        = 8
+	stateStatus        = 9
+	stateBannedUntil   = 10
+	stateOffenses      = 11
+)
+
+// LimitInfo field numbers
+const (
+	infoKey       = 1
+	infoAllowed   = 2
+	infoLimit     = 3
+	infoUsed      = 4
+	infoRemaining = 5
+	infoResetAt   = 6
+	infoResetIn   = 7
+	infoCost      = 8
+	infoAlgorithm = 9
+	infoWarning   = 10
+	infoEnforceAt = 11
+	infoMetadata  = 12
+	infoTraceID   = 13
+	infoSpanID    = 14
+	infoBaggage   = 15
+	infoShed      = 16
+	infoPreempted = 17
+	infoBlocked   = 18
+)
+
+// MarshalState encodes st as a flexlimit.v1.KeyState message.
+func MarshalState(st *flexlimit.State) ([]byte, error) {
+	var b []byte
+	b = pbwire.AppendString(b, stateKey, st.Key)
+	b = pbwire.AppendInt64(b, stateLimit, int64(st.Limit))
+	b = pbwire.AppendInt64(b, stateUsed, int64(st.Used))
+	b = pbwire.AppendInt64(b, stateRemaining, int64(st.Remaining))
+	b = pbwire.AppendTime(b, stateResetAt, st.ResetAt)
+	b = pbwire.AppendDuration(b, stateResetIn, st.ResetIn)
+	b = pbwire.AppendTime(b, stateLastRequestAt, st.LastRequestAt)
+	b = pbwire.AppendDuration(b, stateWindow, st.Window)
+	b = pbwire.AppendString(b, stateStatus, string(st.Status))
+	b = pbwire.AppendTime(b, stateBannedUntil, st.BannedUntil)
+	b = pbwire.AppendInt64(b, stateOffenses, int64(st.Offenses))
+	return b, nil
+}
+
+// UnmarshalState decodes a flexlimit.v1.KeyState message.
+func UnmarshalState(data []byte) (*flexlimit.State, error) {
+	var st flexlimit.State
+	err := pbwire.Range(data, func(f pbwire.Field) error {
+		var err error
+		switch f.Num {
+		case stateKey:
+			st.Key, err = f.String()
+		case stateLimit:
+			st.Limit, err = intField(f)
+		case stateUsed:
+			st.Used, err = intField(f)
+		case stateRemaining:
+			st.Remaining, err = intField(f)
+		case stateResetAt:
+			st.ResetAt, err = f.Time()
+		case stateResetIn:
+			st.ResetIn, err = f.Duration()
+		case stateLastRequestAt:
+			st.LastRequestAt, err = f.Time()
+		case stateWindow:
+			st.Window, err = f.Duration()
+		case stateStatus:
+			var status string
+			status, err = f.String()
+			st.Status = flexlimit.KeyStatus(status)
+		case stateBannedUntil:
+			st.BannedUntil, err = f.Time()
+		case stateOffenses:
+			st.Offenses, err = intField(f)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// MarshalLimitInfo encodes info as a flexlimit.v1.LimitInfo message.
+// Metadata values must be JSON-like (see structpb.NewStruct).
+func MarshalLimitInfo(info flexlimit.LimitInfo) ([]byte, error) {
+	var b []byte
+	b = pbwire.AppendString(b, infoKey, info.Key)
+	b = pbwire.AppendBool(b, infoAllowed, info.Allowed)
+	b = pbwire.AppendInt64(b, infoLimit, int64(info.Limit))
+	b = pbwire.AppendInt64(b, infoUsed, int64(info.Used))
+	b = pbwire.AppendInt64(b, infoRemaining, int64(info.Remaining))
+	b = pbwire.AppendTime(b, infoResetAt, info.ResetAt)
+	b = pbwire.AppendDuration(b, infoResetIn, info.ResetIn)
+	b = pbwire.AppendInt64(b, infoCost, int64(info.Cost))
+	b = pbwire.AppendString(b, infoAlgorithm, info.Algorithm)
+	b = pbwire.AppendBool(b, infoWarning, info.Warning)
+	b = pbwire.AppendTime(b, infoEnforceAt, info.EnforceAt)
+	b, err := pbwire.AppendStruct(b, infoMetadata, info.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	b = pbwire.AppendString(b, infoTraceID, info.TraceID)
+	b = pbwire.AppendString(b, infoSpanID, info.SpanID)
+	b = pbwire.AppendStringMap(b, infoBaggage, info.Baggage)
+	b = pbwire.AppendBool(b, infoShed, info.Shed)
+	b = pbwire.AppendBool(b, infoPreempted, info.Preempted)
+	return pbwire.AppendBool(b, infoBlocked, info.Blocked), nil
+}
+
+// UnmarshalLimitInfo decodes a flexlimit.v1.LimitInfo message.
+func UnmarshalLimitInfo(data []byte) (flexlimit.LimitInfo, error) {
+	var info flexlimit.LimitInfo
+	err := pbwire.Range(data, func(f pbwire.Field) error {
+		var err error
+		switch f.Num {
+		case infoKey:
+			info.Key, err = f.String()
+		case infoAllowed:
+			info.Allowed, err = f.Bool()
+		case infoLimit:
+			info.Limit, err = intField(f)
+		case infoUsed:
+			info.Used, err = intField(f)
+		case infoRemaining:
+			info.Remaining, err = intField(f)
+		case infoResetAt:
+			info.ResetAt, err = f.Time()
+		case infoResetIn:
+			info.ResetIn, err = f.Duration()
+		case infoCost:
+			info.Cost, err = intField(f)
+		case infoAlgorithm:
+			info.Algorithm, err = f.String()
+		case infoWarning:
+			info.Warning, err = f.Bool()
+		case infoEnforceAt:
+			info.EnforceAt, err = f.Time()
+		case infoMetadata:
+			info.Metadata, err = f.Struct()
+		case infoTraceID:
+			info.TraceID, err = f.String()
+		case infoSpanID:
+			info.SpanID, err = f.String()
+		case infoBaggage:
+			var k, v string
+			if k, v, err = f.StringMapEntry(); err == nil {
+				if info.Baggage == nil {
+					info.Baggage = make(map[string]string)
+				}
+				info.Baggage[k] = v
+			}
+		case infoShed:
+			info.Shed, err = f.Bool()
+		case infoPreempted:
+			info.Preempted, err = f.Bool()
+		case infoBlocked:
+			info.Blocked, err = f.Bool()
+		}
+		return err
+	})
+	if err != nil {
+		return flexlimit.LimitInfo{}, err
+	}
+	return info, nil
+}
+
+// intField decodes an int64 field into an int.
+func intField(f pbwire.Field) (int, error) {
+	v, err := f.Int64()
+	return int(v), err
+}
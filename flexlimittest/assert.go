@@ -0,0 +1,87 @@
+package flexlimittest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Vipul984/flexlimit"
+)
+
+// Allower is the method the Assert functions call, implemented by
+// *flexlimit.Limiter and the fake Limiter.
+type Allower interface {
+	Allow(ctx context.Context, key string) (bool, error)
+}
+
+// AssertAllowed fails t unless a request for key is allowed.
+func AssertAllowed(t testing.TB, l Allower, key string) {
+	t.Helper()
+
+	allowed, err := l.Allow(context.Background(), key)
+	switch {
+	case err != nil:
+		t.Errorf("Allow(%q): unexpected error: %v", key, err)
+	case !allowed:
+		t.Errorf("Allow(%q) denied, want allowed", key)
+	}
+}
+
+// AssertDenied fails t unless a request for key is denied.
+func AssertDenied(t testing.TB, l Allower, key string) {
+	t.Helper()
+
+	allowed, err := l.Allow(context.Background(), key)
+	switch {
+	case err != nil:
+		t.Errorf("Allow(%q): unexpected error: %v", key, err)
+	case allowed:
+		t.Errorf("Allow(%q) allowed, want denied", key)
+	}
+}
+
+// AssertLimit fails t unless exactly n requests for key are allowed
+// before one is denied. It makes n+1 requests, or fewer if one fails.
+func AssertLimit(t testing.TB, l Allower, key string, n int) {
+	t.Helper()
+
+	for i := range n {
+		allowed, err := l.Allow(context.Background(), key)
+		if err != nil {
+			t.Errorf("Allow(%q) #%d: unexpected error: %v", key, i+1, err)
+			return
+		}
+		if !allowed {
+			t.Errorf("Allow(%q) denied after %d requests, want %d allowed", key, i, n)
+			return
+		}
+	}
+
+	allowed, err := l.Allow(context.Background(), key)
+	switch {
+	case err != nil:
+		t.Errorf("Allow(%q) #%d: unexpected error: %v", key, n+1, err)
+	case allowed:
+		t.Errorf("Allow(%q) allowed more than %d requests", key, n)
+	}
+}
+
+// AssertLimitExceeded fails t unless err reports an exceeded rate limit,
+// matching flexlimit.ErrRateLimitExceeded.
+func AssertLimitExceeded(t testing.TB, err error) {
+	t.Helper()
+
+	if !errors.Is(err, flexlimit.ErrRateLimitExceeded) {
+		t.Errorf("error = %v, want a rate limit exceeded error", err)
+	}
+}
+
+// AssertNotLimited fails t if err reports an exceeded rate limit. Other
+// errors pass.
+func AssertNotLimited(t testing.TB, err error) {
+	t.Helper()
+
+	if errors.Is(err, flexlimit.ErrRateLimitExceeded) {
+		t.Errorf("error = %v, want no rate limit exceeded error", err)
+	}
+}
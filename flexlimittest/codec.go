@@ -0,0 +1,134 @@
+package flexlimittest
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+// SampleStates returns states covering the fields of storage.State, as
+// each algorithm writes them, to seed codec fuzz targets.
+func SampleStates() []*storage.State {
+	at := time.Date(2025, 1, 1, 12, 0, 0, 123456789, time.UTC)
+	return []*storage.State{
+		{},
+		{Tokens: 7.5, LastRefill: at, CreatedAt: at, UpdatedAt: at},
+		{Count: 42, WindowStart: at.Truncate(time.Minute), UpdatedAt: at},
+		{Timestamps: []time.Time{at.Add(-time.Second), at.Add(-time.Millisecond), at}, UpdatedAt: at},
+		{Tokens: -1, Count: -1, Metadata: map[string]interface{}{"status": "banned", "offenses": "3"}},
+	}
+}
+
+// CheckCodec is the body of a fuzz target for a storage.Codec: it fails t
+// if decoding data panics, or if a state decoded from it doesn't survive
+// an encoding round trip. Errors decoding data are expected and pass.
+//
+// Example:
+//
+//	func FuzzCodec(f *testing.F) {
+//	    codec := storage.MsgpackCodec{}
+//	    for _, st := range flexlimittest.SampleStates() {
+//	        data, _ := codec.Marshal(st)
+//	        f.Add(data)
+//	    }
+//	    f.Fuzz(func(t *testing.T, data []byte) {
+//	        flexlimittest.CheckCodec(t, codec, data)
+//	    })
+//	}
+func CheckCodec(t testing.TB, codec storage.Codec, data []byte) {
+	t.Helper()
+
+	state, err := unmarshal(codec, data)
+	if err != nil || state == nil {
+		return
+	}
+	CheckCodecRoundTrip(t, codec, state)
+}
+
+// CheckCodecRoundTrip fails t unless state encoded and decoded by codec
+// comes back equal. Times are compared with time.Time.Equal, since codecs
+// may drop their location and monotonic reading. Metadata values only
+// need to come back equal after a first round trip, since codecs may
+// change their types (JSON decodes numbers as float64).
+func CheckCodecRoundTrip(t testing.TB, codec storage.Codec, state *storage.State) {
+	t.Helper()
+
+	first, err := roundTrip(codec, state)
+	if err != nil {
+		t.Errorf("round trip of %+v: %v", state, err)
+		return
+	}
+	if msg := diffStates(state, first, false); msg != "" {
+		t.Errorf("round trip of %+v: %s", state, msg)
+		return
+	}
+
+	second, err := roundTrip(codec, first)
+	if err != nil {
+		t.Errorf("second round trip of %+v: %v", first, err)
+		return
+	}
+	if msg := diffStates(first, second, true); msg != "" {
+		t.Errorf("second round trip of %+v: %s", first, msg)
+	}
+}
+
+// roundTrip encodes and decodes state with codec.
+func roundTrip(codec storage.Codec, state *storage.State) (*storage.State, error) {
+	data, err := codec.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("marshal: %w", err)
+	}
+	decoded, err := unmarshal(codec, data)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal: %w", err)
+	}
+	if decoded == nil {
+		return nil, fmt.Errorf("unmarshal: nil state")
+	}
+	return decoded, nil
+}
+
+// unmarshal decodes data with codec, turning panics into errors.
+func unmarshal(codec storage.Codec, data []byte) (state *storage.State, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			state, err = nil, fmt.Errorf("panic decoding %q: %v", data, r)
+		}
+	}()
+	return codec.Unmarshal(data)
+}
+
+// diffStates describes the first difference between want and got, or
+// returns "". Metadata is compared only if metadata is set.
+func diffStates(want, got *storage.State, metadata bool) string {
+	switch {
+	case want.Tokens != got.Tokens && !(math.IsNaN(want.Tokens) && math.IsNaN(got.Tokens)):
+		return fmt.Sprintf("Tokens = %v, want %v", got.Tokens, want.Tokens)
+	case want.Count != got.Count:
+		return fmt.Sprintf("Count = %d, want %d", got.Count, want.Count)
+	case !want.LastRefill.Equal(got.LastRefill):
+		return fmt.Sprintf("LastRefill = %s, want %s", got.LastRefill, want.LastRefill)
+	case !want.WindowStart.Equal(got.WindowStart):
+		return fmt.Sprintf("WindowStart = %s, want %s", got.WindowStart, want.WindowStart)
+	case !want.CreatedAt.Equal(got.CreatedAt):
+		return fmt.Sprintf("CreatedAt = %s, want %s", got.CreatedAt, want.CreatedAt)
+	case !want.UpdatedAt.Equal(got.UpdatedAt):
+		return fmt.Sprintf("UpdatedAt = %s, want %s", got.UpdatedAt, want.UpdatedAt)
+	case len(want.Timestamps) != len(got.Timestamps):
+		return fmt.Sprintf("%d Timestamps, want %d", len(got.Timestamps), len(want.Timestamps))
+	}
+	for i := range want.Timestamps {
+		if !want.Timestamps[i].Equal(got.Timestamps[i]) {
+			return fmt.Sprintf("Timestamps[%d] = %s, want %s", i, got.Timestamps[i], want.Timestamps[i])
+		}
+	}
+	if metadata && len(want.Metadata)+len(got.Metadata) > 0 && !reflect.DeepEqual(want.Metadata, got.Metadata) {
+		return fmt.Sprintf("Metadata = %v, want %v", got.Metadata, want.Metadata)
+	}
+	return ""
+}
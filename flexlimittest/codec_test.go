@@ -0,0 +1,44 @@
+package flexlimittest_test
+
+import (
+	"testing"
+
+	"github.com/Vipul984/flexlimit/flexlimittest"
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+// codecs are the built-in codecs of the storage package.
+var codecs = map[string]storage.Codec{
+	"json":     storage.JSONCodec{},
+	"gob":      storage.GobCodec{},
+	"msgpack":  storage.MsgpackCodec{},
+	"protobuf": storage.ProtoCodec{},
+	"checksum": storage.NewChecksumCodec(storage.MsgpackCodec{}),
+}
+
+func TestCheckCodecRoundTrip(t *testing.T) {
+	for name, codec := range codecs {
+		t.Run(name, func(t *testing.T) {
+			for _, st := range flexlimittest.SampleStates() {
+				flexlimittest.CheckCodecRoundTrip(t, codec, st)
+			}
+		})
+	}
+}
+
+func FuzzCodec(f *testing.F) {
+	for _, codec := range codecs {
+		for _, st := range flexlimittest.SampleStates() {
+			data, err := codec.Marshal(st)
+			if err != nil {
+				f.Fatal(err)
+			}
+			f.Add(data)
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, codec := range codecs {
+			flexlimittest.CheckCodec(t, codec, data)
+		}
+	})
+}
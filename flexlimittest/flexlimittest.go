@@ -0,0 +1,429 @@
+// Package flexlimittest helps unit test code that uses flexlimit, without
+// real algorithms or storage.
+//
+// Limiter is a fake with the method set of flexlimit.Limiter (see
+// compat.Limiter) whose decisions are scripted: it allows everything
+// until told otherwise, and records every call. Code that depends on an
+// interface rather than *flexlimit.Limiter can take it directly.
+// Recorder collects the LimitInfo of OnAllow and OnLimit callbacks, from
+// a real limiter or the fake, and the Assert functions check decisions
+// and errors, failing the test with a readable message.
+//
+// For authors of algorithms and codecs, CheckInvariants runs random
+// requests against an algorithm and checks the invariants flexlimit's
+// own algorithms hold, and CheckCodec is the body of a fuzz target for
+// state serialization.
+//
+// Example:
+//
+//	fake := flexlimittest.NewLimiter(10, time.Minute)
+//	fake.ScriptKey("user:1", flexlimittest.Allow, flexlimittest.Deny)
+//
+//	svc := NewService(fake)
+//	svc.Handle(ctx, "user:1") // allowed
+//	err := svc.Handle(ctx, "user:1")
+//	flexlimittest.AssertLimitExceeded(t, err)
+//
+// With a real limiter, Recorder sees the decisions through its
+// callbacks:
+//
+//	rec := flexlimittest.NewRecorder()
+//	limiter, _ := flexlimit.New(2, time.Second, rec.Options()...)
+//	flexlimittest.AssertLimit(t, limiter, "user:1", 2)
+//	rec.AssertCount(t, "user:1", 2, 1)
+package flexlimittest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Vipul984/flexlimit"
+	"github.com/Vipul984/flexlimit/compat"
+)
+
+var _ compat.Limiter = (*Limiter)(nil)
+
+// Step is one scripted decision of a Limiter.
+type Step struct {
+	// Allowed is the decision
+	Allowed bool
+
+	// Err is returned instead of a decision, to simulate failures such as
+	// flexlimit.ErrStorageUnavailable
+	Err error
+}
+
+// Steps for Limiter.Script and Limiter.ScriptKey.
+var (
+	// Allow allows the request
+	Allow = Step{Allowed: true}
+
+	// Deny denies the request
+	Deny = Step{}
+)
+
+// Fail returns a Step failing the request with err.
+func Fail(err error) Step {
+	return Step{Err: err}
+}
+
+// Call is a call recorded by a Limiter.
+type Call struct {
+	// Method is the name of the Limiter method, such as "AllowN"
+	Method string
+
+	// Key is the rate limit key, empty for methods without one
+	Key string
+
+	// N is the cost of the request, 0 for methods without one
+	N int
+
+	// Step is the decision the call got. Zero for methods that don't
+	// decide requests
+	Step Step
+}
+
+// Limiter is a fake flexlimit.Limiter deciding requests by script.
+//
+// Each request (Allow, AllowN, each request of AllowMulti, and each
+// attempt of Wait and WaitN) takes the next step scripted for its key
+// with ScriptKey, else the next step scripted for any key with Script,
+// else the default step, which is Allow unless changed with SetDefault.
+// Check previews the next step without taking it.
+//
+// Allowed requests add their cost to the key's usage, which State
+// reports against the limit and Refund and Reset give back; usage never
+// decides anything by itself. Wait and WaitN don't sleep: a denied step
+// is retried at once with the next, and once the script of the key is
+// exhausted and the default step denies, they block until the context
+// ends.
+//
+// A Limiter is safe for concurrent use.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   int
+	window time.Duration
+	def    Step
+	script []Step
+	keyed  map[string][]Step
+	used   map[string]int
+	calls  []Call
+	hooks  []func(flexlimit.LimitInfo)
+	closed bool
+}
+
+// NewLimiter returns a fake limiter reporting rate and window as its
+// limit, allowing every request.
+func NewLimiter(rate int, window time.Duration) *Limiter {
+	return &Limiter{
+		rate:   rate,
+		window: window,
+		def:    Allow,
+		keyed:  make(map[string][]Step),
+		used:   make(map[string]int),
+	}
+}
+
+// Script appends steps taken in order by the requests of any key, once
+// the steps scripted for their key run out.
+func (l *Limiter) Script(steps ...Step) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.script = append(l.script, steps...)
+}
+
+// ScriptKey appends steps taken in order by the requests of key.
+func (l *Limiter) ScriptKey(key string, steps ...Step) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.keyed[key] = append(l.keyed[key], steps...)
+}
+
+// SetDefault sets the step taken once the script runs out, such as Deny
+// to simulate an exhausted limit.
+func (l *Limiter) SetDefault(step Step) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.def = step
+}
+
+// OnDecision registers a function called with the LimitInfo of every
+// decided request, as flexlimit.OnAllow and flexlimit.OnLimit would be.
+// Pass Recorder.Record to record them.
+func (l *Limiter) OnDecision(fn func(flexlimit.LimitInfo)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hooks = append(l.hooks, fn)
+}
+
+// Calls returns the calls made so far, in order.
+func (l *Limiter) Calls() []Call {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Call(nil), l.calls...)
+}
+
+// CallsFor returns the calls made so far for key, in order.
+func (l *Limiter) CallsFor(key string) []Call {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var calls []Call
+	for _, c := range l.calls {
+		if c.Key == key {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// Used returns the cost of the requests allowed for key, less refunds,
+// since its last Reset.
+func (l *Limiter) Used(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.used[key]
+}
+
+// Allow decides a request for key by script.
+func (l *Limiter) Allow(ctx context.Context, key string) (bool, error) {
+	return l.decide(ctx, "Allow", key, 1)
+}
+
+// AllowN decides a request for key costing n by script.
+func (l *Limiter) AllowN(ctx context.Context, key string, n int) (bool, error) {
+	return l.decide(ctx, "AllowN", key, n)
+}
+
+// AllowMulti decides each request by script, in order.
+func (l *Limiter) AllowMulti(ctx context.Context, reqs []flexlimit.AllowRequest) ([]flexlimit.Decision, error) {
+	decisions := make([]flexlimit.Decision, len(reqs))
+	for i, req := range reqs {
+		n := req.N
+		if n == 0 {
+			n = 1
+		}
+		allowed, err := l.decide(ctx, "AllowMulti", req.Key, n)
+		if err != nil {
+			return nil, err
+		}
+		st, _ := l.State(ctx, req.Key)
+		decisions[i] = flexlimit.Decision{Key: req.Key, Allowed: allowed, State: st}
+	}
+	return decisions, nil
+}
+
+// Wait takes steps for key until one allows the request.
+func (l *Limiter) Wait(ctx context.Context, key string) error {
+	return l.wait(ctx, "Wait", key, 1)
+}
+
+// WaitN takes steps for key until one allows the request costing n.
+func (l *Limiter) WaitN(ctx context.Context, key string, n int) error {
+	return l.wait(ctx, "WaitN", key, n)
+}
+
+// wait implements Wait and WaitN.
+func (l *Limiter) wait(ctx context.Context, method, key string, n int) error {
+	for {
+		l.mu.Lock()
+		blocked := !l.def.Allowed && l.def.Err == nil && len(l.keyed[key]) == 0 && len(l.script) == 0
+		l.mu.Unlock()
+		if blocked {
+			<-ctx.Done()
+			return contextError(ctx.Err())
+		}
+
+		allowed, err := l.decide(ctx, method, key, n)
+		if err != nil || allowed {
+			return err
+		}
+	}
+}
+
+// Check reports the decision of the next step for key, without taking
+// it, along with the key's state.
+func (l *Limiter) Check(ctx context.Context, key string, n int) (bool, *flexlimit.State, error) {
+	st, err := l.State(ctx, key)
+	if err != nil {
+		return false, nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	step := l.peek(key)
+	l.calls = append(l.calls, Call{Method: "Check", Key: key, N: n, Step: step})
+	return step.Allowed, st, step.Err
+}
+
+// State returns the usage of key against the limit.
+func (l *Limiter) State(ctx context.Context, key string) (*flexlimit.State, error) {
+	if err := l.check(ctx); err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state(key), nil
+}
+
+// Reset clears the usage of key. Its script is kept.
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	if err := l.check(ctx); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.used, key)
+	l.calls = append(l.calls, Call{Method: "Reset", Key: key})
+	return nil
+}
+
+// Refund gives n back to the usage of key.
+func (l *Limiter) Refund(ctx context.Context, key string, n int) error {
+	if err := l.check(ctx); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.used[key] = max(l.used[key]-n, 0)
+	l.calls = append(l.calls, Call{Method: "Refund", Key: key, N: n})
+	return nil
+}
+
+// Limit returns the fake's rate and window.
+func (l *Limiter) Limit() (rate int, window time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate, l.window
+}
+
+// SetLimit changes the rate and window reported by the fake. Like the
+// real limiter, it returns an *flexlimit.InvalidConfigError if either is
+// not positive.
+func (l *Limiter) SetLimit(rate int, window time.Duration) error {
+	if rate <= 0 {
+		return &flexlimit.InvalidConfigError{Field: "rate", Value: rate, Reason: "must be positive"}
+	}
+	if window <= 0 {
+		return &flexlimit.InvalidConfigError{Field: "window", Value: window, Reason: "must be positive"}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate, l.window = rate, window
+	l.calls = append(l.calls, Call{Method: "SetLimit"})
+	return nil
+}
+
+// Close makes further calls fail with flexlimit.ErrLimiterClosed.
+func (l *Limiter) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	l.calls = append(l.calls, Call{Method: "Close"})
+	return nil
+}
+
+// decide takes the next step for a request for key costing n.
+func (l *Limiter) decide(ctx context.Context, method, key string, n int) (bool, error) {
+	if err := l.check(ctx); err != nil {
+		return false, err
+	}
+
+	l.mu.Lock()
+	step := l.peek(key)
+	switch {
+	case len(l.keyed[key]) > 0:
+		l.keyed[key] = l.keyed[key][1:]
+	case len(l.script) > 0:
+		l.script = l.script[1:]
+	}
+	l.calls = append(l.calls, Call{Method: method, Key: key, N: n, Step: step})
+	if step.Err != nil {
+		l.mu.Unlock()
+		return false, step.Err
+	}
+	if step.Allowed {
+		l.used[key] += n
+	}
+	info := l.info(key, n, step.Allowed)
+	hooks := l.hooks
+	l.mu.Unlock()
+
+	for _, fn := range hooks {
+		fn(info)
+	}
+	return step.Allowed, nil
+}
+
+// peek returns the next step for key. Must be called with l.mu held.
+func (l *Limiter) peek(key string) Step {
+	if steps := l.keyed[key]; len(steps) > 0 {
+		return steps[0]
+	}
+	if len(l.script) > 0 {
+		return l.script[0]
+	}
+	return l.def
+}
+
+// state returns the state of key. Must be called with l.mu held.
+func (l *Limiter) state(key string) *flexlimit.State {
+	used := l.used[key]
+	return &flexlimit.State{
+		Key:       key,
+		Limit:     l.rate,
+		Used:      used,
+		Remaining: max(l.rate-used, 0),
+		Window:    l.window,
+	}
+}
+
+// info returns the LimitInfo of a decided request. Must be called with
+// l.mu held.
+func (l *Limiter) info(key string, n int, allowed bool) flexlimit.LimitInfo {
+	st := l.state(key)
+	return flexlimit.LimitInfo{
+		Key:       key,
+		Allowed:   allowed,
+		Limit:     st.Limit,
+		Used:      st.Used,
+		Remaining: st.Remaining,
+		Cost:      n,
+		Algorithm: "fake",
+	}
+}
+
+// check returns the error of a call on a closed limiter or with a done
+// context.
+func (l *Limiter) check(ctx context.Context) error {
+	l.mu.Lock()
+	closed := l.closed
+	l.mu.Unlock()
+
+	if closed {
+		return flexlimit.ErrLimiterClosed
+	}
+	return contextError(ctx.Err())
+}
+
+// contextError wraps a context error as the real limiter does, so it
+// matches both flexlimit.ErrContextCanceled or
+// flexlimit.ErrContextDeadlineExceeded and the context package's error.
+func contextError(err error) error {
+	switch err {
+	case nil:
+		return nil
+	case context.Canceled:
+		return fmt.Errorf("%w: %w", flexlimit.ErrContextCanceled, err)
+	case context.DeadlineExceeded:
+		return fmt.Errorf("%w: %w", flexlimit.ErrContextDeadlineExceeded, err)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,244 @@
+package flexlimittest
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"github.com/Vipul984/flexlimit/algorithm"
+	"github.com/Vipul984/flexlimit/clock"
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+// resetTolerance absorbs the rounding of reset times computed from
+// fractional token counts.
+const resetTolerance = time.Millisecond
+
+// InvariantConfig configures CheckInvariants.
+type InvariantConfig struct {
+	// Config configures the algorithm. Default: 10 requests per second
+	Config algorithm.Config
+
+	// Seed seeds the random requests, so a failure can be replayed.
+	// Default: 1
+	Seed uint64
+
+	// Steps is the number of requests, state reads and refunds made.
+	// Default: 1000
+	Steps int
+
+	// Keys is the number of keys the requests are spread over. Default: 2
+	Keys int
+
+	// MaxCost is the highest cost of a request. Default: 1
+	MaxCost int
+
+	// MaxAdvance is the most the clock moves between two steps. Keep it
+	// short enough for requests to exceed the limit often. Default: a
+	// quarter of the window divided by the capacity, which makes about
+	// three times the capacity of requests per key and window
+	MaxAdvance time.Duration
+
+	// Store is the storage the algorithm runs on; it must use Clock for
+	// its TTLs. Default: a memory store on Clock
+	Store storage.Storage
+
+	// Clock is the mock clock driving the run. Default: a new mock clock
+	Clock *clock.Mock
+}
+
+// CheckInvariants runs a random sequence of requests, state reads,
+// refunds and clock advances against the algorithm built by newAlgo
+// (algorithm.New, or the factory of a custom algorithm), and fails t at
+// the first step that breaks an invariant every algorithm of flexlimit
+// holds:
+//
+//   - a key never gets more than its capacity (the larger of Rate and
+//     BurstSize) for each window an interval spans, plus one for the
+//     window it starts in, plus the units refunded meanwhile
+//   - states report 0 <= Remaining <= Limit and RetryAfter >= 0, even
+//     after refunds of more than was consumed
+//   - a key's ResetAt never moves backwards, except through a refund
+//
+// The bound on allowed requests is loose enough for fixed windows, which
+// allow twice their rate across a boundary; algorithms that exceed it
+// over-admit badly. Run it for several seeds, or from a fuzz target:
+//
+//	func FuzzMyAlgorithm(f *testing.F) {
+//	    f.Add(uint64(1))
+//	    f.Fuzz(func(t *testing.T, seed uint64) {
+//	        flexlimittest.CheckInvariants(t, NewMyAlgorithm, flexlimittest.InvariantConfig{Seed: seed})
+//	    })
+//	}
+func CheckInvariants(t testing.TB, newAlgo algorithm.Factory, cfg InvariantConfig) {
+	t.Helper()
+
+	if cfg.Config.Rate == 0 {
+		cfg.Config.Rate = 10
+	}
+	if cfg.Config.Window == 0 {
+		cfg.Config.Window = time.Second
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = 1
+	}
+	if cfg.Steps <= 0 {
+		cfg.Steps = 1000
+	}
+	if cfg.Keys <= 0 {
+		cfg.Keys = 2
+	}
+	if cfg.MaxCost <= 0 {
+		cfg.MaxCost = 1
+	}
+	capacity := max(cfg.Config.Rate, cfg.Config.BurstSize)
+	if cfg.MaxAdvance <= 0 {
+		cfg.MaxAdvance = cfg.Config.Window / time.Duration(4*capacity)
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = clock.NewMockAt(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	}
+	if cfg.Store == nil {
+		store := storage.NewMemory(storage.Config{Backend: "memory", Clock: cfg.Clock})
+		defer store.Close()
+		cfg.Store = store
+	}
+
+	algo, err := newAlgo(cfg.Config, cfg.Store, cfg.Clock)
+	if err != nil {
+		t.Errorf("creating algorithm: %v", err)
+		return
+	}
+	defer algo.Close()
+
+	r := &invariantRun{
+		cfg:      cfg,
+		rng:      rand.New(rand.NewPCG(cfg.Seed, cfg.Seed)),
+		capacity: capacity,
+		keys:     make(map[string]*keyHistory),
+	}
+	refunder, _ := algo.(algorithm.Refunder)
+	ctx := context.Background()
+
+	for step := range cfg.Steps {
+		cfg.Clock.Advance(time.Duration(r.rng.Int64N(int64(cfg.MaxAdvance) + 1)))
+		now := cfg.Clock.Now()
+		key := fmt.Sprintf("key-%d", r.rng.IntN(cfg.Keys))
+		h := r.history(key)
+		cost := 1 + r.rng.IntN(cfg.MaxCost)
+
+		var op string
+		var st *algorithm.State
+		switch n := r.rng.IntN(10); {
+		case n == 0 && refunder != nil:
+			// Refund up to twice the cost, more than was consumed at times
+			op = fmt.Sprintf("Refund(%q, %d)", key, 2*cost)
+			if err = refunder.Refund(ctx, key, 2*cost); err == nil {
+				h.refunds = append(h.refunds, event{at: now, cost: int64(2 * cost)})
+				h.resetAt = time.Time{}
+				st, err = algo.State(ctx, key)
+			}
+		case n <= 1:
+			op = fmt.Sprintf("State(%q)", key)
+			st, err = algo.State(ctx, key)
+		default:
+			var allowed bool
+			op = fmt.Sprintf("Allow(%q, %d)", key, cost)
+			allowed, st, err = algo.Allow(ctx, key, cost)
+			if err == nil && allowed {
+				h.allowed = append(h.allowed, event{at: now, cost: int64(cost)})
+				op += " = allowed"
+			} else {
+				op += " = denied"
+			}
+		}
+		if err != nil {
+			t.Errorf("seed %d, step %d at %s: %s: %v", cfg.Seed, step, now.Format(time.RFC3339Nano), op, err)
+			return
+		}
+		if msg := r.check(h, st); msg != "" {
+			t.Errorf("seed %d, step %d at %s: %s: %s", cfg.Seed, step, now.Format(time.RFC3339Nano), op, msg)
+			return
+		}
+	}
+}
+
+// invariantRun is the state of a CheckInvariants run.
+type invariantRun struct {
+	cfg      InvariantConfig
+	rng      *rand.Rand
+	capacity int64
+	keys     map[string]*keyHistory
+}
+
+// keyHistory is what a CheckInvariants run did with one key.
+type keyHistory struct {
+	allowed []event
+	refunds []event
+	resetAt time.Time // last ResetAt reported, zero after a refund
+}
+
+// event is an allowed request or a refund.
+type event struct {
+	at   time.Time
+	cost int64
+}
+
+// history returns the history of key.
+func (r *invariantRun) history(key string) *keyHistory {
+	h, ok := r.keys[key]
+	if !ok {
+		h = &keyHistory{}
+		r.keys[key] = h
+	}
+	return h
+}
+
+// check returns a description of the invariant h and st break, or "".
+func (r *invariantRun) check(h *keyHistory, st *algorithm.State) string {
+	if st != nil {
+		switch {
+		case st.Remaining < 0:
+			return fmt.Sprintf("Remaining = %d, want >= 0", st.Remaining)
+		case st.Remaining > st.Limit:
+			return fmt.Sprintf("Remaining = %d exceeds Limit %d", st.Remaining, st.Limit)
+		case st.RetryAfter < 0:
+			return fmt.Sprintf("RetryAfter = %s, want >= 0", st.RetryAfter)
+		}
+		if !st.ResetAt.IsZero() {
+			if !h.resetAt.IsZero() && st.ResetAt.Before(h.resetAt.Add(-resetTolerance)) {
+				return fmt.Sprintf("ResetAt moved back from %s to %s",
+					h.resetAt.Format(time.RFC3339Nano), st.ResetAt.Format(time.RFC3339Nano))
+			}
+			h.resetAt = st.ResetAt
+		}
+	}
+
+	// Only intervals ending with the latest request can break the bound
+	// for the first time
+	if len(h.allowed) == 0 {
+		return ""
+	}
+	last := h.allowed[len(h.allowed)-1].at
+	window := r.cfg.Config.Window
+	var sum int64
+	for i := len(h.allowed) - 1; i >= 0; i-- {
+		first := h.allowed[i].at
+		sum += h.allowed[i].cost
+
+		span := last.Sub(first)
+		bound := r.capacity * (int64(span/window) + 2)
+		for _, rf := range h.refunds {
+			if !rf.at.Before(first.Add(-window)) && !rf.at.After(last) {
+				bound += rf.cost
+			}
+		}
+		if sum > bound {
+			return fmt.Sprintf("allowed %d between %s and %s, more than %d",
+				sum, first.Format(time.RFC3339Nano), last.Format(time.RFC3339Nano), bound)
+		}
+	}
+	return ""
+}
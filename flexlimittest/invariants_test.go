@@ -0,0 +1,71 @@
+package flexlimittest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Vipul984/flexlimit/algorithm"
+	"github.com/Vipul984/flexlimit/flexlimittest"
+)
+
+// builtins configures each built-in algorithm, with and without the
+// options changing how it counts.
+var builtins = map[string]algorithm.Config{
+	"token_bucket":           {Algorithm: "token_bucket"},
+	"token_bucket_burst":     {Algorithm: "token_bucket", BurstSize: 30},
+	"fixed_window":           {Algorithm: "fixed_window"},
+	"fixed_window_rollover":  {Algorithm: "fixed_window", Rate: 20, Rollover: 20},
+	"fixed_window_calendar":  {Algorithm: "fixed_window", Calendar: "day", Rate: 500},
+	"sliding_window":         {Algorithm: "sliding_window"},
+	"sliding_window_buckets": {Algorithm: "sliding_window", Buckets: 10},
+	"leaky_bucket":           {Algorithm: "leaky_bucket", BurstSize: 10},
+	"leaky_bucket_jitter":    {Algorithm: "leaky_bucket", BurstSize: 10, DrainJitter: 100 * time.Millisecond},
+	"decayed_window":         {Algorithm: "decayed_window"},
+}
+
+func TestCheckInvariants(t *testing.T) {
+	for name, cfg := range builtins {
+		t.Run(name, func(t *testing.T) {
+			for seed := uint64(1); seed <= 10; seed++ {
+				flexlimittest.CheckInvariants(t, algorithm.New, flexlimittest.InvariantConfig{
+					Config:  cfg,
+					Seed:    seed,
+					Keys:    3,
+					MaxCost: 3,
+				})
+			}
+		})
+	}
+}
+
+func TestCheckInvariantsRollover(t *testing.T) {
+	for seed := uint64(1); seed <= 5; seed++ {
+		flexlimittest.CheckInvariants(t, algorithm.New, flexlimittest.InvariantConfig{
+			Config: algorithm.Config{
+				Algorithm: string(algorithm.FixedWindow),
+				Rate:      20,
+				Window:    time.Second,
+				Rollover:  20,
+			},
+			Seed: seed,
+		})
+	}
+}
+
+func FuzzInvariants(f *testing.F) {
+	for name := range builtins {
+		f.Add(name, uint64(1), uint8(1))
+	}
+	f.Fuzz(func(t *testing.T, name string, seed uint64, maxCost uint8) {
+		cfg, ok := builtins[name]
+		if !ok {
+			t.Skip("not a built-in configuration")
+		}
+		flexlimittest.CheckInvariants(t, algorithm.New, flexlimittest.InvariantConfig{
+			Config:  cfg,
+			Seed:    seed,
+			Steps:   200,
+			MaxCost: 1 + int(maxCost%5),
+		})
+	})
+}
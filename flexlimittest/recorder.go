@@ -0,0 +1,103 @@
+package flexlimittest
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/Vipul984/flexlimit"
+)
+
+// Recorder collects the LimitInfo of decided requests, from the OnAllow
+// and OnLimit callbacks of a real limiter (see Options) or from a fake
+// Limiter (see Limiter.OnDecision). It is safe for concurrent use.
+type Recorder struct {
+	mu     sync.Mutex
+	events []flexlimit.LimitInfo
+}
+
+// NewRecorder returns an empty Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Options returns the OnAllow and OnLimit options recording a real
+// limiter's decisions, to pass to flexlimit.New.
+func (r *Recorder) Options() []flexlimit.Option {
+	return []flexlimit.Option{flexlimit.OnAllow(r.Record), flexlimit.OnLimit(r.Record)}
+}
+
+// Record records info.
+func (r *Recorder) Record(info flexlimit.LimitInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, info)
+}
+
+// Events returns the recorded LimitInfo, in order.
+func (r *Recorder) Events() []flexlimit.LimitInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]flexlimit.LimitInfo(nil), r.events...)
+}
+
+// Allowed returns the recorded LimitInfo of allowed requests.
+func (r *Recorder) Allowed() []flexlimit.LimitInfo {
+	return r.filter(func(info flexlimit.LimitInfo) bool { return info.Allowed })
+}
+
+// Denied returns the recorded LimitInfo of denied requests.
+func (r *Recorder) Denied() []flexlimit.LimitInfo {
+	return r.filter(func(info flexlimit.LimitInfo) bool { return !info.Allowed })
+}
+
+// ForKey returns the recorded LimitInfo of the requests for key.
+func (r *Recorder) ForKey(key string) []flexlimit.LimitInfo {
+	return r.filter(func(info flexlimit.LimitInfo) bool { return info.Key == key })
+}
+
+// Len returns the number of recorded requests.
+func (r *Recorder) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.events)
+}
+
+// Clear drops the recorded requests.
+func (r *Recorder) Clear() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = nil
+}
+
+// AssertCount fails t unless exactly allowed requests and denied requests
+// were recorded for key.
+func (r *Recorder) AssertCount(t testing.TB, key string, allowed, denied int) {
+	t.Helper()
+
+	var gotAllowed, gotDenied int
+	for _, info := range r.ForKey(key) {
+		if info.Allowed {
+			gotAllowed++
+		} else {
+			gotDenied++
+		}
+	}
+	if gotAllowed != allowed || gotDenied != denied {
+		t.Errorf("key %q: recorded %d allowed and %d denied, want %d and %d",
+			key, gotAllowed, gotDenied, allowed, denied)
+	}
+}
+
+// filter returns the recorded LimitInfo matching keep.
+func (r *Recorder) filter(keep func(flexlimit.LimitInfo) bool) []flexlimit.LimitInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var events []flexlimit.LimitInfo
+	for _, info := range r.events {
+		if keep(info) {
+			events = append(events, info)
+		}
+	}
+	return events
+}
@@ -0,0 +1,51 @@
+package flexlimit
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+// graceRecord is the kind of record storing when a key's grace period
+// started (see recordKey).
+const graceRecord = "grace"
+
+// grace decides whether a request the algorithm denied for key is let
+// through by delayed enforcement. The first denial of a window starts a
+// grace period; denials inside it are allowed with a warning. It returns
+// when hard enforcement begins and whether the request is allowed.
+//
+// Grace records live in the limiter's storage so that every instance
+// sharing it agrees on when enforcement starts. If the record can't be
+// read or written the request stays denied.
+// Must be called with l.mu held.
+func (l *Limiter) grace(ctx context.Context, key string, now time.Time) (time.Time, bool) {
+	period := l.opts.gracePeriod
+	store := l.be.store
+	graceKey := recordKey(graceRecord, key)
+
+	st, err := store.Get(ctx, graceKey)
+	switch {
+	case err == nil:
+		enforceAt := st.WindowStart.Add(period)
+		return enforceAt, now.Before(enforceAt)
+	case errors.Is(err, storage.ErrKeyNotFound):
+	default:
+		return time.Time{}, false
+	}
+
+	// The record outlives the grace period until the end of the window, so
+	// a key gets at most one grace period per window.
+	ttl := max(l.window, period)
+	err = store.Set(ctx, graceKey, &storage.State{
+		WindowStart: now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}, ttl)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return now.Add(period), true
+}
@@ -0,0 +1,158 @@
+package flexlimit
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Group is a registry of named limiters, each with its own algorithm,
+// limits and options.
+//
+// Applications typically need several independent limits ("login",
+// "search", "upload"). A Group keeps them in one place instead of a pile of
+// package-level variables. A Group is safe for concurrent use.
+//
+// Example:
+//
+//	limits := flexlimit.NewGroup()
+//	limits.Add("login", 5, time.Minute, flexlimit.WithAlgorithm(flexlimit.SlidingWindow))
+//	limits.Add("search", 100, time.Minute)
+//	defer limits.Close()
+//
+//	allowed, err := limits.Allow(ctx, "login", "user:123")
+type Group struct {
+	mu       sync.RWMutex
+	limiters map[string]*Limiter
+}
+
+// NewGroup creates an empty Group.
+func NewGroup() *Group {
+	return &Group{
+		limiters: make(map[string]*Limiter),
+	}
+}
+
+// Add creates a limiter with New and registers it under name.
+func (g *Group) Add(name string, rate int, window time.Duration, opts ...Option) (*Limiter, error) {
+	l, err := New(rate, window, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := g.Register(name, l); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Register adds an existing limiter under name. The Group takes ownership
+// and closes it in Close or Remove.
+//
+// Returns an *InvalidConfigError if name is empty or already registered.
+func (g *Group) Register(name string, l *Limiter) error {
+	if name == "" {
+		return &InvalidConfigError{Field: "name", Value: name, Reason: "must not be empty"}
+	}
+	if l == nil {
+		return &InvalidConfigError{Field: "limiter", Value: l, Reason: "must not be nil"}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, exists := g.limiters[name]; exists {
+		return &InvalidConfigError{Field: "name", Value: name, Reason: "already registered"}
+	}
+	g.limiters[name] = l
+	return nil
+}
+
+// Get returns the limiter registered under name.
+func (g *Group) Get(name string) (*Limiter, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	l, ok := g.limiters[name]
+	return l, ok
+}
+
+// Allow calls Allow on the limiter registered under name.
+//
+// Returns ErrLimiterNotFound if no limiter has that name.
+func (g *Group) Allow(ctx context.Context, name, key string) (bool, error) {
+	return g.AllowN(ctx, name, key, 1)
+}
+
+// AllowN calls AllowN on the limiter registered under name.
+//
+// Returns ErrLimiterNotFound if no limiter has that name.
+func (g *Group) AllowN(ctx context.Context, name, key string, n int) (bool, error) {
+	l, ok := g.Get(name)
+	if !ok {
+		return false, ErrLimiterNotFound
+	}
+	return l.AllowN(ctx, key, n)
+}
+
+// Names returns the registered names in sorted order.
+func (g *Group) Names() []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	names := make([]string, 0, len(g.limiters))
+	for name := range g.limiters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Remove unregisters and closes the limiter registered under name.
+// Removing an unknown name is a no-op.
+func (g *Group) Remove(name string) error {
+	g.mu.Lock()
+	l, ok := g.limiters[name]
+	delete(g.limiters, name)
+	g.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return l.Close()
+}
+
+// Shutdown shuts every registered limiter down with Limiter.Shutdown,
+// within ctx, and empties the Group. Limiters whose shutdown failed are
+// closed with Close, so their resources are released either way.
+func (g *Group) Shutdown(ctx context.Context) error {
+	g.mu.Lock()
+	limiters := g.limiters
+	g.limiters = make(map[string]*Limiter)
+	g.mu.Unlock()
+
+	var errs []error
+	for _, l := range limiters {
+		if err := l.Shutdown(ctx); err != nil {
+			errs = append(errs, err, l.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes every registered limiter and empties the Group.
+func (g *Group) Close() error {
+	g.mu.Lock()
+	limiters := g.limiters
+	g.limiters = make(map[string]*Limiter)
+	g.mu.Unlock()
+
+	var errs []error
+	for _, l := range limiters {
+		errs = append(errs, l.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,146 @@
+// Package grpclimit paces outbound gRPC calls with a flexlimit.Limiter, so
+// a client stays within the rate limits of the services it calls.
+//
+// The client interceptors wait for the limiter (see flexlimit.Limiter.Wait)
+// before each call, keyed by method by default, instead of sending calls
+// the service would reject. A call that can't be paced fails with a gRPC
+// status: Canceled or DeadlineExceeded if its context ends while it
+// waits, ResourceExhausted if the limiter turns it away, such as a full
+// queue (see flexlimit.WithQueue) or a blocked key, and Unavailable if the
+// limiter fails.
+//
+// Example:
+//
+//	// The partner allows 50 calls per second per method.
+//	limiter, err := flexlimit.New(50, time.Second)
+//	if err != nil {
+//	    return err
+//	}
+//	conn, err := grpc.Dial(partnerAddr,
+//	    grpc.WithUnaryInterceptor(grpclimit.UnaryClientInterceptor(limiter, grpclimit.Config{
+//	        Jitter: 20 * time.Millisecond,
+//	    })),
+//	    grpc.WithStreamInterceptor(grpclimit.StreamClientInterceptor(limiter, grpclimit.Config{})),
+//	)
+package grpclimit
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/Vipul984/flexlimit"
+)
+
+// Config configures the client interceptors.
+type Config struct {
+	// Key returns the rate limit key of a call to method, the full method
+	// name as in "/pkg.Service/Method", or "" for calls not to pace.
+	// Default: Method
+	Key func(ctx context.Context, method string) string
+
+	// Jitter is the most a call is delayed at random once the limiter
+	// allows it, so calls released together don't reach the service in a
+	// burst. Default: 0
+	Jitter time.Duration
+}
+
+// Method keys calls by their full method name, giving each method its own
+// budget.
+func Method(ctx context.Context, method string) string {
+	return method
+}
+
+// Service keys calls by service, as in "/pkg.Service", so the methods of a
+// service share a budget.
+func Service(ctx context.Context, method string) string {
+	if i := strings.LastIndex(method, "/"); i > 0 {
+		return method[:i]
+	}
+	return method
+}
+
+// UnaryClientInterceptor returns an interceptor waiting for l before each
+// unary call.
+func UnaryClientInterceptor(l *flexlimit.Limiter, cfg Config) grpc.UnaryClientInterceptor {
+	cfg = withDefaults(cfg)
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if err := wait(ctx, l, cfg, method); err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor returns an interceptor waiting for l before
+// opening each stream. Messages sent on the stream aren't paced.
+func StreamClientInterceptor(l *flexlimit.Limiter, cfg Config) grpc.StreamClientInterceptor {
+	cfg = withDefaults(cfg)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if err := wait(ctx, l, cfg, method); err != nil {
+			return nil, err
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+// withDefaults fills in the defaults of cfg.
+func withDefaults(cfg Config) Config {
+	if cfg.Key == nil {
+		cfg.Key = Method
+	}
+	return cfg
+}
+
+// wait blocks until l allows a call to method, plus jitter, and returns a
+// gRPC status error if it doesn't.
+func wait(ctx context.Context, l *flexlimit.Limiter, cfg Config, method string) error {
+	key := cfg.Key(ctx, method)
+	if key == "" {
+		return nil
+	}
+
+	err := l.Wait(ctx, key)
+	if err == nil && cfg.Jitter > 0 {
+		err = sleep(ctx, rand.N(cfg.Jitter))
+	}
+	if err != nil {
+		return statusError(err)
+	}
+	return nil
+}
+
+// sleep pauses for d, or until ctx ends.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// statusError converts an error of Wait to a gRPC status error.
+func statusError(err error) error {
+	var code codes.Code
+	switch {
+	case errors.Is(err, flexlimit.ErrContextCanceled), errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, flexlimit.ErrContextDeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, flexlimit.ErrQueueFull), errors.Is(err, flexlimit.ErrKeyBlocked),
+		errors.Is(err, flexlimit.ErrRateLimitExceeded), errors.Is(err, flexlimit.ErrSelfLimited):
+		code = codes.ResourceExhausted
+	default:
+		code = codes.Unavailable
+	}
+	return status.Error(code, "flexlimit: "+err.Error())
+}
@@ -0,0 +1,187 @@
+package flexlimit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+// healthPingTimeout bounds the storage pings of a health handler when the
+// request has no deadline of its own.
+const healthPingTimeout = 2 * time.Second
+
+// HealthStatus summarizes a HealthReport.
+type HealthStatus string
+
+const (
+	// HealthOK means the storage answers and decisions are made on it.
+	HealthOK HealthStatus = "ok"
+
+	// HealthDegraded means the storage is failing and requests are
+	// decided by the AllowAll or LocalMemory fallback strategy.
+	HealthDegraded HealthStatus = "degraded"
+
+	// HealthUnavailable means requests can't be decided: the limiter is
+	// closed, or the storage is failing under the DenyAll strategy.
+	HealthUnavailable HealthStatus = "unavailable"
+)
+
+// HealthReport describes the health of a limiter. See Limiter.Health.
+type HealthReport struct {
+	// Status summarizes the report
+	Status HealthStatus `json:"status"`
+
+	// Storage is the error of the storage's Ping, or "" if it answered
+	Storage string `json:"storage_error,omitempty"`
+
+	// PingLatency is how long the storage's Ping took
+	PingLatency time.Duration `json:"ping_latency_ns"`
+
+	// CircuitOpen is true while the LocalMemory fallback strategy serves
+	// requests from local memory after the storage failed, until the
+	// storage answers again
+	CircuitOpen bool `json:"circuit_open"`
+
+	// Fallback is the fallback strategy (see WithFallback)
+	Fallback FallbackStrategy `json:"fallback"`
+
+	// Keys is the number of keys in the limiter's in-memory store, or -1
+	// if the state lives in a storage passed to WithStorage, which Health
+	// doesn't scan
+	Keys int `json:"keys"`
+
+	// CheckedAt is when the report was made
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Health pings the limiter's storage and reports whether the limiter can
+// make decisions on it, for readiness checks and dashboards. With the
+// LocalMemory fallback strategy the ping goes to the shared storage even
+// while requests are served locally, so the report shows when it is back.
+// ctx bounds the ping.
+//
+// Example:
+//
+//	if report := limiter.Health(ctx); report.Status != flexlimit.HealthOK {
+//	    log.Printf("rate limiter %s: %s", report.Status, report.Storage)
+//	}
+func (l *Limiter) Health(ctx context.Context) HealthReport {
+	report := HealthReport{
+		Fallback:  FallbackStrategy(l.opts.fallbackStrategy),
+		Keys:      -1,
+		CheckedAt: l.clock.Now(),
+	}
+	if l.closed.Load() {
+		report.Status = HealthUnavailable
+		report.Storage = ErrLimiterClosed.Error()
+		return report
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	start := time.Now()
+	err := l.be.store.Ping(ctx)
+	report.PingLatency = time.Since(start)
+	if err != nil {
+		report.Storage = err.Error()
+	}
+	report.CircuitOpen = l.be.failover != nil && l.be.failover.Degraded()
+	report.Keys = storedKeys(l.be.store)
+
+	switch {
+	case err == nil && !report.CircuitOpen:
+		report.Status = HealthOK
+	case report.Fallback == DenyAll:
+		report.Status = HealthUnavailable
+	default:
+		report.Status = HealthDegraded
+	}
+	return report
+}
+
+// storedKeys returns the number of keys of s if the limiter keeps them in
+// memory, or -1.
+func storedKeys(s storage.Storage) int {
+	if f, ok := s.(*storage.Failover); ok {
+		s = f.Primary()
+	}
+	if m, ok := s.(interface{ Len() int }); ok {
+		return m.Len()
+	}
+	return -1
+}
+
+// HealthHandler returns an http.Handler serving the limiter's
+// HealthReport as JSON, for a /healthz or readiness endpoint. It answers
+// 200 OK if the limiter is ok or degraded, since the fallback strategy
+// still decides requests, and 503 Service Unavailable otherwise. The ping
+// is bounded by the request's context, or by 2 seconds.
+//
+// Example:
+//
+//	mux.Handle("GET /healthz/ratelimit", limiter.HealthHandler())
+func (l *Limiter) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := healthContext(r)
+		defer cancel()
+
+		report := l.Health(ctx)
+		writeHealth(w, report, report.Status != HealthUnavailable)
+	})
+}
+
+// Health returns the HealthReport of each limiter of the group, by name.
+func (g *Group) Health(ctx context.Context) map[string]HealthReport {
+	names := g.Names()
+	reports := make(map[string]HealthReport, len(names))
+	for _, name := range names {
+		if l, ok := g.Get(name); ok {
+			reports[name] = l.Health(ctx)
+		}
+	}
+	return reports
+}
+
+// HealthHandler returns an http.Handler serving the group's health as
+// JSON: an object with each limiter's HealthReport by name. It answers
+// 200 OK if every limiter is ok or degraded, and 503 Service Unavailable
+// otherwise, like Limiter.HealthHandler.
+func (g *Group) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := healthContext(r)
+		defer cancel()
+
+		reports := g.Health(ctx)
+		healthy := true
+		for _, report := range reports {
+			healthy = healthy && report.Status != HealthUnavailable
+		}
+		writeHealth(w, reports, healthy)
+	})
+}
+
+// healthContext returns the context bounding the pings of a health
+// request: the request's, with a timeout of healthPingTimeout if it has
+// no deadline.
+func healthContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if _, ok := r.Context().Deadline(); ok {
+		return r.Context(), func() {}
+	}
+	return context.WithTimeout(r.Context(), healthPingTimeout)
+}
+
+// writeHealth writes v as the JSON response to a health request.
+func writeHealth(w http.ResponseWriter, v any, healthy bool) {
+	status := http.StatusOK
+	if !healthy {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,159 @@
+package flexlimit
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Vipul984/flexlimit/algorithm"
+)
+
+// HierarchyLevel is one level of a Hierarchy.
+type HierarchyLevel struct {
+	// Name identifies the level in LimitExceededError.Level (e.g., "org")
+	Name string
+
+	// Limiter enforces the level's budget, one key per entity at that level
+	Limiter *Limiter
+}
+
+// Hierarchy enforces nested budgets, such as org → project → API key,
+// where a request consumes from its entity at every level and is denied if
+// any level is out of budget.
+//
+// Each level is an ordinary Limiter with its own rate, window and
+// algorithm; a request names one key per level, from the root down. Levels
+// are charged in that order, and when one denies the request, the levels
+// above it are refunded (see Limiter.Refund), so a denied request costs
+// nothing at any level.
+//
+// Example:
+//
+//	orgs, _ := flexlimit.New(10000, time.Minute)
+//	projects, _ := flexlimit.New(2000, time.Minute)
+//	apiKeys, _ := flexlimit.New(100, time.Minute)
+//	h, err := flexlimit.NewHierarchy(
+//	    flexlimit.HierarchyLevel{Name: "org", Limiter: orgs},
+//	    flexlimit.HierarchyLevel{Name: "project", Limiter: projects},
+//	    flexlimit.HierarchyLevel{Name: "api_key", Limiter: apiKeys},
+//	)
+//	...
+//	err = h.Allow(ctx, orgID, projectID, apiKey)
+//	var limitErr *flexlimit.LimitExceededError
+//	if errors.As(err, &limitErr) {
+//	    log.Printf("denied by the %s budget", limitErr.Level)
+//	}
+type Hierarchy struct {
+	levels []HierarchyLevel
+}
+
+// NewHierarchy creates a Hierarchy with levels ordered from the root down.
+// The Hierarchy takes ownership of the limiters and closes them in Close.
+//
+// Returns an *InvalidConfigError if there are no levels, or a level has no
+// name or limiter, or two levels share a name.
+func NewHierarchy(levels ...HierarchyLevel) (*Hierarchy, error) {
+	if len(levels) == 0 {
+		return nil, &InvalidConfigError{Field: "levels", Value: levels, Reason: "must not be empty"}
+	}
+
+	seen := make(map[string]struct{}, len(levels))
+	for _, lv := range levels {
+		if lv.Name == "" {
+			return nil, &InvalidConfigError{Field: "level", Value: lv.Name, Reason: "name must not be empty"}
+		}
+		if lv.Limiter == nil {
+			return nil, &InvalidConfigError{Field: "level", Value: lv.Name, Reason: "limiter must not be nil"}
+		}
+		if _, dup := seen[lv.Name]; dup {
+			return nil, &InvalidConfigError{Field: "level", Value: lv.Name, Reason: "already defined"}
+		}
+		seen[lv.Name] = struct{}{}
+	}
+
+	return &Hierarchy{levels: append([]HierarchyLevel(nil), levels...)}, nil
+}
+
+// Allow consumes one token for a request at every level named by keys,
+// from the root down.
+//
+// Returns nil if the request is allowed, or a *LimitExceededError (which
+// matches ErrRateLimitExceeded) whose Level names the level that denied
+// it. See AllowN.
+func (h *Hierarchy) Allow(ctx context.Context, keys ...string) error {
+	return h.AllowN(ctx, keys, 1)
+}
+
+// AllowN consumes n tokens at every level named by keys, from the root
+// down. keys may stop short of the leaf level: a request made with just an
+// org key is only checked at the org level.
+//
+// Returns nil if the request is allowed, a *LimitExceededError if a level
+// denied it, or the error of a limiter that failed. Returns an
+// *InvalidConfigError if keys is empty or has more entries than there are
+// levels.
+func (h *Hierarchy) AllowN(ctx context.Context, keys []string, n int) error {
+	if len(keys) == 0 || len(keys) > len(h.levels) {
+		return &InvalidConfigError{Field: "keys", Value: len(keys), Reason: "must name between one key and one key per level"}
+	}
+	levels := h.levels[:len(keys)]
+
+	for i := range levels {
+		if err := h.take(ctx, i, keys[i], n); err != nil {
+			h.refund(ctx, keys[:i], n)
+			return err
+		}
+	}
+	return nil
+}
+
+// Levels returns the names of the levels from the root down.
+func (h *Hierarchy) Levels() []string {
+	names := make([]string, len(h.levels))
+	for i, lv := range h.levels {
+		names[i] = lv.Name
+	}
+	return names
+}
+
+// Close closes the limiters of every level.
+func (h *Hierarchy) Close() error {
+	var errs []error
+	for _, lv := range h.levels {
+		errs = append(errs, lv.Limiter.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// refund gives n tokens back to keys at the top levels of the hierarchy,
+// even if ctx has ended.
+func (h *Hierarchy) refund(ctx context.Context, keys []string, n int) {
+	ctx = context.WithoutCancel(ctx)
+	for i, key := range keys {
+		_ = h.levels[i].Limiter.Refund(ctx, key, n)
+	}
+}
+
+// take consumes n tokens for key at level i, returning a
+// *LimitExceededError if the level denies it.
+func (h *Hierarchy) take(ctx context.Context, i int, key string, n int) error {
+	lv := h.levels[i]
+	allowed, st, err := lv.Limiter.allowN(ctx, key, n)
+	if err != nil || allowed {
+		return err
+	}
+	return newLimitExceededError(lv.Name, key, lv.Limiter, st)
+}
+
+// newLimitExceededError describes a denial by l at the given hierarchy
+// level. st is nil if the fallback strategy denied the request.
+func newLimitExceededError(level, key string, l *Limiter, st *algorithm.State) *LimitExceededError {
+	e := &LimitExceededError{Key: l.HashKey(key), Level: level}
+	_, e.Window = l.Limit()
+	if st != nil {
+		e.Limit = int(st.Limit)
+		e.Used = int(st.Current)
+		e.RetryAfter = st.RetryAfter
+		e.ResetAt = st.ResetAt
+	}
+	return e
+}
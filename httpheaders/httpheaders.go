@@ -0,0 +1,144 @@
+// Package httpheaders writes the RateLimit HTTP response header fields of
+// the IETF draft "RateLimit header fields for HTTP"
+// (draft-ietf-httpapi-ratelimit-headers) from flexlimit state.
+//
+// Set writes
+//
+//	RateLimit-Limit: 100
+//	RateLimit-Remaining: 42
+//	RateLimit-Reset: 17
+//	RateLimit-Policy: 100;w=60
+//
+// where Reset is in whole seconds, rounded up. When a request is checked
+// against several limits (e.g., a per-second and a per-day limiter), pass
+// all of them: Limit, Remaining and Reset describe the one closest to
+// being exhausted, and RateLimit-Policy lists every quota, as in
+// "10;w=1, 1000;w=86400".
+//
+// Example:
+//
+//	st, err := limiter.State(ctx, key)
+//	if err == nil {
+//	    httpheaders.Set(w.Header(), httpheaders.FromState(st))
+//	}
+package httpheaders
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Vipul984/flexlimit"
+)
+
+// Header field names.
+const (
+	HeaderLimit     = "RateLimit-Limit"
+	HeaderRemaining = "RateLimit-Remaining"
+	HeaderReset     = "RateLimit-Reset"
+	HeaderPolicy    = "RateLimit-Policy"
+)
+
+// Quota is one limit reported in the headers.
+type Quota struct {
+	// Limit is the number of requests allowed per Window
+	Limit int
+
+	// Remaining is the number of requests left in the current window
+	Remaining int
+
+	// ResetIn is the time until the quota resets
+	ResetIn time.Duration
+
+	// Window is the quota's time window. A zero Window is left out of
+	// RateLimit-Policy
+	Window time.Duration
+}
+
+// FromState returns the quota described by st.
+func FromState(st *flexlimit.State) Quota {
+	return Quota{
+		Limit:     st.Limit,
+		Remaining: st.Remaining,
+		ResetIn:   st.ResetIn,
+		Window:    st.Window,
+	}
+}
+
+// FromLimitInfo returns the quota described by info. LimitInfo doesn't
+// carry the limiter's window, so it is passed separately; see
+// flexlimit.Limiter.Limit.
+func FromLimitInfo(info flexlimit.LimitInfo, window time.Duration) Quota {
+	return Quota{
+		Limit:     info.Limit,
+		Remaining: info.Remaining,
+		ResetIn:   info.ResetIn,
+		Window:    window,
+	}
+}
+
+// Set writes the RateLimit fields for quotas to h, replacing existing
+// ones. It does nothing if quotas is empty.
+func Set(h http.Header, quotas ...Quota) {
+	if len(quotas) == 0 {
+		return
+	}
+
+	active := Active(quotas...)
+	h.Set(HeaderLimit, strconv.Itoa(active.Limit))
+	h.Set(HeaderRemaining, strconv.Itoa(max(active.Remaining, 0)))
+	h.Set(HeaderReset, strconv.FormatInt(seconds(active.ResetIn), 10))
+
+	if policy := Policy(quotas...); policy != "" {
+		h.Set(HeaderPolicy, policy)
+	} else {
+		h.Del(HeaderPolicy)
+	}
+}
+
+// Active returns the quota closest to being exhausted: the one with the
+// fewest remaining requests, and of those the one that resets last.
+func Active(quotas ...Quota) Quota {
+	var active Quota
+	for i, q := range quotas {
+		if i == 0 || q.Remaining < active.Remaining ||
+			(q.Remaining == active.Remaining && q.ResetIn > active.ResetIn) {
+			active = q
+		}
+	}
+	return active
+}
+
+// Policy formats quotas as a RateLimit-Policy list, e.g.
+// "10;w=1, 1000;w=86400". Windows are in whole seconds, rounded up;
+// quotas without a window are listed by their limit alone. It returns ""
+// if no quota has a window.
+func Policy(quotas ...Quota) string {
+	var b strings.Builder
+	windows := false
+	for i, q := range quotas {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(strconv.Itoa(q.Limit))
+		if q.Window > 0 {
+			windows = true
+			b.WriteString(";w=")
+			b.WriteString(strconv.FormatInt(seconds(q.Window), 10))
+		}
+	}
+
+	if !windows {
+		return ""
+	}
+	return b.String()
+}
+
+// seconds returns d in whole seconds, rounded up, and never negative.
+func seconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64((d + time.Second - 1) / time.Second)
+}
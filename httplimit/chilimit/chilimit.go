@@ -0,0 +1,83 @@
+// Package chilimit rate limits chi routes with flexlimit. chi middleware
+// is standard net/http middleware, so Limit and Middleware are those of
+// the httplimit package; this package adds key functions reading chi's
+// routing context.
+//
+// chi only knows a request's route and path parameters once it has been
+// routed, so middleware using URLParam or PerRoute must be attached to
+// routes, with Router.With, Router.Group or Router.Route, rather than to
+// the whole router with Router.Use. Behind a load balancer, identify
+// clients with a clientip.Resolver rather than chi's RealIP middleware,
+// which trusts the headers of every client.
+//
+// Example:
+//
+//	limiter, err := flexlimit.New(100, time.Minute)
+//	if err != nil {
+//	    return err
+//	}
+//	proxies, err := clientip.New([]string{"10.0.0.0/8"})
+//	if err != nil {
+//	    return err
+//	}
+//	r := chi.NewRouter()
+//	r.Use(chilimit.Limit(limiter, proxies.Key))
+//	r.With(chilimit.Limit(searches, chilimit.PerRoute(proxies.Key))).Post("/search", search)
+package chilimit
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Vipul984/flexlimit"
+	"github.com/Vipul984/flexlimit/httplimit"
+)
+
+// Middleware rate limits requests with policy, describing each request
+// with attrs. See httplimit.Middleware.
+func Middleware(policy *flexlimit.Composite, attrs func(*http.Request) flexlimit.Attributes) func(http.Handler) http.Handler {
+	return httplimit.Middleware(policy, attrs)
+}
+
+// Limit rate limits requests with l, identifying clients with key.
+// Requests for which key returns "" aren't limited. See httplimit.Limit.
+func Limit(l *flexlimit.Limiter, key func(*http.Request) string) func(http.Handler) http.Handler {
+	return httplimit.Limit(l, key)
+}
+
+// Header returns a key function returning the value of the request header
+// name, prefixed with the lowercase name and ":", or "" if the request
+// doesn't have it.
+func Header(name string) func(*http.Request) string {
+	return httplimit.Header(name)
+}
+
+// URLParam returns a key function returning the value of the path
+// parameter name, prefixed with name and ":", or "" if the route has no
+// such parameter.
+func URLParam(name string) func(*http.Request) string {
+	return func(r *http.Request) string {
+		if v := chi.URLParam(r, name); v != "" {
+			return name + ":" + v
+		}
+		return ""
+	}
+}
+
+// PerRoute returns a key function giving each route its own limit: the
+// key returned by key, prefixed with the request method, the route's
+// pattern and "|", as in "GET /users/{id}|ip:10.0.0.1".
+func PerRoute(key func(*http.Request) string) func(*http.Request) string {
+	return func(r *http.Request) string {
+		k := key(r)
+		if k == "" {
+			return ""
+		}
+		var pattern string
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			pattern = rctx.RoutePattern()
+		}
+		return r.Method + " " + pattern + "|" + k
+	}
+}
@@ -0,0 +1,113 @@
+// Package echolimit rate limits echo routes with flexlimit. Refused
+// requests end in an *echo.HTTPError handled by the server's
+// HTTPErrorHandler: 429 Too Many Requests, with Retry-After and RateLimit
+// header fields already set on the response, or 503 Service Unavailable
+// if a limiter fails, whose error is the HTTPError's internal error.
+//
+// The key functions below identify clients from an echo.Context. RealIP
+// finds client addresses with a clientip.Resolver rather than
+// echo.Context.RealIP, which trusts the forwarding headers of every
+// client unless the server's IPExtractor is set.
+//
+// Example:
+//
+//	limiter, err := flexlimit.New(100, time.Minute)
+//	if err != nil {
+//	    return err
+//	}
+//	proxies, err := clientip.New([]string{"10.0.0.0/8"})
+//	if err != nil {
+//	    return err
+//	}
+//	e := echo.New()
+//	e.Use(echolimit.Limit(limiter, echolimit.RealIP(proxies)))
+//	e.POST("/search", search, echolimit.Limit(searches, echolimit.PerRoute(echolimit.Header("X-API-Key"))))
+package echolimit
+
+import (
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/Vipul984/flexlimit"
+	"github.com/Vipul984/flexlimit/clientip"
+	"github.com/Vipul984/flexlimit/httplimit"
+)
+
+// Middleware rate limits requests with policy, describing each request
+// with attrs.
+func Middleware(policy *flexlimit.Composite, attrs func(echo.Context) flexlimit.Attributes) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			err := policy.Allow(c.Request().Context(), attrs(c))
+			if err == nil {
+				return next(c)
+			}
+
+			resp := httplimit.Deny(err)
+			for name, values := range resp.Header {
+				c.Response().Header()[name] = values
+			}
+			return echo.NewHTTPError(resp.Status, resp.Body).SetInternal(err)
+		}
+	}
+}
+
+// Limit rate limits requests with l, identifying clients with key.
+// Requests for which key returns "" aren't limited.
+func Limit(l *flexlimit.Limiter, key func(echo.Context) string) echo.MiddlewareFunc {
+	return Middleware(httplimit.Single(l), func(c echo.Context) flexlimit.Attributes {
+		return flexlimit.Attributes{httplimit.KeyAttr: key(c)}
+	})
+}
+
+// RealIP returns a key function returning "ip:" followed by the client
+// address of the request, as found by proxies (see clientip.Resolver).
+// Forwarding headers are only read from the trusted proxies of proxies; a
+// nil Resolver trusts none and keys by the connection's peer.
+func RealIP(proxies *clientip.Resolver) func(echo.Context) string {
+	if proxies == nil {
+		proxies = &clientip.Resolver{}
+	}
+	return func(c echo.Context) string {
+		return proxies.Key(c.Request())
+	}
+}
+
+// Header returns a key function returning the value of the request header
+// name, prefixed with the lowercase name and ":", or "" if the request
+// doesn't have it.
+func Header(name string) func(echo.Context) string {
+	prefix := strings.ToLower(name) + ":"
+	return func(c echo.Context) string {
+		if v := c.Request().Header.Get(name); v != "" {
+			return prefix + v
+		}
+		return ""
+	}
+}
+
+// Param returns a key function returning the value of the path parameter
+// name, prefixed with name and ":", or "" if the route has no such
+// parameter.
+func Param(name string) func(echo.Context) string {
+	return func(c echo.Context) string {
+		if v := c.Param(name); v != "" {
+			return name + ":" + v
+		}
+		return ""
+	}
+}
+
+// PerRoute returns a key function giving each route its own limit: the
+// key returned by key, prefixed with the request method, the route's
+// pattern and "|", as in "GET /users/:id|ip:10.0.0.1".
+func PerRoute(key func(echo.Context) string) func(echo.Context) string {
+	return func(c echo.Context) string {
+		k := key(c)
+		if k == "" {
+			return ""
+		}
+		return c.Request().Method + " " + c.Path() + "|" + k
+	}
+}
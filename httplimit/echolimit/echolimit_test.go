@@ -0,0 +1,41 @@
+package echolimit
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/Vipul984/flexlimit/clientip"
+)
+
+func TestRealIP(t *testing.T) {
+	proxies, err := clientip.New([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		proxies *clientip.Resolver
+		peer    string
+		xff     string
+		want    string
+	}{
+		{"spoofed header from a client", proxies, "203.0.113.7:1234", "198.51.100.1", "ip:203.0.113.7"},
+		{"header from a trusted proxy", proxies, "10.0.0.1:1234", "198.51.100.1", "ip:198.51.100.1"},
+		{"no resolver", nil, "10.0.0.1:1234", "198.51.100.1", "ip:10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.peer
+			req.Header.Set("X-Forwarded-For", tt.xff)
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+
+			if got := RealIP(tt.proxies)(c); got != tt.want {
+				t.Errorf("RealIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
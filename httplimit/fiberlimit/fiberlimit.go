@@ -0,0 +1,111 @@
+// Package fiberlimit rate limits fiber routes with flexlimit. Refused
+// requests end in a *fiber.Error handled by the app's ErrorHandler: 429
+// Too Many Requests, with Retry-After and RateLimit header fields already
+// set on the response, or 503 Service Unavailable if a limiter fails.
+//
+// The key functions below identify clients from a *fiber.Ctx. IP relies
+// on fiber's own proxy handling, so configure fiber.Config's
+// ProxyHeader, EnableTrustedProxyCheck and TrustedProxies when running
+// behind a load balancer.
+//
+// fiber reuses the strings of a request once its handler returns, so the
+// middleware copies attribute values before they reach the limiter and
+// its storage.
+//
+// Example:
+//
+//	limiter, err := flexlimit.New(100, time.Minute)
+//	if err != nil {
+//	    return err
+//	}
+//	app := fiber.New()
+//	app.Use(fiberlimit.Limit(limiter, fiberlimit.IP))
+//	app.Post("/search", fiberlimit.Limit(searches, fiberlimit.PerRoute(fiberlimit.Header("X-API-Key"))), search)
+package fiberlimit
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/Vipul984/flexlimit"
+	"github.com/Vipul984/flexlimit/httplimit"
+)
+
+// Middleware rate limits requests with policy, describing each request
+// with attrs.
+func Middleware(policy *flexlimit.Composite, attrs func(*fiber.Ctx) flexlimit.Attributes) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		a := attrs(c)
+		for name, value := range a {
+			a[name] = strings.Clone(value)
+		}
+
+		err := policy.Allow(c.UserContext(), a)
+		if err == nil {
+			return c.Next()
+		}
+
+		resp := httplimit.Deny(err)
+		for name, values := range resp.Header {
+			for _, v := range values {
+				c.Append(name, v)
+			}
+		}
+		return fiber.NewError(resp.Status, resp.Body)
+	}
+}
+
+// Limit rate limits requests with l, identifying clients with key.
+// Requests for which key returns "" aren't limited.
+func Limit(l *flexlimit.Limiter, key func(*fiber.Ctx) string) fiber.Handler {
+	return Middleware(httplimit.Single(l), func(c *fiber.Ctx) flexlimit.Attributes {
+		return flexlimit.Attributes{httplimit.KeyAttr: key(c)}
+	})
+}
+
+// IP returns "ip:" followed by the client address of the request, as
+// found by fiber.Ctx.IP.
+func IP(c *fiber.Ctx) string {
+	return "ip:" + c.IP()
+}
+
+// Header returns a key function returning the value of the request header
+// name, prefixed with the lowercase name and ":", or "" if the request
+// doesn't have it.
+func Header(name string) func(*fiber.Ctx) string {
+	prefix := strings.ToLower(name) + ":"
+	return func(c *fiber.Ctx) string {
+		if v := c.Get(name); v != "" {
+			return prefix + v
+		}
+		return ""
+	}
+}
+
+// Params returns a key function returning the value of the route
+// parameter name, prefixed with name and ":", or "" if the route has no
+// such parameter.
+func Params(name string) func(*fiber.Ctx) string {
+	return func(c *fiber.Ctx) string {
+		if v := c.Params(name); v != "" {
+			return name + ":" + v
+		}
+		return ""
+	}
+}
+
+// PerRoute returns a key function giving each route its own limit: the
+// key returned by key, prefixed with the request method, the route's
+// path and "|", as in "GET /users/:id|ip:10.0.0.1". In middleware
+// registered with App.Use, the route is the Use route itself, so attach
+// PerRoute limits to the routes they limit.
+func PerRoute(key func(*fiber.Ctx) string) func(*fiber.Ctx) string {
+	return func(c *fiber.Ctx) string {
+		k := key(c)
+		if k == "" {
+			return ""
+		}
+		return c.Method() + " " + c.Route().Path + "|" + k
+	}
+}
@@ -0,0 +1,116 @@
+// Package ginlimit rate limits gin routes with flexlimit, answering like
+// the httplimit middleware: 429 Too Many Requests with Retry-After and
+// RateLimit header fields, or 503 Service Unavailable if a limiter fails.
+//
+// The key functions below identify clients from a *gin.Context. ClientIP
+// finds client addresses with a clientip.Resolver rather than
+// gin.Context.ClientIP, which trusts the forwarding headers of every
+// client unless the engine's trusted proxies are configured.
+//
+// Example:
+//
+//	limiter, err := flexlimit.New(100, time.Minute)
+//	if err != nil {
+//	    return err
+//	}
+//	proxies, err := clientip.New([]string{"10.0.0.0/8"})
+//	if err != nil {
+//	    return err
+//	}
+//	r := gin.Default()
+//	r.Use(ginlimit.Limit(limiter, ginlimit.ClientIP(proxies)))
+//	r.POST("/search", ginlimit.Limit(searches, ginlimit.PerRoute(ginlimit.Header("X-API-Key"))), search)
+package ginlimit
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Vipul984/flexlimit"
+	"github.com/Vipul984/flexlimit/clientip"
+	"github.com/Vipul984/flexlimit/httplimit"
+)
+
+// Middleware rate limits requests with policy, describing each request
+// with attrs. Refused requests are aborted; if a limiter failed, its
+// error is attached to the context (see gin.Context.Error).
+func Middleware(policy *flexlimit.Composite, attrs func(*gin.Context) flexlimit.Attributes) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := policy.Allow(c.Request.Context(), attrs(c))
+		if err == nil {
+			c.Next()
+			return
+		}
+
+		resp := httplimit.Deny(err)
+		if resp.Status != http.StatusTooManyRequests {
+			_ = c.Error(err)
+		}
+		for name, values := range resp.Header {
+			c.Writer.Header()[name] = values
+		}
+		c.String(resp.Status, resp.Body)
+		c.Abort()
+	}
+}
+
+// Limit rate limits requests with l, identifying clients with key.
+// Requests for which key returns "" aren't limited.
+func Limit(l *flexlimit.Limiter, key func(*gin.Context) string) gin.HandlerFunc {
+	return Middleware(httplimit.Single(l), func(c *gin.Context) flexlimit.Attributes {
+		return flexlimit.Attributes{httplimit.KeyAttr: key(c)}
+	})
+}
+
+// ClientIP returns a key function returning "ip:" followed by the client
+// address of the request, as found by proxies (see clientip.Resolver).
+// Forwarding headers are only read from the trusted proxies of proxies; a
+// nil Resolver trusts none and keys by the connection's peer.
+func ClientIP(proxies *clientip.Resolver) func(*gin.Context) string {
+	if proxies == nil {
+		proxies = &clientip.Resolver{}
+	}
+	return func(c *gin.Context) string {
+		return proxies.Key(c.Request)
+	}
+}
+
+// Header returns a key function returning the value of the request header
+// name, prefixed with the lowercase name and ":", or "" if the request
+// doesn't have it.
+func Header(name string) func(*gin.Context) string {
+	prefix := strings.ToLower(name) + ":"
+	return func(c *gin.Context) string {
+		if v := c.GetHeader(name); v != "" {
+			return prefix + v
+		}
+		return ""
+	}
+}
+
+// Param returns a key function returning the value of the path parameter
+// name, prefixed with name and ":", or "" if the route has no such
+// parameter.
+func Param(name string) func(*gin.Context) string {
+	return func(c *gin.Context) string {
+		if v := c.Param(name); v != "" {
+			return name + ":" + v
+		}
+		return ""
+	}
+}
+
+// PerRoute returns a key function giving each route its own limit: the
+// key returned by key, prefixed with the request method, the route's
+// pattern and "|", as in "GET /users/:id|ip:10.0.0.1".
+func PerRoute(key func(*gin.Context) string) func(*gin.Context) string {
+	return func(c *gin.Context) string {
+		k := key(c)
+		if k == "" {
+			return ""
+		}
+		return c.Request.Method + " " + c.FullPath() + "|" + k
+	}
+}
@@ -0,0 +1,42 @@
+package ginlimit
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/Vipul984/flexlimit/clientip"
+)
+
+func TestClientIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	proxies, err := clientip.New([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		proxies *clientip.Resolver
+		peer    string
+		xff     string
+		want    string
+	}{
+		{"spoofed header from a client", proxies, "203.0.113.7:1234", "198.51.100.1", "ip:203.0.113.7"},
+		{"header from a trusted proxy", proxies, "10.0.0.1:1234", "198.51.100.1", "ip:198.51.100.1"},
+		{"no resolver", nil, "10.0.0.1:1234", "198.51.100.1", "ip:10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", "/", nil)
+			c.Request.RemoteAddr = tt.peer
+			c.Request.Header.Set("X-Forwarded-For", tt.xff)
+
+			if got := ClientIP(tt.proxies)(c); got != tt.want {
+				t.Errorf("ClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
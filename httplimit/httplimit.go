@@ -0,0 +1,138 @@
+// Package httplimit rate limits HTTP servers with a flexlimit.Limiter, or
+// with every rule of a flexlimit.Composite, keyed by client.
+//
+// Denied requests get 429 Too Many Requests with Retry-After and the
+// RateLimit header fields of the limit that denied them (see httpheaders).
+// If a limiter fails, the request gets 503 Service Unavailable; storage
+// failures are handled by each limiter's fallback strategy and don't get
+// there.
+//
+// Middleware and Limit wrap net/http handlers, and so routers built on
+// them such as chi (see the chilimit package). The subpackages ginlimit,
+// echolimit and fiberlimit adapt the same behavior to gin, echo and fiber,
+// through Deny, which describes the response to a denied request without
+// writing it.
+//
+// Example:
+//
+//	limiter, err := flexlimit.New(100, time.Minute)
+//	if err != nil {
+//	    return err
+//	}
+//	proxies, err := clientip.New([]string{"10.0.0.0/8"})
+//	if err != nil {
+//	    return err
+//	}
+//	http.ListenAndServe(":8080", httplimit.Limit(limiter, proxies.Key)(mux))
+package httplimit
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Vipul984/flexlimit"
+	"github.com/Vipul984/flexlimit/httpheaders"
+)
+
+// KeyAttr is the attribute holding the client key in the Composite
+// returned by Single.
+const KeyAttr = "key"
+
+// Single returns a Composite with one rule, named "limit", enforcing l on
+// the key held by the KeyAttr attribute. A request whose key is "" isn't
+// limited. The caller keeps ownership of l. Single panics if l is nil.
+func Single(l *flexlimit.Limiter) *flexlimit.Composite {
+	policy, err := flexlimit.NewComposite(flexlimit.Rule{
+		Name:    "limit",
+		Limiter: l,
+		Key:     func(a flexlimit.Attributes) string { return a[KeyAttr] },
+	})
+	if err != nil {
+		panic("httplimit: " + err.Error())
+	}
+	return policy
+}
+
+// Middleware rate limits requests with policy, describing each request
+// with attrs.
+func Middleware(policy *flexlimit.Composite, attrs func(*http.Request) flexlimit.Attributes) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := policy.Allow(r.Context(), attrs(r)); err != nil {
+				Deny(err).Write(w)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Limit rate limits requests with l, identifying clients with key, such as
+// clientip.Resolver.Key. Requests for which key returns "" aren't limited.
+func Limit(l *flexlimit.Limiter, key func(*http.Request) string) func(http.Handler) http.Handler {
+	return Middleware(Single(l), func(r *http.Request) flexlimit.Attributes {
+		return flexlimit.Attributes{KeyAttr: key(r)}
+	})
+}
+
+// Header returns a key function returning the value of the request header
+// name, prefixed with the lowercase name and ":", as in "x-api-key:k3y",
+// or "" if the request doesn't have it.
+func Header(name string) func(*http.Request) string {
+	prefix := strings.ToLower(name) + ":"
+	return func(r *http.Request) string {
+		if v := r.Header.Get(name); v != "" {
+			return prefix + v
+		}
+		return ""
+	}
+}
+
+// Response is the response to a request refused by the limits, for
+// frameworks that don't write responses to an http.ResponseWriter.
+type Response struct {
+	// Status is 429 Too Many Requests if the request was denied, or 503
+	// Service Unavailable if a limiter failed
+	Status int
+
+	// Header holds Retry-After and the RateLimit header fields of the
+	// limit that denied the request
+	Header http.Header
+
+	// Body is a short plain text explanation
+	Body string
+}
+
+// Deny returns the response to a request refused with err, the error of
+// flexlimit.Composite.Allow.
+func Deny(err error) Response {
+	var limitErr *flexlimit.LimitExceededError
+	if !errors.As(err, &limitErr) {
+		return Response{
+			Status: http.StatusServiceUnavailable,
+			Header: http.Header{},
+			Body:   "rate limiter unavailable",
+		}
+	}
+
+	h := http.Header{}
+	httpheaders.Set(h, httpheaders.Quota{
+		Limit:     limitErr.Limit,
+		Remaining: limitErr.Limit - limitErr.Used,
+		ResetIn:   limitErr.RetryAfter,
+		Window:    limitErr.Window,
+	})
+	retryAfter := int(limitErr.RetryAfter.Seconds() + 0.999)
+	h.Set("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+	return Response{Status: http.StatusTooManyRequests, Header: h, Body: "too many requests"}
+}
+
+// Write writes resp to w as a plain text error.
+func (resp Response) Write(w http.ResponseWriter) {
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	http.Error(w, resp.Body, resp.Status)
+}
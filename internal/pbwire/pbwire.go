@@ -0,0 +1,261 @@
+// Package pbwire encodes and decodes the protobuf messages of
+// proto/flexlimit/v1/flexlimit.proto field by field, with the well-known
+// Timestamp, Duration and Struct types.
+//
+// Encoding follows proto3: scalar fields holding their zero value, zero
+// times and zero durations are omitted. Decoding skips unknown fields, so
+// newer writers stay readable.
+package pbwire
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Well-known message fields
+const (
+	fieldSeconds protowire.Number = 1
+	fieldNanos   protowire.Number = 2
+	fieldKey     protowire.Number = 1
+	fieldValue   protowire.Number = 2
+)
+
+// AppendInt64 appends an int64 field.
+func AppendInt64(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+// AppendDouble appends a double field.
+func AppendDouble(b []byte, num protowire.Number, v float64) []byte {
+	if v == 0 && !math.Signbit(v) {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+// AppendBool appends a bool field.
+func AppendBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, 1)
+}
+
+// AppendString appends a string field.
+func AppendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+// AppendTime appends a google.protobuf.Timestamp field, unless t is zero.
+func AppendTime(b []byte, num protowire.Number, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	return AppendTimestamp(b, num, t)
+}
+
+// AppendTimestamp appends a google.protobuf.Timestamp field, even if t is
+// zero, as repeated fields must.
+func AppendTimestamp(b []byte, num protowire.Number, t time.Time) []byte {
+	return appendSecondsNanos(b, num, t.Unix(), int64(t.Nanosecond()))
+}
+
+// AppendDuration appends a google.protobuf.Duration field.
+func AppendDuration(b []byte, num protowire.Number, d time.Duration) []byte {
+	if d == 0 {
+		return b
+	}
+	return appendSecondsNanos(b, num, int64(d/time.Second), int64(d%time.Second))
+}
+
+// appendSecondsNanos appends a Timestamp or Duration message.
+func appendSecondsNanos(b []byte, num protowire.Number, seconds, nanos int64) []byte {
+	var msg []byte
+	msg = AppendInt64(msg, fieldSeconds, seconds)
+	msg = AppendInt64(msg, fieldNanos, nanos)
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+// AppendStruct appends a google.protobuf.Struct field, unless m is empty.
+// Values must be JSON-like: nil, bools, numbers, strings, and slices and
+// maps of them.
+func AppendStruct(b []byte, num protowire.Number, m map[string]interface{}) ([]byte, error) {
+	if len(m) == 0 {
+		return b, nil
+	}
+	s, err := structpb.NewStruct(m)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := proto.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg), nil
+}
+
+// AppendStringMap appends a map<string, string> field.
+func AppendStringMap(b []byte, num protowire.Number, m map[string]string) []byte {
+	for k, v := range m {
+		var entry []byte
+		entry = AppendString(entry, fieldKey, k)
+		entry = AppendString(entry, fieldValue, v)
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	return b
+}
+
+// Field is one field of a message being decoded.
+type Field struct {
+	Num  protowire.Number
+	Type protowire.Type
+
+	value uint64 // varint and fixed values
+	bytes []byte // length-delimited values
+}
+
+// Range calls fn with each field of msg in order.
+func Range(msg []byte, fn func(f Field) error) error {
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		msg = msg[n:]
+
+		f := Field{Num: num, Type: typ}
+		switch typ {
+		case protowire.VarintType:
+			f.value, n = protowire.ConsumeVarint(msg)
+		case protowire.Fixed64Type:
+			f.value, n = protowire.ConsumeFixed64(msg)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(msg)
+			f.value = uint64(v)
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(msg)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, msg)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		msg = msg[n:]
+
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expect returns an error if f is not of type typ.
+func (f Field) expect(typ protowire.Type) error {
+	if f.Type != typ {
+		return fmt.Errorf("field %d: wire type %d, want %d", f.Num, f.Type, typ)
+	}
+	return nil
+}
+
+// Int64 decodes an int64 field.
+func (f Field) Int64() (int64, error) {
+	return int64(f.value), f.expect(protowire.VarintType)
+}
+
+// Double decodes a double field.
+func (f Field) Double() (float64, error) {
+	return math.Float64frombits(f.value), f.expect(protowire.Fixed64Type)
+}
+
+// Bool decodes a bool field.
+func (f Field) Bool() (bool, error) {
+	return f.value != 0, f.expect(protowire.VarintType)
+}
+
+// String decodes a string field.
+func (f Field) String() (string, error) {
+	return string(f.bytes), f.expect(protowire.BytesType)
+}
+
+// Time decodes a google.protobuf.Timestamp field, in UTC.
+func (f Field) Time() (time.Time, error) {
+	seconds, nanos, err := f.secondsNanos()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(seconds, nanos).UTC(), nil
+}
+
+// Duration decodes a google.protobuf.Duration field.
+func (f Field) Duration() (time.Duration, error) {
+	seconds, nanos, err := f.secondsNanos()
+	return time.Duration(seconds)*time.Second + time.Duration(nanos), err
+}
+
+// secondsNanos decodes a Timestamp or Duration message.
+func (f Field) secondsNanos() (seconds, nanos int64, err error) {
+	if err := f.expect(protowire.BytesType); err != nil {
+		return 0, 0, err
+	}
+	err = Range(f.bytes, func(g Field) error {
+		var err error
+		switch g.Num {
+		case fieldSeconds:
+			seconds, err = g.Int64()
+		case fieldNanos:
+			nanos, err = g.Int64()
+			nanos = int64(int32(nanos))
+		}
+		return err
+	})
+	return seconds, nanos, err
+}
+
+// Struct decodes a google.protobuf.Struct field.
+func (f Field) Struct() (map[string]interface{}, error) {
+	if err := f.expect(protowire.BytesType); err != nil {
+		return nil, err
+	}
+	var s structpb.Struct
+	if err := proto.Unmarshal(f.bytes, &s); err != nil {
+		return nil, err
+	}
+	return s.AsMap(), nil
+}
+
+// StringMapEntry decodes one entry of a map<string, string> field.
+func (f Field) StringMapEntry() (key, value string, err error) {
+	if err := f.expect(protowire.BytesType); err != nil {
+		return "", "", err
+	}
+	err = Range(f.bytes, func(g Field) error {
+		var err error
+		switch g.Num {
+		case fieldKey:
+			key, err = g.String()
+		case fieldValue:
+			value, err = g.String()
+		}
+		return err
+	})
+	return key, value, err
+}
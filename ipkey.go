@@ -0,0 +1,79 @@
+package flexlimit
+
+import (
+	"net/netip"
+)
+
+// IPAggregation groups IP addresses into prefixes for keying, so that
+// every address of a subnet shares one key. A client rotating through the
+// addresses of its /24, or through the 2^64 addresses an IPv6 /64 gives
+// every home connection, then can't get a fresh limit per address.
+type IPAggregation struct {
+	// IPv4Bits is the prefix length IPv4 addresses are grouped by, in
+	// [1, 32]. Default: 32, one key per address
+	IPv4Bits int
+
+	// IPv6Bits is the prefix length IPv6 addresses are grouped by, in
+	// [1, 128]. Default: 64, one key per subnet
+	IPv6Bits int
+}
+
+// DefaultIPAggregation keeps IPv4 addresses apart and groups IPv6
+// addresses by /64, the usual allocation to a single customer.
+var DefaultIPAggregation = IPAggregation{IPv4Bits: 32, IPv6Bits: 64}
+
+// Validate returns an *InvalidConfigError if a prefix length is out of
+// range.
+func (a IPAggregation) Validate() error {
+	if a.IPv4Bits < 0 || a.IPv4Bits > 32 {
+		return &InvalidConfigError{Field: "ipv4_bits", Value: a.IPv4Bits, Reason: "must be between 1 and 32, or 0 for the default"}
+	}
+	if a.IPv6Bits < 0 || a.IPv6Bits > 128 {
+		return &InvalidConfigError{Field: "ipv6_bits", Value: a.IPv6Bits, Reason: "must be between 1 and 128, or 0 for the default"}
+	}
+	return nil
+}
+
+// Prefix returns the prefix ip belongs to. IPv4-mapped IPv6 addresses
+// count as IPv4, and zones are dropped. ok is false if ip isn't an IP
+// address or a prefix length is out of range.
+func (a IPAggregation) Prefix(ip string) (prefix netip.Prefix, ok bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || a.Validate() != nil {
+		return netip.Prefix{}, false
+	}
+	addr = addr.Unmap().WithZone("")
+
+	bits := a.IPv6Bits
+	if bits == 0 {
+		bits = DefaultIPAggregation.IPv6Bits
+	}
+	if addr.Is4() {
+		bits = a.IPv4Bits
+		if bits == 0 {
+			bits = DefaultIPAggregation.IPv4Bits
+		}
+	}
+	prefix, err = addr.Prefix(bits)
+	return prefix, err == nil
+}
+
+// Aggregate returns the key part for ip: its prefix in CIDR notation, as
+// in "203.0.113.0/24", or the address alone if the prefix is the whole
+// address, so keys of unaggregated IPv4 addresses don't change. ip is
+// returned as is if it isn't an IP address.
+//
+// Example:
+//
+//	agg := flexlimit.IPAggregation{IPv4Bits: 24}
+//	key := "ip:" + agg.Aggregate(clientIP) // "ip:203.0.113.0/24", "ip:2001:db8:1:2::/64"
+func (a IPAggregation) Aggregate(ip string) string {
+	prefix, ok := a.Prefix(ip)
+	if !ok {
+		return ip
+	}
+	if prefix.IsSingleIP() {
+		return prefix.Addr().String()
+	}
+	return prefix.String()
+}
@@ -0,0 +1,60 @@
+package flexlimit
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// WithKeyHashing replaces every key by its SHA-256 digest, in hex, before
+// it reaches storage, callbacks, metrics, errors and the states the
+// limiter returns, so raw IP addresses, emails or API keys never land in
+// Redis or in logs. Hashing is deterministic: a key is limited the same
+// way as without it.
+//
+// With a secret, keys are hashed with HMAC-SHA-256 keyed by it instead.
+// Keys drawn from a small space, such as IPv4 addresses, are easily
+// recovered from their plain SHA-256 digest, so use a secret unless the
+// keys already carry enough entropy, and keep it the same across the
+// instances sharing a storage: changing it starts every key over.
+//
+// Methods taking a single key (Allow, Wait, State, Check, Reset, Refund,
+// Ban, Unban, ReportOutcome and the composites built on them) take the
+// raw key and hash it, and allowlists and denylists (see WithAllowlist)
+// match raw keys. Keys, Usage and States work on the stored, hashed keys;
+// HashKey gives the stored key of a raw one.
+//
+// Example:
+//
+//	limiter, err := flexlimit.New(100, time.Minute,
+//	    flexlimit.WithStorage(redisStore),
+//	    flexlimit.WithKeyHashing([]byte(os.Getenv("RATELIMIT_KEY_SECRET"))),
+//	)
+//
+// Default: keys are stored as given
+func WithKeyHashing(secret []byte) Option {
+	return func(o *Options) {
+		o.hashKeys = true
+		o.keySecret = bytes.Clone(secret)
+	}
+}
+
+// HashKey returns the key under which the limiter stores and reports the
+// state of key: its digest with WithKeyHashing, key itself otherwise.
+func (l *Limiter) HashKey(key string) string {
+	if !l.opts.hashKeys {
+		return key
+	}
+
+	var sum []byte
+	if len(l.opts.keySecret) > 0 {
+		mac := hmac.New(sha256.New, l.opts.keySecret)
+		mac.Write([]byte(key))
+		sum = mac.Sum(nil)
+	} else {
+		digest := sha256.Sum256([]byte(key))
+		sum = digest[:]
+	}
+	return hex.EncodeToString(sum)
+}
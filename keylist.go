@@ -0,0 +1,297 @@
+package flexlimit
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/Vipul984/flexlimit/algorithm"
+	"github.com/Vipul984/flexlimit/metrics"
+)
+
+// KeyList is a set of keys and IP ranges, used to exempt keys from a
+// limiter or block them (see WithAllowlist and WithDenylist).
+//
+// An entry is either a CIDR range or IP address, such as "10.0.0.0/8" or
+// "2001:db8::1", or any other key, matched exactly. A key is in the list
+// if it is one of its keys, or if it is an IP key within one of its
+// ranges: an IP address or a CIDR range (see IPAggregation) after one of
+// the list's IP key prefixes, "ip:" and "subnet:" unless changed with
+// SetIPKeyPrefixes, as in "ip:10.1.2.3". Only IP keys are matched against
+// ranges, so a key built from a client-supplied value, such as
+// "x-api-key:10.0.0.1", can't pass for an address in the list.
+//
+// A KeyList may be changed at runtime and shared by several limiters; it
+// is safe for concurrent use.
+type KeyList struct {
+	mu       sync.RWMutex
+	keys     map[string]struct{}
+	prefixes []netip.Prefix
+
+	// ipKeyPrefixes are the prefixes of IP keys; nil for
+	// DefaultIPKeyPrefixes
+	ipKeyPrefixes []string
+}
+
+// DefaultIPKeyPrefixes are the prefixes of the keys a KeyList matches
+// against its ranges by default: those of the "ip" and "subnet" key
+// strategies (see RequestContext.Key) and of clientip.Resolver.Key.
+var DefaultIPKeyPrefixes = []string{"ip:", "subnet:"}
+
+// NewKeyList returns a list of entries. Returns an *InvalidConfigError if
+// an entry is empty or an invalid CIDR range.
+//
+// Example:
+//
+//	internal, err := flexlimit.NewKeyList("10.0.0.0/8", "fd00::/8", "apikey:admin")
+func NewKeyList(entries ...string) (*KeyList, error) {
+	list := &KeyList{keys: make(map[string]struct{})}
+	if err := list.Add(entries...); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Add adds entries to the list. Returns an *InvalidConfigError if an
+// entry is empty or an invalid CIDR range, in which case none is added.
+func (k *KeyList) Add(entries ...string) error {
+	keys := make([]string, 0, len(entries))
+	var prefixes []netip.Prefix
+	for _, entry := range entries {
+		prefix, isPrefix, err := parseEntry(entry)
+		if err != nil {
+			return err
+		}
+		if isPrefix {
+			prefixes = append(prefixes, prefix)
+		} else {
+			keys = append(keys, entry)
+		}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.keys == nil {
+		k.keys = make(map[string]struct{})
+	}
+	for _, key := range keys {
+		k.keys[key] = struct{}{}
+	}
+	for _, p := range prefixes {
+		if !slices.Contains(k.prefixes, p) {
+			k.prefixes = append(k.prefixes, p)
+		}
+	}
+	return nil
+}
+
+// Remove removes entries from the list. Entries not in the list are
+// ignored; a range is only removed by the same range, not by the
+// addresses or ranges it contains.
+func (k *KeyList) Remove(entries ...string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, entry := range entries {
+		if prefix, isPrefix, err := parseEntry(entry); err == nil && isPrefix {
+			k.prefixes = slices.DeleteFunc(k.prefixes, func(p netip.Prefix) bool { return p == prefix })
+		} else {
+			delete(k.keys, entry)
+		}
+	}
+}
+
+// SetIPKeyPrefixes sets the prefixes of the keys matched against the
+// list's ranges, replacing DefaultIPKeyPrefixes. Include "" to match keys
+// that are bare addresses, only if no key function of the limiter can
+// return a client-supplied value unprefixed.
+//
+// Example:
+//
+//	internal, _ := flexlimit.NewKeyList("10.0.0.0/8")
+//	internal.SetIPKeyPrefixes("client:")
+func (k *KeyList) SetIPKeyPrefixes(prefixes ...string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.ipKeyPrefixes = slices.Clone(prefixes)
+	if k.ipKeyPrefixes == nil {
+		k.ipKeyPrefixes = []string{}
+	}
+}
+
+// Entries returns the list's entries, keys first, each group sorted.
+func (k *KeyList) Entries() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	entries := make([]string, 0, len(k.keys)+len(k.prefixes))
+	for key := range k.keys {
+		entries = append(entries, key)
+	}
+	slices.Sort(entries)
+
+	ranges := make([]string, 0, len(k.prefixes))
+	for _, p := range k.prefixes {
+		ranges = append(ranges, p.String())
+	}
+	slices.Sort(ranges)
+	return append(entries, ranges...)
+}
+
+// Contains reports whether key is in the list.
+func (k *KeyList) Contains(key string) bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if _, ok := k.keys[key]; ok {
+		return true
+	}
+	if len(k.prefixes) == 0 {
+		return false
+	}
+	prefix, ok := k.keyPrefix(key)
+	if !ok {
+		return false
+	}
+	for _, p := range k.prefixes {
+		if p.Bits() <= prefix.Bits() && p.Contains(prefix.Addr()) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseEntry parses a list entry, returning its range if it is a CIDR
+// range or an IP address, which is a range of one address.
+func parseEntry(entry string) (netip.Prefix, bool, error) {
+	if entry == "" {
+		return netip.Prefix{}, false, &InvalidConfigError{Field: "key_list", Value: entry, Reason: "entry is empty"}
+	}
+
+	if addr, err := netip.ParseAddr(entry); err == nil {
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), true, nil
+	}
+
+	ip, _, found := strings.Cut(entry, "/")
+	if !found {
+		return netip.Prefix{}, false, nil
+	}
+	if _, err := netip.ParseAddr(ip); err != nil {
+		return netip.Prefix{}, false, nil // a key containing "/"
+	}
+	prefix, err := netip.ParsePrefix(entry)
+	if err != nil {
+		return netip.Prefix{}, false, &InvalidConfigError{Field: "key_list", Value: entry, Reason: "invalid CIDR range"}
+	}
+	if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	return prefix.Masked(), true, nil
+}
+
+// keyPrefix returns the IP address of key, as a range of one address, or
+// its CIDR range, after one of the list's IP key prefixes. Must be called
+// with k.mu held.
+func (k *KeyList) keyPrefix(key string) (netip.Prefix, bool) {
+	ipKeyPrefixes := k.ipKeyPrefixes
+	if ipKeyPrefixes == nil {
+		ipKeyPrefixes = DefaultIPKeyPrefixes
+	}
+	for _, p := range ipKeyPrefixes {
+		if rest, ok := strings.CutPrefix(key, p); ok {
+			if prefix, ok := parseKeyPrefix(rest); ok {
+				return prefix, true
+			}
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+// parseKeyPrefix parses s as an IP address or a CIDR range.
+func parseKeyPrefix(s string) (netip.Prefix, bool) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		addr = addr.Unmap().WithZone("")
+		return netip.PrefixFrom(addr, addr.BitLen()), true
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil || prefix.Addr().Is4In6() {
+		return netip.Prefix{}, false
+	}
+	return prefix.Masked(), true
+}
+
+// WithAllowlist exempts the keys of list from the limiter: their requests
+// are always allowed, before and without consulting the algorithm, and
+// consume nothing. Use it for internal networks, health checkers and
+// admin API keys. The list may be changed at runtime.
+//
+// Exempt requests are counted in metrics.Bypassed as well as
+// metrics.RequestsAllowed, but not reported to OnAllow. Check reports
+// them as allowed.
+//
+// Example:
+//
+//	internal, _ := flexlimit.NewKeyList("10.0.0.0/8", "apikey:admin")
+//	limiter, err := flexlimit.New(100, time.Minute, flexlimit.WithAllowlist(internal))
+func WithAllowlist(list *KeyList) Option {
+	return func(o *Options) {
+		o.allowlist = list
+	}
+}
+
+// WithDenylist blocks the keys of list: their requests are always denied,
+// before and without consulting the algorithm, and consume nothing. The
+// list may be changed at runtime, so keys can be blocked and unblocked
+// without a lifecycle policy (compare Limiter.Ban, which is stored with
+// the key and ends on its own). A key in both lists is blocked.
+//
+// Blocked requests are reported to OnLimit with LimitInfo.Blocked set and
+// counted in metrics.Blocked as well as metrics.RequestsDenied. They
+// don't count as denials for WithGracePeriod or WithLifecycle, and shadow
+// mode lets them through like other denials. Wait returns ErrKeyBlocked
+// instead of waiting for them.
+//
+// Example:
+//
+//	blocked, _ := flexlimit.NewKeyList()
+//	limiter, err := flexlimit.New(100, time.Minute, flexlimit.WithDenylist(blocked))
+//
+//	blocked.Add("203.0.113.0/24") // later, at runtime
+func WithDenylist(list *KeyList) Option {
+	return func(o *Options) {
+		o.denylist = list
+	}
+}
+
+// blocked reports whether key is in the limiter's denylist.
+func (l *Limiter) blocked(key string) bool {
+	return l.opts.denylist != nil && l.opts.denylist.Contains(key)
+}
+
+// exempt reports whether key is in the limiter's allowlist, or the limit is
+// suspended by a maintenance window, and key is not in its denylist.
+func (l *Limiter) exempt(key string) bool {
+	if l.blocked(key) {
+		return false
+	}
+	return l.suspended() || l.opts.allowlist != nil && l.opts.allowlist.Contains(key)
+}
+
+// bypass counts an allowed request for a key of the allowlist.
+func (l *Limiter) bypass() {
+	l.opts.metrics.IncCounter(metrics.Bypassed, l.labels)
+	l.opts.metrics.IncCounter(metrics.RequestsAllowed, l.labels)
+}
+
+// blockedState returns the state reported for a key of the denylist: out
+// of tokens, with no reset in sight.
+func (l *Limiter) blockedState(key string) *algorithm.State {
+	rate := int64(l.rate)
+	return &algorithm.State{
+		Key:       key,
+		Limit:     rate,
+		Current:   rate,
+		Algorithm: l.opts.algorithm,
+	}
+}
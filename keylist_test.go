@@ -0,0 +1,82 @@
+package flexlimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestKeyListContains(t *testing.T) {
+	list, err := NewKeyList("10.0.0.0/8", "2001:db8::/32", "apikey:admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"apikey:admin", true},
+		{"ip:10.1.2.3", true},
+		{"subnet:10.1.0.0/16", true},
+		{"ip:2001:db8::1", true},
+		{"subnet:2001:db8:1::/64", true},
+		{"ip:11.0.0.1", false},
+		{"subnet:10.0.0.0/7", false},
+		{"apikey:user", false},
+
+		// Only IP keys are matched against ranges
+		{"x-api-key:10.0.0.1", false},
+		{"user:10.0.0.1", false},
+		{"10.0.0.1", false},
+		{"ip:x-api-key:10.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := list.Contains(tt.key); got != tt.want {
+			t.Errorf("Contains(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestKeyListIPKeyPrefixes(t *testing.T) {
+	list, err := NewKeyList("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	list.SetIPKeyPrefixes("client:", "")
+
+	for key, want := range map[string]bool{
+		"client:10.0.0.1": true,
+		"10.0.0.1":        true,
+		"ip:10.0.0.1":     false,
+	} {
+		if got := list.Contains(key); got != want {
+			t.Errorf("Contains(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestAllowlistIgnoresNonIPKeys(t *testing.T) {
+	internal, err := NewKeyList("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := New(1, time.Minute, WithAllowlist(internal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	ctx := context.Background()
+	for i := range 3 {
+		if ok, _ := l.Allow(ctx, "ip:10.0.0.1"); !ok {
+			t.Fatalf("request %d of an internal address denied", i)
+		}
+	}
+
+	// A header value that looks like an internal address is limited
+	l.Allow(ctx, "x-api-key:10.0.0.1")
+	if ok, _ := l.Allow(ctx, "x-api-key:10.0.0.1"); ok {
+		t.Error("second request keyed by a header holding an internal address allowed")
+	}
+}
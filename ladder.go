@@ -0,0 +1,267 @@
+package flexlimit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Vipul984/flexlimit/algorithm"
+	"github.com/Vipul984/flexlimit/metrics"
+)
+
+// errHardDeadline is the storage error of a call that passed the hard
+// deadline set with WithDeadlines.
+var errHardDeadline = errors.New("storage call exceeded the hard deadline")
+
+// WithDeadlines bounds how long a decision waits for storage, so that tail
+// latency from a slow backend never fully reaches request latency.
+//
+// If storage hasn't answered after soft, the request is decided from the
+// key's state cached locally from earlier answers: allowed if the cached
+// remaining count covers it, which then goes down, and denied otherwise.
+// Keys without cached state keep waiting. If storage hasn't answered
+// after hard, the request is decided by the fallback strategy as if
+// storage had failed. Decisions made at each tier are counted in
+// metrics.DeadlineSoft and metrics.DeadlineHard.
+//
+// The storage call isn't abandoned at the soft deadline: it still
+// completes, up to the hard deadline, so a request decided from the cache
+// may also be counted in storage. Either deadline may be 0 to skip its
+// tier; a non-zero soft deadline must be below a non-zero hard one.
+//
+// Default: 0, 0 (wait for storage as long as ctx allows)
+//
+// Example:
+//
+//	flexlimit.New(100, time.Second,
+//	    flexlimit.WithStorage(redisStore),
+//	    flexlimit.WithDeadlines(5*time.Millisecond, 50*time.Millisecond),
+//	)
+func WithDeadlines(soft, hard time.Duration) Option {
+	return func(o *Options) {
+		o.softDeadline = soft
+		o.hardDeadline = hard
+	}
+}
+
+// WithLatencyBudget bounds the latency of every decision to budget, for
+// callers with soft real-time requirements: whatever storage does, Allow
+// answers within the budget, plus the time taken by callbacks.
+//
+// The budget is the hard deadline of WithDeadlines: if storage hasn't
+// answered by then, the request is decided by the fallback strategy, which
+// only uses local state. Before that, at the soft deadline (half the
+// budget unless set with WithDeadlines), requests for keys with cached
+// state are decided from it. Decisions forced by the budget are counted
+// in metrics.DeadlineSoft and metrics.DeadlineHard.
+//
+// Under a budget, corrupt state found by WithAutoRepair is reset in the
+// background while the request is left to the fallback strategy, and
+// AllowMulti decides each request within the budget instead of in one
+// batch. The extra storage calls of WithGracePeriod, WithLifecycle,
+// WithAdaptive and WithLoadShedder can't be bounded, so they can't be
+// combined with a budget.
+//
+// Calls made while SetLimit or MigrateStorage switch backends may wait
+// for the switch.
+//
+// Example:
+//
+//	flexlimit.New(100, time.Second,
+//	    flexlimit.WithStorage(redisStore),
+//	    flexlimit.WithFallback(flexlimit.LocalMemory),
+//	    flexlimit.WithLatencyBudget(2*time.Millisecond),
+//	)
+func WithLatencyBudget(budget time.Duration) Option {
+	return func(o *Options) {
+		o.latencyBudget = budget
+	}
+}
+
+// deadlines returns the soft and hard deadlines of storage calls, from
+// WithDeadlines or WithLatencyBudget.
+func (o *Options) deadlines() (soft, hard time.Duration) {
+	if o.latencyBudget == 0 {
+		return o.softDeadline, o.hardDeadline
+	}
+	soft = o.softDeadline
+	if soft == 0 {
+		soft = o.latencyBudget / 2
+	}
+	return soft, o.latencyBudget
+}
+
+// allowResult is the outcome of an algorithm's Allow call.
+type allowResult struct {
+	allowed bool
+	st      *algorithm.State
+	err     error
+}
+
+// allowLadder runs Allow on the active algorithm within the deadlines set
+// with WithDeadlines. Must be called with l.mu held.
+func (l *Limiter) allowLadder(ctx context.Context, key string, n int) (bool, *algorithm.State, error) {
+	softDeadline, hardDeadline := l.opts.deadlines()
+
+	callCtx, cancel := ctx, context.CancelFunc(func() {})
+	if hardDeadline > 0 {
+		callCtx, cancel = context.WithTimeout(ctx, hardDeadline)
+	}
+
+	// The call may outlive l.mu, so whatever closes the backend waits
+	// for it on l.detached
+	algo := l.be.active()
+	call := &ladderCall{key: key}
+	l.detached.Add(1)
+
+	done := make(chan allowResult, 1)
+	go func() {
+		defer l.detached.Done()
+		defer cancel()
+
+		var r allowResult
+		r.allowed, r.st, r.err = algo.Allow(callCtx, key, n)
+		l.ladder.finish(call, r.st, l.clock.Now())
+		done <- r
+	}()
+
+	var soft <-chan time.Time
+	if softDeadline > 0 {
+		timer := l.clock.NewTimer(softDeadline)
+		defer timer.Stop()
+		soft = timer.C()
+	}
+
+	var hard <-chan struct{}
+	if hardDeadline > 0 {
+		hard = callCtx.Done()
+	}
+
+	for {
+		select {
+		case r := <-done:
+			return r.allowed, r.st, r.err
+		case <-hard:
+			if err := ctx.Err(); err != nil {
+				return false, nil, err
+			}
+			l.opts.metrics.IncCounter(metrics.DeadlineHard, l.labels)
+			return false, nil, errHardDeadline
+		case <-soft:
+			soft = nil
+			if allowed, st, ok := l.ladder.take(call, n, l.clock.Now()); ok {
+				l.opts.metrics.IncCounter(metrics.DeadlineSoft, l.labels)
+				return allowed, st, nil
+			}
+		}
+	}
+}
+
+// ladderCache holds the last state storage reported for each key, to
+// decide requests at the soft deadline. It keeps at most maxKeys keys,
+// each until its state resets.
+type ladderCache struct {
+	mu      sync.Mutex
+	entries map[string]*ladderEntry
+	maxKeys int
+}
+
+// ladderEntry is the cached state of a key.
+type ladderEntry struct {
+	// st is the state storage last reported, less pending
+	st algorithm.State
+
+	// pending is the cost of requests allowed from the cache whose
+	// storage calls are still running. Their answers will include it
+	pending int64
+}
+
+// ladderCall tracks one storage call made under WithDeadlines.
+type ladderCall struct {
+	key      string
+	finished bool  // storage answered
+	charged  int64 // cost allowed from the cache, pending in its entry
+}
+
+// newLadderCache creates a cache of at most maxKeys keys.
+func newLadderCache(maxKeys int) *ladderCache {
+	return &ladderCache{entries: make(map[string]*ladderEntry), maxKeys: maxKeys}
+}
+
+// finish records that storage answered call with st at now; st is nil if
+// the call failed.
+func (c *ladderCache) finish(call *ladderCall, st *algorithm.State, now time.Time) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	call.finished = true
+	e := c.entries[call.key]
+	if e != nil {
+		e.pending = max(0, e.pending-call.charged)
+	}
+	if st == nil {
+		return
+	}
+
+	if e == nil {
+		if len(c.entries) >= c.maxKeys {
+			c.sweep(now)
+			if len(c.entries) >= c.maxKeys {
+				return
+			}
+		}
+		e = &ladderEntry{}
+		c.entries[call.key] = e
+	}
+
+	e.st = *st
+	e.st.Remaining -= e.pending
+	e.st.Current += e.pending
+}
+
+// take decides call's request costing n from the cached state of its key,
+// consuming n from it if allowed. ok is false if storage already answered
+// or the key has no cached state.
+func (c *ladderCache) take(call *ladderCall, n int, now time.Time) (allowed bool, st *algorithm.State, ok bool) {
+	if c == nil {
+		return false, nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := c.entries[call.key]
+	if call.finished || e == nil || !now.Before(e.st.ResetAt) {
+		return false, nil, false
+	}
+
+	if e.st.Remaining >= int64(n) {
+		e.st.Remaining -= int64(n)
+		e.st.Current += int64(n)
+		e.pending += int64(n)
+		call.charged = int64(n)
+		allowed = true
+	}
+
+	result := e.st
+	result.RetryAfter = 0
+	if !allowed {
+		result.RetryAfter = e.st.ResetAt.Sub(now)
+	}
+	return allowed, &result, true
+}
+
+// sweep drops the entries whose state has reset by now. Must be called
+// with c.mu held.
+func (c *ladderCache) sweep(now time.Time) {
+	for key, e := range c.entries {
+		if !now.Before(e.st.ResetAt) && e.pending == 0 {
+			delete(c.entries, key)
+		}
+	}
+}
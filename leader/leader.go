@@ -0,0 +1,284 @@
+// Package leader elects one instance among many sharing a storage backend
+// to run cluster-wide background work, such as usage export or sweeps of
+// expired keys.
+//
+// When N instances share one backend, work that only needs to happen once
+// per cluster would otherwise run N times, multiplying its load on the
+// backend by the size of the fleet. An Elector holds a lease in the
+// shared storage: only the instance holding it is the leader, and the
+// others skip the work.
+//
+// Leases are fixed terms of Config.TTL. Each term has its own storage key,
+// and the instance whose Incr creates the key (and sees 1) leads the term.
+// The leader claims the next term before its current one ends, so
+// leadership is sticky; if it stops, another instance takes over at the
+// start of the next term it claims, within two terms. Because claiming
+// only needs an atomic Incr, any storage.Storage works, including Redis,
+// etcd and shared memory.
+//
+// Terms are derived from each instance's clock, so TTL should be much
+// larger than the clock skew between instances; otherwise two instances
+// may briefly both consider themselves leader around a term boundary.
+// The work gated by an Elector should therefore be safe to run twice.
+//
+// Example:
+//
+//	elector, err := leader.New(store, leader.Config{Name: "usage-export"})
+//	if err != nil {
+//	    return err
+//	}
+//	defer elector.Close()
+//
+//	exp, err := export.New(limits, sink, export.Config{Leader: elector})
+package leader
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Vipul984/flexlimit"
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+// Config configures an Elector.
+type Config struct {
+	// Name identifies the election. Instances sharing a storage and a name
+	// elect one leader between them. Required.
+	Name string
+
+	// TTL is the length of a leadership term. Default: 15 seconds
+	TTL time.Duration
+
+	// ID identifies this instance in OnChange. Default: the host name,
+	// process ID and a random suffix
+	ID string
+
+	// KeyPrefix is prepended to the storage keys holding the leases.
+	// Default: "leader:"
+	KeyPrefix string
+
+	// Timeout bounds each claim on the storage. Default: TTL / 4
+	Timeout time.Duration
+
+	// OnChange is called from the Elector's goroutine when this instance
+	// gains or loses leadership.
+	OnChange func(id string, leader bool)
+
+	// OnError is called with the error of a failed claim. The Elector
+	// keeps running and tries again at the next tick.
+	OnError func(error)
+}
+
+// Elector takes part in a leader election over a shared storage.
+type Elector struct {
+	store storage.Storage
+	cfg   Config
+	now   func() time.Time
+
+	mu      sync.Mutex
+	held    [2]int64 // latest two terms this instance won, or -1
+	tried   int64    // latest term this instance tried to claim
+	leading bool     // leadership last reported to OnChange
+	closed  bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New creates an Elector over store and starts taking part in the
+// election in the background. Call Close to leave it.
+//
+// The caller keeps ownership of store; it must stay open until Close
+// returns.
+//
+// Returns an *flexlimit.InvalidConfigError if store is nil, Name is
+// empty, or TTL or Timeout is negative.
+func New(store storage.Storage, cfg Config) (*Elector, error) {
+	switch {
+	case store == nil:
+		return nil, &flexlimit.InvalidConfigError{Field: "storage", Value: store, Reason: "must not be nil"}
+	case cfg.Name == "":
+		return nil, &flexlimit.InvalidConfigError{Field: "name", Value: cfg.Name, Reason: "must not be empty"}
+	case cfg.TTL < 0 || cfg.Timeout < 0:
+		return nil, &flexlimit.InvalidConfigError{Field: "config", Value: cfg, Reason: "durations cannot be negative"}
+	}
+
+	if cfg.TTL == 0 {
+		cfg.TTL = 15 * time.Second
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = cfg.TTL / 4
+	}
+	if cfg.ID == "" {
+		cfg.ID = instanceID()
+	}
+	if cfg.KeyPrefix == "" {
+		cfg.KeyPrefix = "leader:"
+	}
+
+	e := &Elector{
+		store: store,
+		cfg:   cfg,
+		now:   time.Now,
+		held:  [2]int64{-1, -1},
+		tried: -1,
+		stop:  make(chan struct{}),
+	}
+
+	e.wg.Add(1)
+	go e.run()
+	return e, nil
+}
+
+// ID returns the identifier of this instance.
+func (e *Elector) ID() string {
+	return e.cfg.ID
+}
+
+// IsLeader reports whether this instance leads the current term.
+//
+// Leadership lapses on its own at the end of the term, so an instance
+// that stalls or loses the storage stops reporting itself as leader
+// without having to reach it.
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return !e.closed && e.leads(e.term(e.now()))
+}
+
+// Do runs fn if this instance is the leader, and reports whether it ran.
+func (e *Elector) Do(ctx context.Context, fn func(ctx context.Context) error) (bool, error) {
+	if !e.IsLeader() {
+		return false, nil
+	}
+	return true, fn(ctx)
+}
+
+// Close leaves the election and waits for a claim in progress to finish.
+// The lease is not released: another instance takes over once the terms
+// this instance claimed end. Calling Close more than once is a no-op.
+func (e *Elector) Close() error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	e.closed = true
+	close(e.stop)
+	e.mu.Unlock()
+
+	e.wg.Wait()
+	e.report(false)
+	return nil
+}
+
+// run claims terms every quarter TTL until the Elector is closed. A
+// quarter guarantees a tick in the last third of every term, when the
+// leader claims the next one.
+func (e *Elector) run() {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(e.cfg.TTL / 4)
+	defer ticker.Stop()
+
+	for {
+		e.tick()
+
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tick claims the current term if nobody has been seen claiming it yet,
+// and the next term if this instance leads the current one and it is
+// ending.
+func (e *Elector) tick() {
+	now := e.now()
+	term := e.term(now)
+
+	e.mu.Lock()
+	claim := int64(-1)
+	switch {
+	case e.leads(term) && now.After(e.termStart(term+1).Add(-e.cfg.TTL/3)):
+		if e.tried < term+1 {
+			claim = term + 1
+		}
+	case e.tried < term:
+		claim = term
+	}
+	e.mu.Unlock()
+
+	if claim >= 0 {
+		e.claim(claim)
+	}
+	e.report(e.IsLeader())
+}
+
+// claim tries to become the leader of term.
+func (e *Elector) claim(term int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.cfg.Timeout)
+	defer cancel()
+
+	key := e.cfg.KeyPrefix + e.cfg.Name + ":" + strconv.FormatInt(term, 10)
+	n, err := e.store.Incr(ctx, key, 1, 2*e.cfg.TTL)
+
+	e.mu.Lock()
+	if err == nil {
+		e.tried = max(e.tried, term)
+		if n == 1 && term > e.held[0] {
+			e.held = [2]int64{term, e.held[0]}
+		}
+	}
+	e.mu.Unlock()
+
+	if err != nil && e.cfg.OnError != nil {
+		e.cfg.OnError(err)
+	}
+}
+
+// report calls OnChange if leadership changed since the last report.
+func (e *Elector) report(leader bool) {
+	e.mu.Lock()
+	changed := e.leading != leader
+	e.leading = leader
+	e.mu.Unlock()
+
+	if changed && e.cfg.OnChange != nil {
+		e.cfg.OnChange(e.cfg.ID, leader)
+	}
+}
+
+// leads reports whether this instance won term. e.mu must be held.
+func (e *Elector) leads(term int64) bool {
+	return term >= 0 && (e.held[0] == term || e.held[1] == term)
+}
+
+// term returns the term containing t.
+func (e *Elector) term(t time.Time) int64 {
+	return t.UnixNano() / int64(e.cfg.TTL)
+}
+
+// termStart returns when term begins.
+func (e *Elector) termStart(term int64) time.Time {
+	return time.Unix(0, term*int64(e.cfg.TTL))
+}
+
+// instanceID returns a default identifier for this process.
+func instanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	var suffix [4]byte
+	rand.Read(suffix[:])
+	return host + "-" + strconv.Itoa(os.Getpid()) + "-" + hex.EncodeToString(suffix[:])
+}
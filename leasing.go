@@ -0,0 +1,47 @@
+package flexlimit
+
+import (
+	"context"
+
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+// WithTokenLeasing serves token buckets from batches of size tokens
+// claimed from the storage passed to WithStorage, so most requests are
+// decided locally while the limit still holds across instances (see
+// storage.Leased): a key's first request on an instance claims size
+// tokens in one operation, the following ones spend them, and tokens left
+// unused are given back after a second without requests and on Close.
+// Failed returns are logged as warnings (see WithLogger).
+//
+// Tokens leased by one instance can't be spent by another, so keep size
+// small against the limit divided by the number of instances. Only the
+// token bucket algorithm is served from leases.
+//
+// Can't be combined with WithLocalCache.
+//
+// Example:
+//
+//	limiter, err := flexlimit.New(10000, time.Second,
+//	    flexlimit.WithStorage(redisStore),
+//	    flexlimit.WithTokenLeasing(100),
+//	)
+//
+// Default: 0 (every request takes its tokens from the storage)
+func WithTokenLeasing(size int) Option {
+	return func(o *Options) {
+		o.leaseSize = size
+	}
+}
+
+// leased returns s serving tokens from leases for WithTokenLeasing.
+// Closing it returns the leases but leaves s open.
+func (l *Limiter) leased(s storage.Storage) storage.Storage {
+	return storage.NewLeased(unownedStorage{s}, storage.LeaseConfig{
+		Size:  float64(l.opts.leaseSize),
+		Clock: l.clock,
+		OnReturnError: func(err error) {
+			l.warn(context.Background(), "flexlimit: returning leased tokens failed", "error", err)
+		},
+	})
+}
@@ -0,0 +1,513 @@
+package flexlimit
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Vipul984/flexlimit/algorithm"
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+// lifecycleRecord is the kind of record storing a key's lifecycle (see
+// recordKey).
+const lifecycleRecord = "lifecycle"
+
+// KeyStatus is where a key is in its lifecycle. See WithLifecycle.
+type KeyStatus string
+
+const (
+	// KeyActive is a key within its limit. Keys without a lifecycle
+	// record are active.
+	KeyActive KeyStatus = "active"
+
+	// KeyWarned is a key whose last request was allowed but used more
+	// than LifecyclePolicy.WarnAt of its limit, or was only allowed by a
+	// grace period.
+	KeyWarned KeyStatus = "warned"
+
+	// KeyLimited is a key whose last request was denied.
+	KeyLimited KeyStatus = "limited"
+
+	// KeyBanned is a key whose requests are all denied, without being
+	// counted, until the ban ends. Keys are banned by Limiter.Ban or by
+	// LifecyclePolicy.BanAfter.
+	KeyBanned KeyStatus = "banned"
+
+	// KeyArchived is a key that was idle long enough for
+	// Limiter.ArchiveIdle to drop its state. Its next request makes it
+	// active again.
+	KeyArchived KeyStatus = "archived"
+)
+
+// keyStatuses maps stored status numbers to statuses.
+var keyStatuses = []KeyStatus{KeyActive, KeyWarned, KeyLimited, KeyBanned, KeyArchived}
+
+// LifecyclePolicy drives the transitions between key statuses. See
+// WithLifecycle.
+type LifecyclePolicy struct {
+	// WarnAt is the fraction of its limit, in (0, 1), beyond which an
+	// allowed key is warned. 0 only warns keys allowed by a grace period
+	WarnAt float64
+
+	// BanAfter is the number of denials in a row, or within BanWithin,
+	// that ban a key for BanDuration. 0 never bans automatically
+	BanAfter int
+
+	// BanWithin, if set, counts BanAfter denials within this long of the
+	// first, allowed requests in between or not, instead of in a row
+	BanWithin time.Duration
+
+	// BanDuration is how long a BanAfter penalty lasts. Default: 10
+	// windows
+	BanDuration time.Duration
+
+	// BanEscalation, if set, replaces BanDuration with escalating
+	// penalties: a key's first automatic ban lasts BanEscalation[0], its
+	// second BanEscalation[1], and so on, the last repeating. A key's
+	// penalties are forgotten on Reset, when ArchiveIdle archives it, or
+	// when its record expires, twice ArchiveAfter after its last
+	// transition
+	BanEscalation []time.Duration
+
+	// ArchiveAfter is how long a key must go without a transition, and
+	// have no usage left, before ArchiveIdle archives it. Lifecycle
+	// records are dropped twice this long after their last transition,
+	// so a sweep every ArchiveAfter sees each idle key. Default: 24 hours
+	ArchiveAfter time.Duration
+
+	// OnTransition is called on every change of a key's status, on the
+	// goroutine that caused it
+	OnTransition func(Transition)
+
+	// OnBan is called whenever a key is banned, automatically or by
+	// Limiter.Ban, on the goroutine that banned it
+	OnBan func(BanEvent)
+}
+
+// banDuration returns how long the automatic ban of a key with offenses
+// penalties so far lasts.
+func (p *LifecyclePolicy) banDuration(offenses int) time.Duration {
+	if len(p.BanEscalation) == 0 {
+		return p.BanDuration
+	}
+	return p.BanEscalation[min(offenses, len(p.BanEscalation)-1)]
+}
+
+// BanEvent describes a ban, as passed to LifecyclePolicy.OnBan.
+type BanEvent struct {
+	// Key is the banned rate limit key
+	Key string
+
+	// Reason is "penalty" for automatic bans (see
+	// LifecyclePolicy.BanAfter) and "ban" for Limiter.Ban
+	Reason string
+
+	// At is when the ban started
+	At time.Time
+
+	// Until is when the ban ends, or zero for bans without an end
+	Until time.Time
+
+	// Offenses is the number of automatic bans of the key so far,
+	// including this one if it is automatic
+	Offenses int
+}
+
+// Transition is a change of a key's status.
+type Transition struct {
+	// Key is the rate limit key
+	Key string
+
+	// From and To are the statuses before and after the change
+	From KeyStatus
+	To   KeyStatus
+
+	// Reason tells what caused the change: "threshold", "grace", "limit",
+	// "penalty", "recovered", "ban", "unban", "ban_expired", "idle",
+	// "returned", "reset" or "decayed"
+	Reason string
+
+	// At is when the change happened
+	At time.Time
+
+	// BannedUntil is when a ban ends. Zero unless To is KeyBanned, and
+	// for bans without an end
+	BannedUntil time.Time
+}
+
+// keyRecord is the stored lifecycle of a key.
+type keyRecord struct {
+	status       KeyStatus
+	strikes      int       // denials in a row, or within BanWithin, counted when BanAfter is set
+	strikesSince time.Time // first of the strikes
+	bannedUntil  time.Time // zero for bans without an end
+	since        time.Time // when status was entered
+	offenses     int       // automatic bans so far
+}
+
+// offenseStride separates the status and offenses packed in the stored
+// count: count = status + offenses*offenseStride.
+const offenseStride = 16
+
+// banned reports whether rec bans its key at now.
+func (rec keyRecord) banned(now time.Time) bool {
+	return rec.status == KeyBanned && (rec.bannedUntil.IsZero() || now.Before(rec.bannedUntil))
+}
+
+// recordPrefix starts the storage keys of the records the limiter keeps
+// about a rate limit key besides its state (grace period, lifecycle,
+// tarpit, warm-up, last seen, consumption, first seen), so they never
+// collide with rate limit keys. Rate limit keys starting with it are
+// reserved.
+const recordPrefix = "_fl:"
+
+// recordKey returns the storage key of the record of kind for key, as in
+// "_fl:grace:user:123".
+func recordKey(kind, key string) string {
+	return recordPrefix + kind + ":" + key
+}
+
+// internalKey reports whether a storage key holds a record of a rate limit
+// key rather than its main state.
+func internalKey(key string) bool {
+	return strings.HasPrefix(key, recordPrefix)
+}
+
+// loadRecord reads the lifecycle record of key from store. A key without
+// a record is active.
+func (l *Limiter) loadRecord(ctx context.Context, store storage.Storage, key string) (keyRecord, error) {
+	st, err := store.Get(ctx, recordKey(lifecycleRecord, key))
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return keyRecord{status: KeyActive}, nil
+	}
+	if err != nil {
+		return keyRecord{status: KeyActive}, err
+	}
+
+	rec := keyRecord{
+		status:   KeyActive,
+		strikes:  int(st.Tokens),
+		since:    st.LastRefill,
+		offenses: int(st.Count / offenseStride),
+	}
+	if status := st.Count % offenseStride; status >= 0 && int(status) < len(keyStatuses) {
+		rec.status = keyStatuses[status]
+	}
+	if rec.status == KeyBanned {
+		rec.bannedUntil = st.WindowStart
+	} else {
+		rec.strikesSince = st.WindowStart
+	}
+	return rec, nil
+}
+
+// saveRecord writes the lifecycle record of key to store. Records are kept
+// for two ArchiveAfter periods after their last transition, and bans until
+// they end.
+// An active record without strikes or offenses is deleted.
+func (l *Limiter) saveRecord(ctx context.Context, store storage.Storage, key string, rec keyRecord, now time.Time) error {
+	if rec.status == KeyActive && rec.strikes == 0 && rec.offenses == 0 {
+		err := store.Delete(ctx, recordKey(lifecycleRecord, key))
+		if errors.Is(err, storage.ErrKeyNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	ttl := 2 * max(l.lifecycle.ArchiveAfter, l.window)
+	if rec.status == KeyBanned {
+		ttl = 0
+		if !rec.bannedUntil.IsZero() {
+			ttl = rec.bannedUntil.Sub(now) + l.lifecycle.ArchiveAfter
+		}
+	}
+
+	status := 0
+	for i, s := range keyStatuses {
+		if s == rec.status {
+			status = i
+		}
+	}
+	windowStart := rec.strikesSince
+	if rec.status == KeyBanned {
+		windowStart = rec.bannedUntil
+	}
+	return store.Set(ctx, recordKey(lifecycleRecord, key), &storage.State{
+		Count:       int64(status + rec.offenses*offenseStride),
+		Tokens:      float64(rec.strikes),
+		WindowStart: windowStart,
+		LastRefill:  rec.since,
+	}, ttl)
+}
+
+// transition moves key from rec to next, saving next and reporting the
+// change to OnTransition, and bans to OnBan. Records that can't be saved
+// are not reported.
+func (l *Limiter) transition(ctx context.Context, store storage.Storage, key string, rec, next keyRecord, reason string, now time.Time) error {
+	if next.status != rec.status {
+		next.since = now
+	}
+	if err := l.saveRecord(ctx, store, key, next, now); err != nil {
+		return err
+	}
+
+	if next.status != rec.status && l.lifecycle.OnTransition != nil {
+		t := Transition{Key: key, From: rec.status, To: next.status, Reason: reason, At: now}
+		if next.status == KeyBanned {
+			t.BannedUntil = next.bannedUntil
+		}
+		l.lifecycle.OnTransition(t)
+	}
+	if next.status == KeyBanned && next != rec && l.lifecycle.OnBan != nil {
+		l.lifecycle.OnBan(BanEvent{Key: key, Reason: reason, At: now, Until: next.bannedUntil, Offenses: next.offenses})
+	}
+	return nil
+}
+
+// checkBan reads the lifecycle record of key before a request, lifting a
+// ban that has ended. It reports whether the key is banned. A record that
+// can't be read leaves the key active, so a storage failure never bans.
+// Must be called with l.mu held.
+func (l *Limiter) checkBan(ctx context.Context, key string, now time.Time) (keyRecord, bool) {
+	rec, err := l.loadRecord(ctx, l.be.store, key)
+	if err != nil {
+		return rec, false
+	}
+	if rec.banned(now) {
+		return rec, true
+	}
+	if rec.status == KeyBanned {
+		next := keyRecord{status: KeyActive, offenses: rec.offenses}
+		if l.transition(ctx, l.be.store, key, rec, next, "ban_expired", now) == nil {
+			rec = next
+		}
+	}
+	return rec, false
+}
+
+// banState builds the algorithm state reported for a request denied by a
+// ban: nothing remaining until the ban ends, or for a window if it has no
+// end. Must be called with l.mu held.
+func (l *Limiter) banState(key string, rec keyRecord, now time.Time) *algorithm.State {
+	until := rec.bannedUntil
+	if until.IsZero() {
+		until = now.Add(l.window)
+	}
+	rate := int64(l.rate)
+	return &algorithm.State{
+		Key:        key,
+		Limit:      rate,
+		Current:    rate,
+		ResetAt:    until,
+		RetryAfter: until.Sub(now),
+		Algorithm:  l.opts.algorithm,
+	}
+}
+
+// advance moves key to the status following a decided request. warning
+// is true if the request was only allowed by a grace period. Must be
+// called with l.mu held.
+func (l *Limiter) advance(ctx context.Context, key string, rec keyRecord, allowed, warning bool, st *algorithm.State, now time.Time) {
+	p := l.lifecycle
+	next := keyRecord{status: KeyActive, since: rec.since, offenses: rec.offenses}
+	// Strikes count denials within BanWithin of the first, or in a row
+	if p.BanWithin > 0 && now.Sub(rec.strikesSince) < p.BanWithin || p.BanWithin == 0 && !allowed {
+		next.strikes, next.strikesSince = rec.strikes, rec.strikesSince
+	}
+	reason := "recovered"
+
+	switch {
+	case !allowed:
+		next.status, reason = KeyLimited, "limit"
+		if p.BanAfter > 0 {
+			if next.strikes == 0 {
+				next.strikesSince = now
+			}
+			next.strikes++
+			if next.strikes >= p.BanAfter {
+				next = keyRecord{
+					status:      KeyBanned,
+					bannedUntil: now.Add(p.banDuration(rec.offenses)),
+					offenses:    rec.offenses + 1,
+				}
+				reason = "penalty"
+			}
+		}
+	case warning:
+		next.status, reason = KeyWarned, "grace"
+	case p.WarnAt > 0 && st != nil && float64(st.Current) > p.WarnAt*float64(st.Limit):
+		next.status, reason = KeyWarned, "threshold"
+	case rec.status == KeyArchived:
+		reason = "returned"
+	}
+
+	if next == rec {
+		return
+	}
+	_ = l.transition(ctx, l.be.store, key, rec, next, reason, now)
+}
+
+// Ban denies every request for key for d, or until Unban if d is not
+// positive. Banned requests are not counted against the key's limit.
+//
+// Returns an *InvalidConfigError if the limiter has no lifecycle policy
+// (see WithLifecycle).
+func (l *Limiter) Ban(ctx context.Context, key string, d time.Duration) error {
+	return l.updateRecord(ctx, key, "ban", func(rec keyRecord, now time.Time) keyRecord {
+		next := keyRecord{status: KeyBanned, since: rec.since, offenses: rec.offenses}
+		if d > 0 {
+			next.bannedUntil = now.Add(d)
+		}
+		return next
+	})
+}
+
+// Unban lifts a ban on key, making it active again. Keys that are not
+// banned are left as they are.
+//
+// Returns an *InvalidConfigError if the limiter has no lifecycle policy
+// (see WithLifecycle).
+func (l *Limiter) Unban(ctx context.Context, key string) error {
+	return l.updateRecord(ctx, key, "unban", func(rec keyRecord, now time.Time) keyRecord {
+		if rec.status != KeyBanned {
+			return rec
+		}
+		return keyRecord{status: KeyActive, offenses: rec.offenses}
+	})
+}
+
+// updateRecord applies a manual transition to the lifecycle record of key.
+func (l *Limiter) updateRecord(ctx context.Context, key, reason string, fn func(rec keyRecord, now time.Time) keyRecord) error {
+	key = l.HashKey(key)
+	if l.lifecycle == nil {
+		return &InvalidConfigError{Field: "lifecycle", Value: nil, Reason: "not enabled (see WithLifecycle)"}
+	}
+	if l.closed.Load() {
+		return ErrLimiterClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return wrapContextError(err)
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	now := l.clock.Now()
+	rec, err := l.loadRecord(ctx, l.be.adminStore, key)
+	if err != nil {
+		return contextOr(ctx, err)
+	}
+	next := fn(rec, now)
+	if next == rec {
+		return nil
+	}
+	if err := l.transition(ctx, l.be.adminStore, key, rec, next, reason, now); err != nil {
+		return contextOr(ctx, err)
+	}
+	return nil
+}
+
+// ArchiveIdle archives every key that has had no transition for
+// LifecyclePolicy.ArchiveAfter and has no usage left: its state is reset
+// and its status becomes KeyArchived. Banned keys are never archived. It
+// returns how many keys were archived.
+//
+// Only keys with a lifecycle record (keys that have been warned, limited
+// or banned) are considered. ArchiveIdle scans every key, so run it in the
+// background, e.g. on the instance elected by the leader package.
+//
+// Returns an *InvalidConfigError if the limiter has no lifecycle policy
+// (see WithLifecycle).
+func (l *Limiter) ArchiveIdle(ctx context.Context) (int, error) {
+	if l.lifecycle == nil {
+		return 0, &InvalidConfigError{Field: "lifecycle", Value: nil, Reason: "not enabled (see WithLifecycle)"}
+	}
+	if l.closed.Load() {
+		return 0, ErrLimiterClosed
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	records := recordKey(lifecycleRecord, "")
+	stored, err := l.be.adminStore.Keys(ctx, records+"*")
+	if err != nil {
+		return 0, contextOr(ctx, err)
+	}
+
+	archived := 0
+	var errs []error
+	for _, storageKey := range stored {
+		key, ok := strings.CutPrefix(storageKey, records)
+		if !ok {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return archived, wrapContextError(err)
+		}
+
+		now := l.clock.Now()
+		rec, err := l.loadRecord(ctx, l.be.adminStore, key)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if rec.status == KeyBanned || rec.status == KeyArchived || now.Sub(rec.since) < l.lifecycle.ArchiveAfter {
+			continue
+		}
+
+		st, err := l.be.admin.State(ctx, key)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if st.Current > 0 {
+			continue
+		}
+
+		if err := l.be.reset(ctx, key, l.be.admin); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := l.transition(ctx, l.be.adminStore, key, rec, keyRecord{status: KeyArchived}, "idle", now); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		archived++
+	}
+	return archived, contextOr(ctx, errors.Join(errs...))
+}
+
+// setStatus sets the lifecycle status, ban and offenses of key on state,
+// leaving them empty without a lifecycle policy. Must be called with l.mu
+// held.
+func (l *Limiter) setStatus(ctx context.Context, key string, state *State) {
+	if l.lifecycle == nil {
+		return
+	}
+	rec, _ := l.loadRecord(ctx, l.be.adminStore, key)
+	state.Status, state.Offenses = rec.status, rec.offenses
+	if rec.status != KeyBanned {
+		return
+	}
+	if !rec.banned(l.clock.Now()) {
+		state.Status = KeyActive
+		return
+	}
+	state.BannedUntil = rec.bannedUntil
+}
+
+// resetRecord deletes the lifecycle record of key on Reset, reporting
+// the change to active. Must be called with l.mu held.
+func (l *Limiter) resetRecord(ctx context.Context, key string) error {
+	rec, err := l.loadRecord(ctx, l.be.adminStore, key)
+	if err != nil {
+		return err
+	}
+	if rec.status == KeyActive && rec.strikes == 0 && rec.offenses == 0 {
+		return nil
+	}
+	return l.transition(ctx, l.be.adminStore, key, rec, keyRecord{status: KeyActive}, "reset", l.clock.Now())
+}
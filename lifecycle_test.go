@@ -0,0 +1,52 @@
+package flexlimit
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+func TestKeysSkipRecords(t *testing.T) {
+	store := storage.NewMemory(storage.Config{})
+	l, err := New(1, time.Minute,
+		WithAlgorithm(FixedWindow),
+		WithStorage(store),
+		WithGracePeriod(time.Minute),
+		WithTarpit(Tarpit{}),
+		WithRollover(1),
+		OnStateChange(func(StateChange) {}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	ctx := context.Background()
+	for range 2 {
+		l.Allow(ctx, "user")
+	}
+	l.tarpitDelay(ctx, "user")
+
+	// Keys named like the records of other keys are keys all the same
+	l.Allow(ctx, "report:grace")
+	l.Allow(ctx, "report:tarpit")
+
+	stored, err := store.Keys(ctx, recordPrefix+"*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) == 0 {
+		t.Fatal("no records stored, want grace, tarpit, credit and first seen records")
+	}
+
+	keys, err := l.Keys(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"report:grace", "report:tarpit", "user"}; !slices.Equal(keys, want) {
+		t.Errorf("Keys = %q, want %q without the records %q", keys, want, stored)
+	}
+}
@@ -0,0 +1,1232 @@
+// Package flexlimit is a flexible rate limiter for Go that grows with your app.
+//
+// Start with a single in-memory limiter:
+//
+//	limiter, err := flexlimit.New(100, time.Minute)
+//	if err != nil {
+//	    return err
+//	}
+//	defer limiter.Close()
+//
+//	allowed, err := limiter.Allow(ctx, "user:123")
+//
+// and move to shared storage, other algorithms, callbacks and metrics
+// through options as requirements grow, without changing call sites.
+package flexlimit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Vipul984/flexlimit/algorithm"
+	"github.com/Vipul984/flexlimit/clock"
+	"github.com/Vipul984/flexlimit/metrics"
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+// Limiter is a rate limiter that allows rate requests per window for each key.
+//
+// A Limiter is safe for concurrent use by multiple goroutines. Create one
+// with New and release it with Close.
+type Limiter struct {
+	rate   int
+	window time.Duration
+	opts   *Options
+
+	clock clock.Clock
+
+	// mu guards be. Calls hold a read lock for their whole duration so a
+	// backend is never closed while in use.
+	mu sync.RWMutex
+	be *backend
+
+	// migrateMu serializes MigrateStorage calls
+	migrateMu sync.Mutex
+
+	labels metrics.Labels
+	closed atomic.Bool
+
+	// released is set once the backend is closed, by Close or Shutdown.
+	// Guarded by mu
+	released bool
+
+	// logger receives operational events (see WithLogger), and logGate
+	// caps the warnings of the request path
+	logger  *slog.Logger
+	logGate *rateGate
+
+	// keyMapper maps storage keys back to rate limit keys for
+	// OnKeyEvicted and Events; nil if the algorithm stores keys unchanged
+	keyMapper algorithm.KeyMapper
+
+	// advisor records traffic for Suggest; nil without WithAdvisor
+	advisor *advisor
+
+	// lifecycle is the lifecycle policy with defaults filled in; nil
+	// without WithLifecycle
+	lifecycle *LifecyclePolicy
+
+	// adaptive holds the rate fraction of each key; nil without
+	// WithAdaptive
+	adaptive *adaptive
+
+	// warmup is the warm-up configuration with defaults filled in; nil
+	// without WithWarmup
+	warmup *WarmupConfig
+
+	// storageGate and callbackGate enforce WithSelfLimits; nil if
+	// unlimited
+	storageGate  *rateGate
+	callbackGate *rateGate
+
+	// callbacks applies WithCallbackPolicy; nil without it
+	callbacks callbackFilters
+
+	// ladder caches storage answers for WithDeadlines; nil without a
+	// soft deadline
+	ladder *ladderCache
+
+	// coalescer batches the requests of hot keys; nil without
+	// WithCoalescing
+	coalescer *coalescer
+
+	// skew is the limiter's clock corrected against the storage's; nil
+	// without WithMaxClockSkew
+	skew *skewClock
+
+	// regions holds the region's share of the limit; nil without
+	// WithRegions
+	regions *regions
+
+	// maintenance holds the state of the maintenance windows; nil without
+	// WithMaintenanceWindows
+	maintenance *maintenance
+
+	// events is the stream of Events; nil until it is first called
+	events     atomic.Pointer[eventStream]
+	eventsOnce sync.Once
+
+	// detached counts storage calls still running after their request was
+	// decided at a deadline. Waited for with mu held for writing before
+	// algorithms are closed
+	detached sync.WaitGroup
+}
+
+// New creates a limiter that allows rate requests per window for each key.
+//
+// Returns an *InvalidConfigError (matching ErrInvalidConfig) if rate or
+// window is not positive, or if an option is invalid.
+//
+// Example:
+//
+//	limiter, err := flexlimit.New(100, time.Minute,
+//	    flexlimit.WithAlgorithm(flexlimit.SlidingWindow),
+//	    flexlimit.OnLimit(func(info flexlimit.LimitInfo) {
+//	        log.Printf("rate limited: %s", info.Key)
+//	    }),
+//	)
+func New(rate int, window time.Duration, opts ...Option) (*Limiter, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if err := validateOptions(rate, window, o); err != nil {
+		return nil, err
+	}
+
+	if o.clock == nil {
+		o.clock = clock.New()
+	}
+
+	l := &Limiter{
+		rate:   rate,
+		window: window,
+		opts:   o,
+		clock:  o.clock,
+		labels: metrics.Labels{metrics.LabelAlgorithm: o.algorithm},
+	}
+	if o.maxClockSkew > 0 {
+		l.skew = &skewClock{Clock: o.clock, max: o.maxClockSkew}
+		l.clock = l.skew
+	}
+
+	if o.advisor != nil {
+		l.advisor = newAdvisor(*o.advisor, window, l.clock.Now())
+	}
+
+	if o.lifecycle != nil {
+		p := *o.lifecycle
+		if p.BanDuration == 0 {
+			p.BanDuration = 10 * window
+		}
+		if p.ArchiveAfter == 0 {
+			p.ArchiveAfter = 24 * time.Hour
+		}
+		l.lifecycle = &p
+	}
+
+	if o.adaptive != nil {
+		l.adaptive = newAdaptive(*o.adaptive)
+	}
+
+	if o.warmup != nil {
+		w := *o.warmup
+		if w.StartFraction == 0 {
+			w.StartFraction = 0.1
+		}
+		if w.IdleReset == 0 {
+			w.IdleReset = 24 * time.Hour
+		}
+		l.warmup = &w
+	}
+
+	if o.regions != nil {
+		l.regions = newRegions(*o.regions)
+	}
+	if len(o.maintenance) > 0 {
+		l.maintenance = newMaintenance(o.maintenance, l.clock.Now())
+	}
+
+	if soft, _ := o.deadlines(); soft > 0 {
+		l.ladder = newLadderCache(o.maxKeys)
+	}
+	l.coalescer = newCoalescer(o.coalesceWindow)
+
+	l.storageGate = newRateGate(o.selfLimits.StorageOpsPerSecond, l.clock.Now())
+	l.callbackGate = newRateGate(o.selfLimits.CallbacksPerSecond, l.clock.Now())
+	l.callbacks = newCallbackFilters(o.callbackPolicies, l.clock.Now())
+
+	l.logger = o.logger
+	if l.logger == nil {
+		l.logger = slog.New(slog.DiscardHandler)
+	}
+	l.logGate = newRateGate(warningsPerSecond, l.clock.Now())
+
+	// Built-in algorithms are cheap to build without storage, so evicted
+	// keys are mapped for Events too; registered ones only for OnKeyEvicted
+	if o.algorithmInstance != nil {
+		l.keyMapper, _ = o.algorithmInstance.(algorithm.KeyMapper)
+	} else if _, registered := algorithm.Lookup(o.algorithm); !registered || o.onKeyEvicted != nil {
+		algo, err := algorithm.New(l.algorithmConfig(rate), nil, l.clock)
+		if err != nil {
+			return nil, err
+		}
+		l.keyMapper, _ = algo.(algorithm.KeyMapper)
+	}
+
+	be, err := l.newBackend(o.storage, o.adminStorage)
+	if err != nil {
+		return nil, err
+	}
+	l.be = be
+
+	if l.skew != nil {
+		l.skew.measuring.Store(true)
+		l.measureSkew(be.store)
+	}
+	if l.regions != nil {
+		l.startRegions()
+	}
+	if l.maintenance != nil {
+		l.startMaintenance()
+	}
+	return l, nil
+}
+
+// Allow reports whether a single request for key is allowed, consuming one
+// token if it is.
+//
+// Storage failures are handled by the fallback strategy and do not return
+// an error; only context cancellation and use after Close do.
+//
+// Example:
+//
+//	allowed, err := limiter.Allow(ctx, "user:123")
+//	if err != nil {
+//	    return err
+//	}
+//	if !allowed {
+//	    http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+//	    return
+//	}
+func (l *Limiter) Allow(ctx context.Context, key string) (bool, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN reports whether a request costing n tokens is allowed for key,
+// consuming n tokens if it is.
+//
+// Either all n tokens are consumed or none are.
+func (l *Limiter) AllowN(ctx context.Context, key string, n int) (bool, error) {
+	allowed, _, err := l.allowN(ctx, key, n)
+	return allowed, err
+}
+
+// allowN implements AllowN. It also returns the algorithm state behind the
+// decision, which is nil if the fallback strategy made it.
+func (l *Limiter) allowN(ctx context.Context, key string, n int) (bool, *algorithm.State, error) {
+	if err := l.checkCall(ctx, n); err != nil {
+		return false, nil, err
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	start := l.clock.Now()
+	l.checkSkew()
+	raw, key := key, l.HashKey(key)
+	if l.exempt(raw) {
+		l.bypass()
+		return true, nil, nil
+	}
+	if l.blocked(raw) {
+		st := l.blockedState(key)
+		l.opts.metrics.IncCounter(metrics.Blocked, l.labels)
+		l.notify(ctx, false, deniedByList, st, n, start, time.Time{})
+		return l.shadowed(false), st, nil
+	}
+
+	if l.advisor != nil {
+		l.advisor.observe(key, n, start)
+	}
+	if l.regions != nil {
+		l.regions.demand.Add(int64(n))
+	}
+
+	if !l.storageGate.allow(start) {
+		l.selfLimited(ctx, "storage_ops")
+		return l.shadowed(l.degrade(ctx, key, n, ErrSelfLimited)), nil, nil
+	}
+
+	if l.opts.decay != nil {
+		l.decay(ctx, key, start)
+	}
+
+	var rec *keyRecord
+	if l.lifecycle != nil {
+		r, banned := l.checkBan(ctx, key, start)
+		if banned {
+			st := l.banState(key, r, start)
+			l.notify(ctx, false, deniedByLimit, st, n, start, time.Time{})
+			return l.shadowed(false), st, nil
+		}
+		rec = &r
+	}
+
+	allow := l.be.active().Allow
+	if soft, hard := l.opts.deadlines(); soft > 0 || hard > 0 {
+		allow = l.allowLadder
+	} else if l.coalescer != nil {
+		allow = l.allowCoalesced
+	}
+
+	allowed, st, err := allow(ctx, key, n)
+	if err != nil && l.opts.latencyBudget > 0 {
+		l.repairDetached(ctx, key, err)
+	} else if err != nil && l.repair(ctx, key, err) {
+		allowed, st, err = l.be.active().Allow(ctx, key, n)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, nil, wrapContextError(ctx.Err())
+		}
+		return l.shadowed(l.fallback(ctx, key, n, err)), nil, nil
+	}
+
+	why := deniedByLimit
+	if allowed && (l.adaptive != nil || l.warmup != nil || l.opts.priority != nil || l.opts.shedder != nil) {
+		allowed, why = l.throttle(ctx, key, n, st, start)
+	}
+
+	return l.decide(ctx, key, n, allowed, why, st, start, rec), st, nil
+}
+
+// decide completes the algorithm's decision on a request for key costing
+// n: it applies the grace period, advances the key's lifecycle record rec
+// (nil without a lifecycle policy), reports the decision and applies
+// shadow mode. why is why the request was denied: requests denied within
+// their key's limit skip the grace period and lifecycle. start is when the request
+// began.
+func (l *Limiter) decide(ctx context.Context, key string, n int, allowed bool, why denial, st *algorithm.State, start time.Time, rec *keyRecord) bool {
+	now := l.clock.Now()
+	var enforceAt time.Time
+	if !allowed && why == deniedByLimit && l.opts.gracePeriod > 0 {
+		enforceAt, allowed = l.grace(ctx, key, now)
+		if allowed {
+			l.opts.metrics.IncCounter(metrics.GraceAllowed, l.labels)
+		} else {
+			enforceAt = time.Time{}
+		}
+	}
+
+	if rec != nil && why == deniedByLimit {
+		l.advance(ctx, key, *rec, allowed, !enforceAt.IsZero(), st, now)
+	}
+
+	l.opts.metrics.ObserveDuration(metrics.DecisionDuration, now.Sub(start), l.labels)
+	l.notify(ctx, allowed, why, st, n, now, enforceAt)
+
+	return l.shadowed(allowed)
+}
+
+// shadowed turns a denial into an allowance in shadow mode.
+func (l *Limiter) shadowed(allowed bool) bool {
+	if allowed || !l.opts.shadow {
+		return allowed
+	}
+	l.opts.metrics.IncCounter(metrics.ShadowAllowed, l.labels)
+	return true
+}
+
+// State returns the current rate limiting state for key without consuming
+// any tokens.
+//
+// Example:
+//
+//	state, err := limiter.State(ctx, "user:123")
+//	if err == nil {
+//	    fmt.Printf("%d/%d used, resets in %s\n", state.Used, state.Limit, state.ResetIn)
+//	}
+func (l *Limiter) State(ctx context.Context, key string) (*State, error) {
+	if l.closed.Load() {
+		return nil, ErrLimiterClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, wrapContextError(err)
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	key = l.HashKey(key)
+	st, err := l.be.active().State(ctx, key)
+	if err != nil && l.repair(ctx, key, err) {
+		st, err = l.be.active().State(ctx, key)
+	}
+	if err != nil {
+		return nil, contextOr(ctx, err)
+	}
+
+	state := l.newState(st, l.clock.Now())
+	l.setStatus(ctx, key, state)
+	return state, nil
+}
+
+// Check reports whether a request costing n tokens would be allowed for
+// key right now, without consuming anything, along with the key's current
+// state. It lets a UI show "you have 12 requests left, this action costs
+// 5" before the user commits.
+//
+// The answer is advisory: concurrent requests may use up the tokens before
+// the real AllowN. Check ignores WithGracePeriod, so a key over its limit
+// may still be let through once by AllowN; in shadow mode Check always
+// reports true, like AllowN.
+//
+// Example:
+//
+//	ok, state, err := limiter.Check(ctx, "user:123", 5)
+//	if err == nil && !ok {
+//	    fmt.Printf("only %d left, resets in %s\n", state.Remaining, state.ResetIn)
+//	}
+func (l *Limiter) Check(ctx context.Context, key string, n int) (bool, *State, error) {
+	if err := l.checkCall(ctx, n); err != nil {
+		return false, nil, err
+	}
+
+	state, err := l.State(ctx, key)
+	if err != nil {
+		return false, nil, err
+	}
+	allowed := state.Remaining >= n && state.Status != KeyBanned && !l.blocked(key)
+	return allowed || l.exempt(key) || l.opts.shadow, state, nil
+}
+
+// Reset clears all state for key, giving it a fresh start.
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	if l.closed.Load() {
+		return ErrLimiterClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return wrapContextError(err)
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	key = l.HashKey(key)
+	err := l.be.reset(ctx, key, l.be.admin)
+	if l.opts.gracePeriod > 0 {
+		if delErr := l.be.adminStore.Delete(ctx, recordKey(graceRecord, key)); !errors.Is(delErr, storage.ErrKeyNotFound) {
+			err = errors.Join(err, delErr)
+		}
+	}
+	if l.opts.tarpit != nil {
+		if delErr := l.be.adminStore.Delete(ctx, recordKey(tarpitRecord, key)); !errors.Is(delErr, storage.ErrKeyNotFound) {
+			err = errors.Join(err, delErr)
+		}
+	}
+	if l.opts.decay != nil {
+		if delErr := l.be.adminStore.Delete(ctx, recordKey(seenRecord, key)); !errors.Is(delErr, storage.ErrKeyNotFound) {
+			err = errors.Join(err, delErr)
+		}
+	}
+	if l.warmup != nil {
+		if delErr := l.be.adminStore.Delete(ctx, recordKey(warmupRecord, key)); !errors.Is(delErr, storage.ErrKeyNotFound) {
+			err = errors.Join(err, delErr)
+		}
+	}
+	if l.lifecycle != nil {
+		err = errors.Join(err, l.resetRecord(ctx, key))
+	}
+	if err != nil {
+		return contextOr(ctx, err)
+	}
+	return nil
+}
+
+// Refund gives back n tokens consumed for key by a request that was
+// counted but never carried out, such as one whose client disconnected or
+// that failed validation before doing real work.
+//
+// A refund never leaves more available than a key that was never used
+// has, so refunding more than was consumed is harmless. Token buckets on
+// storage with atomic token operations (Redis, shared memory) and fixed
+// or bucketed sliding windows refund atomically; other algorithms update
+// their state the same way their Allow does.
+//
+// Example:
+//
+//	if allowed, _ := limiter.Allow(ctx, key); allowed {
+//	    if err := validate(req); err != nil {
+//	        limiter.Refund(ctx, key, 1)
+//	        return err
+//	    }
+//	}
+func (l *Limiter) Refund(ctx context.Context, key string, n int) error {
+	if err := l.checkCall(ctx, n); err != nil {
+		return err
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	r, ok := l.be.active().(algorithm.Refunder)
+	if !ok {
+		return &InvalidConfigError{Field: "algorithm", Value: l.opts.algorithm, Reason: "does not support refunds"}
+	}
+	if err := r.Refund(ctx, l.HashKey(key), n); err != nil {
+		return contextOr(ctx, err)
+	}
+	return nil
+}
+
+// Limit returns the limiter's current rate and window.
+func (l *Limiter) Limit() (rate int, window time.Duration) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.rate, l.window
+}
+
+// SetLimit changes the limiter's rate and window at runtime, without
+// redeploying or losing stored state.
+//
+// Keys continue from their current usage under the new limit. With
+// FixedWindow, changing the window starts fresh windows, so counts from the
+// old window no longer apply. When instances share storage, each one must
+// be updated; until then they enforce different limits on the same state.
+//
+// Returns an *InvalidConfigError if rate or window is not positive, or
+// if the limiter runs an algorithm instance (see WithAlgorithm).
+//
+// Example:
+//
+//	// Double the limit during a sale
+//	err := limiter.SetLimit(200, time.Minute)
+func (l *Limiter) SetLimit(rate int, window time.Duration) error {
+	if l.closed.Load() {
+		return ErrLimiterClosed
+	}
+	if err := validateOptions(rate, window, l.opts); err != nil {
+		return err
+	}
+	if err := l.fixedLimit(); err != nil {
+		return err
+	}
+
+	// Hold off migrations, which build backends from the current limit.
+	l.migrateMu.Lock()
+	defer l.migrateMu.Unlock()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	prevRate, prevWindow := l.rate, l.window
+	l.rate, l.window = rate, window
+
+	next := *l.be
+	if err := l.initAlgorithms(&next, rate); err != nil {
+		l.rate, l.window = prevRate, prevWindow
+		return err
+	}
+
+	prev := l.be
+	l.be = &next
+	l.detached.Wait()
+	l.logLimitChange("flexlimit: limit changed", rate, window, l.opts.algorithm)
+	l.emitEvent(Event{Type: EventConfigReloaded, At: l.clock.Now(), Rate: rate, Window: window, Algorithm: l.opts.algorithm})
+	return prev.closeAlgorithms()
+}
+
+// fixedLimit returns an error if the limit can't change, because the
+// limiter runs an algorithm instance passed to WithAlgorithm.
+func (l *Limiter) fixedLimit() error {
+	if l.opts.algorithmInstance == nil {
+		return nil
+	}
+	return &InvalidConfigError{Field: "algorithm", Value: customAlgorithm, Reason: "an algorithm instance has a fixed limit"}
+}
+
+// Keys returns the sorted keys that have stored state and start with
+// prefix. An empty prefix returns all keys. The records the limiter keeps
+// besides a key's state, such as its grace period, are stored under the
+// reserved prefix "_fl:" and never returned.
+//
+// Keys scans the limiter's storage, which can be slow on a large shared
+// store; it is meant for administration, not the request path.
+func (l *Limiter) Keys(ctx context.Context, prefix string) ([]string, error) {
+	if l.closed.Load() {
+		return nil, ErrLimiterClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, wrapContextError(err)
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	pattern := prefix + "*"
+	if p, ok := l.be.admin.(algorithm.KeyPatterner); ok {
+		pattern = p.KeyPattern(prefix)
+	}
+	stored, err := l.be.adminStore.Keys(ctx, pattern)
+	if err != nil {
+		return nil, contextOr(ctx, err)
+	}
+
+	mapper, _ := l.be.admin.(algorithm.KeyMapper)
+	seen := make(map[string]struct{}, len(stored))
+	keys := make([]string, 0, len(stored))
+	for _, key := range stored {
+		if internalKey(key) {
+			continue
+		}
+		if mapper != nil {
+			var ok bool
+			if key, ok = mapper.KeyOf(key); !ok {
+				continue
+			}
+		}
+		if _, dup := seen[key]; dup {
+			continue
+		}
+		seen[key] = struct{}{}
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// States returns the state of several keys without consuming any tokens,
+// in the order of keys. Entries for keys whose stored state is corrupt are
+// nil.
+//
+// States is meant for bulk reads by admin tools and exporters; with
+// WithAdminStorage it doesn't use the request path's storage connections.
+func (l *Limiter) States(ctx context.Context, keys []string) ([]*State, error) {
+	if l.closed.Load() {
+		return nil, ErrLimiterClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, wrapContextError(err)
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	now := l.clock.Now()
+	states := make([]*State, len(keys))
+	for i, key := range keys {
+		st, err := l.be.admin.State(ctx, key)
+		if errors.Is(err, storage.ErrInvalidState) {
+			continue
+		}
+		if err != nil {
+			return nil, contextOr(ctx, err)
+		}
+		states[i] = l.newState(st, now)
+	}
+	return states, nil
+}
+
+// Close releases the limiter's resources.
+//
+// Storage created by the limiter is closed; storage passed with
+// WithStorage is left open for the caller to close. Calling Close more
+// than once is a no-op. Services should prefer Shutdown, which bounds how
+// long closing may take.
+func (l *Limiter) Close() error {
+	l.closed.Store(true)
+	l.stopRegions()
+	l.stopMaintenance()
+	defer l.closeEvents()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.released {
+		return nil
+	}
+	l.released = true
+	l.detached.Wait()
+	return l.be.close()
+}
+
+// Shutdown closes the limiter gracefully, within ctx. It stops taking
+// requests, which then fail with ErrLimiterClosed, the background
+// reconciliation of WithRegions and the schedule of
+// WithMaintenanceWindows, waits for the storage calls still running after
+// their request was decided (see WithLatencyBudget), writes back the
+// consumption buffered by WithLocalCache, returns the unused tokens of
+// WithTokenLeasing to the shared storage, flushes a metrics collector
+// implementing metrics.Flusher, and then closes the limiter's resources as
+// Close does.
+//
+// If ctx ends or a flush fails first, Shutdown returns the error and
+// keeps the resources, so Shutdown can be called again or Close can
+// release them anyway. Calling Shutdown after the limiter is closed is a
+// no-op.
+//
+// Example:
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+//	defer cancel()
+//	if err := limiter.Shutdown(ctx); err != nil {
+//	    log.Printf("flexlimit: %v", err)
+//	    limiter.Close()
+//	}
+func (l *Limiter) Shutdown(ctx context.Context) error {
+	l.closed.Store(true)
+	l.stopRegions()
+	l.stopMaintenance()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.released {
+		return nil
+	}
+	if err := l.waitDetached(ctx); err != nil {
+		return err
+	}
+	if err := l.be.flush(ctx); err != nil {
+		return contextOr(ctx, err)
+	}
+	if f, ok := l.opts.metrics.(metrics.Flusher); ok {
+		if err := f.Flush(ctx); err != nil {
+			return contextOr(ctx, err)
+		}
+	}
+
+	l.released = true
+	defer l.closeEvents()
+	return l.be.close()
+}
+
+// waitDetached waits for the detached storage calls to finish, or for ctx
+// to end. Must be called with l.mu held for writing.
+func (l *Limiter) waitDetached(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		l.detached.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return wrapContextError(ctx.Err())
+	}
+}
+
+// checkCall validates the common arguments of a request costing n tokens,
+// and applies the maintenance windows that opened or closed meanwhile.
+func (l *Limiter) checkCall(ctx context.Context, n int) error {
+	if l.closed.Load() {
+		return ErrLimiterClosed
+	}
+	if n <= 0 {
+		return &InvalidConfigError{
+			Field:  "cost",
+			Value:  n,
+			Reason: "must be positive",
+		}
+	}
+	l.maintain()
+	return wrapContextError(ctx.Err())
+}
+
+// fallback decides a request after the algorithm failed with err.
+// Must be called with l.mu held.
+func (l *Limiter) fallback(ctx context.Context, key string, n int, err error) bool {
+	l.opts.metrics.IncCounter(metrics.StorageErrors, l.labels)
+	l.warn(ctx, "flexlimit: storage error, using fallback strategy",
+		"key", key, "strategy", l.opts.fallbackStrategy, "error", err)
+	return l.degrade(ctx, key, n, err)
+}
+
+// degrade decides a request with the fallback strategy instead of the
+// algorithm, because of err. Must be called with l.mu held.
+func (l *Limiter) degrade(ctx context.Context, key string, n int, err error) bool {
+	l.opts.metrics.IncCounter(metrics.FallbackActivations, metrics.Labels{
+		metrics.LabelStrategy: l.opts.fallbackStrategy,
+	})
+
+	switch FallbackStrategy(l.opts.fallbackStrategy) {
+	case DenyAll:
+		l.fallbackActivated(err)
+		return false
+	case LocalMemory:
+		// After a storage failure, the failover store already retried on
+		// local state and this only fails open if local state failed too.
+		if allowed, _, localErr := l.be.local.Allow(ctx, key, n); localErr == nil {
+			return allowed
+		}
+		return true
+	default:
+		l.fallbackActivated(err)
+		return true
+	}
+}
+
+// repair resets key if cause reports corrupt state and auto-repair is
+// enabled. It returns true if the caller should retry on the fresh state.
+// Must be called with l.mu held.
+func (l *Limiter) repair(ctx context.Context, key string, cause error) bool {
+	if !l.opts.autoRepair || !errors.Is(cause, storage.ErrInvalidState) {
+		return false
+	}
+
+	if err := l.be.reset(ctx, key, l.be.algo); err != nil {
+		return false
+	}
+	l.repaired(ctx, key, cause)
+	return true
+}
+
+// repairDetached resets key in the background if cause reports corrupt
+// state and auto-repair is enabled, so a latency budget isn't spent on
+// it. Must be called with l.mu held.
+func (l *Limiter) repairDetached(ctx context.Context, key string, cause error) {
+	if !l.opts.autoRepair || !errors.Is(cause, storage.ErrInvalidState) {
+		return
+	}
+
+	// The reset may outlive l.mu, so whatever closes the backend waits
+	// for it on l.detached
+	be := l.be
+	l.detached.Add(1)
+	go func() {
+		defer l.detached.Done()
+		if be.reset(context.WithoutCancel(ctx), key, be.algo) == nil {
+			l.repaired(ctx, key, cause)
+		}
+	}()
+}
+
+// repaired reports that key was reset because of cause, on the request
+// path of ctx.
+func (l *Limiter) repaired(ctx context.Context, key string, cause error) {
+	l.opts.metrics.IncCounter(metrics.StateRepairs, l.labels)
+	l.warn(ctx, "flexlimit: repaired corrupt state", "key", key, "error", cause)
+	if l.opts.onRepair != nil {
+		l.opts.onRepair(key, cause)
+	}
+}
+
+// selfLimited reports that a self-limit on resource kicked in on the
+// request path of ctx.
+func (l *Limiter) selfLimited(ctx context.Context, resource string) {
+	l.opts.metrics.IncCounter(metrics.SelfLimited, metrics.Labels{
+		metrics.LabelAlgorithm: l.opts.algorithm,
+		metrics.LabelResource:  resource,
+	})
+	l.warn(ctx, "flexlimit: self-limit reached", "resource", resource)
+}
+
+// fallbackActivated reports a fallback activation to the user callback.
+func (l *Limiter) fallbackActivated(err error) {
+	if l.opts.onFallback != nil && l.callbacks.allow(CallbackOnFallback, "", l.clock.Now()) {
+		l.opts.onFallback(err)
+	}
+	l.emitEvent(Event{Type: EventFallbackActivated, At: l.clock.Now(), Err: err})
+}
+
+// notify reports a decision to metrics and callbacks. why is why the
+// request was denied, and enforceAt is non-zero if it was only allowed by
+// a grace period.
+func (l *Limiter) notify(ctx context.Context, allowed bool, why denial, st *algorithm.State, cost int, now, enforceAt time.Time) {
+	if allowed {
+		l.opts.metrics.IncCounter(metrics.RequestsAllowed, l.labels)
+	} else {
+		l.opts.metrics.IncCounter(metrics.RequestsDenied, l.labels)
+	}
+
+	callback, kind := l.opts.onLimit, CallbackOnLimit
+	if allowed {
+		callback, kind = l.opts.onAllow, CallbackOnAllow
+	}
+	if callback != nil && !l.callbacks.allow(kind, st.Key, now) {
+		callback = nil
+	}
+	changes := allowed && l.opts.onStateChange != nil
+	if (callback != nil || changes) && !l.callbackGate.allow(now) {
+		l.selfLimited(ctx, "callbacks")
+		callback, changes = nil, false
+	}
+	stream := l.events.Load()
+	if callback == nil && !changes && stream == nil {
+		return
+	}
+
+	info := LimitInfo{
+		Key:       st.Key,
+		Allowed:   allowed,
+		Limit:     int(st.Limit),
+		Used:      int(st.Current),
+		Remaining: int(st.Remaining),
+		ResetAt:   st.ResetAt,
+		ResetIn:   durationUntil(st.ResetAt, now),
+		Cost:      cost,
+		Algorithm: st.Algorithm,
+		Warning:   !enforceAt.IsZero(),
+		EnforceAt: enforceAt,
+		Shed:      why == deniedByShed,
+		Preempted: why == deniedByPriority,
+		Blocked:   why == deniedByList,
+	}
+	info.Metadata = MetadataFromContext(ctx)
+	info.withTrace(ctx)
+	if callback != nil {
+		callback(info)
+	}
+	if changes {
+		l.stateChanged(ctx, info, now)
+	}
+	if stream != nil {
+		e := Event{Type: EventDenied, At: now, Key: info.Key, Info: info}
+		if allowed {
+			e.Type = EventAllowed
+		}
+		l.emit(stream, e)
+	}
+}
+
+// newState converts an algorithm state into the public State.
+func (l *Limiter) newState(st *algorithm.State, now time.Time) *State {
+	return &State{
+		Key:       st.Key,
+		Limit:     int(st.Limit),
+		Used:      int(st.Current),
+		Remaining: int(st.Remaining),
+		ResetAt:   st.ResetAt,
+		ResetIn:   durationUntil(st.ResetAt, now),
+		Window:    l.window,
+	}
+}
+
+// algorithmConfig builds the algorithm configuration for the given rate.
+func (l *Limiter) algorithmConfig(rate int) algorithm.Config {
+	burst, rollover := l.opts.burstSize, l.opts.rollover
+	if m := l.maintenance; m != nil {
+		rate, burst, rollover = m.scale(rate), m.scale(burst), m.scale(rollover)
+	}
+	if l.regions != nil {
+		rate = l.regions.scale(rate)
+		if burst > 0 {
+			burst = l.regions.scale(burst)
+		}
+		if rollover > 0 {
+			rollover = l.regions.scale(rollover)
+		}
+	}
+	return algorithm.Config{
+		Rate:        int64(rate),
+		Window:      l.window,
+		BurstSize:   int64(burst),
+		Buckets:     int64(l.opts.windowBuckets),
+		QueueSize:   int64(l.opts.queueSize),
+		DrainJitter: l.opts.drainJitter,
+		Algorithm:   l.opts.algorithm,
+		Calendar:    string(l.opts.calendar),
+		Location:    l.opts.location,
+		Rollover:    int64(rollover),
+	}
+}
+
+// newMemoryStore creates an in-memory store from the limiter options.
+func (l *Limiter) newMemoryStore() *storage.Memory {
+	return storage.NewMemory(l.memoryConfig())
+}
+
+// newOwnedStore creates the in-memory store of a limiter without
+// WithStorage. Token buckets are kept in an AtomicMemory, so Allow takes
+// no lock on the hot path.
+func (l *Limiter) newOwnedStore() storage.Storage {
+	if l.opts.algorithm == string(TokenBucket) {
+		return storage.NewAtomicMemory(l.memoryConfig())
+	}
+	return l.newMemoryStore()
+}
+
+// memoryConfig returns the configuration of the limiter's in-memory
+// stores.
+func (l *Limiter) memoryConfig() storage.Config {
+	return storage.Config{
+		Backend:         "memory",
+		MaxKeys:         l.maxKeysFor(),
+		CleanupInterval: l.opts.cleanupInterval,
+		Clock:           l.clock,
+		OnEvict:         l.onEvict,
+	}
+}
+
+// onEvict reports a key dropped by an in-memory store to the logger and
+// OnKeyEvicted. Storage keys of a rate limit key other than its main state
+// (grace and lifecycle records, and with a key mapper, keys it doesn't
+// recognize) are skipped.
+func (l *Limiter) onEvict(key string, state *storage.State, reason storage.EvictReason) {
+	if internalKey(key) {
+		return
+	}
+	if l.keyMapper != nil {
+		var ok bool
+		if key, ok = l.keyMapper.KeyOf(key); !ok {
+			return
+		}
+	}
+	l.opts.metrics.IncCounter(metrics.KeysEvicted, metrics.Labels{
+		metrics.LabelAlgorithm: l.opts.algorithm,
+		metrics.LabelReason:    reason.String(),
+	})
+	l.logEviction(key, reason)
+	if l.opts.onKeyEvicted != nil {
+		l.opts.onKeyEvicted(key, state)
+	}
+	l.emitEvent(Event{Type: EventEvicted, At: l.clock.Now(), Key: key})
+}
+
+// validateOptions checks the constructor arguments and collected options.
+func validateOptions(rate int, window time.Duration, o *Options) error {
+	if rate <= 0 {
+		return &InvalidConfigError{Field: "rate", Value: rate, Reason: "must be positive"}
+	}
+	if window <= 0 {
+		return &InvalidConfigError{Field: "window", Value: window, Reason: "must be positive"}
+	}
+	if o.algorithmInstance == nil {
+		if err := AlgorithmType(o.algorithm).Validate(); err != nil {
+			return err
+		}
+	}
+	if err := FallbackStrategy(o.fallbackStrategy).Validate(); err != nil {
+		return err
+	}
+	if o.burstSize < 0 {
+		return &InvalidConfigError{Field: "burst_size", Value: o.burstSize, Reason: "cannot be negative"}
+	}
+	if strings.ContainsAny(o.keyPrefix, "*?[") {
+		return &InvalidConfigError{Field: "key_prefix", Value: o.keyPrefix, Reason: "must not contain *, ? or ["}
+	}
+	if o.maxKeys <= 0 {
+		return &InvalidConfigError{Field: "max_keys", Value: o.maxKeys, Reason: "must be positive"}
+	}
+	if o.cleanupInterval < 0 {
+		return &InvalidConfigError{Field: "cleanup_interval", Value: o.cleanupInterval, Reason: "cannot be negative"}
+	}
+	if o.windowBuckets < 0 {
+		return &InvalidConfigError{Field: "window_buckets", Value: o.windowBuckets, Reason: "cannot be negative"}
+	}
+	if o.windowBuckets > 0 && o.algorithm != string(SlidingWindow) {
+		return &InvalidConfigError{Field: "window_buckets", Value: o.windowBuckets, Reason: "requires the sliding_window algorithm"}
+	}
+	if int64(o.windowBuckets) > int64(window) {
+		return &InvalidConfigError{Field: "window_buckets", Value: o.windowBuckets, Reason: "cannot exceed the window in nanoseconds"}
+	}
+	if o.calendar != "" {
+		if err := o.calendar.Validate(); err != nil {
+			return err
+		}
+		if o.algorithm != string(FixedWindow) {
+			return &InvalidConfigError{Field: "calendar", Value: o.calendar, Reason: "requires the fixed_window algorithm"}
+		}
+	}
+	if o.queueSize < 0 {
+		return &InvalidConfigError{Field: "queue_size", Value: o.queueSize, Reason: "cannot be negative"}
+	}
+	if o.queueSize > 0 && o.algorithm != string(LeakyBucket) {
+		return &InvalidConfigError{Field: "queue_size", Value: o.queueSize, Reason: "requires the leaky_bucket algorithm"}
+	}
+	if o.drainJitter < 0 {
+		return &InvalidConfigError{Field: "drain_jitter", Value: o.drainJitter, Reason: "cannot be negative"}
+	}
+	if o.drainJitter > 0 && o.algorithm != string(LeakyBucket) {
+		return &InvalidConfigError{Field: "drain_jitter", Value: o.drainJitter, Reason: "requires the leaky_bucket algorithm"}
+	}
+	if o.rollover < 0 {
+		return &InvalidConfigError{Field: "rollover", Value: o.rollover, Reason: "cannot be negative"}
+	}
+	if o.rollover > 0 && o.algorithm != string(FixedWindow) {
+		return &InvalidConfigError{Field: "rollover", Value: o.rollover, Reason: "requires the fixed_window algorithm"}
+	}
+	if t := o.tarpit; t != nil {
+		if t.BaseDelay < 0 || t.MaxDelay < t.BaseDelay || t.Multiplier < 1 || t.Jitter < 0 || t.Jitter > 1 {
+			return &InvalidConfigError{Field: "tarpit", Value: *t, Reason: "delays must be positive with max delay at least base delay, multiplier at least 1 and jitter in [0, 1]"}
+		}
+		if o.queueSize > 0 {
+			return &InvalidConfigError{Field: "tarpit", Value: *t, Reason: "cannot be combined with a queue"}
+		}
+	}
+	if o.gracePeriod < 0 {
+		return &InvalidConfigError{Field: "grace_period", Value: o.gracePeriod, Reason: "cannot be negative"}
+	}
+	if a := o.advisor; a != nil {
+		if a.Period < 0 || a.SafetyFactor < 0 || a.MaxSamples < 0 {
+			return &InvalidConfigError{Field: "advisor", Value: *a, Reason: "period, safety factor and max samples cannot be negative"}
+		}
+		if a.Percentile < 0 || a.Percentile > 100 {
+			return &InvalidConfigError{Field: "advisor", Value: *a, Reason: "percentile must be in (0, 100]"}
+		}
+	}
+	if p := o.lifecycle; p != nil {
+		if p.WarnAt < 0 || p.WarnAt >= 1 {
+			return &InvalidConfigError{Field: "lifecycle", Value: *p, Reason: "warn threshold must be in [0, 1)"}
+		}
+		if p.BanAfter < 0 || p.BanWithin < 0 || p.BanDuration < 0 || p.ArchiveAfter < 0 ||
+			slices.ContainsFunc(p.BanEscalation, func(d time.Duration) bool { return d <= 0 }) {
+			return &InvalidConfigError{Field: "lifecycle", Value: *p, Reason: "ban and archive settings cannot be negative, nor escalating bans zero"}
+		}
+	}
+	if a := o.adaptive; a != nil {
+		if a.Decrease < 0 || a.Decrease >= 1 || a.Increase < 0 || a.Increase > 1 || a.MinFraction < 0 || a.MinFraction > 1 {
+			return &InvalidConfigError{Field: "adaptive", Value: *a, Reason: "decrease must be in (0, 1), increase and min fraction in (0, 1]"}
+		}
+		if a.LatencyTarget < 0 || a.Cooldown < 0 || a.IdleReset < 0 || a.MaxKeys < 0 {
+			return &InvalidConfigError{Field: "adaptive", Value: *a, Reason: "durations and max keys cannot be negative"}
+		}
+	}
+	if w := o.warmup; w != nil {
+		if w.Period <= 0 || w.IdleReset < 0 {
+			return &InvalidConfigError{Field: "warmup", Value: *w, Reason: "period must be positive and idle reset not negative"}
+		}
+		if w.StartFraction < 0 || w.StartFraction > 1 {
+			return &InvalidConfigError{Field: "warmup", Value: *w, Reason: "start fraction must be in (0, 1]"}
+		}
+	}
+	if d := o.decay; d != nil {
+		if d.IdleAfter <= 0 {
+			return &InvalidConfigError{Field: "decay", Value: *d, Reason: "idle period must be positive"}
+		}
+		if !d.Refill && !d.ClearPenalties && !d.LiftBans {
+			return &InvalidConfigError{Field: "decay", Value: *d, Reason: "must refill, clear penalties or lift bans"}
+		}
+	}
+	if r := o.priority; r != nil && (r.Reserve <= 0 || r.Reserve >= 1) {
+		return &InvalidConfigError{Field: "priority_reserve", Value: r.Reserve, Reason: "must be in (0, 1)"}
+	}
+	if o.softDeadline < 0 || o.hardDeadline < 0 {
+		return &InvalidConfigError{Field: "deadlines", Value: [2]time.Duration{o.softDeadline, o.hardDeadline}, Reason: "cannot be negative"}
+	}
+	if o.softDeadline > 0 && o.hardDeadline > 0 && o.softDeadline >= o.hardDeadline {
+		return &InvalidConfigError{Field: "deadlines", Value: [2]time.Duration{o.softDeadline, o.hardDeadline}, Reason: "soft deadline must be below the hard deadline"}
+	}
+	if o.latencyBudget < 0 {
+		return &InvalidConfigError{Field: "latency_budget", Value: o.latencyBudget, Reason: "cannot be negative"}
+	}
+	if o.latencyBudget > 0 {
+		switch {
+		case o.hardDeadline > 0:
+			return &InvalidConfigError{Field: "latency_budget", Value: o.latencyBudget, Reason: "replaces the hard deadline of WithDeadlines"}
+		case o.softDeadline >= o.latencyBudget:
+			return &InvalidConfigError{Field: "latency_budget", Value: o.latencyBudget, Reason: "soft deadline must be below the budget"}
+		case o.gracePeriod > 0 || o.lifecycle != nil || o.adaptive != nil || o.warmup != nil || o.decay != nil || o.priority != nil || o.shedder != nil:
+			return &InvalidConfigError{Field: "latency_budget", Value: o.latencyBudget, Reason: "can't bound the storage calls of WithGracePeriod, WithLifecycle, WithAdaptive, WithWarmup, WithDecay, WithPriorityReserve or WithLoadShedder"}
+		}
+	}
+	if o.maxClockSkew < 0 {
+		return &InvalidConfigError{Field: "max_clock_skew", Value: o.maxClockSkew, Reason: "cannot be negative"}
+	}
+	if o.cacheStaleness < 0 {
+		return &InvalidConfigError{Field: "cache_staleness", Value: o.cacheStaleness, Reason: "cannot be negative"}
+	}
+	if o.leaseSize < 0 {
+		return &InvalidConfigError{Field: "lease_size", Value: o.leaseSize, Reason: "cannot be negative"}
+	}
+	if o.leaseSize > 0 && o.cacheStaleness > 0 {
+		return &InvalidConfigError{Field: "lease_size", Value: o.leaseSize, Reason: "can't be combined with WithLocalCache"}
+	}
+	if o.coalesceWindow < 0 {
+		return &InvalidConfigError{Field: "coalesce_window", Value: o.coalesceWindow, Reason: "cannot be negative"}
+	}
+	if soft, hard := o.deadlines(); o.coalesceWindow > 0 && (soft > 0 || hard > 0) {
+		return &InvalidConfigError{Field: "coalesce_window", Value: o.coalesceWindow, Reason: "can't be combined with WithDeadlines or WithLatencyBudget"}
+	}
+	if s := o.selfLimits; s.StorageOpsPerSecond < 0 || s.CallbacksPerSecond < 0 || s.MaxMemory < 0 {
+		return &InvalidConfigError{Field: "self_limits", Value: s, Reason: "cannot be negative"}
+	}
+	if o.eventBuffer < 0 {
+		return &InvalidConfigError{Field: "event_buffer", Value: o.eventBuffer, Reason: "cannot be negative"}
+	}
+	if o.localFallbackScale < 1 {
+		return &InvalidConfigError{Field: "local_fallback_scale", Value: o.localFallbackScale, Reason: "must be at least 1"}
+	}
+	if err := validateThresholds(o.thresholds); err != nil {
+		return err
+	}
+	if err := validateCallbackPolicies(o.callbackPolicies); err != nil {
+		return err
+	}
+	if len(o.maintenance) > 0 {
+		if o.algorithmInstance != nil {
+			return &InvalidConfigError{Field: "maintenance", Value: len(o.maintenance), Reason: "an algorithm instance has a fixed limit"}
+		}
+		if err := validateMaintenance(o.maintenance); err != nil {
+			return err
+		}
+	}
+	if r := o.regions; r != nil {
+		if o.algorithmInstance != nil {
+			return &InvalidConfigError{Field: "regions", Value: r.Region, Reason: "an algorithm instance has a fixed limit"}
+		}
+		return r.validate()
+	}
+	return nil
+}
+
+// durationUntil returns t - now, or zero if t is in the past.
+func durationUntil(t, now time.Time) time.Duration {
+	if d := t.Sub(now); d > 0 {
+		return d
+	}
+	return 0
+}
@@ -0,0 +1,238 @@
+package flexlimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Vipul984/flexlimit/clock"
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+func TestNewRejectsInvalidLimit(t *testing.T) {
+	tests := []struct {
+		name   string
+		rate   int
+		window time.Duration
+		field  string
+	}{
+		{"zero rate", 0, time.Minute, "rate"},
+		{"negative rate", -1, time.Minute, "rate"},
+		{"zero window", 10, 0, "window"},
+		{"negative window", 10, -time.Second, "window"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.rate, tt.window)
+			var cfgErr *InvalidConfigError
+			if !errors.As(err, &cfgErr) || !errors.Is(err, ErrInvalidConfig) {
+				t.Fatalf("New = %v, want an *InvalidConfigError", err)
+			}
+			if cfgErr.Field != tt.field {
+				t.Errorf("Field = %q, want %q", cfgErr.Field, tt.field)
+			}
+		})
+	}
+}
+
+func TestAllowN(t *testing.T) {
+	for _, algo := range []AlgorithmType{TokenBucket, SlidingWindow, FixedWindow} {
+		t.Run(string(algo), func(t *testing.T) {
+			clk := clock.NewMockAt(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+			l, err := New(10, time.Minute, WithAlgorithm(algo), WithClock(clk))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			ctx := context.Background()
+
+			if ok, err := l.AllowN(ctx, "k", 7); !ok || err != nil {
+				t.Fatalf("AllowN(7) = %v, %v, want allowed", ok, err)
+			}
+			// All or nothing: 4 more don't fit, and none are consumed
+			if ok, _ := l.AllowN(ctx, "k", 4); ok {
+				t.Error("AllowN(4) allowed with 3 left")
+			}
+			state, err := l.State(ctx, "k")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state.Limit != 10 || state.Used != 7 || state.Remaining != 3 {
+				t.Errorf("State = %d/%d used, %d remaining, want 7/10 used, 3 remaining", state.Used, state.Limit, state.Remaining)
+			}
+
+			for range 3 {
+				if ok, _ := l.Allow(ctx, "k"); !ok {
+					t.Fatal("request within the limit denied")
+				}
+			}
+			if ok, _ := l.Allow(ctx, "k"); ok {
+				t.Error("request over the limit allowed")
+			}
+			if ok, _ := l.Allow(ctx, "other"); !ok {
+				t.Error("another key shares the limit")
+			}
+
+			clk.Advance(time.Minute)
+			if ok, _ := l.AllowN(ctx, "k", 10); !ok {
+				t.Error("the full limit was not available a window later")
+			}
+		})
+	}
+}
+
+func TestAllowNInvalidCost(t *testing.T) {
+	l, err := New(10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for _, n := range []int{0, -1} {
+		if _, err := l.AllowN(context.Background(), "k", n); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("AllowN(%d) = %v, want ErrInvalidConfig", n, err)
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	l, err := New(2, time.Hour, WithGracePeriod(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	ctx := context.Background()
+
+	for range 4 {
+		l.Allow(ctx, "k")
+	}
+	if err := l.Reset(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := l.State(ctx, "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Used != 0 {
+		t.Errorf("Used = %d after Reset, want 0", state.Used)
+	}
+	if ok, _ := l.AllowN(ctx, "k", 2); !ok {
+		t.Error("the full limit was not available after Reset")
+	}
+}
+
+func TestCanceledContext(t *testing.T) {
+	l, err := New(10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.Allow(ctx, "k"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Allow = %v, want context.Canceled", err)
+	}
+	if _, err := l.State(ctx, "k"); !errors.Is(err, context.Canceled) {
+		t.Errorf("State = %v, want context.Canceled", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	store := storage.NewMemory(storage.Config{})
+	defer store.Close()
+
+	l, err := New(10, time.Minute, WithStorage(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Errorf("second Close = %v, want nil", err)
+	}
+
+	ctx := context.Background()
+	if _, err := l.Allow(ctx, "k"); !errors.Is(err, ErrLimiterClosed) {
+		t.Errorf("Allow = %v, want ErrLimiterClosed", err)
+	}
+	if _, err := l.State(ctx, "k"); !errors.Is(err, ErrLimiterClosed) {
+		t.Errorf("State = %v, want ErrLimiterClosed", err)
+	}
+	if err := l.Reset(ctx, "k"); !errors.Is(err, ErrLimiterClosed) {
+		t.Errorf("Reset = %v, want ErrLimiterClosed", err)
+	}
+
+	// Storage passed with WithStorage belongs to the caller
+	if err := store.Set(ctx, "k", &storage.State{}, 0); err != nil {
+		t.Errorf("storage closed with the limiter: %v", err)
+	}
+}
+
+func TestKeyPrefix(t *testing.T) {
+	store := storage.NewMemory(storage.Config{})
+	defer store.Close()
+	ctx := context.Background()
+
+	newLimiter := func(prefix string) *Limiter {
+		l, err := New(1, time.Minute, WithStorage(store), WithKeyPrefix(prefix))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.Close() })
+		return l
+	}
+	a, b := newLimiter("a:"), newLimiter("b:")
+
+	if ok, _ := a.Allow(ctx, "k"); !ok {
+		t.Fatal("first request denied")
+	}
+	if ok, _ := b.Allow(ctx, "k"); !ok {
+		t.Error("limiters with different prefixes share a key")
+	}
+	if ok, _ := a.Allow(ctx, "k"); ok {
+		t.Error("second request allowed")
+	}
+
+	keys, err := store.Keys(ctx, "a:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) == 0 {
+		t.Error("no key stored under the limiter's prefix")
+	}
+}
+
+func TestLocalMemoryFallback(t *testing.T) {
+	l, err := New(10, time.Minute,
+		WithStorage(downStore{storage.NewMemory(storage.Config{})}),
+		WithFallback(LocalMemory),
+		WithLocalFallbackScale(2),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// The first failure switches the limiter to local state; from then on
+	// keys are limited locally at the scaled rate
+	if ok, _ := l.Allow(context.Background(), "first"); !ok {
+		t.Fatal("request denied when the storage failed")
+	}
+	allowed := 0
+	for range 10 {
+		ok, err := l.Allow(context.Background(), "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Errorf("allowed %d requests, want the local rate of 5", allowed)
+	}
+}
@@ -0,0 +1,272 @@
+package flexlimit
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Vipul984/flexlimit/algorithm"
+	"github.com/Vipul984/flexlimit/metrics"
+)
+
+// Gauge is a custom load signal of a LoadShedder, such as a queue depth
+// or a connection pool's usage.
+type Gauge struct {
+	// Name identifies the gauge in LoadShedConfig.OnChange
+	Name string
+
+	// Value returns the current value of the gauge. It is called from the
+	// LoadShedder's goroutine and must not block
+	Value func() float64
+
+	// Threshold is the value above which the process is overloaded
+	Threshold float64
+}
+
+// LoadShedConfig configures a LoadShedder. At least one signal must be
+// set.
+type LoadShedConfig struct {
+	// MaxCPU is the CPU usage of the process, as a fraction of
+	// GOMAXPROCS, above which it is overloaded, in (0, 1]. Only available
+	// on Unix. 0 ignores CPU
+	MaxCPU float64
+
+	// MaxGoroutines is the number of goroutines above which the process
+	// is overloaded. 0 ignores goroutines
+	MaxGoroutines int
+
+	// Gauges are custom signals
+	Gauges []Gauge
+
+	// Interval is how often the signals are sampled. Default: 1 second
+	Interval time.Duration
+
+	// Decrease multiplies the fraction of their rate keys may use at each
+	// sample while the process is overloaded, in (0, 1). Default: 0.75
+	Decrease float64
+
+	// Increase is added back to the fraction at each sample while it is
+	// not, in (0, 1]. Default: 0.1
+	Increase float64
+
+	// MinFraction is the lowest fraction of their rate keys are lowered
+	// to, in (0, 1]. Default: 0.1
+	MinFraction float64
+
+	// OnChange is called from the LoadShedder's goroutine when the
+	// fraction changes, with the names of the signals over their
+	// threshold: "cpu", "goroutines" and gauge names.
+	OnChange func(fraction float64, overloaded []string)
+}
+
+// LoadShedder lowers the rates of every key of the limiters using it
+// while the process is overloaded, so a struggling service sheds load
+// across all its clients instead of queueing it. Share one LoadShedder
+// between all the limiters of a process with WithLoadShedder.
+//
+// The LoadShedder samples its signals every interval. While any is over
+// its threshold, the fraction of their rate keys may use goes down
+// multiplicatively; once all are below, it comes back up additively.
+type LoadShedder struct {
+	cfg      LoadShedConfig
+	fraction atomic.Uint64 // math.Float64bits of the fraction
+
+	// CPU time and wall time at the previous sample
+	lastCPU  time.Duration
+	lastWall time.Time
+
+	closeOnce sync.Once
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewLoadShedder creates a LoadShedder and starts sampling its signals in
+// the background. Call Close to stop it.
+//
+// Returns an *InvalidConfigError if no signal is set, a field is out of
+// range, or MaxCPU is set on a platform where CPU usage can't be read.
+func NewLoadShedder(cfg LoadShedConfig) (*LoadShedder, error) {
+	switch {
+	case cfg.MaxCPU == 0 && cfg.MaxGoroutines == 0 && len(cfg.Gauges) == 0:
+		return nil, &InvalidConfigError{Field: "load_shed", Value: cfg, Reason: "needs at least one signal"}
+	case cfg.MaxCPU < 0 || cfg.MaxCPU > 1:
+		return nil, &InvalidConfigError{Field: "load_shed", Value: cfg.MaxCPU, Reason: "max CPU must be in (0, 1]"}
+	case cfg.MaxGoroutines < 0 || cfg.Interval < 0:
+		return nil, &InvalidConfigError{Field: "load_shed", Value: cfg, Reason: "max goroutines and interval cannot be negative"}
+	case cfg.Decrease < 0 || cfg.Decrease >= 1 || cfg.Increase < 0 || cfg.Increase > 1 || cfg.MinFraction < 0 || cfg.MinFraction > 1:
+		return nil, &InvalidConfigError{Field: "load_shed", Value: cfg, Reason: "decrease must be in (0, 1), increase and min fraction in (0, 1]"}
+	}
+	if _, ok := processCPU(); cfg.MaxCPU > 0 && !ok {
+		return nil, &InvalidConfigError{Field: "load_shed", Value: cfg.MaxCPU, Reason: "CPU usage is not available on this platform"}
+	}
+	for _, g := range cfg.Gauges {
+		if g.Value == nil {
+			return nil, &InvalidConfigError{Field: "load_shed", Value: g.Name, Reason: "gauge has no value function"}
+		}
+	}
+
+	if cfg.Interval == 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.Decrease == 0 {
+		cfg.Decrease = 0.75
+	}
+	if cfg.Increase == 0 {
+		cfg.Increase = 0.1
+	}
+	if cfg.MinFraction == 0 {
+		cfg.MinFraction = 0.1
+	}
+
+	s := &LoadShedder{
+		cfg:      cfg,
+		lastWall: time.Now(),
+		stop:     make(chan struct{}),
+	}
+	s.fraction.Store(math.Float64bits(1))
+	s.lastCPU, _ = processCPU()
+
+	s.wg.Add(1)
+	go s.run()
+	return s, nil
+}
+
+// WithLoadShedder makes the limiter shed load with s: while s reports the
+// process overloaded, an allowed request is denied, and its tokens
+// refunded, if it takes what its key consumed in the current window above
+// s's fraction of the rate, as with WithAdaptive.
+//
+// Shed requests are reported to OnLimit with LimitInfo.Shed set, and
+// counted in metrics.LoadShed as well as metrics.RequestsDenied. They
+// don't count as denials for WithGracePeriod or WithLifecycle. AllowMulti
+// decides requests one by one.
+//
+// Example:
+//
+//	shedder, err := flexlimit.NewLoadShedder(flexlimit.LoadShedConfig{
+//	    MaxCPU:        0.85,
+//	    MaxGoroutines: 50000,
+//	})
+//	if err != nil {
+//	    return err
+//	}
+//	defer shedder.Close()
+//
+//	api, err := flexlimit.New(100, time.Minute, flexlimit.WithLoadShedder(shedder))
+func WithLoadShedder(s *LoadShedder) Option {
+	return func(o *Options) {
+		o.shedder = s
+	}
+}
+
+// Fraction returns the fraction of their rate keys may currently use: 1
+// when the process isn't overloaded.
+func (s *LoadShedder) Fraction() float64 {
+	return math.Float64frombits(s.fraction.Load())
+}
+
+// Close stops sampling and restores full rates. Calling Close more than
+// once is a no-op.
+func (s *LoadShedder) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		s.wg.Wait()
+		s.fraction.Store(math.Float64bits(1))
+	})
+	return nil
+}
+
+// run samples the signals every interval until Close.
+func (s *LoadShedder) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.sample()
+		}
+	}
+}
+
+// sample reads the signals and moves the fraction.
+func (s *LoadShedder) sample() {
+	var overloaded []string
+
+	if s.cfg.MaxCPU > 0 {
+		now := time.Now()
+		cpu, _ := processCPU()
+		if wall := now.Sub(s.lastWall); wall > 0 {
+			usage := float64(cpu-s.lastCPU) / (float64(wall) * float64(runtime.GOMAXPROCS(0)))
+			if usage > s.cfg.MaxCPU {
+				overloaded = append(overloaded, "cpu")
+			}
+		}
+		s.lastCPU, s.lastWall = cpu, now
+	}
+	if s.cfg.MaxGoroutines > 0 && runtime.NumGoroutine() > s.cfg.MaxGoroutines {
+		overloaded = append(overloaded, "goroutines")
+	}
+	for _, g := range s.cfg.Gauges {
+		if g.Value() > g.Threshold {
+			overloaded = append(overloaded, g.Name)
+		}
+	}
+
+	prev := s.Fraction()
+	next := min(prev+s.cfg.Increase, 1)
+	if next > 1-1e-9 {
+		next = 1 // don't stop a hair short of full rate
+	}
+	if len(overloaded) > 0 {
+		next = max(prev*s.cfg.Decrease, s.cfg.MinFraction)
+	}
+	if next == prev {
+		return
+	}
+	s.fraction.Store(math.Float64bits(next))
+	if s.cfg.OnChange != nil {
+		s.cfg.OnChange(next, overloaded)
+	}
+}
+
+// throttle applies the warm-up and adaptive rate of key, the priority
+// reserve and load shedding to a request costing n that the algorithm
+// allowed with state st, and returns why it was denied, if it was. The
+// rate fractions share one count of what the key consumed; the priority
+// reserve caps its fill level. Must be called with l.mu held.
+func (l *Limiter) throttle(ctx context.Context, key string, n int, st *algorithm.State, now time.Time) (bool, denial) {
+	var c consumption
+	fraction := 1.0
+	if l.warmup != nil {
+		fraction = l.warmupFraction(ctx, key, now)
+		if !l.scaled(ctx, key, n, st, fraction, &c, now) {
+			l.opts.metrics.IncCounter(metrics.WarmupDenied, l.labels)
+			return false, deniedByLimit
+		}
+	}
+	if l.adaptive != nil {
+		fraction *= l.adaptive.fraction(key, now)
+		if !l.scaled(ctx, key, n, st, fraction, &c, now) {
+			l.opts.metrics.IncCounter(metrics.AdaptiveDenied, l.labels)
+			return false, deniedByLimit
+		}
+	}
+	if l.opts.priority != nil && !l.capped(ctx, key, n, st, l.unreserved(ctx)) {
+		l.uncount(ctx, n, &c, now)
+		l.opts.metrics.IncCounter(metrics.PriorityDenied, l.labels)
+		return false, deniedByPriority
+	}
+	if l.opts.shedder != nil && !l.scaled(ctx, key, n, st, fraction*l.opts.shedder.Fraction(), &c, now) {
+		l.opts.metrics.IncCounter(metrics.LoadShed, l.labels)
+		return false, deniedByShed
+	}
+	return true, deniedByLimit
+}
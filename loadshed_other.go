@@ -0,0 +1,11 @@
+//go:build !unix
+
+package flexlimit
+
+import "time"
+
+// processCPU reports that the CPU time of the process can't be read on
+// this platform.
+func processCPU() (cpu time.Duration, ok bool) {
+	return 0, false
+}
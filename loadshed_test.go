@@ -0,0 +1,54 @@
+package flexlimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Vipul984/flexlimit/clock"
+)
+
+func TestLoadShedderLowersSustainedRate(t *testing.T) {
+	shedder, err := NewLoadShedder(LoadShedConfig{
+		Gauges:      []Gauge{{Name: "queue", Value: func() float64 { return 1 }, Threshold: 0}},
+		Interval:    time.Millisecond,
+		Decrease:    0.5,
+		MinFraction: 0.1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shedder.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for shedder.Fraction() > 0.1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("fraction stuck at %v", shedder.Fraction())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, algo := range []AlgorithmType{TokenBucket, LeakyBucket, FixedWindow, SlidingWindow} {
+		t.Run(string(algo), func(t *testing.T) {
+			clk := clock.NewMock()
+			l, err := New(100, time.Minute,
+				WithAlgorithm(algo),
+				WithBurst(100),
+				WithClock(clk),
+				WithLoadShedder(shedder),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+
+			// Several keys, so the rate is lowered across all of them
+			total := 0
+			for _, key := range []string{"a", "b", "c"} {
+				total += drive(t, l, clk, key, 100, 10)
+			}
+			if total > 330 {
+				t.Errorf("allowed %d of 3000 requests while shedding to a tenth, want at most 330", total)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package flexlimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+// WithLocalCache keeps a local replica of the hot keys of the storage
+// passed to WithStorage, so requests are decided without a round trip to
+// Redis: each key's state is read at most once per maxStaleness, and what
+// requests consume is written back in the background every
+// maxStaleness / 2 (see storage.Cached). Failed writes are logged as
+// warnings (see WithLogger) and retried.
+//
+// Instances sharing the storage then don't see each other's requests for
+// up to 1.5 * maxStaleness, and may together allow more than the limit by
+// what they admit in that time; use it where shaving the storage's
+// latency off every request is worth that. The token bucket and fixed
+// window algorithms benefit; sliding window logs are not cached, and
+// still take a round trip per request. The limiter's own in-memory store
+// is never cached.
+//
+// Example:
+//
+//	limiter, err := flexlimit.New(1000, time.Second,
+//	    flexlimit.WithStorage(redisStore),
+//	    flexlimit.WithLocalCache(50*time.Millisecond),
+//	)
+//
+// Default: 0 (every request goes to the storage)
+func WithLocalCache(maxStaleness time.Duration) Option {
+	return func(o *Options) {
+		o.cacheStaleness = maxStaleness
+	}
+}
+
+// cached returns s behind a local replica for WithLocalCache. Closing it
+// flushes the replica but leaves s open.
+func (l *Limiter) cached(s storage.Storage) storage.Storage {
+	return storage.NewCached(unownedStorage{s}, storage.CacheConfig{
+		MaxStaleness: l.opts.cacheStaleness,
+		MaxKeys:      l.maxKeysFor(),
+		Clock:        l.clock,
+		OnSyncError: func(err error) {
+			l.warn(context.Background(), "flexlimit: local cache sync failed", "error", err)
+		},
+	})
+}
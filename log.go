@@ -0,0 +1,97 @@
+package flexlimit
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+// warningsPerSecond caps the warnings logged on the request path, so an
+// outage of the storage doesn't flood the logs with one line per request.
+const warningsPerSecond = 10
+
+// WithLogger sets the logger receiving the limiter's operational events,
+// so they aren't silently swallowed:
+//
+//   - Warn: requests decided by the fallback strategy because of a
+//     storage error, self-limits kicking in (see WithSelfLimits), keys
+//     evicted from a full in-memory store, corrupt state repaired (see
+//     WithAutoRepair), and idle keys that failed to decay (see WithDecay).
+//     These happen on the request path and are logged at most 10 times
+//     per second, so a metrics collector remains the way to count them
+//   - Warn: the switch to local memory when the storage fails with the
+//     LocalMemory fallback strategy; Info: the switch back
+//   - Info: limits changed by SetLimit, UpdateConfig or a regional
+//     reconciliation (see WithRegions), and storage migrations; Warn:
+//     failed reconciliations
+//   - Debug: keys expiring from an in-memory store
+//
+// Keys are logged as the limiter stores them, hashed with
+// WithKeyHashing. Warnings caused by a request carry the trace_id and
+// span_id of its trace context, if any (see ContextWithTrace).
+//
+// Example:
+//
+//	logger := slog.New(slog.NewJSONHandler(os.Stderr, nil))
+//	limiter, err := flexlimit.New(100, time.Minute,
+//	    flexlimit.WithStorage(redisStore),
+//	    flexlimit.WithLogger(logger.With("limiter", "api")),
+//	)
+//
+// Default: nothing is logged
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *Options) {
+		o.logger = logger
+	}
+}
+
+// warn logs a warning of the request path of ctx, unless more than
+// warningsPerSecond were logged in the last second.
+func (l *Limiter) warn(ctx context.Context, msg string, args ...any) {
+	if !l.logger.Enabled(ctx, slog.LevelWarn) || !l.logGate.allow(l.clock.Now()) {
+		return
+	}
+	l.logger.Log(ctx, slog.LevelWarn, msg, traceArgs(ctx, args)...)
+}
+
+// traceArgs appends the trace_id and span_id of the trace context of ctx,
+// if it carries one, to the log arguments args.
+func traceArgs(ctx context.Context, args []any) []any {
+	tc, ok := TraceFromContext(ctx)
+	if !ok {
+		return args
+	}
+	args = append(args, "trace_id", tc.TraceID)
+	if tc.SpanID != "" {
+		args = append(args, "span_id", tc.SpanID)
+	}
+	return args
+}
+
+// failedOver reports that the local fallback took over from the storage
+// after the operation of ctx failed with err.
+func (l *Limiter) failedOver(ctx context.Context, err error) {
+	l.logger.Log(ctx, slog.LevelWarn, "flexlimit: storage failed, switching to local memory", traceArgs(ctx, []any{"error", err})...)
+	l.fallbackActivated(err)
+}
+
+// recovered reports that the storage is back after a failover.
+func (l *Limiter) recovered() {
+	l.logger.Info("flexlimit: storage recovered, switching back from local memory")
+}
+
+// logEviction logs a key dropped by an in-memory store.
+func (l *Limiter) logEviction(key string, reason storage.EvictReason) {
+	if reason == storage.EvictCapacity {
+		l.warn(context.Background(), "flexlimit: key evicted from full memory store", "key", key)
+		return
+	}
+	l.logger.Debug("flexlimit: key expired", "key", key)
+}
+
+// logLimitChange logs a change of the limiter's rate or window.
+func (l *Limiter) logLimitChange(msg string, rate int, window time.Duration, algorithm string) {
+	l.logger.Info(msg, "rate", rate, "window", window, "algorithm", algorithm)
+}
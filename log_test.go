@@ -0,0 +1,86 @@
+package flexlimit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+// downStore is a memory store whose state operations all fail.
+type downStore struct {
+	storage.Storage
+}
+
+var errDown = errors.New("storage down")
+
+func (downStore) Get(context.Context, string) (*storage.State, error) {
+	return nil, errDown
+}
+
+func (downStore) Set(context.Context, string, *storage.State, time.Duration) error {
+	return errDown
+}
+
+func (downStore) Incr(context.Context, string, int64, time.Duration) (int64, error) {
+	return 0, errDown
+}
+
+func TestWarnCarriesTrace(t *testing.T) {
+	tc := TraceContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7"}
+
+	for _, strategy := range []FallbackStrategy{AllowAll, LocalMemory} {
+		t.Run(string(strategy), func(t *testing.T) {
+			var buf bytes.Buffer
+			l, err := New(10, time.Minute,
+				WithAlgorithm(FixedWindow),
+				WithStorage(downStore{storage.NewMemory(storage.Config{})}),
+				WithFallback(strategy),
+				WithLogger(slog.New(slog.NewJSONHandler(&buf, nil))),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+
+			if allowed, _ := l.Allow(ContextWithTrace(context.Background(), tc), "k"); !allowed {
+				t.Fatal("request denied, want it allowed by the fallback strategy")
+			}
+
+			var entry struct {
+				Msg     string `json:"msg"`
+				TraceID string `json:"trace_id"`
+				SpanID  string `json:"span_id"`
+			}
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("log = %q: %v", buf.String(), err)
+			}
+			if entry.TraceID != tc.TraceID || entry.SpanID != tc.SpanID {
+				t.Errorf("%q logged trace %q span %q, want %q and %q", entry.Msg, entry.TraceID, entry.SpanID, tc.TraceID, tc.SpanID)
+			}
+		})
+	}
+}
+
+func TestWarnWithoutTrace(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New(10, time.Minute,
+		WithAlgorithm(FixedWindow),
+		WithStorage(downStore{storage.NewMemory(storage.Config{})}),
+		WithLogger(slog.New(slog.NewJSONHandler(&buf, nil))),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	l.Allow(context.Background(), "k")
+	if bytes.Contains(buf.Bytes(), []byte("trace_id")) {
+		t.Errorf("log = %q, want no trace_id without a trace context", buf.String())
+	}
+}
@@ -0,0 +1,273 @@
+package flexlimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MaintenanceWindow changes a limiter's limit at the same time every day.
+// See WithMaintenanceWindows.
+type MaintenanceWindow struct {
+	// Start is when the window opens, as the time since midnight in
+	// Location, such as 2*time.Hour for 02:00, in [0, 24h)
+	Start time.Duration
+
+	// Duration is how long the window stays open, in (0, 24h]. A window
+	// may run past midnight. Required
+	Duration time.Duration
+
+	// Location is the time zone of Start. Default: UTC
+	Location *time.Location
+
+	// Multiplier multiplies the rate, burst and rollover while the window
+	// is open, such as 3 to triple them or 0.5 to halve them. Default: 1
+	Multiplier float64
+
+	// Suspend lifts the limit while the window is open: requests are
+	// allowed as for a key of the allowlist, except keys of the denylist
+	Suspend bool
+}
+
+// WithMaintenanceWindows changes the limit during daily windows, such as
+// a nightly sync that needs three times the export limit from 02:00 to
+// 04:00 UTC, or suspends it.
+//
+// The windows follow the limiter's clock (see WithClock): when one opens
+// or closes, the algorithms are rebuilt with the multiplied limit, as by
+// SetLimit, in the background or by the first request after it, and keys
+// continue from their current usage. While windows overlap, the highest
+// multiplier applies, and the limit is suspended if any of them suspends
+// it. Instances sharing storage switch limits at the same time as long as
+// their clocks agree.
+//
+// Can't be combined with an algorithm instance (see WithAlgorithm).
+//
+// Example:
+//
+//	limiter, err := flexlimit.New(1000, time.Hour,
+//	    flexlimit.WithMaintenanceWindows(flexlimit.MaintenanceWindow{
+//	        Start:      2 * time.Hour,
+//	        Duration:   2 * time.Hour,
+//	        Multiplier: 3,
+//	    }),
+//	)
+func WithMaintenanceWindows(windows ...MaintenanceWindow) Option {
+	return func(o *Options) {
+		o.maintenance = append(o.maintenance, windows...)
+	}
+}
+
+// validateMaintenance checks the windows of WithMaintenanceWindows.
+func validateMaintenance(windows []MaintenanceWindow) error {
+	for _, w := range windows {
+		switch {
+		case w.Start < 0 || w.Start >= 24*time.Hour:
+			return &InvalidConfigError{Field: "maintenance.start", Value: w.Start, Reason: "must be in [0, 24h)"}
+		case w.Duration <= 0 || w.Duration > 24*time.Hour:
+			return &InvalidConfigError{Field: "maintenance.duration", Value: w.Duration, Reason: "must be in (0, 24h]"}
+		case w.Multiplier < 0 || math.IsNaN(w.Multiplier) || math.IsInf(w.Multiplier, 1):
+			return &InvalidConfigError{Field: "maintenance.multiplier", Value: w.Multiplier, Reason: "must be positive"}
+		}
+	}
+	return nil
+}
+
+// maintenance holds the state of the limiter's maintenance windows.
+type maintenance struct {
+	windows []MaintenanceWindow
+
+	multiplier atomic.Uint64 // multiplier of the open windows, as float64 bits
+	suspended  atomic.Bool   // whether an open window suspends the limit
+	due        atomic.Int64  // when a window next opens or closes, in Unix nanoseconds
+
+	mu       sync.Mutex // serializes applyMaintenance
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// newMaintenance returns the maintenance windows with defaults filled in,
+// in the state they are in at now.
+func newMaintenance(windows []MaintenanceWindow, now time.Time) *maintenance {
+	m := &maintenance{
+		windows: make([]MaintenanceWindow, len(windows)),
+		stop:    make(chan struct{}),
+	}
+	for i, w := range windows {
+		if w.Location == nil {
+			w.Location = time.UTC
+		}
+		if w.Multiplier == 0 {
+			w.Multiplier = 1
+		}
+		m.windows[i] = w
+	}
+	multiplier, suspend := m.open(now)
+	m.multiplier.Store(math.Float64bits(multiplier))
+	m.suspended.Store(suspend)
+	m.due.Store(m.next(now).UnixNano())
+	return m
+}
+
+// opening returns when w opens on the day days after that of t in w's
+// time zone.
+func (w MaintenanceWindow) opening(t time.Time, days int) time.Time {
+	t = t.In(w.Location)
+	return time.Date(t.Year(), t.Month(), t.Day()+days, 0, 0, 0, 0, w.Location).Add(w.Start)
+}
+
+// open returns the multiplier of the windows open at now, 1 if none is,
+// and whether one of them suspends the limit.
+func (m *maintenance) open(now time.Time) (multiplier float64, suspend bool) {
+	multiplier = 1
+	found := false
+	for _, w := range m.windows {
+		// A window open now opened today or, past midnight, yesterday
+		for days := -1; days <= 0; days++ {
+			start := w.opening(now, days)
+			if now.Before(start) || !now.Before(start.Add(w.Duration)) {
+				continue
+			}
+			if !found || w.Multiplier > multiplier {
+				multiplier = w.Multiplier
+			}
+			found = true
+			suspend = suspend || w.Suspend
+		}
+	}
+	return multiplier, suspend
+}
+
+// next returns the first time after now a window opens or closes.
+func (m *maintenance) next(now time.Time) time.Time {
+	var next time.Time
+	for _, w := range m.windows {
+		for days := -1; days <= 1; days++ {
+			start := w.opening(now, days)
+			for _, t := range []time.Time{start, start.Add(w.Duration)} {
+				if t.After(now) && (next.IsZero() || t.Before(next)) {
+					next = t
+				}
+			}
+		}
+	}
+	return next
+}
+
+// current returns the multiplier of the open windows.
+func (m *maintenance) current() float64 {
+	return math.Float64frombits(m.multiplier.Load())
+}
+
+// scale returns n multiplied by the multiplier of the open windows, at
+// least 1. 0, an unset burst or rollover, stays 0.
+func (m *maintenance) scale(n int) int {
+	if n == 0 {
+		return 0
+	}
+	return max(int(math.Round(float64(n)*m.current())), 1)
+}
+
+// suspended reports whether an open maintenance window suspends the
+// limit.
+func (l *Limiter) suspended() bool {
+	return l.maintenance != nil && l.maintenance.suspended.Load()
+}
+
+// startMaintenance applies the windows as they open and close until
+// Close, so the limit changes even while no request comes in.
+func (l *Limiter) startMaintenance() {
+	m := l.maintenance
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		for {
+			now := l.clock.Now()
+			l.applyMaintenance(now)
+			timer := l.clock.NewTimer(time.Unix(0, m.due.Load()).Sub(now))
+			select {
+			case <-m.stop:
+				timer.Stop()
+				return
+			case <-timer.C():
+			}
+		}
+	}()
+}
+
+// stopMaintenance stops applying the windows. It must be called without
+// l.mu held. Calling it more than once is a no-op.
+func (l *Limiter) stopMaintenance() {
+	m := l.maintenance
+	if m == nil {
+		return
+	}
+	m.stopOnce.Do(func() { close(m.stop) })
+	m.wg.Wait()
+}
+
+// maintain applies the windows that opened or closed since they were last
+// applied, so a request never sees the limit of a window that has ended,
+// whenever the background goroutine runs. It must be called without l.mu
+// held.
+func (l *Limiter) maintain() {
+	if l.maintenance != nil {
+		l.applyMaintenance(l.clock.Now())
+	}
+}
+
+// applyMaintenance puts the limiter in the state of the windows open at
+// now, if one opened or closed since they were last applied, rebuilding
+// the algorithms if the multiplier changed. It must be called without
+// l.mu held.
+func (l *Limiter) applyMaintenance(now time.Time) {
+	m := l.maintenance
+	if now.UnixNano() < m.due.Load() {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if now.UnixNano() < m.due.Load() {
+		return
+	}
+	defer m.due.Store(m.next(now).UnixNano())
+
+	multiplier, suspend := m.open(now)
+	if m.suspended.Swap(suspend) != suspend {
+		l.logger.Info("flexlimit: maintenance window changed the suspension of the limit", "suspended", suspend)
+	}
+	if multiplier == m.current() {
+		return
+	}
+
+	l.migrateMu.Lock()
+	defer l.migrateMu.Unlock()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed.Load() {
+		return
+	}
+
+	prev := m.multiplier.Swap(math.Float64bits(multiplier))
+	next := *l.be
+	if err := l.initAlgorithms(&next, l.rate); err != nil {
+		m.multiplier.Store(prev)
+		l.warn(context.Background(), "flexlimit: applying maintenance window failed", "multiplier", multiplier, "error", err)
+		return
+	}
+
+	old := l.be
+	l.be = &next
+	l.detached.Wait()
+	l.logger.Info("flexlimit: maintenance window changed the limit", "multiplier", multiplier, "rate", m.scale(l.rate))
+	if err := old.closeAlgorithms(); err != nil {
+		l.warn(context.Background(), "flexlimit: closing algorithms failed", "error", err)
+	}
+}
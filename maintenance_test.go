@@ -0,0 +1,112 @@
+package flexlimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Vipul984/flexlimit/clock"
+)
+
+// allowed returns how many of n requests for key l allows.
+func allowed(t *testing.T, l *Limiter, key string, n int) int {
+	t.Helper()
+	count := 0
+	for range n {
+		ok, err := l.Allow(context.Background(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			count++
+		}
+	}
+	return count
+}
+
+func TestMaintenanceWindowMultiplier(t *testing.T) {
+	clk := clock.NewMockAt(time.Date(2025, 1, 1, 1, 0, 0, 0, time.UTC))
+	l, err := New(10, time.Hour,
+		WithAlgorithm(FixedWindow),
+		WithClock(clk),
+		WithMaintenanceWindows(MaintenanceWindow{
+			Start:      2 * time.Hour,
+			Duration:   2 * time.Hour,
+			Multiplier: 3,
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for _, step := range []struct {
+		at   string
+		want int
+	}{
+		{"01:00", 10},
+		{"02:00", 30},
+		{"03:00", 30},
+		{"04:00", 10},
+	} {
+		if got := allowed(t, l, "export", 100); got != step.want {
+			t.Errorf("at %s allowed %d, want %d", step.at, got, step.want)
+		}
+		clk.Advance(time.Hour)
+	}
+
+	// The window opens again the next night
+	clk.Advance(21 * time.Hour)
+	if got := allowed(t, l, "export", 100); got != 30 {
+		t.Errorf("the next night allowed %d, want 30", got)
+	}
+}
+
+func TestMaintenanceWindowSuspend(t *testing.T) {
+	// The window runs past midnight, and the limiter starts inside it
+	clk := clock.NewMockAt(time.Date(2025, 1, 1, 23, 30, 0, 0, time.UTC))
+	denylist, err := NewKeyList("blocked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := New(10, time.Hour,
+		WithAlgorithm(FixedWindow),
+		WithClock(clk),
+		WithDenylist(denylist),
+		WithMaintenanceWindows(MaintenanceWindow{
+			Start:    23 * time.Hour,
+			Duration: time.Hour,
+			Suspend:  true,
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if got := allowed(t, l, "k", 100); got != 100 {
+		t.Errorf("suspended limit allowed %d, want all 100", got)
+	}
+	if got := allowed(t, l, "blocked", 1); got != 0 {
+		t.Error("suspended limit allowed a key of the denylist")
+	}
+
+	clk.Advance(time.Hour)
+	if got := allowed(t, l, "k", 100); got != 10 {
+		t.Errorf("after the window allowed %d, want 10", got)
+	}
+}
+
+func TestMaintenanceWindowValidation(t *testing.T) {
+	for _, w := range []MaintenanceWindow{
+		{Start: -time.Hour, Duration: time.Hour},
+		{Start: 24 * time.Hour, Duration: time.Hour},
+		{Start: time.Hour},
+		{Start: time.Hour, Duration: 25 * time.Hour},
+		{Start: time.Hour, Duration: time.Hour, Multiplier: -1},
+	} {
+		if _, err := New(10, time.Hour, WithMaintenanceWindows(w)); err == nil {
+			t.Errorf("New accepted maintenance window %+v", w)
+		}
+	}
+}
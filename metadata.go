@@ -0,0 +1,61 @@
+package flexlimit
+
+import "context"
+
+// AllowOptions describes one request of an AllowWithInfo call.
+type AllowOptions struct {
+	// Cost is the number of tokens the request costs. Default: 1
+	Cost int
+
+	// Metadata is passed to the OnAllow and OnLimit callbacks in
+	// LimitInfo.Metadata, such as a request ID, the user's tier or a
+	// trace ID. It is not used for limiting and must not be modified
+	// while callbacks may read it
+	Metadata map[string]interface{}
+}
+
+// AllowWithInfo is AllowN with per-request details: it reports whether a
+// request costing opts.Cost is allowed for key, and hands opts.Metadata to
+// the OnAllow or OnLimit callback deciding it, so handlers get the
+// request's context without globals.
+//
+// Returns an *InvalidConfigError if opts.Cost is negative, and the errors
+// of AllowN otherwise.
+//
+// Example:
+//
+//	allowed, err := limiter.AllowWithInfo(ctx, "user:"+userID, flexlimit.AllowOptions{
+//	    Metadata: map[string]interface{}{"request_id": reqID, "tier": user.Plan},
+//	})
+func (l *Limiter) AllowWithInfo(ctx context.Context, key string, opts AllowOptions) (bool, error) {
+	n := opts.Cost
+	if n == 0 {
+		n = 1
+	}
+	if n < 0 {
+		return false, &InvalidConfigError{Field: "cost", Value: n, Reason: "must be positive"}
+	}
+	if opts.Metadata != nil {
+		ctx = ContextWithMetadata(ctx, opts.Metadata)
+	}
+	return l.AllowN(ctx, key, n)
+}
+
+// metadataKey is the context key for request metadata.
+type metadataKey struct{}
+
+// ContextWithMetadata returns a copy of ctx carrying metadata for the
+// callbacks of the limiters it is passed to, as AllowWithInfo does, for
+// calls without an options argument such as Wait or Composite.Allow.
+func ContextWithMetadata(ctx context.Context, metadata map[string]interface{}) context.Context {
+	return context.WithValue(ctx, metadataKey{}, metadata)
+}
+
+// MetadataFromContext returns the metadata attached to ctx, if any.
+func MetadataFromContext(ctx context.Context) map[string]interface{} {
+	if ctx == nil {
+		return nil
+	}
+	m, _ := ctx.Value(metadataKey{}).(map[string]interface{})
+	return m
+}
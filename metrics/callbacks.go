@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"time"
+)
+
+var _ Collector = Callbacks{}
+
+// Callbacks is a Collector that forwards measurements to plain functions.
+//
+// It is the simplest way to bridge the limiter into an existing metrics
+// library. Either function may be nil.
+//
+// Example:
+//
+//	collector := metrics.Callbacks{
+//	    OnCounter: func(name string, labels metrics.Labels) {
+//	        promCounters.WithLabelValues(name, labels[metrics.LabelAlgorithm]).Inc()
+//	    },
+//	}
+type Callbacks struct {
+	// OnCounter is called for every counter increment
+	OnCounter func(name string, labels Labels)
+
+	// OnDuration is called for every latency observation
+	OnDuration func(name string, d time.Duration, labels Labels)
+}
+
+// IncCounter calls OnCounter if it is set.
+func (c Callbacks) IncCounter(name string, labels Labels) {
+	if c.OnCounter != nil {
+		c.OnCounter(name, labels)
+	}
+}
+
+// ObserveDuration calls OnDuration if it is set.
+func (c Callbacks) ObserveDuration(name string, d time.Duration, labels Labels) {
+	if c.OnDuration != nil {
+		c.OnDuration(name, d, labels)
+	}
+}
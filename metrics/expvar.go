@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var _ Collector = (*Expvar)(nil)
+
+// Expvar is a Collector keeping live statistics in expvar variables, so
+// operators can inspect a limiter through /debug/vars, or through
+// Handler, without a Prometheus setup.
+//
+// Counters are kept by series, the metric name followed by its labels as
+// in `flexlimit_requests_denied_total{algorithm="token_bucket"}`, under
+// "counters". Latencies are summarized by series under "durations", with
+// their count and their total, mean and maximum in seconds. Limiters
+// sharing an Expvar add up their measurements; give each its own to tell
+// them apart.
+//
+// Example:
+//
+//	collector := metrics.NewExpvar("flexlimit")
+//	limiter, err := flexlimit.New(100, time.Minute, flexlimit.WithMetrics(collector))
+//	if err != nil {
+//	    return err
+//	}
+//	http.Handle("/debug/ratelimit", collector.Handler())
+type Expvar struct {
+	vars      *expvar.Map
+	counters  *expvar.Map
+	durations *expvar.Map
+
+	mu sync.Mutex // serializes the creation of duration summaries
+}
+
+// NewExpvar returns an Expvar publishing its statistics as the expvar
+// variable name. Like expvar.Publish, it panics if name is already
+// published. With an empty name the statistics are only served by
+// Handler.
+func NewExpvar(name string) *Expvar {
+	e := &Expvar{
+		vars:      new(expvar.Map).Init(),
+		counters:  new(expvar.Map).Init(),
+		durations: new(expvar.Map).Init(),
+	}
+	e.vars.Set("counters", e.counters)
+	e.vars.Set("durations", e.durations)
+	if name != "" {
+		expvar.Publish(name, e.vars)
+	}
+	return e
+}
+
+// IncCounter increments the counter of the series.
+func (e *Expvar) IncCounter(name string, labels Labels) {
+	e.counters.Add(series(name, labels), 1)
+}
+
+// ObserveDuration adds d to the latency summary of the series.
+func (e *Expvar) ObserveDuration(name string, d time.Duration, labels Labels) {
+	key := series(name, labels)
+	s, ok := e.durations.Get(key).(*durationSummary)
+	if !ok {
+		e.mu.Lock()
+		if s, ok = e.durations.Get(key).(*durationSummary); !ok {
+			s = new(durationSummary)
+			e.durations.Set(key, s)
+		}
+		e.mu.Unlock()
+	}
+	s.observe(d)
+}
+
+// Counter returns the value of the counter of a series, or 0 if it was
+// never incremented.
+func (e *Expvar) Counter(name string, labels Labels) int64 {
+	if v, ok := e.counters.Get(series(name, labels)).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+// Handler returns an http.Handler serving the statistics as JSON, the
+// same document /debug/vars shows under the Expvar's name.
+func (e *Expvar) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		fmt.Fprintln(w, e.vars.String())
+	})
+}
+
+// String returns the statistics as JSON, implementing expvar.Var.
+func (e *Expvar) String() string {
+	return e.vars.String()
+}
+
+// series returns the name of the series of a measurement: name followed
+// by its labels, sorted.
+func series(name string, labels Labels) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	names := make([]string, 0, len(labels))
+	for label := range labels {
+		names = append(names, label)
+	}
+	slices.Sort(names)
+
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i, label := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", label, labels[label])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// durationSummary summarizes the latencies of a series.
+type durationSummary struct {
+	count atomic.Int64
+	total atomic.Int64 // nanoseconds
+	max   atomic.Int64 // nanoseconds
+}
+
+// observe adds d to the summary.
+func (s *durationSummary) observe(d time.Duration) {
+	s.count.Add(1)
+	s.total.Add(int64(d))
+	for {
+		prev := s.max.Load()
+		if int64(d) <= prev || s.max.CompareAndSwap(prev, int64(d)) {
+			return
+		}
+	}
+}
+
+// String returns the summary as JSON, implementing expvar.Var.
+func (s *durationSummary) String() string {
+	count := s.count.Load()
+	total := time.Duration(s.total.Load())
+	mean := 0.0
+	if count > 0 {
+		mean = total.Seconds() / float64(count)
+	}
+	return fmt.Sprintf(`{"count": %d, "total_seconds": %s, "mean_seconds": %s, "max_seconds": %s}`,
+		count, seconds(total.Seconds()), seconds(mean), seconds(time.Duration(s.max.Load()).Seconds()))
+}
+
+// seconds formats a number of seconds as a JSON number.
+func seconds(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
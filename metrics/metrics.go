@@ -0,0 +1,164 @@
+// Package metrics defines the observability hooks used by the rate limiter.
+//
+// The limiter reports what it does through a Collector: every decision,
+// storage failure and fallback activation becomes a counter increment or a
+// latency observation. Collector is deliberately small so it can be adapted
+// to Prometheus, OpenTelemetry, StatsD or plain callbacks without pulling
+// any of those dependencies into flexlimit itself.
+package metrics
+
+import (
+	"context"
+	"time"
+)
+
+// Collector receives measurements from the rate limiter.
+//
+// Implementations must be safe for concurrent use and should not block:
+// they are called on the request hot path.
+type Collector interface {
+	// IncCounter increments the named counter by one.
+	IncCounter(name string, labels Labels)
+
+	// ObserveDuration records a latency sample for the named histogram.
+	ObserveDuration(name string, d time.Duration, labels Labels)
+}
+
+// Flusher is implemented by collectors that buffer measurements, such as
+// a StatsD client batching packets. Limiter.Shutdown calls Flush so
+// nothing measured before shutting down is lost.
+type Flusher interface {
+	// Flush sends the buffered measurements, within ctx.
+	Flush(ctx context.Context) error
+}
+
+// Labels are the dimensions attached to a measurement.
+//
+// Labels never contain rate limit keys, to keep cardinality bounded.
+type Labels map[string]string
+
+// Metric names reported by the limiter.
+const (
+	// RequestsAllowed counts allowed requests.
+	// Labels: algorithm
+	RequestsAllowed = "flexlimit_requests_allowed_total"
+
+	// RequestsDenied counts denied requests.
+	// Labels: algorithm
+	RequestsDenied = "flexlimit_requests_denied_total"
+
+	// StorageErrors counts failed storage operations.
+	// Labels: algorithm
+	StorageErrors = "flexlimit_storage_errors_total"
+
+	// FallbackActivations counts decisions made by the fallback strategy
+	// instead of the algorithm.
+	// Labels: strategy
+	FallbackActivations = "flexlimit_fallback_activations_total"
+
+	// StateRepairs counts keys reset because their stored state was corrupt.
+	// Labels: algorithm
+	StateRepairs = "flexlimit_state_repairs_total"
+
+	// GraceAllowed counts requests over the limit that were allowed
+	// because their key was in a grace period.
+	// Labels: algorithm
+	GraceAllowed = "flexlimit_grace_allowed_total"
+
+	// ShadowAllowed counts requests over the limit that were allowed
+	// because the limiter is in shadow mode.
+	// Labels: algorithm
+	ShadowAllowed = "flexlimit_shadow_allowed_total"
+
+	// SelfLimited counts operations skipped because the limiter reached
+	// one of its own resource limits (see flexlimit.WithSelfLimits).
+	// Labels: algorithm, resource ("storage_ops" or "callbacks")
+	SelfLimited = "flexlimit_self_limited_total"
+
+	// DeadlineSoft counts requests decided from cached local state
+	// because storage passed the soft deadline (see
+	// flexlimit.WithDeadlines).
+	// Labels: algorithm
+	DeadlineSoft = "flexlimit_deadline_soft_total"
+
+	// DeadlineHard counts requests left to the fallback strategy because
+	// storage passed the hard deadline.
+	// Labels: algorithm
+	DeadlineHard = "flexlimit_deadline_hard_total"
+
+	// AdaptiveDenied counts requests the algorithm allowed but that were
+	// denied because their key's rate was lowered by reported failures
+	// (see flexlimit.WithAdaptive).
+	// Labels: algorithm
+	AdaptiveDenied = "flexlimit_adaptive_denied_total"
+
+	// WarmupDenied counts requests the algorithm allowed but that were
+	// denied because their key is new and still ramping up to the full
+	// rate (see flexlimit.WithWarmup).
+	// Labels: algorithm
+	WarmupDenied = "flexlimit_warmup_denied_total"
+
+	// PriorityDenied counts requests the algorithm allowed but that were
+	// denied to keep the rest of their key's limit for higher priorities
+	// (see flexlimit.WithPriorityReserve).
+	// Labels: algorithm
+	PriorityDenied = "flexlimit_priority_denied_total"
+
+	// LoadShed counts requests the algorithm allowed but that were denied
+	// because the process is overloaded (see flexlimit.WithLoadShedder).
+	// Labels: algorithm
+	LoadShed = "flexlimit_load_shed_total"
+
+	// Bypassed counts requests allowed without consulting the algorithm
+	// because their key is exempt (see flexlimit.WithAllowlist).
+	// Labels: algorithm
+	Bypassed = "flexlimit_bypassed_total"
+
+	// Blocked counts requests denied without consulting the algorithm
+	// because their key is blocked (see flexlimit.WithDenylist).
+	// Labels: algorithm
+	Blocked = "flexlimit_blocked_total"
+
+	// TarpitDelayed counts requests over the limit that Wait held and
+	// then let through (see flexlimit.WithTarpit).
+	// Labels: algorithm
+	TarpitDelayed = "flexlimit_tarpit_delayed_total"
+
+	// KeysDecayed counts keys whose limits were relaxed after they had
+	// been idle (see flexlimit.WithDecay).
+	// Labels: algorithm
+	KeysDecayed = "flexlimit_keys_decayed_total"
+
+	// KeysEvicted counts keys dropped by an in-memory store of the
+	// limiter, because their state expired or to make room for others.
+	// Labels: algorithm, reason ("expired" or "capacity")
+	KeysEvicted = "flexlimit_keys_evicted_total"
+
+	// EventsDropped counts events not delivered by Limiter.Events because
+	// its receiver fell behind.
+	// Labels: algorithm
+	EventsDropped = "flexlimit_events_dropped_total"
+
+	// DecisionDuration measures how long each Allow call took.
+	// Labels: algorithm
+	DecisionDuration = "flexlimit_decision_duration_seconds"
+)
+
+// Label names used by the limiter.
+const (
+	LabelAlgorithm = "algorithm"
+	LabelStrategy  = "strategy"
+	LabelResource  = "resource"
+	LabelReason    = "reason"
+)
+
+// Nop is a Collector that discards all measurements.
+//
+// It is the default when no collector is configured.
+type Nop struct{}
+
+// IncCounter does nothing.
+func (Nop) IncCounter(string, Labels) {}
+
+// ObserveDuration does nothing.
+func (Nop) ObserveDuration(string, time.Duration, Labels) {}
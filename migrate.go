@@ -0,0 +1,121 @@
+package flexlimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+// migrateBatchSize is how many keys are copied per GetMulti/SetMulti pair.
+const migrateBatchSize = 500
+
+// MigrateStorage copies every key's state from the limiter's current
+// storage to dst and then switches the limiter over to dst.
+//
+// This is the "hybrid approach" in practice: start with in-memory storage
+// and move to Redis when you scale out, without resetting everyone's
+// counters. Requests keep being served from the current storage while the
+// copy runs; decisions made during the copy may not be reflected in dst,
+// so a key can briefly get slightly more capacity than its limit.
+//
+// Ownership follows WithStorage: dst is not closed by the limiter. The old
+// storage is closed only if the limiter created it.
+//
+// If the copy fails or ctx is canceled, the limiter keeps using its current
+// storage and dst may hold a partial copy.
+//
+// Example:
+//
+//	redisStore, err := redis.New(storage.Config{RedisAddr: "redis:6379"})
+//	if err != nil {
+//	    return err
+//	}
+//	if err := limiter.MigrateStorage(ctx, redisStore); err != nil {
+//	    return err
+//	}
+func (l *Limiter) MigrateStorage(ctx context.Context, dst storage.Storage) error {
+	if l.closed.Load() {
+		return ErrLimiterClosed
+	}
+	if dst == nil {
+		return &InvalidConfigError{Field: "storage", Value: dst, Reason: "must not be nil"}
+	}
+	if err := ctx.Err(); err != nil {
+		return wrapContextError(err)
+	}
+
+	// Only one migration at a time, so the source can't be closed under us.
+	l.migrateMu.Lock()
+	defer l.migrateMu.Unlock()
+
+	next, err := l.newBackend(dst, nil)
+	if err != nil {
+		return err
+	}
+
+	l.mu.RLock()
+	src := l.be.store
+	l.mu.RUnlock()
+
+	if err := copyStates(ctx, src, l.namespaced(dst), l.stateTTL()); err != nil {
+		next.close()
+		return contextOr(ctx, err)
+	}
+
+	l.mu.Lock()
+	if l.closed.Load() {
+		l.mu.Unlock()
+		next.close()
+		return ErrLimiterClosed
+	}
+	prev := l.be
+	l.be = next
+	l.detached.Wait()
+	l.mu.Unlock()
+
+	l.logger.Info("flexlimit: storage migrated")
+	return prev.close()
+}
+
+// copyStates copies all keys from src to dst in batches.
+func copyStates(ctx context.Context, src, dst storage.Storage, ttl time.Duration) error {
+	keys, err := src.Keys(ctx, "*")
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(keys); start += migrateBatchSize {
+		end := min(start+migrateBatchSize, len(keys))
+		batch := keys[start:end]
+
+		states, err := src.GetMulti(ctx, batch)
+		if err != nil {
+			return err
+		}
+
+		found := make(map[string]*storage.State, len(batch))
+		for i, st := range states {
+			if st != nil {
+				found[batch[i]] = st
+			}
+		}
+		if len(found) == 0 {
+			continue
+		}
+
+		if err := dst.SetMulti(ctx, found, ttl); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stateTTL is an upper bound on how long any key's state stays relevant:
+// the time to refill (or drain) a full burst, plus one window.
+func (l *Limiter) stateTTL() time.Duration {
+	capacity := max(l.rate, l.opts.burstSize)
+	windows := (capacity + l.rate - 1) / l.rate
+	return l.window * time.Duration(windows+1)
+}
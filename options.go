@@ -0,0 +1,499 @@
+package flexlimit
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Vipul984/flexlimit/algorithm"
+	"github.com/Vipul984/flexlimit/clock"
+	"github.com/Vipul984/flexlimit/metrics"
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+// Option configures a Limiter.
+//
+// Options are passed to New and applied in order, so later options
+// override earlier ones.
+//
+// Example:
+//
+//	limiter, err := flexlimit.New(100, time.Minute,
+//	    flexlimit.WithAlgorithm(flexlimit.SlidingWindow),
+//	    flexlimit.WithFallback(flexlimit.DenyAll),
+//	)
+type Option func(*Options)
+
+// WithAlgorithm selects the rate limiting algorithm: an AlgorithmType,
+// the name (as a string) of an algorithm registered with
+// algorithm.Register, or an algorithm.Algorithm instance. Any other value
+// makes New fail with an *InvalidConfigError.
+//
+// A registered algorithm is built like the built-in ones, over the
+// limiter's storage and at its current limit. An instance is used as is
+// for every request: it keeps its own state, so WithStorage, the
+// LocalMemory fallback and MigrateStorage don't apply to it, and SetLimit
+// and UpdateConfig fail since its limit is fixed. The limiter does not
+// close it. Refunds, queueing and batching work if the instance
+// implements algorithm.Refunder, algorithm.Queuer or algorithm.Batcher.
+//
+// Example:
+//
+//	limiter, err := flexlimit.New(100, time.Minute,
+//	    flexlimit.WithAlgorithm(flexlimit.SlidingWindow))
+//
+//	// A custom algorithm, registered in an init function
+//	limiter, err := flexlimit.New(100, time.Minute,
+//	    flexlimit.WithAlgorithm("gcra"))
+//
+// Default: TokenBucket
+func WithAlgorithm(algo any) Option {
+	return func(o *Options) {
+		o.algorithmInstance = nil
+		switch a := algo.(type) {
+		case AlgorithmType:
+			o.algorithm = string(a)
+		case string:
+			o.algorithm = a
+		case algorithm.Algorithm:
+			o.algorithm, o.algorithmInstance = customAlgorithm, a
+		default:
+			o.algorithm = fmt.Sprintf("%T", algo)
+		}
+	}
+}
+
+// WithStorage sets the backend used to store rate limit state.
+//
+// The limiter does not close a storage passed here; the caller owns it and
+// may share it between limiters. Default: an in-memory store owned by the
+// limiter.
+func WithStorage(s storage.Storage) Option {
+	return func(o *Options) {
+		o.storage = s
+	}
+}
+
+// WithAdminStorage routes admin operations (Keys, Reset, States and the
+// adminapi and export packages built on them) to s instead of the main
+// storage.
+//
+// s must reach the same data as the storage passed to WithStorage, but
+// through its own connections, so bulk scans during an incident can't
+// exhaust the connection pool that Allow calls depend on. See
+// redis.NewSplit. The limiter does not close s. After MigrateStorage,
+// admin operations use the new storage.
+//
+// Default: admin operations share the main storage
+func WithAdminStorage(s storage.Storage) Option {
+	return func(o *Options) {
+		o.adminStorage = s
+	}
+}
+
+// WithKeyPrefix namespaces the limiter's keys in the storage passed to
+// WithStorage, WithAdminStorage and MigrateStorage: each key is stored as
+// prefix followed by the key (see storage.Prefixed), so several services
+// or limiters can share one backend, such as a Redis cluster, without
+// their keys colliding. Repeated calls add up, so a service-wide prefix
+// and a per-limiter namespace combine.
+//
+// Keys, States, callbacks and metrics still see keys without the prefix.
+// The prefix must not contain the pattern characters "*", "?" or "[". To
+// drop a namespace, see storage.Purge. The prefix has no effect on the
+// in-memory store a limiter creates for itself, which it doesn't share.
+//
+// Example:
+//
+//	service := flexlimit.WithKeyPrefix("svc-a:")
+//	logins, err := flexlimit.New(5, time.Minute,
+//	    flexlimit.WithStorage(shared), service, flexlimit.WithKeyPrefix("login:"))
+//
+// Default: "" (no prefix)
+func WithKeyPrefix(prefix string) Option {
+	return func(o *Options) {
+		o.keyPrefix += prefix
+	}
+}
+
+// WithMetrics sets the collector that receives limiter measurements.
+//
+// Default: metrics.Nop{}
+func WithMetrics(c metrics.Collector) Option {
+	return func(o *Options) {
+		if c == nil {
+			c = metrics.Nop{}
+		}
+		o.metrics = c
+	}
+}
+
+// OnLimit registers a callback invoked every time a request is denied.
+//
+// The callback runs synchronously on the request path and should be fast.
+func OnLimit(fn func(LimitInfo)) Option {
+	return func(o *Options) {
+		o.onLimit = fn
+	}
+}
+
+// OnAllow registers a callback invoked every time a request is allowed.
+//
+// The callback runs synchronously on the request path and should be fast.
+func OnAllow(fn func(LimitInfo)) Option {
+	return func(o *Options) {
+		o.onAllow = fn
+	}
+}
+
+// WithFallback sets how the limiter behaves when storage fails.
+//
+// Default: AllowAll
+func WithFallback(strategy FallbackStrategy) Option {
+	return func(o *Options) {
+		o.fallbackStrategy = string(strategy)
+	}
+}
+
+// OnFallback registers a callback invoked with the storage error whenever
+// the fallback strategy takes over.
+//
+// For LocalMemory, it is called once when the limiter switches to local
+// state, not for every request served locally.
+func OnFallback(fn func(error)) Option {
+	return func(o *Options) {
+		o.onFallback = fn
+	}
+}
+
+// WithLocalFallbackScale divides the rate used while the LocalMemory
+// fallback is active.
+//
+// When N instances share a distributed limit and all fall back to local
+// state at once, each should allow only rate/N to keep the fleet-wide rate
+// close to the configured one. The scaled rate never drops below 1.
+//
+// Default: 1 (each instance keeps the full rate)
+//
+// Example:
+//
+//	flexlimit.New(1000, time.Minute,
+//	    flexlimit.WithStorage(redisStore),
+//	    flexlimit.WithFallback(flexlimit.LocalMemory),
+//	    flexlimit.WithLocalFallbackScale(4), // 4 replicas
+//	)
+func WithLocalFallbackScale(n int) Option {
+	return func(o *Options) {
+		o.localFallbackScale = n
+	}
+}
+
+// WithAutoRepair makes the limiter reset a key whose stored state is
+// corrupt (storage.ErrInvalidState) and retry the request on the fresh
+// state, instead of sending every request for that key to the fallback
+// strategy.
+//
+// Each repair is counted in metrics.StateRepairs and reported to the
+// OnStateRepaired callback. The key loses its usage history, so it
+// briefly gets its full limit back.
+//
+// Default: false
+func WithAutoRepair(enabled bool) Option {
+	return func(o *Options) {
+		o.autoRepair = enabled
+	}
+}
+
+// OnStateRepaired registers a callback invoked after a corrupt key was
+// reset by WithAutoRepair. cause is the error that reported the corruption.
+func OnStateRepaired(fn func(key string, cause error)) Option {
+	return func(o *Options) {
+		o.onRepair = fn
+	}
+}
+
+// WithSlidingWindowBuckets bounds the memory a SlidingWindow limiter uses
+// per key by counting requests in n sub-windows instead of storing the
+// timestamp of every request.
+//
+// The sub-window sliding out of the window is weighted by how much of it
+// still overlaps the window, so the count is off by at most 1/n of the
+// requests in one sub-window. Each key costs n+1 small counters however
+// high the rate, and counting stays atomic across instances sharing
+// storage. 60 buckets for a one-minute window is a good default.
+//
+// Only valid with the SlidingWindow algorithm. Default: 0 (exact log of
+// timestamps)
+//
+// Example:
+//
+//	flexlimit.New(50000, time.Minute,
+//	    flexlimit.WithAlgorithm(flexlimit.SlidingWindow),
+//	    flexlimit.WithSlidingWindowBuckets(60), // one-second precision
+//	)
+func WithSlidingWindowBuckets(n int) Option {
+	return func(o *Options) {
+		o.windowBuckets = n
+	}
+}
+
+// WithQueue turns a LeakyBucket limiter into a queue for Wait.
+//
+// Instead of rejecting requests that overflow the bucket, Wait queues up to
+// n units of them and releases each one when the bucket has drained enough,
+// so callers proceed at exactly the configured rate. Wait returns
+// ErrQueueFull when the queue has no room. Allow still never queues; it
+// rejects requests while others are waiting in the queue.
+//
+// Only valid with the LeakyBucket algorithm. Default: 0 (no queue)
+//
+// Example:
+//
+//	// Smooth writes to the database to 50 per second, with up to 500 waiting
+//	limiter, err := flexlimit.New(50, time.Second,
+//	    flexlimit.WithAlgorithm(flexlimit.LeakyBucket),
+//	    flexlimit.WithQueue(500),
+//	)
+//
+//	if err := limiter.Wait(ctx, "db:writes"); err != nil {
+//	    return err // ErrQueueFull, or the context ended first
+//	}
+//	db.Exec(...)
+func WithQueue(n int) Option {
+	return func(o *Options) {
+		o.queueSize = n
+	}
+}
+
+// WithDrainJitter spreads the releases of a LeakyBucket limiter's keys
+// over up to d, so thousands of keys filled at the same instant don't all
+// release their requests to the protected backend at once. Each key is
+// delayed by its own fixed part of d, derived from the key: requests of
+// one key stay evenly spaced, and the rate is unchanged. Waits in the
+// queue (see WithQueue) and retry times of denied requests are delayed
+// alike.
+//
+// Only valid with the LeakyBucket algorithm. Default: 0 (keys drain in
+// step)
+//
+// Example:
+//
+//	limiter, err := flexlimit.New(50, time.Second,
+//	    flexlimit.WithAlgorithm(flexlimit.LeakyBucket),
+//	    flexlimit.WithQueue(500),
+//	    flexlimit.WithDrainJitter(20*time.Millisecond),
+//	)
+func WithDrainJitter(d time.Duration) Option {
+	return func(o *Options) {
+		o.drainJitter = d
+	}
+}
+
+// WithRollover lets a FixedWindow limiter's keys carry the requests they
+// leave unused in a window over into later ones, up to n on top of the
+// rate, so occasional heavy use is allowed against a quota that was
+// mostly unused, as a token bucket allows bursts. With calendar windows,
+// a daily quota of 1000 and n = 2000 lets unused quota accumulate for up
+// to three days.
+//
+// A key is credited the rate minus what it used in each window, including
+// credit left unused, capped at n. Keys without history, keys after Reset
+// and keys idle for longer than it takes to fill the credit start without
+// credit, so a new key's first window allows the rate. Each key keeps a
+// credit record in the storage besides its counters, and counters outlive
+// their window until the credit would have filled up again.
+//
+// Only valid with the FixedWindow algorithm. Default: 0 (unused requests
+// are lost when the window ends)
+//
+// Example:
+//
+//	limiter, err := flexlimit.NewFromConfig(flexlimit.PerCalendar(1000, flexlimit.CalendarDay, nil),
+//	    flexlimit.WithRollover(2000),
+//	)
+func WithRollover(n int) Option {
+	return func(o *Options) {
+		o.rollover = n
+	}
+}
+
+// WithShadowMode makes the limiter observe without enforcing: requests
+// over the limit are allowed anyway.
+//
+// Decisions are still made and reported as usual, so OnLimit and
+// metrics.RequestsDenied show what enforcement would do, and denied
+// requests are also counted in metrics.ShadowAllowed. Use it to roll out
+// new limits safely, or with WithAdvisor to find them.
+//
+// Default: false
+func WithShadowMode(enabled bool) Option {
+	return func(o *Options) {
+		o.shadow = enabled
+	}
+}
+
+// WithAdvisor records how much each key uses per window so that
+// Limiter.Suggest can suggest a limit grounded in observed traffic: the
+// cfg.Percentile of per-key usage, times cfg.SafetyFactor.
+//
+// Usage is recorded for every request, allowed or not, so it reflects
+// demand rather than what the current limit lets through. Combined with
+// WithShadowMode, a limiter can observe production traffic for
+// cfg.Period before any limit is enforced. Suggestions are also served by
+// the adminapi package.
+//
+// Example:
+//
+//	limiter, err := flexlimit.New(1_000_000, time.Minute,
+//	    flexlimit.WithShadowMode(true),
+//	    flexlimit.WithAdvisor(flexlimit.AdvisorConfig{Period: 7 * 24 * time.Hour}),
+//	)
+//	...
+//	if s, _ := limiter.Suggest(); s.Ready {
+//	    log.Printf("suggested limit: %d per %s", s.Rate, s.Window)
+//	}
+func WithAdvisor(cfg AdvisorConfig) Option {
+	return func(o *Options) {
+		o.advisor = &cfg
+	}
+}
+
+// WithLifecycle tracks an explicit status for each key (active, warned,
+// limited, banned, archived), so systems downstream can react to changes
+// instead of inferring them from counters. Every change is passed to
+// policy.OnTransition and the current status is reported in State.Status.
+//
+// Statuses are kept in a lifecycle record next to the key's state, in the
+// limiter's storage, so every instance sharing it agrees on them. Each
+// request reads the record to enforce bans, and writes it when the status
+// changes. If the record can't be read, the key is treated as active.
+//
+// Keys are banned by Ban, or automatically after policy.BanAfter denials
+// in a row or within policy.BanWithin, for policy.BanDuration or for
+// escalating penalties (policy.BanEscalation) as a key offends again.
+// Banned requests are denied without being counted; their RetryAfter is
+// the end of the ban. Bans are passed to policy.OnBan and reported in
+// State.BannedUntil. ArchiveIdle archives keys that have been idle for
+// policy.ArchiveAfter.
+//
+// Example:
+//
+//	limiter, err := flexlimit.New(100, time.Minute,
+//	    flexlimit.WithLifecycle(flexlimit.LifecyclePolicy{
+//	        WarnAt:        0.8,
+//	        BanAfter:      50,
+//	        BanWithin:     5 * time.Minute,
+//	        BanEscalation: []time.Duration{time.Minute, 10 * time.Minute, time.Hour},
+//	        OnTransition: func(t flexlimit.Transition) {
+//	            events.Publish("ratelimit."+string(t.To), t.Key, t.Reason)
+//	        },
+//	        OnBan: func(b flexlimit.BanEvent) {
+//	            log.Printf("banned %s until %s (offense %d)", b.Key, b.Until, b.Offenses)
+//	        },
+//	    }),
+//	)
+func WithLifecycle(policy LifecyclePolicy) Option {
+	return func(o *Options) {
+		o.lifecycle = &policy
+	}
+}
+
+// WithGracePeriod delays enforcement for keys that just hit their limit.
+//
+// The first request denied for a key in a window is allowed instead, and
+// so is every request in the following d. Once d has passed, requests over
+// the limit are denied as usual until the window ends; a key gets at most
+// one grace period per window. Requests allowed this way have
+// LimitInfo.Warning set and LimitInfo.EnforceAt telling when enforcement
+// starts, so interactive features can warn users before cutting them off.
+// They are counted in metrics.GraceAllowed.
+//
+// Default: 0 (deny as soon as the limit is reached)
+//
+// Example:
+//
+//	flexlimit.New(30, time.Minute,
+//	    flexlimit.WithGracePeriod(10*time.Second),
+//	    flexlimit.OnAllow(func(info flexlimit.LimitInfo) {
+//	        if info.Warning {
+//	            notifyUser(info.Key, "slow down, limiting starts at", info.EnforceAt)
+//	        }
+//	    }),
+//	)
+func WithGracePeriod(d time.Duration) Option {
+	return func(o *Options) {
+		o.gracePeriod = d
+	}
+}
+
+// WithMaxKeys bounds how many keys the in-memory store tracks. A key is
+// evicted when the bound is reached, keys used only once first (see
+// storage.Memory).
+//
+// Default: 10000
+func WithMaxKeys(n int) Option {
+	return func(o *Options) {
+		o.maxKeys = n
+	}
+}
+
+// WithCleanupInterval sets how often a background goroutine removes
+// expired keys from the in-memory store. 0 disables it; expired keys are
+// then only removed when they are next accessed.
+//
+// Default: 5 minutes
+func WithCleanupInterval(d time.Duration) Option {
+	return func(o *Options) {
+		o.cleanupInterval = d
+	}
+}
+
+// OnKeyEvicted registers a callback invoked when the in-memory store
+// drops a key because it expired or to stay within WithMaxKeys, so
+// applications can log or persist the final state.
+//
+// It is called with the rate limit key and its last stored state, outside
+// the store's lock but possibly from the cleanup goroutine. It is not
+// called for Reset or Close, nor for external storage, which expires keys
+// on its own.
+func OnKeyEvicted(fn func(key string, state *storage.State)) Option {
+	return func(o *Options) {
+		o.onKeyEvicted = fn
+	}
+}
+
+// WithBurst sets the bucket capacity for TokenBucket and LeakyBucket, and
+// the limit of the decayed count for DecayedWindow.
+//
+// For TokenBucket and DecayedWindow, 0 means capacity equals the rate.
+// For LeakyBucket, 0 means no bursts at all.
+//
+// Default: 0
+func WithBurst(n int) Option {
+	return func(o *Options) {
+		o.burstSize = n
+	}
+}
+
+// WithClock sets the time source of the limiter, usually a *clock.Mock in
+// tests. Algorithms, the in-memory store and its cleanup, Wait, WaitN and
+// their retries, tarpits and soft deadlines all run on it, so a test
+// advancing a mock clock sees waits return and keys expire without real
+// sleeps (see clock.Mock.BlockUntil). Storages passed to WithStorage keep
+// their own clocks; give them the same one through their configuration.
+// Context deadlines and storage timeouts stay on real time.
+//
+// Example:
+//
+//	clk := clock.NewMock()
+//	limiter, err := flexlimit.New(1, time.Second, flexlimit.WithClock(clk))
+//	limiter.Allow(ctx, "user")
+//	go limiter.Wait(ctx, "user") // waits for the next token
+//	clk.BlockUntil(1)
+//	clk.Advance(time.Second) // Wait returns
+//
+// Default: the system clock
+func WithClock(c clock.Clock) Option {
+	return func(o *Options) {
+		o.clock = c
+	}
+}
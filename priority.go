@@ -0,0 +1,162 @@
+package flexlimit
+
+import (
+	"context"
+	"strconv"
+	"strings"
+)
+
+// Priority ranks requests for WithPriorityReserve. Requests without a
+// priority are PriorityNormal.
+type Priority int
+
+// Request priorities
+const (
+	PriorityLow      Priority = -1
+	PriorityNormal   Priority = 0
+	PriorityHigh     Priority = 1
+	PriorityCritical Priority = 2
+)
+
+// PriorityMetadataKey is the RequestContext.Metadata key holding a
+// request's priority. See RequestContext.Priority.
+const PriorityMetadataKey = "priority"
+
+// String returns "low", "normal", "high" or "critical", or the number of
+// other priorities.
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	case PriorityCritical:
+		return "critical"
+	}
+	return strconv.Itoa(int(p))
+}
+
+// ParsePriority parses the name of a priority, as returned by
+// Priority.String, case-insensitively.
+func ParsePriority(s string) (Priority, bool) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "low":
+		return PriorityLow, true
+	case "normal", "":
+		return PriorityNormal, true
+	case "high":
+		return PriorityHigh, true
+	case "critical":
+		return PriorityCritical, true
+	}
+	return PriorityNormal, false
+}
+
+// priorityKey is the context key for Priority values.
+type priorityKey struct{}
+
+// ContextWithPriority returns a copy of ctx carrying the priority of the
+// request it belongs to.
+func ContextWithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFromContext returns the priority attached to ctx, or
+// PriorityNormal.
+func PriorityFromContext(ctx context.Context) Priority {
+	if ctx == nil {
+		return PriorityNormal
+	}
+	p, _ := ctx.Value(priorityKey{}).(Priority)
+	return p
+}
+
+// Priority returns the priority in rc.Metadata[PriorityMetadataKey]: a
+// Priority, an int, or a name accepted by ParsePriority. Other values and
+// missing entries are PriorityNormal.
+func (rc RequestContext) Priority() Priority {
+	switch v := rc.Metadata[PriorityMetadataKey].(type) {
+	case Priority:
+		return v
+	case int:
+		return Priority(v)
+	case string:
+		p, _ := ParsePriority(v)
+		return p
+	}
+	return PriorityNormal
+}
+
+// PriorityReserve keeps the last part of every key's limit for important
+// requests. See WithPriorityReserve.
+type PriorityReserve struct {
+	// Reserve is the fraction of the limit kept for requests of
+	// MinPriority and above, in (0, 1). With a rate of 100 and a reserve
+	// of 0.2, requests below MinPriority are denied once a key has used
+	// 80 of its 100 tokens
+	Reserve float64
+
+	// MinPriority is the lowest priority allowed to use the reserve.
+	// Default: PriorityHigh
+	MinPriority Priority
+}
+
+// WithPriorityReserve keeps the last cfg.Reserve of every key's limit for
+// requests of cfg.MinPriority and above, so health checks and paid
+// traffic keep flowing while a key is hammered. A request's priority is
+// read from its context (see ContextWithPriority).
+//
+// A lower-priority request that would take its key into the reserve is
+// denied and its tokens refunded. It is reported to OnLimit with
+// LimitInfo.Preempted set and counted in metrics.PriorityDenied as well as
+// metrics.RequestsDenied. Like shed requests, it doesn't count as a denial
+// for WithGracePeriod or WithLifecycle. AllowMulti decides requests one by
+// one.
+//
+// Example:
+//
+//	limiter, err := flexlimit.New(100, time.Minute,
+//	    flexlimit.WithPriorityReserve(flexlimit.PriorityReserve{Reserve: 0.2}),
+//	)
+//
+//	ctx = flexlimit.ContextWithPriority(ctx, flexlimit.PriorityHigh)
+//	allowed, err := limiter.Allow(ctx, "tenant:acme")
+func WithPriorityReserve(cfg PriorityReserve) Option {
+	return func(o *Options) {
+		if cfg.MinPriority == PriorityNormal {
+			cfg.MinPriority = PriorityHigh
+		}
+		o.priority = &cfg
+	}
+}
+
+// unreserved returns the fraction of the limit a request with ctx may use:
+// 1, or the part outside the priority reserve for requests below its
+// minimum priority.
+func (l *Limiter) unreserved(ctx context.Context) float64 {
+	r := l.opts.priority
+	if r == nil || PriorityFromContext(ctx) >= r.MinPriority {
+		return 1
+	}
+	return 1 - r.Reserve
+}
+
+// denial is why a request within its key's limit was denied.
+type denial uint8
+
+const (
+	// deniedByLimit: the request was allowed, or denied by its key's
+	// limit, or by its adaptive rate
+	deniedByLimit denial = iota
+
+	// deniedByShed: the request was denied by load shedding
+	deniedByShed
+
+	// deniedByPriority: the request was denied by the priority reserve
+	deniedByPriority
+
+	// deniedByList: the request's key is in the denylist
+	deniedByList
+)
@@ -0,0 +1,289 @@
+package flexlimit
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+// RegionConfig configures how a global limit is split across regions. See
+// WithRegions.
+type RegionConfig struct {
+	// Name identifies the limiter in Global, the same in every region.
+	// Required
+	Name string
+
+	// Region is the region of this instance, one of Shares. Required
+	Region string
+
+	// Shares weighs the regions' parts of the limit, as in
+	// {"us": 3, "eu": 2, "ap": 1}. Every region must list the same
+	// shares. Required
+	Shares map[string]float64
+
+	// Global is the storage all regions report their demand to, such as
+	// a cross-region Redis. Only reconciliation uses it. Required
+	Global storage.Storage
+
+	// Interval is how often demand is reported and the shares
+	// reconciled. Default: 10 seconds
+	Interval time.Duration
+
+	// MinShare is the part of its configured share a region keeps however
+	// little demand it reports, in (0, 1], so it can take traffic again
+	// before the next reconciliation. 1 keeps the configured shares.
+	// Default: 0.5
+	MinShare float64
+}
+
+// WithRegions splits the limiter's limit across regions, so every region
+// enforces its part on its own storage and geo-distributed deployments
+// don't need a single cross-region store on the hot path. Pass the global
+// limit to New and a regional store to WithStorage; the region's rate and
+// burst are its share of them, at least 1.
+//
+// A region starts at its configured share. Every Interval, each instance
+// reports the demand it saw, the cost of the requests it decided, to the
+// Global storage in the background, and reads the other regions' reports.
+// The shares then follow demand: a region gets its part of the total
+// demand, but never less than MinShare of its configured share, and the
+// parts are scaled back to add up to the whole limit. Regions without a
+// report for the last interval count as idle. Every region computes the same
+// shares from the same reports, so the limit holds across regions, give
+// or take the requests of one interval while shares change.
+//
+// Each instance of a region reports on its own and their reports are
+// added up, so the instances of a region should share its storage. All
+// regions must use the same Interval, which reports are aligned to. Failed
+// reconciliations are logged as warnings (see WithLogger) and keep the
+// current shares.
+//
+// Can't be combined with an algorithm instance (see WithAlgorithm).
+//
+// Example:
+//
+//	limiter, err := flexlimit.New(10000, time.Minute,
+//	    flexlimit.WithStorage(regionalRedis),
+//	    flexlimit.WithRegions(flexlimit.RegionConfig{
+//	        Name:   "api",
+//	        Region: "eu",
+//	        Shares: map[string]float64{"us": 3, "eu": 2, "ap": 1},
+//	        Global: globalRedis,
+//	    }),
+//	)
+func WithRegions(cfg RegionConfig) Option {
+	return func(o *Options) {
+		o.regions = &cfg
+	}
+}
+
+// regions holds the share of the limit of the limiter's region.
+type regions struct {
+	cfg  RegionConfig
+	base map[string]float64 // configured shares, adding up to 1
+
+	share  atomic.Uint64 // current share, as float64 bits
+	demand atomic.Int64  // cost of the requests decided since the last report
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// newRegions returns the regions of cfg with defaults filled in, at the
+// configured shares.
+func newRegions(cfg RegionConfig) *regions {
+	if cfg.Interval == 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	if cfg.MinShare == 0 {
+		cfg.MinShare = 0.5
+	}
+
+	var total float64
+	for _, s := range cfg.Shares {
+		total += s
+	}
+	r := &regions{
+		cfg:  cfg,
+		base: make(map[string]float64, len(cfg.Shares)),
+		stop: make(chan struct{}),
+	}
+	for region, s := range cfg.Shares {
+		r.base[region] = s / total
+	}
+	r.share.Store(math.Float64bits(r.base[cfg.Region]))
+	return r
+}
+
+// validate checks cfg for WithRegions.
+func (cfg *RegionConfig) validate() error {
+	switch {
+	case cfg.Name == "":
+		return &InvalidConfigError{Field: "regions.name", Value: cfg.Name, Reason: "required"}
+	case cfg.Global == nil:
+		return &InvalidConfigError{Field: "regions.global", Value: nil, Reason: "required"}
+	case cfg.Interval < 0:
+		return &InvalidConfigError{Field: "regions.interval", Value: cfg.Interval, Reason: "cannot be negative"}
+	case cfg.MinShare < 0 || cfg.MinShare > 1:
+		return &InvalidConfigError{Field: "regions.min_share", Value: cfg.MinShare, Reason: "must be in (0, 1]"}
+	}
+	for region, s := range cfg.Shares {
+		if !(s > 0) || math.IsInf(s, 1) {
+			return &InvalidConfigError{Field: "regions.shares", Value: region, Reason: "shares must be positive"}
+		}
+	}
+	if _, ok := cfg.Shares[cfg.Region]; !ok {
+		return &InvalidConfigError{Field: "regions.region", Value: cfg.Region, Reason: "must be one of the shares"}
+	}
+	return nil
+}
+
+// current returns the region's share of the limit.
+func (r *regions) current() float64 {
+	return math.Float64frombits(r.share.Load())
+}
+
+// scale returns the region's part of n, at least 1.
+func (r *regions) scale(n int) int {
+	return max(int(math.Round(float64(n)*r.current())), 1)
+}
+
+// key returns the Global key of the demand reported by region during
+// interval i, counted from the Unix epoch.
+func (r *regions) key(region string, i int64) string {
+	return r.cfg.Name + ":" + region + ":demand:" + strconv.FormatInt(i, 10)
+}
+
+// reconcile reports the demand seen since the last report and returns the
+// region's share for the demand of every region.
+func (r *regions) reconcile(ctx context.Context, now time.Time) (float64, error) {
+	// Each instance reports once per interval, so the reports of the
+	// previous interval are complete in every region
+	interval := now.UnixNano() / int64(r.cfg.Interval)
+
+	demand := r.demand.Swap(0)
+	if _, err := r.cfg.Global.Incr(ctx, r.key(r.cfg.Region, interval), demand, 3*r.cfg.Interval); err != nil {
+		r.demand.Add(demand)
+		return 0, err
+	}
+
+	regions := make([]string, 0, len(r.base))
+	keys := make([]string, 0, len(r.base))
+	for region := range r.base {
+		regions = append(regions, region)
+		keys = append(keys, r.key(region, interval-1))
+	}
+	states, err := r.cfg.Global.GetMulti(ctx, keys)
+	if err != nil {
+		return 0, err
+	}
+
+	demands := make(map[string]float64, len(regions))
+	var total float64
+	for i, region := range regions {
+		if st := states[i]; st != nil && st.Count > 0 {
+			demands[region] = float64(st.Count)
+			total += float64(st.Count)
+		}
+	}
+	return r.shareOf(demands, total), nil
+}
+
+// shareOf returns the region's share for the demand of every region:
+// each region's part of the total demand, at least MinShare of its
+// configured share, scaled so the parts add up to 1. Without demand, the
+// shares are the configured ones.
+func (r *regions) shareOf(demands map[string]float64, total float64) float64 {
+	if total == 0 {
+		return r.base[r.cfg.Region]
+	}
+
+	part := func(region string) float64 {
+		return max(demands[region]/total, r.cfg.MinShare*r.base[region])
+	}
+	var sum float64
+	for region := range r.base {
+		sum += part(region)
+	}
+	return part(r.cfg.Region) / sum
+}
+
+// startRegions reconciles the shares every Interval until Close.
+func (l *Limiter) startRegions() {
+	r := l.regions
+	ticker := l.clock.NewTicker(r.cfg.Interval)
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C():
+				l.reconcileRegions()
+			}
+		}
+	}()
+}
+
+// stopRegions stops reconciling. It must be called without l.mu held.
+// Calling it more than once is a no-op.
+func (l *Limiter) stopRegions() {
+	r := l.regions
+	if r == nil {
+		return
+	}
+	r.stopOnce.Do(func() { close(r.stop) })
+	r.wg.Wait()
+}
+
+// reconcileRegions reconciles the shares once, and rebuilds the
+// algorithms if the region's share changed by more than 1%.
+func (l *Limiter) reconcileRegions() {
+	r := l.regions
+	ctx, cancel := context.WithTimeout(context.Background(), r.cfg.Interval)
+	defer cancel()
+
+	share, err := r.reconcile(ctx, l.clock.Now())
+	if err != nil {
+		l.warn(ctx, "flexlimit: reconciling regional shares failed", "region", r.cfg.Region, "error", err)
+		return
+	}
+	if math.Abs(share-r.current()) <= 0.01*r.current() {
+		return
+	}
+
+	l.migrateMu.Lock()
+	defer l.migrateMu.Unlock()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed.Load() {
+		return
+	}
+
+	prevShare := r.share.Swap(math.Float64bits(share))
+	next := *l.be
+	if err := l.initAlgorithms(&next, l.rate); err != nil {
+		r.share.Store(prevShare)
+		l.warn(context.Background(), "flexlimit: applying regional share failed", "region", r.cfg.Region, "share", share, "error", err)
+		return
+	}
+
+	prev := l.be
+	l.be = &next
+	l.detached.Wait()
+	l.logger.Info("flexlimit: regional share changed", "region", r.cfg.Region, "share", share, "rate", r.scale(l.rate))
+	if err := prev.closeAlgorithms(); err != nil {
+		l.warn(context.Background(), "flexlimit: closing algorithms failed", "error", err)
+	}
+}
@@ -0,0 +1,178 @@
+package flexlimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/Vipul984/flexlimit/metrics"
+)
+
+// UpdateConfig changes the limiter's rate, window, burst and algorithm at
+// runtime, carrying every key's usage over to the new limit.
+//
+// Unlike SetLimit, which keeps each key's usage as it is, UpdateConfig
+// keeps each key's share of its limit: a key that had used 40 of 100
+// tokens has used 80 of 200 after the limit doubles. This works across
+// algorithms, so a limiter can move from FixedWindow to TokenBucket
+// without giving every key a fresh start; a key's usage then restarts
+// its window or refill from the time of the update.
+//
+// Usage is read from the admin storage before the switch and written back
+// after it, key by key, while requests keep being served. Requests made
+// in between may be counted under the old limit only, and with a new
+// algorithm a request may briefly see the old algorithm's state. When
+// instances share storage, each one must be updated; the carried shares
+// are the same whichever does it.
+//
+// A zero cfg.Algorithm keeps the current algorithm. cfg.Calendar and
+// cfg.Location replace the limiter's calendar window, if any, so a Config
+// without a calendar period moves the limiter to windows of cfg.Window.
+// Returns an
+// *InvalidConfigError if cfg is invalid or the limiter runs an algorithm
+// instance (see WithAlgorithm), in which case nothing changes,
+// or the errors of the keys whose usage couldn't be carried over, in
+// which case the new limit applies and those keys keep their state.
+//
+// Example:
+//
+//	err := limiter.UpdateConfig(ctx, flexlimit.PerMinute(200).WithBurst(50))
+func (l *Limiter) UpdateConfig(ctx context.Context, cfg Config) error {
+	if l.closed.Load() {
+		return ErrLimiterClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return wrapContextError(err)
+	}
+	if err := l.fixedLimit(); err != nil {
+		return err
+	}
+
+	// Hold off SetLimit and migrations, which build backends from the
+	// current limit.
+	l.migrateMu.Lock()
+	defer l.migrateMu.Unlock()
+
+	l.mu.RLock()
+	next := *l.opts
+	l.mu.RUnlock()
+
+	next.burstSize = cfg.Burst
+	if cfg.Algorithm != "" {
+		next.algorithm = string(cfg.Algorithm)
+	}
+	next.calendar, next.location = cfg.Calendar, cfg.Location
+	if err := validateOptions(cfg.Rate, cfg.Window, &next); err != nil {
+		return err
+	}
+
+	l.mu.RLock()
+	unchanged := cfg.Rate == l.rate && cfg.Window == l.window &&
+		next.burstSize == l.opts.burstSize && next.algorithm == l.opts.algorithm &&
+		next.calendar == l.opts.calendar && next.location == l.opts.location
+	l.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	shares, err := l.shares(ctx)
+	if err != nil {
+		return err
+	}
+
+	prev, err := l.reconfigure(cfg.Rate, cfg.Window, &next)
+	if err != nil {
+		return err
+	}
+	defer prev.closeAlgorithms()
+
+	l.logLimitChange("flexlimit: configuration updated", cfg.Rate, cfg.Window, next.algorithm)
+	l.emitEvent(Event{Type: EventConfigReloaded, At: l.clock.Now(), Rate: cfg.Rate, Window: cfg.Window, Algorithm: next.algorithm})
+	return l.carry(ctx, prev, shares)
+}
+
+// shares returns the fraction of its limit each key with stored state has
+// used.
+func (l *Limiter) shares(ctx context.Context) (map[string]float64, error) {
+	keys, err := l.Keys(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.RLock()
+	admin := l.be.admin
+	l.mu.RUnlock()
+
+	shares := make(map[string]float64, len(keys))
+	for _, key := range keys {
+		st, err := admin.State(ctx, key)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, wrapContextError(ctx.Err())
+			}
+			continue // no usage to carry
+		}
+		if st.Limit > 0 && st.Current > 0 {
+			shares[key] = min(float64(st.Current)/float64(st.Limit), 1)
+		}
+	}
+	return shares, nil
+}
+
+// reconfigure switches the limiter to new algorithms for rate, window,
+// and the burst, algorithm and calendar window of opts, and returns the
+// previous backend, whose algorithms the caller must close. Must be
+// called with l.migrateMu held.
+func (l *Limiter) reconfigure(rate int, window time.Duration, opts *Options) (*backend, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	prevRate, prevWindow, prevOpts := l.rate, l.window, *l.opts
+	l.rate, l.window = rate, window
+	l.opts.burstSize, l.opts.algorithm = opts.burstSize, opts.algorithm
+	l.opts.calendar, l.opts.location = opts.calendar, opts.location
+
+	next := *l.be
+	if err := l.initAlgorithms(&next, rate); err != nil {
+		l.rate, l.window = prevRate, prevWindow
+		*l.opts = prevOpts
+		return nil, err
+	}
+
+	prev := l.be
+	l.be = &next
+	l.labels = metrics.Labels{metrics.LabelAlgorithm: opts.algorithm}
+	l.detached.Wait()
+	return prev, nil
+}
+
+// carry clears each key's state under the previous backend prev and
+// consumes its share of the new limit.
+func (l *Limiter) carry(ctx context.Context, prev *backend, shares map[string]float64) error {
+	l.mu.RLock()
+	admin := l.be.admin
+	l.mu.RUnlock()
+
+	var errs []error
+	for key, share := range shares {
+		if err := ctx.Err(); err != nil {
+			return errors.Join(append(errs, wrapContextError(err))...)
+		}
+		if err := prev.admin.Reset(ctx, key); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		st, err := admin.State(ctx, key)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if n := int(math.Round(share * float64(st.Limit))); n > 0 {
+			if _, _, err := admin.Allow(ctx, key, n); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
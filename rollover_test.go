@@ -0,0 +1,64 @@
+package flexlimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Vipul984/flexlimit/clock"
+)
+
+func TestRolloverBuildsFromUnusedWindows(t *testing.T) {
+	clk := clock.NewMockAt(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	l, err := New(20, time.Minute,
+		WithAlgorithm(FixedWindow),
+		WithRollover(20),
+		WithClock(clk),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// use makes up to n requests and returns how many were allowed
+	use := func(n int) int {
+		allowed := 0
+		for range n {
+			if ok, _ := l.Allow(context.Background(), "k"); ok {
+				allowed++
+			}
+		}
+		return allowed
+	}
+
+	// A new key has no credit yet
+	if got := use(100); got != 20 {
+		t.Errorf("first window allowed %d, want the rate, 20", got)
+	}
+
+	// 15 requests left unused carry over into the next window
+	clk.Advance(time.Minute)
+	if got := use(5); got != 5 {
+		t.Fatalf("second window allowed %d of 5", got)
+	}
+	clk.Advance(time.Minute)
+	if got := use(100); got != 35 {
+		t.Errorf("third window allowed %d, want the rate plus 15 unused, 35", got)
+	}
+
+	// The credit was used up, then an idle window fills it
+	clk.Advance(time.Minute)
+	if got := use(100); got != 20 {
+		t.Errorf("fourth window allowed %d after the credit was used, want 20", got)
+	}
+	clk.Advance(2 * time.Minute)
+	if got := use(100); got != 40 {
+		t.Errorf("window after an idle one allowed %d, want the rate plus the rollover, 40", got)
+	}
+
+	// A key idle for longer than its record is kept starts over
+	clk.Advance(time.Hour)
+	if got := use(100); got != 20 {
+		t.Errorf("window after an hour idle allowed %d, want the rate, 20", got)
+	}
+}
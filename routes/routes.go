@@ -0,0 +1,421 @@
+// Package routes rate limits HTTP endpoints with their own limits,
+// declared as pattern rules:
+//
+//	POST /api/search      => 10/min
+//	GET  /api/users/{id}  => 50/min
+//	GET  /api/*           => 100/min
+//	default               => 1000/min
+//
+// A pattern is an optional method followed by a path. Path segments match
+// literally, except:
+//
+//   - "{name}" or ":name" matches any single segment and captures it as a
+//     path parameter
+//   - "*" matches any single segment, or, as the last segment, the rest of
+//     the path
+//   - "{name...}" as the last segment captures the rest of the path
+//
+// A pattern without a method, or with the method "*", matches every
+// method. Rules are tried in order and the first match applies; the
+// "default" rule, wherever it is declared, applies to requests no other
+// rule matches. Requests matching no rule are not limited.
+//
+// Each rule has its own limiter, keyed by client, so a client's searches
+// don't use up its quota for other endpoints. Keys are prefixed with the
+// rule's pattern, as in "POST /api/search|10.0.0.1", so rules can share
+// storage.
+//
+// Example:
+//
+//	rules, err := routes.Parse(`
+//	    POST /api/search => 10/min
+//	    GET  /api/*      => 100/min
+//	    default          => 1000/min
+//	`)
+//	if err != nil {
+//	    return err
+//	}
+//	router, err := routes.New(rules, flexlimit.WithStorage(store))
+//	if err != nil {
+//	    return err
+//	}
+//	defer router.Close()
+//
+//	proxies, err := clientip.New([]string{"10.0.0.0/8"})
+//	if err != nil {
+//	    return err
+//	}
+//	http.ListenAndServe(":8080", routes.Middleware(router, proxies.Key)(mux))
+package routes
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Vipul984/flexlimit"
+	"github.com/Vipul984/flexlimit/httpheaders"
+)
+
+// DefaultPattern is the pattern of the rule applying to requests no other
+// rule matches.
+const DefaultPattern = "default"
+
+// Rule limits the requests matching Pattern.
+type Rule struct {
+	// Pattern is "[METHOD] PATH", or DefaultPattern
+	Pattern string
+
+	// Limit is the rate limit of each client on the matching requests
+	Limit flexlimit.Config
+}
+
+// String returns r in the form accepted by Parse.
+func (r Rule) String() string {
+	return fmt.Sprintf("%s => %d/%s", r.Pattern, r.Limit.Rate, r.Limit.Window)
+}
+
+// Parse parses rules, one per line, written "PATTERN => LIMIT" where
+// LIMIT is accepted by flexlimit.ParseConfig. Blank lines and lines
+// starting with "#" are ignored.
+//
+// Returns an *flexlimit.InvalidConfigError naming the line of the first
+// malformed rule.
+func Parse(text string) ([]Rule, error) {
+	var rules []Rule
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for line := 1; scanner.Scan(); line++ {
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		r, err := ParseRule(s)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, scanner.Err()
+}
+
+// ParseRule parses a single rule written "PATTERN => LIMIT".
+func ParseRule(s string) (Rule, error) {
+	pattern, limit, ok := strings.Cut(s, "=>")
+	if !ok {
+		return Rule{}, &flexlimit.InvalidConfigError{Field: "rule", Value: s, Reason: `must be written "PATTERN => LIMIT"`}
+	}
+	cfg, err := flexlimit.ParseConfig(strings.TrimSpace(limit))
+	if err != nil {
+		return Rule{}, err
+	}
+	r := Rule{Pattern: strings.Join(strings.Fields(pattern), " "), Limit: cfg}
+	if r.Pattern != DefaultPattern {
+		if _, err := compile(r.Pattern); err != nil {
+			return Rule{}, err
+		}
+	}
+	return r, nil
+}
+
+// segment kinds
+const (
+	literal  = iota
+	param    // {name} or :name
+	wildcard // *
+	rest     // trailing * or {name...}
+)
+
+// segment is one segment of a compiled path pattern.
+type segment struct {
+	kind int
+	text string // literal text or parameter name
+}
+
+// pattern is a compiled rule pattern.
+type pattern struct {
+	method   string // "" matches every method
+	segments []segment
+}
+
+// compile parses a rule pattern.
+func compile(s string) (*pattern, error) {
+	invalid := func(reason string) error {
+		return &flexlimit.InvalidConfigError{Field: "pattern", Value: s, Reason: reason}
+	}
+
+	var p pattern
+	path := s
+	if method, rest, ok := strings.Cut(s, " "); ok {
+		p.method, path = strings.ToUpper(method), strings.TrimSpace(rest)
+		if p.method == "*" {
+			p.method = ""
+		}
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, invalid(`path must start with "/"`)
+	}
+
+	parts := splitPath(path)
+	for i, part := range parts {
+		last := i == len(parts)-1
+		switch {
+		case part == "*" && last:
+			p.segments = append(p.segments, segment{kind: rest})
+		case part == "*":
+			p.segments = append(p.segments, segment{kind: wildcard})
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "...}"):
+			if !last {
+				return nil, invalid("{name...} must be the last segment")
+			}
+			p.segments = append(p.segments, segment{kind: rest, text: part[1 : len(part)-4]})
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+			p.segments = append(p.segments, segment{kind: param, text: part[1 : len(part)-1]})
+		case strings.HasPrefix(part, ":") && len(part) > 1:
+			p.segments = append(p.segments, segment{kind: param, text: part[1:]})
+		case strings.ContainsAny(part, "{}*"):
+			return nil, invalid("wildcards and parameters must take a whole segment")
+		default:
+			p.segments = append(p.segments, segment{kind: literal, text: part})
+		}
+	}
+	return &p, nil
+}
+
+// splitPath splits a path into its segments, ignoring empty ones.
+func splitPath(path string) []string {
+	return strings.FieldsFunc(path, func(r rune) bool { return r == '/' })
+}
+
+// match reports whether the pattern matches a request, and returns the
+// path parameters it captured.
+func (p *pattern) match(method string, parts []string) (map[string]string, bool) {
+	if p.method != "" && p.method != method {
+		return nil, false
+	}
+
+	var params map[string]string
+	capture := func(name, value string) {
+		if name == "" {
+			return
+		}
+		if params == nil {
+			params = make(map[string]string)
+		}
+		params[name] = value
+	}
+
+	for i, seg := range p.segments {
+		if seg.kind == rest {
+			if i >= len(parts) {
+				return nil, false
+			}
+			capture(seg.text, strings.Join(parts[i:], "/"))
+			return params, true
+		}
+		if i >= len(parts) {
+			return nil, false
+		}
+		switch seg.kind {
+		case literal:
+			if parts[i] != seg.text {
+				return nil, false
+			}
+		case param:
+			capture(seg.text, parts[i])
+		}
+	}
+	if len(parts) != len(p.segments) {
+		return nil, false
+	}
+	return params, true
+}
+
+// route is a rule with its compiled pattern and limiter.
+type route struct {
+	rule    Rule
+	pattern *pattern // nil for the default rule
+	limiter *flexlimit.Limiter
+	policy  *flexlimit.Composite // the limiter keyed by clientAttr
+}
+
+// clientAttr is the attribute holding the client key in a route's policy.
+const clientAttr = "client"
+
+// keyPrefix returns the prefix of the keys of the rule with pattern, so
+// rules sharing storage (see flexlimit.WithStorage) keep separate keys.
+func keyPrefix(pattern string) string {
+	return pattern + "|"
+}
+
+// Router picks the rule applying to each request and enforces it.
+type Router struct {
+	routes []*route
+	def    *route
+}
+
+// New creates a Router for rules, creating one limiter per rule with opts,
+// which apply after each rule's own limit.
+//
+// Returns an *flexlimit.InvalidConfigError if there are no rules, a
+// pattern is malformed or declared twice, or a limiter can't be created.
+func New(rules []Rule, opts ...flexlimit.Option) (*Router, error) {
+	if len(rules) == 0 {
+		return nil, &flexlimit.InvalidConfigError{Field: "rules", Value: rules, Reason: "must not be empty"}
+	}
+
+	rt := &Router{}
+	seen := make(map[string]struct{}, len(rules))
+	for _, rule := range rules {
+		if _, dup := seen[rule.Pattern]; dup {
+			rt.Close()
+			return nil, &flexlimit.InvalidConfigError{Field: "pattern", Value: rule.Pattern, Reason: "already defined"}
+		}
+		seen[rule.Pattern] = struct{}{}
+
+		r := &route{rule: rule}
+		if rule.Pattern != DefaultPattern {
+			p, err := compile(rule.Pattern)
+			if err != nil {
+				rt.Close()
+				return nil, err
+			}
+			r.pattern = p
+		}
+
+		l, err := flexlimit.NewFromConfig(rule.Limit, opts...)
+		if err != nil {
+			rt.Close()
+			return nil, err
+		}
+		r.limiter = l
+		r.policy, err = flexlimit.NewComposite(flexlimit.Rule{
+			Name:    rule.Pattern,
+			Limiter: l,
+			Key:     func(a flexlimit.Attributes) string { return keyPrefix(rule.Pattern) + a[clientAttr] },
+		})
+		if err != nil {
+			l.Close()
+			rt.Close()
+			return nil, err
+		}
+
+		if r.pattern == nil {
+			rt.def = r
+		} else {
+			rt.routes = append(rt.routes, r)
+		}
+	}
+	return rt, nil
+}
+
+// Match is the rule applying to a request.
+type Match struct {
+	// Rule is the matching rule
+	Rule Rule
+
+	// Limiter enforces the rule
+	Limiter *flexlimit.Limiter
+
+	// Params holds the path parameters captured by the pattern
+	Params map[string]string
+
+	route *route
+}
+
+// Match returns the rule applying to a request with method and path, or
+// false if no rule applies.
+func (rt *Router) Match(method, path string) (Match, bool) {
+	parts := splitPath(path)
+	for _, r := range rt.routes {
+		if params, ok := r.pattern.match(method, parts); ok {
+			return Match{Rule: r.rule, Limiter: r.limiter, Params: params, route: r}, true
+		}
+	}
+	if rt.def != nil {
+		return Match{Rule: rt.def.rule, Limiter: rt.def.limiter, route: rt.def}, true
+	}
+	return Match{}, false
+}
+
+// Allow checks a request with method and path from the client identified
+// by key against the matching rule, consuming a token if it is allowed. A
+// request no rule applies to is allowed.
+//
+// Returns the Match, and nil if the request is allowed, a
+// *flexlimit.LimitExceededError whose Rule is the matching pattern, or the
+// error of a limiter that failed.
+func (rt *Router) Allow(ctx context.Context, method, path, key string) (Match, error) {
+	m, ok := rt.Match(method, path)
+	if !ok {
+		return m, nil
+	}
+	return m, m.route.policy.Allow(ctx, flexlimit.Attributes{clientAttr: key})
+}
+
+// Rules returns the rules in order, the default rule last.
+func (rt *Router) Rules() []Rule {
+	rules := make([]Rule, 0, len(rt.routes)+1)
+	for _, r := range rt.routes {
+		rules = append(rules, r.rule)
+	}
+	if rt.def != nil {
+		rules = append(rules, rt.def.rule)
+	}
+	return rules
+}
+
+// Close closes the limiters of every rule.
+func (rt *Router) Close() error {
+	var errs []error
+	for _, r := range rt.routes {
+		errs = append(errs, r.policy.Close())
+	}
+	if rt.def != nil {
+		errs = append(errs, rt.def.policy.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Middleware rate limits requests with rt, identifying clients with key,
+// such as clientip.Resolver.Key behind proxies.
+// The path parameters of the matching rule are set on the request (see
+// http.Request.PathValue).
+//
+// Denied requests get 429 Too Many Requests with Retry-After and the
+// RateLimit header fields of the matching rule. If the limiter fails, the
+// request gets 503 Service Unavailable; storage failures are handled by
+// the limiter's fallback strategy and don't get here.
+func Middleware(rt *Router, key func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			m, err := rt.Allow(r.Context(), r.Method, r.URL.Path, key(r))
+			if err == nil {
+				for name, value := range m.Params {
+					r.SetPathValue(name, value)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var limitErr *flexlimit.LimitExceededError
+			if !errors.As(err, &limitErr) {
+				http.Error(w, "rate limiter unavailable", http.StatusServiceUnavailable)
+				return
+			}
+
+			httpheaders.Set(w.Header(), httpheaders.Quota{
+				Limit:     limitErr.Limit,
+				Remaining: limitErr.Limit - limitErr.Used,
+				ResetIn:   limitErr.RetryAfter,
+				Window:    limitErr.Window,
+			})
+			retryAfter := int(limitErr.RetryAfter.Seconds() + 0.999)
+			w.Header().Set("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+			http.Error(w, "too many requests (rule "+limitErr.Rule+")", http.StatusTooManyRequests)
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package flexlimit
+
+import (
+	"sync"
+	"time"
+)
+
+// approxKeyBytes is the estimated memory an in-memory store uses per key,
+// excluding sliding window timestamps.
+const approxKeyBytes = 256
+
+// SelfLimits bound the resources a limiter may use, so that the limiter
+// protecting a service can't itself become the outage: a storage backend
+// flooded by rate limit checks, a callback storm or an unbounded key set.
+//
+// A zero field means no limit.
+type SelfLimits struct {
+	// StorageOpsPerSecond caps how many decisions per second go to
+	// storage; an AllowMulti batch counts as one. Requests over the cap are decided by the fallback strategy
+	// as if storage had failed, and OnFallback receives ErrSelfLimited
+	StorageOpsPerSecond int
+
+	// CallbacksPerSecond caps how many OnLimit, OnAllow and OnStateChange
+	// calls run per second. Calls over the cap are skipped
+	CallbacksPerSecond int
+
+	// MaxMemory caps the approximate number of bytes the limiter's
+	// in-memory stores may hold, by lowering WithMaxKeys to fit. It
+	// doesn't apply to storage passed to WithStorage
+	MaxMemory int64
+}
+
+// WithSelfLimits bounds the resources the limiter may use. Each time a
+// self-limit kicks in, metrics.SelfLimited is incremented with the
+// resource's name as the LabelResource label.
+//
+// Example:
+//
+//	flexlimit.New(100, time.Minute,
+//	    flexlimit.WithStorage(redisStore),
+//	    flexlimit.WithFallback(flexlimit.LocalMemory),
+//	    flexlimit.WithSelfLimits(flexlimit.SelfLimits{
+//	        StorageOpsPerSecond: 50000,
+//	        CallbacksPerSecond:  1000,
+//	        MaxMemory:           64 << 20,
+//	    }),
+//	)
+func WithSelfLimits(limits SelfLimits) Option {
+	return func(o *Options) {
+		o.selfLimits = limits
+	}
+}
+
+// rateGate is a token bucket admitting up to rate events per second, used
+// to enforce self-limits. A nil gate admits everything.
+type rateGate struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateGate returns a gate admitting rate events per second, or nil if
+// rate is zero.
+func newRateGate(rate int, now time.Time) *rateGate {
+	if rate == 0 {
+		return nil
+	}
+	return &rateGate{rate: float64(rate), tokens: float64(rate), last: now}
+}
+
+// allow reports whether one more event is admitted at now.
+func (g *rateGate) allow(now time.Time) bool {
+	if g == nil {
+		return true
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if elapsed := now.Sub(g.last); elapsed > 0 {
+		g.tokens = min(g.rate, g.tokens+elapsed.Seconds()*g.rate)
+		g.last = now
+	}
+	if g.tokens < 1 {
+		return false
+	}
+	g.tokens--
+	return true
+}
+
+// maxKeysFor returns the number of keys the in-memory stores may hold:
+// WithMaxKeys, lowered to fit SelfLimits.MaxMemory.
+func (l *Limiter) maxKeysFor() int {
+	maxMemory := l.opts.selfLimits.MaxMemory
+	if maxMemory == 0 {
+		return l.opts.maxKeys
+	}
+
+	perKey := int64(approxKeyBytes)
+	if l.opts.algorithm == string(SlidingWindow) {
+		if l.opts.windowBuckets > 0 {
+			perKey += int64(l.opts.windowBuckets) * 8
+		} else {
+			perKey += int64(l.rate) * 24 // one time.Time per request
+		}
+	}
+	return int(max(1, min(int64(l.opts.maxKeys), maxMemory/perKey)))
+}
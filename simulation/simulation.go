@@ -0,0 +1,255 @@
+// Package simulation replays recorded traffic against the limiters of a
+// configuration file (see package config) offline, so limits can be tuned
+// against real traffic before they are deployed.
+//
+// Traffic is a CSV file with one request per line: its time, the limiter
+// deciding it, the key and an optional cost, as in
+//
+//	time,limiter,key,cost
+//	2025-01-01T12:00:00Z,api.pro,tenant-42,1
+//	2025-01-01T12:00:00.25Z,login,user-7,
+//
+// Times are RFC 3339 or Unix seconds, and requests are replayed in time
+// order on a mock clock, so a day of traffic replays in seconds. Limiters
+// are named as in the file, tiers included ("api.pro"). A first line
+// starting with "time" is a header and is skipped.
+//
+// Example:
+//
+//	f, err := config.Parse(policy)
+//	if err != nil {
+//	    return err
+//	}
+//	traffic, err := simulation.ReadCSV(csvFile)
+//	if err != nil {
+//	    return err
+//	}
+//	report, err := simulation.Run(ctx, f, traffic)
+package simulation
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Vipul984/flexlimit"
+	"github.com/Vipul984/flexlimit/clock"
+	"github.com/Vipul984/flexlimit/config"
+)
+
+// Request is a recorded request.
+type Request struct {
+	// Time is when the request was made
+	Time time.Time
+
+	// Limiter is the name of the limiter deciding the request, or of one
+	// of its tiers, as in "api.pro"
+	Limiter string
+
+	// Key is the key of the request
+	Key string
+
+	// Cost is the number of tokens the request consumes. Default: 1
+	Cost int
+}
+
+// ReadCSV reads the requests of a traffic file.
+//
+// Returns an error naming the line of the first malformed request.
+func ReadCSV(r io.Reader) ([]Request, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	var reqs []Request
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return reqs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == 1 && strings.EqualFold(rec[0], "time") {
+			continue
+		}
+		req, err := parseRecord(rec)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		reqs = append(reqs, req)
+	}
+}
+
+// parseRecord parses the fields of a request.
+func parseRecord(rec []string) (Request, error) {
+	if len(rec) < 3 || len(rec) > 4 {
+		return Request{}, fmt.Errorf("want time, limiter, key and an optional cost, got %d fields", len(rec))
+	}
+
+	at, err := parseTime(rec[0])
+	if err != nil {
+		return Request{}, err
+	}
+	req := Request{Time: at, Limiter: rec[1], Key: rec[2], Cost: 1}
+	if req.Limiter == "" || req.Key == "" {
+		return Request{}, errors.New("limiter and key must not be empty")
+	}
+	if len(rec) == 4 && rec[3] != "" {
+		req.Cost, err = strconv.Atoi(rec[3])
+		if err != nil || req.Cost <= 0 {
+			return Request{}, fmt.Errorf("invalid cost %q", rec[3])
+		}
+	}
+	return req, nil
+}
+
+// parseTime parses an RFC 3339 time or a number of Unix seconds.
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: want RFC 3339 or Unix seconds", s)
+	}
+	return time.Unix(0, int64(secs*float64(time.Second))).UTC(), nil
+}
+
+// Report is the outcome of a simulation.
+type Report struct {
+	// Rules holds the outcome of each limiter the traffic named, by name
+	Rules []RuleReport
+}
+
+// RuleReport is the outcome of the requests of one limiter.
+type RuleReport struct {
+	// Name is the name of the limiter, or of one of its tiers
+	Name string
+
+	// Requests and Denied count the limiter's requests, and those it
+	// denied
+	Requests int
+	Denied   int
+
+	// Keys holds the outcome of each key the limiter denied requests of,
+	// most denied first
+	Keys []KeyReport
+}
+
+// DenyPercent returns the percentage of the rule's requests that were
+// denied.
+func (r RuleReport) DenyPercent() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return 100 * float64(r.Denied) / float64(r.Requests)
+}
+
+// KeyReport is the outcome of the requests of one key.
+type KeyReport struct {
+	Key      string
+	Requests int
+	Denied   int
+}
+
+// Run replays traffic against the limiters of f and reports what they
+// denied. opts apply to every limiter after the file's settings.
+//
+// The limiters run in memory on a mock clock, whatever the storage of f,
+// and shadow mode is ignored, so shadow limiters report what they would
+// deny. f must be valid (see config.File.Validate).
+func Run(ctx context.Context, f *config.File, traffic []Request, opts ...flexlimit.Option) (*Report, error) {
+	reqs := slices.Clone(traffic)
+	slices.SortStableFunc(reqs, func(a, b Request) int { return a.Time.Compare(b.Time) })
+
+	start := time.Now()
+	if len(reqs) > 0 {
+		start = reqs[0].Time
+	}
+	clk := clock.NewMockAt(start)
+
+	set, err := offline(f).Build(append([]flexlimit.Option{flexlimit.WithClock(clk)}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer set.Close()
+
+	rules := make(map[string]*RuleReport)
+	keys := make(map[string]map[string]*KeyReport)
+	for i, req := range reqs {
+		l, ok := set.Limiter(req.Limiter)
+		if !ok {
+			return nil, fmt.Errorf("request %d: no limiter or tier %q", i+1, req.Limiter)
+		}
+		clk.Set(req.Time)
+
+		allowed, err := l.AllowN(ctx, req.Key, max(req.Cost, 1))
+		if err != nil {
+			return nil, fmt.Errorf("request %d: %w", i+1, err)
+		}
+
+		rule, ok := rules[req.Limiter]
+		if !ok {
+			rule = &RuleReport{Name: req.Limiter}
+			rules[req.Limiter] = rule
+			keys[req.Limiter] = make(map[string]*KeyReport)
+		}
+		key, ok := keys[req.Limiter][req.Key]
+		if !ok {
+			key = &KeyReport{Key: req.Key}
+			keys[req.Limiter][req.Key] = key
+		}
+		rule.Requests++
+		key.Requests++
+		if !allowed {
+			rule.Denied++
+			key.Denied++
+		}
+	}
+
+	report := &Report{}
+	for _, name := range slices.Sorted(maps.Keys(rules)) {
+		rule := rules[name]
+		for _, key := range keys[name] {
+			if key.Denied > 0 {
+				rule.Keys = append(rule.Keys, *key)
+			}
+		}
+		slices.SortFunc(rule.Keys, func(a, b KeyReport) int {
+			if a.Denied != b.Denied {
+				return b.Denied - a.Denied
+			}
+			return strings.Compare(a.Key, b.Key)
+		})
+		report.Rules = append(report.Rules, *rule)
+	}
+	return report, nil
+}
+
+// offline returns a copy of f without its storage and shadow mode.
+func offline(f *config.File) *config.File {
+	c := *f
+	c.Storage = nil
+	c.Defaults.Shadow = false
+	c.Templates = unshadowed(f.Templates)
+	c.Limiters = unshadowed(f.Limiters)
+	return &c
+}
+
+// unshadowed returns a copy of limiters without shadow mode.
+func unshadowed(limiters map[string]config.Limiter) map[string]config.Limiter {
+	c := make(map[string]config.Limiter, len(limiters))
+	for name, l := range limiters {
+		l.Shadow = false
+		c[name] = l
+	}
+	return c
+}
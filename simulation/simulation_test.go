@@ -0,0 +1,94 @@
+package simulation_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Vipul984/flexlimit/config"
+	"github.com/Vipul984/flexlimit/simulation"
+)
+
+const policy = `
+templates:
+  observed:
+    shadow: true
+limiters:
+  api:
+    extends: observed
+    limit: 10/min
+    algorithm: fixed_window
+    tiers:
+      pro: 100/min
+`
+
+func TestRun(t *testing.T) {
+	f, err := config.Parse([]byte(policy))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var csv strings.Builder
+	csv.WriteString("time,limiter,key,cost\n")
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := range 30 {
+		at := start.Add(time.Duration(i) * time.Second).Format(time.RFC3339)
+		fmt.Fprintf(&csv, "%s,api,alice,1\n", at)
+		fmt.Fprintf(&csv, "%s,api.pro,acme,\n", at)
+		if i < 5 {
+			fmt.Fprintf(&csv, "%d,api,bob,3\n", start.Unix()+int64(i))
+		}
+	}
+	traffic, err := simulation.ReadCSV(strings.NewReader(csv.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := simulation.Run(context.Background(), f, traffic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Rules) != 2 {
+		t.Fatalf("got %d rules, want api and api.pro", len(report.Rules))
+	}
+
+	// Shadow mode is ignored: alice gets 10 of her 30 requests and bob
+	// 3 of his 5, costing 3 each
+	api := report.Rules[0]
+	if api.Name != "api" || api.Requests != 35 || api.Denied != 22 {
+		t.Errorf("api = %s with %d requests, %d denied; want api with 35, 22 denied", api.Name, api.Requests, api.Denied)
+	}
+	want := []simulation.KeyReport{{Key: "alice", Requests: 30, Denied: 20}, {Key: "bob", Requests: 5, Denied: 2}}
+	if fmt.Sprint(api.Keys) != fmt.Sprint(want) {
+		t.Errorf("api keys = %v, want %v", api.Keys, want)
+	}
+	if pro := report.Rules[1]; pro.Denied != 0 || pro.DenyPercent() != 0 {
+		t.Errorf("api.pro denied %d requests, want none", pro.Denied)
+	}
+}
+
+func TestRunUnknownLimiter(t *testing.T) {
+	f, err := config.Parse([]byte(policy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	traffic := []simulation.Request{{Time: time.Now(), Limiter: "login", Key: "u", Cost: 1}}
+	if _, err := simulation.Run(context.Background(), f, traffic); err == nil {
+		t.Error("Run succeeded with a request for an unknown limiter")
+	}
+}
+
+func TestReadCSVErrors(t *testing.T) {
+	for _, data := range []string{
+		"yesterday,api,k\n",
+		"1735732800,api\n",
+		"1735732800,api,k,-1\n",
+		"1735732800,,k\n",
+	} {
+		if _, err := simulation.ReadCSV(strings.NewReader(data)); err == nil {
+			t.Errorf("ReadCSV(%q) succeeded, want an error", data)
+		}
+	}
+}
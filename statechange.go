@@ -0,0 +1,129 @@
+package flexlimit
+
+import (
+	"context"
+	"math"
+	"slices"
+	"time"
+)
+
+// firstSeenRecord is the kind of record marking a key seen for FirstSeen
+// (see recordKey).
+const firstSeenRecord = "first_seen"
+
+// firstSeenTTL is how long a key stays marked seen after its first
+// request.
+const firstSeenTTL = 24 * time.Hour
+
+// StateChangeKind is what changed in a key's usage. See OnStateChange.
+type StateChangeKind string
+
+const (
+	// FirstSeen is the first request of a key the limiter has not seen in
+	// the last day.
+	FirstSeen StateChangeKind = "first_seen"
+
+	// ThresholdCrossed is a request taking a key's usage to or past one of
+	// the thresholds of WithUsageThresholds.
+	ThresholdCrossed StateChangeKind = "threshold_crossed"
+)
+
+// StateChange describes a change in a key's usage, with the request that
+// caused it. See OnStateChange.
+type StateChange struct {
+	// Kind is what changed
+	Kind StateChangeKind
+
+	// Threshold is the fraction of the limit that was crossed, as passed
+	// to WithUsageThresholds. Zero unless Kind is ThresholdCrossed
+	Threshold float64
+
+	// At is when the request was decided, on the limiter's clock (see
+	// WithClock)
+	At time.Time
+
+	// LimitInfo is the allowed request that caused the change
+	LimitInfo
+}
+
+// OnStateChange registers a callback invoked when an allowed request
+// changes its key's usage in a way worth telling the key's owner about: on
+// the first request of a key, and when a request takes the key to or past
+// one of the thresholds of WithUsageThresholds, such as 80% of its quota,
+// to warn users before their requests are denied.
+//
+// Thresholds are found from the key's usage before and after the request,
+// so a threshold fires once each time the key's usage rises past it, such
+// as once per window, even when several instances share the storage. A
+// key's first request marks it seen in the storage ("_fl:first_seen:<key>")
+// for a day, so FirstSeen fires once a day at most for a key, across
+// instances. The mark is only checked when the key had no usage before the
+// request, and a mark that can't be written is taken as seen. A request
+// crossing several thresholds fires each, lowest first, after OnAllow. The
+// callback runs synchronously on the request path and should be fast.
+//
+// Example:
+//
+//	flexlimit.New(1000, 24*time.Hour,
+//	    flexlimit.WithUsageThresholds(0.8, 1),
+//	    flexlimit.OnStateChange(func(c flexlimit.StateChange) {
+//	        if c.Kind == flexlimit.ThresholdCrossed {
+//	            notifyQuota(c.Key, c.Threshold)
+//	        }
+//	    }),
+//	)
+func OnStateChange(fn func(StateChange)) Option {
+	return func(o *Options) {
+		o.onStateChange = fn
+	}
+}
+
+// WithUsageThresholds sets the fractions of the limit, in (0, 1], whose
+// crossing OnStateChange reports. 1 is reached by the request using a
+// key's last token.
+//
+// Default: none (only FirstSeen is reported)
+func WithUsageThresholds(fractions ...float64) Option {
+	return func(o *Options) {
+		o.thresholds = slices.Sorted(slices.Values(fractions))
+	}
+}
+
+// validateThresholds checks the fractions of WithUsageThresholds.
+func validateThresholds(fractions []float64) error {
+	for _, f := range fractions {
+		if !(f > 0 && f <= 1) {
+			return &InvalidConfigError{Field: "usage_thresholds", Value: f, Reason: "must be in (0, 1]"}
+		}
+	}
+	return nil
+}
+
+// stateChanged reports the state changes caused by the allowed request
+// of info, decided at now, to the OnStateChange callback. Must be called
+// with l.mu held.
+func (l *Limiter) stateChanged(ctx context.Context, info LimitInfo, now time.Time) {
+	fn := func(c StateChange) {
+		if l.callbacks.allow(CallbackOnStateChange, c.Key, now) {
+			l.opts.onStateChange(c)
+		}
+	}
+	before := info.Used - info.Cost
+	if before <= 0 && info.Used > 0 && l.firstSeen(ctx, info.Key) {
+		fn(StateChange{Kind: FirstSeen, At: now, LimitInfo: info})
+	}
+
+	for _, t := range l.opts.thresholds {
+		at := int(math.Ceil(t * float64(info.Limit)))
+		if before < at && info.Used >= at {
+			fn(StateChange{Kind: ThresholdCrossed, Threshold: t, At: now, LimitInfo: info})
+		}
+	}
+}
+
+// firstSeen marks key seen and reports whether it wasn't already. Must be
+// called with l.mu held.
+func (l *Limiter) firstSeen(ctx context.Context, key string) bool {
+	n, err := l.be.store.Incr(ctx, recordKey(firstSeenRecord, key), 1, firstSeenTTL)
+	return err == nil && n == 1
+}
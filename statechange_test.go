@@ -0,0 +1,63 @@
+package flexlimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Vipul984/flexlimit/clock"
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+func TestFirstSeenOncePerKey(t *testing.T) {
+	clk := clock.NewMockAt(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	store := storage.NewMemory(storage.Config{Clock: clk})
+
+	seen := map[string]int{}
+	newLimiter := func() *Limiter {
+		l, err := New(2, time.Minute,
+			WithAlgorithm(FixedWindow),
+			WithStorage(store),
+			WithClock(clk),
+			OnStateChange(func(c StateChange) {
+				if c.Kind == FirstSeen {
+					seen[c.Key]++
+				}
+			}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.Close() })
+		return l
+	}
+	a, b := newLimiter(), newLimiter()
+	ctx := context.Background()
+
+	a.Allow(ctx, "k")
+	a.Allow(ctx, "k")
+	if seen["k"] != 1 {
+		t.Fatalf("FirstSeen fired %d times for a key's first requests, want 1", seen["k"])
+	}
+
+	// Neither a new window nor another instance sees the key for the
+	// first time
+	clk.Advance(time.Minute)
+	a.Allow(ctx, "k")
+	b.Allow(ctx, "k")
+	if seen["k"] != 1 {
+		t.Errorf("FirstSeen fired %d times after a new window and another instance, want 1", seen["k"])
+	}
+
+	b.Allow(ctx, "other")
+	if seen["other"] != 1 {
+		t.Errorf("FirstSeen fired %d times for another key, want 1", seen["other"])
+	}
+
+	// A day later, the key is new again
+	clk.Advance(firstSeenTTL)
+	a.Allow(ctx, "k")
+	if seen["k"] != 2 {
+		t.Errorf("FirstSeen fired %d times after a day, want 2", seen["k"])
+	}
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// TokenBucketStore is implemented by backends that can run a token bucket
+// refill-and-consume cycle as one atomic server-side operation.
+//
+// Backends shared between processes (Redis, etc.) should implement it: the
+// generic Get/Set cycle is only safe within a single process, where the
+// algorithm serializes access per key. Backends that cannot run the
+// operation at the moment may return ErrNotSupported, and the algorithm
+// falls back to Get/Set.
+//
+// The bucket is stored in the regular State fields (Tokens, LastRefill), so
+// Get and Set remain usable on the same keys.
+type TokenBucketStore interface {
+	// TakeTokens refills the bucket for key and consumes req.Cost tokens
+	// if enough are available. A zero Cost only reports the refilled
+	// token count and writes nothing. A negative Cost puts -Cost tokens
+	// back, up to Capacity, and is always allowed.
+	TakeTokens(ctx context.Context, key string, req TokenBucketRequest) (TokenBucketResult, error)
+}
+
+// TokenBucketRequest describes one TakeTokens operation.
+type TokenBucketRequest struct {
+	// Capacity is the maximum number of tokens the bucket holds
+	Capacity float64
+
+	// RefillRate is the number of tokens added per second
+	RefillRate float64
+
+	// Cost is the number of tokens to consume (0 to only read, negative
+	// to refund)
+	Cost float64
+
+	// Now is the current time as seen by the caller
+	Now time.Time
+
+	// TTL is how long the key is kept after a successful consume
+	TTL time.Duration
+}
+
+// TokenBucketResult is the outcome of a TakeTokens operation.
+type TokenBucketResult struct {
+	// Allowed reports whether Cost tokens were consumed
+	Allowed bool
+
+	// Tokens is the number of tokens left after the operation
+	Tokens float64
+}
+
+// TokenBucketBatchStore is implemented by token bucket stores that can run
+// several TakeTokens operations in one round trip, such as a Redis
+// pipeline or a single pass under the memory store's lock.
+type TokenBucketBatchStore interface {
+	TokenBucketStore
+
+	// TakeTokensMulti runs reqs[i] on keys[i] and returns the results in
+	// the same order. Each operation is atomic on its own; the batch as a
+	// whole is not.
+	TakeTokensMulti(ctx context.Context, keys []string, reqs []TokenBucketRequest) ([]TokenBucketResult, error)
+}
+
+// SlidingLogStore is implemented by backends that can run a sliding window
+// log check-and-record cycle as one atomic server-side operation, so
+// instances sharing the backend get exact rolling windows. As with
+// TokenBucketStore, backends that cannot run the operation at the moment
+// may return ErrNotSupported, and the algorithm falls back to Get/Set.
+//
+// The log may be kept in a structure of the backend's own, such as a Redis
+// sorted set; Get then still returns it in State.Timestamps, and a key
+// replaced with Set is converted back on its next operation.
+type SlidingLogStore interface {
+	// TakeSlots drops the entries of key older than req.Window and adds
+	// req.Cost entries at req.Now if they fit within req.Limit. A zero
+	// Cost only reports the log and writes nothing. A negative Cost
+	// removes the -Cost most recent entries and is always allowed.
+	TakeSlots(ctx context.Context, key string, req SlidingLogRequest) (SlidingLogResult, error)
+}
+
+// SlidingLogRequest describes one TakeSlots operation.
+type SlidingLogRequest struct {
+	// Limit is the maximum number of entries within Window
+	Limit int64
+
+	// Window is how long entries count against Limit
+	Window time.Duration
+
+	// Cost is the number of entries to add (0 to only read, negative to
+	// refund)
+	Cost int64
+
+	// Need is the number of entries SlidingLogResult.Expiring makes room
+	// for if Cost entries aren't added; usually Cost, or 1 to only read
+	Need int64
+
+	// Now is the current time as seen by the caller; it is the time of the
+	// added entries
+	Now time.Time
+}
+
+// SlidingLogResult is the outcome of a TakeSlots operation.
+type SlidingLogResult struct {
+	// Allowed reports whether Cost entries were added, or removed for a
+	// negative Cost
+	Allowed bool
+
+	// Count is the number of entries within the window after the
+	// operation
+	Count int64
+
+	// Newest is the time of the most recent entry, or zero if there is
+	// none
+	Newest time.Time
+
+	// Expiring is the time of the entry whose expiry makes room for Need
+	// entries, or zero if Cost entries were added, there is room for Need
+	// entries already, or they can never fit
+	Expiring time.Time
+}
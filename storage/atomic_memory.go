@@ -0,0 +1,496 @@
+package storage
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Vipul984/flexlimit/clock"
+)
+
+var (
+	_ Storage               = (*AtomicMemory)(nil)
+	_ TokenBucketBatchStore = (*AtomicMemory)(nil)
+)
+
+// AtomicMemory is an in-memory Storage whose token buckets are updated
+// without locks, for gateways deciding millions of requests per second
+// over many cores. The limiter uses it for the token bucket algorithm
+// when it creates its own storage.
+//
+// Each bucket is a single word updated with compare-and-swap: its tokens
+// and last refill are packed into the time at which it will be full
+// again, as in the generic cell rate algorithm, so TakeTokens on a known
+// key takes no lock and allocates nothing. Buckets live in a sync.Map,
+// tuned for keys that are read far more often than they are added.
+//
+// Other states, such as grace period records, are kept in a Memory store
+// configured from the same Config. A state with a LastRefill passed to
+// Set, such as a bucket copied from another storage, is kept as is until
+// its first TakeTokens. MaxKeys bounds the buckets and the other states
+// each; once the buckets reach it, expired buckets are dropped first,
+// then arbitrary ones, a sixteenth of MaxKeys at a time.
+//
+// Example:
+//
+//	store := storage.NewAtomicMemory(storage.Config{MaxKeys: 100000})
+//	defer store.Close()
+type AtomicMemory struct {
+	buckets sync.Map // key -> *atomicBucket or *pendingBucket
+	count   atomic.Int64
+	maxKeys int
+
+	// evicting is held by the goroutine making room for new buckets
+	evicting sync.Mutex
+
+	other   *Memory
+	clock   clock.Clock
+	onEvict func(key string, state *State, reason EvictReason)
+	closed  atomic.Bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// atomicBucket is a token bucket of an AtomicMemory.
+type atomicBucket struct {
+	// full is the Unix time in nanoseconds at which the bucket is full
+	// again; the bucket holds capacity - (full - now) / perToken tokens
+	full atomic.Int64
+
+	capacity float64
+	perToken float64 // nanoseconds to refill one token
+	keep     int64   // nanoseconds the key is kept once full
+}
+
+// pendingBucket is a bucket stored with Set, kept as is until TakeTokens
+// tells its capacity and refill rate.
+type pendingBucket struct {
+	state     *State
+	expiresAt int64 // Unix nanoseconds, zero for no expiry
+}
+
+// NewAtomicMemory creates an in-memory storage with lock-free token
+// buckets.
+//
+// MaxKeys, CleanupInterval, Clock and OnEvict are read from cfg as by
+// NewMemory; snapshots are not supported. A positive CleanupInterval
+// starts a janitor goroutine that runs until Close.
+func NewAtomicMemory(cfg Config) *AtomicMemory {
+	clk := cfg.Clock
+	if clk == nil {
+		clk = clock.New()
+	}
+
+	other := cfg
+	other.SnapshotPath = ""
+	a := &AtomicMemory{
+		maxKeys: cfg.MaxKeys,
+		other:   NewMemory(other),
+		clock:   clk,
+		onEvict: cfg.OnEvict,
+		stop:    make(chan struct{}),
+	}
+	if a.maxKeys <= 0 {
+		a.maxKeys = 10000
+	}
+
+	if cfg.CleanupInterval > 0 {
+		a.wg.Add(1)
+		go a.janitor(cfg.CleanupInterval)
+	}
+	return a
+}
+
+// TakeTokens refills and consumes a token bucket atomically, without
+// taking a lock for a known key.
+func (a *AtomicMemory) TakeTokens(ctx context.Context, key string, req TokenBucketRequest) (TokenBucketResult, error) {
+	if err := ctx.Err(); err != nil {
+		return TokenBucketResult{}, err
+	}
+	if a.closed.Load() {
+		return TokenBucketResult{}, ErrClosed
+	}
+	return a.takeTokens(key, req), nil
+}
+
+// TakeTokensMulti runs several token bucket operations, each atomic on
+// its own.
+func (a *AtomicMemory) TakeTokensMulti(ctx context.Context, keys []string, reqs []TokenBucketRequest) ([]TokenBucketResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if a.closed.Load() {
+		return nil, ErrClosed
+	}
+
+	results := make([]TokenBucketResult, len(keys))
+	for i, key := range keys {
+		results[i] = a.takeTokens(key, reqs[i])
+	}
+	return results, nil
+}
+
+// takeTokens implements TakeTokens.
+func (a *AtomicMemory) takeTokens(key string, req TokenBucketRequest) TokenBucketResult {
+	now := req.Now.UnixNano()
+	perToken := float64(time.Second) / req.RefillRate
+
+	for {
+		var b *atomicBucket
+		v, ok := a.buckets.Load(key)
+		switch cur := v.(type) {
+		case *atomicBucket:
+			b = cur
+			if b.capacity != req.Capacity || b.perToken != perToken {
+				// The limit changed: carry the tokens over to a bucket
+				// with the new parameters
+				b = newAtomicBucket(req, b.tokens(now), now)
+				if !a.buckets.CompareAndSwap(key, cur, b) {
+					continue
+				}
+			}
+		case *pendingBucket:
+			elapsed := max(now-cur.state.LastRefill.UnixNano(), 0)
+			tokens := min(req.Capacity, cur.state.Tokens+float64(elapsed)/perToken)
+			b = newAtomicBucket(req, tokens, now)
+			if !a.buckets.CompareAndSwap(key, cur, b) {
+				continue
+			}
+		}
+
+		if !ok {
+			if req.Cost <= 0 {
+				return TokenBucketResult{Tokens: req.Capacity}
+			}
+			b = newAtomicBucket(req, req.Capacity, now)
+			if _, loaded := a.buckets.LoadOrStore(key, b); loaded {
+				continue
+			}
+			a.added()
+		}
+
+		res := b.take(req.Cost, now)
+		// A bucket deleted or replaced meanwhile lost the update, so the
+		// request is decided again on the current one
+		if res.Allowed && req.Cost != 0 {
+			if v, _ := a.buckets.Load(key); v != b {
+				continue
+			}
+		}
+		return res
+	}
+}
+
+// newAtomicBucket returns a bucket for req holding tokens at now.
+func newAtomicBucket(req TokenBucketRequest, tokens float64, now int64) *atomicBucket {
+	b := &atomicBucket{
+		capacity: req.Capacity,
+		perToken: float64(time.Second) / req.RefillRate,
+	}
+	b.keep = max(int64(req.TTL)-int64(b.capacity*b.perToken), 0)
+	b.full.Store(b.fullAt(tokens, now))
+	return b
+}
+
+// take consumes cost tokens at now if enough are available. A zero cost
+// only reads the bucket; a negative one puts tokens back.
+func (b *atomicBucket) take(cost float64, now int64) TokenBucketResult {
+	for {
+		full := b.full.Load()
+		tokens := b.capacity - float64(max(full-now, 0))/b.perToken
+
+		if cost == 0 || tokens < cost {
+			return TokenBucketResult{Tokens: tokens}
+		}
+		left := min(b.capacity, tokens-cost)
+		if b.full.CompareAndSwap(full, b.fullAt(left, now)) {
+			return TokenBucketResult{Allowed: true, Tokens: left}
+		}
+	}
+}
+
+// tokens returns the tokens held at now.
+func (b *atomicBucket) tokens(now int64) float64 {
+	return b.capacity - float64(max(b.full.Load()-now, 0))/b.perToken
+}
+
+// fullAt returns when the bucket is full again if it holds tokens at now.
+func (b *atomicBucket) fullAt(tokens float64, now int64) int64 {
+	return now + int64(math.Ceil(max(b.capacity-tokens, 0)*b.perToken))
+}
+
+// expired reports whether the bucket has been full long enough at now to
+// be dropped.
+func (b *atomicBucket) expired(now int64) bool {
+	return now >= b.full.Load()+b.keep
+}
+
+// state returns the bucket as a State at now.
+func (b *atomicBucket) state(now time.Time) *State {
+	return &State{Tokens: b.tokens(now.UnixNano()), LastRefill: now}
+}
+
+// live returns the state of the bucket stored as v at now, or nil if it
+// expired.
+func live(v any, now time.Time) *State {
+	switch b := v.(type) {
+	case *atomicBucket:
+		if !b.expired(now.UnixNano()) {
+			return b.state(now)
+		}
+	case *pendingBucket:
+		if b.expiresAt == 0 || now.UnixNano() < b.expiresAt {
+			return copyState(b.state)
+		}
+	}
+	return nil
+}
+
+// Get retrieves a copy of the state for key.
+func (a *AtomicMemory) Get(ctx context.Context, key string) (*State, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if a.closed.Load() {
+		return nil, ErrClosed
+	}
+
+	if v, ok := a.buckets.Load(key); ok {
+		if st := live(v, a.clock.Now()); st != nil {
+			return st, nil
+		}
+		return nil, ErrKeyNotFound
+	}
+	return a.other.Get(ctx, key)
+}
+
+// Set stores a copy of state for key. A state with a LastRefill is kept
+// with the buckets.
+func (a *AtomicMemory) Set(ctx context.Context, key string, state *State, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if a.closed.Load() {
+		return ErrClosed
+	}
+
+	if state == nil || state.LastRefill.IsZero() {
+		return a.other.Set(ctx, key, state, ttl)
+	}
+	a.setPending(key, state, ttl)
+	return nil
+}
+
+// setPending stores a bucket state for key.
+func (a *AtomicMemory) setPending(key string, state *State, ttl time.Duration) {
+	p := &pendingBucket{state: copyState(state)}
+	if ttl > 0 {
+		p.expiresAt = a.clock.Now().Add(ttl).UnixNano()
+	}
+	if _, loaded := a.buckets.Swap(key, p); !loaded {
+		a.added()
+	}
+}
+
+// Incr atomically adds amount to the Count field of key.
+func (a *AtomicMemory) Incr(ctx context.Context, key string, amount int64, ttl time.Duration) (int64, error) {
+	return a.other.Incr(ctx, key, amount, ttl)
+}
+
+// Delete removes key. Deleting a missing key is not an error.
+func (a *AtomicMemory) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if a.closed.Load() {
+		return ErrClosed
+	}
+
+	if _, loaded := a.buckets.LoadAndDelete(key); loaded {
+		a.count.Add(-1)
+	}
+	return a.other.Delete(ctx, key)
+}
+
+// Exists reports whether key exists.
+func (a *AtomicMemory) Exists(ctx context.Context, key string) (bool, error) {
+	st, err := a.Get(ctx, key)
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+	return st != nil, err
+}
+
+// GetMulti retrieves copies of the state for several keys.
+func (a *AtomicMemory) GetMulti(ctx context.Context, keys []string) ([]*State, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if a.closed.Load() {
+		return nil, ErrClosed
+	}
+
+	now := a.clock.Now()
+	states := make([]*State, len(keys))
+	var rest []string
+	var restIdx []int
+	for i, key := range keys {
+		if v, ok := a.buckets.Load(key); ok {
+			states[i] = live(v, now)
+			continue
+		}
+		rest = append(rest, key)
+		restIdx = append(restIdx, i)
+	}
+	if len(rest) == 0 {
+		return states, nil
+	}
+
+	others, err := a.other.GetMulti(ctx, rest)
+	if err != nil {
+		return nil, err
+	}
+	for j, st := range others {
+		states[restIdx[j]] = st
+	}
+	return states, nil
+}
+
+// SetMulti stores several states.
+func (a *AtomicMemory) SetMulti(ctx context.Context, states map[string]*State, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if a.closed.Load() {
+		return ErrClosed
+	}
+
+	others := make(map[string]*State, len(states))
+	for key, state := range states {
+		if state == nil || state.LastRefill.IsZero() {
+			others[key] = state
+			continue
+		}
+		a.setPending(key, state, ttl)
+	}
+	if len(others) == 0 {
+		return nil
+	}
+	return a.other.SetMulti(ctx, others, ttl)
+}
+
+// Keys returns all live keys matching pattern.
+//
+// Only prefix patterns are supported, as with Memory.
+func (a *AtomicMemory) Keys(ctx context.Context, pattern string) ([]string, error) {
+	keys, err := a.other.Keys(ctx, pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := strings.TrimSuffix(pattern, "*")
+	now := a.clock.Now()
+	a.buckets.Range(func(k, v any) bool {
+		key := k.(string)
+		if strings.HasPrefix(key, prefix) && live(v, now) != nil {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	return keys, nil
+}
+
+// Len returns the number of keys currently held, including expired keys
+// that have not been removed yet.
+func (a *AtomicMemory) Len() int {
+	return int(a.count.Load()) + a.other.Len()
+}
+
+// Ping reports whether the store is open.
+func (a *AtomicMemory) Ping(ctx context.Context) error {
+	return a.other.Ping(ctx)
+}
+
+// Close stops the janitor and drops all state without reporting it to
+// OnEvict. Subsequent operations return ErrClosed.
+func (a *AtomicMemory) Close() error {
+	if a.closed.Swap(true) {
+		return nil
+	}
+	close(a.stop)
+	a.wg.Wait()
+
+	a.buckets.Clear()
+	a.count.Store(0)
+	return a.other.Close()
+}
+
+// added counts a new bucket, making room if there are too many.
+func (a *AtomicMemory) added() {
+	if a.count.Add(1) <= int64(a.maxKeys) || !a.evicting.TryLock() {
+		return
+	}
+	defer a.evicting.Unlock()
+
+	a.sweep()
+	target := int64(a.maxKeys - max(a.maxKeys/16, 1))
+	if a.count.Load() <= int64(a.maxKeys) {
+		return
+	}
+	now := a.clock.Now()
+	a.buckets.Range(func(k, v any) bool {
+		if a.count.Load() <= target {
+			return false
+		}
+		a.remove(k.(string), v, live(v, now), EvictCapacity)
+		return true
+	})
+}
+
+// remove deletes the bucket v of key, if it is still there, and reports
+// it to OnEvict.
+func (a *AtomicMemory) remove(key string, v any, state *State, reason EvictReason) {
+	if !a.buckets.CompareAndDelete(key, v) {
+		return
+	}
+	a.count.Add(-1)
+	if a.onEvict != nil {
+		if state == nil {
+			state = &State{}
+		}
+		a.onEvict(key, state, reason)
+	}
+}
+
+// sweep removes every expired bucket.
+func (a *AtomicMemory) sweep() {
+	now := a.clock.Now()
+	a.buckets.Range(func(k, v any) bool {
+		if live(v, now) == nil {
+			a.remove(k.(string), v, &State{}, EvictExpired)
+		}
+		return true
+	})
+}
+
+// janitor removes expired buckets every interval until Close. The other
+// states have their own janitor.
+func (a *AtomicMemory) janitor(interval time.Duration) {
+	defer a.wg.Done()
+
+	ticker := a.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C():
+			a.sweep()
+		}
+	}
+}
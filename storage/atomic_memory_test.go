@@ -0,0 +1,121 @@
+package storage_test
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+// takeConcurrently runs callers goroutines each taking one token from key
+// attempts times, yielding between takes so they interleave, and returns
+// how many takes were allowed.
+func takeConcurrently(t *testing.T, store *storage.AtomicMemory, key string, req storage.TokenBucketRequest, callers, attempts int) int64 {
+	t.Helper()
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range attempts {
+				res, err := store.TakeTokens(context.Background(), key, req)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if res.Allowed {
+					allowed.Add(1)
+				}
+				runtime.Gosched()
+			}
+		}()
+	}
+	wg.Wait()
+	return allowed.Load()
+}
+
+func TestAtomicMemoryConcurrentTakes(t *testing.T) {
+	store := storage.NewAtomicMemory(storage.Config{})
+	defer store.Close()
+
+	// The clock stands still, so exactly the capacity is granted however
+	// the compare-and-swaps interleave
+	req := storage.TokenBucketRequest{Capacity: 500, RefillRate: 1, Cost: 1, Now: time.Now(), TTL: time.Minute}
+	if got := takeConcurrently(t, store, "k", req, 50, 20); got != 500 {
+		t.Errorf("allowed %d takes, want 500", got)
+	}
+
+	req.Cost = 0
+	res, err := store.TakeTokens(context.Background(), "k", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Tokens != 0 {
+		t.Errorf("tokens = %g, want 0", res.Tokens)
+	}
+}
+
+func TestAtomicMemoryConcurrentTakeAndRefund(t *testing.T) {
+	store := storage.NewAtomicMemory(storage.Config{})
+	defer store.Close()
+
+	ctx := context.Background()
+	req := storage.TokenBucketRequest{Capacity: 100, RefillRate: 1, Now: time.Now(), TTL: time.Minute}
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				take := req
+				take.Cost = 3
+				res, err := store.TakeTokens(ctx, "k", take)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				runtime.Gosched()
+				if res.Allowed {
+					take.Cost = -3
+					if _, err := store.TakeTokens(ctx, "k", take); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every token taken was put back
+	res, err := store.TakeTokens(ctx, "k", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Tokens != 100 {
+		t.Errorf("tokens = %g, want all 100 back", res.Tokens)
+	}
+}
+
+func TestAtomicMemoryConcurrentFirstTakeAfterSet(t *testing.T) {
+	store := storage.NewAtomicMemory(storage.Config{})
+	defer store.Close()
+
+	// Callers race to turn the state stored with Set into a bucket; only
+	// one of them may, and all take from the tokens it held
+	now := time.Now()
+	err := store.Set(context.Background(), "k", &storage.State{Tokens: 40, LastRefill: now}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := storage.TokenBucketRequest{Capacity: 100, RefillRate: 1, Cost: 1, Now: now, TTL: time.Minute}
+	if got := takeConcurrently(t, store, "k", req, 50, 2); got != 40 {
+		t.Errorf("allowed %d takes, want the 40 tokens set", got)
+	}
+}
@@ -0,0 +1,550 @@
+// Package bolt implements flexlimit storage in an embedded bbolt database
+// file, so a single-node daemon keeps its rate limit state across
+// restarts.
+//
+// In-memory state is lost when the process restarts, which resets every
+// limit. For short windows that hardly matters, but for long ones, such as
+// daily quotas, it lets a client start fresh by getting the process
+// restarted. A bolt Store writes every change to disk before returning.
+//
+// Each key is stored as one value holding its expiry time and the
+// codec-encoded storage.State. Expiry times are absolute, so TTLs keep
+// running while the process is down; expired keys are ignored on reads
+// and removed by a periodic sweep. Write operations run in bbolt
+// read-write transactions, which bbolt serializes, so Incr and TakeTokens
+// are atomic. Concurrent writes are coalesced into shared transactions to
+// amortize the cost of syncing the file.
+//
+// A database file can only be opened by one process at a time.
+//
+// Example:
+//
+//	store, err := bolt.Open(bolt.Config{Path: "/var/lib/myapp/limits.db"})
+//	if err != nil {
+//	    return err
+//	}
+//	defer store.Close()
+//
+//	quota, err := flexlimit.New(1000, 24*time.Hour, flexlimit.WithStorage(store))
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	bbolt "go.etcd.io/bbolt"
+
+	"github.com/Vipul984/flexlimit/clock"
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+var (
+	_ storage.Storage               = (*Store)(nil)
+	_ storage.TokenBucketBatchStore = (*Store)(nil)
+)
+
+// backendName identifies this backend in storage errors.
+const backendName = "bolt"
+
+// bucketName is the bbolt bucket holding rate limit keys.
+var bucketName = []byte("flexlimit")
+
+// expirySize is the length of the expiry header of stored values.
+const expirySize = 8
+
+// Config configures a bolt Store.
+type Config struct {
+	// Path is the database file, created if it doesn't exist. Required
+	Path string
+
+	// CleanupInterval is how often expired keys are removed from the
+	// file. Default: 5 minutes
+	CleanupInterval time.Duration
+
+	// OpenTimeout bounds how long Open waits for another process to
+	// release the file. Default: 1 second
+	OpenTimeout time.Duration
+
+	// NoSync skips syncing the file after each write. Writes are much
+	// faster, but the last changes may be lost if the machine (not just
+	// the process) crashes. Default: false
+	NoSync bool
+
+	// Codec encodes state in the file, e.g. storage.MsgpackCodec for a
+	// smaller file, or storage.NewAESGCMCodec to encrypt it at rest.
+	// Default: storage.JSONCodec
+	Codec storage.Codec
+
+	// Corruption decides what happens when a stored value fails to decode.
+	// Default: storage.CorruptionFail
+	Corruption storage.CorruptionPolicy
+
+	// Clock is the time source used for TTLs. Default: the system clock
+	Clock clock.Clock
+}
+
+// Store is a storage backend over a bbolt database file.
+//
+// A Store is safe for concurrent use by multiple goroutines.
+type Store struct {
+	db         *bbolt.DB
+	codec      storage.Codec
+	corruption storage.CorruptionPolicy
+	clock      clock.Clock
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// Open opens the database at cfg.Path, creating it if it doesn't exist,
+// and starts the background sweep of expired keys.
+//
+// Returns a *storage.StorageError if the file can't be opened, for
+// example because another process holds it.
+func Open(cfg Config) (*Store, error) {
+	if cfg.Path == "" {
+		return nil, &storage.StorageError{Backend: backendName, Op: "open", Err: "path is required"}
+	}
+	if cfg.CleanupInterval <= 0 {
+		cfg.CleanupInterval = 5 * time.Minute
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = time.Second
+	}
+	if cfg.Codec == nil {
+		cfg.Codec = storage.JSONCodec{}
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = clock.New()
+	}
+
+	db, err := bbolt.Open(cfg.Path, 0o600, &bbolt.Options{Timeout: cfg.OpenTimeout, NoSync: cfg.NoSync})
+	if err != nil {
+		return nil, &storage.StorageError{Backend: backendName, Op: "open", Key: cfg.Path, Err: err}
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketName)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, &storage.StorageError{Backend: backendName, Op: "open", Key: cfg.Path, Err: err}
+	}
+
+	s := &Store{
+		db:         db,
+		codec:      cfg.Codec,
+		corruption: cfg.Corruption,
+		clock:      cfg.Clock,
+		stop:       make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.janitor(cfg.CleanupInterval)
+	return s, nil
+}
+
+// DB returns the underlying database, e.g. to back it up with
+// bbolt.Tx.WriteTo.
+func (s *Store) DB() *bbolt.DB {
+	return s.db
+}
+
+// Get retrieves the state for key.
+func (s *Store) Get(ctx context.Context, key string) (*storage.State, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var state *storage.State
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		state, err = s.load(tx.Bucket(bucketName), key, s.clock.Now())
+		return err
+	})
+	if err != nil {
+		return nil, s.handleCorruption("get", key, err)
+	}
+	if state == nil {
+		return nil, storage.ErrKeyNotFound
+	}
+	return state, nil
+}
+
+// Set replaces the state for key.
+func (s *Store) Set(ctx context.Context, key string, state *storage.State, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err := s.db.Batch(func(tx *bbolt.Tx) error {
+		return s.store(tx.Bucket(bucketName), key, state, s.expiry(ttl))
+	})
+	return wrapError("set", key, err)
+}
+
+// Incr atomically adds amount to the count field of key, creating it with
+// the given TTL if it doesn't exist. An existing key keeps its expiry.
+func (s *Store) Incr(ctx context.Context, key string, amount int64, ttl time.Duration) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var count int64
+	err := s.db.Batch(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketName)
+		now := s.clock.Now()
+
+		state, expiry, err := s.lookup(b, key, now)
+		if err != nil {
+			return err
+		}
+		if state == nil {
+			state, expiry = &storage.State{CreatedAt: now}, s.expiry(ttl)
+		}
+
+		state.Count += amount
+		state.UpdatedAt = now
+		count = state.Count
+		return s.store(b, key, state, expiry)
+	})
+	if err != nil {
+		return 0, s.handleCorruption("incr", key, err)
+	}
+	return count, nil
+}
+
+// Delete removes key.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err := s.db.Batch(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketName).Delete([]byte(key))
+	})
+	return wrapError("delete", key, err)
+}
+
+// Exists reports whether key exists and hasn't expired.
+func (s *Store) Exists(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	var exists bool
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		value := tx.Bucket(bucketName).Get([]byte(key))
+		exists = value != nil && !expired(value, s.clock.Now())
+		return nil
+	})
+	return exists, wrapError("exists", key, err)
+}
+
+// GetMulti retrieves several keys in one read transaction.
+func (s *Store) GetMulti(ctx context.Context, keys []string) ([]*storage.State, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	states := make([]*storage.State, len(keys))
+	var corrupt []string
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketName)
+		now := s.clock.Now()
+
+		for i, key := range keys {
+			state, err := s.load(b, key, now)
+			if errors.Is(err, storage.ErrInvalidState) && s.corruption == storage.CorruptionReset {
+				corrupt = append(corrupt, key)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			states[i] = state
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, wrapError("get_multi", "", err)
+	}
+
+	for _, key := range corrupt {
+		if err := s.Delete(ctx, key); err != nil {
+			return nil, err
+		}
+	}
+	return states, nil
+}
+
+// SetMulti stores several keys in one write transaction.
+func (s *Store) SetMulti(ctx context.Context, states map[string]*storage.State, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketName)
+		expiry := s.expiry(ttl)
+		for key, state := range states {
+			if err := s.store(b, key, state, expiry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return wrapError("set_multi", "", err)
+}
+
+// Keys returns all live keys starting with pattern, ignoring a trailing
+// "*".
+func (s *Store) Keys(ctx context.Context, pattern string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	prefix := []byte(strings.TrimSuffix(pattern, "*"))
+	var keys []string
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		now := s.clock.Now()
+		c := tx.Bucket(bucketName).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			if !expired(v, now) {
+				keys = append(keys, string(k))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, wrapError("keys", "", err)
+	}
+	return keys, nil
+}
+
+// Close stops the sweep and closes the database file.
+// Calling Close more than once is a no-op.
+func (s *Store) Close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.stop)
+		s.wg.Wait()
+		err = wrapError("close", "", s.db.Close())
+	})
+	return err
+}
+
+// Ping checks that the database is open.
+func (s *Store) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return wrapError("ping", "", s.db.View(func(*bbolt.Tx) error { return nil }))
+}
+
+// TakeTokens runs the token bucket cycle in one write transaction.
+func (s *Store) TakeTokens(ctx context.Context, key string, req storage.TokenBucketRequest) (storage.TokenBucketResult, error) {
+	results, err := s.TakeTokensMulti(ctx, []string{key}, []storage.TokenBucketRequest{req})
+	if err != nil {
+		return storage.TokenBucketResult{}, err
+	}
+	return results[0], nil
+}
+
+// TakeTokensMulti runs several token bucket cycles in one write
+// transaction.
+func (s *Store) TakeTokensMulti(ctx context.Context, keys []string, reqs []storage.TokenBucketRequest) ([]storage.TokenBucketResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	results := make([]storage.TokenBucketResult, len(keys))
+	var failed string
+
+	err := s.db.Batch(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketName)
+		for i, key := range keys {
+			res, err := s.takeTokens(b, key, reqs[i])
+			if err != nil {
+				failed = key
+				return err
+			}
+			results[i] = res
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, s.handleCorruption("take_tokens", failed, err)
+	}
+	return results, nil
+}
+
+// takeTokens applies one token bucket operation within a transaction.
+func (s *Store) takeTokens(b *bbolt.Bucket, key string, req storage.TokenBucketRequest) (storage.TokenBucketResult, error) {
+	state, _, err := s.lookup(b, key, s.clock.Now())
+	if err != nil {
+		return storage.TokenBucketResult{}, err
+	}
+
+	tokens, last := req.Capacity, req.Now
+	if state != nil {
+		tokens, last = state.Tokens, state.LastRefill
+	}
+
+	elapsed := max(req.Now.Sub(last), 0)
+	tokens = min(req.Capacity, tokens+elapsed.Seconds()*req.RefillRate)
+
+	res := storage.TokenBucketResult{Tokens: tokens}
+	if req.Cost == 0 || tokens < req.Cost {
+		return res, nil
+	}
+
+	res.Allowed = true
+	res.Tokens = min(req.Capacity, tokens-req.Cost)
+
+	next := &storage.State{Tokens: res.Tokens, LastRefill: req.Now, CreatedAt: req.Now}
+	if state != nil {
+		next.CreatedAt = state.CreatedAt
+	}
+	return res, s.store(b, key, next, s.expiry(req.TTL))
+}
+
+// load returns the live state of key, or nil if it doesn't exist or has
+// expired.
+func (s *Store) load(b *bbolt.Bucket, key string, now time.Time) (*storage.State, error) {
+	state, _, err := s.lookup(b, key, now)
+	return state, err
+}
+
+// lookup returns the live state of key and its expiry in Unix
+// nanoseconds (0 if it doesn't expire), or a nil state if it doesn't
+// exist or has expired.
+func (s *Store) lookup(b *bbolt.Bucket, key string, now time.Time) (*storage.State, int64, error) {
+	value := b.Get([]byte(key))
+	if value == nil || expired(value, now) {
+		return nil, 0, nil
+	}
+
+	state, err := storage.UnmarshalKey(s.codec, key, value[expirySize:])
+	if err != nil {
+		return nil, 0, &storage.StorageError{
+			Backend: backendName,
+			Op:      "deserialize",
+			Key:     key,
+			Err:     &storage.InvalidStateError{Err: err},
+		}
+	}
+	return state, int64(binary.BigEndian.Uint64(value)), nil
+}
+
+// store writes state for key with an expiry in Unix nanoseconds (0 for
+// none).
+func (s *Store) store(b *bbolt.Bucket, key string, state *storage.State, expiry int64) error {
+	blob, err := storage.MarshalKey(s.codec, key, state)
+	if err != nil {
+		return &storage.StorageError{Backend: backendName, Op: "serialize", Key: key, Err: err}
+	}
+
+	value := make([]byte, expirySize+len(blob))
+	binary.BigEndian.PutUint64(value, uint64(expiry))
+	copy(value[expirySize:], blob)
+	return b.Put([]byte(key), value)
+}
+
+// expiry returns the expiry of a key written now with ttl, in Unix
+// nanoseconds, or 0 if ttl is not positive.
+func (s *Store) expiry(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return s.clock.Now().Add(ttl).UnixNano()
+}
+
+// expired reports whether a stored value has expired at now.
+func expired(value []byte, now time.Time) bool {
+	if len(value) < expirySize {
+		return false
+	}
+	expiry := int64(binary.BigEndian.Uint64(value))
+	return expiry != 0 && now.UnixNano() >= expiry
+}
+
+// handleCorruption applies the corruption policy to a decode error, and
+// wraps other errors. Under CorruptionReset the key is deleted and
+// ErrKeyNotFound returned.
+func (s *Store) handleCorruption(op, key string, err error) error {
+	if !errors.Is(err, storage.ErrInvalidState) {
+		return wrapError(op, key, err)
+	}
+	if s.corruption != storage.CorruptionReset {
+		return err
+	}
+	if delErr := s.Delete(context.Background(), key); delErr != nil {
+		return delErr
+	}
+	return storage.ErrKeyNotFound
+}
+
+// janitor removes expired keys every interval until Close.
+func (s *Store) janitor(interval time.Duration) {
+	defer s.wg.Done()
+
+	ticker := s.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C():
+			s.sweep()
+		}
+	}
+}
+
+// sweep removes every expired key.
+func (s *Store) sweep() {
+	_ = s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketName)
+		now := s.clock.Now()
+
+		// Deleting while iterating would skip keys, so collect them first
+		var keys [][]byte
+		b.ForEach(func(k, v []byte) error {
+			if expired(v, now) {
+				keys = append(keys, bytes.Clone(k))
+			}
+			return nil
+		})
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// wrapError converts a bbolt error into a storage error.
+func wrapError(op, key string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var storageErr *storage.StorageError
+	if errors.As(err, &storageErr) {
+		return err
+	}
+	if errors.Is(err, bbolt.ErrDatabaseNotOpen) {
+		return storage.ErrClosed
+	}
+	return &storage.StorageError{Backend: backendName, Op: op, Key: key, Err: err}
+}
@@ -0,0 +1,47 @@
+package bolt_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Vipul984/flexlimit/clock"
+	"github.com/Vipul984/flexlimit/storage"
+	"github.com/Vipul984/flexlimit/storage/bolt"
+	"github.com/Vipul984/flexlimit/storage/storagetest"
+)
+
+func TestStore(t *testing.T) {
+	mock := clock.NewMock()
+	storagetest.TestStorage(t, func(t *testing.T) storage.Storage {
+		store, err := bolt.Open(bolt.Config{
+			Path:   filepath.Join(t.TempDir(), "limits.db"),
+			NoSync: true,
+			Clock:  mock,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}, storagetest.WithAdvance(mock.Advance))
+}
+
+func TestStoreEncrypted(t *testing.T) {
+	codec, err := storage.NewAESGCMCodec(storage.JSONCodec{}, make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock := clock.NewMock()
+	storagetest.TestStorage(t, func(t *testing.T) storage.Storage {
+		store, err := bolt.Open(bolt.Config{
+			Path:   filepath.Join(t.TempDir(), "limits.db"),
+			NoSync: true,
+			Clock:  mock,
+			Codec:  codec,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}, storagetest.WithAdvance(mock.Advance))
+}
@@ -0,0 +1,511 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Vipul984/flexlimit/clock"
+)
+
+var (
+	_ Storage               = (*Cached)(nil)
+	_ TokenBucketBatchStore = (*Cached)(nil)
+	_ SlidingLogStore       = (*Cached)(nil)
+)
+
+// CacheConfig configures a Cached storage.
+type CacheConfig struct {
+	// MaxStaleness is how long a key's state read from the wrapped storage
+	// is used before it is read again. Default: 100 milliseconds
+	MaxStaleness time.Duration
+
+	// SyncInterval is how often the tokens and counts consumed locally are
+	// written to the wrapped storage. Default: MaxStaleness / 2
+	SyncInterval time.Duration
+
+	// MaxKeys bounds the keys kept locally. Default: 10000
+	MaxKeys int
+
+	// Clock is the time source. Default: the system clock
+	Clock clock.Clock
+
+	// OnSyncError is called when writing local consumption to the wrapped
+	// storage fails. The consumption is kept and retried on the next sync
+	OnSyncError func(err error)
+}
+
+// Cached keeps a short-lived local replica of the hot keys of another
+// Storage, such as Redis, and takes it off the critical path of requests:
+// token bucket operations (see TokenBucketStore) and counters (Incr) are
+// decided against the replica, and what they consume is written to the
+// wrapped storage in the background every SyncInterval. A key's replica
+// is read again once it is older than MaxStaleness.
+//
+// The price is accuracy: instances sharing the wrapped storage don't see
+// each other's requests for up to MaxStaleness plus SyncInterval, so
+// together they may allow more than the limit by what they admit in that
+// time. A sync finding that other instances consumed the tokens meanwhile
+// drains the bucket instead of failing.
+//
+// Get is read through the replica and Set, SetMulti and Delete are
+// written through, so one instance always reads its own writes. Sliding
+// window logs (see SlidingLogStore) are not cached: their operations,
+// Keys and Ping go to the wrapped storage. Close writes pending
+// consumption back and closes the wrapped storage.
+//
+// Example:
+//
+//	store := storage.NewCached(redisStore, storage.CacheConfig{
+//	    MaxStaleness: 50 * time.Millisecond,
+//	})
+//	defer store.Close()
+type Cached struct {
+	inner Storage
+	cfg   CacheConfig
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+
+	stop      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// cacheKind is what a cache entry holds.
+type cacheKind int
+
+const (
+	cachedState cacheKind = iota
+	cachedBucket
+	cachedCounter
+)
+
+// cacheEntry is the local replica of one key.
+type cacheEntry struct {
+	kind    cacheKind
+	fetched time.Time // when the entry was read from the wrapped storage
+
+	// cachedState
+	state *State
+
+	// cachedBucket: tokens held by the wrapped storage at refilled, and
+	// the bucket's parameters for syncing
+	tokens   float64
+	refilled time.Time
+	capacity float64
+	rate     float64
+	ttl      time.Duration
+
+	// cachedCounter: the count held by the wrapped storage
+	count int64
+
+	// pending is the cost or count consumed locally and not yet written
+	// to the wrapped storage
+	pending float64
+}
+
+// NewCached returns a Storage caching the hot keys of s locally. It starts
+// a goroutine syncing them until Close.
+func NewCached(s Storage, cfg CacheConfig) *Cached {
+	if cfg.MaxStaleness <= 0 {
+		cfg.MaxStaleness = 100 * time.Millisecond
+	}
+	if cfg.SyncInterval <= 0 {
+		cfg.SyncInterval = cfg.MaxStaleness / 2
+	}
+	if cfg.MaxKeys <= 0 {
+		cfg.MaxKeys = 10000
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = clock.New()
+	}
+
+	c := &Cached{
+		inner:   s,
+		cfg:     cfg,
+		entries: make(map[string]*cacheEntry),
+		stop:    make(chan struct{}),
+	}
+	c.wg.Add(1)
+	go c.syncLoop()
+	return c
+}
+
+// Unwrap returns the wrapped storage.
+func (c *Cached) Unwrap() Storage {
+	return c.inner
+}
+
+// TakeTokens decides a token bucket operation against the local replica
+// of key, reading it from the wrapped storage if it is missing or stale.
+// Returns ErrNotSupported if the wrapped storage doesn't implement
+// TokenBucketStore.
+func (c *Cached) TakeTokens(ctx context.Context, key string, req TokenBucketRequest) (TokenBucketResult, error) {
+	tbs, ok := c.inner.(TokenBucketStore)
+	if !ok {
+		return TokenBucketResult{}, ErrNotSupported
+	}
+
+	now := c.cfg.Clock.Now()
+	c.mu.Lock()
+	e := c.entries[key]
+	if e != nil && e.kind == cachedBucket && c.fresh(e, now) && e.capacity == req.Capacity && e.rate == req.RefillRate {
+		res := e.take(req)
+		c.mu.Unlock()
+		return res, nil
+	}
+	pending := c.takePending(e, cachedBucket)
+	c.mu.Unlock()
+
+	res, err := c.writeTokens(ctx, tbs, key, req, pending)
+	if err != nil {
+		c.restorePending(key, e, pending)
+		return TokenBucketResult{}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e = c.entry(key, cachedBucket)
+	e.fetched, e.tokens, e.refilled = now, res.Tokens, req.Now
+	e.capacity, e.rate, e.ttl = req.Capacity, req.RefillRate, req.TTL
+	return e.take(req), nil
+}
+
+// TakeTokensMulti runs several token bucket operations against the local
+// replica, each atomic on its own.
+func (c *Cached) TakeTokensMulti(ctx context.Context, keys []string, reqs []TokenBucketRequest) ([]TokenBucketResult, error) {
+	results := make([]TokenBucketResult, len(keys))
+	for i, key := range keys {
+		res, err := c.TakeTokens(ctx, key, reqs[i])
+		if err != nil {
+			return nil, err
+		}
+		results[i] = res
+	}
+	return results, nil
+}
+
+// take decides req against the bucket, recording the cost as pending.
+func (e *cacheEntry) take(req TokenBucketRequest) TokenBucketResult {
+	elapsed := max(req.Now.Sub(e.refilled), 0)
+	tokens := math.Min(e.capacity, e.tokens+elapsed.Seconds()*e.rate) - e.pending
+	if req.Cost == 0 || tokens < req.Cost {
+		return TokenBucketResult{Tokens: tokens}
+	}
+	e.pending += req.Cost
+	return TokenBucketResult{Allowed: true, Tokens: math.Min(e.capacity, tokens-req.Cost)}
+}
+
+// writeTokens writes pending tokens consumed locally to key in the
+// wrapped storage and returns the bucket it holds afterwards. If other
+// instances consumed the tokens meanwhile, the bucket is drained.
+func (c *Cached) writeTokens(ctx context.Context, tbs TokenBucketStore, key string, req TokenBucketRequest, pending float64) (TokenBucketResult, error) {
+	req.Cost = pending
+	res, err := tbs.TakeTokens(ctx, key, req)
+	if err != nil || res.Allowed || pending <= 0 || res.Tokens <= 0 {
+		return res, err
+	}
+
+	req.Cost = res.Tokens
+	return tbs.TakeTokens(ctx, key, req)
+}
+
+// TakeSlots runs a sliding window log operation on the wrapped storage,
+// if it supports them, and drops the replica of key.
+func (c *Cached) TakeSlots(ctx context.Context, key string, req SlidingLogRequest) (SlidingLogResult, error) {
+	sls, ok := c.inner.(SlidingLogStore)
+	if !ok {
+		return SlidingLogResult{}, ErrNotSupported
+	}
+
+	c.mu.Lock()
+	delete(c.entries, key)
+	c.mu.Unlock()
+	return sls.TakeSlots(ctx, key, req)
+}
+
+// Incr adds amount to the local replica of key's count, reading it from
+// the wrapped storage if it is missing or stale.
+func (c *Cached) Incr(ctx context.Context, key string, amount int64, ttl time.Duration) (int64, error) {
+	now := c.cfg.Clock.Now()
+	c.mu.Lock()
+	e := c.entries[key]
+	if e != nil && e.kind == cachedCounter && c.fresh(e, now) {
+		e.pending += float64(amount)
+		count := e.count + int64(e.pending)
+		c.mu.Unlock()
+		return count, nil
+	}
+	pending := c.takePending(e, cachedCounter)
+	c.mu.Unlock()
+
+	count, err := c.inner.Incr(ctx, key, int64(pending)+amount, ttl)
+	if err != nil {
+		c.restorePending(key, e, pending)
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e = c.entry(key, cachedCounter)
+	e.fetched, e.count, e.ttl = now, count, ttl
+	return count + int64(e.pending), nil
+}
+
+// Get retrieves the state for key through the local replica. Pending
+// consumption of key is written to the wrapped storage first.
+func (c *Cached) Get(ctx context.Context, key string) (*State, error) {
+	if err := c.syncKey(ctx, key); err != nil {
+		return nil, err
+	}
+
+	now := c.cfg.Clock.Now()
+	c.mu.Lock()
+	if e := c.entries[key]; e != nil && e.kind == cachedState && c.fresh(e, now) {
+		st := copyState(e.state)
+		c.mu.Unlock()
+		return st, nil
+	}
+	c.mu.Unlock()
+
+	st, err := c.inner.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	c.remember(key, st, now)
+	return st, nil
+}
+
+// Set writes the state for key to the wrapped storage and the replica,
+// dropping pending consumption of key.
+func (c *Cached) Set(ctx context.Context, key string, state *State, ttl time.Duration) error {
+	if err := c.inner.Set(ctx, key, state, ttl); err != nil {
+		return err
+	}
+	c.remember(key, state, c.cfg.Clock.Now())
+	return nil
+}
+
+// Delete removes key from the wrapped storage and the replica, dropping
+// pending consumption of key.
+func (c *Cached) Delete(ctx context.Context, key string) error {
+	c.mu.Lock()
+	delete(c.entries, key)
+	c.mu.Unlock()
+	return c.inner.Delete(ctx, key)
+}
+
+// Exists reports whether key exists in the wrapped storage, once pending
+// consumption of key is written to it.
+func (c *Cached) Exists(ctx context.Context, key string) (bool, error) {
+	if err := c.syncKey(ctx, key); err != nil {
+		return false, err
+	}
+	return c.inner.Exists(ctx, key)
+}
+
+// GetMulti retrieves the state for several keys through the local
+// replica.
+func (c *Cached) GetMulti(ctx context.Context, keys []string) ([]*State, error) {
+	states := make([]*State, len(keys))
+	for i, key := range keys {
+		st, err := c.Get(ctx, key)
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			return nil, err
+		}
+		states[i] = st
+	}
+	return states, nil
+}
+
+// SetMulti writes several states to the wrapped storage and the replica.
+func (c *Cached) SetMulti(ctx context.Context, states map[string]*State, ttl time.Duration) error {
+	if err := c.inner.SetMulti(ctx, states, ttl); err != nil {
+		return err
+	}
+	now := c.cfg.Clock.Now()
+	for key, state := range states {
+		c.remember(key, state, now)
+	}
+	return nil
+}
+
+// Keys returns the keys of the wrapped storage matching pattern.
+func (c *Cached) Keys(ctx context.Context, pattern string) ([]string, error) {
+	return c.inner.Keys(ctx, pattern)
+}
+
+// Ping pings the wrapped storage.
+func (c *Cached) Ping(ctx context.Context) error {
+	return c.inner.Ping(ctx)
+}
+
+// Flush writes the consumption of every key to the wrapped storage now,
+// instead of at the next sync. It returns the first error, keeping the
+// consumption that couldn't be written for the next sync.
+func (c *Cached) Flush(ctx context.Context) error {
+	c.mu.Lock()
+	keys := make([]string, 0, len(c.entries))
+	for key, e := range c.entries {
+		if e.pending != 0 {
+			keys = append(keys, key)
+		}
+	}
+	c.mu.Unlock()
+
+	var first error
+	for _, key := range keys {
+		if err := c.syncKey(ctx, key); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Close stops the sync goroutine, flushes pending consumption and closes
+// the wrapped storage.
+func (c *Cached) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		c.wg.Wait()
+
+		flushErr := c.Flush(context.Background())
+		err = errors.Join(flushErr, c.inner.Close())
+	})
+	return err
+}
+
+// fresh reports whether e may still be used at now.
+func (c *Cached) fresh(e *cacheEntry, now time.Time) bool {
+	return now.Sub(e.fetched) < c.cfg.MaxStaleness
+}
+
+// entry returns the entry of key holding kind, replacing one holding
+// something else. Must be called with c.mu held.
+func (c *Cached) entry(key string, kind cacheKind) *cacheEntry {
+	e := c.entries[key]
+	if e != nil && e.kind == kind {
+		return e
+	}
+	if e == nil {
+		c.makeRoom()
+	}
+	e = &cacheEntry{kind: kind}
+	c.entries[key] = e
+	return e
+}
+
+// remember caches a copy of state as the replica of key.
+func (c *Cached) remember(key string, state *State, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entry(key, cachedState)
+	e.state, e.fetched = copyState(state), now
+}
+
+// takePending returns the pending consumption of e, if it holds kind, and
+// clears it. Must be called with c.mu held.
+func (c *Cached) takePending(e *cacheEntry, kind cacheKind) float64 {
+	if e == nil || e.kind != kind {
+		return 0
+	}
+	pending := e.pending
+	e.pending = 0
+	return pending
+}
+
+// restorePending puts back pending consumption of key taken from e that
+// couldn't be written.
+func (c *Cached) restorePending(key string, e *cacheEntry, pending float64) {
+	if pending == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries[key] == e {
+		e.pending += pending
+	}
+}
+
+// makeRoom drops keys without pending consumption once the cache holds
+// MaxKeys, a sixteenth of MaxKeys at a time. Must be called with c.mu
+// held.
+func (c *Cached) makeRoom() {
+	if len(c.entries) < c.cfg.MaxKeys {
+		return
+	}
+	target := c.cfg.MaxKeys - max(c.cfg.MaxKeys/16, 1)
+	for key, e := range c.entries {
+		if len(c.entries) <= target {
+			return
+		}
+		if e.pending == 0 {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// syncKey writes the pending consumption of key to the wrapped storage.
+func (c *Cached) syncKey(ctx context.Context, key string) error {
+	now := c.cfg.Clock.Now()
+	c.mu.Lock()
+	e := c.entries[key]
+	if e == nil || e.pending == 0 {
+		c.mu.Unlock()
+		return nil
+	}
+	pending := c.takePending(e, e.kind)
+	req := TokenBucketRequest{Capacity: e.capacity, RefillRate: e.rate, Now: now, TTL: e.ttl}
+	ttl := e.ttl
+	c.mu.Unlock()
+
+	var err error
+	switch e.kind {
+	case cachedBucket:
+		var res TokenBucketResult
+		if res, err = c.writeTokens(ctx, c.inner.(TokenBucketStore), key, req, pending); err == nil {
+			c.mu.Lock()
+			if c.entries[key] == e {
+				e.fetched, e.tokens, e.refilled = now, res.Tokens, now
+			}
+			c.mu.Unlock()
+		}
+	case cachedCounter:
+		var count int64
+		if count, err = c.inner.Incr(ctx, key, int64(pending), ttl); err == nil {
+			c.mu.Lock()
+			if c.entries[key] == e {
+				e.fetched, e.count = now, count
+			}
+			c.mu.Unlock()
+		}
+	}
+	if err != nil {
+		c.restorePending(key, e, pending)
+	}
+	return err
+}
+
+// syncLoop writes pending consumption back every SyncInterval until
+// Close.
+func (c *Cached) syncLoop() {
+	defer c.wg.Done()
+
+	ticker := c.cfg.Clock.NewTicker(c.cfg.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C():
+			if err := c.Flush(context.Background()); err != nil && c.cfg.OnSyncError != nil {
+				c.cfg.OnSyncError(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// checksumSize is the length of the CRC-32C trailer.
+const checksumSize = 4
+
+// castagnoli is the CRC-32C table, which has hardware support on most CPUs.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ChecksumCodec appends a CRC-32C checksum to the output of another Codec
+// and verifies it on read.
+//
+// Without it, a truncated or bit-flipped value may still parse and be
+// silently mis-read as valid state. With it, corruption surfaces as a
+// *ChecksumError that matches ErrInvalidState.
+//
+// Encrypting codecs (AEADCodec) already authenticate their data and don't
+// need a checksum.
+type ChecksumCodec struct {
+	inner Codec
+}
+
+var _ KeyedCodec = (*ChecksumCodec)(nil)
+
+// NewChecksumCodec wraps inner with checksum validation. A nil inner
+// defaults to JSONCodec.
+//
+// Example:
+//
+//	store, err := redis.New(cfg,
+//	    redis.WithCodec(storage.NewChecksumCodec(storage.JSONCodec{})),
+//	    redis.WithCorruptionPolicy(storage.CorruptionReset),
+//	)
+func NewChecksumCodec(inner Codec) *ChecksumCodec {
+	if inner == nil {
+		inner = JSONCodec{}
+	}
+	return &ChecksumCodec{inner: inner}
+}
+
+// Marshal encodes state and appends its checksum.
+func (c *ChecksumCodec) Marshal(state *State) ([]byte, error) {
+	return c.seal(c.inner.Marshal(state))
+}
+
+// MarshalKey encodes state stored under key, binding it to key if the
+// inner codec does, and appends its checksum.
+func (c *ChecksumCodec) MarshalKey(key string, state *State) ([]byte, error) {
+	return c.seal(MarshalKey(c.inner, key, state))
+}
+
+// seal appends the checksum of data.
+func (c *ChecksumCodec) seal(data []byte, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	return binary.BigEndian.AppendUint32(data, crc32.Checksum(data, castagnoli)), nil
+}
+
+// Unmarshal verifies the checksum and decodes the state.
+func (c *ChecksumCodec) Unmarshal(data []byte) (*State, error) {
+	payload, err := c.verify(data)
+	if err != nil {
+		return nil, err
+	}
+	return c.inner.Unmarshal(payload)
+}
+
+// UnmarshalKey verifies the checksum and decodes the state stored under
+// key.
+func (c *ChecksumCodec) UnmarshalKey(key string, data []byte) (*State, error) {
+	payload, err := c.verify(data)
+	if err != nil {
+		return nil, err
+	}
+	return UnmarshalKey(c.inner, key, payload)
+}
+
+// verify checks the checksum of data and returns the payload it covers.
+func (c *ChecksumCodec) verify(data []byte) ([]byte, error) {
+	if len(data) < checksumSize {
+		return nil, &ChecksumError{Length: len(data)}
+	}
+
+	payload := data[:len(data)-checksumSize]
+	expected := binary.BigEndian.Uint32(data[len(payload):])
+	actual := crc32.Checksum(payload, castagnoli)
+	if expected != actual {
+		return nil, &ChecksumError{
+			Expected: expected,
+			Actual:   actual,
+			Length:   len(data),
+		}
+	}
+	return payload, nil
+}
+
+// ChecksumError reports stored state whose checksum doesn't match its
+// contents. It matches ErrInvalidState with errors.Is.
+//
+// Backends wrap it in a *StorageError carrying the key and backend name.
+//
+// Example:
+//
+//	var sumErr *storage.ChecksumError
+//	if errors.As(err, &sumErr) {
+//	    log.Error("corrupt rate limit state",
+//	        "expected", sumErr.Expected, "actual", sumErr.Actual)
+//	}
+type ChecksumError struct {
+	// Expected is the checksum stored with the data
+	Expected uint32
+
+	// Actual is the checksum computed from the data as read
+	Actual uint32
+
+	// Length is the size of the stored value in bytes
+	Length int
+}
+
+// Error implements the error interface.
+func (e *ChecksumError) Error() string {
+	if e.Length < checksumSize {
+		return fmt.Sprintf("state too short for checksum (%d bytes)", e.Length)
+	}
+	return fmt.Sprintf("state checksum mismatch: expected %08x, actual %08x (%d bytes)",
+		e.Expected, e.Actual, e.Length)
+}
+
+// Is allows matching with errors.Is(err, ErrInvalidState).
+func (e *ChecksumError) Is(target error) bool {
+	return target == ErrInvalidState
+}
+
+// CorruptionPolicy decides what a backend does when stored state fails to
+// decode or validate.
+type CorruptionPolicy int
+
+const (
+	// CorruptionFail returns the ErrInvalidState error to the caller.
+	// This is the default.
+	CorruptionFail CorruptionPolicy = iota
+
+	// CorruptionReset deletes the corrupt key and reports it as missing,
+	// so the key starts over with a fresh state.
+	CorruptionReset
+)
+
+// String returns the policy name.
+func (p CorruptionPolicy) String() string {
+	switch p {
+	case CorruptionReset:
+		return "reset"
+	default:
+		return "fail"
+	}
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec serializes State for backends that store it as an opaque blob.
+//
+// Implementations must be safe for concurrent use.
+type Codec interface {
+	// Marshal encodes state.
+	Marshal(state *State) ([]byte, error)
+
+	// Unmarshal decodes data produced by Marshal.
+	Unmarshal(data []byte) (*State, error)
+}
+
+// KeyedCodec is implemented by codecs that bind state to the key it is
+// stored under, such as AEADCodec, so a value copied to another key fails
+// to decode. Backends storing state as a blob encode it with MarshalKey
+// and decode it with UnmarshalKey.
+type KeyedCodec interface {
+	Codec
+
+	// MarshalKey encodes state stored under key.
+	MarshalKey(key string, state *State) ([]byte, error)
+
+	// UnmarshalKey decodes data produced by MarshalKey for key.
+	UnmarshalKey(key string, data []byte) (*State, error)
+}
+
+// MarshalKey encodes state stored under key with c, binding it to key if
+// c is a KeyedCodec.
+func MarshalKey(c Codec, key string, state *State) ([]byte, error) {
+	if kc, ok := c.(KeyedCodec); ok {
+		return kc.MarshalKey(key, state)
+	}
+	return c.Marshal(state)
+}
+
+// UnmarshalKey decodes data stored under key with c, checking it is bound
+// to key if c is a KeyedCodec.
+func UnmarshalKey(c Codec, key string, data []byte) (*State, error) {
+	if kc, ok := c.(KeyedCodec); ok {
+		return kc.UnmarshalKey(key, data)
+	}
+	return c.Unmarshal(data)
+}
+
+// NewCodec returns the codec named name: "json", "gob", "msgpack" or
+// "protobuf". It is how Config.Codec is resolved.
+func NewCodec(name string) (Codec, error) {
+	switch name {
+	case "json":
+		return JSONCodec{}, nil
+	case "gob":
+		return GobCodec{}, nil
+	case "msgpack":
+		return MsgpackCodec{}, nil
+	case "protobuf":
+		return ProtoCodec{}, nil
+	}
+	return nil, &StorageError{Op: "codec", Err: fmt.Sprintf("unknown codec %q (want json, gob, msgpack or protobuf)", name)}
+}
+
+// JSONCodec encodes State as JSON.
+type JSONCodec struct{}
+
+// Marshal encodes state as JSON.
+func (JSONCodec) Marshal(state *State) ([]byte, error) {
+	return json.Marshal(state)
+}
+
+// Unmarshal decodes JSON produced by Marshal.
+func (JSONCodec) Unmarshal(data []byte) (*State, error) {
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// GobCodec encodes State with encoding/gob.
+//
+// Each blob carries the description of the State type, which makes small
+// states larger than with JSON; gob pays off for sliding window states
+// holding many timestamps, which it encodes more compactly. Metadata
+// values must be of types known to gob (see gob.Register).
+type GobCodec struct{}
+
+// Marshal encodes state with gob.
+func (GobCodec) Marshal(state *State) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(state); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes gob produced by Marshal.
+func (GobCodec) Unmarshal(data []byte) (*State, error) {
+	var state State
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// MsgpackCodec encodes State as MessagePack: the smallest and fastest of
+// the built-in codecs, at the cost of blobs that can't be read by eye.
+type MsgpackCodec struct{}
+
+// Marshal encodes state as MessagePack.
+func (MsgpackCodec) Marshal(state *State) ([]byte, error) {
+	return msgpack.Marshal(state)
+}
+
+// Unmarshal decodes MessagePack produced by Marshal.
+func (MsgpackCodec) Unmarshal(data []byte) (*State, error) {
+	var state State
+	if err := msgpack.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// Versions of the blobs written by AEADCodec, their first byte: blobs of
+// keyedVersion authenticate the key they are stored under as additional
+// data, blobs of encryptedVersion don't.
+const (
+	encryptedVersion byte = 1
+	keyedVersion     byte = 2
+)
+
+// AEADCodec encrypts the output of another Codec with an AEAD cipher.
+//
+// Use it with shared backends when rate limit state (which reveals user
+// identifiers' usage patterns) must not be readable by anyone with access
+// to the backend. Only values are encrypted; keys are stored as given.
+//
+// Backends bind each value to its key (see KeyedCodec): the key is
+// authenticated as additional data, so a value copied to another key, to
+// hand one user the state of another, fails to decrypt. Values encoded
+// with Marshal, outside a backend, are not bound to a key and must be
+// decoded with Unmarshal; values written before keys were bound are still
+// read under any key until they expire.
+//
+// Blobs are laid out as version (1 byte) | nonce | ciphertext. Decryption
+// tries the current cipher first and then each previous one, so keys can be
+// rotated without resetting state: deploy with the new key as current and
+// the old key as previous, and drop the old key once all state written
+// with it has expired.
+type AEADCodec struct {
+	inner    Codec
+	current  cipher.AEAD
+	previous []cipher.AEAD
+}
+
+var _ KeyedCodec = (*AEADCodec)(nil)
+
+// NewAEADCodec creates a codec that encrypts inner's output with current.
+// previous ciphers are only used for decryption.
+func NewAEADCodec(inner Codec, current cipher.AEAD, previous ...cipher.AEAD) *AEADCodec {
+	if inner == nil {
+		inner = JSONCodec{}
+	}
+	return &AEADCodec{
+		inner:    inner,
+		current:  current,
+		previous: previous,
+	}
+}
+
+// NewAESGCMCodec creates an AEADCodec using AES-GCM.
+//
+// key must be 16, 24 or 32 bytes (AES-128, AES-192 or AES-256).
+// previousKeys are accepted for decryption only, for key rotation.
+//
+// Example:
+//
+//	codec, err := storage.NewAESGCMCodec(storage.JSONCodec{}, key)
+//	if err != nil {
+//	    return err
+//	}
+//	store, err := redis.New(cfg, redis.WithCodec(codec))
+func NewAESGCMCodec(inner Codec, key []byte, previousKeys ...[]byte) (*AEADCodec, error) {
+	current, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := make([]cipher.AEAD, len(previousKeys))
+	for i, k := range previousKeys {
+		if previous[i], err = newAESGCM(k); err != nil {
+			return nil, err
+		}
+	}
+
+	return NewAEADCodec(inner, current, previous...), nil
+}
+
+// Marshal encodes state with the inner codec and encrypts the result,
+// without binding it to a key.
+func (c *AEADCodec) Marshal(state *State) ([]byte, error) {
+	return c.seal(encryptedVersion, nil, state)
+}
+
+// MarshalKey encodes state with the inner codec and encrypts the result,
+// binding it to key.
+func (c *AEADCodec) MarshalKey(key string, state *State) ([]byte, error) {
+	return c.seal(keyedVersion, []byte(key), state)
+}
+
+// seal encodes state and encrypts it into a blob of version, with
+// additional data ad.
+func (c *AEADCodec) seal(version byte, ad []byte, state *State) ([]byte, error) {
+	plaintext, err := c.inner.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := c.current.NonceSize()
+	out := make([]byte, 1+nonceSize, 1+nonceSize+len(plaintext)+c.current.Overhead())
+	out[0] = version
+	if _, err := rand.Read(out[1:]); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+
+	return c.current.Seal(out, out[1:], plaintext, ad), nil
+}
+
+// Unmarshal decrypts data produced by Marshal and decodes it with the
+// inner codec.
+func (c *AEADCodec) Unmarshal(data []byte) (*State, error) {
+	if len(data) > 0 && data[0] == keyedVersion {
+		return nil, errors.New("decrypt state: state is bound to its key")
+	}
+	return c.open(data, nil)
+}
+
+// UnmarshalKey decrypts data stored under key and decodes it with the
+// inner codec.
+func (c *AEADCodec) UnmarshalKey(key string, data []byte) (*State, error) {
+	return c.open(data, []byte(key))
+}
+
+// open decrypts data and decodes it with the inner codec. ad is the
+// additional data of blobs of keyedVersion.
+func (c *AEADCodec) open(data, ad []byte) (*State, error) {
+	if len(data) == 0 || (data[0] != encryptedVersion && data[0] != keyedVersion) {
+		return nil, errors.New("unknown encrypted state version")
+	}
+	if data[0] == encryptedVersion {
+		ad = nil
+	}
+
+	for _, aead := range append([]cipher.AEAD{c.current}, c.previous...) {
+		nonceSize := aead.NonceSize()
+		if len(data) < 1+nonceSize {
+			continue
+		}
+
+		plaintext, err := aead.Open(nil, data[1:1+nonceSize], data[1+nonceSize:], ad)
+		if err == nil {
+			return c.inner.Unmarshal(plaintext)
+		}
+	}
+
+	return nil, errors.New("decrypt state: no key could authenticate the data")
+}
+
+// newAESGCM creates an AES-GCM AEAD from key.
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
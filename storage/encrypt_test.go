@@ -0,0 +1,70 @@
+package storage_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+func newAESGCMCodec(t *testing.T, inner storage.Codec) *storage.AEADCodec {
+	t.Helper()
+	codec, err := storage.NewAESGCMCodec(inner, bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return codec
+}
+
+func TestAEADCodecBindsKey(t *testing.T) {
+	codecs := map[string]storage.Codec{
+		"aead":          newAESGCMCodec(t, storage.JSONCodec{}),
+		"checksum_aead": storage.NewChecksumCodec(newAESGCMCodec(t, storage.JSONCodec{})),
+	}
+	for name, codec := range codecs {
+		t.Run(name, func(t *testing.T) {
+			blob, err := storage.MarshalKey(codec, "user:1", &storage.State{Count: 3})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			st, err := storage.UnmarshalKey(codec, "user:1", blob)
+			if err != nil {
+				t.Fatalf("UnmarshalKey under its own key: %v", err)
+			}
+			if st.Count != 3 {
+				t.Errorf("Count = %d, want 3", st.Count)
+			}
+
+			if _, err := storage.UnmarshalKey(codec, "user:2", blob); err == nil {
+				t.Error("UnmarshalKey under another key succeeded, want an error")
+			}
+			if _, err := codec.Unmarshal(blob); err == nil {
+				t.Error("Unmarshal of a keyed blob succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestAEADCodecUnkeyed(t *testing.T) {
+	codec := newAESGCMCodec(t, storage.JSONCodec{})
+
+	// Blobs encoded without a key, as before keys were bound, still
+	// decode, under any key.
+	blob, err := codec.Marshal(&storage.State{Count: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, decode := range []func() (*storage.State, error){
+		func() (*storage.State, error) { return codec.Unmarshal(blob) },
+		func() (*storage.State, error) { return codec.UnmarshalKey("user:1", blob) },
+	} {
+		st, err := decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Count != 5 {
+			t.Errorf("Count = %d, want 5", st.Count)
+		}
+	}
+}
@@ -0,0 +1,511 @@
+// Package etcd provides an etcd-backed Storage for control-plane rate
+// limiting, where request rates are low but limits must hold exactly and
+// survive the loss of any single node.
+//
+// Each key is stored as one etcd value holding the codec-encoded
+// storage.State. Read-modify-write operations (Incr, the token bucket
+// refill-and-consume cycle) run as compare-and-swap transactions on the
+// key's revision, retried under contention, so they are atomic across
+// every instance sharing the cluster. TTLs are implemented with etcd
+// leases.
+//
+// etcd is a consensus store: every write goes through the Raft log, so it
+// suits limits guarding admin APIs, deployments or job schedulers rather
+// than high-volume request paths, where the redis package fits better.
+//
+// Example:
+//
+//	store, err := etcd.New(storage.Config{
+//	    EtcdEndpoints: []string{"localhost:2379"},
+//	})
+//	if err != nil {
+//	    return err
+//	}
+//	limiter, err := flexlimit.New(10, time.Minute, flexlimit.WithStorage(store))
+package etcd
+
+import (
+	"context"
+	"errors"
+	"hash/maphash"
+	"math"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+var (
+	_ storage.Storage               = (*Store)(nil)
+	_ storage.TokenBucketBatchStore = (*Store)(nil)
+)
+
+// backendName identifies this backend in storage errors, and names it in
+// storage.Open.
+const backendName = "etcd"
+
+func init() {
+	storage.Register(backendName, func(cfg storage.Config) (storage.Storage, error) {
+		s, err := New(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	})
+}
+
+// maxTxRetries bounds compare-and-swap retries under contention.
+const maxTxRetries = 16
+
+// lockStripes is the number of locks serializing updates of the same key
+// within a process.
+const lockStripes = 64
+
+// maxTxnOps is the number of operations put in one transaction, below
+// etcd's default limit of 128.
+const maxTxnOps = 100
+
+// Store is a Storage backed by etcd.
+type Store struct {
+	client     *clientv3.Client
+	ownsClient bool
+	prefix     string
+	codec      storage.Codec
+	corruption storage.CorruptionPolicy
+
+	// locks serialize read-modify-write cycles on the same key within the
+	// process, so compare-and-swap retries are only spent on contention
+	// with other processes
+	seed  maphash.Seed
+	locks [lockStripes]sync.Mutex
+}
+
+// New connects to etcd using the Etcd* and timeout fields of cfg.
+//
+// The connection is verified with a read bounded by ConnectTimeout
+// (default 5 seconds).
+func New(cfg storage.Config, opts ...Option) (*Store, error) {
+	if cfg.Codec != "" {
+		codec, err := storage.NewCodec(cfg.Codec)
+		if err != nil {
+			return nil, err
+		}
+		opts = append([]Option{WithCodec(codec)}, opts...)
+	}
+
+	timeout := cfg.ConnectTimeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   cfg.EtcdEndpoints,
+		Username:    cfg.EtcdUsername,
+		Password:    cfg.EtcdPassword,
+		DialTimeout: timeout,
+	})
+	if err != nil {
+		return nil, &storage.StorageError{Backend: backendName, Op: "connect", Err: err}
+	}
+
+	s := NewFromClient(client, opts...)
+	s.ownsClient = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := s.Ping(ctx); err != nil {
+		client.Close()
+		return nil, &storage.StorageError{Backend: backendName, Op: "connect", Err: err}
+	}
+	return s, nil
+}
+
+// NewFromClient creates a Store over an existing client.
+//
+// The client is not closed by Close; the caller keeps ownership.
+func NewFromClient(client *clientv3.Client, opts ...Option) *Store {
+	s := &Store{
+		client: client,
+		prefix: "/flexlimit/",
+		codec:  storage.JSONCodec{},
+		seed:   maphash.MakeSeed(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Client returns the underlying etcd client.
+func (s *Store) Client() *clientv3.Client {
+	return s.client
+}
+
+// Get retrieves the state for key.
+func (s *Store) Get(ctx context.Context, key string) (*storage.State, error) {
+	state, _, err := s.get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, storage.ErrKeyNotFound
+	}
+	return state, nil
+}
+
+// Set replaces the state for key. A positive ttl is rounded up to whole
+// seconds, the granularity of etcd leases.
+func (s *Store) Set(ctx context.Context, key string, state *storage.State, ttl time.Duration) error {
+	value, err := s.encode(key, state)
+	if err != nil {
+		return err
+	}
+
+	opts, err := s.leaseOpts(ctx, ttl)
+	if err != nil {
+		return wrapError("set", key, err)
+	}
+
+	_, err = s.client.Put(ctx, s.prefix+key, value, opts...)
+	return wrapError("set", key, err)
+}
+
+// Incr atomically adds amount to the count field of key, creating it with
+// the given TTL if it doesn't exist. An existing key keeps its lease.
+func (s *Store) Incr(ctx context.Context, key string, amount int64, ttl time.Duration) (int64, error) {
+	var count int64
+
+	err := s.update(ctx, "incr", key, func(state *storage.State, found bool) (*storage.State, time.Duration, bool) {
+		if !found {
+			state = &storage.State{}
+		}
+		state.Count += amount
+		count = state.Count
+		return state, ttl, true
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// Delete removes key.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	_, err := s.client.Delete(ctx, s.prefix+key)
+	return wrapError("delete", key, err)
+}
+
+// Exists reports whether key exists.
+func (s *Store) Exists(ctx context.Context, key string) (bool, error) {
+	resp, err := s.client.Get(ctx, s.prefix+key, clientv3.WithCountOnly())
+	if err != nil {
+		return false, wrapError("exists", key, err)
+	}
+	return resp.Count > 0, nil
+}
+
+// GetMulti retrieves several keys, reading up to 100 keys per round trip
+// from a single revision.
+func (s *Store) GetMulti(ctx context.Context, keys []string) ([]*storage.State, error) {
+	states := make([]*storage.State, len(keys))
+
+	for start := 0; start < len(keys); start += maxTxnOps {
+		chunk := keys[start:min(start+maxTxnOps, len(keys))]
+
+		ops := make([]clientv3.Op, len(chunk))
+		for i, key := range chunk {
+			ops[i] = clientv3.OpGet(s.prefix + key)
+		}
+		resp, err := s.client.Txn(ctx).Then(ops...).Commit()
+		if err != nil {
+			return nil, wrapError("get_multi", "", err)
+		}
+
+		for i, r := range resp.Responses {
+			kvs := r.GetResponseRange().Kvs
+			if len(kvs) == 0 {
+				continue
+			}
+			key := chunk[i]
+			if states[start+i], err = s.decode(key, kvs[0].Value); err != nil {
+				if err = s.handleCorruption(ctx, key, err); !errors.Is(err, storage.ErrKeyNotFound) {
+					return nil, err
+				}
+			}
+		}
+	}
+	return states, nil
+}
+
+// SetMulti stores several keys sharing one lease, in transactions of up to
+// 100 keys.
+func (s *Store) SetMulti(ctx context.Context, states map[string]*storage.State, ttl time.Duration) error {
+	opts, err := s.leaseOpts(ctx, ttl)
+	if err != nil {
+		return wrapError("set_multi", "", err)
+	}
+
+	ops := make([]clientv3.Op, 0, min(len(states), maxTxnOps))
+	flush := func() error {
+		if len(ops) == 0 {
+			return nil
+		}
+		_, err := s.client.Txn(ctx).Then(ops...).Commit()
+		ops = ops[:0]
+		return wrapError("set_multi", "", err)
+	}
+
+	for key, state := range states {
+		value, err := s.encode(key, state)
+		if err != nil {
+			return err
+		}
+		ops = append(ops, clientv3.OpPut(s.prefix+key, value, opts...))
+		if len(ops) == maxTxnOps {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// Keys returns all keys matching a glob pattern (see path.Match). Keys
+// are listed by the pattern's literal prefix, so patterns starting with a
+// wildcard read the whole store prefix.
+func (s *Store) Keys(ctx context.Context, pattern string) ([]string, error) {
+	if pattern == "" {
+		pattern = "*"
+	}
+	literal := pattern
+	if i := strings.IndexAny(pattern, "*?[\\"); i >= 0 {
+		literal = pattern[:i]
+	}
+
+	resp, err := s.client.Get(ctx, s.prefix+literal, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return nil, wrapError("keys", "", err)
+	}
+
+	keys := make([]string, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		key := strings.TrimPrefix(string(kv.Key), s.prefix)
+		if ok, _ := path.Match(pattern, key); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// Close closes the client if the Store created it.
+func (s *Store) Close() error {
+	if !s.ownsClient {
+		return nil
+	}
+	return s.client.Close()
+}
+
+// Ping checks that a quorum of the etcd cluster is reachable.
+func (s *Store) Ping(ctx context.Context) error {
+	_, err := s.client.Get(ctx, s.prefix, clientv3.WithCountOnly())
+	return wrapError("ping", "", err)
+}
+
+// TakeTokens runs the token bucket cycle atomically as a compare-and-swap
+// transaction on key.
+func (s *Store) TakeTokens(ctx context.Context, key string, req storage.TokenBucketRequest) (storage.TokenBucketResult, error) {
+	var res storage.TokenBucketResult
+
+	err := s.update(ctx, "take_tokens", key, func(state *storage.State, found bool) (*storage.State, time.Duration, bool) {
+		tokens, last := req.Capacity, req.Now
+		if found {
+			tokens, last = state.Tokens, state.LastRefill
+		}
+
+		elapsed := max(req.Now.Sub(last), 0)
+		tokens = min(req.Capacity, tokens+elapsed.Seconds()*req.RefillRate)
+
+		res = storage.TokenBucketResult{Tokens: tokens}
+		if req.Cost == 0 || tokens < req.Cost {
+			return nil, 0, false
+		}
+
+		res.Allowed = true
+		res.Tokens = min(req.Capacity, tokens-req.Cost)
+
+		next := &storage.State{Tokens: res.Tokens, LastRefill: req.Now, CreatedAt: req.Now}
+		if found {
+			next.CreatedAt = state.CreatedAt
+		}
+		return next, req.TTL, true
+	})
+	if err != nil {
+		return storage.TokenBucketResult{}, err
+	}
+	return res, nil
+}
+
+// TakeTokensMulti runs TakeTokens for each key in turn. Each operation is
+// its own transaction.
+func (s *Store) TakeTokensMulti(ctx context.Context, keys []string, reqs []storage.TokenBucketRequest) ([]storage.TokenBucketResult, error) {
+	results := make([]storage.TokenBucketResult, len(keys))
+	for i, key := range keys {
+		res, err := s.TakeTokens(ctx, key, reqs[i])
+		if err != nil {
+			return nil, err
+		}
+		results[i] = res
+	}
+	return results, nil
+}
+
+// update runs a compare-and-swap cycle on key: fn receives the current
+// state (found is false if the key doesn't exist) and returns the state to
+// write, the TTL to give a newly created key, and whether to write at
+// all. The write only succeeds if key wasn't modified since it was read;
+// otherwise the cycle is retried.
+//
+// A key that already exists keeps its lease.
+func (s *Store) update(ctx context.Context, op, key string, fn func(state *storage.State, found bool) (*storage.State, time.Duration, bool)) error {
+	mu := &s.locks[maphash.String(s.seed, key)%lockStripes]
+	mu.Lock()
+	defer mu.Unlock()
+
+	state, rev, err := s.get(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < maxTxRetries; i++ {
+		next, ttl, write := fn(state, state != nil)
+		if !write {
+			return nil
+		}
+
+		value, err := s.encode(key, next)
+		if err != nil {
+			return err
+		}
+
+		opts := []clientv3.OpOption{clientv3.WithIgnoreLease()}
+		if state == nil {
+			if opts, err = s.leaseOpts(ctx, ttl); err != nil {
+				return wrapError(op, key, err)
+			}
+		}
+
+		k := s.prefix + key
+		resp, err := s.client.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(k), "=", rev)).
+			Then(clientv3.OpPut(k, value, opts...)).
+			Else(clientv3.OpGet(k)).
+			Commit()
+		if err != nil {
+			return wrapError(op, key, err)
+		}
+		if resp.Succeeded {
+			return nil
+		}
+
+		// Another process modified key; retry on the value it wrote
+		if state, rev, err = s.parse(ctx, key, resp.Responses[0].GetResponseRange().Kvs); err != nil {
+			return err
+		}
+	}
+
+	return &storage.StorageError{Backend: backendName, Op: op, Key: key, Err: "too much contention"}
+}
+
+// get reads key, returning its state and modification revision, or a nil
+// state and revision 0 if it doesn't exist. Corrupt state is handled by
+// the corruption policy.
+func (s *Store) get(ctx context.Context, key string) (*storage.State, int64, error) {
+	resp, err := s.client.Get(ctx, s.prefix+key)
+	if err != nil {
+		return nil, 0, wrapError("get", key, err)
+	}
+	return s.parse(ctx, key, resp.Kvs)
+}
+
+// parse decodes the result of reading key as get does.
+func (s *Store) parse(ctx context.Context, key string, kvs []*mvccpb.KeyValue) (*storage.State, int64, error) {
+	if len(kvs) == 0 {
+		return nil, 0, nil
+	}
+
+	kv := kvs[0]
+	state, err := s.decode(key, kv.Value)
+	if err != nil {
+		if err = s.handleCorruption(ctx, key, err); errors.Is(err, storage.ErrKeyNotFound) {
+			return nil, 0, nil
+		}
+		return nil, 0, err
+	}
+	return state, kv.ModRevision, nil
+}
+
+// leaseOpts grants a lease for ttl, rounded up to whole seconds, and
+// returns the put options attaching it. A non-positive ttl needs no lease.
+func (s *Store) leaseOpts(ctx context.Context, ttl time.Duration) ([]clientv3.OpOption, error) {
+	if ttl <= 0 {
+		return nil, nil
+	}
+
+	lease, err := s.client.Grant(ctx, int64(math.Ceil(ttl.Seconds())))
+	if err != nil {
+		return nil, err
+	}
+	return []clientv3.OpOption{clientv3.WithLease(lease.ID)}, nil
+}
+
+// handleCorruption applies the corruption policy to a decode error.
+// Under CorruptionReset the key is deleted and ErrKeyNotFound returned.
+func (s *Store) handleCorruption(ctx context.Context, key string, err error) error {
+	if s.corruption != storage.CorruptionReset || !errors.Is(err, storage.ErrInvalidState) {
+		return err
+	}
+	if _, delErr := s.client.Delete(ctx, s.prefix+key); delErr != nil {
+		return wrapError("delete", key, delErr)
+	}
+	return storage.ErrKeyNotFound
+}
+
+// decode parses a stored value into a State.
+func (s *Store) decode(key string, value []byte) (*storage.State, error) {
+	state, err := storage.UnmarshalKey(s.codec, key, value)
+	if err != nil {
+		return nil, &storage.StorageError{
+			Backend: backendName,
+			Op:      "deserialize",
+			Key:     key,
+			Err:     &storage.InvalidStateError{Err: err},
+		}
+	}
+	return state, nil
+}
+
+// encode serializes state for key.
+func (s *Store) encode(key string, state *storage.State) (string, error) {
+	value, err := storage.MarshalKey(s.codec, key, state)
+	if err != nil {
+		return "", &storage.StorageError{Backend: backendName, Op: "serialize", Key: key, Err: err}
+	}
+	return string(value), nil
+}
+
+// wrapError converts an etcd error into a storage error.
+func wrapError(op, key string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return &storage.StorageError{Backend: backendName, Op: op, Key: key, Err: err}
+}
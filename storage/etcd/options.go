@@ -0,0 +1,38 @@
+package etcd
+
+import (
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithPrefix sets the etcd key prefix under which rate limit keys are
+// stored, so the limiter can share a cluster with other applications.
+//
+// Default: "/flexlimit/"
+func WithPrefix(prefix string) Option {
+	return func(s *Store) {
+		s.prefix = prefix
+	}
+}
+
+// WithCodec sets how state is encoded in etcd values. This is how
+// encryption at rest is enabled (see storage.NewAESGCMCodec).
+//
+// Default: storage.JSONCodec
+func WithCodec(codec storage.Codec) Option {
+	return func(s *Store) {
+		s.codec = codec
+	}
+}
+
+// WithCorruptionPolicy sets what happens when a stored value fails to
+// decode or fails checksum validation (see storage.NewChecksumCodec).
+//
+// Default: storage.CorruptionFail
+func WithCorruptionPolicy(policy storage.CorruptionPolicy) Option {
+	return func(s *Store) {
+		s.corruption = policy
+	}
+}
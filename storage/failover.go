@@ -0,0 +1,331 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	_ Storage               = (*Failover)(nil)
+	_ TokenBucketBatchStore = (*Failover)(nil)
+	_ SlidingLogStore       = (*Failover)(nil)
+)
+
+// FailoverConfig configures a Failover storage.
+type FailoverConfig struct {
+	// ProbeInterval is how often the primary is pinged while the failover
+	// is degraded. Default: 1 second
+	ProbeInterval time.Duration
+
+	// ProbeTimeout bounds each recovery ping. Default: ProbeInterval
+	ProbeTimeout time.Duration
+
+	// OnFailover is called when the primary fails and traffic switches to
+	// the secondary. ctx is that of the operation that failed, and err its
+	// error.
+	OnFailover func(ctx context.Context, err error)
+
+	// OnRecover is called when the primary answers a ping again and
+	// traffic switches back.
+	OnRecover func()
+}
+
+// Failover routes operations to a primary storage and transparently
+// switches to a secondary storage when the primary fails.
+//
+// This is the storage half of the "local_memory" fallback strategy: the
+// primary is typically Redis and the secondary an in-memory store. While
+// degraded, a background probe pings the primary and switches back as soon
+// as it answers. State written to the secondary is not copied back; keys
+// simply resume from whatever the primary holds.
+//
+// A key that does not exist (ErrKeyNotFound), an unsupported optional
+// operation (ErrNotSupported), corrupt state for a single key
+// (ErrInvalidState) and context cancellation are not treated as failures.
+//
+// Example:
+//
+//	store := storage.NewFailover(redisStore, storage.NewMemory(storage.Config{}),
+//	    storage.FailoverConfig{
+//	        OnFailover: func(ctx context.Context, err error) { log.Warn("redis down", "err", err) },
+//	    })
+type Failover struct {
+	primary   Storage
+	secondary Storage
+	cfg       FailoverConfig
+
+	degraded atomic.Bool
+
+	mu      sync.Mutex
+	probing bool
+	stop    chan struct{}
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// NewFailover creates a Failover over primary and secondary.
+func NewFailover(primary, secondary Storage, cfg FailoverConfig) *Failover {
+	if cfg.ProbeInterval <= 0 {
+		cfg.ProbeInterval = time.Second
+	}
+	if cfg.ProbeTimeout <= 0 {
+		cfg.ProbeTimeout = cfg.ProbeInterval
+	}
+
+	return &Failover{
+		primary:   primary,
+		secondary: secondary,
+		cfg:       cfg,
+		stop:      make(chan struct{}),
+	}
+}
+
+// Degraded reports whether operations are currently served by the secondary.
+func (f *Failover) Degraded() bool {
+	return f.degraded.Load()
+}
+
+// Primary returns the primary storage.
+func (f *Failover) Primary() Storage {
+	return f.primary
+}
+
+// Secondary returns the secondary storage.
+func (f *Failover) Secondary() Storage {
+	return f.secondary
+}
+
+// Get retrieves the state for key.
+func (f *Failover) Get(ctx context.Context, key string) (*State, error) {
+	var state *State
+	err := f.do(ctx, func(s Storage) error {
+		var err error
+		state, err = s.Get(ctx, key)
+		return err
+	})
+	return state, err
+}
+
+// Set stores the state for key.
+func (f *Failover) Set(ctx context.Context, key string, state *State, ttl time.Duration) error {
+	return f.do(ctx, func(s Storage) error {
+		return s.Set(ctx, key, state, ttl)
+	})
+}
+
+// Incr atomically increments the counter for key.
+func (f *Failover) Incr(ctx context.Context, key string, amount int64, ttl time.Duration) (int64, error) {
+	var n int64
+	err := f.do(ctx, func(s Storage) error {
+		var err error
+		n, err = s.Incr(ctx, key, amount, ttl)
+		return err
+	})
+	return n, err
+}
+
+// Delete removes key.
+func (f *Failover) Delete(ctx context.Context, key string) error {
+	return f.do(ctx, func(s Storage) error {
+		return s.Delete(ctx, key)
+	})
+}
+
+// Exists reports whether key exists.
+func (f *Failover) Exists(ctx context.Context, key string) (bool, error) {
+	var ok bool
+	err := f.do(ctx, func(s Storage) error {
+		var err error
+		ok, err = s.Exists(ctx, key)
+		return err
+	})
+	return ok, err
+}
+
+// GetMulti retrieves the state for several keys.
+func (f *Failover) GetMulti(ctx context.Context, keys []string) ([]*State, error) {
+	var states []*State
+	err := f.do(ctx, func(s Storage) error {
+		var err error
+		states, err = s.GetMulti(ctx, keys)
+		return err
+	})
+	return states, err
+}
+
+// SetMulti stores the state for several keys.
+func (f *Failover) SetMulti(ctx context.Context, states map[string]*State, ttl time.Duration) error {
+	return f.do(ctx, func(s Storage) error {
+		return s.SetMulti(ctx, states, ttl)
+	})
+}
+
+// Keys returns keys matching pattern from whichever storage is active.
+func (f *Failover) Keys(ctx context.Context, pattern string) ([]string, error) {
+	var keys []string
+	err := f.do(ctx, func(s Storage) error {
+		var err error
+		keys, err = s.Keys(ctx, pattern)
+		return err
+	})
+	return keys, err
+}
+
+// TakeTokens runs a token bucket operation on the active storage, or
+// returns ErrNotSupported if that storage can't run it atomically.
+func (f *Failover) TakeTokens(ctx context.Context, key string, req TokenBucketRequest) (TokenBucketResult, error) {
+	var res TokenBucketResult
+	err := f.do(ctx, func(s Storage) error {
+		tbs, ok := s.(TokenBucketStore)
+		if !ok {
+			return ErrNotSupported
+		}
+
+		var err error
+		res, err = tbs.TakeTokens(ctx, key, req)
+		return err
+	})
+	return res, err
+}
+
+// TakeTokensMulti runs a batch of token bucket operations on the active
+// storage, or returns ErrNotSupported if that storage can't batch them.
+func (f *Failover) TakeTokensMulti(ctx context.Context, keys []string, reqs []TokenBucketRequest) ([]TokenBucketResult, error) {
+	var res []TokenBucketResult
+	err := f.do(ctx, func(s Storage) error {
+		tbs, ok := s.(TokenBucketBatchStore)
+		if !ok {
+			return ErrNotSupported
+		}
+
+		var err error
+		res, err = tbs.TakeTokensMulti(ctx, keys, reqs)
+		return err
+	})
+	return res, err
+}
+
+// TakeSlots runs a sliding window log operation on the active storage, or
+// returns ErrNotSupported if that storage can't run it atomically.
+func (f *Failover) TakeSlots(ctx context.Context, key string, req SlidingLogRequest) (SlidingLogResult, error) {
+	var res SlidingLogResult
+	err := f.do(ctx, func(s Storage) error {
+		sls, ok := s.(SlidingLogStore)
+		if !ok {
+			return ErrNotSupported
+		}
+
+		var err error
+		res, err = sls.TakeSlots(ctx, key, req)
+		return err
+	})
+	return res, err
+}
+
+// Ping checks the primary storage. It reports the primary's health even
+// while degraded, so health checks see the real backend status.
+func (f *Failover) Ping(ctx context.Context) error {
+	return f.primary.Ping(ctx)
+}
+
+// Close stops the recovery probe and closes both storages.
+func (f *Failover) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+	f.closed = true
+	close(f.stop)
+	f.mu.Unlock()
+
+	f.wg.Wait()
+
+	return errors.Join(f.primary.Close(), f.secondary.Close())
+}
+
+// do runs op against the active storage, switching to the secondary and
+// retrying once if the primary fails.
+func (f *Failover) do(ctx context.Context, op func(Storage) error) error {
+	if f.degraded.Load() {
+		return op(f.secondary)
+	}
+
+	err := op(f.primary)
+	if !isFailure(ctx, err) {
+		return err
+	}
+
+	f.trip(ctx, err)
+	return op(f.secondary)
+}
+
+// trip switches to the secondary after the operation of ctx failed with
+// err, and starts the recovery probe.
+func (f *Failover) trip(ctx context.Context, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed || f.probing {
+		return
+	}
+
+	f.probing = true
+	f.degraded.Store(true)
+
+	if f.cfg.OnFailover != nil {
+		f.cfg.OnFailover(ctx, err)
+	}
+
+	f.wg.Add(1)
+	go f.probe()
+}
+
+// probe pings the primary until it recovers or the failover is closed.
+func (f *Failover) probe() {
+	defer f.wg.Done()
+
+	ticker := time.NewTicker(f.cfg.ProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), f.cfg.ProbeTimeout)
+		err := f.primary.Ping(ctx)
+		cancel()
+		if err != nil {
+			continue
+		}
+
+		f.mu.Lock()
+		f.probing = false
+		f.degraded.Store(false)
+		f.mu.Unlock()
+
+		if f.cfg.OnRecover != nil {
+			f.cfg.OnRecover()
+		}
+		return
+	}
+}
+
+// isFailure reports whether err indicates the storage itself is unhealthy,
+// as opposed to a missing key or a caller-side cancellation.
+func isFailure(ctx context.Context, err error) bool {
+	if err == nil || errors.Is(err, ErrKeyNotFound) || errors.Is(err, ErrNotSupported) ||
+		errors.Is(err, ErrInvalidState) {
+		return false
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+	return true
+}
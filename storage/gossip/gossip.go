@@ -0,0 +1,405 @@
+// Package gossip provides an experimental peer-to-peer Storage for
+// distributed rate limiting without a shared backend such as Redis.
+//
+// Every instance keeps its own in-memory state and decides requests on
+// it. Instances find each other with hashicorp/memberlist, and every
+// SyncInterval each one sends its peers what it consumed since the last
+// sync: the tokens taken from each token bucket and the increments of
+// each counter. Peers apply them to their own state, so the limit holds
+// globally once updates have spread. Until then every instance decides on
+// its own view, and the cluster may allow up to the limit per instance
+// within one sync interval plus the network delay.
+//
+// The token bucket and fixed window algorithms are shared this way. State
+// written with Set (sliding windows, leaky buckets, lifecycle records),
+// Delete and Keys stay local to each instance, and an instance joining
+// the cluster starts from empty state.
+//
+// Example:
+//
+//	store, err := gossip.New(storage.Config{},
+//	    gossip.WithBindAddr("0.0.0.0", 7946),
+//	    gossip.WithJoin("10.0.0.11:7946", "10.0.0.12:7946"),
+//	)
+//	if err != nil {
+//	    return err
+//	}
+//	limiter, err := flexlimit.New(1000, time.Minute, flexlimit.WithStorage(store))
+package gossip
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/Vipul984/flexlimit/clock"
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+var (
+	_ storage.Storage               = (*Store)(nil)
+	_ storage.TokenBucketBatchStore = (*Store)(nil)
+)
+
+// backendName identifies this backend in storage errors.
+const backendName = "gossip"
+
+// leaveTimeout bounds how long Close waits for peers to learn that the
+// instance leaves.
+const leaveTimeout = time.Second
+
+// Store is a Storage sharing token buckets and counters with its peers.
+type Store struct {
+	local *storage.Memory
+	ml    *memberlist.Memberlist
+	clock clock.Clock
+
+	nodeName     string
+	bindAddr     string
+	bindPort     int
+	join         []string
+	syncInterval time.Duration
+	mlConfig     *memberlist.Config
+	onError      func(err error)
+
+	// mu guards the consumption not sent to peers yet
+	mu       sync.Mutex
+	buckets  map[string]*bucketUpdate
+	counters map[string]*counterUpdate
+
+	stop      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// update is the message an instance sends its peers every sync.
+type update struct {
+	Node     string          `msgpack:"n"`
+	Buckets  []bucketUpdate  `msgpack:"b,omitempty"`
+	Counters []counterUpdate `msgpack:"c,omitempty"`
+}
+
+// bucketUpdate is the tokens taken from one token bucket.
+type bucketUpdate struct {
+	Key        string        `msgpack:"k"`
+	Cost       float64       `msgpack:"v"`
+	Capacity   float64       `msgpack:"cap"`
+	RefillRate float64       `msgpack:"r"`
+	TTL        time.Duration `msgpack:"t"`
+}
+
+// counterUpdate is the increments of one counter.
+type counterUpdate struct {
+	Key    string        `msgpack:"k"`
+	Amount int64         `msgpack:"v"`
+	TTL    time.Duration `msgpack:"t"`
+}
+
+// New starts a gossip member keeping its state in memory, as configured
+// by the MaxKeys, CleanupInterval and Clock fields of cfg, and joins the
+// peers given with WithJoin. Without peers, it waits for others to join
+// it.
+func New(cfg storage.Config, opts ...Option) (*Store, error) {
+	s := &Store{
+		clock:        cfg.Clock,
+		syncInterval: 100 * time.Millisecond,
+		buckets:      make(map[string]*bucketUpdate),
+		counters:     make(map[string]*counterUpdate),
+		stop:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.clock == nil {
+		s.clock = clock.New()
+	}
+
+	mlConfig := s.mlConfig
+	if mlConfig == nil {
+		mlConfig = memberlist.DefaultLANConfig()
+		mlConfig.LogOutput = io.Discard
+	}
+	if s.nodeName != "" {
+		mlConfig.Name = s.nodeName
+	}
+	if s.bindAddr != "" {
+		mlConfig.BindAddr = s.bindAddr
+	}
+	if s.bindPort != 0 {
+		mlConfig.BindPort = s.bindPort
+		mlConfig.AdvertisePort = s.bindPort
+	}
+	mlConfig.Delegate = delegate{s}
+
+	// Peers may send updates as soon as the member starts
+	s.local = storage.NewMemory(cfg)
+	ml, err := memberlist.Create(mlConfig)
+	if err != nil {
+		s.local.Close()
+		return nil, &storage.StorageError{Backend: backendName, Op: "connect", Err: err}
+	}
+	if len(s.join) > 0 {
+		if _, err := ml.Join(s.join); err != nil {
+			ml.Shutdown()
+			s.local.Close()
+			return nil, &storage.StorageError{Backend: backendName, Op: "join", Err: err}
+		}
+	}
+
+	s.ml = ml
+	s.wg.Add(1)
+	go s.syncLoop()
+	return s, nil
+}
+
+// Memberlist returns the underlying memberlist.
+func (s *Store) Memberlist() *memberlist.Memberlist {
+	return s.ml
+}
+
+// Members returns the names of the live members of the cluster,
+// including this one.
+func (s *Store) Members() []string {
+	members := s.ml.Members()
+	names := make([]string, len(members))
+	for i, m := range members {
+		names[i] = m.Name
+	}
+	return names
+}
+
+// TakeTokens runs a token bucket operation on the local state and sends
+// the tokens taken to peers at the next sync.
+func (s *Store) TakeTokens(ctx context.Context, key string, req storage.TokenBucketRequest) (storage.TokenBucketResult, error) {
+	res, err := s.local.TakeTokens(ctx, key, req)
+	if err == nil && res.Allowed {
+		s.recordTokens(key, req)
+	}
+	return res, err
+}
+
+// TakeTokensMulti runs several token bucket operations on the local state,
+// each atomic on its own.
+func (s *Store) TakeTokensMulti(ctx context.Context, keys []string, reqs []storage.TokenBucketRequest) ([]storage.TokenBucketResult, error) {
+	results, err := s.local.TakeTokensMulti(ctx, keys, reqs)
+	if err != nil {
+		return nil, err
+	}
+	for i, res := range results {
+		if res.Allowed {
+			s.recordTokens(keys[i], reqs[i])
+		}
+	}
+	return results, nil
+}
+
+// Incr adds amount to the local count of key and sends it to peers at the
+// next sync.
+func (s *Store) Incr(ctx context.Context, key string, amount int64, ttl time.Duration) (int64, error) {
+	n, err := s.local.Incr(ctx, key, amount, ttl)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.counters[key]
+	if !ok {
+		u = &counterUpdate{Key: key}
+		s.counters[key] = u
+	}
+	u.Amount += amount
+	u.TTL = ttl
+	return n, nil
+}
+
+// recordTokens remembers the tokens taken by req from key for the next
+// sync.
+func (s *Store) recordTokens(key string, req storage.TokenBucketRequest) {
+	if req.Cost == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.buckets[key]
+	if !ok {
+		u = &bucketUpdate{Key: key}
+		s.buckets[key] = u
+	}
+	u.Cost += req.Cost
+	u.Capacity, u.RefillRate, u.TTL = req.Capacity, req.RefillRate, req.TTL
+}
+
+// Get retrieves the local state for key.
+func (s *Store) Get(ctx context.Context, key string) (*storage.State, error) {
+	return s.local.Get(ctx, key)
+}
+
+// Set replaces the local state for key. It is not sent to peers.
+func (s *Store) Set(ctx context.Context, key string, state *storage.State, ttl time.Duration) error {
+	return s.local.Set(ctx, key, state, ttl)
+}
+
+// Delete removes key from the local state. Peers keep theirs.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	return s.local.Delete(ctx, key)
+}
+
+// Exists reports whether key exists in the local state.
+func (s *Store) Exists(ctx context.Context, key string) (bool, error) {
+	return s.local.Exists(ctx, key)
+}
+
+// GetMulti retrieves the local state for several keys.
+func (s *Store) GetMulti(ctx context.Context, keys []string) ([]*storage.State, error) {
+	return s.local.GetMulti(ctx, keys)
+}
+
+// SetMulti replaces the local state for several keys.
+func (s *Store) SetMulti(ctx context.Context, states map[string]*storage.State, ttl time.Duration) error {
+	return s.local.SetMulti(ctx, states, ttl)
+}
+
+// Keys returns the local keys matching pattern.
+func (s *Store) Keys(ctx context.Context, pattern string) ([]string, error) {
+	return s.local.Keys(ctx, pattern)
+}
+
+// Ping reports whether the store is open.
+func (s *Store) Ping(ctx context.Context) error {
+	return s.local.Ping(ctx)
+}
+
+// Close sends the last updates to peers, leaves the cluster and drops the
+// local state.
+func (s *Store) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		s.wg.Wait()
+
+		s.sync()
+		err = errors.Join(s.ml.Leave(leaveTimeout), s.ml.Shutdown(), s.local.Close())
+	})
+	return err
+}
+
+// syncLoop sends updates to peers every syncInterval until Close.
+func (s *Store) syncLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.sync()
+		}
+	}
+}
+
+// sync sends the consumption since the last sync to every peer.
+func (s *Store) sync() {
+	s.mu.Lock()
+	if len(s.buckets) == 0 && len(s.counters) == 0 {
+		s.mu.Unlock()
+		return
+	}
+	msg := update{Node: s.ml.LocalNode().Name}
+	for _, u := range s.buckets {
+		msg.Buckets = append(msg.Buckets, *u)
+	}
+	for _, u := range s.counters {
+		msg.Counters = append(msg.Counters, *u)
+	}
+	s.buckets = make(map[string]*bucketUpdate)
+	s.counters = make(map[string]*counterUpdate)
+	s.mu.Unlock()
+
+	buf, err := msgpack.Marshal(&msg)
+	if err != nil {
+		s.fail("encode", err)
+		return
+	}
+	self := s.ml.LocalNode().Name
+	for _, m := range s.ml.Members() {
+		if m.Name == self {
+			continue
+		}
+		if err := s.ml.SendReliable(m, buf); err != nil {
+			s.fail("sync", err)
+		}
+	}
+}
+
+// apply applies the consumption a peer sent to the local state.
+func (s *Store) apply(buf []byte) {
+	var msg update
+	if err := msgpack.Unmarshal(buf, &msg); err != nil {
+		s.fail("decode", err)
+		return
+	}
+
+	ctx := context.Background()
+	now := s.clock.Now()
+	for _, u := range msg.Buckets {
+		req := storage.TokenBucketRequest{
+			Capacity:   u.Capacity,
+			RefillRate: u.RefillRate,
+			Cost:       u.Cost,
+			Now:        now,
+			TTL:        u.TTL,
+		}
+		// Tokens already spent elsewhere can't be refused: take what is
+		// left if the peer spent more than this instance thinks there is
+		res, err := s.local.TakeTokens(ctx, u.Key, req)
+		if err == nil && !res.Allowed && res.Tokens > 0 {
+			req.Cost = res.Tokens
+			_, err = s.local.TakeTokens(ctx, u.Key, req)
+		}
+		if err != nil {
+			s.fail("apply", err)
+		}
+	}
+	for _, u := range msg.Counters {
+		if _, err := s.local.Incr(ctx, u.Key, u.Amount, u.TTL); err != nil {
+			s.fail("apply", err)
+		}
+	}
+}
+
+// fail reports an error of op to the OnError callback.
+func (s *Store) fail(op string, err error) {
+	if s.onError != nil {
+		s.onError(&storage.StorageError{Backend: backendName, Op: op, Err: err})
+	}
+}
+
+// delegate receives the messages of peers from memberlist.
+type delegate struct {
+	s *Store
+}
+
+// NodeMeta returns no metadata.
+func (delegate) NodeMeta(limit int) []byte { return nil }
+
+// NotifyMsg applies a peer's update.
+func (d delegate) NotifyMsg(buf []byte) { d.s.apply(buf) }
+
+// GetBroadcasts returns nothing: updates are sent directly to peers.
+func (delegate) GetBroadcasts(overhead, limit int) [][]byte { return nil }
+
+// LocalState returns nothing: joining instances start from empty state.
+func (delegate) LocalState(join bool) []byte { return nil }
+
+// MergeRemoteState ignores the state of peers.
+func (delegate) MergeRemoteState(buf []byte, join bool) {}
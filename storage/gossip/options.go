@@ -0,0 +1,76 @@
+package gossip
+
+import (
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithNodeName sets the instance's name in the cluster, which must be
+// unique.
+//
+// Default: the host name
+func WithNodeName(name string) Option {
+	return func(s *Store) {
+		s.nodeName = name
+	}
+}
+
+// WithBindAddr sets the address and port on which the instance talks to
+// its peers, over both UDP and TCP. Port 0 picks a free port.
+//
+// Default: "0.0.0.0", port 7946
+func WithBindAddr(addr string, port int) Option {
+	return func(s *Store) {
+		s.bindAddr = addr
+		s.bindPort = port
+	}
+}
+
+// WithJoin sets the addresses ("host:port") of existing members to join.
+// New fails unless at least one of them answers. Others are discovered
+// from them.
+//
+// Default: none (the instance starts a cluster)
+func WithJoin(peers ...string) Option {
+	return func(s *Store) {
+		s.join = append(s.join, peers...)
+	}
+}
+
+// WithSyncInterval sets how often consumption is sent to peers. Shorter
+// intervals tighten the global limit at the cost of more messages.
+//
+// Default: 100 milliseconds
+func WithSyncInterval(d time.Duration) Option {
+	return func(s *Store) {
+		if d > 0 {
+			s.syncInterval = d
+		}
+	}
+}
+
+// WithMemberlistConfig sets the memberlist configuration, to tune failure
+// detection, encryption or transports. Its Delegate is replaced, and
+// WithNodeName and WithBindAddr override its fields when given.
+//
+// Default: memberlist.DefaultLANConfig(), with logging discarded
+func WithMemberlistConfig(cfg *memberlist.Config) Option {
+	return func(s *Store) {
+		s.mlConfig = cfg
+	}
+}
+
+// WithOnError sets a function called with the errors of sending updates to
+// peers and applying theirs, which otherwise happen in the background
+// unnoticed.
+//
+// Default: errors are dropped
+func WithOnError(fn func(err error)) Option {
+	return func(s *Store) {
+		s.onError = fn
+	}
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"strings"
+)
+
+// HashTagStore is implemented by backends that spread keys over nodes by
+// a hash of the key, such as a Redis Cluster. Keys hash by their hash tag
+// instead, the part between the first "{" and the following "}", if they
+// have one, so an operation on several keys is only served by one node,
+// in one round trip or one script, if the keys share a tag.
+type HashTagStore interface {
+	// HashTags reports whether algorithms should tag the keys they derive
+	// from one rate limit key, such as the buckets of a window (see
+	// HashTag).
+	HashTags() bool
+}
+
+// UsesHashTags reports whether s, or the storage s wraps (see Prefixed,
+// Cached, Leased and the primary of a Failover), is a HashTagStore whose
+// HashTags returns true.
+func UsesHashTags(s Storage) bool {
+	for {
+		switch w := s.(type) {
+		case HashTagStore:
+			return w.HashTags()
+		case *Failover:
+			s = w.Primary()
+		case interface{ Unwrap() Storage }:
+			s = w.Unwrap()
+		default:
+			return false
+		}
+	}
+}
+
+// HashTag returns key as a hash tag, "{key}". Keys derived from it by
+// appending a suffix, as in "{user:123}:42", all hash to the same node of
+// a cluster, whatever key contains.
+func HashTag(key string) string {
+	return "{" + key + "}"
+}
+
+// CutHashTag returns the key of a hash tag made by HashTag, and whether
+// tag was one.
+func CutHashTag(tag string) (string, bool) {
+	if len(tag) < 2 || !strings.HasPrefix(tag, "{") || !strings.HasSuffix(tag, "}") {
+		return tag, false
+	}
+	return tag[1 : len(tag)-1], true
+}
@@ -0,0 +1,357 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Vipul984/flexlimit/clock"
+)
+
+var (
+	_ Storage               = (*Leased)(nil)
+	_ TokenBucketBatchStore = (*Leased)(nil)
+	_ SlidingLogStore       = (*Leased)(nil)
+)
+
+// LeaseConfig configures a Leased storage.
+type LeaseConfig struct {
+	// Size is the number of tokens claimed at once from a bucket.
+	// Default: a tenth of the bucket's capacity, at least 1
+	Size float64
+
+	// IdleTimeout is how long a key's leased tokens are kept without a
+	// request before they are returned. Default: 1 second
+	IdleTimeout time.Duration
+
+	// Clock is the time source. Default: the system clock
+	Clock clock.Clock
+
+	// OnReturnError is called when returning unused tokens fails. The
+	// tokens are then lost until the bucket refills
+	OnReturnError func(err error)
+}
+
+// Leased serves token buckets from tokens leased from another Storage,
+// such as Redis, the usual architecture for distributed limits at very
+// high request rates. The first request of a key on an instance claims
+// Size tokens from the shared bucket in one operation; the following
+// requests are served from them locally, and the next batch is claimed
+// when they run out. Unused tokens are returned to the shared bucket
+// when the key has been idle for IdleTimeout, and on Close.
+//
+// Unlike Cached, leasing never allows more than the limit: every token is
+// taken from the shared bucket before it is spent. The price is fairness
+// between instances, since tokens leased by one can't be spent by another
+// until they are returned, so an instance may deny requests while the
+// bucket's tokens sit idle elsewhere. Keep Size small against the
+// capacity divided by the number of instances.
+//
+// Only token bucket operations (see TokenBucketStore) are leased; the
+// others go to the wrapped storage. Get reads the shared bucket, without
+// the tokens leased locally.
+//
+// Example:
+//
+//	store := storage.NewLeased(redisStore, storage.LeaseConfig{Size: 50})
+//	defer store.Close()
+type Leased struct {
+	inner Storage
+	cfg   LeaseConfig
+
+	mu     sync.Mutex
+	leases map[string]*lease
+
+	stop      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// lease is the tokens of one key leased by a Leased.
+type lease struct {
+	mu sync.Mutex
+
+	tokens   float64            // leased tokens not spent yet
+	shared   float64            // tokens left in the shared bucket at the last claim
+	req      TokenBucketRequest // the bucket's parameters, for returning tokens
+	used     time.Time          // when the lease last served a request
+	returned bool               // the lease was dropped from Leased.leases
+}
+
+// NewLeased returns a Storage serving the token buckets of s from leased
+// tokens. It starts a goroutine returning idle leases until Close.
+func NewLeased(s Storage, cfg LeaseConfig) *Leased {
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = time.Second
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = clock.New()
+	}
+
+	l := &Leased{
+		inner:  s,
+		cfg:    cfg,
+		leases: make(map[string]*lease),
+		stop:   make(chan struct{}),
+	}
+	l.wg.Add(1)
+	go l.returnLoop()
+	return l
+}
+
+// Unwrap returns the wrapped storage.
+func (l *Leased) Unwrap() Storage {
+	return l.inner
+}
+
+// TakeTokens serves a token bucket operation from the tokens leased for
+// key, claiming more from the wrapped storage if they don't cover it. A
+// zero Cost reads the shared bucket; a negative Cost adds the tokens to
+// the lease. Returns ErrNotSupported if the wrapped storage doesn't
+// implement TokenBucketStore.
+func (l *Leased) TakeTokens(ctx context.Context, key string, req TokenBucketRequest) (TokenBucketResult, error) {
+	tbs, ok := l.inner.(TokenBucketStore)
+	if !ok {
+		return TokenBucketResult{}, ErrNotSupported
+	}
+
+	for {
+		ls := l.lease(key)
+		ls.mu.Lock()
+		if ls.returned {
+			ls.mu.Unlock()
+			continue
+		}
+		res, err := l.take(ctx, tbs, key, ls, req)
+		ls.mu.Unlock()
+		return res, err
+	}
+}
+
+// TakeTokensMulti runs several token bucket operations, each atomic on
+// its own.
+func (l *Leased) TakeTokensMulti(ctx context.Context, keys []string, reqs []TokenBucketRequest) ([]TokenBucketResult, error) {
+	results := make([]TokenBucketResult, len(keys))
+	for i, key := range keys {
+		res, err := l.TakeTokens(ctx, key, reqs[i])
+		if err != nil {
+			return nil, err
+		}
+		results[i] = res
+	}
+	return results, nil
+}
+
+// take serves req from ls. Must be called with ls.mu held.
+func (l *Leased) take(ctx context.Context, tbs TokenBucketStore, key string, ls *lease, req TokenBucketRequest) (TokenBucketResult, error) {
+	ls.req, ls.used = req, l.cfg.Clock.Now()
+	ls.req.Cost = 0
+
+	switch {
+	case req.Cost < 0 || ls.tokens >= req.Cost && req.Cost > 0:
+		ls.tokens -= req.Cost
+		return TokenBucketResult{Allowed: true, Tokens: ls.tokens + ls.shared}, nil
+	case req.Cost == 0:
+		res, err := tbs.TakeTokens(ctx, key, req)
+		if err != nil {
+			return TokenBucketResult{}, err
+		}
+		ls.shared = res.Tokens
+		return TokenBucketResult{Tokens: ls.tokens + ls.shared}, nil
+	}
+
+	// Claim a batch, or whatever is left if that covers the request
+	need := req.Cost - ls.tokens
+	claim := req
+	claim.Cost = max(l.size(req), need)
+	res, err := tbs.TakeTokens(ctx, key, claim)
+	if err == nil && !res.Allowed && res.Tokens >= need {
+		claim.Cost = res.Tokens
+		res, err = tbs.TakeTokens(ctx, key, claim)
+	}
+	if err != nil {
+		return TokenBucketResult{}, err
+	}
+
+	ls.shared = res.Tokens
+	if !res.Allowed {
+		return TokenBucketResult{Tokens: ls.tokens + ls.shared}, nil
+	}
+	ls.tokens += claim.Cost - req.Cost
+	return TokenBucketResult{Allowed: true, Tokens: ls.tokens + ls.shared}, nil
+}
+
+// size returns the number of tokens to claim from the bucket of req.
+func (l *Leased) size(req TokenBucketRequest) float64 {
+	if l.cfg.Size > 0 {
+		return math.Min(l.cfg.Size, req.Capacity)
+	}
+	return math.Max(math.Floor(req.Capacity/10), 1)
+}
+
+// lease returns the lease of key, creating an empty one if needed.
+func (l *Leased) lease(key string) *lease {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ls, ok := l.leases[key]
+	if !ok {
+		ls = &lease{}
+		l.leases[key] = ls
+	}
+	return ls
+}
+
+// TakeSlots forwards to the wrapped storage if it supports atomic sliding
+// window log operations.
+func (l *Leased) TakeSlots(ctx context.Context, key string, req SlidingLogRequest) (SlidingLogResult, error) {
+	sls, ok := l.inner.(SlidingLogStore)
+	if !ok {
+		return SlidingLogResult{}, ErrNotSupported
+	}
+	return sls.TakeSlots(ctx, key, req)
+}
+
+// Get retrieves the state for key from the wrapped storage.
+func (l *Leased) Get(ctx context.Context, key string) (*State, error) {
+	return l.inner.Get(ctx, key)
+}
+
+// Set replaces the state for key, dropping its lease.
+func (l *Leased) Set(ctx context.Context, key string, state *State, ttl time.Duration) error {
+	l.drop(key)
+	return l.inner.Set(ctx, key, state, ttl)
+}
+
+// Incr atomically adds amount to the Count field of key.
+func (l *Leased) Incr(ctx context.Context, key string, amount int64, ttl time.Duration) (int64, error) {
+	return l.inner.Incr(ctx, key, amount, ttl)
+}
+
+// Delete removes key, dropping its lease.
+func (l *Leased) Delete(ctx context.Context, key string) error {
+	l.drop(key)
+	return l.inner.Delete(ctx, key)
+}
+
+// Exists reports whether key exists in the wrapped storage.
+func (l *Leased) Exists(ctx context.Context, key string) (bool, error) {
+	return l.inner.Exists(ctx, key)
+}
+
+// GetMulti retrieves the state for several keys from the wrapped storage.
+func (l *Leased) GetMulti(ctx context.Context, keys []string) ([]*State, error) {
+	return l.inner.GetMulti(ctx, keys)
+}
+
+// SetMulti replaces several states, dropping their leases.
+func (l *Leased) SetMulti(ctx context.Context, states map[string]*State, ttl time.Duration) error {
+	for key := range states {
+		l.drop(key)
+	}
+	return l.inner.SetMulti(ctx, states, ttl)
+}
+
+// Keys returns the keys of the wrapped storage matching pattern.
+func (l *Leased) Keys(ctx context.Context, pattern string) ([]string, error) {
+	return l.inner.Keys(ctx, pattern)
+}
+
+// Ping pings the wrapped storage.
+func (l *Leased) Ping(ctx context.Context) error {
+	return l.inner.Ping(ctx)
+}
+
+// Return gives the unused tokens of every key back to the wrapped storage
+// now, as Close does, and returns the first error.
+func (l *Leased) Return(ctx context.Context) error {
+	return l.returnIdle(ctx, time.Time{})
+}
+
+// Close stops the return goroutine, returns the unused tokens of every key
+// and closes the wrapped storage.
+func (l *Leased) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.stop)
+		l.wg.Wait()
+
+		err = errors.Join(l.Return(context.Background()), l.inner.Close())
+	})
+	return err
+}
+
+// drop forgets the lease of key without returning its tokens, for keys
+// whose bucket is replaced.
+func (l *Leased) drop(key string) {
+	l.mu.Lock()
+	ls, ok := l.leases[key]
+	delete(l.leases, key)
+	l.mu.Unlock()
+
+	if ok {
+		ls.mu.Lock()
+		ls.returned = true
+		ls.mu.Unlock()
+	}
+}
+
+// returnIdle returns the tokens of the leases unused since before, or of
+// every lease if before is zero.
+func (l *Leased) returnIdle(ctx context.Context, before time.Time) error {
+	l.mu.Lock()
+	idle := make(map[string]*lease)
+	for key, ls := range l.leases {
+		idle[key] = ls
+	}
+	l.mu.Unlock()
+
+	tbs, _ := l.inner.(TokenBucketStore)
+	var first error
+	for key, ls := range idle {
+		ls.mu.Lock()
+		if ls.returned || !before.IsZero() && !ls.used.Before(before) {
+			ls.mu.Unlock()
+			continue
+		}
+
+		l.mu.Lock()
+		if l.leases[key] == ls {
+			delete(l.leases, key)
+		}
+		l.mu.Unlock()
+		ls.returned = true
+
+		if ls.tokens != 0 && tbs != nil {
+			req := ls.req
+			req.Cost, req.Now = -ls.tokens, l.cfg.Clock.Now()
+			if _, err := tbs.TakeTokens(ctx, key, req); err != nil && first == nil {
+				first = err
+			}
+		}
+		ls.mu.Unlock()
+	}
+	return first
+}
+
+// returnLoop returns the tokens of idle leases until Close.
+func (l *Leased) returnLoop() {
+	defer l.wg.Done()
+
+	ticker := l.cfg.Clock.NewTicker(l.cfg.IdleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C():
+			before := l.cfg.Clock.Now().Add(-l.cfg.IdleTimeout)
+			if err := l.returnIdle(context.Background(), before); err != nil && l.cfg.OnReturnError != nil {
+				l.cfg.OnReturnError(err)
+			}
+		}
+	}
+}
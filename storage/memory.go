@@ -1 +1,705 @@
 package storage
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Vipul984/flexlimit/clock"
+)
+
+var (
+	_ Storage               = (*Memory)(nil)
+	_ TokenBucketBatchStore = (*Memory)(nil)
+)
+
+// Memory is an in-memory Storage implementation.
+//
+// Memory is the default backend, except for token buckets (see
+// AtomicMemory), and the one used for local fallback when a distributed
+// backend becomes unavailable. It keeps at most MaxKeys entries
+// and evicts a key when that bound is reached, so a flood of unique keys
+// cannot exhaust process memory.
+//
+// Eviction is scan resistant (a variant of 2Q): new keys start on a
+// probation queue and move to a protected LRU when they are used again, or
+// when they come back soon after being evicted. When the store is full,
+// keys leave probation first, so a burst of one-off keys, such as an
+// internet scanner walking through IP addresses, cannot push out the
+// long-lived keys of regular clients. Stats reports the hit ratio.
+//
+// Expired keys are removed when they are accessed and, if
+// Config.CleanupInterval is set, by a background janitor; Close stops it.
+// Config.OnEvict is told about every key that expires or is evicted.
+//
+// With Config.SnapshotPath, keys are kept in memory but written behind to
+// a file every Config.SnapshotInterval and on Close, and loaded back by
+// NewMemory, so a restart loses at most one interval of changes instead
+// of resetting every limit.
+//
+// Example:
+//
+//	store := storage.NewMemory(storage.Config{MaxKeys: 50000})
+//	defer store.Close()
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	maxKeys int
+	clock   clock.Clock
+	closed  bool
+
+	// probation holds keys used once, protected keys used again, each with
+	// the most recently used at the front. ghosts remembers the keys most
+	// recently evicted from probation
+	probation    *list.List
+	protected    *list.List
+	protectedCap int
+	ghosts       *list.List
+	ghostKeys    map[string]*list.Element
+	ghostCap     int
+
+	stats MemoryStats
+
+	// onEvict receives evictions collected in evicted while mu is held,
+	// after mu is released
+	onEvict func(key string, state *State, reason EvictReason)
+	evicted []eviction
+
+	// snapshots persists the store to a file, if configured
+	snapshots *snapshotter
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// EvictReason tells why a key was removed from a store.
+type EvictReason int
+
+const (
+	// EvictExpired means the key's TTL passed.
+	EvictExpired EvictReason = iota
+
+	// EvictCapacity means the key was chosen to make room when the store
+	// reached MaxKeys.
+	EvictCapacity
+)
+
+// String returns "expired" or "capacity".
+func (r EvictReason) String() string {
+	if r == EvictCapacity {
+		return "capacity"
+	}
+	return "expired"
+}
+
+// eviction is a removal waiting to be reported to onEvict.
+type eviction struct {
+	key    string
+	state  *State
+	reason EvictReason
+}
+
+// MemoryStats are the cache statistics of a Memory store.
+type MemoryStats struct {
+	// Hits is the number of reads that found a live key
+	Hits uint64
+
+	// Misses is the number of reads that found no key or an expired one
+	Misses uint64
+
+	// Evictions is the number of keys evicted to stay within MaxKeys
+	Evictions uint64
+
+	// Keys is the number of keys held, including expired keys that have
+	// not been removed yet
+	Keys int
+
+	// Protected is how many of Keys have been used more than once
+	Protected int
+}
+
+// HitRatio returns Hits / (Hits + Misses), or 0 before the first read.
+func (s MemoryStats) HitRatio() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+// memoryEntry is a single key stored in Memory.
+type memoryEntry struct {
+	key       string
+	state     *State
+	expiresAt time.Time // zero means no expiry
+	protected bool      // in Memory.protected rather than probation
+}
+
+// NewMemory creates an in-memory storage backend.
+//
+// Only MaxKeys, CleanupInterval, Clock, OnEvict and the Snapshot fields
+// are read from cfg. A MaxKeys of zero or less defaults to 10000. A
+// positive CleanupInterval starts a janitor goroutine that runs until
+// Close. A SnapshotPath loads the keys saved in that file, if it exists,
+// and starts a goroutine writing them back every SnapshotInterval.
+func NewMemory(cfg Config) *Memory {
+	maxKeys := cfg.MaxKeys
+	if maxKeys <= 0 {
+		maxKeys = 10000
+	}
+
+	clk := cfg.Clock
+	if clk == nil {
+		clk = clock.New()
+	}
+
+	// A quarter of the keys are kept for probation, so that returning
+	// keys have time to prove themselves before a scan pushes them out
+	probationCap := max(1, maxKeys/4)
+
+	m := &Memory{
+		entries:      make(map[string]*list.Element),
+		maxKeys:      maxKeys,
+		clock:        clk,
+		probation:    list.New(),
+		protected:    list.New(),
+		protectedCap: maxKeys - probationCap,
+		ghosts:       list.New(),
+		ghostKeys:    make(map[string]*list.Element),
+		ghostCap:     maxKeys / 2,
+		onEvict:      cfg.OnEvict,
+		snapshots:    newSnapshotter(cfg),
+		stop:         make(chan struct{}),
+	}
+
+	if m.snapshots != nil {
+		m.snapshots.load(m)
+		m.wg.Add(1)
+		go m.persist()
+	}
+	if cfg.CleanupInterval > 0 {
+		m.wg.Add(1)
+		go m.janitor(cfg.CleanupInterval)
+	}
+	return m
+}
+
+// Get retrieves a copy of the state for key.
+func (m *Memory) Get(ctx context.Context, key string) (*State, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.unlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+
+	entry := m.lookup(key)
+	if entry == nil {
+		return nil, ErrKeyNotFound
+	}
+	return copyState(entry.state), nil
+}
+
+// Set stores a copy of state for key.
+func (m *Memory) Set(ctx context.Context, key string, state *State, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	m.store(key, state, ttl)
+	return nil
+}
+
+// Incr atomically adds amount to the Count field of key.
+//
+// The TTL is applied only when the key is created, matching Redis INCR +
+// EXPIRE semantics for fixed windows.
+func (m *Memory) Incr(ctx context.Context, key string, amount int64, ttl time.Duration) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.unlock()
+
+	if m.closed {
+		return 0, ErrClosed
+	}
+
+	if entry := m.lookup(key); entry != nil {
+		entry.state.Count += amount
+		entry.state.UpdatedAt = m.clock.Now()
+		return entry.state.Count, nil
+	}
+
+	m.store(key, &State{Count: amount}, ttl)
+	return amount, nil
+}
+
+// TakeTokens refills and consumes a token bucket atomically.
+func (m *Memory) TakeTokens(ctx context.Context, key string, req TokenBucketRequest) (TokenBucketResult, error) {
+	if err := ctx.Err(); err != nil {
+		return TokenBucketResult{}, err
+	}
+
+	m.mu.Lock()
+	defer m.unlock()
+
+	if m.closed {
+		return TokenBucketResult{}, ErrClosed
+	}
+
+	return m.takeTokens(key, req), nil
+}
+
+// TakeTokensMulti runs several token bucket operations under one lock.
+func (m *Memory) TakeTokensMulti(ctx context.Context, keys []string, reqs []TokenBucketRequest) ([]TokenBucketResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.unlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+
+	results := make([]TokenBucketResult, len(keys))
+	for i, key := range keys {
+		results[i] = m.takeTokens(key, reqs[i])
+	}
+	return results, nil
+}
+
+// Delete removes key. Deleting a missing key is not an error.
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	if elem, ok := m.entries[key]; ok {
+		m.remove(elem)
+	}
+	return nil
+}
+
+// Exists reports whether key is present and not expired.
+func (m *Memory) Exists(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.unlock()
+
+	if m.closed {
+		return false, ErrClosed
+	}
+
+	return m.lookup(key) != nil, nil
+}
+
+// GetMulti retrieves copies of the state for several keys under one lock.
+func (m *Memory) GetMulti(ctx context.Context, keys []string) ([]*State, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.unlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+
+	states := make([]*State, len(keys))
+	for i, key := range keys {
+		if entry := m.lookup(key); entry != nil {
+			states[i] = copyState(entry.state)
+		}
+	}
+	return states, nil
+}
+
+// SetMulti stores several states under one lock.
+func (m *Memory) SetMulti(ctx context.Context, states map[string]*State, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	for key, state := range states {
+		m.store(key, state, ttl)
+	}
+	return nil
+}
+
+// Keys returns all live keys matching pattern.
+//
+// Only prefix patterns are supported: "user:*" matches every key starting
+// with "user:", and "" or "*" matches everything.
+func (m *Memory) Keys(ctx context.Context, pattern string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.unlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+
+	prefix := strings.TrimSuffix(pattern, "*")
+	now := m.clock.Now()
+
+	keys := make([]string, 0, len(m.entries))
+	for key, elem := range m.entries {
+		if expired(elem.Value.(*memoryEntry), now) {
+			continue
+		}
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// Len returns the number of keys currently held, including expired keys
+// that have not been removed yet.
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.unlock()
+	return len(m.entries)
+}
+
+// Stats returns the store's cache statistics.
+func (m *Memory) Stats() MemoryStats {
+	m.mu.Lock()
+	defer m.unlock()
+
+	stats := m.stats
+	stats.Keys = len(m.entries)
+	stats.Protected = m.protected.Len()
+	return stats
+}
+
+// Close stops the janitor and drops all state without reporting it to
+// OnEvict, after writing it to the snapshot file if Config.SnapshotPath is
+// set. Subsequent operations return ErrClosed.
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	var snap snapshot
+	if m.snapshots != nil {
+		snap = m.snapshot()
+	}
+	m.closed = true
+	m.entries = make(map[string]*list.Element)
+	m.probation.Init()
+	m.protected.Init()
+	m.ghosts.Init()
+	m.ghostKeys = make(map[string]*list.Element)
+	m.evicted = nil
+	close(m.stop)
+	m.mu.Unlock()
+
+	m.wg.Wait()
+	if m.snapshots != nil {
+		return m.snapshots.write(snap)
+	}
+	return nil
+}
+
+// Ping always succeeds unless the store has been closed.
+func (m *Memory) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+// lookup returns the live entry for key and marks it as recently used.
+// Expired entries are removed. Must be called with m.mu held.
+func (m *Memory) lookup(key string) *memoryEntry {
+	elem, ok := m.entries[key]
+	if !ok {
+		m.stats.Misses++
+		return nil
+	}
+
+	entry := elem.Value.(*memoryEntry)
+	if expired(entry, m.clock.Now()) {
+		m.evict(elem, EvictExpired)
+		m.stats.Misses++
+		return nil
+	}
+
+	m.stats.Hits++
+	m.touch(elem)
+	return entry
+}
+
+// takeTokens implements TakeTokens. Must be called with m.mu held.
+func (m *Memory) takeTokens(key string, req TokenBucketRequest) TokenBucketResult {
+	tokens, last := req.Capacity, req.Now
+	entry := m.lookup(key)
+	if entry != nil {
+		tokens, last = entry.state.Tokens, entry.state.LastRefill
+	}
+
+	elapsed := max(req.Now.Sub(last), 0)
+	tokens = min(req.Capacity, tokens+elapsed.Seconds()*req.RefillRate)
+
+	res := TokenBucketResult{Tokens: tokens}
+	if req.Cost == 0 || tokens < req.Cost {
+		return res
+	}
+
+	res.Allowed = true
+	res.Tokens = min(req.Capacity, tokens-req.Cost)
+
+	state := &State{Tokens: res.Tokens, LastRefill: req.Now}
+	if entry != nil {
+		state.CreatedAt = entry.state.CreatedAt
+	}
+	m.store(key, state, req.TTL)
+	return res
+}
+
+// touch marks elem as recently used, promoting it out of probation. Must
+// be called with m.mu held.
+func (m *Memory) touch(elem *list.Element) {
+	entry := elem.Value.(*memoryEntry)
+	if entry.protected {
+		m.protected.MoveToFront(elem)
+		return
+	}
+	if m.protectedCap == 0 {
+		m.probation.MoveToFront(elem)
+		return
+	}
+
+	m.probation.Remove(elem)
+	m.protect(entry)
+}
+
+// protect adds entry to the protected list, demoting the least recently
+// used protected entry back to probation if the list is full. Must be
+// called with m.mu held.
+func (m *Memory) protect(entry *memoryEntry) {
+	entry.protected = true
+	m.entries[entry.key] = m.protected.PushFront(entry)
+
+	if m.protected.Len() > m.protectedCap {
+		demoted := m.protected.Remove(m.protected.Back()).(*memoryEntry)
+		demoted.protected = false
+		m.entries[demoted.key] = m.probation.PushFront(demoted)
+	}
+}
+
+// store inserts or replaces key, evicting an entry if the store is full.
+// Must be called with m.mu held.
+func (m *Memory) store(key string, state *State, ttl time.Duration) {
+	now := m.clock.Now()
+
+	stored := copyState(state)
+	if stored.CreatedAt.IsZero() {
+		stored.CreatedAt = now
+	}
+	stored.UpdatedAt = now
+
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = now.Add(ttl)
+	}
+
+	if elem, ok := m.entries[key]; ok {
+		entry := elem.Value.(*memoryEntry)
+		entry.state = stored
+		entry.expiresAt = expiresAt
+		m.touch(elem)
+		return
+	}
+
+	for len(m.entries) >= m.maxKeys {
+		victim := m.probation.Back()
+		if victim == nil {
+			victim = m.protected.Back()
+		}
+		m.evict(victim, EvictCapacity)
+	}
+
+	entry := &memoryEntry{
+		key:       key,
+		state:     stored,
+		expiresAt: expiresAt,
+	}
+
+	// A key evicted from probation that is back already is not a one-off
+	if ghost, ok := m.ghostKeys[key]; ok && m.protectedCap > 0 {
+		m.ghosts.Remove(ghost)
+		delete(m.ghostKeys, key)
+		m.protect(entry)
+		return
+	}
+	m.entries[key] = m.probation.PushFront(entry)
+}
+
+// remove deletes elem from the store and returns its entry. Must be called
+// with m.mu held.
+func (m *Memory) remove(elem *list.Element) *memoryEntry {
+	entry := elem.Value.(*memoryEntry)
+	if entry.protected {
+		m.protected.Remove(elem)
+	} else {
+		m.probation.Remove(elem)
+	}
+	delete(m.entries, entry.key)
+	return entry
+}
+
+// evict removes elem and queues it for OnEvict. Keys evicted from
+// probation for capacity are remembered as ghosts. Must be called with
+// m.mu held.
+func (m *Memory) evict(elem *list.Element, reason EvictReason) {
+	entry := m.remove(elem)
+	if reason == EvictCapacity {
+		m.stats.Evictions++
+		if !entry.protected {
+			m.remember(entry.key)
+		}
+	}
+	if m.onEvict != nil {
+		m.evicted = append(m.evicted, eviction{key: entry.key, state: entry.state, reason: reason})
+	}
+}
+
+// remember adds key to the ghosts, forgetting the oldest ghost if there
+// are too many. Must be called with m.mu held.
+func (m *Memory) remember(key string) {
+	if m.ghostCap == 0 {
+		return
+	}
+	if ghost, ok := m.ghostKeys[key]; ok {
+		m.ghosts.MoveToFront(ghost)
+		return
+	}
+
+	m.ghostKeys[key] = m.ghosts.PushFront(key)
+	if m.ghosts.Len() > m.ghostCap {
+		delete(m.ghostKeys, m.ghosts.Remove(m.ghosts.Back()).(string))
+	}
+}
+
+// unlock releases m.mu, then reports the evictions queued while it was
+// held, so OnEvict may call back into the store.
+func (m *Memory) unlock() {
+	evicted := m.evicted
+	m.evicted = nil
+	m.mu.Unlock()
+
+	for _, e := range evicted {
+		m.onEvict(e.key, e.state, e.reason)
+	}
+}
+
+// janitor removes expired keys every interval until Close.
+func (m *Memory) janitor(interval time.Duration) {
+	defer m.wg.Done()
+
+	ticker := m.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C():
+			m.sweep()
+		}
+	}
+}
+
+// sweep removes every expired key.
+func (m *Memory) sweep() {
+	m.mu.Lock()
+	defer m.unlock()
+
+	if m.closed {
+		return
+	}
+
+	now := m.clock.Now()
+	for _, l := range []*list.List{m.probation, m.protected} {
+		for elem := l.Back(); elem != nil; {
+			prev := elem.Prev()
+			if expired(elem.Value.(*memoryEntry), now) {
+				m.evict(elem, EvictExpired)
+			}
+			elem = prev
+		}
+	}
+}
+
+// expired reports whether entry has passed its expiry time.
+func expired(entry *memoryEntry, now time.Time) bool {
+	return !entry.expiresAt.IsZero() && !now.Before(entry.expiresAt)
+}
+
+// copyState returns a copy of s that shares no mutable data with it.
+func copyState(s *State) *State {
+	if s == nil {
+		return &State{}
+	}
+
+	c := *s
+	if s.Timestamps != nil {
+		c.Timestamps = make([]time.Time, len(s.Timestamps))
+		copy(c.Timestamps, s.Timestamps)
+	}
+	if s.Metadata != nil {
+		c.Metadata = make(map[string]interface{}, len(s.Metadata))
+		for k, v := range s.Metadata {
+			c.Metadata[k] = v
+		}
+	}
+	return &c
+}
@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+var (
+	_ Storage               = (*Prefixed)(nil)
+	_ TokenBucketBatchStore = (*Prefixed)(nil)
+	_ SlidingLogStore       = (*Prefixed)(nil)
+)
+
+// Prefixed namespaces the keys of another Storage: every key is stored as
+// the prefix followed by the key, and Keys only returns keys of the
+// namespace, without the prefix. Several services or limiters can then
+// share one backend, such as a Redis cluster, without their keys
+// colliding, and a namespace can be dropped as a whole with Purge.
+//
+// Atomic operations (see TokenBucketStore and SlidingLogStore) are
+// forwarded if the wrapped storage supports them.
+//
+// Example:
+//
+//	shared, err := redis.New(storage.Config{RedisAddr: "redis:6379"})
+//	if err != nil {
+//	    return err
+//	}
+//	store := storage.NewPrefixed(shared, "svc-a:")
+//	limiter, err := flexlimit.New(100, time.Minute, flexlimit.WithStorage(store))
+type Prefixed struct {
+	inner  Storage
+	prefix string
+}
+
+// NewPrefixed returns a Storage keeping the keys of s under prefix, such
+// as "svc-a:". The prefix should not contain the pattern characters "*",
+// "?" or "[", which Keys would pass on to backends such as Redis. Close
+// closes s.
+func NewPrefixed(s Storage, prefix string) *Prefixed {
+	return &Prefixed{inner: s, prefix: prefix}
+}
+
+// Prefix returns the namespace's prefix.
+func (p *Prefixed) Prefix() string {
+	return p.prefix
+}
+
+// Unwrap returns the wrapped storage.
+func (p *Prefixed) Unwrap() Storage {
+	return p.inner
+}
+
+// Get retrieves the state for key.
+func (p *Prefixed) Get(ctx context.Context, key string) (*State, error) {
+	return p.inner.Get(ctx, p.prefix+key)
+}
+
+// Set replaces the state for key.
+func (p *Prefixed) Set(ctx context.Context, key string, state *State, ttl time.Duration) error {
+	return p.inner.Set(ctx, p.prefix+key, state, ttl)
+}
+
+// Incr atomically adds amount to the count of key.
+func (p *Prefixed) Incr(ctx context.Context, key string, amount int64, ttl time.Duration) (int64, error) {
+	return p.inner.Incr(ctx, p.prefix+key, amount, ttl)
+}
+
+// Delete removes key.
+func (p *Prefixed) Delete(ctx context.Context, key string) error {
+	return p.inner.Delete(ctx, p.prefix+key)
+}
+
+// Exists reports whether key exists.
+func (p *Prefixed) Exists(ctx context.Context, key string) (bool, error) {
+	return p.inner.Exists(ctx, p.prefix+key)
+}
+
+// GetMulti retrieves several keys.
+func (p *Prefixed) GetMulti(ctx context.Context, keys []string) ([]*State, error) {
+	return p.inner.GetMulti(ctx, p.prefixAll(keys))
+}
+
+// SetMulti stores several keys.
+func (p *Prefixed) SetMulti(ctx context.Context, states map[string]*State, ttl time.Duration) error {
+	prefixed := make(map[string]*State, len(states))
+	for key, state := range states {
+		prefixed[p.prefix+key] = state
+	}
+	return p.inner.SetMulti(ctx, prefixed, ttl)
+}
+
+// Keys returns the keys of the namespace matching pattern, without the
+// prefix. An empty pattern matches every key of the namespace.
+func (p *Prefixed) Keys(ctx context.Context, pattern string) ([]string, error) {
+	if pattern == "" {
+		pattern = "*"
+	}
+	stored, err := p.inner.Keys(ctx, p.prefix+pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := stored[:0]
+	for _, key := range stored {
+		if rest, ok := strings.CutPrefix(key, p.prefix); ok {
+			keys = append(keys, rest)
+		}
+	}
+	return keys, nil
+}
+
+// Close closes the wrapped storage.
+func (p *Prefixed) Close() error {
+	return p.inner.Close()
+}
+
+// Ping checks the wrapped storage.
+func (p *Prefixed) Ping(ctx context.Context) error {
+	return p.inner.Ping(ctx)
+}
+
+// TakeTokens forwards to the wrapped storage if it supports atomic token
+// bucket operations.
+func (p *Prefixed) TakeTokens(ctx context.Context, key string, req TokenBucketRequest) (TokenBucketResult, error) {
+	tbs, ok := p.inner.(TokenBucketStore)
+	if !ok {
+		return TokenBucketResult{}, ErrNotSupported
+	}
+	return tbs.TakeTokens(ctx, p.prefix+key, req)
+}
+
+// TakeTokensMulti forwards to the wrapped storage if it supports batched
+// token bucket operations.
+func (p *Prefixed) TakeTokensMulti(ctx context.Context, keys []string, reqs []TokenBucketRequest) ([]TokenBucketResult, error) {
+	tbs, ok := p.inner.(TokenBucketBatchStore)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	return tbs.TakeTokensMulti(ctx, p.prefixAll(keys), reqs)
+}
+
+// TakeSlots forwards to the wrapped storage if it supports atomic sliding
+// window log operations.
+func (p *Prefixed) TakeSlots(ctx context.Context, key string, req SlidingLogRequest) (SlidingLogResult, error) {
+	sls, ok := p.inner.(SlidingLogStore)
+	if !ok {
+		return SlidingLogResult{}, ErrNotSupported
+	}
+	return sls.TakeSlots(ctx, p.prefix+key, req)
+}
+
+// Purge deletes every key of the namespace and returns how many it
+// deleted. See the Purge function.
+func (p *Prefixed) Purge(ctx context.Context) (int, error) {
+	return Purge(ctx, p.inner, p.prefix)
+}
+
+// prefixAll returns keys with the prefix added.
+func (p *Prefixed) prefixAll(keys []string) []string {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = p.prefix + key
+	}
+	return prefixed
+}
+
+// Purge deletes every key of s starting with prefix, such as the keys of
+// a service that no longer shares s, and returns how many it deleted.
+// Keys written while Purge runs may survive it. The prefix must not be
+// empty, so a typo can't wipe the whole storage.
+//
+// Example:
+//
+//	n, err := storage.Purge(ctx, shared, "svc-a:")
+func Purge(ctx context.Context, s Storage, prefix string) (int, error) {
+	if prefix == "" {
+		return 0, &StorageError{Op: "purge", Err: "prefix must not be empty"}
+	}
+
+	keys, err := s.Keys(ctx, prefix+"*")
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if err := s.Delete(ctx, key); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
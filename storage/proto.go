@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/Vipul984/flexlimit/internal/pbwire"
+)
+
+// StoredState field numbers, see proto/flexlimit/v1/flexlimit.proto.
+const (
+	protoTokens      = 1
+	protoLastRefill  = 2
+	protoCount       = 3
+	protoWindowStart = 4
+	protoTimestamps  = 5
+	protoCreatedAt   = 6
+	protoUpdatedAt   = 7
+	protoMetadata    = 8
+)
+
+// ProtoCodec encodes State as the flexlimit.v1.StoredState protobuf
+// message, so services in other languages can read and update the keys a
+// limiter stores, using code generated from
+// proto/flexlimit/v1/flexlimit.proto.
+//
+// Metadata values must be JSON-like (see structpb.NewStruct). Times are
+// decoded in UTC.
+type ProtoCodec struct{}
+
+// Marshal encodes state as a StoredState message.
+func (ProtoCodec) Marshal(state *State) ([]byte, error) {
+	var b []byte
+	b = pbwire.AppendDouble(b, protoTokens, state.Tokens)
+	b = pbwire.AppendTime(b, protoLastRefill, state.LastRefill)
+	b = pbwire.AppendInt64(b, protoCount, state.Count)
+	b = pbwire.AppendTime(b, protoWindowStart, state.WindowStart)
+	for _, t := range state.Timestamps {
+		b = pbwire.AppendTimestamp(b, protoTimestamps, t)
+	}
+	b = pbwire.AppendTime(b, protoCreatedAt, state.CreatedAt)
+	b = pbwire.AppendTime(b, protoUpdatedAt, state.UpdatedAt)
+	return pbwire.AppendStruct(b, protoMetadata, state.Metadata)
+}
+
+// Unmarshal decodes a StoredState message.
+func (ProtoCodec) Unmarshal(data []byte) (*State, error) {
+	var state State
+	err := pbwire.Range(data, func(f pbwire.Field) error {
+		var err error
+		switch f.Num {
+		case protoTokens:
+			state.Tokens, err = f.Double()
+		case protoLastRefill:
+			state.LastRefill, err = f.Time()
+		case protoCount:
+			state.Count, err = f.Int64()
+		case protoWindowStart:
+			state.WindowStart, err = f.Time()
+		case protoTimestamps:
+			var t time.Time
+			if t, err = f.Time(); err == nil {
+				state.Timestamps = append(state.Timestamps, t)
+			}
+		case protoCreatedAt:
+			state.CreatedAt, err = f.Time()
+		case protoUpdatedAt:
+			state.UpdatedAt, err = f.Time()
+		case protoMetadata:
+			state.Metadata, err = f.Struct()
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
@@ -0,0 +1,74 @@
+package redis
+
+import (
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithCodec stores each key's state as a single blob encoded by codec
+// instead of as individual hash fields.
+//
+// This is how encryption at rest is enabled (see storage.NewAESGCMCodec).
+// Because Lua scripts can't read encoded state, the token bucket no longer
+// runs as one atomic script: Incr uses an optimistic WATCH/MULTI
+// transaction, and TakeTokens reports storage.ErrNotSupported so the
+// algorithm falls back to Get/Set, which is only serialized within a
+// process.
+func WithCodec(codec storage.Codec) Option {
+	return func(s *Store) {
+		s.codec = codec
+	}
+}
+
+// WithCorruptionPolicy sets what happens when a stored value fails to
+// decode or fails checksum validation (see storage.NewChecksumCodec).
+//
+// Default: storage.CorruptionFail
+func WithCorruptionPolicy(policy storage.CorruptionPolicy) Option {
+	return func(s *Store) {
+		s.corruption = policy
+	}
+}
+
+// WithServerTime makes the atomic scripts (Incr, TakeTokens and TakeSlots)
+// read the current time from Redis's clock instead of taking the
+// caller's, so instances whose clocks drift apart refill buckets and
+// expire log entries consistently. Times in their results are reported
+// on the caller's clock. Get, Set and the codec fallbacks still use the
+// caller's clock; see flexlimit.WithMaxClockSkew to correct it.
+//
+// Default: the caller's clock
+func WithServerTime() Option {
+	return func(s *Store) {
+		s.serverTime = true
+	}
+}
+
+// WithPipelineWindow sets the most keys GetMulti and SetMulti send in one
+// pipeline. Larger batches are split into several pipelines, so a huge
+// batch doesn't hold a connection, or the server, for long.
+//
+// Default: 100
+func WithPipelineWindow(keys int) Option {
+	return func(s *Store) {
+		s.pipelineWindow = keys
+	}
+}
+
+// WithHashTags stores the keys an algorithm derives from one rate limit
+// key, such as the buckets of a bucketed sliding window, under a hash tag
+// of the key (see storage.HashTag), so they live on one node of a
+// cluster and are read in one round trip. Stores over a Redis Cluster
+// client do this already; the option is for cluster proxies, or to keep
+// key names unchanged when moving to a cluster.
+//
+// Changing it renames the derived keys, so their windows restart.
+//
+// Default: on for a Redis Cluster client
+func WithHashTags(enabled bool) Option {
+	return func(s *Store) {
+		s.hashTags = enabled
+	}
+}
@@ -0,0 +1,844 @@
+// Package redis provides a Redis-backed Storage for distributed rate limiting.
+//
+// All instances pointing at the same Redis share rate limit state, so a
+// limit of 100 requests per minute holds across the whole fleet rather than
+// per process.
+//
+// Each key is stored as a Redis hash holding the storage.State fields,
+// except sliding window logs, which are sorted sets of request times
+// trimmed with ZREMRANGEBYSCORE, so windows are exact across instances.
+// Operations that must be atomic across instances (Incr, the token bucket
+// refill-and-consume cycle, the sliding window check-and-record cycle) run
+// as Lua scripts.
+//
+// On a Redis Cluster, every script touches a single key, and the keys an
+// algorithm reads together, such as the buckets of a bucketed sliding
+// window, share a hash tag (see WithHashTags), so no command crosses
+// slots. Keys scans every master.
+//
+// With Sentinel (RedisMasterName), the client follows the sentinels to
+// the new master after a failover and reconnects on its own. Commands
+// failing in between are retried (see RedisMaxRetries) and then reported
+// as storage errors, which activate the limiter's fallback strategy until
+// the new master answers; with flexlimit.LocalMemory, requests are
+// limited by local state meanwhile.
+//
+// Example:
+//
+//	store, err := redis.New(storage.Config{RedisAddr: "localhost:6379"})
+//	if err != nil {
+//	    return err
+//	}
+//	limiter, err := flexlimit.New(100, time.Minute, flexlimit.WithStorage(store))
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+var (
+	_ storage.Storage               = (*Store)(nil)
+	_ storage.TokenBucketBatchStore = (*Store)(nil)
+	_ storage.SlidingLogStore       = (*Store)(nil)
+	_ storage.HashTagStore          = (*Store)(nil)
+)
+
+// Hash field names used to store storage.State.
+const (
+	fieldTokens      = "tokens"
+	fieldLastRefill  = "last_refill"
+	fieldCount       = "count"
+	fieldWindowStart = "window_start"
+	fieldTimestamps  = "timestamps"
+	fieldCreatedAt   = "created_at"
+	fieldUpdatedAt   = "updated_at"
+	fieldMetadata    = "metadata"
+
+	// fieldState holds the whole encoded state when a codec is set
+	fieldState = "state"
+)
+
+// backendName identifies this backend in storage errors, and names it in
+// storage.Open.
+const backendName = "redis"
+
+func init() {
+	storage.Register(backendName, func(cfg storage.Config) (storage.Storage, error) {
+		s, err := New(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	})
+}
+
+// maxTxRetries bounds optimistic transaction retries under contention.
+const maxTxRetries = 16
+
+// defaultPipelineWindow is the default for WithPipelineWindow.
+const defaultPipelineWindow = 100
+
+// Store is a Storage backed by Redis.
+//
+// Times are stored as Unix microseconds, which Lua scripts can compare
+// without losing precision.
+type Store struct {
+	client     goredis.UniversalClient
+	ownsClient bool
+	codec      storage.Codec
+	corruption storage.CorruptionPolicy
+	serverTime bool
+
+	pipelineWindow int  // most keys per GetMulti and SetMulti pipeline
+	hashTags       bool // tag derived keys, see WithHashTags
+}
+
+// New connects to Redis using the Redis* and timeout fields of cfg: to a
+// single server at RedisAddr, to a Redis Cluster through the seed nodes of
+// RedisAddrs (or RedisAddr with RedisClusterMode), or to the master named
+// RedisMasterName through the sentinels of RedisAddrs.
+//
+// The connection is verified with a PING bounded by ConnectTimeout
+// (default 5 seconds).
+//
+// Example:
+//
+//	store, err := redis.New(storage.Config{
+//	    RedisAddrs:        []string{"sentinel-1:26379", "sentinel-2:26379", "sentinel-3:26379"},
+//	    RedisMasterName:   "ratelimits",
+//	    RedisPoolSize:     200,
+//	    RedisMinIdleConns: 20,
+//	})
+func New(cfg storage.Config, opts ...Option) (*Store, error) {
+	return dial(cfg, cfg.RedisPoolSize, opts)
+}
+
+// NewSplit connects to Redis twice, returning a store for the request path
+// and an admin store with its own connection pool of cfg.RedisAdminPoolSize
+// connections (default 2). Pass the admin store to
+// flexlimit.WithAdminStorage so key scans, resets and exports can never
+// take connections away from Allow calls.
+//
+// Both stores use the same options and must be closed by the caller.
+//
+// Example:
+//
+//	hot, admin, err := redis.NewSplit(storage.Config{
+//	    RedisAddr:          "localhost:6379",
+//	    RedisPoolSize:      50,
+//	    RedisAdminPoolSize: 2,
+//	})
+//	if err != nil {
+//	    return err
+//	}
+//	defer hot.Close()
+//	defer admin.Close()
+//
+//	limiter, err := flexlimit.New(100, time.Minute,
+//	    flexlimit.WithStorage(hot),
+//	    flexlimit.WithAdminStorage(admin),
+//	)
+func NewSplit(cfg storage.Config, opts ...Option) (hot, admin *Store, err error) {
+	hot, err = dial(cfg, cfg.RedisPoolSize, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	adminPool := cfg.RedisAdminPoolSize
+	if adminPool <= 0 {
+		adminPool = 2
+	}
+	admin, err = dial(cfg, adminPool, opts)
+	if err != nil {
+		hot.Close()
+		return nil, nil, err
+	}
+	return hot, admin, nil
+}
+
+// dial connects a client with the given pool size and wraps it in a Store
+// that owns it.
+func dial(cfg storage.Config, poolSize int, opts []Option) (*Store, error) {
+	if cfg.Codec != "" {
+		codec, err := storage.NewCodec(cfg.Codec)
+		if err != nil {
+			return nil, err
+		}
+		opts = append([]Option{WithCodec(codec)}, opts...)
+	}
+	if cfg.RedisPipelineWindow > 0 {
+		opts = append([]Option{WithPipelineWindow(cfg.RedisPipelineWindow)}, opts...)
+	}
+
+	addrs := cfg.RedisAddrs
+	if len(addrs) == 0 {
+		addrs = []string{cfg.RedisAddr}
+	}
+	client := goredis.NewUniversalClient(&goredis.UniversalOptions{
+		Addrs:            addrs,
+		MasterName:       cfg.RedisMasterName,
+		IsClusterMode:    cfg.RedisClusterMode,
+		Password:         cfg.RedisPassword,
+		SentinelPassword: cfg.RedisSentinelPassword,
+		DB:               cfg.RedisDB,
+		PoolSize:         poolSize,
+		MinIdleConns:     cfg.RedisMinIdleConns,
+		MaxRetries:       cfg.RedisMaxRetries,
+		DialTimeout:      cfg.ConnectTimeout,
+		ReadTimeout:      cfg.ReadTimeout,
+		WriteTimeout:     cfg.WriteTimeout,
+	})
+
+	timeout := cfg.ConnectTimeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, &storage.StorageError{Backend: backendName, Op: "connect", Err: err}
+	}
+
+	s := NewFromClient(client, opts...)
+	s.ownsClient = true
+	return s, nil
+}
+
+// NewFromClient creates a Store over an existing client.
+//
+// The client is not closed by Close; the caller keeps ownership.
+func NewFromClient(client goredis.UniversalClient, opts ...Option) *Store {
+	_, cluster := client.(*goredis.ClusterClient)
+	s := &Store{client: client, hashTags: cluster}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.pipelineWindow <= 0 {
+		s.pipelineWindow = defaultPipelineWindow
+	}
+	return s
+}
+
+// Client returns the underlying Redis client.
+func (s *Store) Client() goredis.UniversalClient {
+	return s.client
+}
+
+// Get retrieves the state for key.
+func (s *Store) Get(ctx context.Context, key string) (*storage.State, error) {
+	fields, err := s.client.HGetAll(ctx, key).Result()
+	if isWrongType(err) {
+		return s.getLog(ctx, key)
+	}
+	if err != nil {
+		return nil, wrapError("get", key, err)
+	}
+	if len(fields) == 0 {
+		return nil, storage.ErrKeyNotFound
+	}
+
+	state, err := s.decode(key, fields)
+	if err != nil {
+		return nil, s.handleCorruption(ctx, key, err)
+	}
+	return state, nil
+}
+
+// Set replaces the state for key.
+func (s *Store) Set(ctx context.Context, key string, state *storage.State, ttl time.Duration) error {
+	_, err := s.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		return s.setState(ctx, pipe, key, state, ttl)
+	})
+	return wrapError("set", key, err)
+}
+
+// Incr atomically adds amount to the count field of key, creating it with
+// the given TTL if it doesn't exist.
+func (s *Store) Incr(ctx context.Context, key string, amount int64, ttl time.Duration) (int64, error) {
+	if s.codec != nil {
+		return s.incrEncoded(ctx, key, amount, ttl)
+	}
+
+	n, err := incrScript.Run(ctx, s.client, []string{key},
+		amount, ttl.Milliseconds(), s.now(time.Now())).Int64()
+	if err != nil {
+		return 0, wrapError("incr", key, err)
+	}
+	return n, nil
+}
+
+// Delete removes key.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	return wrapError("delete", key, s.client.Del(ctx, key).Err())
+}
+
+// Exists reports whether key exists.
+func (s *Store) Exists(ctx context.Context, key string) (bool, error) {
+	n, err := s.client.Exists(ctx, key).Result()
+	if err != nil {
+		return false, wrapError("exists", key, err)
+	}
+	return n > 0, nil
+}
+
+// GetMulti retrieves several keys in pipelined round trips of up to the
+// pipeline window (see WithPipelineWindow) each.
+func (s *Store) GetMulti(ctx context.Context, keys []string) ([]*storage.State, error) {
+	cmds := make([]*goredis.MapStringStringCmd, len(keys))
+	for start := 0; start < len(keys); start += s.pipelineWindow {
+		end := min(start+s.pipelineWindow, len(keys))
+		_, err := s.client.Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+			for i := start; i < end; i++ {
+				cmds[i] = pipe.HGetAll(ctx, keys[i])
+			}
+			return nil
+		})
+		if err != nil && !isWrongType(err) {
+			return nil, wrapError("get_multi", "", err)
+		}
+	}
+
+	var err error
+	states := make([]*storage.State, len(keys))
+	for i, cmd := range cmds {
+		if isWrongType(cmd.Err()) {
+			if states[i], err = s.getLog(ctx, keys[i]); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := cmd.Err(); err != nil {
+			return nil, wrapError("get_multi", keys[i], err)
+		}
+		fields := cmd.Val()
+		if len(fields) == 0 {
+			continue
+		}
+		if states[i], err = s.decode(keys[i], fields); err != nil {
+			if err = s.handleCorruption(ctx, keys[i], err); !errors.Is(err, storage.ErrKeyNotFound) {
+				return nil, err
+			}
+		}
+	}
+	return states, nil
+}
+
+// SetMulti stores several keys in transactions of up to the pipeline
+// window (see WithPipelineWindow) each, so every key is replaced
+// atomically but the batch as a whole isn't. On a Redis Cluster, each
+// transaction is further split by hash slot.
+func (s *Store) SetMulti(ctx context.Context, states map[string]*storage.State, ttl time.Duration) error {
+	keys := make([]string, 0, len(states))
+	for key := range states {
+		keys = append(keys, key)
+	}
+
+	for start := 0; start < len(keys); start += s.pipelineWindow {
+		chunk := keys[start:min(start+s.pipelineWindow, len(keys))]
+		_, err := s.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			for _, key := range chunk {
+				if err := s.setState(ctx, pipe, key, states[key], ttl); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return wrapError("set_multi", "", err)
+		}
+	}
+	return nil
+}
+
+// Keys returns all keys matching a Redis glob pattern, using SCAN so the
+// server isn't blocked. On a Redis Cluster, every master is scanned.
+func (s *Store) Keys(ctx context.Context, pattern string) ([]string, error) {
+	if pattern == "" {
+		pattern = "*"
+	}
+
+	cluster, ok := s.client.(*goredis.ClusterClient)
+	if !ok {
+		keys, err := scan(ctx, s.client, pattern)
+		return keys, wrapError("keys", "", err)
+	}
+
+	var mu sync.Mutex
+	var keys []string
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, master *goredis.Client) error {
+		found, err := scan(ctx, master, pattern)
+		mu.Lock()
+		keys = append(keys, found...)
+		mu.Unlock()
+		return err
+	})
+	if err != nil {
+		return nil, wrapError("keys", "", err)
+	}
+	return keys, nil
+}
+
+// HashTags reports whether the buckets of a key are stored under a hash
+// tag, so they live on one node: on a Redis Cluster, or with
+// WithHashTags.
+func (s *Store) HashTags() bool {
+	return s.hashTags
+}
+
+// scan returns the keys of one server matching pattern.
+func scan(ctx context.Context, client goredis.Cmdable, pattern string) ([]string, error) {
+	var keys []string
+	iter := client.Scan(ctx, 0, pattern, 1000).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Close closes the client if the Store created it.
+func (s *Store) Close() error {
+	if !s.ownsClient {
+		return nil
+	}
+	return s.client.Close()
+}
+
+// Ping checks that Redis is reachable.
+func (s *Store) Ping(ctx context.Context) error {
+	return wrapError("ping", "", s.client.Ping(ctx).Err())
+}
+
+// TakeTokens runs the token bucket cycle atomically in a Lua script.
+//
+// Returns storage.ErrNotSupported when a codec is configured.
+func (s *Store) TakeTokens(ctx context.Context, key string, req storage.TokenBucketRequest) (storage.TokenBucketResult, error) {
+	if s.codec != nil {
+		return storage.TokenBucketResult{}, storage.ErrNotSupported
+	}
+
+	res, err := tokenBucketScript.Run(ctx, s.client, []string{key}, s.tokenBucketArgs(req)...).Slice()
+	if err != nil {
+		return storage.TokenBucketResult{}, wrapError("take_tokens", key, err)
+	}
+	return parseTokenBucketReply(key, res)
+}
+
+// TakeTokensMulti runs the token bucket script for several keys in one
+// pipelined round trip, loading the script first if Redis doesn't have it
+// cached.
+//
+// Returns storage.ErrNotSupported when a codec is configured.
+func (s *Store) TakeTokensMulti(ctx context.Context, keys []string, reqs []storage.TokenBucketRequest) ([]storage.TokenBucketResult, error) {
+	if s.codec != nil {
+		return nil, storage.ErrNotSupported
+	}
+
+	cmds, err := s.takeTokensPipelined(ctx, keys, reqs)
+	if err != nil && goredis.HasErrorPrefix(err, "NOSCRIPT") {
+		if err = tokenBucketScript.Load(ctx, s.client).Err(); err == nil {
+			cmds, err = s.takeTokensPipelined(ctx, keys, reqs)
+		}
+	}
+	if err != nil {
+		return nil, wrapError("take_tokens_multi", "", err)
+	}
+
+	results := make([]storage.TokenBucketResult, len(keys))
+	for i, cmd := range cmds {
+		res, err := cmd.Slice()
+		if err != nil {
+			return nil, wrapError("take_tokens_multi", keys[i], err)
+		}
+		if results[i], err = parseTokenBucketReply(keys[i], res); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// takeTokensPipelined sends one EVALSHA of the token bucket script per key
+// in a single pipeline.
+func (s *Store) takeTokensPipelined(ctx context.Context, keys []string, reqs []storage.TokenBucketRequest) ([]*goredis.Cmd, error) {
+	cmds := make([]*goredis.Cmd, len(keys))
+	_, err := s.client.Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = tokenBucketScript.EvalSha(ctx, pipe, []string{key}, s.tokenBucketArgs(reqs[i])...)
+		}
+		return nil
+	})
+	return cmds, err
+}
+
+// TakeSlots runs the sliding window log cycle atomically in a Lua script,
+// keeping the log in a sorted set.
+//
+// Returns storage.ErrNotSupported when a codec is configured.
+func (s *Store) TakeSlots(ctx context.Context, key string, req storage.SlidingLogRequest) (storage.SlidingLogResult, error) {
+	if s.codec != nil {
+		return storage.SlidingLogResult{}, storage.ErrNotSupported
+	}
+
+	now := req.Now.UnixMicro()
+	res, err := slidingLogScript.Run(ctx, s.client, []string{key},
+		req.Limit,
+		req.Window.Microseconds(),
+		req.Cost,
+		req.Need,
+		s.now(req.Now),
+		fmt.Sprintf("%d:%016x:", now, rand.Uint64()),
+	).Int64Slice()
+	if err != nil {
+		return storage.SlidingLogResult{}, wrapError("take_slots", key, err)
+	}
+	if len(res) != 5 {
+		return storage.SlidingLogResult{}, &storage.StorageError{
+			Backend: backendName,
+			Op:      "take_slots", Key: key, Err: "unexpected script reply",
+		}
+	}
+
+	// Entries are timed by the clock the script used; report them on the
+	// caller's
+	offset := res[4] - now
+	return storage.SlidingLogResult{
+		Allowed:  res[0] == 1,
+		Count:    res[1],
+		Newest:   unixMicro(res[2], offset),
+		Expiring: unixMicro(res[3], offset),
+	}, nil
+}
+
+// getLog reads a sliding window log kept in a sorted set by TakeSlots.
+func (s *Store) getLog(ctx context.Context, key string) (*storage.State, error) {
+	entries, err := s.client.ZRangeWithScores(ctx, key, 0, -1).Result()
+	if err != nil {
+		return nil, wrapError("get", key, err)
+	}
+	if len(entries) == 0 {
+		return nil, storage.ErrKeyNotFound
+	}
+
+	state := &storage.State{Timestamps: make([]time.Time, len(entries))}
+	for i, e := range entries {
+		state.Timestamps[i] = time.UnixMicro(int64(e.Score))
+	}
+	state.CreatedAt = state.Timestamps[0]
+	state.UpdatedAt = state.Timestamps[len(entries)-1]
+	return state, nil
+}
+
+// isWrongType reports whether err is Redis refusing a command on a key of
+// another type, such as HGETALL on a sliding window log.
+func isWrongType(err error) bool {
+	return err != nil && goredis.HasErrorPrefix(err, "WRONGTYPE")
+}
+
+// unixMicro returns the time of us Unix microseconds less offset, or zero
+// if us is 0.
+func unixMicro(us, offset int64) time.Time {
+	if us == 0 {
+		return time.Time{}
+	}
+	return time.UnixMicro(us - offset)
+}
+
+// now returns the time argument of a script called at t: t in Unix
+// microseconds, or 0 for Redis's clock with WithServerTime.
+func (s *Store) now(t time.Time) int64 {
+	if s.serverTime {
+		return 0
+	}
+	return t.UnixMicro()
+}
+
+// Time returns the time of the Redis server, so the limiter can measure
+// the skew of its own clock (see flexlimit.WithMaxClockSkew).
+func (s *Store) Time(ctx context.Context) (time.Time, error) {
+	t, err := s.client.Time(ctx).Result()
+	return t, wrapError("time", "", err)
+}
+
+// tokenBucketArgs returns the ARGV of tokenBucketScript for req.
+func (s *Store) tokenBucketArgs(req storage.TokenBucketRequest) []interface{} {
+	return []interface{}{
+		strconv.FormatFloat(req.Capacity, 'f', -1, 64),
+		strconv.FormatFloat(req.RefillRate/1e6, 'f', -1, 64), // per microsecond
+		strconv.FormatFloat(req.Cost, 'f', -1, 64),
+		s.now(req.Now),
+		req.TTL.Milliseconds(),
+	}
+}
+
+// parseTokenBucketReply decodes the reply of tokenBucketScript for key.
+func parseTokenBucketReply(key string, res []interface{}) (storage.TokenBucketResult, error) {
+	if len(res) != 2 {
+		return storage.TokenBucketResult{}, &storage.StorageError{
+			Backend: backendName,
+			Op:      "take_tokens", Key: key, Err: "unexpected script reply",
+		}
+	}
+
+	allowed, _ := res[0].(int64)
+	tokensStr, _ := res[1].(string)
+	tokens, err := strconv.ParseFloat(tokensStr, 64)
+	if err != nil {
+		return storage.TokenBucketResult{}, &storage.StorageError{
+			Backend: backendName,
+			Op:      "take_tokens", Key: key, Err: err,
+		}
+	}
+
+	return storage.TokenBucketResult{Allowed: allowed == 1, Tokens: tokens}, nil
+}
+
+// incrEncoded implements Incr for codec-encoded state with an optimistic
+// transaction, retrying if another client modifies key concurrently.
+func (s *Store) incrEncoded(ctx context.Context, key string, amount int64, ttl time.Duration) (int64, error) {
+	var count int64
+
+	txf := func(tx *goredis.Tx) error {
+		fields, err := tx.HGetAll(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+
+		state := &storage.State{}
+		created := len(fields) == 0
+		if !created {
+			if state, err = s.decode(key, fields); err != nil {
+				return err
+			}
+		}
+
+		state.Count += amount
+		count = state.Count
+
+		encoded, err := s.encode(key, state)
+		if err != nil {
+			return &storage.StorageError{Backend: backendName, Op: "serialize", Key: key, Err: err}
+		}
+
+		// HSET overwrites the single state field in place, so an existing
+		// key keeps its TTL.
+		_, err = tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			pipe.HSet(ctx, key, encoded)
+			if created && ttl > 0 {
+				pipe.PExpire(ctx, key, ttl)
+			}
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < maxTxRetries; i++ {
+		err := s.client.Watch(ctx, txf, key)
+		if errors.Is(err, goredis.TxFailedErr) {
+			continue
+		}
+		if err != nil {
+			return 0, wrapError("incr", key, err)
+		}
+		return count, nil
+	}
+
+	return 0, &storage.StorageError{Backend: backendName, Op: "incr", Key: key, Err: "too much contention"}
+}
+
+// handleCorruption applies the corruption policy to a decode error.
+// Under CorruptionReset the key is deleted and ErrKeyNotFound returned.
+func (s *Store) handleCorruption(ctx context.Context, key string, err error) error {
+	if s.corruption != storage.CorruptionReset || !errors.Is(err, storage.ErrInvalidState) {
+		return err
+	}
+	if delErr := s.client.Del(ctx, key).Err(); delErr != nil {
+		return wrapError("delete", key, delErr)
+	}
+	return storage.ErrKeyNotFound
+}
+
+// decode parses a stored hash into a State.
+func (s *Store) decode(key string, fields map[string]string) (*storage.State, error) {
+	if s.codec == nil {
+		return decodeState(key, fields)
+	}
+
+	state, err := storage.UnmarshalKey(s.codec, key, []byte(fields[fieldState]))
+	if err != nil {
+		return nil, &storage.StorageError{
+			Backend: backendName,
+			Op:      "deserialize",
+			Key:     key,
+			Err:     &storage.InvalidStateError{Err: err},
+		}
+	}
+	return state, nil
+}
+
+// setState queues the commands that replace key with state.
+func (s *Store) setState(ctx context.Context, pipe goredis.Pipeliner, key string, state *storage.State, ttl time.Duration) error {
+	fields, err := s.encode(key, state)
+	if err != nil {
+		return &storage.StorageError{Backend: backendName, Op: "serialize", Key: key, Err: err}
+	}
+
+	pipe.Del(ctx, key)
+	pipe.HSet(ctx, key, fields)
+	if ttl > 0 {
+		pipe.PExpire(ctx, key, ttl)
+	}
+	return nil
+}
+
+// encode converts the state of key into hash fields.
+func (s *Store) encode(key string, state *storage.State) (map[string]interface{}, error) {
+	if s.codec == nil {
+		return encodeState(state)
+	}
+
+	blob, err := storage.MarshalKey(s.codec, key, state)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{fieldState: blob}, nil
+}
+
+// encodeState converts state into hash fields. Zero values are omitted.
+func encodeState(state *storage.State) (map[string]interface{}, error) {
+	now := time.Now()
+	fields := map[string]interface{}{
+		fieldTokens:    strconv.FormatFloat(state.Tokens, 'g', -1, 64),
+		fieldCount:     state.Count,
+		fieldCreatedAt: micros(state.CreatedAt, now),
+		fieldUpdatedAt: now.UnixMicro(),
+	}
+
+	if !state.LastRefill.IsZero() {
+		fields[fieldLastRefill] = state.LastRefill.UnixMicro()
+	}
+	if !state.WindowStart.IsZero() {
+		fields[fieldWindowStart] = state.WindowStart.UnixMicro()
+	}
+
+	if len(state.Timestamps) > 0 {
+		ts := make([]int64, len(state.Timestamps))
+		for i, t := range state.Timestamps {
+			ts[i] = t.UnixMicro()
+		}
+		b, err := json.Marshal(ts)
+		if err != nil {
+			return nil, err
+		}
+		fields[fieldTimestamps] = b
+	}
+
+	if len(state.Metadata) > 0 {
+		b, err := json.Marshal(state.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		fields[fieldMetadata] = b
+	}
+
+	return fields, nil
+}
+
+// decodeState parses hash fields into a State. Fields that fail to parse
+// are reported as storage.ErrInvalidState.
+func decodeState(key string, fields map[string]string) (*storage.State, error) {
+	var (
+		state storage.State
+		err   error
+	)
+
+	invalid := func(field string, cause error) error {
+		return &storage.StorageError{
+			Backend: backendName,
+			Op:      "deserialize",
+			Key:     key,
+			Err:     &storage.InvalidStateError{Err: fmt.Errorf("field %s: %w", field, cause)},
+		}
+	}
+
+	if v, ok := fields[fieldTokens]; ok {
+		if state.Tokens, err = strconv.ParseFloat(v, 64); err != nil {
+			return nil, invalid(fieldTokens, err)
+		}
+	}
+	if v, ok := fields[fieldCount]; ok {
+		if state.Count, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, invalid(fieldCount, err)
+		}
+	}
+
+	for field, dst := range map[string]*time.Time{
+		fieldLastRefill:  &state.LastRefill,
+		fieldWindowStart: &state.WindowStart,
+		fieldCreatedAt:   &state.CreatedAt,
+		fieldUpdatedAt:   &state.UpdatedAt,
+	} {
+		v, ok := fields[field]
+		if !ok {
+			continue
+		}
+		us, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, invalid(field, err)
+		}
+		*dst = time.UnixMicro(us)
+	}
+
+	if v, ok := fields[fieldTimestamps]; ok {
+		var ts []int64
+		if err := json.Unmarshal([]byte(v), &ts); err != nil {
+			return nil, invalid(fieldTimestamps, err)
+		}
+		state.Timestamps = make([]time.Time, len(ts))
+		for i, us := range ts {
+			state.Timestamps[i] = time.UnixMicro(us)
+		}
+	}
+
+	if v, ok := fields[fieldMetadata]; ok {
+		if err := json.Unmarshal([]byte(v), &state.Metadata); err != nil {
+			return nil, invalid(fieldMetadata, err)
+		}
+	}
+
+	return &state, nil
+}
+
+// micros returns t as Unix microseconds, or def if t is zero.
+func micros(t, def time.Time) int64 {
+	if t.IsZero() {
+		return def.UnixMicro()
+	}
+	return t.UnixMicro()
+}
+
+// wrapError converts a Redis error into a storage error.
+func wrapError(op, key string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return &storage.StorageError{Backend: backendName, Op: op, Key: key, Err: err}
+}
@@ -0,0 +1,89 @@
+package redis_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/Vipul984/flexlimit/storage"
+	"github.com/Vipul984/flexlimit/storage/redis"
+	"github.com/Vipul984/flexlimit/storage/storagetest"
+)
+
+// newStore returns a Store backed by a fresh in-process Redis server.
+func newStore(t *testing.T, opts ...redis.Option) (*redis.Store, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	store, err := redis.New(storage.Config{RedisAddr: mr.Addr()}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store, mr
+}
+
+func TestStore(t *testing.T) {
+	var mr *miniredis.Miniredis
+	storagetest.TestStorage(t, func(t *testing.T) storage.Storage {
+		var store *redis.Store
+		store, mr = newStore(t)
+		return store
+	}, storagetest.WithAdvance(func(d time.Duration) { mr.FastForward(d) }))
+}
+
+func TestServerTime(t *testing.T) {
+	store, mr := newStore(t, redis.WithServerTime())
+	ctx := context.Background()
+	server := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	caller := server.Add(time.Hour) // the caller's clock runs an hour ahead
+	mr.SetTime(server)
+
+	got, err := store.Time(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(server) {
+		t.Errorf("Time = %v, want the server's %v", got, server)
+	}
+
+	take := func(cost float64) storage.TokenBucketResult {
+		t.Helper()
+		res, err := store.TakeTokens(ctx, "bucket", storage.TokenBucketRequest{
+			Capacity: 10, RefillRate: 1, Cost: cost, Now: caller,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	take(10)
+
+	// The caller's clock stands still; the bucket refills by the server's
+	mr.SetTime(server.Add(2 * time.Second))
+	if res := take(0); res.Tokens != 2 {
+		t.Errorf("tokens = %g, want 2 refilled over the server's 2s", res.Tokens)
+	}
+
+	// Log entries are timed by the server, and reported on the caller's clock
+	res, err := store.TakeSlots(ctx, "log", storage.SlidingLogRequest{
+		Limit: 1, Window: time.Second, Cost: 1, Need: 1, Now: caller,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Allowed || !res.Newest.Equal(caller) {
+		t.Errorf("got allowed %v with newest %v, want an entry at %v", res.Allowed, res.Newest, caller)
+	}
+	mr.SetTime(server.Add(3 * time.Second))
+	res, err = store.TakeSlots(ctx, "log", storage.SlidingLogRequest{
+		Limit: 1, Window: time.Second, Cost: 1, Need: 1, Now: caller,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Allowed || res.Count != 1 {
+		t.Errorf("got allowed %v with %d entries, want the first entry expired on the server's clock", res.Allowed, res.Count)
+	}
+}
@@ -0,0 +1,171 @@
+package redis
+
+import (
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// serverNow is prepended to scripts taking the current time: a time of 0
+// is replaced by Redis's own clock (see WithServerTime). TIME makes the
+// script non-deterministic, which Redis before 5.0 only allows once
+// replication of its effects is turned on.
+const serverNow = `
+local function server_now(arg)
+  local now = tonumber(arg)
+  if now ~= 0 then
+    return now, arg
+  end
+  if redis.replicate_commands then
+    redis.replicate_commands()
+  end
+  local t = redis.call('TIME')
+  now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+  return now, string.format('%d', now)
+end
+`
+
+// incrScript adds ARGV[1] to the count field, setting the TTL and creation
+// time only when the key is new.
+//
+// KEYS[1] = key
+// ARGV[1] = amount
+// ARGV[2] = ttl in milliseconds (0 = no expiry)
+// ARGV[3] = now in Unix microseconds (0 = Redis's clock)
+var incrScript = goredis.NewScript(serverNow + `
+local _, now = server_now(ARGV[3])
+local created = redis.call('EXISTS', KEYS[1]) == 0
+local count = redis.call('HINCRBY', KEYS[1], 'count', ARGV[1])
+redis.call('HSET', KEYS[1], 'updated_at', now)
+if created then
+  redis.call('HSET', KEYS[1], 'created_at', now)
+  local ttl = tonumber(ARGV[2])
+  if ttl > 0 then
+    redis.call('PEXPIRE', KEYS[1], ttl)
+  end
+end
+return count
+`)
+
+// tokenBucketScript refills and consumes a token bucket in one atomic step.
+//
+// Tokens are returned as a string because Redis truncates Lua numbers to
+// integers in replies.
+//
+// KEYS[1] = key
+// ARGV[1] = capacity
+// ARGV[2] = refill rate in tokens per microsecond
+// ARGV[3] = cost (0 = read only, negative = refund)
+// ARGV[4] = now in Unix microseconds (0 = Redis's clock)
+// ARGV[5] = ttl in milliseconds (0 = no expiry)
+//
+// Returns {allowed (0|1), tokens remaining}.
+var tokenBucketScript = goredis.NewScript(serverNow + `
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local now, now_str = server_now(ARGV[4])
+local ttl = tonumber(ARGV[5])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'last_refill')
+local tokens = tonumber(state[1])
+local last = tonumber(state[2])
+if tokens == nil or last == nil then
+  tokens = capacity
+  last = now
+end
+
+local elapsed = math.max(0, now - last)
+tokens = math.min(capacity, tokens + elapsed * rate)
+
+local allowed = 0
+if cost < 0 then
+  tokens = math.min(capacity, tokens - cost)
+  allowed = 1
+elseif cost > 0 and tokens >= cost then
+  tokens = tokens - cost
+  allowed = 1
+end
+
+if allowed == 1 then
+  local created = redis.call('EXISTS', KEYS[1]) == 0
+  redis.call('HSET', KEYS[1],
+    'tokens', string.format('%.17g', tokens),
+    'last_refill', now_str,
+    'updated_at', now_str)
+  if created then
+    redis.call('HSET', KEYS[1], 'created_at', now_str)
+  end
+  if ttl > 0 then
+    redis.call('PEXPIRE', KEYS[1], ttl)
+  end
+end
+
+return {allowed, string.format('%.17g', tokens)}
+`)
+
+// slidingLogScript checks and records requests in a sliding window log
+// kept as a sorted set of entries scored by their time. A key holding a
+// hash, written by Set, is first converted from its timestamps field.
+//
+// KEYS[1] = key
+// ARGV[1] = limit
+// ARGV[2] = window in microseconds
+// ARGV[3] = cost (0 = read only, negative = remove the newest entries)
+// ARGV[4] = need (entries to report room for if cost isn't added)
+// ARGV[5] = now in Unix microseconds (0 = Redis's clock)
+// ARGV[6] = member prefix unique to this call
+//
+// Returns {allowed (0|1), count, newest, expiring, now}, times in Unix
+// microseconds or 0.
+var slidingLogScript = goredis.NewScript(serverNow + `
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local need = tonumber(ARGV[4])
+local now = server_now(ARGV[5])
+
+if redis.call('TYPE', KEYS[1]).ok == 'hash' then
+  local ts = redis.call('HGET', KEYS[1], 'timestamps')
+  redis.call('DEL', KEYS[1])
+  if ts then
+    for i, t in ipairs(cjson.decode(ts)) do
+      redis.call('ZADD', KEYS[1], t, 'h' .. t .. ':' .. i)
+    end
+  end
+end
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+
+local allowed = 0
+if cost < 0 then
+  if count > 0 then
+    redis.call('ZPOPMAX', KEYS[1], -cost)
+    count = math.max(0, count + cost)
+  end
+  allowed = 1
+elseif cost > 0 and count + cost <= limit then
+  for i = 1, cost do
+    redis.call('ZADD', KEYS[1], now, ARGV[6] .. i)
+  end
+  count = count + cost
+  allowed = 1
+end
+if cost ~= 0 and count > 0 then
+  redis.call('PEXPIRE', KEYS[1], math.ceil(window / 1000))
+end
+
+local newest = 0
+local last = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+if #last == 2 then
+  newest = tonumber(last[2])
+end
+
+local expiring = 0
+local excess = count + need - limit
+if allowed == 0 and need > 0 and excess > 0 and excess <= count then
+  local entry = redis.call('ZRANGE', KEYS[1], excess - 1, excess - 1, 'WITHSCORES')
+  expiring = tonumber(entry[2])
+end
+
+return {allowed, count, newest, expiring, now}
+`)
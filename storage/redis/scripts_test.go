@@ -0,0 +1,194 @@
+package redis_test
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+func TestTakeTokens(t *testing.T) {
+	store, mr := newStore(t)
+	ctx := context.Background()
+	t0 := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		name    string
+		at      time.Duration
+		cost    float64
+		allowed bool
+		tokens  float64
+	}{
+		{"new bucket starts full", 0, 4, true, 6},
+		{"cost over the tokens left", 0, 7, false, 6},
+		{"read refills without consuming", 2 * time.Second, 0, false, 8},
+		{"refund capped at capacity", 2 * time.Second, -5, true, 10},
+		{"cost of every token", 2 * time.Second, 10, true, 0},
+		{"clock going back refills nothing", time.Second, 1, false, 0},
+		{"half a second refills half a token", 2500 * time.Millisecond, 0.5, true, 0},
+	}
+	for _, step := range steps {
+		res, err := store.TakeTokens(ctx, "bucket", storage.TokenBucketRequest{
+			Capacity:   10,
+			RefillRate: 1,
+			Cost:       step.cost,
+			Now:        t0.Add(step.at),
+			TTL:        time.Minute,
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if res.Allowed != step.allowed || math.Abs(res.Tokens-step.tokens) > 1e-9 {
+			t.Errorf("%s: got allowed %v with %g tokens, want %v with %g", step.name, res.Allowed, res.Tokens, step.allowed, step.tokens)
+		}
+	}
+
+	if ttl := mr.TTL("bucket"); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("TTL = %v, want up to a minute", ttl)
+	}
+}
+
+func TestTakeTokensReadOnly(t *testing.T) {
+	store, mr := newStore(t)
+
+	res, err := store.TakeTokens(context.Background(), "bucket", storage.TokenBucketRequest{
+		Capacity:   10,
+		RefillRate: 1,
+		Now:        time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed || res.Tokens != 10 {
+		t.Errorf("got allowed %v with %g tokens, want a full bucket of 10 and no consumption", res.Allowed, res.Tokens)
+	}
+	if mr.Exists("bucket") {
+		t.Error("reading a bucket created it")
+	}
+}
+
+func TestTakeTokensMulti(t *testing.T) {
+	store, _ := newStore(t)
+	now := time.Now()
+
+	req := storage.TokenBucketRequest{Capacity: 2, RefillRate: 1, Cost: 2, Now: now}
+	results, err := store.TakeTokensMulti(context.Background(), []string{"a", "b", "a"},
+		[]storage.TokenBucketRequest{req, req, req})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []bool{true, true, false}
+	for i, res := range results {
+		if res.Allowed != want[i] {
+			t.Errorf("result %d allowed = %v, want %v", i, res.Allowed, want[i])
+		}
+	}
+}
+
+func TestTakeSlots(t *testing.T) {
+	store, _ := newStore(t)
+	ctx := context.Background()
+	t0 := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		name     string
+		at       time.Duration
+		cost     int64
+		need     int64
+		allowed  bool
+		count    int64
+		newest   time.Duration // from t0, -1 for none
+		expiring time.Duration // from t0, -1 for none
+	}{
+		{"entries within the limit", 0, 2, 2, true, 2, 0, -1},
+		{"entries over the limit", 100 * time.Millisecond, 2, 2, false, 2, 0, 0},
+		{"read", 200 * time.Millisecond, 0, 1, false, 2, 0, -1},
+		{"one more fits", 300 * time.Millisecond, 1, 1, true, 3, 300 * time.Millisecond, -1},
+		{"old entries leave the window", 1100 * time.Millisecond, 1, 1, true, 2, 1100 * time.Millisecond, -1},
+		{"refund removes the newest", 1100 * time.Millisecond, -1, 0, true, 1, 300 * time.Millisecond, -1},
+	}
+	for _, step := range steps {
+		res, err := store.TakeSlots(ctx, "log", storage.SlidingLogRequest{
+			Limit:  3,
+			Window: time.Second,
+			Cost:   step.cost,
+			Need:   step.need,
+			Now:    t0.Add(step.at),
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if res.Allowed != step.allowed || res.Count != step.count {
+			t.Errorf("%s: got allowed %v with %d entries, want %v with %d", step.name, res.Allowed, res.Count, step.allowed, step.count)
+		}
+		if want := offsetTime(t0, step.newest); !res.Newest.Equal(want) {
+			t.Errorf("%s: newest = %v, want %v", step.name, res.Newest, want)
+		}
+		if want := offsetTime(t0, step.expiring); !res.Expiring.Equal(want) {
+			t.Errorf("%s: expiring = %v, want %v", step.name, res.Expiring, want)
+		}
+	}
+}
+
+func TestTakeSlotsConvertsSetState(t *testing.T) {
+	store, _ := newStore(t)
+	ctx := context.Background()
+	t0 := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	err := store.Set(ctx, "log", &storage.State{Timestamps: []time.Time{t0, t0.Add(time.Millisecond)}}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := store.TakeSlots(ctx, "log", storage.SlidingLogRequest{
+		Limit: 3, Window: time.Second, Cost: 1, Need: 1, Now: t0.Add(2 * time.Millisecond),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Allowed || res.Count != 3 {
+		t.Errorf("got allowed %v with %d entries, want the 2 set entries plus 1", res.Allowed, res.Count)
+	}
+
+	st, err := store.Get(ctx, "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Timestamps) != 3 {
+		t.Errorf("Get returned %d timestamps, want 3", len(st.Timestamps))
+	}
+}
+
+func TestIncrScript(t *testing.T) {
+	store, mr := newStore(t)
+	ctx := context.Background()
+
+	for i, want := range []int64{2, 5, 4} {
+		amount := []int64{2, 3, -1}[i]
+		n, err := store.Incr(ctx, "counter", amount, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want {
+			t.Errorf("Incr(%d) = %d, want %d", amount, n, want)
+		}
+		if i == 0 {
+			mr.FastForward(30 * time.Second)
+		}
+	}
+
+	// The TTL is only set when the counter is created
+	if ttl := mr.TTL("counter"); ttl != 30*time.Second {
+		t.Errorf("TTL = %v, want the 30s left of the first Incr's minute", ttl)
+	}
+}
+
+// offsetTime returns t0 plus d, or the zero time for a negative d.
+func offsetTime(t0 time.Time, d time.Duration) time.Time {
+	if d < 0 {
+		return time.Time{}
+	}
+	return t0.Add(d)
+}
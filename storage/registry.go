@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Factory creates a storage backend from cfg.
+type Factory func(cfg Config) (Storage, error)
+
+// registry holds the backends Open can create, by Config.Backend.
+var registry = struct {
+	sync.RWMutex
+	factories map[string]Factory
+}{factories: map[string]Factory{
+	"memory": func(cfg Config) (Storage, error) { return NewMemory(cfg), nil },
+}}
+
+// Register makes a backend available to Open, and to configuration
+// files, under name. "memory" is always registered; "redis" and "etcd"
+// are registered when their packages are imported. Like
+// database/sql.Register, it is meant to be called from an init function,
+// and panics if name is empty or already registered, or if factory is
+// nil.
+//
+// Third-party backends read the fields of Config they need, and should
+// pass the conformance tests of the storagetest package.
+//
+// Example:
+//
+//	func init() {
+//	    storage.Register("cassandra", func(cfg storage.Config) (storage.Storage, error) {
+//	        return cassandra.New(cfg)
+//	    })
+//	}
+func Register(name string, factory Factory) {
+	if name == "" {
+		panic("storage: Register with an empty name")
+	}
+	if factory == nil {
+		panic("storage: Register factory is nil for " + name)
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	if _, dup := registry.factories[name]; dup {
+		panic("storage: Register called twice for " + name)
+	}
+	registry.factories[name] = factory
+}
+
+// Lookup returns the factory registered under name.
+func Lookup(name string) (Factory, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	f, ok := registry.factories[name]
+	return f, ok
+}
+
+// Names returns the names of the registered backends, sorted.
+func Names() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	names := make([]string, 0, len(registry.factories))
+	for name := range registry.factories {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Open creates the backend named by cfg.Backend with cfg. An empty
+// Backend selects "memory". Backends other than memory must be
+// registered first, usually by importing their package:
+//
+//	import _ "github.com/Vipul984/flexlimit/storage/redis"
+//
+//	store, err := storage.Open(storage.Config{Backend: "redis", RedisAddr: "localhost:6379"})
+//
+// Returns a *StorageError if no backend is registered under the name.
+func Open(cfg Config) (Storage, error) {
+	if cfg.Backend == "" {
+		cfg.Backend = "memory"
+	}
+
+	factory, ok := Lookup(cfg.Backend)
+	if !ok {
+		return nil, &StorageError{Op: "open", Err: fmt.Sprintf("unknown backend %q (registered: %v)", cfg.Backend, Names())}
+	}
+	return factory(cfg)
+}
@@ -0,0 +1,535 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	_ Storage          = (*Sharded)(nil)
+	_ TokenBucketStore = (*Sharded)(nil)
+	_ SlidingLogStore  = (*Sharded)(nil)
+)
+
+// shardedBackend identifies Sharded in StorageErrors.
+const shardedBackend = "sharded"
+
+// ShardedConfig configures a Sharded storage.
+type ShardedConfig struct {
+	// Replicas is the number of points each shard gets on the hash ring.
+	// More points spread keys more evenly. Default: 128
+	Replicas int
+
+	// Fallback serves the keys of unhealthy shards, typically an in-memory
+	// store. If nil, they move to the next healthy shard on the ring.
+	Fallback Storage
+
+	// ProbeInterval is how often an unhealthy shard is pinged.
+	// Default: 1 second
+	ProbeInterval time.Duration
+
+	// ProbeTimeout bounds each recovery ping. Default: ProbeInterval
+	ProbeTimeout time.Duration
+
+	// OnShardDown is called when shard i fails and its keys move away.
+	// err is the error that triggered the switch.
+	OnShardDown func(i int, err error)
+
+	// OnShardUp is called when shard i answers a ping again and gets its
+	// keys back.
+	OnShardUp func(i int)
+}
+
+// Sharded spreads keys over several independent storages (typically one
+// Redis per shard, without Redis Cluster) by consistent hashing.
+//
+// Each key lives on exactly one shard, so every operation on it stays as
+// atomic as the shard makes it. Adding a shard at the end of the list only
+// moves about 1/N of the keys.
+//
+// Keys containing a hash tag in braces are placed by the tag alone, like
+// in Redis Cluster: "{user:123}:api" and "{user:123}:upload" always share
+// a shard. ShardOf reports where a key is placed.
+//
+// Shards fail independently. When one fails, it is marked unhealthy, its
+// keys are served by ShardedConfig.Fallback (or the next healthy shard) and
+// a background probe pings it until it recovers; keys on the other shards
+// are not affected. As with Failover, state written while a shard is down
+// is not copied back, and missing keys, unsupported operations, corrupt
+// state and context cancellation are not treated as failures.
+//
+// Example:
+//
+//	var shards []storage.Storage
+//	for _, addr := range []string{"redis-a:6379", "redis-b:6379", "redis-c:6379"} {
+//	    s, err := redis.New(storage.Config{RedisAddr: addr})
+//	    if err != nil {
+//	        return err
+//	    }
+//	    shards = append(shards, s)
+//	}
+//	store, err := storage.NewSharded(shards, storage.ShardedConfig{
+//	    Fallback: storage.NewMemory(storage.Config{}),
+//	})
+type Sharded struct {
+	shards []Storage
+	ring   []ringPoint
+	cfg    ShardedConfig
+	down   []atomic.Bool
+
+	mu      sync.Mutex
+	probing []bool
+	stop    chan struct{}
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// ringPoint is one position of a shard on the hash ring.
+type ringPoint struct {
+	hash  uint64
+	shard int
+}
+
+// Targets of route other than a shard index.
+const (
+	toFallback = -1
+	toNone     = -2
+)
+
+// NewSharded creates a Sharded storage over shards. The order of shards
+// determines key placement, so every process must pass them in the same
+// order.
+func NewSharded(shards []Storage, cfg ShardedConfig) (*Sharded, error) {
+	if len(shards) == 0 {
+		return nil, &StorageError{Backend: shardedBackend, Op: "init", Err: "no shards"}
+	}
+	if cfg.Replicas <= 0 {
+		cfg.Replicas = 128
+	}
+	if cfg.ProbeInterval <= 0 {
+		cfg.ProbeInterval = time.Second
+	}
+	if cfg.ProbeTimeout <= 0 {
+		cfg.ProbeTimeout = cfg.ProbeInterval
+	}
+
+	ring := make([]ringPoint, 0, len(shards)*cfg.Replicas)
+	for i := range shards {
+		for r := 0; r < cfg.Replicas; r++ {
+			ring = append(ring, ringPoint{
+				hash:  hash64(strconv.Itoa(i) + "#" + strconv.Itoa(r)),
+				shard: i,
+			})
+		}
+	}
+	sort.Slice(ring, func(a, b int) bool { return ring[a].hash < ring[b].hash })
+
+	return &Sharded{
+		shards:  shards,
+		ring:    ring,
+		cfg:     cfg,
+		down:    make([]atomic.Bool, len(shards)),
+		probing: make([]bool, len(shards)),
+		stop:    make(chan struct{}),
+	}, nil
+}
+
+// ShardOf returns the index of the shard key belongs to, whether or not
+// that shard is healthy.
+func (s *Sharded) ShardOf(key string) int {
+	return s.ring[s.search(key)].shard
+}
+
+// Healthy reports whether shard i is serving its keys.
+func (s *Sharded) Healthy(i int) bool {
+	return !s.down[i].Load()
+}
+
+// Shards returns the shard storages.
+func (s *Sharded) Shards() []Storage {
+	return s.shards
+}
+
+// Get retrieves the state for key.
+func (s *Sharded) Get(ctx context.Context, key string) (*State, error) {
+	var state *State
+	err := s.do(ctx, "get", key, func(st Storage) error {
+		var err error
+		state, err = st.Get(ctx, key)
+		return err
+	})
+	return state, err
+}
+
+// Set stores the state for key.
+func (s *Sharded) Set(ctx context.Context, key string, state *State, ttl time.Duration) error {
+	return s.do(ctx, "set", key, func(st Storage) error {
+		return st.Set(ctx, key, state, ttl)
+	})
+}
+
+// Incr atomically increments the counter for key.
+func (s *Sharded) Incr(ctx context.Context, key string, amount int64, ttl time.Duration) (int64, error) {
+	var n int64
+	err := s.do(ctx, "incr", key, func(st Storage) error {
+		var err error
+		n, err = st.Incr(ctx, key, amount, ttl)
+		return err
+	})
+	return n, err
+}
+
+// Delete removes key.
+func (s *Sharded) Delete(ctx context.Context, key string) error {
+	return s.do(ctx, "delete", key, func(st Storage) error {
+		return st.Delete(ctx, key)
+	})
+}
+
+// Exists reports whether key exists.
+func (s *Sharded) Exists(ctx context.Context, key string) (bool, error) {
+	var ok bool
+	err := s.do(ctx, "exists", key, func(st Storage) error {
+		var err error
+		ok, err = st.Exists(ctx, key)
+		return err
+	})
+	return ok, err
+}
+
+// GetMulti retrieves the state for several keys with one call per shard.
+func (s *Sharded) GetMulti(ctx context.Context, keys []string) ([]*State, error) {
+	states := make([]*State, len(keys))
+	err := s.each(ctx, "get_multi", keys, func(st Storage, idx []int) error {
+		sub := make([]string, len(idx))
+		for j, i := range idx {
+			sub[j] = keys[i]
+		}
+
+		got, err := st.GetMulti(ctx, sub)
+		if err != nil {
+			return err
+		}
+		for j, i := range idx {
+			states[i] = got[j]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+// SetMulti stores the state for several keys with one call per shard.
+func (s *Sharded) SetMulti(ctx context.Context, states map[string]*State, ttl time.Duration) error {
+	keys := make([]string, 0, len(states))
+	for key := range states {
+		keys = append(keys, key)
+	}
+
+	return s.each(ctx, "set_multi", keys, func(st Storage, idx []int) error {
+		sub := make(map[string]*State, len(idx))
+		for _, i := range idx {
+			sub[keys[i]] = states[keys[i]]
+		}
+		return st.SetMulti(ctx, sub, ttl)
+	})
+}
+
+// Keys returns keys matching pattern from every healthy shard and the
+// fallback. Keys of unhealthy shards are missing from the result.
+func (s *Sharded) Keys(ctx context.Context, pattern string) ([]string, error) {
+	seen := make(map[string]struct{})
+	var keys []string
+	add := func(found []string) {
+		for _, key := range found {
+			if _, dup := seen[key]; !dup {
+				seen[key] = struct{}{}
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	for i, shard := range s.shards {
+		if s.down[i].Load() {
+			continue
+		}
+
+		found, err := shard.Keys(ctx, pattern)
+		if isFailure(ctx, err) {
+			s.markDown(i, err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		add(found)
+	}
+
+	if s.cfg.Fallback != nil {
+		found, err := s.cfg.Fallback.Keys(ctx, pattern)
+		if err != nil {
+			return nil, err
+		}
+		add(found)
+	}
+	return keys, nil
+}
+
+// TakeTokens runs a token bucket operation on the storage serving key, or
+// returns ErrNotSupported if that storage can't run it atomically.
+func (s *Sharded) TakeTokens(ctx context.Context, key string, req TokenBucketRequest) (TokenBucketResult, error) {
+	var res TokenBucketResult
+	err := s.do(ctx, "take_tokens", key, func(st Storage) error {
+		tbs, ok := st.(TokenBucketStore)
+		if !ok {
+			return ErrNotSupported
+		}
+
+		var err error
+		res, err = tbs.TakeTokens(ctx, key, req)
+		return err
+	})
+	return res, err
+}
+
+// TakeSlots runs a sliding window log operation on the storage serving
+// key, or returns ErrNotSupported if that storage can't run it atomically.
+func (s *Sharded) TakeSlots(ctx context.Context, key string, req SlidingLogRequest) (SlidingLogResult, error) {
+	var res SlidingLogResult
+	err := s.do(ctx, "take_slots", key, func(st Storage) error {
+		sls, ok := st.(SlidingLogStore)
+		if !ok {
+			return ErrNotSupported
+		}
+
+		var err error
+		res, err = sls.TakeSlots(ctx, key, req)
+		return err
+	})
+	return res, err
+}
+
+// Ping checks every shard and reports the failing ones, even while their
+// keys are served elsewhere, so health checks see the real backend status.
+func (s *Sharded) Ping(ctx context.Context) error {
+	var errs []error
+	for i, shard := range s.shards {
+		if err := shard.Ping(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("shard %d: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close stops the recovery probes and closes every shard and the fallback.
+func (s *Sharded) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.stop)
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	errs := make([]error, 0, len(s.shards)+1)
+	for _, shard := range s.shards {
+		errs = append(errs, shard.Close())
+	}
+	if s.cfg.Fallback != nil {
+		errs = append(errs, s.cfg.Fallback.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// do runs op on the storage serving key. If a shard fails, it is marked
+// unhealthy and op is retried on the key's new target.
+func (s *Sharded) do(ctx context.Context, opName, key string, op func(Storage) error) error {
+	for attempt := 0; ; attempt++ {
+		target := s.route(key)
+		st := s.storageAt(target)
+		if st == nil {
+			return &StorageError{Backend: shardedBackend, Op: opName, Key: key, Err: ErrStorageUnavailable}
+		}
+
+		err := op(st)
+		if target < 0 || attempt == len(s.shards) || !isFailure(ctx, err) {
+			return err
+		}
+		s.markDown(target, err)
+	}
+}
+
+// each runs op once per storage serving some of keys, with the indices of
+// those keys. Keys of a failing shard are regrouped and retried like in do.
+func (s *Sharded) each(ctx context.Context, opName string, keys []string, op func(Storage, []int) error) error {
+	pending := make([]int, len(keys))
+	for i := range pending {
+		pending[i] = i
+	}
+
+	for attempt := 0; len(pending) > 0; attempt++ {
+		groups := make(map[int][]int)
+		for _, i := range pending {
+			target := s.route(keys[i])
+			groups[target] = append(groups[target], i)
+		}
+
+		var retry []int
+		for target, idx := range groups {
+			st := s.storageAt(target)
+			if st == nil {
+				return &StorageError{Backend: shardedBackend, Op: opName, Err: ErrStorageUnavailable}
+			}
+
+			err := op(st, idx)
+			if target >= 0 && attempt < len(s.shards) && isFailure(ctx, err) {
+				s.markDown(target, err)
+				retry = append(retry, idx...)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+		pending = retry
+	}
+	return nil
+}
+
+// route returns the shard serving key, toFallback if its shard is down and
+// a fallback is configured, or toNone if no shard is healthy.
+func (s *Sharded) route(key string) int {
+	start := s.search(key)
+	owner := s.ring[start].shard
+	if !s.down[owner].Load() {
+		return owner
+	}
+	if s.cfg.Fallback != nil {
+		return toFallback
+	}
+
+	for i := 1; i < len(s.ring); i++ {
+		shard := s.ring[(start+i)%len(s.ring)].shard
+		if !s.down[shard].Load() {
+			return shard
+		}
+	}
+	return toNone
+}
+
+// storageAt returns the storage for a route target, or nil for toNone.
+func (s *Sharded) storageAt(target int) Storage {
+	switch target {
+	case toNone:
+		return nil
+	case toFallback:
+		return s.cfg.Fallback
+	default:
+		return s.shards[target]
+	}
+}
+
+// search returns the index of the first ring point at or after key's hash.
+func (s *Sharded) search(key string) int {
+	h := hash64(hashTag(key))
+	i := sort.Search(len(s.ring), func(i int) bool { return s.ring[i].hash >= h })
+	if i == len(s.ring) {
+		i = 0
+	}
+	return i
+}
+
+// markDown marks shard i unhealthy and starts its recovery probe.
+func (s *Sharded) markDown(i int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed || s.probing[i] {
+		return
+	}
+
+	s.probing[i] = true
+	s.down[i].Store(true)
+
+	if s.cfg.OnShardDown != nil {
+		s.cfg.OnShardDown(i, err)
+	}
+
+	s.wg.Add(1)
+	go s.probe(i)
+}
+
+// probe pings shard i until it recovers or the storage is closed.
+func (s *Sharded) probe(i int) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.cfg.ProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.cfg.ProbeTimeout)
+		err := s.shards[i].Ping(ctx)
+		cancel()
+		if err != nil {
+			continue
+		}
+
+		s.mu.Lock()
+		s.probing[i] = false
+		s.down[i].Store(false)
+		s.mu.Unlock()
+
+		if s.cfg.OnShardUp != nil {
+			s.cfg.OnShardUp(i)
+		}
+		return
+	}
+}
+
+// hashTag returns the part of key used for placement: the contents of the
+// first non-empty {...} section, or the whole key.
+func hashTag(key string) string {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return key
+	}
+	end := strings.IndexByte(key[start+1:], '}')
+	if end <= 0 {
+		return key
+	}
+	return key[start+1 : start+1+end]
+}
+
+// hash64 hashes s for the ring. FNV-1a alone spreads short, similar
+// strings poorly, so its result goes through the MurmurHash3 finalizer.
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,36 @@
+// Package shm implements flexlimit storage in a shared memory segment.
+//
+// Several processes on one host (prefork workers, CGI-style servers,
+// multi-process runtimes) can open the same file and share rate limits
+// through it, with no sockets or sidecar: every operation is a few memory
+// accesses under a spinlock that lives in the segment itself.
+//
+// The segment is a fixed-size, set-associative table. A key hashes to one
+// bucket of eight slots, and each bucket has its own lock, so unrelated
+// keys don't contend. When all slots of a bucket hold live keys, the least
+// recently updated one is evicted. Incr and TakeTokens run atomically under the bucket lock, so
+// fixed windows and token buckets are exact across processes. Sliding
+// windows and leaky buckets use Get and Set and can overshoot slightly
+// when several processes hit the same key at once.
+//
+// The geometry (number of keys, maximum key length, maximum sliding window
+// timestamps per key) is fixed when the file is created; processes opening
+// an existing file use its geometry. A sliding window key needs room for
+// one timestamp per request in its window, so set MaxTimestamps to at
+// least the limiter's rate. State.Metadata is not stored.
+//
+// A process that dies while holding a bucket lock would block that bucket
+// forever, so a lock held for more than a second is taken over.
+//
+// The package is only available on Unix systems.
+//
+// Example:
+//
+//	store, err := shm.Open(shm.Config{Path: "/dev/shm/flexlimit-api"})
+//	if err != nil {
+//	    return err
+//	}
+//	defer store.Close()
+//
+//	limiter, err := flexlimit.New(100, time.Minute, flexlimit.WithStorage(store))
+package shm
@@ -0,0 +1,245 @@
+package shm
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+// Segment layout. All integers are little-endian.
+//
+//	header  (64 bytes)
+//	bucket  0: lock uint32, padding uint32, ways × slot
+//	bucket  1: ...
+//
+// A slot is:
+//
+//	 0 hash         uint64
+//	 8 keyLen       uint32 (0 = free)
+//	12 tsLen        uint32
+//	16 expiresAt    int64 (Unix ns, 0 = never)
+//	24 tokens       float64 bits
+//	32 lastRefill   int64
+//	40 count        int64
+//	48 windowStart  int64
+//	56 createdAt    int64
+//	64 updatedAt    int64
+//	72 reserved
+//	80 key          [keyCap]byte
+//	   timestamps   [tsCap]int64
+const (
+	magic   = 0x314d48535846 // "FXSHM1"
+	version = 1
+
+	headerSize     = 64
+	bucketOverhead = 8
+	slotFixedSize  = 80
+	ways           = 8
+
+	offMagic      = 0
+	offVersion    = 8
+	offBuckets    = 12
+	offWays       = 16
+	offKeyCap     = 20
+	offTsCap      = 24
+	offSlotSize   = 28
+	offBucketSize = 32
+
+	offHash        = 0
+	offKeyLen      = 8
+	offTsLen       = 12
+	offExpiresAt   = 16
+	offTokens      = 24
+	offLastRefill  = 32
+	offCount       = 40
+	offWindowStart = 48
+	offCreatedAt   = 56
+	offUpdatedAt   = 64
+	offKey         = slotFixedSize
+)
+
+// geometry describes the table stored in a segment.
+type geometry struct {
+	buckets    int
+	ways       int
+	keyCap     int
+	tsCap      int
+	slotSize   int
+	bucketSize int
+}
+
+// newGeometry computes the table for the given limits.
+func newGeometry(maxKeys, keyCap, tsCap int) geometry {
+	keyCap = (keyCap + 7) &^ 7
+	slotSize := slotFixedSize + keyCap + 8*tsCap
+	return geometry{
+		buckets:    (maxKeys + ways - 1) / ways,
+		ways:       ways,
+		keyCap:     keyCap,
+		tsCap:      tsCap,
+		slotSize:   slotSize,
+		bucketSize: bucketOverhead + ways*slotSize,
+	}
+}
+
+// size returns the number of bytes of a segment with this geometry.
+func (g geometry) size() int {
+	return headerSize + g.buckets*g.bucketSize
+}
+
+// writeHeader stores g in the header of data.
+func (g geometry) writeHeader(data []byte) {
+	le := binary.LittleEndian
+	le.PutUint64(data[offMagic:], magic)
+	le.PutUint32(data[offVersion:], version)
+	le.PutUint32(data[offBuckets:], uint32(g.buckets))
+	le.PutUint32(data[offWays:], uint32(g.ways))
+	le.PutUint32(data[offKeyCap:], uint32(g.keyCap))
+	le.PutUint32(data[offTsCap:], uint32(g.tsCap))
+	le.PutUint32(data[offSlotSize:], uint32(g.slotSize))
+	le.PutUint32(data[offBucketSize:], uint32(g.bucketSize))
+}
+
+// readHeader loads the geometry from the header of data, reporting false
+// if data doesn't hold a valid segment.
+func readHeader(data []byte) (geometry, bool) {
+	if len(data) < headerSize {
+		return geometry{}, false
+	}
+
+	le := binary.LittleEndian
+	if le.Uint64(data[offMagic:]) != magic || le.Uint32(data[offVersion:]) != version {
+		return geometry{}, false
+	}
+
+	g := geometry{
+		buckets:    int(le.Uint32(data[offBuckets:])),
+		ways:       int(le.Uint32(data[offWays:])),
+		keyCap:     int(le.Uint32(data[offKeyCap:])),
+		tsCap:      int(le.Uint32(data[offTsCap:])),
+		slotSize:   int(le.Uint32(data[offSlotSize:])),
+		bucketSize: int(le.Uint32(data[offBucketSize:])),
+	}
+	valid := g.buckets > 0 && g.ways > 0 &&
+		g.slotSize == slotFixedSize+g.keyCap+8*g.tsCap &&
+		g.bucketSize == bucketOverhead+g.ways*g.slotSize &&
+		g.size() == len(data)
+	return g, valid
+}
+
+// slot is one key's entry in the segment.
+type slot []byte
+
+func (s slot) u32(off int) uint32 { return binary.LittleEndian.Uint32(s[off:]) }
+func (s slot) u64(off int) uint64 { return binary.LittleEndian.Uint64(s[off:]) }
+func (s slot) i64(off int) int64  { return int64(s.u64(off)) }
+
+func (s slot) putU32(off int, v uint32) { binary.LittleEndian.PutUint32(s[off:], v) }
+func (s slot) putU64(off int, v uint64) { binary.LittleEndian.PutUint64(s[off:], v) }
+func (s slot) putI64(off int, v int64)  { s.putU64(off, uint64(v)) }
+
+func (s slot) time(off int) time.Time       { return fromNanos(s.i64(off)) }
+func (s slot) putTime(off int, t time.Time) { s.putI64(off, toNanos(t)) }
+
+// used reports whether the slot holds a key, live or expired.
+func (s slot) used() bool {
+	return s.u32(offKeyLen) != 0
+}
+
+// live reports whether the slot holds a key that has not expired at now.
+func (s slot) live(now time.Time) bool {
+	if !s.used() {
+		return false
+	}
+	exp := s.i64(offExpiresAt)
+	return exp == 0 || now.UnixNano() < exp
+}
+
+// is reports whether the slot holds key.
+func (s slot) is(key string, hash uint64) bool {
+	n := int(s.u32(offKeyLen))
+	return n == len(key) && s.u64(offHash) == hash && string(s[offKey:offKey+n]) == key
+}
+
+// key returns the slot's key.
+func (s slot) key() string {
+	return string(s[offKey : offKey+int(s.u32(offKeyLen))])
+}
+
+// claim empties the slot and assigns it to key.
+func (s slot) claim(key string, hash uint64) {
+	clear(s)
+	s.putU64(offHash, hash)
+	s.putU32(offKeyLen, uint32(len(key)))
+	copy(s[offKey:], key)
+}
+
+// free empties the slot.
+func (s slot) free() {
+	clear(s[:slotFixedSize])
+}
+
+// setExpiry makes the slot expire ttl after now, or never if ttl is zero.
+func (s slot) setExpiry(now time.Time, ttl time.Duration) {
+	var exp int64
+	if ttl > 0 {
+		exp = now.Add(ttl).UnixNano()
+	}
+	s.putI64(offExpiresAt, exp)
+}
+
+// state decodes the slot into a State.
+func (s slot) state(keyCap int) *storage.State {
+	st := &storage.State{
+		Tokens:      math.Float64frombits(s.u64(offTokens)),
+		LastRefill:  s.time(offLastRefill),
+		Count:       s.i64(offCount),
+		WindowStart: s.time(offWindowStart),
+		CreatedAt:   s.time(offCreatedAt),
+		UpdatedAt:   s.time(offUpdatedAt),
+	}
+
+	if n := int(s.u32(offTsLen)); n > 0 {
+		st.Timestamps = make([]time.Time, n)
+		base := offKey + keyCap
+		for i := range st.Timestamps {
+			st.Timestamps[i] = s.time(base + 8*i)
+		}
+	}
+	return st
+}
+
+// putState encodes st into the slot. The caller checks that the
+// timestamps fit.
+func (s slot) putState(st *storage.State, keyCap int) {
+	s.putU64(offTokens, math.Float64bits(st.Tokens))
+	s.putTime(offLastRefill, st.LastRefill)
+	s.putI64(offCount, st.Count)
+	s.putTime(offWindowStart, st.WindowStart)
+	s.putTime(offCreatedAt, st.CreatedAt)
+	s.putTime(offUpdatedAt, st.UpdatedAt)
+
+	s.putU32(offTsLen, uint32(len(st.Timestamps)))
+	base := offKey + keyCap
+	for i, ts := range st.Timestamps {
+		s.putTime(base+8*i, ts)
+	}
+}
+
+// toNanos converts t to Unix nanoseconds, mapping the zero time to 0.
+func toNanos(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// fromNanos is the inverse of toNanos.
+func fromNanos(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
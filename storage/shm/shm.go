@@ -0,0 +1,503 @@
+//go:build unix
+
+package shm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/Vipul984/flexlimit/clock"
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+var (
+	_ storage.Storage          = (*Store)(nil)
+	_ storage.TokenBucketStore = (*Store)(nil)
+)
+
+// backendName identifies this backend in storage errors.
+const backendName = "shm"
+
+// lockStealAfter is how long a bucket lock may be held before another
+// process assumes its holder died and takes it over.
+const lockStealAfter = time.Second
+
+// Config configures a shared memory Store.
+type Config struct {
+	// Path is the file backing the segment. Use a tmpfs path such as
+	// /dev/shm/<name> to keep it in memory. Required
+	Path string
+
+	// MaxKeys is the number of keys the segment holds before evicting.
+	// Only used when creating the file. Default: 10000
+	MaxKeys int
+
+	// MaxKeyLength is the longest key, in bytes, the segment can store.
+	// Only used when creating the file. Default: 128
+	MaxKeyLength int
+
+	// MaxTimestamps is the most sliding window timestamps stored per key;
+	// it should be at least the rate of sliding window limiters using the
+	// segment. Only used when creating the file. Default: 64
+	MaxTimestamps int
+
+	// Clock is the time source used for TTLs. Default: the system clock
+	Clock clock.Clock
+}
+
+// Store is a storage backend over a shared memory segment.
+//
+// A Store is safe for concurrent use by multiple goroutines and by
+// multiple processes opening the same file.
+type Store struct {
+	geometry
+
+	file  *os.File
+	data  []byte
+	clock clock.Clock
+
+	// mu guards data against Close; operations hold a read lock
+	mu     sync.RWMutex
+	closed bool
+}
+
+// Open opens the segment at cfg.Path, creating and sizing the file if it
+// doesn't exist yet.
+//
+// Returns a *storage.StorageError if the file can't be opened or mapped,
+// or if it exists but doesn't hold a segment.
+func Open(cfg Config) (*Store, error) {
+	if cfg.Path == "" {
+		return nil, &storage.StorageError{Backend: backendName, Op: "open", Err: "path is required"}
+	}
+	if cfg.MaxKeys <= 0 {
+		cfg.MaxKeys = 10000
+	}
+	if cfg.MaxKeyLength <= 0 {
+		cfg.MaxKeyLength = 128
+	}
+	if cfg.MaxTimestamps < 0 {
+		cfg.MaxTimestamps = 0
+	} else if cfg.MaxTimestamps == 0 {
+		cfg.MaxTimestamps = 64
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = clock.New()
+	}
+
+	file, err := os.OpenFile(cfg.Path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, &storage.StorageError{Backend: backendName, Op: "open", Key: cfg.Path, Err: err}
+	}
+
+	data, geo, err := mapSegment(file, newGeometry(cfg.MaxKeys, cfg.MaxKeyLength, cfg.MaxTimestamps))
+	if err != nil {
+		file.Close()
+		return nil, &storage.StorageError{Backend: backendName, Op: "open", Key: cfg.Path, Err: err}
+	}
+
+	return &Store{
+		geometry: geo,
+		file:     file,
+		data:     data,
+		clock:    cfg.Clock,
+	}, nil
+}
+
+// mapSegment maps file, initializing it with geo if it is empty. An
+// existing segment keeps its own geometry.
+func mapSegment(file *os.File, geo geometry) ([]byte, geometry, error) {
+	fd := int(file.Fd())
+
+	// Serialize initialization between processes opening the file at once.
+	if err := syscall.Flock(fd, syscall.LOCK_EX); err != nil {
+		return nil, geometry{}, err
+	}
+	defer syscall.Flock(fd, syscall.LOCK_UN)
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, geometry{}, err
+	}
+
+	size := int(info.Size())
+	created := size == 0
+	if created {
+		size = geo.size()
+		if err := file.Truncate(int64(size)); err != nil {
+			return nil, geometry{}, err
+		}
+	}
+
+	data, err := syscall.Mmap(fd, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, geometry{}, err
+	}
+
+	if created {
+		geo.writeHeader(data)
+		return data, geo, nil
+	}
+
+	existing, ok := readHeader(data)
+	if !ok {
+		syscall.Munmap(data)
+		return nil, geometry{}, errors.New("file is not a flexlimit segment")
+	}
+	return data, existing, nil
+}
+
+// Get retrieves the state for key.
+func (s *Store) Get(ctx context.Context, key string) (*storage.State, error) {
+	var st *storage.State
+	err := s.withBucket(ctx, "get", key, func(b bucket, hash uint64, now time.Time) error {
+		sl := b.find(key, hash, now)
+		if sl == nil {
+			return storage.ErrKeyNotFound
+		}
+		st = sl.state(s.keyCap)
+		return nil
+	})
+	return st, err
+}
+
+// Set stores the state for key.
+//
+// Returns a *storage.StorageError if the key or its timestamps don't fit
+// the segment.
+func (s *Store) Set(ctx context.Context, key string, state *storage.State, ttl time.Duration) error {
+	if len(state.Timestamps) > s.tsCap {
+		return &storage.StorageError{
+			Backend: backendName,
+			Op:      "set",
+			Key:     key,
+			Err:     fmt.Sprintf("%d timestamps exceed the segment limit of %d", len(state.Timestamps), s.tsCap),
+		}
+	}
+
+	return s.withBucket(ctx, "set", key, func(b bucket, hash uint64, now time.Time) error {
+		sl, _ := b.acquire(key, hash, now)
+		s.store(sl, state, now, ttl)
+		return nil
+	})
+}
+
+// Incr atomically adds amount to the Count field of key.
+//
+// The TTL is applied only when the key is created.
+func (s *Store) Incr(ctx context.Context, key string, amount int64, ttl time.Duration) (int64, error) {
+	var count int64
+	err := s.withBucket(ctx, "incr", key, func(b bucket, hash uint64, now time.Time) error {
+		sl, created := b.acquire(key, hash, now)
+		if created {
+			sl.putTime(offCreatedAt, now)
+			sl.setExpiry(now, ttl)
+		}
+		count = sl.i64(offCount) + amount
+		sl.putI64(offCount, count)
+		sl.putTime(offUpdatedAt, now)
+		return nil
+	})
+	return count, err
+}
+
+// TakeTokens refills and consumes a token bucket atomically across every
+// process sharing the segment.
+func (s *Store) TakeTokens(ctx context.Context, key string, req storage.TokenBucketRequest) (storage.TokenBucketResult, error) {
+	var res storage.TokenBucketResult
+	err := s.withBucket(ctx, "take_tokens", key, func(b bucket, hash uint64, _ time.Time) error {
+		now := req.Now
+		tokens, last := req.Capacity, now
+		if sl := b.find(key, hash, now); sl != nil {
+			tokens = math.Float64frombits(sl.u64(offTokens))
+			last = sl.time(offLastRefill)
+		}
+
+		elapsed := max(now.Sub(last), 0)
+		tokens = min(req.Capacity, tokens+elapsed.Seconds()*req.RefillRate)
+
+		res.Tokens = tokens
+		if req.Cost == 0 || tokens < req.Cost {
+			return nil
+		}
+
+		res.Allowed = true
+		res.Tokens = min(req.Capacity, tokens-req.Cost)
+
+		sl, created := b.acquire(key, hash, now)
+		if created {
+			sl.putTime(offCreatedAt, now)
+		}
+		sl.putU64(offTokens, math.Float64bits(res.Tokens))
+		sl.putTime(offLastRefill, now)
+		sl.putTime(offUpdatedAt, now)
+		if req.TTL > 0 {
+			sl.setExpiry(now, req.TTL)
+		}
+		return nil
+	})
+	return res, err
+}
+
+// Delete removes key. Deleting a missing key is not an error.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	return s.withBucket(ctx, "delete", key, func(b bucket, hash uint64, now time.Time) error {
+		if sl := b.find(key, hash, now); sl != nil {
+			sl.free()
+		}
+		return nil
+	})
+}
+
+// Exists reports whether key is present and not expired.
+func (s *Store) Exists(ctx context.Context, key string) (bool, error) {
+	var ok bool
+	err := s.withBucket(ctx, "exists", key, func(b bucket, hash uint64, now time.Time) error {
+		ok = b.find(key, hash, now) != nil
+		return nil
+	})
+	return ok, err
+}
+
+// GetMulti retrieves the state for several keys; missing keys yield nil.
+func (s *Store) GetMulti(ctx context.Context, keys []string) ([]*storage.State, error) {
+	states := make([]*storage.State, len(keys))
+	for i, key := range keys {
+		st, err := s.Get(ctx, key)
+		if errors.Is(err, storage.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		states[i] = st
+	}
+	return states, nil
+}
+
+// SetMulti stores several states.
+func (s *Store) SetMulti(ctx context.Context, states map[string]*storage.State, ttl time.Duration) error {
+	for key, state := range states {
+		if err := s.Set(ctx, key, state, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Keys returns all live keys matching pattern.
+//
+// Only prefix patterns are supported: "user:*" matches every key starting
+// with "user:", and "" or "*" matches everything.
+func (s *Store) Keys(ctx context.Context, pattern string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return nil, storage.ErrClosed
+	}
+
+	prefix := strings.TrimSuffix(pattern, "*")
+	now := s.clock.Now()
+
+	var keys []string
+	for i := range s.buckets {
+		b := s.bucket(i)
+		unlock, err := b.lock(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for w := range s.ways {
+			sl := b.slot(w)
+			if sl.live(now) && strings.HasPrefix(sl.key(), prefix) {
+				keys = append(keys, sl.key())
+			}
+		}
+		unlock()
+	}
+	return keys, nil
+}
+
+// Ping succeeds unless the store has been closed.
+func (s *Store) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return storage.ErrClosed
+	}
+	return nil
+}
+
+// Close unmaps the segment and closes the file. The file and the state in
+// it are kept for other processes. Calling Close more than once is a no-op.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	err := syscall.Munmap(s.data)
+	s.data = nil
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return &storage.StorageError{Backend: backendName, Op: "close", Err: err}
+	}
+	return nil
+}
+
+// withBucket runs fn with the bucket of key locked.
+func (s *Store) withBucket(ctx context.Context, op, key string, fn func(b bucket, hash uint64, now time.Time) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(key) == 0 || len(key) > s.keyCap {
+		return &storage.StorageError{
+			Backend: backendName,
+			Op:      op,
+			Key:     key,
+			Err:     fmt.Sprintf("key length must be between 1 and %d bytes", s.keyCap),
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return storage.ErrClosed
+	}
+
+	hash := hashKey(key)
+	b := s.bucket(int(hash % uint64(s.buckets)))
+	unlock, err := b.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return fn(b, hash, s.clock.Now())
+}
+
+// store writes state into sl, stamping the creation and update times the
+// way the in-memory store does.
+func (s *Store) store(sl slot, state *storage.State, now time.Time, ttl time.Duration) {
+	stored := *state
+	if stored.CreatedAt.IsZero() {
+		stored.CreatedAt = now
+	}
+	stored.UpdatedAt = now
+
+	sl.putState(&stored, s.keyCap)
+	sl.setExpiry(now, ttl)
+}
+
+// bucket returns bucket i of the segment.
+func (s *Store) bucket(i int) bucket {
+	off := headerSize + i*s.bucketSize
+	return bucket{data: s.data[off : off+s.bucketSize], slotSize: s.slotSize, ways: s.ways}
+}
+
+// bucket is a lock and the slots it guards.
+type bucket struct {
+	data     []byte
+	slotSize int
+	ways     int
+}
+
+// lock acquires the bucket's spinlock, taking it over if it has been held
+// for longer than lockStealAfter, and returns the unlock function. It gives
+// up with ctx's error if ctx ends while waiting.
+func (b bucket) lock(ctx context.Context) (func(), error) {
+	word := (*uint32)(unsafe.Pointer(&b.data[0]))
+
+	var waitStart time.Time
+	for spins := 0; !atomic.CompareAndSwapUint32(word, 0, 1); spins++ {
+		if spins < 100 {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if waitStart.IsZero() {
+			waitStart = time.Now()
+		} else if time.Since(waitStart) > lockStealAfter {
+			atomic.StoreUint32(word, 1)
+			break
+		}
+		runtime.Gosched()
+	}
+
+	return func() { atomic.StoreUint32(word, 0) }, nil
+}
+
+// slot returns slot w of the bucket.
+func (b bucket) slot(w int) slot {
+	off := bucketOverhead + w*b.slotSize
+	return slot(b.data[off : off+b.slotSize])
+}
+
+// find returns the live slot holding key, or nil.
+func (b bucket) find(key string, hash uint64, now time.Time) slot {
+	for w := range b.ways {
+		sl := b.slot(w)
+		if sl.live(now) && sl.is(key, hash) {
+			return sl
+		}
+	}
+	return nil
+}
+
+// acquire returns the slot for key, claiming a free or expired slot, or
+// evicting the least recently updated one, if key has none. created
+// reports whether the slot was newly claimed.
+func (b bucket) acquire(key string, hash uint64, now time.Time) (sl slot, created bool) {
+	if sl := b.find(key, hash, now); sl != nil {
+		return sl, false
+	}
+
+	var victim slot
+	for w := range b.ways {
+		candidate := b.slot(w)
+		if !candidate.live(now) {
+			victim = candidate
+			break
+		}
+		if victim == nil || candidate.i64(offUpdatedAt) < victim.i64(offUpdatedAt) {
+			victim = candidate
+		}
+	}
+
+	victim.claim(key, hash)
+	return victim, true
+}
+
+// hashKey hashes a key to pick its bucket.
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
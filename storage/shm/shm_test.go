@@ -0,0 +1,27 @@
+//go:build unix
+
+package shm_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/Vipul984/flexlimit/clock"
+	"github.com/Vipul984/flexlimit/storage"
+	"github.com/Vipul984/flexlimit/storage/shm"
+	"github.com/Vipul984/flexlimit/storage/storagetest"
+)
+
+func TestStore(t *testing.T) {
+	mock := clock.NewMock()
+	storagetest.TestStorage(t, func(t *testing.T) storage.Storage {
+		store, err := shm.Open(shm.Config{
+			Path:  filepath.Join(t.TempDir(), "segment"),
+			Clock: mock,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}, storagetest.WithAdvance(mock.Advance), storagetest.WithoutMetadata())
+}
@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"container/list"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SnapshotFormat is the encoding of a memory store snapshot.
+type SnapshotFormat int
+
+const (
+	// SnapshotJSON encodes snapshots as JSON, readable by other tools.
+	// This is the default.
+	SnapshotJSON SnapshotFormat = iota
+
+	// SnapshotGob encodes snapshots with encoding/gob, which is smaller
+	// and faster to load.
+	SnapshotGob
+)
+
+// String returns "json" or "gob".
+func (f SnapshotFormat) String() string {
+	if f == SnapshotGob {
+		return "gob"
+	}
+	return "json"
+}
+
+// snapshotVersion is the version of the snapshot layout.
+const snapshotVersion = 1
+
+// snapshot is the content of a snapshot file.
+type snapshot struct {
+	Version int             `json:"version"`
+	TakenAt time.Time       `json:"taken_at"`
+	Entries []snapshotEntry `json:"entries"`
+}
+
+// snapshotEntry is one key of a snapshot. Entries are ordered from the
+// most to the least valuable to keep: protected keys, then probation, each
+// most recently used first.
+type snapshotEntry struct {
+	Key       string    `json:"key"`
+	State     *State    `json:"state"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	Protected bool      `json:"protected,omitempty"`
+}
+
+// WriteSnapshot writes every live key of the store, with its state and
+// expiry, to w.
+//
+// The keys are copied under the store's lock, and encoded after it is
+// released, so requests are only held up for the copy.
+func (m *Memory) WriteSnapshot(w io.Writer, format SnapshotFormat) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrClosed
+	}
+	snap := m.snapshot()
+	m.mu.Unlock()
+
+	if err := encodeSnapshot(w, format, snap); err != nil {
+		return &StorageError{Backend: "memory", Op: "write_snapshot", Err: err}
+	}
+	return nil
+}
+
+// LoadSnapshot adds the keys of a snapshot written by WriteSnapshot to the
+// store. Keys that have expired since, or that the store already holds,
+// are skipped, as are the least recently used keys once the store is full.
+func (m *Memory) LoadSnapshot(r io.Reader, format SnapshotFormat) error {
+	var snap snapshot
+	var err error
+	switch format {
+	case SnapshotGob:
+		err = gob.NewDecoder(r).Decode(&snap)
+	default:
+		err = json.NewDecoder(r).Decode(&snap)
+	}
+	if err != nil {
+		return &StorageError{Backend: "memory", Op: "load_snapshot", Err: &InvalidStateError{Err: err}}
+	}
+	if snap.Version != snapshotVersion {
+		return &StorageError{Backend: "memory", Op: "load_snapshot", Err: fmt.Sprintf("unsupported snapshot version %d", snap.Version)}
+	}
+
+	m.mu.Lock()
+	defer m.unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	now := m.clock.Now()
+	for _, e := range snap.Entries {
+		if len(m.entries) >= m.maxKeys {
+			break
+		}
+		if _, ok := m.entries[e.Key]; ok || e.State == nil {
+			continue
+		}
+		if !e.ExpiresAt.IsZero() && !now.Before(e.ExpiresAt) {
+			continue
+		}
+
+		// Entries come most valuable first, so each is added behind the
+		// ones loaded before it
+		entry := &memoryEntry{key: e.Key, state: e.State, expiresAt: e.ExpiresAt}
+		if e.Protected && m.protected.Len() < m.protectedCap {
+			entry.protected = true
+			m.entries[e.Key] = m.protected.PushBack(entry)
+		} else {
+			m.entries[e.Key] = m.probation.PushBack(entry)
+		}
+	}
+	return nil
+}
+
+// snapshot copies the live keys of the store. Must be called with m.mu
+// held.
+func (m *Memory) snapshot() snapshot {
+	now := m.clock.Now()
+	snap := snapshot{
+		Version: snapshotVersion,
+		TakenAt: now,
+		Entries: make([]snapshotEntry, 0, len(m.entries)),
+	}
+	for _, elem := range [...]*list.Element{m.protected.Front(), m.probation.Front()} {
+		for ; elem != nil; elem = elem.Next() {
+			entry := elem.Value.(*memoryEntry)
+			if expired(entry, now) {
+				continue
+			}
+			snap.Entries = append(snap.Entries, snapshotEntry{
+				Key:       entry.key,
+				State:     copyState(entry.state),
+				ExpiresAt: entry.expiresAt,
+				Protected: entry.protected,
+			})
+		}
+	}
+	return snap
+}
+
+// encodeSnapshot writes snap to w in format.
+func encodeSnapshot(w io.Writer, format SnapshotFormat, snap snapshot) error {
+	if format == SnapshotGob {
+		return gob.NewEncoder(w).Encode(snap)
+	}
+	return json.NewEncoder(w).Encode(snap)
+}
+
+// snapshotter writes the store's snapshot file in the background.
+type snapshotter struct {
+	path     string
+	interval time.Duration
+	format   SnapshotFormat
+	onError  func(error)
+}
+
+// newSnapshotter returns the snapshotter configured by cfg, or nil if
+// snapshots are disabled.
+func newSnapshotter(cfg Config) *snapshotter {
+	if cfg.SnapshotPath == "" {
+		return nil
+	}
+	interval := cfg.SnapshotInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return &snapshotter{
+		path:     cfg.SnapshotPath,
+		interval: interval,
+		format:   cfg.SnapshotFormat,
+		onError:  cfg.OnSnapshotError,
+	}
+}
+
+// Snapshot writes the store's snapshot file now, instead of waiting for
+// the next interval. It does nothing if Config.SnapshotPath is not set.
+func (m *Memory) Snapshot() error {
+	if m.snapshots == nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrClosed
+	}
+	snap := m.snapshot()
+	m.mu.Unlock()
+
+	return m.snapshots.write(snap)
+}
+
+// load loads the snapshot file into m. A missing file is not an error.
+func (s *snapshotter) load(m *Memory) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err == nil {
+		err = m.LoadSnapshot(f, s.format)
+		f.Close()
+	}
+	s.report(err)
+}
+
+// write replaces the snapshot file with snap. The snapshot is written to
+// a temporary file that is renamed over the old one, so a crash while
+// writing never leaves a truncated snapshot behind.
+func (s *snapshotter) write(snap snapshot) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return &StorageError{Backend: "memory", Op: "write_snapshot", Key: s.path, Err: err}
+	}
+	defer os.Remove(tmp.Name())
+
+	err = encodeSnapshot(tmp, s.format, snap)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		return &StorageError{Backend: "memory", Op: "write_snapshot", Key: s.path, Err: err}
+	}
+	return nil
+}
+
+// report passes err to the error callback, if both are set.
+func (s *snapshotter) report(err error) {
+	if err != nil && s.onError != nil {
+		s.onError(err)
+	}
+}
+
+// persist writes the snapshot file every interval until Close, which
+// writes the last one.
+func (m *Memory) persist() {
+	defer m.wg.Done()
+
+	ticker := m.clock.NewTicker(m.snapshots.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C():
+			if err := m.Snapshot(); err != ErrClosed {
+				m.snapshots.report(err)
+			}
+		}
+	}
+}
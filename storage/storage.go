@@ -11,6 +11,8 @@ import (
 	"context"
 	"fmt"
 	"time"
+
+	"github.com/Vipul984/flexlimit/clock"
 )
 
 // Storage defines the interface for persisting rate limiter state.
@@ -150,6 +152,32 @@ type Storage interface {
 	Ping(ctx context.Context) error
 }
 
+// TimeSource is implemented by backends with a clock of their own, such
+// as a Redis server, which the instances sharing them can agree on.
+type TimeSource interface {
+	// Time returns the current time of the backend's clock.
+	Time(ctx context.Context) (time.Time, error)
+}
+
+// ServerTime returns the time of the clock of s, or of the storage s
+// wraps (see Prefixed, Cached, Leased and the primary of a Failover). ok
+// is false if none of them is a TimeSource.
+func ServerTime(ctx context.Context, s Storage) (t time.Time, ok bool, err error) {
+	for {
+		switch w := s.(type) {
+		case TimeSource:
+			t, err = w.Time(ctx)
+			return t, true, err
+		case *Failover:
+			s = w.Primary()
+		case interface{ Unwrap() Storage }:
+			s = w.Unwrap()
+		default:
+			return time.Time{}, false, nil
+		}
+	}
+}
+
 // State represents the rate limiter state stored in the backend.
 //
 // Different algorithms use different fields:
@@ -203,12 +231,82 @@ type Config struct {
 	// Default: 5 minutes
 	CleanupInterval time.Duration
 
+	// Clock is the time source used for TTLs (memory only)
+	// Default: the system clock
+	Clock clock.Clock
+
+	// OnEvict is called after a key expires or is evicted to make room
+	// (memory only). It is not called for Delete or Close
+	OnEvict func(key string, state *State, reason EvictReason)
+
+	// SnapshotPath, if set, makes the memory store write its keys to this
+	// file every SnapshotInterval and on Close, and load them back when it
+	// is created, so a restart doesn't reset long windows (memory only)
+	SnapshotPath string
+
+	// SnapshotInterval is how often the snapshot is written (memory only)
+	// Default: 1 minute
+	SnapshotInterval time.Duration
+
+	// SnapshotFormat is the encoding of the snapshot file (memory only)
+	// Default: SnapshotJSON
+	SnapshotFormat SnapshotFormat
+
+	// OnSnapshotError is called when the snapshot can't be loaded or
+	// written (memory only). The store keeps running either way
+	OnSnapshotError func(err error)
+
+	// Codec selects how backends storing state as a blob encode it:
+	// "json", "gob", "msgpack" or "protobuf" (see NewCodec). Redis stores
+	// state in hash fields unless it is set; etcd defaults to "json". An
+	// explicit WithCodec option takes precedence (redis, etcd)
+	Codec string
+
 	// Redis-specific config (used in Phase 4)
 	RedisAddr     string
 	RedisPassword string
 	RedisDB       int
 	RedisPoolSize int
 
+	// RedisAddrs lists the seed nodes of a Redis Cluster, or the sentinels
+	// with RedisMasterName. Two or more addresses select cluster mode;
+	// RedisAddr is used if it is empty
+	RedisAddrs []string
+
+	// RedisMasterName is the name of the master monitored by the sentinels
+	// of RedisAddrs. Setting it selects Sentinel failover
+	RedisMasterName string
+
+	// RedisSentinelPassword authenticates with the sentinels
+	RedisSentinelPassword string
+
+	// RedisMaxRetries is how many times a command failing on a network
+	// error, or while a failover is in progress, is retried with backoff.
+	// -1 disables retries, so failovers reach the limiter's fallback
+	// strategy at once. Default: 3
+	RedisMaxRetries int
+
+	// RedisClusterMode selects cluster mode with a single address, such
+	// as the configuration endpoint of a managed cluster
+	RedisClusterMode bool
+
+	// RedisMinIdleConns is the number of idle connections kept open, so
+	// bursts of traffic don't wait for new connections. Default: 0
+	RedisMinIdleConns int
+
+	// RedisPipelineWindow is the most keys GetMulti and SetMulti send in
+	// one pipeline; larger batches are split. Default: 100
+	RedisPipelineWindow int
+
+	// RedisAdminPoolSize is the size of the separate connection pool used
+	// for admin operations by redis.NewSplit. Default: 2
+	RedisAdminPoolSize int
+
+	// etcd-specific config
+	EtcdEndpoints []string
+	EtcdUsername  string
+	EtcdPassword  string
+
 	// Connection timeouts
 	ConnectTimeout time.Duration
 	ReadTimeout    time.Duration
@@ -234,10 +332,26 @@ var (
 		Op:  "deserialize",
 		Err: "invalid state format",
 	}
+
+	// ErrNotSupported is returned when a backend doesn't implement an
+	// optional operation (see TokenBucketStore)
+	ErrNotSupported = &StorageError{
+		Op:  "capability",
+		Err: "operation not supported by backend",
+	}
+
+	// ErrClosed is returned when a storage is used after Close()
+	ErrClosed = &StorageError{
+		Op:  "close",
+		Err: "storage closed",
+	}
 )
 
 // StorageError wraps storage operation errors with context.
 type StorageError struct {
+	// Backend identifies the storage backend (e.g., "redis"); may be empty
+	Backend string
+
 	// Op is the operation that failed (get, set, incr, etc.)
 	Op string
 
@@ -250,10 +364,37 @@ type StorageError struct {
 
 // Error implements the error interface
 func (e *StorageError) Error() string {
+	op := e.Op
+	if e.Backend != "" {
+		op = e.Backend + "/" + e.Op
+	}
+
 	if e.Key != "" {
-		return fmt.Sprintf("storage error [%s] for key %q: %v", e.Op, e.Key, e.Err)
+		return fmt.Sprintf("storage error [%s] for key %q: %v", op, e.Key, e.Err)
 	}
-	return fmt.Sprintf("storage error [%s]: %v", e.Op, e.Err)
+	return fmt.Sprintf("storage error [%s]: %v", op, e.Err)
+}
+
+// InvalidStateError reports stored state that could not be decoded.
+// It matches ErrInvalidState with errors.Is.
+type InvalidStateError struct {
+	// Err describes what was wrong with the state
+	Err error
+}
+
+// Error implements the error interface
+func (e *InvalidStateError) Error() string {
+	return "invalid state format: " + e.Err.Error()
+}
+
+// Is allows matching with errors.Is(err, ErrInvalidState)
+func (e *InvalidStateError) Is(target error) bool {
+	return target == ErrInvalidState
+}
+
+// Unwrap allows error chain inspection
+func (e *InvalidStateError) Unwrap() error {
+	return e.Err
 }
 
 // Unwrap allows error chain inspection
@@ -0,0 +1,30 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/Vipul984/flexlimit/clock"
+	"github.com/Vipul984/flexlimit/storage"
+	"github.com/Vipul984/flexlimit/storage/storagetest"
+)
+
+func TestMemory(t *testing.T) {
+	mock := clock.NewMock()
+	storagetest.TestStorage(t, func(t *testing.T) storage.Storage {
+		return storage.NewMemory(storage.Config{Clock: mock})
+	}, storagetest.WithAdvance(mock.Advance))
+}
+
+func TestAtomicMemory(t *testing.T) {
+	mock := clock.NewMock()
+	storagetest.TestStorage(t, func(t *testing.T) storage.Storage {
+		return storage.NewAtomicMemory(storage.Config{Clock: mock})
+	}, storagetest.WithAdvance(mock.Advance))
+}
+
+func TestPrefixed(t *testing.T) {
+	mock := clock.NewMock()
+	storagetest.TestStorage(t, func(t *testing.T) storage.Storage {
+		return storage.NewPrefixed(storage.NewMemory(storage.Config{Clock: mock}), "svc-a:")
+	}, storagetest.WithAdvance(mock.Advance))
+}
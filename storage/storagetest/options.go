@@ -0,0 +1,57 @@
+package storagetest
+
+import (
+	"time"
+)
+
+// Option configures TestStorage.
+type Option func(*suite)
+
+// suite holds the settings of a TestStorage run.
+type suite struct {
+	advance     func(d time.Duration)
+	ttl         time.Duration
+	concurrency int
+	metadata    bool
+}
+
+// WithAdvance sets how the expiry tests move the clock of the stores
+// forward by d: mock.Advance for a memory store on a clock.Mock, or
+// FastForward for an embedded Redis server such as miniredis.
+//
+// Default: time.Sleep
+func WithAdvance(advance func(d time.Duration)) Option {
+	return func(s *suite) {
+		s.advance = advance
+	}
+}
+
+// WithTTL sets the TTL of the keys the expiry tests expect to expire. The
+// tests advance the clock by twice the TTL, so backends with coarse
+// expiry, such as etcd leases counted in seconds, need a longer one.
+//
+// Default: 1 second
+func WithTTL(ttl time.Duration) Option {
+	return func(s *suite) {
+		s.ttl = ttl
+	}
+}
+
+// WithConcurrency sets the number of goroutines incrementing a counter at
+// the same time in the atomicity test.
+//
+// Default: 8
+func WithConcurrency(n int) Option {
+	return func(s *suite) {
+		s.concurrency = n
+	}
+}
+
+// WithoutMetadata skips checking that State.Metadata is stored, for
+// backends with a fixed record layout that don't store it, such as
+// storage/shm.
+func WithoutMetadata() Option {
+	return func(s *suite) {
+		s.metadata = false
+	}
+}
@@ -0,0 +1,434 @@
+// Package storagetest checks that a storage backend honours the contract
+// of storage.Storage that flexlimit's algorithms rely on.
+//
+// Third-party backends, registered with storage.Register or passed to
+// flexlimit.WithStorage, should pass TestStorage, run against a real or
+// embedded server:
+//
+//	func TestCassandra(t *testing.T) {
+//	    storagetest.TestStorage(t, func(t *testing.T) storage.Storage {
+//	        store, err := cassandra.New(storage.Config{Backend: "cassandra"})
+//	        if err != nil {
+//	            t.Fatal(err)
+//	        }
+//	        return store
+//	    })
+//	}
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+// ttl is the TTL of keys the tests don't expect to expire.
+const ttl = time.Hour
+
+// NewStore creates an empty store for one test. TestStorage closes it
+// when the test ends.
+type NewStore func(t *testing.T) storage.Storage
+
+// TestStorage runs the conformance tests against stores created by
+// newStore, each as a subtest with a store of its own:
+//
+//   - Get of a missing key returns storage.ErrKeyNotFound
+//   - Set stores every field of storage.State but UpdatedAt, with times
+//     to the microsecond, and overwrites earlier states
+//   - Delete removes a key and succeeds for missing keys
+//   - Exists reports Set keys, and not missing or deleted ones
+//   - Incr starts missing counters at the amount and adds to them, and
+//     concurrent increments each return a distinct total
+//   - GetMulti returns states in the order of its keys, nil for missing
+//     ones, and SetMulti stores every state it is given
+//   - Keys returns the keys matching a "prefix*" pattern
+//   - keys set or incremented with a TTL are gone once it has passed,
+//     for every read, and keys without one stay
+//   - Ping succeeds
+//   - after Close, operations fail rather than panic or succeed, and a
+//     second Close doesn't panic
+//
+// Keys are prefixed with the test name, so stores sharing a server don't
+// need to be emptied between tests. Stores must own their connection, so
+// closing them makes them unusable.
+//
+// The expiry test waits for twice its TTL (see WithTTL), with time.Sleep
+// unless WithAdvance moves the stores' clock instead:
+//
+//	mock := clock.NewMock()
+//	storagetest.TestStorage(t, func(t *testing.T) storage.Storage {
+//	    return storage.NewMemory(storage.Config{Clock: mock})
+//	}, storagetest.WithAdvance(mock.Advance))
+func TestStorage(t *testing.T, newStore NewStore, opts ...Option) {
+	t.Helper()
+
+	su := &suite{advance: time.Sleep, ttl: time.Second, concurrency: 8, metadata: true}
+	for _, opt := range opts {
+		opt(su)
+	}
+
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s storage.Storage, key func(string) string)
+	}{
+		{"GetMissing", su.testGetMissing},
+		{"SetGet", su.testSetGet},
+		{"Overwrite", su.testOverwrite},
+		{"Delete", su.testDelete},
+		{"Exists", su.testExists},
+		{"Incr", su.testIncr},
+		{"IncrConcurrent", su.testIncrConcurrent},
+		{"GetMulti", su.testGetMulti},
+		{"SetMulti", su.testSetMulti},
+		{"Keys", su.testKeys},
+		{"Expiry", su.testExpiry},
+		{"Ping", su.testPing},
+		{"Close", su.testClose},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStore(t)
+			t.Cleanup(func() { s.Close() })
+			tt.run(t, s, prefixer(t))
+		})
+	}
+}
+
+// prefixer returns a function prefixing keys with the name of t.
+func prefixer(t *testing.T) func(string) string {
+	prefix := fmt.Sprintf("storagetest:%s:%d:", t.Name(), time.Now().UnixNano())
+	return func(key string) string { return prefix + key }
+}
+
+// sampleState returns a state with every field set, and times the
+// backends can all store exactly.
+func sampleState(tokens float64) *storage.State {
+	at := time.Now().Truncate(time.Microsecond)
+	return &storage.State{
+		Tokens:      tokens,
+		LastRefill:  at.Add(-time.Second),
+		Count:       int64(tokens) + 1,
+		WindowStart: at.Add(-time.Minute),
+		Timestamps:  []time.Time{at.Add(-2 * time.Millisecond), at.Add(-time.Millisecond), at},
+		CreatedAt:   at.Add(-time.Hour),
+		UpdatedAt:   at,
+		Metadata:    map[string]interface{}{"status": "ok"},
+	}
+}
+
+func (su *suite) testGetMissing(t *testing.T, s storage.Storage, key func(string) string) {
+	_, err := s.Get(context.Background(), key("missing"))
+	if !errors.Is(err, storage.ErrKeyNotFound) {
+		t.Errorf("Get of a missing key: err = %v, want storage.ErrKeyNotFound", err)
+	}
+}
+
+func (su *suite) testSetGet(t *testing.T, s storage.Storage, key func(string) string) {
+	want := sampleState(7.5)
+	mustSet(t, s, key("k"), want)
+	su.checkGet(t, s, key("k"), want)
+}
+
+func (su *suite) testOverwrite(t *testing.T, s storage.Storage, key func(string) string) {
+	mustSet(t, s, key("k"), sampleState(1))
+	want := sampleState(2)
+	mustSet(t, s, key("k"), want)
+	su.checkGet(t, s, key("k"), want)
+}
+
+func (su *suite) testDelete(t *testing.T, s storage.Storage, key func(string) string) {
+	ctx := context.Background()
+	mustSet(t, s, key("k"), sampleState(1))
+	if err := s.Delete(ctx, key("k")); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Get(ctx, key("k")); !errors.Is(err, storage.ErrKeyNotFound) {
+		t.Errorf("Get after Delete: err = %v, want storage.ErrKeyNotFound", err)
+	}
+	if err := s.Delete(ctx, key("k")); err != nil {
+		t.Errorf("Delete of a missing key: %v, want nil", err)
+	}
+}
+
+func (su *suite) testExists(t *testing.T, s storage.Storage, key func(string) string) {
+	ctx := context.Background()
+	checkExists(t, s, key("k"), false)
+	mustSet(t, s, key("k"), sampleState(1))
+	checkExists(t, s, key("k"), true)
+	if err := s.Delete(ctx, key("k")); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	checkExists(t, s, key("k"), false)
+}
+
+func (su *suite) testIncr(t *testing.T, s storage.Storage, key func(string) string) {
+	ctx := context.Background()
+	for i, tc := range []struct{ amount, want int64 }{{3, 3}, {1, 4}, {10, 14}} {
+		got, err := s.Incr(ctx, key("counter"), tc.amount, ttl)
+		if err != nil {
+			t.Fatalf("Incr #%d: %v", i+1, err)
+		}
+		if got != tc.want {
+			t.Errorf("Incr #%d by %d = %d, want %d", i+1, tc.amount, got, tc.want)
+		}
+	}
+}
+
+func (su *suite) testIncrConcurrent(t *testing.T, s storage.Storage, key func(string) string) {
+	const perWorker = 50
+	total := su.concurrency * perWorker
+
+	results := make(chan int64, total)
+	errs := make(chan error, su.concurrency)
+	var wg sync.WaitGroup
+	for range su.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range perWorker {
+				n, err := s.Incr(context.Background(), key("counter"), 1, ttl)
+				if err != nil {
+					errs <- err
+					return
+				}
+				results <- n
+			}
+		}()
+	}
+	wg.Wait()
+	close(results)
+	close(errs)
+
+	for err := range errs {
+		t.Fatalf("Incr: %v", err)
+	}
+	// Atomic increments return each total from 1 to total exactly once
+	seen := make([]bool, total+1)
+	for n := range results {
+		if n < 1 || n > int64(total) || seen[n] {
+			t.Fatalf("%d concurrent Incr by 1 returned %d twice or out of range; increments were lost", total, n)
+		}
+		seen[n] = true
+	}
+}
+
+func (su *suite) testGetMulti(t *testing.T, s storage.Storage, key func(string) string) {
+	// Over 100 keys, so backends batching requests return several batches
+	const n = 150
+	keys := make([]string, n)
+	want := make([]*storage.State, n)
+	for i := range n {
+		keys[i] = key(fmt.Sprintf("k%03d", i))
+		if i%3 != 1 {
+			want[i] = sampleState(float64(i))
+			mustSet(t, s, keys[i], want[i])
+		}
+	}
+	slices.Reverse(keys)
+	slices.Reverse(want)
+
+	states, err := s.GetMulti(context.Background(), keys)
+	if err != nil {
+		t.Fatalf("GetMulti: %v", err)
+	}
+	if len(states) != len(keys) {
+		t.Fatalf("GetMulti of %d keys returned %d states", len(keys), len(states))
+	}
+	for i := range keys {
+		switch {
+		case want[i] == nil && states[i] != nil:
+			t.Errorf("GetMulti: state %d (missing key %s) = %+v, want nil", i, keys[i], states[i])
+		case want[i] != nil && states[i] == nil:
+			t.Errorf("GetMulti: state %d (%s) = nil, want %+v", i, keys[i], want[i])
+		case want[i] != nil:
+			if msg := diffStates(want[i], states[i], su.metadata); msg != "" {
+				t.Errorf("GetMulti: state %d (%s): %s", i, keys[i], msg)
+			}
+		}
+	}
+
+	states, err = s.GetMulti(context.Background(), nil)
+	if err != nil || len(states) != 0 {
+		t.Errorf("GetMulti of no keys = %d states, %v; want none", len(states), err)
+	}
+}
+
+func (su *suite) testSetMulti(t *testing.T, s storage.Storage, key func(string) string) {
+	states := map[string]*storage.State{
+		key("a"): sampleState(1),
+		key("b"): sampleState(2),
+		key("c"): sampleState(3),
+	}
+	if err := s.SetMulti(context.Background(), states, ttl); err != nil {
+		t.Fatalf("SetMulti: %v", err)
+	}
+	for k, want := range states {
+		su.checkGet(t, s, k, want)
+	}
+}
+
+func (su *suite) testKeys(t *testing.T, s storage.Storage, key func(string) string) {
+	want := []string{key("user:1"), key("user:2")}
+	for _, k := range append([]string{key("ip:1")}, want...) {
+		mustSet(t, s, k, sampleState(1))
+	}
+
+	got, err := s.Keys(context.Background(), key("user:*"))
+	if err != nil {
+		t.Fatalf("Keys: %v", err)
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Errorf("Keys(%q) = %q, want %q", key("user:*"), got, want)
+	}
+}
+
+func (su *suite) testExpiry(t *testing.T, s storage.Storage, key func(string) string) {
+	ctx := context.Background()
+	if err := s.Set(ctx, key("short"), sampleState(1), su.ttl); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if _, err := s.Incr(ctx, key("counter"), 5, su.ttl); err != nil {
+		t.Fatalf("Incr: %v", err)
+	}
+	if err := s.Set(ctx, key("forever"), sampleState(2), 0); err != nil {
+		t.Fatalf("Set without TTL: %v", err)
+	}
+	checkExists(t, s, key("short"), true)
+
+	su.advance(2 * su.ttl)
+
+	if _, err := s.Get(ctx, key("short")); !errors.Is(err, storage.ErrKeyNotFound) {
+		t.Errorf("Get after the TTL: err = %v, want storage.ErrKeyNotFound", err)
+	}
+	checkExists(t, s, key("short"), false)
+	states, err := s.GetMulti(ctx, []string{key("short")})
+	if err != nil || len(states) != 1 || states[0] != nil {
+		t.Errorf("GetMulti after the TTL = %v, %v; want [nil]", states, err)
+	}
+	if keys, err := s.Keys(ctx, key("short*")); err != nil || len(keys) != 0 {
+		t.Errorf("Keys after the TTL = %q, %v; want none", keys, err)
+	}
+	if n, err := s.Incr(ctx, key("counter"), 1, su.ttl); err != nil || n != 1 {
+		t.Errorf("Incr after the TTL = %d, %v; want a new counter at 1", n, err)
+	}
+	checkExists(t, s, key("forever"), true)
+}
+
+func (su *suite) testPing(t *testing.T, s storage.Storage, _ func(string) string) {
+	if err := s.Ping(context.Background()); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+}
+
+func (su *suite) testClose(t *testing.T, s storage.Storage, key func(string) string) {
+	ctx := context.Background()
+	mustSet(t, s, key("k"), sampleState(1))
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	ops := []struct {
+		name string
+		call func() error
+	}{
+		{"Get", func() error { _, err := s.Get(ctx, key("k")); return err }},
+		{"Set", func() error { return s.Set(ctx, key("k"), sampleState(2), ttl) }},
+		{"Incr", func() error { _, err := s.Incr(ctx, key("counter"), 1, ttl); return err }},
+		{"Ping", func() error { return s.Ping(ctx) }},
+	}
+	for _, op := range ops {
+		if err := callSafely(op.call); err == nil {
+			t.Errorf("%s after Close succeeded, want an error", op.name)
+		} else if errors.Is(err, errPanic) {
+			t.Errorf("%s after Close: %v", op.name, err)
+		}
+	}
+	if err := callSafely(s.Close); errors.Is(err, errPanic) {
+		t.Errorf("second Close: %v", err)
+	}
+}
+
+// errPanic wraps panics recovered by callSafely.
+var errPanic = errors.New("panic")
+
+// callSafely calls f, turning a panic into an error wrapping errPanic.
+func callSafely(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", errPanic, r)
+		}
+	}()
+	return f()
+}
+
+// mustSet stores state under key, failing t on error.
+func mustSet(t *testing.T, s storage.Storage, key string, state *storage.State) {
+	t.Helper()
+	if err := s.Set(context.Background(), key, state, ttl); err != nil {
+		t.Fatalf("Set(%q): %v", key, err)
+	}
+}
+
+// checkGet fails t unless key holds want.
+func (su *suite) checkGet(t *testing.T, s storage.Storage, key string, want *storage.State) {
+	t.Helper()
+	got, err := s.Get(context.Background(), key)
+	if err != nil {
+		t.Errorf("Get(%q): %v", key, err)
+		return
+	}
+	if msg := diffStates(want, got, su.metadata); msg != "" {
+		t.Errorf("Get(%q): %s", key, msg)
+	}
+}
+
+// checkExists fails t unless Exists reports want for key.
+func checkExists(t *testing.T, s storage.Storage, key string, want bool) {
+	t.Helper()
+	got, err := s.Exists(context.Background(), key)
+	if err != nil {
+		t.Errorf("Exists(%q): %v", key, err)
+		return
+	}
+	if got != want {
+		t.Errorf("Exists(%q) = %t, want %t", key, got, want)
+	}
+}
+
+// diffStates describes the first difference between want and got, or
+// returns "". Times are compared with time.Time.Equal. UpdatedAt isn't
+// compared, since backends may set it themselves when storing a state,
+// and Metadata only if metadata is set.
+func diffStates(want, got *storage.State, metadata bool) string {
+	switch {
+	case want.Tokens != got.Tokens:
+		return fmt.Sprintf("Tokens = %v, want %v", got.Tokens, want.Tokens)
+	case want.Count != got.Count:
+		return fmt.Sprintf("Count = %d, want %d", got.Count, want.Count)
+	case !want.LastRefill.Equal(got.LastRefill):
+		return fmt.Sprintf("LastRefill = %s, want %s", got.LastRefill, want.LastRefill)
+	case !want.WindowStart.Equal(got.WindowStart):
+		return fmt.Sprintf("WindowStart = %s, want %s", got.WindowStart, want.WindowStart)
+	case !want.CreatedAt.Equal(got.CreatedAt):
+		return fmt.Sprintf("CreatedAt = %s, want %s", got.CreatedAt, want.CreatedAt)
+	case len(want.Timestamps) != len(got.Timestamps):
+		return fmt.Sprintf("%d Timestamps, want %d", len(got.Timestamps), len(want.Timestamps))
+	}
+	for i := range want.Timestamps {
+		if !want.Timestamps[i].Equal(got.Timestamps[i]) {
+			return fmt.Sprintf("Timestamps[%d] = %s, want %s", i, got.Timestamps[i], want.Timestamps[i])
+		}
+	}
+	if metadata && !reflect.DeepEqual(want.Metadata, got.Metadata) {
+		return fmt.Sprintf("Metadata = %v, want %v", got.Metadata, want.Metadata)
+	}
+	return ""
+}
@@ -0,0 +1,112 @@
+package flexlimit
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"github.com/Vipul984/flexlimit/metrics"
+)
+
+// tarpitRecord is the kind of record storing how many of a key's
+// requests in a row went over the limit under a tarpit (see recordKey).
+const tarpitRecord = "tarpit"
+
+// Tarpit configures progressive delays for requests over the limit. See
+// WithTarpit.
+type Tarpit struct {
+	// BaseDelay is the delay of a key's first request over its limit.
+	// Default: 100 milliseconds
+	BaseDelay time.Duration
+
+	// MaxDelay bounds the delay. Default: 30 seconds
+	MaxDelay time.Duration
+
+	// Multiplier is how much the delay grows with each further request
+	// over the limit, at least 1. Default: 2
+	Multiplier float64
+
+	// Jitter is the fraction of each delay, in [0, 1], taken off at
+	// random, so held requests don't all come back at once. Default: 0
+	Jitter float64
+}
+
+// WithTarpit makes Wait and WaitN delay requests over the limit instead
+// of waiting for tokens: the first request of a key over its limit is
+// held for cfg.BaseDelay, the next for cfg.Multiplier times as long, and
+// so on up to cfg.MaxDelay, after which the request goes through without
+// consuming anything. A key starts over a window after its first request
+// over the limit.
+//
+// A client bursting slightly over its limit barely notices; a scraper
+// hammering away gets slower and slower answers rather than 429s it could
+// learn to work around. Allow and AllowN still deny requests over the
+// limit, so a server can tarpit some endpoints and reject on others.
+//
+// Requests over the limit are reported to OnLimit and counted as denied,
+// then in metrics.TarpitDelayed once held. The count of requests over the
+// limit is kept in the limiter's storage, shared by instances, and a
+// count that can't be read or written delays by cfg.BaseDelay. A tarpit
+// can't be combined with WithQueue.
+//
+// Example:
+//
+//	limiter, err := flexlimit.New(10, time.Second,
+//	    flexlimit.WithTarpit(flexlimit.Tarpit{MaxDelay: 10 * time.Second, Jitter: 0.2}),
+//	)
+//
+//	if err := limiter.Wait(r.Context(), "ip:"+ip); err != nil {
+//	    return // client gave up
+//	}
+func WithTarpit(cfg Tarpit) Option {
+	return func(o *Options) {
+		if cfg.BaseDelay == 0 {
+			cfg.BaseDelay = 100 * time.Millisecond
+		}
+		if cfg.MaxDelay == 0 {
+			cfg.MaxDelay = 30 * time.Second
+		}
+		if cfg.Multiplier == 0 {
+			cfg.Multiplier = 2
+		}
+		o.tarpit = &cfg
+	}
+}
+
+// tarpitDelay counts a request for key over the limit and returns how
+// long to hold it.
+func (l *Limiter) tarpitDelay(ctx context.Context, key string) time.Duration {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	t := l.opts.tarpit
+	strikes, err := l.be.store.Incr(ctx, recordKey(tarpitRecord, key), 1, l.window)
+	if err != nil {
+		return t.BaseDelay
+	}
+
+	delay := float64(t.BaseDelay) * math.Pow(t.Multiplier, float64(strikes-1))
+	delay = min(delay, float64(t.MaxDelay))
+	if t.Jitter > 0 {
+		delay -= delay * t.Jitter * rand.Float64()
+	}
+	return time.Duration(delay)
+}
+
+// waitTarpit holds a request over the limit for key, then lets it
+// through.
+func (l *Limiter) waitTarpit(ctx context.Context, key string) error {
+	delay := l.tarpitDelay(ctx, key)
+	if deadline, ok := ctx.Deadline(); ok && deadline.Sub(l.opts.clock.Now()) < delay {
+		return wrapContextError(context.DeadlineExceeded)
+	}
+	if err := l.sleep(ctx, delay); err != nil {
+		return err
+	}
+
+	l.mu.RLock()
+	l.opts.metrics.IncCounter(metrics.TarpitDelayed, l.labels)
+	l.mu.RUnlock()
+	return nil
+}
@@ -0,0 +1,76 @@
+package flexlimit
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+// slowStore is a memory store taking a while to write, so concurrent
+// callers interleave.
+type slowStore struct {
+	storage.Storage
+}
+
+func (s slowStore) Set(ctx context.Context, key string, state *storage.State, ttl time.Duration) error {
+	time.Sleep(10 * time.Millisecond)
+	return s.Storage.Set(ctx, key, state, ttl)
+}
+
+func (s slowStore) Incr(ctx context.Context, key string, amount int64, ttl time.Duration) (int64, error) {
+	time.Sleep(10 * time.Millisecond)
+	return s.Storage.Incr(ctx, key, amount, ttl)
+}
+
+func TestTarpitDelayConcurrent(t *testing.T) {
+	l, err := New(1, time.Minute,
+		WithTarpit(Tarpit{BaseDelay: time.Millisecond, MaxDelay: time.Hour}),
+		WithStorage(slowStore{storage.NewMemory(storage.Config{})}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// Each caller counts its own strike, so the delays double from one to
+	// the next with none repeated.
+	const callers = 20
+	delays := make([]time.Duration, callers)
+	var wg sync.WaitGroup
+	for i := range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			delays[i] = l.tarpitDelay(context.Background(), "k")
+		}()
+	}
+	wg.Wait()
+
+	slices.Sort(delays)
+	for i, d := range delays {
+		if want := time.Millisecond << i; d != want {
+			t.Fatalf("delays = %v, want 1ms doubling %d times", delays, callers-1)
+		}
+	}
+}
+
+func TestTarpitDelayCapped(t *testing.T) {
+	l, err := New(1, time.Minute, WithTarpit(Tarpit{BaseDelay: time.Second, MaxDelay: 3 * time.Second}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var got []time.Duration
+	for range 4 {
+		got = append(got, l.tarpitDelay(context.Background(), "k"))
+	}
+	want := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second}
+	if !slices.Equal(got, want) {
+		t.Errorf("delays = %v, want %v", got, want)
+	}
+}
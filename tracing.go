@@ -0,0 +1,144 @@
+package flexlimit
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// TraceContext holds W3C Trace Context identifiers and baggage for a request.
+//
+// When a TraceContext is attached to the context passed to the limiter, its
+// identifiers are copied into every LimitInfo the limiter emits, so a denied
+// request can be correlated with the trace that produced it without adding
+// metadata by hand.
+//
+// Example:
+//
+//	tc, ok := flexlimit.ExtractTraceContext(r.Header)
+//	if ok {
+//	    ctx = flexlimit.ContextWithTrace(ctx, tc)
+//	}
+//	allowed, err := limiter.Allow(ctx, key)
+type TraceContext struct {
+	// TraceID is the 32 hex character trace identifier
+	TraceID string
+
+	// SpanID is the 16 hex character parent span identifier
+	SpanID string
+
+	// Sampled reports whether the caller sampled this trace
+	Sampled bool
+
+	// Baggage holds the W3C baggage members (key=value pairs)
+	Baggage map[string]string
+}
+
+// IsValid reports whether tc carries a usable trace and span ID.
+func (tc TraceContext) IsValid() bool {
+	return isHexID(tc.TraceID, 32) && isHexID(tc.SpanID, 16)
+}
+
+// traceContextKey is the context key for TraceContext values.
+type traceContextKey struct{}
+
+// ContextWithTrace returns a copy of ctx carrying tc.
+func ContextWithTrace(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// TraceFromContext returns the TraceContext attached to ctx, if any.
+func TraceFromContext(ctx context.Context) (TraceContext, bool) {
+	if ctx == nil {
+		return TraceContext{}, false
+	}
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc, ok
+}
+
+// ExtractTraceContext reads the "traceparent" and "baggage" headers.
+//
+// It returns false if the traceparent header is missing or malformed.
+// Baggage is parsed on a best-effort basis; malformed members are skipped.
+func ExtractTraceContext(h http.Header) (TraceContext, bool) {
+	tc, ok := ParseTraceParent(h.Get("traceparent"))
+	if !ok {
+		return TraceContext{}, false
+	}
+
+	if baggage := h.Values("baggage"); len(baggage) > 0 {
+		tc.Baggage = ParseBaggage(strings.Join(baggage, ","))
+	}
+	return tc, true
+}
+
+// ParseTraceParent parses a W3C traceparent header value of the form
+// "00-<trace-id>-<span-id>-<flags>".
+func ParseTraceParent(value string) (TraceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return TraceContext{}, false
+	}
+
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	if !isHexID(version, 2) || version == "ff" || !isHexID(flags, 2) {
+		return TraceContext{}, false
+	}
+	// Version 00 has exactly four fields; later versions may append more.
+	if version == "00" && len(parts) != 4 {
+		return TraceContext{}, false
+	}
+
+	tc := TraceContext{TraceID: traceID, SpanID: spanID}
+	if !tc.IsValid() {
+		return TraceContext{}, false
+	}
+
+	b, _ := hex.DecodeString(flags)
+	tc.Sampled = b[0]&0x01 == 0x01
+	return tc, true
+}
+
+// ParseBaggage parses a W3C baggage header value into key/value pairs.
+// Member properties (";prop=value") are dropped.
+func ParseBaggage(value string) map[string]string {
+	baggage := make(map[string]string)
+	for _, member := range strings.Split(value, ",") {
+		member, _, _ = strings.Cut(member, ";")
+		k, v, ok := strings.Cut(member, "=")
+		if !ok {
+			continue
+		}
+
+		k = strings.TrimSpace(k)
+		if k == "" {
+			continue
+		}
+		if unescaped, err := url.PathUnescape(strings.TrimSpace(v)); err == nil {
+			baggage[k] = unescaped
+		}
+	}
+	return baggage
+}
+
+// isHexID reports whether s is n lowercase hex characters and not all zeros.
+func isHexID(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+
+	nonZero := false
+	for _, c := range s {
+		switch {
+		case c == '0':
+		case c >= '1' && c <= '9', c >= 'a' && c <= 'f':
+			nonZero = true
+		default:
+			return false
+		}
+	}
+	// Version and flags fields may legitimately be all zeros.
+	return nonZero || n == 2
+}
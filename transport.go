@@ -0,0 +1,320 @@
+package flexlimit
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transport is an http.RoundTripper limiting outbound requests with a
+// Limiter, so a client stays within the budget of the APIs it calls, per
+// host or per API key. By default a request over the limit waits for its
+// turn, as with Limiter.Wait; set Reject to fail it instead.
+//
+// Set the exported fields before the Transport's first use; a Transport
+// is safe for concurrent use.
+//
+// Example:
+//
+//	limiter, err := flexlimit.New(10, time.Second)
+//	if err != nil {
+//	    return err
+//	}
+//	t := flexlimit.NewTransport(limiter, flexlimit.HostKey, nil)
+//	t.RespectRetryAfter = true
+//	client := &http.Client{Transport: t}
+type Transport struct {
+	// Reject makes RoundTrip fail requests over the limit with a
+	// *LimitExceededError instead of waiting for them. Default: false
+	Reject bool
+
+	// RespectRetryAfter holds back a key's requests after a 429 Too Many
+	// Requests or 503 Service Unavailable response with a Retry-After
+	// header, until the time it gives, on top of the limiter. The response
+	// itself is returned as is. Default: false
+	RespectRetryAfter bool
+
+	// AdaptToHeaders tunes the limiter to the rate limit the upstream
+	// advertises in its responses, so the client stays just under the
+	// provider's real limit without configuring it by hand. Both the
+	// RateLimit header fields of the IETF draft (RateLimit-Limit,
+	// RateLimit-Remaining, RateLimit-Reset and RateLimit-Policy) and the
+	// common X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset
+	// are read; X-RateLimit-Reset may be a delay in seconds or a Unix time.
+	//
+	// When a response gives both the upstream's limit and its window, as
+	// RateLimit-Policy does, the limiter's rate and window are set to
+	// match (see Limiter.SetLimit), less Headroom. When a response says
+	// no requests remain, the key's requests are held back until the
+	// upstream's limit resets, as with RespectRetryAfter. Since the rate
+	// applies to every key of the limiter, adapt one Transport and
+	// Limiter per upstream API. Default: false
+	AdaptToHeaders bool
+
+	// Headroom is the fraction of the upstream's limit, in [0, 1), left
+	// unused when AdaptToHeaders sets the limiter's rate, to absorb
+	// requests from other clients sharing the same quota. Default: 0
+	Headroom float64
+
+	limiter *Limiter
+	key     func(*http.Request) string
+	base    http.RoundTripper
+
+	mu     sync.Mutex
+	paused map[string]time.Time // end of each key's Retry-After
+}
+
+// NewTransport returns a Transport sending requests through base (default:
+// http.DefaultTransport) once l allows them, keyed by key, such as
+// HostKey. Requests for which key returns "" aren't limited. The caller
+// keeps ownership of l.
+func NewTransport(l *Limiter, key func(*http.Request) string, base http.RoundTripper) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{
+		limiter: l,
+		key:     key,
+		base:    base,
+		paused:  make(map[string]time.Time),
+	}
+}
+
+// HostKey keys outbound requests by host, as in "host:api.example.com",
+// giving each API its own budget.
+func HostKey(r *http.Request) string {
+	return "host:" + r.URL.Host
+}
+
+// RoundTrip implements http.RoundTripper. It returns the errors of
+// Limiter.Wait, such as ErrContextCanceled if the request's context ends
+// while it waits, and with Reject a *LimitExceededError for a request over
+// the limit.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := t.key(req)
+	if key == "" {
+		return t.base.RoundTrip(req)
+	}
+
+	if err := t.acquire(req.Context(), key); err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err == nil && (t.RespectRetryAfter || t.AdaptToHeaders) {
+		t.observe(key, resp)
+	}
+	return resp, err
+}
+
+// CloseIdleConnections closes the idle connections of the base transport,
+// if it supports it.
+func (t *Transport) CloseIdleConnections() {
+	if c, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// acquire waits until a request for key may be sent, or with Reject
+// returns a *LimitExceededError if it may not be sent now.
+func (t *Transport) acquire(ctx context.Context, key string) error {
+	if wait, until := t.pause(key); wait > 0 {
+		if t.Reject {
+			rate, window := t.limiter.Limit()
+			return &LimitExceededError{
+				Key:        t.limiter.HashKey(key),
+				Limit:      rate,
+				Window:     window,
+				Used:       rate,
+				RetryAfter: wait,
+				ResetAt:    until,
+			}
+		}
+		if err := t.limiter.sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+
+	if !t.Reject {
+		return t.limiter.Wait(ctx, key)
+	}
+	allowed, st, err := t.limiter.allowN(ctx, key, 1)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return newLimitExceededError("", key, t.limiter, st)
+	}
+	return nil
+}
+
+// pause returns how long requests for key are still held back by a
+// Retry-After, and until when.
+func (t *Transport) pause(key string) (time.Duration, time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	until, ok := t.paused[key]
+	if !ok {
+		return 0, time.Time{}
+	}
+	wait := until.Sub(t.limiter.clock.Now())
+	if wait <= 0 {
+		delete(t.paused, key)
+	}
+	return wait, until
+}
+
+// observe holds back requests for key if resp asks the client to retry
+// later, and with AdaptToHeaders tunes the limiter to the upstream's limit.
+func (t *Transport) observe(key string, resp *http.Response) {
+	now := t.limiter.clock.Now()
+
+	var wait time.Duration
+	if t.RespectRetryAfter && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		wait, _ = parseRetryAfter(resp.Header.Get("Retry-After"), now)
+	}
+	if t.AdaptToHeaders {
+		if up, ok := parseUpstreamLimit(resp.Header, now); ok {
+			t.adapt(up)
+			if up.remaining == 0 {
+				wait = max(wait, up.reset)
+			}
+		}
+	}
+	if wait > 0 {
+		t.hold(key, now.Add(wait))
+	}
+}
+
+// adapt sets the limiter's rate and window to the upstream's limit, less
+// Headroom, if the upstream gave both. A limit the limiter rejects is
+// ignored.
+func (t *Transport) adapt(up upstreamLimit) {
+	if up.limit <= 0 || up.window <= 0 {
+		return
+	}
+	headroom := min(max(t.Headroom, 0), 1)
+	rate := max(int(float64(up.limit)*(1-headroom)), 1)
+	if r, w := t.limiter.Limit(); r == rate && w == up.window {
+		return
+	}
+	_ = t.limiter.SetLimit(rate, up.window)
+}
+
+// hold holds back requests for key until the given time.
+func (t *Transport) hold(key string, until time.Time) {
+	now := t.limiter.clock.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for k, until := range t.paused {
+		if !until.After(now) {
+			delete(t.paused, k)
+		}
+	}
+	if until.After(t.paused[key]) {
+		t.paused[key] = until
+	}
+}
+
+// parseRetryAfter parses a Retry-After header value, a number of seconds
+// or an HTTP date, into a delay from now.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if secs <= 0 {
+			return 0, false
+		}
+		return time.Duration(min(secs, math.MaxInt64/int64(time.Second))) * time.Second, true
+	}
+	at, err := http.ParseTime(value)
+	if err != nil || !at.After(now) {
+		return 0, false
+	}
+	return at.Sub(now), true
+}
+
+// upstreamLimit is the rate limit an upstream advertises in the headers of
+// a response.
+type upstreamLimit struct {
+	limit     int           // 0 if unknown
+	remaining int           // -1 if unknown
+	reset     time.Duration // 0 if unknown
+	window    time.Duration // 0 if unknown
+}
+
+// unixTimeThreshold tells Unix times from delays in X-RateLimit-Reset:
+// larger values are taken as Unix times.
+const unixTimeThreshold = 1_000_000_000
+
+// parseUpstreamLimit reads the rate limit headers of h. It returns false if
+// h has none.
+func parseUpstreamLimit(h http.Header, now time.Time) (upstreamLimit, bool) {
+	up := upstreamLimit{remaining: -1}
+	found := false
+
+	if v, ok := headerInt(h, "RateLimit-Limit", "X-RateLimit-Limit"); ok && v > 0 {
+		up.limit, found = int(v), true
+	}
+	if v, ok := headerInt(h, "RateLimit-Remaining", "X-RateLimit-Remaining"); ok && v >= 0 {
+		up.remaining, found = int(v), true
+	}
+	if v, ok := headerInt(h, "RateLimit-Reset", "X-RateLimit-Reset"); ok && v > 0 {
+		found = true
+		if v > unixTimeThreshold {
+			up.reset = max(time.Unix(v, 0).Sub(now), 0)
+		} else {
+			up.reset = time.Duration(v) * time.Second
+		}
+	}
+	if limit, window, ok := parsePolicy(h.Get("RateLimit-Policy"), up.limit); ok {
+		up.limit, up.window, found = limit, window, true
+	}
+	return up, found
+}
+
+// headerInt returns the integer value of the first of names present in h.
+func headerInt(h http.Header, names ...string) (int64, bool) {
+	for _, name := range names {
+		if v := strings.TrimSpace(h.Get(name)); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			return n, err == nil
+		}
+	}
+	return 0, false
+}
+
+// parsePolicy parses a RateLimit-Policy value such as
+// "10;w=1, 1000;w=86400" and returns the quota whose limit is active, or
+// the first quota with a window if active is 0 or not listed.
+func parsePolicy(value string, active int) (int, time.Duration, bool) {
+	var limit int
+	var window time.Duration
+	for _, item := range strings.Split(value, ",") {
+		params := strings.Split(item, ";")
+		n, err := strconv.Atoi(strings.TrimSpace(params[0]))
+		if err != nil || n <= 0 {
+			continue
+		}
+		for _, p := range params[1:] {
+			name, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			secs, err := strconv.ParseInt(v, 10, 64)
+			if name != "w" || err != nil || secs <= 0 {
+				continue
+			}
+			if window == 0 || n == active {
+				limit, window = n, time.Duration(secs)*time.Second
+			}
+			if n == active {
+				return limit, window, true
+			}
+		}
+	}
+	return limit, window, window > 0
+}
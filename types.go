@@ -1,7 +1,15 @@
 package flexlimit
 
 import (
+	"context"
+	"log/slog"
+	"strings"
 	"time"
+
+	"github.com/Vipul984/flexlimit/algorithm"
+	"github.com/Vipul984/flexlimit/clock"
+	"github.com/Vipul984/flexlimit/metrics"
+	"github.com/Vipul984/flexlimit/storage"
 )
 
 // State represents the current rate limiting state for a specific key.
@@ -11,7 +19,10 @@ import (
 //
 // Example:
 //
-//	state := limiter.State(ctx, "user:123")
+//	state, err := limiter.State(ctx, "user:123")
+//	if err != nil {
+//	    return err
+//	}
 //	fmt.Printf("Used: %d/%d\n", state.Used, state.Limit)
 //	fmt.Printf("Remaining: %d\n", state.Remaining)
 //	fmt.Printf("Resets in: %s\n", state.ResetIn)
@@ -43,6 +54,18 @@ type State struct {
 
 	// Window is the time window for this rate limit (e.g., 1 minute, 1 hour)
 	Window time.Duration
+
+	// Status is the key's lifecycle status, or empty if the limiter has no
+	// lifecycle policy (see WithLifecycle)
+	Status KeyStatus
+
+	// BannedUntil is when the key's ban ends. Zero unless Status is
+	// KeyBanned, and for bans without an end
+	BannedUntil time.Time
+
+	// Offenses is the number of automatic bans of the key so far (see
+	// LifecyclePolicy.BanEscalation)
+	Offenses int
 }
 
 // LimitInfo provides contextual information when a rate limit event occurs.
@@ -52,7 +75,7 @@ type State struct {
 //
 // Example:
 //
-//	limiter := flexlimit.New(100, time.Minute,
+//	limiter, err := flexlimit.New(100, time.Minute,
 //	    flexlimit.OnLimit(func(info LimitInfo) {
 //	        log.Warn("Rate limited",
 //	            "key", info.Key,
@@ -91,9 +114,52 @@ type LimitInfo struct {
 	// (e.g., "token_bucket", "sliding_window", "fixed_window")
 	Algorithm string
 
+	// Warning is true if the request was over the limit but allowed by the
+	// grace period of WithGracePeriod
+	Warning bool
+
+	// EnforceAt is when the grace period ends and requests over the limit
+	// start being denied. Zero unless Warning is set
+	EnforceAt time.Time
+
+	// Shed is true if the request was within its key's limit but denied
+	// because the process is overloaded (see WithLoadShedder)
+	Shed bool
+
+	// Preempted is true if the request was within its key's limit but
+	// denied to keep the rest for higher priorities (see
+	// WithPriorityReserve)
+	Preempted bool
+
+	// Blocked is true if the request was denied because its key is in the
+	// limiter's denylist (see WithDenylist)
+	Blocked bool
+
 	// Metadata allows passing custom data through callbacks
 	// This can be used for request tracing, user context, etc.
+	// It is set by AllowWithInfo or ContextWithMetadata
 	Metadata map[string]interface{}
+
+	// TraceID is the W3C trace ID of the request, if the context passed to
+	// the limiter carried a TraceContext (see ContextWithTrace)
+	TraceID string
+
+	// SpanID is the W3C parent span ID of the request, if known
+	SpanID string
+
+	// Baggage is the W3C baggage propagated with the request, if any
+	Baggage map[string]string
+}
+
+// withTrace copies trace identifiers from ctx into info.
+func (info *LimitInfo) withTrace(ctx context.Context) {
+	tc, ok := TraceFromContext(ctx)
+	if !ok {
+		return
+	}
+	info.TraceID = tc.TraceID
+	info.SpanID = tc.SpanID
+	info.Baggage = tc.Baggage
 }
 
 // RequestContext provides multiple identifiers for composite rate limiting.
@@ -135,7 +201,8 @@ type RequestContext struct {
 	Custom map[string]string
 
 	// Metadata is for passing additional context through the system
-	// This is NOT used for rate limiting keys, only for observability
+	// This is NOT used for rate limiting keys, only for observability and
+	// the request's priority (see RequestContext.Priority)
 	Metadata map[string]interface{}
 }
 
@@ -149,12 +216,22 @@ type RequestContext struct {
 //	ctx := RequestContext{IP: "1.2.3.4", UserID: "user:123"}
 //	ipKey := ctx.Key("ip")     // Returns "ip:1.2.3.4"
 //	userKey := ctx.Key("user") // Returns "user:user:123"
+//
+// The "subnet" strategy keys by the IP's prefix under
+// DefaultIPAggregation, so IPv6 clients share a key per /64:
+//
+//	ctx = RequestContext{IP: "2001:db8::1"}
+//	subnetKey := ctx.Key("subnet") // Returns "subnet:2001:db8::/64"
 func (rc RequestContext) Key(strategy string) string {
 	switch strategy {
 	case "ip":
 		if rc.IP != "" {
 			return "ip:" + rc.IP
 		}
+	case "subnet":
+		if rc.IP != "" {
+			return "subnet:" + DefaultIPAggregation.Aggregate(rc.IP)
+		}
 	case "user":
 		if rc.UserID != "" {
 			return "user:" + rc.UserID
@@ -197,18 +274,38 @@ func (rc RequestContext) Key(strategy string) string {
 //	}
 type Options struct {
 	// algorithm specifies which rate limiting algorithm to use
-	// (token_bucket, sliding_window, fixed_window, leaky_bucket)
+	// (token_bucket, sliding_window, fixed_window, leaky_bucket,
+	// decayed_window, or a registered name)
 	algorithm string
 
+	// algorithmInstance is the algorithm passed to WithAlgorithm, if any;
+	// algorithm is then "custom"
+	algorithmInstance algorithm.Algorithm
+
 	// storage is the backend for storing rate limit state
 	// (memory, redis, etc.)
-	storage interface{} // Will be storage.Storage once we define it
+	storage storage.Storage
+
+	// adminStorage is a second handle on the same backend used for admin
+	// operations, so they can't starve the request path
+	adminStorage storage.Storage
+
+	// keyPrefix namespaces the keys of storage and adminStorage
+	keyPrefix string
+
+	// hashKeys replaces keys by their SHA-256 digest, or their
+	// HMAC-SHA-256 keyed by keySecret if it is set
+	hashKeys  bool
+	keySecret []byte
+
+	// logger receives operational events; nil discards them
+	logger *slog.Logger
 
 	// clock is the time source (real or mock for testing)
-	clock interface{} // Will be clock.Clock once we define it
+	clock clock.Clock
 
 	// metrics is the metrics collector for observability
-	metrics interface{} // Will be metrics.Collector once we define it
+	metrics metrics.Collector
 
 	// onLimit is called when a request is denied
 	onLimit func(LimitInfo)
@@ -216,6 +313,21 @@ type Options struct {
 	// onAllow is called when a request is allowed
 	onAllow func(LimitInfo)
 
+	// onStateChange is called when an allowed request changes its key's
+	// usage past one of thresholds, or is the first of the key
+	onStateChange func(StateChange)
+	thresholds    []float64
+
+	// callbackPolicies thin out the calls of callbacks (see
+	// WithCallbackPolicy)
+	callbackPolicies map[Callback]CallbackPolicy
+
+	// eventBuffer is the buffer of the Events channel
+	eventBuffer int
+
+	// onKeyEvicted is called when the in-memory store drops a key
+	onKeyEvicted func(key string, state *storage.State)
+
 	// fallbackStrategy defines behavior when storage fails
 	// ("allow_all", "deny_all", "local_memory")
 	fallbackStrategy string
@@ -223,9 +335,120 @@ type Options struct {
 	// onFallback is called when fallback is activated
 	onFallback func(error)
 
+	// localFallbackScale divides the rate used by the local_memory fallback
+	// (e.g., the number of instances sharing the distributed limit)
+	localFallbackScale int
+
+	// autoRepair resets keys whose stored state is corrupt instead of
+	// failing every request for them
+	autoRepair bool
+
+	// onRepair is called after a corrupt key was reset
+	onRepair func(key string, cause error)
+
+	// windowBuckets is the number of sub-window counters per key
+	// (only for sliding window algorithm; 0 keeps every timestamp)
+	windowBuckets int
+
+	// calendar aligns fixed windows to calendar periods in location
+	// (empty for windows of the limiter's window)
+	calendar CalendarPeriod
+	location *time.Location
+
+	// queueSize is how many units Wait may queue beyond the burst size
+	// (only for leaky bucket algorithm)
+	queueSize int
+
+	// drainJitter spreads the releases of leaky bucket keys (see
+	// WithDrainJitter)
+	drainJitter time.Duration
+
+	// rollover caps the unused requests fixed window keys carry into
+	// later windows (see WithRollover)
+	rollover int
+
+	// gracePeriod delays enforcement after the first denial of a window
+	// (0 disables it)
+	gracePeriod time.Duration
+
+	// shadow allows requests over the limit while still reporting them
+	shadow bool
+
+	// advisor enables limit suggestions from observed traffic (nil
+	// disables them)
+	advisor *AdvisorConfig
+
+	// lifecycle tracks key statuses (nil disables it)
+	lifecycle *LifecyclePolicy
+
 	// maxKeys is the maximum number of keys to track (prevents memory exhaustion)
 	maxKeys int
 
+	// adaptive adapts each key's rate to reported outcomes (nil without
+	// WithAdaptive)
+	adaptive *AdaptiveConfig
+
+	// warmup ramps new keys up to the full rate (nil without WithWarmup)
+	warmup *WarmupConfig
+
+	// decay relaxes the limits of idle keys (nil without WithDecay)
+	decay *DecayPolicy
+
+	// regions splits the limit across regions (nil without WithRegions)
+	regions *RegionConfig
+
+	// maintenance changes the limit during daily windows (see
+	// WithMaintenanceWindows)
+	maintenance []MaintenanceWindow
+
+	// priority keeps part of every key's limit for important requests
+	// (nil without WithPriorityReserve)
+	priority *PriorityReserve
+
+	// shedder lowers every key's rate while the process is overloaded
+	// (nil without WithLoadShedder)
+	shedder *LoadShedder
+
+	// tarpit delays Wait requests over the limit instead of waiting for
+	// tokens (nil without WithTarpit)
+	tarpit *Tarpit
+
+	// allowlist holds keys whose requests are always allowed (nil without
+	// WithAllowlist)
+	allowlist *KeyList
+
+	// denylist holds keys whose requests are always denied (nil without
+	// WithDenylist)
+	denylist *KeyList
+
+	// selfLimits bound the resources the limiter itself may use
+	selfLimits SelfLimits
+
+	// softDeadline and hardDeadline bound how long a decision waits for
+	// storage (0 waits as long as the context allows)
+	softDeadline time.Duration
+	hardDeadline time.Duration
+
+	// latencyBudget bounds every decision, replacing the hard deadline
+	// (0 = unbounded)
+	latencyBudget time.Duration
+
+	// maxClockSkew is how far the clock may drift from the storage's
+	// before it is corrected (0 = never corrected)
+	maxClockSkew time.Duration
+
+	// cacheStaleness is how long a locally cached state of the storage
+	// is used (0 = no local cache)
+	cacheStaleness time.Duration
+
+	// leaseSize is the number of tokens claimed at once from the storage
+	// (0 = no leasing)
+	leaseSize int
+
+	// coalesceWindow is how long requests for a key are collected into
+	// one storage operation (0 = not coalesced)
+	coalesceWindow time.Duration
+
 	// cleanupInterval is how often to cleanup expired keys
 	cleanupInterval time.Duration
 
@@ -239,17 +462,21 @@ type Options struct {
 // These are sensible defaults that work for most use cases.
 func defaultOptions() *Options {
 	return &Options{
-		algorithm:        "token_bucket", // Most common algorithm
-		fallbackStrategy: "allow_all",    // Fail open by default (availability over protection)
-		maxKeys:          10000,          // Reasonable memory limit
-		cleanupInterval:  5 * time.Minute,
-		burstSize:        0, // No burst by default (strict rate limiting)
+		algorithm:          "token_bucket", // Most common algorithm
+		fallbackStrategy:   "allow_all",    // Fail open by default (availability over protection)
+		localFallbackScale: 1,              // Local fallback keeps the full rate
+		maxKeys:            10000,          // Reasonable memory limit
+		cleanupInterval:    5 * time.Minute,
+		burstSize:          0, // No burst by default (strict rate limiting)
+		metrics:            metrics.Nop{},
+		eventBuffer:        1024,
 	}
 }
 
 // AlgorithmType represents the available rate limiting algorithms.
 //
-// This is used for type-safe algorithm selection.
+// This is used for type-safe algorithm selection. Names of algorithms
+// registered with algorithm.Register are valid too.
 type AlgorithmType string
 
 const (
@@ -268,8 +495,18 @@ const (
 	// LeakyBucket enforces a strict constant rate with no bursts.
 	// Requests are processed at a fixed rate, excess requests are dropped.
 	LeakyBucket AlgorithmType = "leaky_bucket"
+
+	// DecayedWindow is a sliding window where recent requests count more
+	// than old ones: each key's count decays exponentially, losing a
+	// factor of e every window. A burst is forgotten quickly while
+	// sustained pressure keeps a key limited, which suits abuse detection.
+	DecayedWindow AlgorithmType = "decayed_window"
 )
 
+// customAlgorithm names an algorithm instance passed to WithAlgorithm in
+// metrics, logs and LimitInfo.
+const customAlgorithm = "custom"
+
 // FallbackStrategy defines how the limiter behaves when storage fails.
 type FallbackStrategy string
 
@@ -297,17 +534,20 @@ func (f FallbackStrategy) String() string {
 	return string(f)
 }
 
-// Validate checks if the algorithm type is valid.
+// Validate checks if the algorithm type is a built-in algorithm or one
+// registered with algorithm.Register.
 func (a AlgorithmType) Validate() error {
 	switch a {
-	case TokenBucket, SlidingWindow, FixedWindow, LeakyBucket:
+	case TokenBucket, SlidingWindow, FixedWindow, LeakyBucket, DecayedWindow:
 		return nil
-	default:
-		return &InvalidConfigError{
-			Field:  "algorithm",
-			Value:  a,
-			Reason: "must be one of: token_bucket, sliding_window, fixed_window, leaky_bucket",
-		}
+	}
+	if _, ok := algorithm.Lookup(string(a)); ok {
+		return nil
+	}
+	return &InvalidConfigError{
+		Field:  "algorithm",
+		Value:  a,
+		Reason: "must be one of: " + strings.Join(algorithm.Names(), ", "),
 	}
 }
 
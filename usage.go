@@ -0,0 +1,88 @@
+package flexlimit
+
+import (
+	"context"
+	"strings"
+)
+
+// Usage returns the state of every key with stored state matching
+// pattern, sorted by key, without consuming any tokens: what each key has
+// used of its limit in the current window. Billing and metering systems
+// read it to charge for consumption; see the export package to write it
+// out periodically.
+//
+// In pattern, "*" matches any run of bytes and "?" any single byte;
+// everything else matches itself. An empty pattern matches every key.
+// Keys whose stored state is corrupt are skipped.
+//
+// Like Keys, Usage scans the limiter's storage, using the admin storage
+// of WithAdminStorage if set; it is meant for administration, not the
+// request path.
+//
+// Example:
+//
+//	usage, err := limiter.Usage(ctx, "tenant:*")
+//	for _, st := range usage {
+//	    meter.Record(st.Key, st.Used, st.ResetAt)
+//	}
+func (l *Limiter) Usage(ctx context.Context, pattern string) ([]*State, error) {
+	prefix := pattern
+	if i := strings.IndexAny(pattern, "*?"); i >= 0 {
+		prefix = pattern[:i]
+	}
+
+	keys, err := l.Keys(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if pattern != "" {
+		matched := keys[:0]
+		for _, key := range keys {
+			if matchPattern(pattern, key) {
+				matched = append(matched, key)
+			}
+		}
+		keys = matched
+	}
+
+	states, err := l.States(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	usage := states[:0]
+	for _, st := range states {
+		if st != nil {
+			usage = append(usage, st)
+		}
+	}
+	return usage, nil
+}
+
+// matchPattern reports whether key matches pattern, where "*" matches any
+// run of bytes and "?" any single byte.
+func matchPattern(pattern, key string) bool {
+	// Backtrack to the last "*" on a mismatch, as in the usual greedy
+	// wildcard matching.
+	p, k := 0, 0
+	star, mark := -1, 0
+	for k < len(key) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == key[k]):
+			p++
+			k++
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, k
+			p++
+		case star >= 0:
+			p = star + 1
+			mark++
+			k = mark
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
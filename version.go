@@ -0,0 +1,7 @@
+package flexlimit
+
+// Version is the version of the flexlimit API, following semantic
+// versioning. While the major version is 0, minor versions may change
+// signatures; the compat package keeps the v0 signatures compiling across
+// them.
+const Version = "0.1.0"
@@ -0,0 +1,179 @@
+package flexlimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/Vipul984/flexlimit/algorithm"
+	"github.com/Vipul984/flexlimit/metrics"
+)
+
+// Wait blocks until a single request for key is allowed, then consumes one
+// token for it.
+//
+// Example:
+//
+//	if err := limiter.Wait(ctx, "db:writes"); err != nil {
+//	    return err
+//	}
+//	db.Exec(...)
+func (l *Limiter) Wait(ctx context.Context, key string) error {
+	return l.WaitN(ctx, key, 1)
+}
+
+// WaitN blocks until a request costing n tokens is allowed for key, then
+// consumes the n tokens for it.
+//
+// With WithQueue, the request takes a place in the key's queue and is
+// released in order at the drain rate; ErrQueueFull is returned if the
+// queue has no room. With WithTarpit, a request over the limit is held
+// for the key's tarpit delay and then let through without consuming
+// tokens. Otherwise WaitN retries whenever the algorithm expects enough
+// tokens to be available again.
+//
+// Returns ErrContextCanceled or ErrContextDeadlineExceeded if ctx ends
+// first. A queued request whose turn comes after ctx's deadline fails
+// immediately instead of waiting for the deadline, and gives its place
+// back. Returns ErrKeyBlocked at once for a key of the denylist (see
+// WithDenylist).
+func (l *Limiter) WaitN(ctx context.Context, key string, n int) error {
+	if l.blocked(key) && !l.opts.shadow {
+		if _, _, err := l.allowN(ctx, key, n); err != nil {
+			return err
+		}
+		return ErrKeyBlocked
+	}
+	if l.opts.queueSize > 0 {
+		return l.waitQueued(ctx, key, n)
+	}
+
+	for {
+		allowed, st, err := l.allowN(ctx, key, n)
+		if err != nil {
+			return err
+		}
+		if allowed {
+			return nil
+		}
+		if st != nil && int64(n) > st.Limit {
+			return &InvalidConfigError{Field: "cost", Value: n, Reason: "exceeds the limit"}
+		}
+		if st != nil && l.opts.tarpit != nil {
+			return l.waitTarpit(ctx, l.HashKey(key))
+		}
+
+		if err := l.sleep(ctx, l.retryDelay(st)); err != nil {
+			return err
+		}
+	}
+}
+
+// waitQueued implements WaitN for limiters with a queue.
+func (l *Limiter) waitQueued(ctx context.Context, key string, n int) error {
+	if err := l.checkCall(ctx, n); err != nil {
+		return err
+	}
+
+	wait, q, err := l.reserve(ctx, key, n)
+	if err != nil || wait <= 0 {
+		return err
+	}
+
+	// ctx's deadline is local, so it is judged on the limiter's own clock
+	// rather than the one corrected against the storage's (see
+	// WithMaxClockSkew)
+	if deadline, ok := ctx.Deadline(); ok && deadline.Sub(l.opts.clock.Now()) < wait {
+		l.unreserve(ctx, q, key, n)
+		return wrapContextError(context.DeadlineExceeded)
+	}
+
+	if err := l.sleep(ctx, wait); err != nil {
+		l.unreserve(context.WithoutCancel(ctx), q, key, n)
+		return err
+	}
+	return nil
+}
+
+// reserve queues a request costing n tokens and returns how long it must
+// wait, along with the algorithm holding its place. q is nil if the
+// request was decided without queueing it.
+func (l *Limiter) reserve(ctx context.Context, key string, n int) (wait time.Duration, q algorithm.Queuer, err error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	q, ok := l.be.active().(algorithm.Queuer)
+	if !ok {
+		return 0, nil, &InvalidConfigError{
+			Field:  "queue_size",
+			Value:  l.opts.queueSize,
+			Reason: "algorithm does not support queueing",
+		}
+	}
+
+	if l.exempt(key) {
+		l.bypass()
+		return 0, nil, nil
+	}
+
+	key = l.HashKey(key)
+	start := l.clock.Now()
+	if l.opts.decay != nil {
+		l.decay(ctx, key, start)
+	}
+	wait, queued, st, err := q.Enqueue(ctx, key, n)
+	if err != nil && l.repair(ctx, key, err) {
+		wait, queued, st, err = q.Enqueue(ctx, key, n)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, nil, wrapContextError(ctx.Err())
+		}
+		if l.fallback(ctx, key, n, err) {
+			return 0, nil, nil
+		}
+		return 0, nil, ErrStorageUnavailable
+	}
+
+	now := l.clock.Now()
+	l.opts.metrics.ObserveDuration(metrics.DecisionDuration, now.Sub(start), l.labels)
+	l.notify(ctx, queued, deniedByLimit, st, n, now, time.Time{})
+
+	if !queued {
+		return 0, nil, ErrQueueFull
+	}
+	return wait, q, nil
+}
+
+// unreserve gives back the place of a queued request that stopped waiting.
+func (l *Limiter) unreserve(ctx context.Context, q algorithm.Queuer, key string, n int) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.closed.Load() {
+		return
+	}
+	_ = q.Dequeue(ctx, l.HashKey(key), n)
+}
+
+// retryDelay returns how long WaitN sleeps before retrying a denied request.
+func (l *Limiter) retryDelay(st *algorithm.State) time.Duration {
+	if st != nil && st.RetryAfter > 0 {
+		return st.RetryAfter
+	}
+
+	rate, window := l.Limit()
+	return window / time.Duration(rate)
+}
+
+// sleep waits for d on the limiter's clock or until ctx ends.
+func (l *Limiter) sleep(ctx context.Context, d time.Duration) error {
+	timer := l.clock.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return wrapContextError(ctx.Err())
+	case <-timer.C():
+		return nil
+	}
+}
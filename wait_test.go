@@ -0,0 +1,176 @@
+package flexlimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Vipul984/flexlimit/clock"
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+func TestWaitWokenByClock(t *testing.T) {
+	clk := clock.NewMock()
+	l, err := New(1, time.Minute, WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if allowed, _ := l.Allow(context.Background(), "k"); !allowed {
+		t.Fatal("first request denied")
+	}
+
+	waiting := clk.Waiters()
+	done := make(chan error, 1)
+	go func() { done <- l.Wait(context.Background(), "k") }()
+
+	clk.BlockUntil(waiting + 1)
+	select {
+	case err := <-done:
+		t.Fatalf("Wait returned %v before the clock moved", err)
+	default:
+	}
+
+	clk.Advance(time.Minute)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Wait = %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait still blocked after the clock moved past the refill")
+	}
+}
+
+func TestWaitCanceled(t *testing.T) {
+	clk := clock.NewMock()
+	l, err := New(1, time.Minute, WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if allowed, _ := l.Allow(context.Background(), "k"); !allowed {
+		t.Fatal("first request denied")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	waiting := clk.Waiters()
+	done := make(chan error, 1)
+	go func() { done <- l.Wait(ctx, "k") }()
+
+	clk.BlockUntil(waiting + 1)
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrContextCanceled) || !errors.Is(err, context.Canceled) {
+			t.Errorf("Wait = %v, want ErrContextCanceled matching context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait still blocked after its context was canceled")
+	}
+}
+
+func TestWaitQueuedDeadline(t *testing.T) {
+	// The limiter's clock runs an hour ahead of the wall clock, so only a
+	// deadline judged on the limiter's clock is too close for the queue.
+	clk := clock.NewMockAt(time.Now().Add(time.Hour))
+	l, err := New(1, time.Minute,
+		WithAlgorithm(LeakyBucket),
+		WithQueue(5),
+		WithClock(clk),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if err := l.Wait(context.Background(), "k"); err != nil {
+		t.Fatalf("first Wait = %v", err)
+	}
+
+	ctx, cancel := context.WithDeadline(context.Background(), clk.Now().Add(30*time.Second))
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- l.Wait(ctx, "k") }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrContextDeadlineExceeded) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Wait = %v, want ErrContextDeadlineExceeded matching context.DeadlineExceeded", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait queued a request whose turn comes after its deadline")
+	}
+
+	// The request gave its place back, so the next one waits a single
+	// drain interval.
+	waiting := clk.Waiters()
+	go func() { done <- l.Wait(context.Background(), "k") }()
+	clk.BlockUntil(waiting + 1)
+	clk.Advance(time.Minute)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Wait = %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait still blocked a drain interval after the failed request")
+	}
+}
+
+// timeStore is a memory store whose clock runs offset ahead of clk.
+type timeStore struct {
+	storage.Storage
+	clk    clock.Clock
+	offset time.Duration
+}
+
+func (s timeStore) Time(context.Context) (time.Time, error) {
+	return s.clk.Now().Add(s.offset), nil
+}
+
+func TestWaitQueuedDeadlineSkewedClock(t *testing.T) {
+	// The storage's clock runs an hour ahead, so the limiter's corrected
+	// clock does too, but the deadline is still two minutes away locally.
+	clk := clock.NewMockAt(time.Now())
+	l, err := New(1, time.Minute,
+		WithAlgorithm(LeakyBucket),
+		WithQueue(5),
+		WithClock(clk),
+		WithStorage(timeStore{Storage: storage.NewMemory(storage.Config{}), clk: clk, offset: time.Hour}),
+		WithMaxClockSkew(time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if err := l.Wait(context.Background(), "k"); err != nil {
+		t.Fatalf("first Wait = %v", err)
+	}
+
+	ctx, cancel := context.WithDeadline(context.Background(), clk.Now().Add(2*time.Minute))
+	defer cancel()
+	waiting := clk.Waiters()
+	done := make(chan error, 1)
+	go func() { done <- l.Wait(ctx, "k") }()
+
+	for clk.Waiters() <= waiting {
+		select {
+		case err := <-done:
+			t.Fatalf("Wait = %v before its turn, want it queued", err)
+		case <-time.After(time.Millisecond):
+		}
+	}
+	clk.Advance(time.Minute)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Wait = %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait still blocked a drain interval after it was queued")
+	}
+}
@@ -0,0 +1,98 @@
+package flexlimit
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Vipul984/flexlimit/storage"
+)
+
+// warmupRecord is the kind of record storing when a key was first seen
+// (see recordKey).
+const warmupRecord = "warmup"
+
+// WarmupConfig configures how new keys ramp up to the full rate. See
+// WithWarmup.
+type WarmupConfig struct {
+	// Period is how long a new key takes to ramp up to the full rate
+	Period time.Duration
+
+	// StartFraction is the fraction of the rate a new key starts at, in
+	// (0, 1]. Default: 0.1
+	StartFraction float64
+
+	// IdleReset makes a key unseen for this long start cold again, as if
+	// it were new. Default: 24 hours
+	IdleReset time.Duration
+}
+
+// WithWarmup makes newly seen keys start at a fraction of the rate and
+// ramp up to all of it over cfg.Period, so a burst of fresh keys can't hit
+// cold caches at full speed, and credential stuffing that rotates through
+// fresh keys gets a fraction of the rate out of each.
+//
+// Requests are first decided by the algorithm as usual; an allowed request
+// is then denied, and its tokens refunded, if it takes what the key
+// consumed in the current window above its fraction of the rate, as with
+// WithAdaptive. The fraction grows linearly from StartFraction when the
+// key is first seen to 1 after Period.
+//
+// When a key was first seen is stored next to its state, so every
+// instance sharing the storage ramps a key alike. This costs a storage
+// read per allowed request, and a write when a key is first seen and then
+// at most every IdleReset/2. If the record can't be read the key gets the
+// full rate. AllowMulti decides warming requests one by one.
+//
+// Example:
+//
+//	limiter, err := flexlimit.New(100, time.Minute,
+//	    flexlimit.WithWarmup(flexlimit.WarmupConfig{
+//	        Period:        time.Hour,
+//	        StartFraction: 0.2,
+//	    }),
+//	)
+func WithWarmup(cfg WarmupConfig) Option {
+	return func(o *Options) {
+		o.warmup = &cfg
+	}
+}
+
+// warmupFraction returns the fraction of the rate key runs at while it
+// warms up, recording when it was first seen and last seen.
+//
+// The record stores when the key was first seen as WindowStart and when
+// the record was last written as LastRefill. It is kept until IdleReset
+// after the key warmed up or was last seen, whichever is later, and only
+// rewritten once half of IdleReset has passed.
+// Must be called with l.mu held.
+func (l *Limiter) warmupFraction(ctx context.Context, key string, now time.Time) float64 {
+	cfg := l.warmup
+	store := l.be.store
+	warmupKey := recordKey(warmupRecord, key)
+
+	firstSeen, written := now, time.Time{}
+	st, err := store.Get(ctx, warmupKey)
+	switch {
+	case err == nil:
+		firstSeen, written = st.WindowStart, st.LastRefill
+	case errors.Is(err, storage.ErrKeyNotFound):
+	default:
+		return 1
+	}
+
+	age := now.Sub(firstSeen)
+	if written.IsZero() || now.Sub(written) >= cfg.IdleReset/2 {
+		// A failed write only shortens the key's memory; the fraction
+		// still follows the record read above.
+		_ = store.Set(ctx, warmupKey, &storage.State{
+			WindowStart: firstSeen,
+			LastRefill:  now,
+		}, max(cfg.Period-age, 0)+cfg.IdleReset)
+	}
+
+	if age >= cfg.Period {
+		return 1
+	}
+	return cfg.StartFraction + (1-cfg.StartFraction)*float64(age)/float64(cfg.Period)
+}
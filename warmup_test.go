@@ -0,0 +1,63 @@
+package flexlimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Vipul984/flexlimit/clock"
+)
+
+func TestWarmupLowersSustainedRate(t *testing.T) {
+	for _, algo := range []AlgorithmType{TokenBucket, LeakyBucket, FixedWindow, SlidingWindow, DecayedWindow} {
+		t.Run(string(algo), func(t *testing.T) {
+			clk := clock.NewMock()
+			l, err := New(100, time.Minute,
+				WithAlgorithm(algo),
+				WithBurst(100),
+				WithClock(clk),
+				WithWarmup(WarmupConfig{Period: 24 * time.Hour, StartFraction: 0.1}),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+
+			// Ten minutes into a day-long ramp a key still runs at about a
+			// tenth of the rate
+			got := drive(t, l, clk, "k", 100, 10)
+			if got < 90 || got > 115 {
+				t.Errorf("allowed %d of 1000 requests for a new key, want about 100", got)
+			}
+		})
+	}
+}
+
+func TestWarmupRampsToFullRate(t *testing.T) {
+	clk := clock.NewMockAt(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	l, err := New(100, time.Minute,
+		WithAlgorithm(FixedWindow),
+		WithClock(clk),
+		WithWarmup(WarmupConfig{Period: 10 * time.Minute, StartFraction: 0.1}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var got []int
+	for range 12 {
+		got = append(got, drive(t, l, clk, "k", 200, 1))
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i] < got[i-1] {
+			t.Errorf("allowed per minute %v, want non-decreasing", got)
+			break
+		}
+	}
+	if got[0] > 20 {
+		t.Errorf("allowed %d in the first minute, want about 10", got[0])
+	}
+	if last := got[len(got)-1]; last < 95 {
+		t.Errorf("allowed %d once warmed up, want about 100", last)
+	}
+}
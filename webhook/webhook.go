@@ -0,0 +1,515 @@
+// Package webhook POSTs rate limit events to an HTTP endpoint, such as a
+// chat alerting integration or an incident tool.
+//
+// A Notifier turns the limiter's callbacks into JSON events: keys banned
+// by a lifecycle policy, keys crossing a usage threshold (see
+// flexlimit.OnStateChange) and fallback activations. Events are queued
+// and POSTed in batches in the background, so the request path never
+// waits for the webhook. Failed deliveries are retried with exponential
+// backoff, and the Notifier limits its own requests with a flexlimit
+// limiter, so a flood of events can't flood the endpoint too: events pile
+// up in batches instead, and are dropped once the queue is full.
+//
+// Each POST carries a JSON object with the batch of events:
+//
+//	{"events": [{"type": "threshold_crossed", "limiter": "api", "key": "user:123",
+//	  "limit": 1000, "used": 800, "threshold": 0.8, "timestamp": "2024-05-01T12:00:00Z"}]}
+//
+// Example:
+//
+//	notifier, err := webhook.New(webhook.Config{
+//	    URL:     "https://hooks.example.com/ratelimits",
+//	    Limiter: "api",
+//	})
+//	if err != nil {
+//	    return err
+//	}
+//	defer notifier.Close()
+//
+//	limiter, err := flexlimit.New(1000, time.Hour, append(notifier.Options(),
+//	    flexlimit.WithUsageThresholds(0.8, 1),
+//	    flexlimit.WithLifecycle(flexlimit.LifecyclePolicy{BanAfter: 10, OnBan: notifier.OnBan}),
+//	)...)
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Vipul984/flexlimit"
+)
+
+// EventType is what an Event reports.
+type EventType string
+
+const (
+	// Banned is a key banned by a lifecycle policy or Limiter.Ban.
+	Banned EventType = "banned"
+
+	// ThresholdCrossed is a key whose usage crossed one of the thresholds
+	// of flexlimit.WithUsageThresholds.
+	ThresholdCrossed EventType = "threshold_crossed"
+
+	// Fallback is the limiter's fallback strategy taking over after a
+	// storage failure.
+	Fallback EventType = "fallback"
+)
+
+// Event is one limit event, as POSTed to the webhook.
+type Event struct {
+	// Type is what happened
+	Type EventType `json:"type"`
+
+	// Limiter is Config.Limiter, naming the limiter the event comes from
+	Limiter string `json:"limiter,omitempty"`
+
+	// Key is the rate limit key, empty for Fallback
+	Key string `json:"key,omitempty"`
+
+	// Limit and Used are the key's limit and usage, for ThresholdCrossed
+	Limit int `json:"limit,omitempty"`
+	Used  int `json:"used,omitempty"`
+
+	// Threshold is the fraction of the limit crossed, for
+	// ThresholdCrossed
+	Threshold float64 `json:"threshold,omitempty"`
+
+	// Until is when a ban ends, for Banned; zero for bans without an end
+	Until time.Time `json:"until,omitzero"`
+
+	// Error is the storage error, for Fallback
+	Error string `json:"error,omitempty"`
+
+	// TraceID and SpanID identify the trace of the request that caused
+	// the event, if it carried one (see flexlimit.ContextWithTrace), for
+	// ThresholdCrossed
+	TraceID string `json:"trace_id,omitempty"`
+	SpanID  string `json:"span_id,omitempty"`
+
+	// Timestamp is when the event happened
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// payload is the body of a POST.
+type payload struct {
+	Events []Event `json:"events"`
+}
+
+// Config configures a Notifier.
+type Config struct {
+	// URL is the endpoint events are POSTed to. Required
+	URL string
+
+	// Header is added to every POST, e.g. for an Authorization header
+	Header http.Header
+
+	// Client sends the POSTs. Default: an http.Client with a 10 second
+	// timeout
+	Client *http.Client
+
+	// Limiter names the limiter in the events. Default: empty
+	Limiter string
+
+	// BatchSize is the maximum number of events in one POST. Default: 100
+	BatchSize int
+
+	// FlushInterval is how long events wait for a batch to fill before
+	// they are sent anyway. Default: 1 second
+	FlushInterval time.Duration
+
+	// QueueSize is how many events may wait to be sent. Events arriving
+	// while the queue is full are dropped. Default: 10000
+	QueueSize int
+
+	// RequestsPerSecond limits the POSTs the Notifier sends, retries
+	// included. Default: 10
+	RequestsPerSecond int
+
+	// MaxAttempts is how many times a batch is POSTed before it is
+	// dropped, including the first. Default: 5
+	MaxAttempts int
+
+	// Backoff is the wait before the first retry. It doubles after every
+	// attempt, up to MaxBackoff. Default: 500 milliseconds
+	Backoff time.Duration
+
+	// MaxBackoff caps the wait between two attempts. Default: 30 seconds
+	MaxBackoff time.Duration
+
+	// OnError is called with the error of a batch dropped after its last
+	// attempt, and with ErrQueueFull when events are dropped from a full
+	// queue. Default: errors are only counted by Dropped
+	OnError func(error)
+}
+
+// ErrQueueFull is passed to Config.OnError when events are dropped
+// because the queue is full.
+var ErrQueueFull = errors.New("webhook: queue full, event dropped")
+
+// StatusError is the error of a POST answered with a status other than
+// 2xx.
+type StatusError struct {
+	// StatusCode is the HTTP status of the response
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("webhook: unexpected status %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// retryable reports whether a POST answered with the status may succeed
+// if sent again.
+func (e *StatusError) retryable() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusRequestTimeout
+}
+
+// Notifier POSTs limit events to a webhook in the background.
+type Notifier struct {
+	cfg     Config
+	limiter *flexlimit.Limiter
+
+	events  chan Event
+	flushes chan flushRequest
+	dropped atomic.Uint64
+
+	// ctx is canceled when Shutdown gives up on pending events
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	closed    atomic.Bool
+	closeOnce sync.Once
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// flushRequest asks the sending goroutine to send every queued event.
+type flushRequest struct {
+	ctx  context.Context
+	done chan error
+}
+
+// New creates a Notifier and starts sending events. Call Close or
+// Shutdown to stop it.
+//
+// Returns an *flexlimit.InvalidConfigError if URL is empty or a Config
+// field is negative.
+func New(cfg Config) (*Notifier, error) {
+	if cfg.URL == "" {
+		return nil, &flexlimit.InvalidConfigError{Field: "url", Value: cfg.URL, Reason: "required"}
+	}
+	if cfg.BatchSize < 0 || cfg.FlushInterval < 0 || cfg.QueueSize < 0 || cfg.RequestsPerSecond < 0 ||
+		cfg.MaxAttempts < 0 || cfg.Backoff < 0 || cfg.MaxBackoff < 0 {
+		return nil, &flexlimit.InvalidConfigError{Field: "config", Value: cfg, Reason: "sizes, rates and durations cannot be negative"}
+	}
+
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.FlushInterval == 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = 10000
+	}
+	if cfg.RequestsPerSecond == 0 {
+		cfg.RequestsPerSecond = 10
+	}
+	if cfg.MaxAttempts == 0 {
+		cfg.MaxAttempts = 5
+	}
+	if cfg.Backoff == 0 {
+		cfg.Backoff = 500 * time.Millisecond
+	}
+	if cfg.MaxBackoff == 0 {
+		cfg.MaxBackoff = 30 * time.Second
+	}
+
+	limiter, err := flexlimit.New(cfg.RequestsPerSecond, time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &Notifier{
+		cfg:     cfg,
+		limiter: limiter,
+		events:  make(chan Event, cfg.QueueSize),
+		flushes: make(chan flushRequest),
+		ctx:     ctx,
+		cancel:  cancel,
+		stop:    make(chan struct{}),
+	}
+	n.wg.Add(1)
+	go n.run()
+	return n, nil
+}
+
+// Options returns the options reporting a limiter's threshold crossings
+// (see flexlimit.WithUsageThresholds) and fallback activations to n. They
+// replace the limiter's OnStateChange and OnFallback callbacks; call
+// OnStateChange and OnFallback from your own callbacks instead to keep
+// them. Bans are reported by setting LifecyclePolicy.OnBan to n.OnBan.
+func (n *Notifier) Options() []flexlimit.Option {
+	return []flexlimit.Option{
+		flexlimit.OnStateChange(n.OnStateChange),
+		flexlimit.OnFallback(n.OnFallback),
+	}
+}
+
+// OnStateChange queues a ThresholdCrossed event for c, if it is a
+// threshold crossing. It has the signature of flexlimit.OnStateChange.
+func (n *Notifier) OnStateChange(c flexlimit.StateChange) {
+	if c.Kind != flexlimit.ThresholdCrossed {
+		return
+	}
+	n.Notify(Event{
+		Type:      ThresholdCrossed,
+		Key:       c.Key,
+		Limit:     c.Limit,
+		Used:      c.Used,
+		Threshold: c.Threshold,
+		TraceID:   c.TraceID,
+		SpanID:    c.SpanID,
+		Timestamp: c.At,
+	})
+}
+
+// OnBan queues a Banned event for e. It has the signature of
+// flexlimit.LifecyclePolicy.OnBan.
+func (n *Notifier) OnBan(e flexlimit.BanEvent) {
+	n.Notify(Event{
+		Type:      Banned,
+		Key:       e.Key,
+		Until:     e.Until,
+		Timestamp: e.At,
+	})
+}
+
+// OnFallback queues a Fallback event for err. It has the signature of
+// flexlimit.OnFallback.
+func (n *Notifier) OnFallback(err error) {
+	e := Event{Type: Fallback, Timestamp: time.Now()}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	n.Notify(e)
+}
+
+// Notify queues e to be sent, without blocking. Limiter is set to
+// Config.Limiter if it is empty. Events are dropped if the queue is full
+// or the Notifier is closed.
+func (n *Notifier) Notify(e Event) {
+	if n.closed.Load() {
+		n.dropped.Add(1)
+		return
+	}
+	if e.Limiter == "" {
+		e.Limiter = n.cfg.Limiter
+	}
+
+	select {
+	case n.events <- e:
+	default:
+		n.dropped.Add(1)
+		if n.cfg.OnError != nil {
+			n.cfg.OnError(ErrQueueFull)
+		}
+	}
+}
+
+// Dropped returns the number of events dropped so far: from a full queue,
+// after the Notifier was closed, or in batches that could not be
+// delivered.
+func (n *Notifier) Dropped() uint64 {
+	return n.dropped.Load()
+}
+
+// Flush sends every queued event now, within ctx, and returns the error
+// of the first batch that could not be delivered.
+func (n *Notifier) Flush(ctx context.Context) error {
+	req := flushRequest{ctx: ctx, done: make(chan error, 1)}
+	select {
+	case n.flushes <- req:
+	case <-n.stop:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-req.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown stops the Notifier, sending the queued events first. If ctx
+// ends before they are delivered, the rest are dropped and Shutdown
+// returns ctx's error. Calling Shutdown more than once is a no-op.
+func (n *Notifier) Shutdown(ctx context.Context) error {
+	n.closeOnce.Do(func() {
+		n.closed.Store(true)
+		close(n.stop)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		n.cancel()
+		<-done
+		err = ctx.Err()
+	}
+	n.cancel()
+	return errors.Join(err, n.limiter.Close())
+}
+
+// Close stops the Notifier, waiting for the queued events to be delivered
+// or dropped.
+func (n *Notifier) Close() error {
+	return n.Shutdown(context.Background())
+}
+
+// run sends batches of events until the Notifier is stopped.
+func (n *Notifier) run() {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(n.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Event, 0, n.cfg.BatchSize)
+	send := func(ctx context.Context) error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := n.send(ctx, batch)
+		batch = batch[:0]
+		return err
+	}
+
+	for {
+		select {
+		case e := <-n.events:
+			batch = append(batch, e)
+			if len(batch) == n.cfg.BatchSize {
+				send(n.ctx)
+			}
+		case <-ticker.C:
+			send(n.ctx)
+		case req := <-n.flushes:
+			req.done <- n.drain(req.ctx, &batch, send)
+		case <-n.stop:
+			n.drain(n.ctx, &batch, send)
+			return
+		}
+	}
+}
+
+// drain sends the batch and every queued event, in batches, and returns
+// the first error.
+func (n *Notifier) drain(ctx context.Context, batch *[]Event, send func(context.Context) error) error {
+	var first error
+	for {
+		select {
+		case e := <-n.events:
+			*batch = append(*batch, e)
+			if len(*batch) < n.cfg.BatchSize {
+				continue
+			}
+		default:
+		}
+
+		if err := send(ctx); err != nil && first == nil {
+			first = err
+		}
+		if len(n.events) == 0 {
+			return first
+		}
+	}
+}
+
+// send POSTs batch until it is delivered, MaxAttempts is reached or ctx
+// ends. An undelivered batch is dropped and reported to OnError.
+func (n *Notifier) send(ctx context.Context, batch []Event) error {
+	body, err := json.Marshal(payload{Events: batch})
+	if err != nil {
+		return n.fail(len(batch), err)
+	}
+
+	backoff := n.cfg.Backoff
+	for attempt := 1; ; attempt++ {
+		if err = n.limiter.Wait(ctx, "webhook"); err != nil {
+			return n.fail(len(batch), err)
+		}
+
+		err = n.post(ctx, body)
+		var status *StatusError
+		if err == nil || attempt == n.cfg.MaxAttempts || errors.As(err, &status) && !status.retryable() {
+			break
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return n.fail(len(batch), ctx.Err())
+		}
+		backoff = min(2*backoff, n.cfg.MaxBackoff)
+	}
+	if err != nil {
+		return n.fail(len(batch), err)
+	}
+	return nil
+}
+
+// post sends one POST of body.
+func (n *Notifier) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range n.cfg.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "flexlimit-webhook/"+flexlimit.Version)
+
+	resp, err := n.cfg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// fail drops a batch of size events that could not be delivered because
+// of err.
+func (n *Notifier) fail(size int, err error) error {
+	err = fmt.Errorf("webhook: dropping %d events: %w", size, err)
+	n.dropped.Add(uint64(size))
+	if n.cfg.OnError != nil {
+		n.cfg.OnError(err)
+	}
+	return err
+}
@@ -0,0 +1,320 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Vipul984/flexlimit"
+	"github.com/Vipul984/flexlimit/clock"
+	"github.com/Vipul984/flexlimit/webhook"
+)
+
+// recorder is a webhook endpoint recording the batches POSTed to it. It
+// answers the first POSTs with statuses, then with 200 OK.
+type recorder struct {
+	statuses []int
+
+	mu      sync.Mutex
+	posts   []time.Time
+	header  http.Header
+	batches [][]webhook.Event
+}
+
+func (r *recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Events []webhook.Event `json:"events"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.posts = append(r.posts, time.Now())
+	r.header = req.Header
+	if n := len(r.posts); n <= len(r.statuses) {
+		w.WriteHeader(r.statuses[n-1])
+		return
+	}
+	r.batches = append(r.batches, body.Events)
+}
+
+// events returns the events received so far, in order.
+func (r *recorder) events() []webhook.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var events []webhook.Event
+	for _, b := range r.batches {
+		events = append(events, b...)
+	}
+	return events
+}
+
+// batchSizes returns the sizes of the batches received so far.
+func (r *recorder) batchSizes() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sizes := make([]int, len(r.batches))
+	for i, b := range r.batches {
+		sizes[i] = len(b)
+	}
+	return sizes
+}
+
+// postTimes returns when each POST arrived, failed ones included.
+func (r *recorder) postTimes() []time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.posts)
+}
+
+// newNotifier returns a Notifier POSTing to rec, with fast retries.
+func newNotifier(t *testing.T, rec http.Handler, cfg webhook.Config) *webhook.Notifier {
+	t.Helper()
+	srv := httptest.NewServer(rec)
+	t.Cleanup(srv.Close)
+
+	cfg.URL = srv.URL
+	if cfg.Backoff == 0 {
+		cfg.Backoff = time.Millisecond
+	}
+	if cfg.RequestsPerSecond == 0 {
+		cfg.RequestsPerSecond = 1000
+	}
+	n, err := webhook.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n.Close() })
+	return n
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBatching(t *testing.T) {
+	rec := &recorder{}
+	n := newNotifier(t, rec, webhook.Config{
+		BatchSize:     3,
+		FlushInterval: time.Hour,
+		Limiter:       "api",
+		Header:        http.Header{"Authorization": {"Bearer secret"}},
+	})
+
+	for i := range 7 {
+		n.Notify(webhook.Event{Type: webhook.ThresholdCrossed, Key: strconv.Itoa(i)})
+	}
+	// Full batches go out at once; the rest waits for a flush
+	waitFor(t, "two full batches", func() bool { return len(rec.batchSizes()) == 2 })
+	if err := n.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := rec.batchSizes(); !slices.Equal(got, []int{3, 3, 1}) {
+		t.Errorf("batch sizes = %v, want [3 3 1]", got)
+	}
+	for i, e := range rec.events() {
+		if e.Key != strconv.Itoa(i) || e.Limiter != "api" {
+			t.Errorf("event %d = %+v, want key %d of api", i, e, i)
+		}
+	}
+	rec.mu.Lock()
+	header := rec.header
+	rec.mu.Unlock()
+	if header.Get("Authorization") != "Bearer secret" || header.Get("Content-Type") != "application/json" {
+		t.Errorf("headers = %v, want the configured Authorization and a JSON body", header)
+	}
+}
+
+func TestFlushInterval(t *testing.T) {
+	rec := &recorder{}
+	n := newNotifier(t, rec, webhook.Config{FlushInterval: 10 * time.Millisecond})
+
+	n.Notify(webhook.Event{Type: webhook.ThresholdCrossed, Key: "k"})
+	waitFor(t, "the ticker to send a partial batch", func() bool { return len(rec.events()) == 1 })
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		posts    int
+		dropped  uint64
+		status   int // of the error, 0 for none
+	}{
+		{"server errors are retried", []int{503, 500}, 3, 0, 0},
+		{"too many requests is retried", []int{429}, 2, 0, 0},
+		{"client errors are not retried", []int{400}, 1, 2, 400},
+		{"dropped after the last attempt", []int{500, 500, 500}, 3, 2, 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recorder{statuses: tt.statuses}
+			var reported []error
+			var mu sync.Mutex
+			n := newNotifier(t, rec, webhook.Config{
+				MaxAttempts: 3,
+				OnError: func(err error) {
+					mu.Lock()
+					reported = append(reported, err)
+					mu.Unlock()
+				},
+			})
+
+			n.Notify(webhook.Event{Type: webhook.ThresholdCrossed, Key: "a"})
+			n.Notify(webhook.Event{Type: webhook.ThresholdCrossed, Key: "b"})
+			err := n.Flush(context.Background())
+
+			if got := len(rec.postTimes()); got != tt.posts {
+				t.Errorf("got %d POSTs, want %d", got, tt.posts)
+			}
+			if got := n.Dropped(); got != tt.dropped {
+				t.Errorf("Dropped = %d, want %d", got, tt.dropped)
+			}
+			if tt.status == 0 {
+				if err != nil || len(reported) != 0 {
+					t.Errorf("Flush = %v, reported %v, want the batch delivered", err, reported)
+				}
+				if len(rec.events()) != 2 {
+					t.Errorf("got %d events, want 2", len(rec.events()))
+				}
+				return
+			}
+			var status *webhook.StatusError
+			if !errors.As(err, &status) || status.StatusCode != tt.status {
+				t.Errorf("Flush = %v, want a StatusError %d", err, tt.status)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(reported) != 1 || !errors.As(reported[0], &status) {
+				t.Errorf("OnError got %v, want the StatusError once", reported)
+			}
+		})
+	}
+}
+
+func TestRequestsPerSecond(t *testing.T) {
+	rec := &recorder{statuses: []int{500}}
+	n := newNotifier(t, rec, webhook.Config{BatchSize: 1, RequestsPerSecond: 4})
+
+	// 5 POSTs, the retry included: 4 go out at once, the fifth a quarter
+	// of a second later
+	for i := range 4 {
+		n.Notify(webhook.Event{Type: webhook.ThresholdCrossed, Key: strconv.Itoa(i)})
+	}
+	if err := n.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	posts := rec.postTimes()
+	if len(posts) != 5 {
+		t.Fatalf("got %d POSTs, want 5", len(posts))
+	}
+	if gap := posts[4].Sub(posts[0]); gap < 200*time.Millisecond {
+		t.Errorf("5 POSTs sent within %v, want them limited to 4 per second", gap)
+	}
+}
+
+// blockingHandler holds every POST until release is closed, signaling
+// each one on started.
+type blockingHandler struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *blockingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.started <- struct{}{}
+	<-h.release
+}
+
+func TestQueueFull(t *testing.T) {
+	h := &blockingHandler{started: make(chan struct{}, 10), release: make(chan struct{})}
+	var full atomic.Int64
+	n := newNotifier(t, h, webhook.Config{
+		BatchSize: 1,
+		QueueSize: 1,
+		OnError: func(err error) {
+			if errors.Is(err, webhook.ErrQueueFull) {
+				full.Add(1)
+			}
+		},
+	})
+	// Registered after newNotifier's cleanup, so it runs first
+	t.Cleanup(func() { close(h.release) })
+
+	// The first event is being sent, the second waits in the queue and
+	// the third has no room
+	n.Notify(webhook.Event{Key: "sending"})
+	<-h.started
+	n.Notify(webhook.Event{Key: "queued"})
+	n.Notify(webhook.Event{Key: "dropped"})
+
+	if got := n.Dropped(); got != 1 {
+		t.Errorf("Dropped = %d, want 1", got)
+	}
+	if got := full.Load(); got != 1 {
+		t.Errorf("OnError got ErrQueueFull %d times, want once", got)
+	}
+}
+
+func TestThresholdEventCarriesTrace(t *testing.T) {
+	rec := &recorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	notifier, err := webhook.New(webhook.Config{URL: srv.URL, Limiter: "api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer notifier.Close()
+
+	at := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	l, err := flexlimit.New(2, time.Minute, append(notifier.Options(),
+		flexlimit.WithUsageThresholds(0.5),
+		flexlimit.WithClock(clock.NewMockAt(at)),
+	)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	tc := flexlimit.TraceContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7"}
+	if allowed, _ := l.Allow(flexlimit.ContextWithTrace(context.Background(), tc), "k"); !allowed {
+		t.Fatal("request denied")
+	}
+	if err := notifier.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	events := rec.events()
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+	e := events[0]
+	if e.Type != webhook.ThresholdCrossed || e.Limiter != "api" || e.Key != "k" {
+		t.Errorf("event = %+v, want a threshold crossing of api/k", e)
+	}
+	if e.TraceID != tc.TraceID || e.SpanID != tc.SpanID {
+		t.Errorf("event trace %q span %q, want %q and %q", e.TraceID, e.SpanID, tc.TraceID, tc.SpanID)
+	}
+	if !e.Timestamp.Equal(at) {
+		t.Errorf("event timestamp = %v, want the limiter's clock, %v", e.Timestamp, at)
+	}
+}